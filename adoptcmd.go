@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/mount"
+	"golang.org/x/xerrors"
+
+	"go.coder.com/cli"
+	"go.coder.com/sail/internal/dockutil"
+	"go.coder.com/sail/internal/xlog"
+)
+
+// adoptedImagePrefix prefixes the images adopted containers are committed to.
+const adoptedImagePrefix = "sail-adopted/"
+
+type adoptcmd struct {
+	gf *globalFlags
+
+	rm     bool
+	noOpen bool
+}
+
+func (c *adoptcmd) Spec() cli.CommandSpec {
+	return cli.CommandSpec{
+		Name:  "adopt",
+		Usage: "[flags] <container> <repo>",
+		Desc: `Migrates a container that wasn't created by sail into a sail environment of a project.
+
+The container is committed to an image, from which the project's container is
+created, with code-server, the project directory and the container's environment
+variables and bind mounts. The project mustn't exist. The original container is
+kept unless --rm is given.`,
+	}
+}
+
+func (c *adoptcmd) RegisterFlags(fl *flag.FlagSet) {
+	fl.BoolVar(&c.rm, "rm", false, "Remove the original container once it's adopted.")
+	fl.BoolVar(&c.noOpen, "no-open", false, "Don't open the project.")
+}
+
+func (c *adoptcmd) Run(fl *flag.FlagSet) {
+	if fl.NArg() < 2 {
+		fl.Usage()
+		os.Exit(1)
+	}
+
+	projFl := flag.NewFlagSet(fl.Name(), flag.ExitOnError)
+	projFl.Parse(fl.Args()[1:])
+	proj := c.gf.project(schemaPrefs{}, projFl)
+	c.gf.ensureDockerDaemon()
+
+	_, err := proj.lock()
+	if err != nil {
+		xlog.Fatal("failed to lock project: %v", err)
+	}
+	exists, err := proj.cntExists()
+	if err != nil {
+		xlog.Fatal("%v", err)
+	}
+	if exists {
+		xlog.Fatal("%v already exists, remove it with sail rm to adopt a container into it", proj.pathName())
+	}
+
+	ctx, cancel := withInterrupt(context.Background())
+	defer cancel()
+
+	cli := dockerClient()
+	defer cli.Close()
+
+	cnt, err := cli.ContainerInspect(ctx, fl.Arg(0))
+	if err != nil {
+		xlog.Fatal("failed to inspect %v: %v", fl.Arg(0), err)
+	}
+	if _, ok := cnt.Config.Labels[sailLabel]; ok {
+		xlog.Fatal("%v is already a sail environment", fl.Arg(0))
+	}
+
+	err = proj.ensureDir()
+	if err != nil {
+		xlog.Fatal("%v", err)
+	}
+
+	r, err := new(runcmd).runner(proj, false)
+	if err != nil {
+		xlog.Fatal("%v", err)
+	}
+	err = c.adopt(ctx, cnt, r)
+	if err != nil {
+		xlog.Fatal("%v", err)
+	}
+
+	image, err := commitAdopted(ctx, cnt, proj.cntName())
+	if err != nil {
+		xlog.Fatal("%v", err)
+	}
+
+	emitEvent(eventBuilding, proj.cntName(), nil)
+	err = new(runcmd).build(ctx, c.gf, proj, &hatBuilder{baseImage: image}, r)
+	if err != nil {
+		emitEvent(eventFailed, proj.cntName(), map[string]string{"error": err.Error()})
+		rmErr := dockutil.StopRemove(context.Background(), cli, proj.cntName())
+		if rmErr != nil {
+			xlog.Error("failed to remove %v: %v", proj.cntName(), rmErr)
+		}
+		xlog.Fatal("failed to create %v: %v", proj.pathName(), err)
+	}
+
+	// Later runs recreate the container with the adopted mounts and environment.
+	err = saveMounts(proj.cntName(), r.extraMounts)
+	if err != nil {
+		xlog.Error("failed to save mounts: %v", err)
+	}
+	err = saveEnv(proj.cntName(), r.env)
+	if err != nil {
+		xlog.Error("failed to save environment: %v", err)
+	}
+	xlog.Success("adopted %v into %v", fl.Arg(0), proj.pathName())
+
+	if c.rm {
+		err = dockutil.StopRemove(ctx, cli, cnt.ID)
+		if err != nil {
+			xlog.Error("failed to remove %v: %v", fl.Arg(0), err)
+		}
+	} else {
+		xlog.Info("%v is kept, remove it with docker rm once you're done with it", fl.Arg(0))
+	}
+
+	if c.noOpen {
+		return
+	}
+	err = proj.open()
+	if err != nil {
+		xlog.Fatal("failed to open project: %v", err)
+	}
+}
+
+// adopt carries the environment variables and mounts of cnt over to r.
+func (c *adoptcmd) adopt(ctx context.Context, cnt types.ContainerJSON, r *runner) error {
+	cli := dockerClient()
+	defer cli.Close()
+
+	img, _, err := cli.ImageInspectWithRaw(ctx, cnt.Image)
+	if err != nil {
+		return xerrors.Errorf("failed to inspect image of %v: %w", cnt.Name, err)
+	}
+	var imageEnv []string
+	if img.Config != nil {
+		imageEnv = img.Config.Env
+	}
+	r.env = setEnv(r.env, adoptedEnv(cnt.Config.Env, imageEnv)...)
+
+	mounts, skipped := adoptedMounts(cnt.Mounts)
+	for _, m := range skipped {
+		xlog.Warn("the volume mounted at %v isn't adopted, copy what you need into the project", m)
+	}
+	for _, m := range mounts {
+		// The Docker socket is shared like sail run --docker does.
+		if strings.HasSuffix(m, ":"+containerDockerSocket) {
+			r.shareDocker = true
+			continue
+		}
+		r.extraMounts = append(r.extraMounts, m)
+	}
+	return nil
+}
+
+// commitAdopted commits cnt to the image the container cntName is created
+// from. sail runs its own command in it, so the image's entrypoint is reset.
+func commitAdopted(ctx context.Context, cnt types.ContainerJSON, cntName string) (string, error) {
+	cli := dockerClient()
+	defer cli.Close()
+
+	image := adoptedImagePrefix + strings.ToLower(cntName)
+	xlog.Info("committing %v to %v", strings.TrimPrefix(cnt.Name, "/"), image)
+	_, err := cli.ContainerCommit(ctx, cnt.ID, types.ContainerCommitOptions{
+		Reference: image,
+		Comment:   "adopted by sail",
+		Changes:   []string{"ENTRYPOINT []"},
+	})
+	if err != nil {
+		return "", xerrors.Errorf("failed to commit %v: %w", cnt.Name, err)
+	}
+	return image, nil
+}
+
+// unadoptedEnv are the variables that describe the container rather than
+// the environment, like its user and hostname. The project's container sets
+// its own, so they aren't adopted.
+var unadoptedEnv = map[string]bool{
+	"HOME":     true,
+	"HOSTNAME": true,
+	"LOGNAME":  true,
+	"OLDPWD":   true,
+	"PATH":     true,
+	"PWD":      true,
+	"SHELL":    true,
+	"SHLVL":    true,
+	"TERM":     true,
+	"USER":     true,
+	"_":        true,
+}
+
+// adoptedEnv returns the variables of env the container set in addition to
+// those of its image, imageEnv, except for those of unadoptedEnv.
+func adoptedEnv(env, imageEnv []string) []string {
+	set := make(map[string]bool, len(imageEnv))
+	for _, v := range imageEnv {
+		set[v] = true
+	}
+	var adopted []string
+	for _, v := range env {
+		key := strings.SplitN(v, "=", 2)[0]
+		if !set[v] && !unadoptedEnv[key] {
+			adopted = append(adopted, v)
+		}
+	}
+	return adopted
+}
+
+// adoptedMounts returns the bind mounts of a container as sail mounts, and
+// the targets of those that can't be adopted, like volumes.
+func adoptedMounts(mounts []types.MountPoint) (adopted []string, skipped []string) {
+	for _, m := range mounts {
+		if m.Type != mount.TypeBind {
+			skipped = append(skipped, m.Destination)
+			continue
+		}
+		adopted = append(adopted, m.Source+":"+m.Destination)
+	}
+	return adopted, skipped
+}
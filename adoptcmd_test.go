@@ -0,0 +1,26 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_adoptedEnv(t *testing.T) {
+	env := adoptedEnv(
+		[]string{"PATH=/usr/local/go/bin:/usr/bin", "GOFLAGS=-mod=vendor", "HOME=/root", "HOSTNAME=3f2a1c", "EDITOR=vim"},
+		[]string{"PATH=/usr/bin", "HOME=/home/user", "EDITOR=vim"},
+	)
+	assert.Equal(t, []string{"GOFLAGS=-mod=vendor"}, env)
+}
+
+func Test_adoptedMounts(t *testing.T) {
+	adopted, skipped := adoptedMounts([]types.MountPoint{
+		{Type: mount.TypeBind, Source: "/home/alice/src", Destination: "/src"},
+		{Type: mount.TypeVolume, Name: "pgdata", Destination: "/var/lib/postgresql/data"},
+	})
+	assert.Equal(t, []string{"/home/alice/src:/src"}, adopted)
+	assert.Equal(t, []string{"/var/lib/postgresql/data"}, skipped)
+}
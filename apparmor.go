@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"runtime"
+	"strings"
+
+	"golang.org/x/xerrors"
+
+	"go.coder.com/sail/internal/xlog"
+)
+
+// appArmorProfilesPath lists the AppArmor profiles loaded in the kernel, one
+// per line of the form "name (mode)".
+const appArmorProfilesPath = "/sys/kernel/security/apparmor/profiles"
+
+// loadedAppArmorProfile reports whether the AppArmor profile name is in the
+// list of loaded profiles at path. ok is false if the list can't be read.
+func loadedAppArmorProfile(path, name string) (loaded, ok bool) {
+	fi, err := os.Open(path)
+	if err != nil {
+		return false, false
+	}
+	defer fi.Close()
+
+	sc := bufio.NewScanner(fi)
+	for sc.Scan() {
+		line := sc.Text()
+		if i := strings.LastIndex(line, " ("); i >= 0 {
+			line = line[:i]
+		}
+		if line == name {
+			return true, true
+		}
+	}
+	return false, sc.Err() == nil
+}
+
+// checkAppArmor checks the AppArmor profile of the apparmor label of an
+// unprivileged container, as Docker only fails to start the container if it
+// isn't loaded. Sail doesn't ship a profile of its own: AppArmor confines
+// paths rather than labeling files, so unlike with SELinux, bind mounts work
+// under any profile that allows their paths.
+func (r *runner) checkAppArmor(labels map[string]string) error {
+	profile := labels[apparmorLabel]
+	if profile == "" || profile == "unconfined" || labels[privilegedLabel] != "false" {
+		return nil
+	}
+	if !r.apparmor {
+		xlog.Warn("the Docker daemon doesn't use AppArmor, so the %v profile of the %v label isn't applied", profile, apparmorLabel)
+		return nil
+	}
+	// The profiles of daemons in a VM can't be read from the host.
+	if runtime.GOOS != "linux" || r.desktop || r.windows || !r.usesDocker() {
+		return nil
+	}
+	loaded, ok := loadedAppArmorProfile(appArmorProfilesPath, profile)
+	if ok && !loaded {
+		return xerrors.Errorf("the AppArmor profile %v of the %v label isn't loaded, load it with sudo apparmor_parser -r", profile, apparmorLabel)
+	}
+	return nil
+}
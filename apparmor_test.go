@@ -0,0 +1,39 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_loadedAppArmorProfile(t *testing.T) {
+	fi, err := ioutil.TempFile("", "sail-apparmor")
+	require.NoError(t, err)
+	defer os.Remove(fi.Name())
+	fi.WriteString("docker-default (enforce)\n/usr/bin/man (enforce)\nsail dev (complain)\n")
+	fi.Close()
+
+	for _, name := range []string{"docker-default", "/usr/bin/man", "sail dev"} {
+		loaded, ok := loadedAppArmorProfile(fi.Name(), name)
+		assert.True(t, ok)
+		assert.True(t, loaded, name)
+	}
+	loaded, ok := loadedAppArmorProfile(fi.Name(), "docker")
+	assert.True(t, ok)
+	assert.False(t, loaded)
+
+	_, ok = loadedAppArmorProfile("/does/not/exist", "docker-default")
+	assert.False(t, ok)
+}
+
+func Test_checkAppArmor(t *testing.T) {
+	r := &runner{apparmor: true, desktop: true}
+	// Privileged containers aren't confined.
+	assert.NoError(t, r.checkAppArmor(map[string]string{apparmorLabel: "missing", privilegedLabel: "true"}))
+	assert.NoError(t, r.checkAppArmor(map[string]string{apparmorLabel: "unconfined", privilegedLabel: "false"}))
+	// The profiles of Docker Desktop's VM can't be checked.
+	assert.NoError(t, r.checkAppArmor(map[string]string{apparmorLabel: "missing", privilegedLabel: "false"}))
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"go.coder.com/cli"
+	"go.coder.com/sail/internal/xlog"
+)
+
+type applycmd struct {
+	gf *globalFlags
+
+	dryRun bool
+}
+
+func (c *applycmd) Spec() cli.CommandSpec {
+	return cli.CommandSpec{
+		Name:  "apply",
+		Usage: "[flags] <repo> [paths...]",
+		Desc: `Copies the changes of an overlay project back to the host.
+
+The changes sail diff shows are applied to the host's project directory, or
+only those of the given paths and the paths beneath them. Applied changes
+no longer show up in sail diff.`,
+	}
+}
+
+func (c *applycmd) RegisterFlags(fl *flag.FlagSet) {
+	fl.BoolVar(&c.dryRun, "dry-run", false, "Only print the changes that would be applied.")
+}
+
+func (c *applycmd) Run(fl *flag.FlagSet) {
+	if fl.NArg() < 1 {
+		fl.Usage()
+		os.Exit(1)
+	}
+	paths := fl.Args()[1:]
+	proj := projectArg(c.gf, fl)
+	c.gf.ensureDockerDaemon()
+
+	lower, upper, err := overlayLayers(proj)
+	if err != nil {
+		xlog.Fatal("%v", err)
+	}
+	changes, err := overlayChanges(lower, upper)
+	if err != nil {
+		xlog.Fatal("%v", err)
+	}
+
+	var applied int
+	for _, ch := range changes {
+		if len(paths) > 0 && !underAnyPath(ch.path, paths) {
+			continue
+		}
+		if c.dryRun {
+			fmt.Printf("would apply %v %v\n", ch.kind, ch.path)
+			continue
+		}
+		err = applyOverlayChange(lower, upper, ch)
+		if err != nil {
+			xlog.Fatal("failed to apply %v: %v", ch.path, err)
+		}
+		fmt.Printf("%v %v\n", ch.kind, ch.path)
+		applied++
+	}
+	if !c.dryRun {
+		xlog.Success("applied %v changes to %v", applied, lower)
+	}
+}
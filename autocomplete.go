@@ -3,13 +3,79 @@ package main
 import (
 	"flag"
 	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
 	"unicode/utf8"
 
+	"github.com/BurntSushi/toml"
 	"github.com/posener/complete"
 
 	"go.coder.com/cli"
 )
 
+// flagPredictors predicts the values of flags by name.
+// Flags that aren't listed accept anything.
+var flagPredictors = map[string]complete.Predictor{
+	"hat":      predictHats,
+	"format":   complete.PredictSet("devcontainer", "dockerfile"),
+	"status":   complete.PredictSet("running", "stopped"),
+	"group-by": complete.PredictSet("host", "org"),
+}
+
+// argPredictors predicts the arguments of commands by name.
+// Commands that take a <repo> complete environment names.
+var argPredictors = map[string]complete.Predictor{
+	"completion": complete.PredictSet("bash", "zsh", "fish"),
+}
+
+// predictProjects predicts the names of existing environments.
+var predictProjects = complete.PredictFunc(func(complete.Args) []string {
+	infos, err := listProjects()
+	if err != nil {
+		return nil
+	}
+	names := make([]string, 0, len(infos))
+	for _, info := range infos {
+		names = append(names, info.name)
+	}
+	return names
+})
+
+// predictHats predicts the default hat from the config, the hats used by
+// existing environments and local hat directories.
+var predictHats = complete.PredictOr(
+	complete.PredictFunc(func(complete.Args) []string {
+		var conf config
+		// The config flag hasn't been parsed, so only the default config is used.
+		_, err := toml.DecodeFile(filepath.Join(metaRoot(), "sail.toml"), &conf)
+		if err != nil {
+			conf = config{}
+		}
+
+		hats := map[string]struct{}{}
+		if conf.DefaultHat != "" {
+			hats[conf.DefaultHat] = struct{}{}
+		}
+		cnts, err := listContainers()
+		if err == nil {
+			for _, cnt := range cnts {
+				if hat := cnt.Labels[hatLabel]; hat != "" {
+					hats[hat] = struct{}{}
+				}
+			}
+		}
+
+		names := make([]string, 0, len(hats))
+		for hat := range hats {
+			names = append(names, hat)
+		}
+		sort.Strings(names)
+		return names
+	}),
+	complete.PredictDirs("*"),
+)
+
 func genAutocomplete(cmds []cli.Command) complete.Command {
 	var (
 		ac = complete.Command{
@@ -31,6 +97,9 @@ func genAutocomplete(cmds []cli.Command) complete.Command {
 					ac.GlobalFlags[n] = complete.PredictFiles("*.toml")
 				default:
 					ac.GlobalFlags[n] = complete.PredictAnything
+					if isBoolFlag(f) {
+						ac.GlobalFlags[n] = complete.PredictNothing
+					}
 				}
 			})
 
@@ -54,12 +123,24 @@ func genCommandAutocomplete(parent complete.Command, cmd cli.Command) {
 
 	if f, ok := cmd.(cli.FlaggedCommand); ok {
 		registerFlags(f, func(f *flag.Flag) {
-			// TODO: we can probably write a wrapper around *flag.FlagSet
-			// that is smarter about predictions
-			child.Flags[fmtFlag(f.Name)] = complete.PredictAnything
+			p, ok := flagPredictors[f.Name]
+			if !ok {
+				p = complete.PredictAnything
+				if isBoolFlag(f) {
+					p = complete.PredictNothing
+				}
+			}
+			child.Flags[fmtFlag(f.Name)] = p
 		})
 	}
 
+	spec := cmd.Spec()
+	if p, ok := argPredictors[spec.Name]; ok {
+		child.Args = p
+	} else if strings.Contains(spec.Usage, "<repo>") {
+		child.Args = predictProjects
+	}
+
 	if pc, ok := cmd.(cli.ParentCommand); ok {
 		genSubcommandAutocomplete(child, pc.Subcommands())
 	}
@@ -84,6 +165,12 @@ func registerFlags(cmd cli.FlaggedCommand, visitFunc func(f *flag.Flag)) {
 	set.VisitAll(visitFunc)
 }
 
+// isBoolFlag reports whether f doesn't take a value.
+func isBoolFlag(f *flag.Flag) bool {
+	bf, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && bf.IsBoolFlag()
+}
+
 func fmtFlag(name string) string {
 	if utf8.RuneCountInString(name) > 1 {
 		return fmt.Sprintf("--%s", name)
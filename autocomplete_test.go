@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/posener/complete"
+	"github.com/stretchr/testify/require"
+
+	"go.coder.com/cli"
+)
+
+func Test_genAutocomplete(t *testing.T) {
+	root := &rootCmd{}
+	cmds := append([]cli.Command{root}, root.Subcommands()...)
+	ac := genAutocomplete(cmds)
+
+	predict := func(completed ...string) []string {
+		return ac.Predict(complete.Args{
+			All:           completed,
+			Completed:     completed,
+			LastCompleted: completed[len(completed)-1],
+		})
+	}
+
+	require.ElementsMatch(t, []string{"devcontainer", "dockerfile"}, predict("export", "--format"))
+	require.ElementsMatch(t, []string{"bash", "zsh", "fish"}, predict("completion"))
+	require.Contains(t, predict("-v"), "run", "bool flags don't take a value")
+	require.NotNil(t, ac.Sub["run"].Args, "commands taking a repo complete environments")
+}
+
+func Test_completionScript(t *testing.T) {
+	for _, shell := range []string{"bash", "zsh", "fish"} {
+		script, err := completionScript(shell)
+		require.NoError(t, err)
+		require.Contains(t, script, "sail")
+	}
+
+	_, err := completionScript("powershell")
+	require.Error(t, err)
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"golang.org/x/xerrors"
+
+	"go.coder.com/sail/internal/dockutil"
+	"go.coder.com/sail/internal/xlog"
+)
+
+// Backends of the backend and backends config keys.
+const (
+	backendDocker = "docker"
+	backendLXD    = "lxd"
+	backendIncus  = "incus"
+	backendIgnite = "ignite"
+)
+
+// containerBackend runs the containers of environments. Images are always
+// built, and containers assembled, with Docker; the backend creates and runs
+// the container from its Docker spec.
+type containerBackend interface {
+	// create creates the container cntName without starting it.
+	create(ctx context.Context, cntName string, containerConfig *container.Config, hostConfig *container.HostConfig) error
+	// start starts the created container cntName.
+	start(ctx context.Context, cntName string) error
+	// remove removes the container cntName, whether it's running or only
+	// partially created. It doesn't fail if it doesn't exist.
+	remove(ctx context.Context, cntName string) error
+}
+
+// externalBackend is a backend other than Docker. sail's proxy and commands
+// reach containers through Docker, so code-server is opened directly, on the
+// port sail published it on.
+type externalBackend interface {
+	containerBackend
+	// status reports whether the container cntName exists, and whether
+	// it's running.
+	status(ctx context.Context, cntName string) (exists, running bool, err error)
+	// stop stops the container cntName.
+	stop(ctx context.Context, cntName string) error
+	// shell returns the command opening an interactive shell as the user of
+	// the running container of env, cntName, in the directory dir.
+	shell(cntName string, env externalEnv, dir string) (*exec.Cmd, error)
+}
+
+// newBackend returns the backend named name, Docker if it's empty.
+func newBackend(name string) containerBackend {
+	switch name {
+	case backendLXD:
+		return lxdBackend{cli: "lxc"}
+	case backendIncus:
+		return lxdBackend{cli: "incus"}
+	case backendIgnite:
+		return igniteBackend{}
+	default:
+		return dockerBackend{}
+	}
+}
+
+// backendOf returns the name of the backend of the project name.
+func (c config) backendOf(name string) string {
+	if b := c.Backends[name]; b != "" {
+		return b
+	}
+	return c.Backend
+}
+
+// dockerBackend runs containers with the Docker daemon.
+type dockerBackend struct{}
+
+func (dockerBackend) create(ctx context.Context, cntName string, containerConfig *container.Config, hostConfig *container.HostConfig) error {
+	cli := dockerClient()
+	defer cli.Close()
+
+	return dockutil.Retry(ctx, func(ctx context.Context) error {
+		_, err := cli.ContainerCreate(ctx, containerConfig, hostConfig, nil, cntName)
+		return err
+	})
+}
+
+func (dockerBackend) start(ctx context.Context, cntName string) error {
+	cli := dockerClient()
+	defer cli.Close()
+
+	return dockutil.Retry(ctx, func(ctx context.Context) error {
+		return cli.ContainerStart(ctx, cntName, types.ContainerStartOptions{})
+	})
+}
+
+func (dockerBackend) remove(ctx context.Context, cntName string) error {
+	cli := dockerClient()
+	defer cli.Close()
+
+	return dockutil.RemovePartial(ctx, cli, cntName)
+}
+
+// containerBackend returns the backend running the runner's container.
+func (r *runner) containerBackend() containerBackend {
+	if r.backend == nil {
+		return dockerBackend{}
+	}
+	return r.backend
+}
+
+// usesDocker reports whether the runner's container is run by Docker.
+// sail's proxy, shells and hooks reach containers through Docker, so they
+// aren't available with other backends.
+func (r *runner) usesDocker() bool {
+	_, ok := r.containerBackend().(dockerBackend)
+	return ok
+}
+
+var backendNameRx = regexp.MustCompile(`[^a-zA-Z0-9-]+`)
+
+// backendName returns the name of the container cntName in external
+// backends, which may only have letters, digits and hyphens, and at most 63
+// of them.
+func backendName(cntName string) string {
+	name := "sail-" + backendNameRx.ReplaceAllString(cntName, "-")
+	if len(name) > 63 {
+		name = name[:63]
+	}
+	return strings.TrimRight(name, "-")
+}
+
+// waitURL waits until the server at u responds, for at most timeout.
+func waitURL(ctx context.Context, u string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for {
+		req, err := http.NewRequest(http.MethodGet, u, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+		if err == nil {
+			resp.Body.Close()
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return xerrors.Errorf("%v didn't respond: %w", u, err)
+		case <-time.After(time.Millisecond * 100):
+		}
+	}
+}
+
+// runExternal runs the project with the external backend b. Existing
+// containers are started if they're stopped, otherwise they're created like
+// sail run does with Docker.
+func (c *runcmd) runExternal(ctx context.Context, proj *project, b externalBackend) {
+	exists, running, err := b.status(ctx, proj.cntName())
+	if err != nil {
+		xlog.Fatal("%v", err)
+	}
+	if exists && c.rebuild {
+		err = b.remove(ctx, proj.cntName())
+		if err != nil {
+			xlog.Fatal("failed to delete existing container: %v", err)
+		}
+		exists = false
+	}
+
+	switch {
+	case !exists:
+		sandbox, err := c.sandboxed(proj)
+		if err != nil {
+			xlog.Fatal("%v", err)
+		}
+		if sandbox {
+			proj.sandboxBuild()
+		}
+		// VMs isolate projects that aren't sandboxed, but the files copied
+		// into them aren't.
+		if ib, ok := b.(igniteBackend); ok && !sandbox {
+			trusted, err := c.trusted(proj)
+			if err != nil {
+				xlog.Fatal("%v", err)
+			}
+			ib.projectOnly = !trusted
+			b = ib
+		}
+		err = proj.ensureDir()
+		if err != nil {
+			xlog.Fatal("%v", err)
+		}
+		image, pulled := c.resolveImage(ctx, proj)
+		r, err := c.runner(proj, pulled)
+		if err != nil {
+			xlog.Fatal("%v", err)
+		}
+		r.backend = b
+		r.sandbox = sandbox
+
+		emitEvent(eventBuilding, proj.cntName(), nil)
+		err = c.build(ctx, c.gf, proj, c.hatBuilder(proj, image), r)
+		if err != nil {
+			emitEvent(eventFailed, proj.cntName(), map[string]string{"error": err.Error()})
+			xlog.Error("build run failed: %v", err)
+			if !c.keep || ctx.Err() != nil {
+				err = b.remove(context.Background(), proj.cntName())
+				if err != nil {
+					xlog.Error("failed to remove %v: %v", proj.cntName(), err)
+				}
+			}
+			os.Exit(1)
+		}
+	case !running:
+		xlog.Debug("starting %v", backendName(proj.cntName()))
+		err = b.start(ctx, proj.cntName())
+		if err != nil {
+			xlog.Fatal("failed to start %v: %v", proj.pathName(), err)
+		}
+	}
+
+	ports, err := loadPorts(proj.cntName())
+	if err != nil {
+		xlog.Fatal("%v", err)
+	}
+	u := "http://127.0.0.1:" + ports[codeServerPortName]
+	if exists {
+		err = waitURL(ctx, u, proj.conf.timeouts(false).start)
+		if err != nil {
+			xlog.Fatal("failed to wait for code-server: %v", err)
+		}
+	}
+
+	if c.noOpen {
+		return
+	}
+	if c.urlOnly {
+		fmt.Println(u)
+		return
+	}
+	err = proj.openURL(u)
+	if err != nil {
+		xlog.Fatal("failed to open project: %v", err)
+	}
+}
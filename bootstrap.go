@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"golang.org/x/xerrors"
+
+	"go.coder.com/sail/internal/xlog"
+)
+
+// bootstrapUser is the user created by the bootstrap, with bootstrapUID, the
+// uid of the first user of most hosts, so files in mounts keep their owner.
+const (
+	bootstrapUser = "user"
+	bootstrapUID  = "1000"
+)
+
+// bootstrapDockerfile provisions an image without a user account for sail,
+// installing what code-server and the launcher need and creating
+// bootstrapUser with passwordless sudo. An existing user with bootstrapUID,
+// such as ubuntu's, is renamed instead.
+const bootstrapDockerfile = `FROM %v
+USER root
+RUN set -e; \
+	if command -v apt-get >/dev/null; then \
+		apt-get update; \
+		DEBIAN_FRONTEND=noninteractive apt-get install -y --no-install-recommends \
+			bash ca-certificates curl git sudo procps libx11-6; \
+		rm -rf /var/lib/apt/lists/*; \
+	elif command -v dnf >/dev/null; then \
+		dnf install -y bash ca-certificates curl git sudo procps-ng shadow-utils libX11; \
+		dnf clean all; \
+	elif command -v yum >/dev/null; then \
+		yum install -y bash ca-certificates curl git sudo procps-ng shadow-utils libX11; \
+		yum clean all; \
+	elif command -v apk >/dev/null; then \
+		apk add --no-cache bash ca-certificates curl git sudo procps shadow libx11; \
+	else \
+		echo "no supported package manager, install bash, curl, git and sudo in the image" >&2; \
+		exit 1; \
+	fi; \
+	existing="$(getent passwd ` + bootstrapUID + ` | cut -d : -f 1)"; \
+	if [ -z "$existing" ]; then \
+		useradd -m -u ` + bootstrapUID + ` -s /bin/bash ` + bootstrapUser + `; \
+	elif [ "$existing" != ` + bootstrapUser + ` ]; then \
+		usermod -l ` + bootstrapUser + ` -d /home/` + bootstrapUser + ` -m -s /bin/bash "$existing"; \
+	fi; \
+	mkdir -p /etc/sudoers.d; \
+	echo "` + bootstrapUser + ` ALL=(ALL) NOPASSWD:ALL" > /etc/sudoers.d/sail; \
+	chmod 0440 /etc/sudoers.d/sail
+USER ` + bootstrapUser + `
+`
+
+// needsBootstrap reports whether the image with cfg has no user for sail,
+// i.e. it runs as root without naming one with guestUserLabel.
+func needsBootstrap(cfg *container.Config) bool {
+	if cfg == nil || cfg.Labels[guestUserLabel] != "" {
+		return false
+	}
+	switch cfg.User {
+	case "", "root", "0", "root:root", "0:0":
+		return true
+	}
+	return false
+}
+
+// bootstrapImageName returns the name of the bootstrapped image of base.
+// Changes to the bootstrap change the name, so they are applied.
+func bootstrapImageName(base string) string {
+	sum := sha256.Sum256([]byte(bootstrapDockerfile))
+	return derivedImageName(base, "bootstrap", hex.EncodeToString(sum[:])[:16])
+}
+
+// bootstrapImage returns the image to run for image, building its bootstrap
+// first if it has no user for sail.
+func (r *runner) bootstrapImage(ctx context.Context, image string) (string, error) {
+	if !r.bootstrap || r.user != "" {
+		return image, nil
+	}
+
+	cli := dockerClient()
+	defer cli.Close()
+
+	img, _, err := cli.ImageInspectWithRaw(ctx, image)
+	if err != nil {
+		return "", xerrors.Errorf("failed to inspect image: %w", err)
+	}
+	// Windows images don't run as Linux users, see applyWindows.
+	if img.Os == windowsOSType || !needsBootstrap(img.Config) {
+		return image, nil
+	}
+
+	to := r.timeouts.build
+	if to == 0 {
+		to = config{}.timeouts(false).build
+	}
+	ctx, cancel := context.WithTimeout(ctx, to)
+	defer cancel()
+
+	name := bootstrapImageName(image)
+	xlog.Info("%v has no user, bootstrapping it as %v", image, name)
+	// The image's own binaries run, so sandboxed projects don't get the
+	// host's network.
+	opts := buildOpts{sandbox: r.sandbox}
+	args := []string{
+		opts.network(), "-t", name,
+		"--label", bootstrapLabel + "=" + image,
+		"-",
+	}
+	err = dockerBuild(ctx, opts, args, fmt.Sprintf(bootstrapDockerfile, image))
+	if err != nil {
+		return "", xerrors.Errorf("failed to bootstrap %v: %w", image, err)
+	}
+	return name, nil
+}
+
+// sourceImage returns the image cnt was created from, before the bootstrap.
+func sourceImage(cnt types.ContainerJSON) string {
+	base := cnt.Config.Labels[bootstrapLabel]
+	// Images committed from bootstrapped containers keep the label.
+	if base == "" || normalizeImageName(bootstrapImageName(base)) != normalizeImageName(cnt.Config.Image) {
+		return cnt.Config.Image
+	}
+	return base
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_needsBootstrap(t *testing.T) {
+	assert.True(t, needsBootstrap(&container.Config{}))
+	assert.True(t, needsBootstrap(&container.Config{User: "0:0"}))
+	assert.False(t, needsBootstrap(&container.Config{User: "user"}))
+	assert.False(t, needsBootstrap(&container.Config{
+		Labels: map[string]string{guestUserLabel: "root"},
+	}))
+	assert.False(t, needsBootstrap(nil))
+}
+
+func Test_sourceImage(t *testing.T) {
+	name := bootstrapImageName("ubuntu:24.04")
+	assert.True(t, strings.HasPrefix(name, "ubuntu:24.04-bootstrap-"), name)
+
+	cnt := types.ContainerJSON{Config: &container.Config{
+		Image:  name,
+		Labels: map[string]string{bootstrapLabel: "ubuntu:24.04"},
+	}}
+	assert.Equal(t, "ubuntu:24.04", sourceImage(cnt))
+
+	// Images committed from a bootstrapped container keep its label.
+	cnt.Config.Image = "cdr/sail:export"
+	assert.Equal(t, "cdr/sail:export", sourceImage(cnt))
+}
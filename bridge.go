@@ -0,0 +1,204 @@
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"golang.org/x/xerrors"
+)
+
+// uploadsDirLabel is the image label of the directory in the container that
+// files dropped on and large pastes into code-server land in.
+const uploadsDirLabel = sailLabel + ".uploads_dir"
+
+// defaultUploadsDir is where uploads land if the image doesn't set
+// uploadsDirLabel.
+const defaultUploadsDir = "~/uploads"
+
+// bridgeHeader must be set on requests to the bridge. Browsers only let
+// pages of the proxy's own origin set it, so other sites can't write into the
+// container.
+const bridgeHeader = "X-Sail-Bridge"
+
+// upload writes the request's body into the uploads directory, as the file
+// of the name query parameter. It responds with the file's path in the
+// container, which is renamed if the name is taken.
+func (p *proxy) upload(w http.ResponseWriter, r *http.Request) {
+	name, err := uploadName(r.URL.Query().Get("name"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	p.bridge(w, r, name)
+}
+
+// clipboard writes a paste, the request's body, into a new file of the
+// uploads directory, and responds with its path.
+func (p *proxy) clipboard(w http.ResponseWriter, r *http.Request) {
+	p.bridge(w, r, "clipboard-"+time.Now().Format("20060102-150405")+".txt")
+}
+
+// bridge writes the request's body into the uploads directory as the file
+// name. Files go from the browser into the container through the proxy and
+// the Docker API rather than through code-server's connection, which drops
+// large pastes and uploads, especially to remote daemons.
+func (p *proxy) bridge(w http.ResponseWriter, r *http.Request, name string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !bridgeRequest(r) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Hour)
+	defer cancel()
+
+	dst, err := bridgeWrite(ctx, p.cntName, name, r.Body)
+	if err != nil {
+		p.log.Error("failed to write %v: %v", name, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	p.log.Info("wrote %v", dst)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"path": dst})
+}
+
+// bridgeRequest reports whether r comes from code-server's page, see
+// bridgeHeader.
+func bridgeRequest(r *http.Request) bool {
+	if r.Header.Get(bridgeHeader) == "" {
+		return false
+	}
+	origin := r.Header.Get("Origin")
+	return origin == "" || origin == "http://"+r.Host
+}
+
+// uploadName returns the base name of the file name the browser uploads,
+// which mustn't escape the uploads directory.
+func uploadName(name string) (string, error) {
+	name = path.Base(strings.Replace(name, `\`, "/", -1))
+	name = strings.Map(func(r rune) rune {
+		if r < ' ' || r == 0x7f {
+			return -1
+		}
+		return r
+	}, name)
+	if name == "" || name == "." || name == ".." || name == "/" {
+		return "", xerrors.New("invalid file name")
+	}
+	return name, nil
+}
+
+// uploadsDir returns the uploads directory of the container with labels.
+func uploadsDir(labels map[string]string) string {
+	dir := labels[uploadsDirLabel]
+	if dir == "" {
+		dir = defaultUploadsDir
+	}
+	return resolvePath(guestHome(labels), dir)
+}
+
+// bridgeWrite writes body into the uploads directory of the container
+// cntName as the file name, and returns its path. The file and directory are
+// owned by the container's user.
+func bridgeWrite(ctx context.Context, cntName, name string, body io.Reader) (string, error) {
+	cli := dockerClient()
+	defer cli.Close()
+
+	cnt, err := inspectContainer(ctx, cli, cntName)
+	if err != nil {
+		return "", xerrors.Errorf("failed to inspect %v: %w", cntName, err)
+	}
+	if cnt.Platform == windowsOSType {
+		return "", xerrors.New("uploads aren't supported in Windows containers")
+	}
+	dir := uploadsDir(cnt.Config.Labels)
+
+	// Tar headers need the size up front, so the body is spooled first.
+	f, err := ioutil.TempFile("", "sail-upload")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	size, err := io.Copy(f, body)
+	if err != nil {
+		return "", xerrors.Errorf("failed to receive upload: %w", err)
+	}
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		return "", err
+	}
+
+	name = freeName(name, func(name string) bool {
+		_, err := cli.ContainerStatPath(ctx, cntName, path.Join(dir, name))
+		return err == nil
+	})
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(writeUploadTar(pw, dir, name, f, size))
+	}()
+	err = cli.CopyToContainer(ctx, cntName, "/", pr, types.CopyToContainerOptions{CopyUIDGID: true})
+	pr.Close()
+	if err != nil {
+		return "", xerrors.Errorf("failed to copy into %v: %w", cntName, err)
+	}
+	return path.Join(dir, name), nil
+}
+
+// freeName returns name, or the first of name (1), name (2) and so on that
+// doesn't exist, keeping its extension.
+func freeName(name string, exists func(string) bool) string {
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := 1; exists(name); i++ {
+		name = fmt.Sprintf("%v (%v)%v", base, i, ext)
+	}
+	return name
+}
+
+// writeUploadTar writes the tar archive of the directory dir with the file
+// name of size, relative to /.
+func writeUploadTar(w io.Writer, dir, name string, r io.Reader, size int64) error {
+	tw := tar.NewWriter(w)
+	now := time.Now()
+	rel := strings.TrimPrefix(dir, "/")
+	err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     rel + "/",
+		Mode:     0755,
+		ModTime:  now,
+	})
+	if err != nil {
+		return err
+	}
+	err = tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     path.Join(rel, name),
+		Mode:     0644,
+		Size:     size,
+		ModTime:  now,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.CopyN(tw, r, size)
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_uploadName(t *testing.T) {
+	for in, exp := range map[string]string{
+		"notes.txt":           "notes.txt",
+		"../../etc/passwd":    "passwd",
+		`C:\Users\me\a b.png`: "a b.png",
+		"bad\nname":           "badname",
+	} {
+		name, err := uploadName(in)
+		require.NoError(t, err, in)
+		assert.Equal(t, exp, name, in)
+	}
+	for _, in := range []string{"", ".", "..", "/", "dir/.."} {
+		_, err := uploadName(in)
+		assert.Error(t, err, in)
+	}
+}
+
+func Test_freeName(t *testing.T) {
+	taken := map[string]bool{"a.txt": true, "a (1).txt": true}
+	assert.Equal(t, "a (2).txt", freeName("a.txt", func(n string) bool { return taken[n] }))
+	assert.Equal(t, "b.txt", freeName("b.txt", func(n string) bool { return taken[n] }))
+}
+
+func Test_uploadsDir(t *testing.T) {
+	assert.Equal(t, "/home/user/uploads", uploadsDir(map[string]string{}))
+	assert.Equal(t, "/srv/in", uploadsDir(map[string]string{uploadsDirLabel: "/srv/in"}))
+	assert.Equal(t, "/root/drop", uploadsDir(map[string]string{guestHomeLabel: "/root", uploadsDirLabel: "~/drop"}))
+}
+
+func Test_bridgeRequest(t *testing.T) {
+	r := httptest.NewRequest("POST", "http://127.0.0.1:8000/sail/api/v1/upload", nil)
+	assert.False(t, bridgeRequest(r))
+	r.Header.Set(bridgeHeader, "1")
+	assert.True(t, bridgeRequest(r))
+	r.Header.Set("Origin", "http://127.0.0.1:8000")
+	assert.True(t, bridgeRequest(r))
+	r.Header.Set("Origin", "http://evil.example")
+	assert.False(t, bridgeRequest(r))
+}
+
+func Test_writeUploadTar(t *testing.T) {
+	var b bytes.Buffer
+	err := writeUploadTar(&b, "/home/user/uploads", "a.txt", strings.NewReader("hello"), 5)
+	require.NoError(t, err)
+
+	tr := tar.NewReader(&b)
+	hdr, err := tr.Next()
+	require.NoError(t, err)
+	assert.Equal(t, "home/user/uploads/", hdr.Name)
+	hdr, err = tr.Next()
+	require.NoError(t, err)
+	assert.Equal(t, "home/user/uploads/a.txt", hdr.Name)
+	content, err := ioutil.ReadAll(tr)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(content))
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+
+	"go.coder.com/cli"
+	"go.coder.com/sail/internal/dockutil"
+	"go.coder.com/sail/internal/xlog"
+)
+
+// bugLogLines is how many lines of each log are included in bug reports.
+const bugLogLines = 1000
+
+type bugcmd struct {
+	gf *globalFlags
+
+	output string
+}
+
+func (c *bugcmd) Spec() cli.CommandSpec {
+	return cli.CommandSpec{
+		Name:  "bug",
+		Usage: "[flags] [repo]",
+		Desc: `Collects information for a bug report into a tarball.
+
+The tarball holds sail's version, its config, and Docker's info. With a repo,
+it also holds the project container's inspect output and recent logs.
+Secrets in the config and the container's environment are redacted.
+
+Attach it to an issue at https://github.com/cdr/sail/issues.`,
+	}
+}
+
+func (c *bugcmd) RegisterFlags(fl *flag.FlagSet) {
+	fl.StringVar(&c.output, "o", "", "Path to write the tarball to. Defaults to sail-bug-<time>.tar.gz in the current directory.")
+}
+
+func (c *bugcmd) Run(fl *flag.FlagSet) {
+	output := c.output
+	if output == "" {
+		output = fmt.Sprintf("sail-bug-%v.tar.gz", time.Now().Format("20060102-150405"))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	b := &bugReport{}
+	b.add("version.txt", []byte(fmt.Sprintf("sail %v\n%v %v/%v\n", versionOrDev(), runtime.Version(), runtime.GOOS, runtime.GOARCH)))
+
+	conf, err := ioutil.ReadFile(c.gf.configPath)
+	if err != nil {
+		b.addError("sail.toml", err)
+	} else {
+		b.add("sail.toml", redactConfig(conf))
+	}
+
+	b.addCmd("docker-version.txt", exec.CommandContext(ctx, "docker", "version"))
+	b.addCmd("docker-info.txt", exec.CommandContext(ctx, "docker", "info"))
+
+	if fl.NArg() > 0 {
+		proj := c.gf.project(schemaPrefs{}, fl)
+		c.addProject(ctx, b, proj.cntName())
+	}
+
+	err = b.write(output)
+	if err != nil {
+		xlog.Fatal("failed to write %v: %v", output, err)
+	}
+	xlog.Success("wrote %v, attach it to an issue at https://github.com/cdr/sail/issues", output)
+	xlog.Info("secrets are redacted, but review it before sharing")
+}
+
+// addProject adds the state and logs of the project's container.
+func (c *bugcmd) addProject(ctx context.Context, b *bugReport, cntName string) {
+	cli := dockerClient()
+	defer cli.Close()
+
+	cnt, err := dockutil.ContainerInspect(ctx, cli, cntName)
+	if err != nil {
+		b.addError("container-inspect.json", err)
+		return
+	}
+	cnt.Config.Env = redactEnv(cnt.Config.Env)
+	inspect, err := json.MarshalIndent(cnt, "", "\t")
+	if err != nil {
+		b.addError("container-inspect.json", err)
+	} else {
+		b.add("container-inspect.json", inspect)
+	}
+
+	// code-server's output is in the container's logs.
+	b.addCmd("container.log", exec.CommandContext(ctx, "docker", "logs", "--tail", fmt.Sprint(bugLogLines), cntName))
+
+	proxyLog, err := latestProxyLog(cntName)
+	if err != nil {
+		b.addError("proxy.log", err)
+	} else if proxyLog != "" {
+		log, err := tailFile(proxyLog, bugLogLines)
+		if err != nil {
+			b.addError("proxy.log", err)
+		} else {
+			b.add("proxy.log", log)
+		}
+	}
+
+	evs, err := tailFile(eventLogPath(), bugLogLines)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		b.addError("events.jsonl", err)
+	default:
+		b.add("events.jsonl", filterLines(evs, `"container":"`+cntName+`"`))
+	}
+}
+
+// bugReport is the contents of a bug report tarball.
+type bugReport struct {
+	names []string
+	files map[string][]byte
+}
+
+func (b *bugReport) add(name string, content []byte) {
+	if b.files == nil {
+		b.files = make(map[string][]byte)
+	}
+	if _, ok := b.files[name]; !ok {
+		b.names = append(b.names, name)
+	}
+	b.files[name] = content
+}
+
+// addError records that name couldn't be collected, so the report still
+// shows what was tried.
+func (b *bugReport) addError(name string, err error) {
+	b.add(name+".error", []byte(err.Error()+"\n"))
+}
+
+// addCmd adds the output of cmd as name.
+func (b *bugReport) addCmd(name string, cmd *exec.Cmd) {
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		out = append(out, fmt.Sprintf("\n%v failed: %v\n", strings.Join(cmd.Args, " "), err)...)
+	}
+	b.add(name, out)
+}
+
+// write writes the report as a gzipped tarball to path.
+func (b *bugReport) write(path string) error {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+
+	dir := strings.TrimSuffix(filepath.Base(path), ".tar.gz")
+	for _, name := range b.names {
+		content := b.files[name]
+		err := tw.WriteHeader(&tar.Header{
+			Name:    dir + "/" + name,
+			Mode:    0644,
+			Size:    int64(len(content)),
+			ModTime: time.Now(),
+		})
+		if err != nil {
+			return err
+		}
+		_, err = tw.Write(content)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := tw.Close()
+	if err != nil {
+		return err
+	}
+	err = gw.Close()
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, buf.Bytes(), 0600)
+}
+
+// secretKeyRegex matches names of config keys and environment variables
+// that likely hold secrets.
+var secretKeyRegex = regexp.MustCompile(`(?i)(token|secret|passw(or)?d|key|auth|credential)`)
+
+// redactConfig redacts the values of keys in a TOML config that look like
+// secrets.
+func redactConfig(conf []byte) []byte {
+	var out bytes.Buffer
+	sc := bufio.NewScanner(bytes.NewReader(conf))
+	for sc.Scan() {
+		line := sc.Text()
+		toks := strings.SplitN(line, "=", 2)
+		if len(toks) == 2 && !strings.HasPrefix(strings.TrimSpace(line), "#") && secretKeyRegex.MatchString(toks[0]) {
+			line = toks[0] + `= "REDACTED"`
+		}
+		out.WriteString(line + "\n")
+	}
+	return out.Bytes()
+}
+
+// redactEnv redacts the values of environment variables that look like
+// secrets.
+func redactEnv(env []string) []string {
+	out := make([]string, 0, len(env))
+	for _, v := range env {
+		toks := strings.SplitN(v, "=", 2)
+		if len(toks) == 2 && secretKeyRegex.MatchString(toks[0]) {
+			v = toks[0] + "=REDACTED"
+		}
+		out = append(out, v)
+	}
+	return out
+}
+
+// latestProxyLog returns the newest log file of the container's proxy, or
+// an empty string if there's none.
+func latestProxyLog(cntName string) (string, error) {
+	logs, err := filepath.Glob(filepath.Join(os.TempDir(), "sailproxy_"+cntName+"*"))
+	if err != nil {
+		return "", err
+	}
+
+	var (
+		latest    string
+		latestMod time.Time
+	)
+	for _, l := range logs {
+		fi, err := os.Stat(l)
+		if err != nil {
+			continue
+		}
+		if fi.ModTime().After(latestMod) {
+			latest, latestMod = l, fi.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// tailFile returns the last n lines of the file at path.
+func tailFile(path string, n int) ([]byte, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.SplitAfter(string(b), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return []byte(strings.Join(lines, "")), nil
+}
+
+// filterLines returns the lines of b containing substr.
+func filterLines(b []byte, substr string) []byte {
+	var out []string
+	for _, l := range strings.SplitAfter(string(b), "\n") {
+		if strings.Contains(l, substr) {
+			out = append(out, l)
+		}
+	}
+	return []byte(strings.Join(out, ""))
+}
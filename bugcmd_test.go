@@ -0,0 +1,76 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_redactConfig(t *testing.T) {
+	conf := `default_image = "codercom/ubuntu-dev"
+github_token = "ghp_secret"
+# api_key = "commented"
+`
+	assert.Equal(t, `default_image = "codercom/ubuntu-dev"
+github_token = "REDACTED"
+# api_key = "commented"
+`, string(redactConfig([]byte(conf))))
+}
+
+func Test_redactEnv(t *testing.T) {
+	assert.Equal(t,
+		[]string{"PATH=/usr/bin", "GITHUB_TOKEN=REDACTED", "AWS_SECRET_ACCESS_KEY=REDACTED"},
+		redactEnv([]string{"PATH=/usr/bin", "GITHUB_TOKEN=ghp_secret", "AWS_SECRET_ACCESS_KEY=abc"}),
+	)
+}
+
+func Test_bugReport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sail-bug")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	b := &bugReport{}
+	b.add("version.txt", []byte("sail v1"))
+	b.addError("sail.toml", os.ErrNotExist)
+
+	path := filepath.Join(dir, "sail-bug-1.tar.gz")
+	require.NoError(t, b.write(path))
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	gr, err := gzip.NewReader(f)
+	require.NoError(t, err)
+	tr := tar.NewReader(gr)
+
+	var names []string
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			break
+		}
+		names = append(names, hdr.Name)
+	}
+	assert.Equal(t, []string{"sail-bug-1/version.txt", "sail-bug-1/sail.toml.error"}, names)
+}
+
+func Test_tailFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "sail-tail")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	_, err = f.WriteString("a\nb\nc\n")
+	require.NoError(t, err)
+	f.Close()
+
+	b, err := tailFile(f.Name(), 2)
+	require.NoError(t, err)
+	assert.Equal(t, "b\nc\n", string(b))
+
+	assert.Equal(t, "b\n", string(filterLines(b, "b")))
+}
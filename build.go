@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"golang.org/x/xerrors"
+
+	"go.coder.com/sail/internal/xexec"
+	"go.coder.com/sail/internal/xlog"
+)
+
+// buildOpts are the options for building a project's images.
+type buildOpts struct {
+	// secrets are exposed to RUN --mount=type=secret instructions.
+	// They're of form id=<id>,src=<path>.
+	secrets []string
+	// buildArgs are passed as --build-arg, of form KEY[=VALUE].
+	buildArgs []string
+	noCache   bool
+	// pull always pulls newer versions of the base image.
+	pull bool
+	// platform is the platform to build for, e.g. linux/amd64. The daemon's
+	// is used if it's empty.
+	platform string
+	// frozen fails builds and pulls that can't use the digests of the
+	// project's .sail/lock, instead of falling back to the images' tags.
+	frozen bool
+	// unlocked ignores the project's .sail/lock.
+	unlocked bool
+	// sandbox builds the image of a sandboxed project on Docker's default
+	// network instead of the host's, without the host's proxy settings.
+	sandbox bool
+}
+
+// network returns the --network of builds.
+func (o buildOpts) network() string {
+	if o.sandbox {
+		return "--network=default"
+	}
+	return "--network=host"
+}
+
+// args returns the docker build arguments for the options.
+func (o buildOpts) args() []string {
+	var args []string
+	for _, s := range o.secrets {
+		args = append(args, "--secret", s)
+	}
+	for _, a := range o.buildArgs {
+		args = append(args, "--build-arg", a)
+	}
+	if o.noCache {
+		args = append(args, "--no-cache")
+	}
+	if o.pull {
+		args = append(args, "--pull")
+	}
+	if o.platform != "" {
+		args = append(args, "--platform", o.platform)
+	}
+	return args
+}
+
+// validatePlatform checks that platform is of form linux/<arch>[/<variant>],
+// as sail environments are linux containers.
+func validatePlatform(platform string) error {
+	toks := strings.Split(platform, "/")
+	if len(toks) < 2 || len(toks) > 3 || toks[0] != "linux" || toks[1] == "" {
+		return xerrors.Errorf("invalid platform %q: must be of form linux/<arch>, e.g. linux/amd64", platform)
+	}
+	return nil
+}
+
+// validateSecret checks that secret is of form id=<id>,src=<path> and that
+// the path exists, so mistakes are reported before a long build.
+func validateSecret(secret string) error {
+	var id, src string
+	for _, kv := range strings.Split(secret, ",") {
+		sp := strings.SplitN(kv, "=", 2)
+		if len(sp) != 2 {
+			return xerrors.Errorf("invalid secret %q: must be of form id=<id>,src=<path>", secret)
+		}
+		switch sp[0] {
+		case "id":
+			id = sp[1]
+		case "src", "source":
+			src = sp[1]
+		}
+	}
+	if id == "" || src == "" {
+		return xerrors.Errorf("invalid secret %q: must be of form id=<id>,src=<path>", secret)
+	}
+
+	_, err := os.Stat(src)
+	if err != nil {
+		return xerrors.Errorf("secret %v: %w", id, err)
+	}
+	return nil
+}
+
+// dockerBuild runs docker build with args, using stdin as its input.
+// The args are passed directly so labels may contain any characters.
+// The host's proxy settings are passed as build arguments, so builds work
+// behind proxies, unless the build is sandboxed.
+//
+// Builds use BuildKit so Dockerfiles can use RUN --mount for caches and
+// secrets, unless it's disabled with DOCKER_BUILDKIT=0.
+func dockerBuild(ctx context.Context, opts buildOpts, args []string, stdin string) error {
+	buildArgs := append([]string{"build"}, opts.args()...)
+	if !opts.sandbox {
+		buildArgs = append(buildArgs, proxyBuildArgs(opts.buildArgs)...)
+	}
+	args = append(buildArgs, args...)
+	xlog.Info("running docker %v", strings.Join(args, " "))
+
+	cmd := exec.CommandContext(ctx, "docker", args...)
+	xexec.Attach(cmd)
+	cmd.Stdin = strings.NewReader(stdin)
+	stderr := &tailWriter{max: 4096}
+	cmd.Stderr = io.MultiWriter(os.Stderr, stderr)
+	cmd.Env = os.Environ()
+	if _, ok := os.LookupEnv("DOCKER_BUILDKIT"); !ok {
+		cmd.Env = append(cmd.Env, "DOCKER_BUILDKIT=1")
+	}
+
+	err := cmd.Run()
+	if err != nil {
+		if ctx.Err() != nil {
+			return xerrors.Errorf("failed to build: %w", ctx.Err())
+		}
+		// The registry of the images the build pulls is unknown.
+		return xerrors.Errorf("failed to build: %w", checkAuthError(err, stderr.String(), ""))
+	}
+	return nil
+}
+
+// buildLabelImage builds image from base, adding labels and the environment
+// variables env. base must have been pulled for platform.
+func buildLabelImage(ctx context.Context, image, base, platform string, labels, env map[string]string) error {
+	// The image only adds labels and variables, so it's built without a
+	// context.
+	args := []string{"-t", image}
+	for _, k := range sortedKeys(labels) {
+		args = append(args, "--label", k+"="+labels[k])
+	}
+	args = append(args, "-")
+
+	dockerfile := "FROM " + base + "\n"
+	for _, k := range sortedKeys(env) {
+		dockerfile += "ENV " + k + "=" + strconv.Quote(env[k]) + "\n"
+	}
+	return dockerBuild(ctx, buildOpts{platform: platform}, args, dockerfile)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_validateSecret(t *testing.T) {
+	t.Parallel()
+
+	fi, err := ioutil.TempFile("", "sail-secret")
+	require.NoError(t, err)
+	fi.Close()
+	defer os.Remove(fi.Name())
+
+	assert.NoError(t, validateSecret("id=npmrc,src="+fi.Name()))
+	assert.NoError(t, validateSecret("id=npmrc,source="+fi.Name()))
+	assert.Error(t, validateSecret("id=npmrc,src="+fi.Name()+"-missing"))
+	assert.Error(t, validateSecret("id=npmrc"))
+	assert.Error(t, validateSecret(fi.Name()))
+}
+
+func Test_buildOpts(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t,
+		[]string{"--secret", "id=a,src=/a", "--secret", "id=b,src=/b"},
+		buildOpts{secrets: []string{"id=a,src=/a", "id=b,src=/b"}}.args(),
+	)
+	assert.Equal(t,
+		[]string{"--build-arg", "GO_VERSION=1.13", "--build-arg", "GOPROXY", "--no-cache", "--pull"},
+		buildOpts{
+			buildArgs: []string{"GO_VERSION=1.13", "GOPROXY"},
+			noCache:   true,
+			pull:      true,
+		}.args(),
+	)
+	assert.Equal(t,
+		[]string{"--platform", "linux/amd64"},
+		buildOpts{platform: "linux/amd64"}.args(),
+	)
+	assert.Equal(t, "--network=host", buildOpts{}.network())
+	assert.Equal(t, "--network=default", buildOpts{sandbox: true}.network())
+}
+
+func Test_validatePlatform(t *testing.T) {
+	t.Parallel()
+
+	assert.NoError(t, validatePlatform("linux/amd64"))
+	assert.NoError(t, validatePlatform("linux/arm/v7"))
+	assert.Error(t, validatePlatform("amd64"))
+	assert.Error(t, validatePlatform("windows/amd64"))
+	assert.Error(t, validatePlatform("linux/"))
+	assert.Error(t, validatePlatform("linux/arm/v7/x"))
+}
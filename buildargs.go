@@ -0,0 +1,90 @@
+package main
+
+import (
+	"os"
+	"os/user"
+	"regexp"
+	"strconv"
+
+	"golang.org/x/xerrors"
+)
+
+// hostBuildArgs returns the build arguments sail passes to every build of
+// project, so Dockerfiles and hats can declare them with ARG instead of
+// hardcoding the host user.
+func hostBuildArgs(project string) map[string]string {
+	u := currentHostUser()
+	args := map[string]string{
+		"SAIL_PROJECT": project,
+		"SAIL_UID":     strconv.Itoa(u.uid),
+		"SAIL_GID":     strconv.Itoa(u.gid),
+	}
+	hu, err := user.LookupId(strconv.Itoa(u.uid))
+	if err == nil {
+		args["SAIL_USER"] = hu.Username
+	}
+	return args
+}
+
+// buildArgs returns the build arguments of project, of form KEY=VALUE. They're
+// sail's own, then build_args, then project_build_args of project, each
+// overriding the previous ones. $VAR and ${VAR} in values are expanded from
+// sail's build arguments and the environment.
+func (c config) buildArgs(project string) []string {
+	host := hostBuildArgs(project)
+	args := make(map[string]string, len(host))
+	for k, v := range host {
+		args[k] = v
+	}
+
+	expand := func(v string) string {
+		return os.Expand(v, func(k string) string {
+			if v, ok := host[k]; ok {
+				return v
+			}
+			return os.Getenv(k)
+		})
+	}
+	for k, v := range c.BuildArgs {
+		args[k] = expand(v)
+	}
+	for k, v := range c.ProjectBuildArgs[project] {
+		args[k] = expand(v)
+	}
+
+	kvs := make([]string, 0, len(args))
+	for _, k := range sortedKeys(args) {
+		kvs = append(kvs, k+"="+args[k])
+	}
+	return kvs
+}
+
+var buildArgNameRx = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// checkBuildArgs validates the names of build_args, project_build_args and
+// hat_args.
+func checkBuildArgs(c config) error {
+	check := func(key string, args map[string]string) error {
+		for k := range args {
+			if !buildArgNameRx.MatchString(k) {
+				return xerrors.Errorf("%v: invalid build argument %q, must only contain letters, digits and underscores", key, k)
+			}
+		}
+		return nil
+	}
+	err := check("build_args", c.BuildArgs)
+	if err != nil {
+		return err
+	}
+	err = check("hat_args", c.HatArgs)
+	if err != nil {
+		return err
+	}
+	for proj, args := range c.ProjectBuildArgs {
+		err = check("project_build_args."+proj, args)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_buildArgs(t *testing.T) {
+	os.Setenv("SAIL_TEST_GOPROXY", "https://proxy.example.com")
+	defer os.Unsetenv("SAIL_TEST_GOPROXY")
+
+	c := config{
+		BuildArgs: map[string]string{
+			"GOPROXY":    "$SAIL_TEST_GOPROXY",
+			"GO_VERSION": "1.12",
+			"OWNER":      "${SAIL_UID}:${SAIL_GID}",
+		},
+		ProjectBuildArgs: map[string]map[string]string{
+			"cdr/sail": {"GO_VERSION": "1.13"},
+		},
+	}
+
+	u := currentHostUser()
+	args := c.buildArgs("cdr/sail")
+	assert.Contains(t, args, "GOPROXY=https://proxy.example.com")
+	assert.Contains(t, args, "GO_VERSION=1.13")
+	assert.Contains(t, args, "OWNER="+strconv.Itoa(u.uid)+":"+strconv.Itoa(u.gid))
+	assert.Contains(t, args, "SAIL_PROJECT=cdr/sail")
+	assert.Contains(t, c.buildArgs("cdr/api"), "GO_VERSION=1.12")
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"golang.org/x/xerrors"
+
+	"go.coder.com/cli"
+	"go.coder.com/sail/internal/xlog"
+)
+
+type buildcmd struct {
+	gf *globalFlags
+
+	schemaPrefs
+
+	secrets   stringsFlag
+	buildArgs stringsFlag
+	noCache   bool
+	pull      bool
+	platform  string
+	frozen    bool
+}
+
+func (c *buildcmd) Spec() cli.CommandSpec {
+	return cli.CommandSpec{
+		Name:  "build",
+		Usage: "[flags] <repo>",
+		Desc: `Builds a project's image without running it.
+The project is cloned if it doesn't exist yet.
+On success, the image's name and ID are printed separated by a tab.
+
+Images are built with BuildKit, so Dockerfiles can use RUN --mount=type=cache
+and RUN --mount=type=secret. Set DOCKER_BUILDKIT=0 to use the legacy builder.`,
+	}
+}
+
+func (c *buildcmd) RegisterFlags(fl *flag.FlagSet) {
+	fl.BoolVar(&c.ssh, "ssh", false, "Clone repo over SSH")
+	fl.BoolVar(&c.http, "http", false, "Clone repo over HTTP")
+	fl.BoolVar(&c.https, "https", false, "Clone repo over HTTPS")
+
+	fl.Var(&c.secrets, "secret", "Secret to expose to the build, of form id=<id>,src=<path>. Can be repeated.")
+	fl.Var(&c.buildArgs, "build-arg", "Build argument of form KEY[=VALUE]. Can be repeated. Overrides build_args in the config.")
+	fl.BoolVar(&c.noCache, "no-cache", false, "Don't use the build cache.")
+	fl.BoolVar(&c.pull, "pull", false, "Always pull newer versions of the base image.")
+	fl.StringVar(&c.platform, "platform", "", "Platform to build the image for, e.g. linux/amd64. Overrides platform in the config.")
+	fl.BoolVar(&c.frozen, "frozen", false, "Fail if the image can't be built at the digests of .sail/lock.")
+}
+
+func (c *buildcmd) Run(fl *flag.FlagSet) {
+	proj := c.gf.project(c.schemaPrefs, fl)
+	c.gf.ensureDockerDaemon()
+
+	for _, s := range c.secrets {
+		err := validateSecret(s)
+		if err != nil {
+			xlog.Fatal("%v", err)
+		}
+	}
+	proj.buildOpts.secrets = c.secrets
+	// Flags override the config's build arguments.
+	proj.buildOpts.buildArgs = append(proj.buildOpts.buildArgs, c.buildArgs...)
+	proj.buildOpts.noCache = c.noCache
+	proj.buildOpts.pull = c.pull
+	proj.buildOpts.frozen = c.frozen
+	if c.platform != "" {
+		err := validatePlatform(c.platform)
+		if err != nil {
+			xlog.Fatal("%v", err)
+		}
+		proj.buildOpts.platform = c.platform
+	}
+
+	_, err := proj.lock()
+	if err != nil {
+		xlog.Fatal("failed to lock project: %v", err)
+	}
+
+	err = proj.ensureDir()
+	if err != nil {
+		xlog.Fatal("%v", err)
+	}
+
+	image, ok, err := proj.buildImage()
+	if err != nil {
+		xlog.Fatal("failed to build image: %v", err)
+	}
+	if !ok {
+		xlog.Fatal("%v doesn't define an environment, it uses the default image %v", proj.pathName(), proj.defaultRepoImage())
+	}
+
+	id, err := inspectImageID(image)
+	if err != nil {
+		xlog.Fatal("%v", err)
+	}
+	fmt.Printf("%v\t%v\n", image, id)
+}
+
+// inspectImageID returns the content addressable ID of image.
+func inspectImageID(image string) (string, error) {
+	cli := dockerClient()
+	defer cli.Close()
+
+	ins, _, err := cli.ImageInspectWithRaw(context.Background(), image)
+	if err != nil {
+		return "", xerrors.Errorf("failed to inspect %v: %w", image, err)
+	}
+	return ins.ID, nil
+}
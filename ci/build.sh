@@ -19,3 +19,8 @@ build(){
 
 GOOS=darwin build
 GOOS=linux build
+
+# sail selfupdate verifies the tarballs against these checksums.
+pushd bin
+sha256sum sail-*.tar.gz > SHA256SUMS
+popd
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"golang.org/x/xerrors"
+
+	"go.coder.com/cli"
+	"go.coder.com/sail/internal/dockutil"
+	"go.coder.com/sail/internal/xlog"
+)
+
+type clonecmd struct {
+	gf *globalFlags
+
+	copy   bool
+	noOpen bool
+}
+
+func (c *clonecmd) Spec() cli.CommandSpec {
+	return cli.CommandSpec{
+		Name:  "clone",
+		Usage: "[flags] <repo> <new-name>",
+		Desc: `Clones a project's environment into a new project, to try out risky changes.
+
+The container is committed to an image, so the new environment has everything
+installed in it, and the editor's state, environment variables and mounts are
+copied over. The new project is named <new-name>, of form <name> or <org>/<name>.
+
+The project directory is cloned with git, so the new project starts at the
+committed state of the current branch. With --copy, it's copied instead,
+uncommitted changes included.`,
+	}
+}
+
+func (c *clonecmd) RegisterFlags(fl *flag.FlagSet) {
+	fl.BoolVar(&c.copy, "copy", false, "Copy the project directory instead of cloning it with git.")
+	fl.BoolVar(&c.noOpen, "no-open", false, "Don't open the new project.")
+}
+
+func (c *clonecmd) Run(fl *flag.FlagSet) {
+	if fl.NArg() != 2 {
+		fl.Usage()
+		os.Exit(1)
+	}
+	src := projectArg(c.gf, fl)
+	name := fl.Arg(1)
+	err := validateProjectName(name)
+	if err != nil {
+		xlog.Fatal("%v", err)
+	}
+	if name == src.pathName() {
+		xlog.Fatal("the new project must have another name than %v", src.pathName())
+	}
+	c.gf.ensureDockerDaemon()
+
+	dst := *src
+	dst.name = name
+
+	_, err = dst.lock()
+	if err != nil {
+		xlog.Fatal("failed to lock project: %v", err)
+	}
+
+	exists, err := src.cntExists()
+	if err != nil {
+		xlog.Fatal("%v", err)
+	}
+	if !exists {
+		xlog.Fatal("%v doesn't exist, create it with sail run", src.pathName())
+	}
+	exists, err = dst.cntExists()
+	if err != nil {
+		xlog.Fatal("%v", err)
+	}
+	if exists {
+		xlog.Fatal("%v already exists", dst.pathName())
+	}
+	_, err = os.Stat(dst.localDir())
+	if err == nil {
+		xlog.Fatal("%v already exists", dst.localDir())
+	}
+
+	ctx, cancel := withInterrupt(context.Background())
+	defer cancel()
+
+	err = c.clone(ctx, src, &dst)
+	if err != nil {
+		xlog.Fatal("failed to clone %v: %v", src.pathName(), err)
+	}
+	xlog.Success("cloned %v to %v", src.pathName(), dst.pathName())
+
+	if c.noOpen {
+		return
+	}
+	err = dst.open()
+	if err != nil {
+		xlog.Fatal("failed to open project: %v", err)
+	}
+}
+
+// clone creates the environment of dst from the environment of src.
+func (c *clonecmd) clone(ctx context.Context, src, dst *project) error {
+	r, err := runnerFromContainer(src.cntName())
+	if err != nil {
+		return xerrors.Errorf("failed to initialize runner: %w", err)
+	}
+
+	image := dst.imageName() + ":clone"
+	xlog.Info("committing %v to %v", src.pathName(), image)
+	err = commitContainer(ctx, src.cntName(), image)
+	if err != nil {
+		return err
+	}
+
+	err = cloneProjectDir(src.localDir(), dst.localDir(), c.copy)
+	if err != nil {
+		return err
+	}
+	err = cloneMeta(src.cntName(), dst.cntName())
+	if err != nil {
+		return err
+	}
+
+	r.cntName = dst.cntName()
+	r.name = dst.pathName()
+	r.projectLocalDir = dst.localDir()
+	r.port = "0"
+	r.proxyURL = ""
+	r.timeouts = dst.conf.timeouts(false)
+	r.idleTimeout = time.Duration(dst.conf.IdleTimeout)
+	r.codeServer = dst.conf.codeServerSource()
+	r.groups = dst.conf.groupsOf(dst.pathName())
+	// The clone is an environment of its own, not of the pull request the
+	// original was created for.
+	r.review = ""
+	r.ephemeral = false
+
+	emitEvent(eventBuilding, dst.cntName(), nil)
+	err = new(runcmd).build(ctx, c.gf, dst, &hatBuilder{baseImage: image}, r)
+	if err != nil {
+		emitEvent(eventFailed, dst.cntName(), map[string]string{"error": err.Error()})
+		rmErr := dockutil.StopRemove(context.Background(), dockerClient(), dst.cntName())
+		if rmErr != nil {
+			xlog.Error("failed to remove %v: %v", dst.cntName(), rmErr)
+		}
+		xlog.Info("%v is kept, sail run %v creates its container from scratch", dst.localDir(), dst.pathName())
+		return err
+	}
+	return nil
+}
+
+// commitContainer commits the container cntName to image. The project and
+// the editor's state aren't committed, as they're mounted.
+func commitContainer(ctx context.Context, cntName, image string) error {
+	cli := dockerClient()
+	defer cli.Close()
+
+	// Committed images can't drop labels, so the runner's are unset to keep
+	// them from applying to the containers of the image.
+	var changes []string
+	for l, v := range runnerOnlyLabels {
+		changes = append(changes, fmt.Sprintf("LABEL %v=%q", l, v))
+	}
+	_, err := cli.ContainerCommit(ctx, cntName, types.ContainerCommitOptions{
+		Reference: image,
+		Comment:   "sail clone of " + toSailName(cntName),
+		Changes:   changes,
+		Pause:     true,
+	})
+	if err != nil {
+		return xerrors.Errorf("failed to commit %v: %w", cntName, err)
+	}
+	return nil
+}
+
+// cloneProjectDir creates the project directory dst from the project
+// directory src. Git repositories are cloned, with the same origin, unless
+// copyFiles is set, in which case src is copied as is.
+//
+// A git worktree wouldn't work in the container, as its repository is
+// outside of the mounted project directory.
+func cloneProjectDir(src, dst string, copyFiles bool) error {
+	_, err := os.Stat(filepath.Join(src, ".git"))
+	if copyFiles || err != nil {
+		xlog.Info("copying %v to %v", src, dst)
+		return copyPath(src, dst)
+	}
+
+	xlog.Info("cloning %v to %v", src, dst)
+	out, err := exec.Command("git", "clone", "--quiet", src, dst).CombinedOutput()
+	if err != nil {
+		return xerrors.Errorf("failed to clone %v: %s: %w", src, out, err)
+	}
+
+	// The clone's origin is src, it should be where src pulls from.
+	out, err = exec.Command("git", "-C", src, "remote", "get-url", "origin").Output()
+	if err != nil {
+		// src has no origin to share.
+		return nil
+	}
+	origin := strings.TrimSpace(string(out))
+	out, err = exec.Command("git", "-C", dst, "remote", "set-url", "origin", origin).CombinedOutput()
+	if err != nil {
+		return xerrors.Errorf("failed to set origin of %v: %s: %w", dst, out, err)
+	}
+	return nil
+}
+
+// cloneMeta copies the state sail keeps on the host for the container srcCnt
+// to the container dstCnt: the editor's state, the environment variables and
+// the mounts.
+func cloneMeta(srcCnt, dstCnt string) error {
+	paths := []struct {
+		src, dst string
+	}{
+		{filepath.Join(metaRoot(), srcCnt, "globalStorage"), filepath.Join(metaRoot(), dstCnt, "globalStorage")},
+		{envPath(srcCnt), envPath(dstCnt)},
+		{mountsPath(srcCnt), mountsPath(dstCnt)},
+	}
+	for _, p := range paths {
+		_, err := os.Stat(p.src)
+		if os.IsNotExist(err) {
+			continue
+		}
+		// Any state left from a removed project of the same name is stale.
+		err = os.RemoveAll(p.dst)
+		if err != nil {
+			return err
+		}
+		err = os.MkdirAll(filepath.Dir(p.dst), 0750)
+		if err != nil {
+			return err
+		}
+		err = copyPath(p.src, p.dst)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyPath copies the file or directory src to dst, which mustn't exist,
+// keeping its permissions.
+func copyPath(src, dst string) error {
+	out, err := exec.Command("cp", "-a", src, dst).CombinedOutput()
+	if err != nil {
+		return xerrors.Errorf("failed to copy %v to %v: %s: %w", src, dst, out, err)
+	}
+	return nil
+}
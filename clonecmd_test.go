@@ -0,0 +1,90 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_cloneProjectDir(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "sail-clone")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmp)
+
+	git := func(dir string, args ...string) string {
+		cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=sail", "GIT_AUTHOR_EMAIL=sail@coder.com",
+			"GIT_COMMITTER_NAME=sail", "GIT_COMMITTER_EMAIL=sail@coder.com",
+		)
+		out, err := cmd.CombinedOutput()
+		require.NoError(t, err, "%s", out)
+		return strings.TrimSpace(string(out))
+	}
+
+	src := filepath.Join(tmp, "src")
+	require.NoError(t, os.Mkdir(src, 0750))
+	git(src, "init", "--quiet")
+	git(src, "remote", "add", "origin", "https://github.com/cdr/sail.git")
+	require.NoError(t, ioutil.WriteFile(filepath.Join(src, "committed"), nil, 0640))
+	git(src, "add", "committed")
+	git(src, "commit", "--quiet", "-m", "commit")
+	require.NoError(t, ioutil.WriteFile(filepath.Join(src, "uncommitted"), nil, 0640))
+
+	t.Run("Clone", func(t *testing.T) {
+		dst := filepath.Join(tmp, "clone")
+		require.NoError(t, cloneProjectDir(src, dst, false))
+
+		assert.FileExists(t, filepath.Join(dst, "committed"))
+		_, err := os.Stat(filepath.Join(dst, "uncommitted"))
+		assert.True(t, os.IsNotExist(err))
+		assert.Equal(t, "https://github.com/cdr/sail.git", git(dst, "remote", "get-url", "origin"))
+	})
+
+	t.Run("Copy", func(t *testing.T) {
+		dst := filepath.Join(tmp, "copy")
+		require.NoError(t, cloneProjectDir(src, dst, true))
+
+		assert.FileExists(t, filepath.Join(dst, "committed"))
+		assert.FileExists(t, filepath.Join(dst, "uncommitted"))
+	})
+}
+
+func Test_cloneMeta(t *testing.T) {
+	home, err := ioutil.TempDir("", "sail-clone")
+	require.NoError(t, err)
+	defer os.RemoveAll(home)
+	defer os.Setenv("HOME", os.Getenv("HOME"))
+	os.Setenv("HOME", home)
+
+	storage := filepath.Join(metaRoot(), "cdr_sail", "globalStorage")
+	require.NoError(t, os.MkdirAll(storage, 0750))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(storage, "state.vscdb"), []byte("state"), 0640))
+	require.NoError(t, ioutil.WriteFile(envPath("cdr_sail"), []byte(`["FOO=bar"]`), 0640))
+
+	// Stale state of a removed project of the same name is replaced.
+	stale := filepath.Join(metaRoot(), "cdr_sail-risky", "globalStorage")
+	require.NoError(t, os.MkdirAll(stale, 0750))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(stale, "stale"), nil, 0640))
+
+	require.NoError(t, cloneMeta("cdr_sail", "cdr_sail-risky"))
+
+	b, err := ioutil.ReadFile(filepath.Join(stale, "state.vscdb"))
+	require.NoError(t, err)
+	assert.Equal(t, "state", string(b))
+	_, err = os.Stat(filepath.Join(stale, "stale"))
+	assert.True(t, os.IsNotExist(err))
+
+	env, err := loadEnv("cdr_sail-risky")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"FOO=bar"}, env)
+
+	_, err = os.Stat(mountsPath("cdr_sail-risky"))
+	assert.True(t, os.IsNotExist(err))
+}
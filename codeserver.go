@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"io"
 	"io/ioutil"
 	"net"
@@ -10,23 +12,17 @@ import (
 	"os/exec"
 	"path/filepath"
 	"runtime"
-	"strconv"
 	"strings"
 	"time"
 
 	"golang.org/x/xerrors"
 
-	"go.coder.com/flog"
 	"go.coder.com/sail/internal/codeserver"
+	"go.coder.com/sail/internal/xlog"
 )
 
-// loadCodeServer produces a path containing the code-server binary.
-// It will attempt to cache the binary.
-func loadCodeServer(ctx context.Context) (string, error) {
-	start := time.Now()
-
-	var cachePath string
-	const codeServerPathSuffix = "sail-code-server-cache/code-server"
+// codeServerCacheDir returns the directory the code-server binary is cached in.
+func codeServerCacheDir() string {
 	// MacOS maps os.TempDir() to `/var/folders/...`, which isn't shared with the docker
 	// system since docker tries to comply with Apple's filesystem sandbox guidelines, so
 	// default to `/tmp` when on MacOS.
@@ -35,102 +31,468 @@ func loadCodeServer(ctx context.Context) (string, error) {
 	// https://stackoverflow.com/questions/45122459/docker-mounts-denied-the-paths-are-not-shared-from-os-x-and-are-not-known
 	switch runtime.GOOS {
 	case "darwin":
-		cachePath = filepath.Join("/tmp", codeServerPathSuffix)
+		return filepath.Join("/tmp", "sail-code-server-cache")
 	default:
-		cachePath = filepath.Join(os.TempDir(), codeServerPathSuffix)
+		return filepath.Join(os.TempDir(), "sail-code-server-cache")
 	}
+}
 
-	// downloadURLPath stores the download URL, so we know whether we should update
-	// the binary.
-	downloadURLPath := cachePath + ".download_url"
+// codeServerArchCacheDir returns the directory the code-server binary for
+// linux on arch is cached in.
+func codeServerArchCacheDir(arch string) string {
+	return filepath.Join(codeServerCacheDir(), arch)
+}
 
-	// Only check for a new codeserver if it's over an hour old.
-	info, err := os.Stat(cachePath)
-	if err == nil {
-		if info.ModTime().Add(time.Hour).After(time.Now()) {
-			return cachePath, nil
+// imageArch returns the architecture of image as a GOARCH, which is the
+// architecture of the code-server it needs.
+func imageArch(image string) (string, error) {
+	cli := dockerClient()
+	defer cli.Close()
+
+	ins, _, err := cli.ImageInspectWithRaw(context.Background(), image)
+	if err != nil {
+		return "", xerrors.Errorf("failed to inspect %v: %w", image, err)
+	}
+	if ins.Architecture == "" {
+		return "amd64", nil
+	}
+	return ins.Architecture, nil
+}
+
+// codeServerSource is where code-server comes from. It's the latest release
+// on GitHub if both fields are empty.
+type codeServerSource struct {
+	// path is a code-server binary on the host.
+	path string
+	// url is a release tarball, with {arch} standing for the architecture.
+	url string
+	// windowsPath is a directory on the host with a Windows build of
+	// code-server, for Windows containers.
+	windowsPath string
+}
+
+// codeServerSource returns the code-server configured in c.
+func (c config) codeServerSource() codeServerSource {
+	return codeServerSource{
+		path:        c.CodeServerPath,
+		url:         c.CodeServerURL,
+		windowsPath: c.WindowsCodeServerPath,
+	}
+}
+
+// load returns the path of the code-server binary for linux on arch,
+// downloading it if needed.
+func (s codeServerSource) load(ctx context.Context, arch string) (string, error) {
+	switch {
+	case s.path != "":
+		binPath := s.localPath()
+		fi, err := os.Stat(binPath)
+		if err != nil {
+			return "", xerrors.Errorf("failed to find code_server_path: %w", err)
 		}
+		if !fi.Mode().IsRegular() || fi.Mode()&0111 == 0 {
+			return "", xerrors.Errorf("code_server_path %v isn't an executable file", binPath)
+		}
+		return binPath, nil
+	case s.url != "":
+		return loadCodeServerURL(ctx, arch, s.archURL(arch))
+	default:
+		return loadCodeServer(ctx, arch)
 	}
+}
+
+// cachedPath is like load, but it doesn't download anything. The path
+// code-server is downloaded to is returned if it isn't yet.
+func (s codeServerSource) cachedPath(arch string) string {
+	switch {
+	case s.path != "":
+		return s.localPath()
+	case s.url != "":
+		return filepath.Join(codeServerArchCacheDir(arch), urlCodeServerVersion(s.archURL(arch)), codeServerBin)
+	default:
+		return cachedCodeServerPath(arch)
+	}
+}
+
+func (s codeServerSource) localPath() string {
+	hostHomeDir, err := os.UserHomeDir()
+	if err != nil {
+		panic(err)
+	}
+	return resolvePath(hostHomeDir, s.path)
+}
+
+func (s codeServerSource) windowsLocalPath() string {
+	hostHomeDir, err := os.UserHomeDir()
+	if err != nil {
+		panic(err)
+	}
+	return resolvePath(hostHomeDir, s.windowsPath)
+}
+
+func (s codeServerSource) archURL(arch string) string {
+	return strings.Replace(s.url, "{arch}", arch, -1)
+}
+
+// codeServerURLPrefix starts the versions of code-server downloaded from a
+// code_server_url.
+const codeServerURLPrefix = "url-"
+
+// urlCodeServerVersion returns the version code-server downloaded from u
+// is cached as. It's derived from u, so changing it downloads code-server
+// again.
+func urlCodeServerVersion(u string) string {
+	sum := sha256.Sum256([]byte(u))
+	return codeServerURLPrefix + hex.EncodeToString(sum[:])[:12]
+}
 
-	err = os.MkdirAll(filepath.Dir(cachePath), 0750)
+// loadCodeServerURL produces a path containing the code-server binary of
+// the release tarball at u. It's only downloaded once.
+func loadCodeServerURL(ctx context.Context, arch, u string) (string, error) {
+	cacheDir := codeServerArchCacheDir(arch)
+	err := os.MkdirAll(cacheDir, 0750)
 	if err != nil {
 		return "", err
 	}
 
-	_, err = os.Stat(cachePath)
-	if err != nil && !os.IsNotExist(err) {
-		return "", xerrors.Errorf("failed to stat %v: %v", cachePath, err)
+	version := urlCodeServerVersion(u)
+	binPath, err := verifiedCodeServer(cacheDir, version)
+	if err == nil {
+		return binPath, nil
+	}
+
+	start := time.Now()
+	binPath, err = downloadCodeServer(ctx, cacheDir, version, codeserver.Release{
+		Version: version,
+		URL:     u,
+	})
+	if err != nil {
+		return "", err
+	}
+	xlog.Info("loaded code-server from %v in %v", u, time.Since(start))
+	return binPath, nil
+}
+
+// The code-server cache holds a directory per architecture, each holding
+// a directory per version containing the binary and its checksum. The latest
+// file names the newest version.
+const (
+	codeServerLatestFile = "latest"
+	codeServerBin        = "code-server"
+	codeServerSumSuffix  = ".sha256"
+	// codeServerTmpPrefix prefixes in progress downloads.
+	// All temporary files in the cache start with a dot.
+	codeServerTmpPrefix = ".download-"
+)
+
+// loadCodeServer produces a path containing the code-server binary for
+// linux on arch. It will attempt to cache the binary.
+func loadCodeServer(ctx context.Context, arch string) (string, error) {
+	start := time.Now()
+	cacheDir := codeServerArchCacheDir(arch)
+
+	err := os.MkdirAll(cacheDir, 0750)
+	if err != nil {
+		return "", err
 	}
 
-	cachedBinExists := err == nil
+	latestPath := filepath.Join(cacheDir, codeServerLatestFile)
+	var latest string
+	info, err := os.Stat(latestPath)
+	if err == nil {
+		b, err := ioutil.ReadFile(latestPath)
+		if err != nil {
+			return "", xerrors.Errorf("failed to read %v: %w", latestPath, err)
+		}
+		latest = string(b)
+
+		// Only check for a new code-server if it's over an hour old.
+		if info.ModTime().Add(time.Hour).After(time.Now()) {
+			binPath, err := verifiedCodeServer(cacheDir, latest)
+			if err == nil {
+				return binPath, nil
+			}
+			xlog.Info("cached code-server %v is invalid, downloading it again: %v", latest, err)
+		}
+	}
 
-	downloadURL, err := codeserver.DownloadURL(ctx)
+	rel, err := codeserver.LatestRelease(ctx, arch)
 	if err != nil {
+		// Keep working offline with the last version.
+		binPath, verr := verifiedCodeServer(cacheDir, latest)
+		if latest != "" && verr == nil {
+			xlog.Error("%v, using cached code-server %v", err, latest)
+			return binPath, nil
+		}
 		return "", err
 	}
+	// The version comes from the network, so make sure it can't escape the cache.
+	version := filepath.Base(rel.Version)
 
-	lastDownloadURL, err := ioutil.ReadFile(downloadURLPath)
+	binPath, err := verifiedCodeServer(cacheDir, version)
 	if err != nil {
-		if !os.IsNotExist(err) {
-			return "", xerrors.Errorf("failed to get download URL: %w", err)
+		binPath, err = downloadCodeServer(ctx, cacheDir, version, rel)
+		if err != nil {
+			return "", err
 		}
+		xlog.Info("loaded code-server %v for %v in %v", version, arch, time.Since(start))
+	}
+
+	err = writeFileAtomic(latestPath, []byte(version), 0640)
+	if err != nil {
+		return "", err
+	}
+
+	err = gcCodeServerCache(cacheDir, version)
+	if err != nil {
+		xlog.Error("failed to clean code-server cache: %v", err)
+	}
+	return binPath, nil
+}
+
+// cachedCodeServerPath returns the path of the newest cached code-server
+// binary for arch without checking for a new version, or the path it's
+// downloaded to if there is none yet.
+func cachedCodeServerPath(arch string) string {
+	cacheDir := codeServerArchCacheDir(arch)
+	latest, err := ioutil.ReadFile(filepath.Join(cacheDir, codeServerLatestFile))
+	if err != nil {
+		latest = []byte("<latest>")
+	}
+	return filepath.Join(cacheDir, string(latest), codeServerBin)
+}
 
-		lastDownloadURL = []byte("")
+// verifiedCodeServer returns the path of the cached code-server binary of
+// version, after checking that it matches the checksum recorded when it
+// was downloaded.
+func verifiedCodeServer(cacheDir, version string) (string, error) {
+	if version == "" {
+		return "", xerrors.New("no version")
 	}
+	binPath := filepath.Join(cacheDir, version, codeServerBin)
 
-	// The binary is already up to date.
-	if string(lastDownloadURL) == downloadURL && cachedBinExists {
-		return cachePath, nil
+	want, err := ioutil.ReadFile(binPath + codeServerSumSuffix)
+	if err != nil {
+		return "", err
+	}
+
+	fi, err := os.Open(binPath)
+	if err != nil {
+		return "", err
 	}
+	defer fi.Close()
 
-	// We can't just overwrite the binary, as that would cause a `text file busy` error if code-server is running.
-	// We write to a temporary path first, and then atomically swap in this new file.
-	tmpCachePath := cachePath + strconv.FormatInt(time.Now().UnixNano(), 10)
-	defer os.Remove(tmpCachePath)
+	h := sha256.New()
+	_, err = io.Copy(h, fi)
+	if err != nil {
+		return "", xerrors.Errorf("failed to read %v: %w", binPath, err)
+	}
+	got := hex.EncodeToString(h.Sum(nil))
+	if got != string(want) {
+		return "", xerrors.Errorf("%v has checksum %v, expected %v", binPath, got, want)
+	}
+	return binPath, nil
+}
 
-	// Update the binary.
-	cachedBinFi, err := os.OpenFile(tmpCachePath, os.O_CREATE|os.O_RDWR, 0750)
+// downloadCodeServer downloads rel into the cache as version.
+// The binary is downloaded to a temporary file and renamed into place, so
+// an interrupted download never leaves a partial binary behind.
+func downloadCodeServer(ctx context.Context, cacheDir, version string, rel codeserver.Release) (string, error) {
+	tmp, err := ioutil.TempFile(cacheDir, codeServerTmpPrefix)
 	if err != nil {
 		return "", err
 	}
-	defer cachedBinFi.Close()
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
 
-	tarFi, err := http.Get(downloadURL)
+	req, err := http.NewRequest(http.MethodGet, rel.URL, nil)
 	if err != nil {
-		return "", xerrors.Errorf("failed to get %v: %w", downloadURL, err)
+		return "", err
+	}
+	// The default transport honors HTTPS_PROXY and NO_PROXY.
+	if proxy, err := http.ProxyFromEnvironment(req); err == nil && proxy != nil {
+		xlog.Debug("downloading code-server through proxy %v", proxy.Host)
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", xerrors.Errorf("failed to get %v: %w", rel.URL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", xerrors.Errorf("failed to get %v: %v", rel.URL, resp.Status)
 	}
-	defer tarFi.Body.Close()
 
-	binRd, err := codeserver.Extract(ctx, tarFi.Body)
+	tarRd := &countingReader{r: resp.Body}
+	binRd, err := codeserver.Extract(ctx, tarRd)
 	if err != nil {
-		return "", xerrors.Errorf("failed to untar %v: %w", downloadURL, err)
+		return "", xerrors.Errorf("failed to untar %v: %w", rel.URL, err)
 	}
 
-	_, err = io.Copy(cachedBinFi, binRd)
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(tmp, h), binRd)
 	if err != nil {
-		return "", xerrors.Errorf("failed to copy binary into %v: %w", tmpCachePath, err)
+		return "", xerrors.Errorf("failed to copy binary into %v: %w", tmp.Name(), err)
 	}
 
-	err = cachedBinFi.Close()
+	// Drain the rest of the tarball so we can check it arrived whole.
+	_, err = io.Copy(ioutil.Discard, tarRd)
 	if err != nil {
-		return "", xerrors.Errorf("failed to close %v: %v", cachedBinFi.Name(), err)
+		return "", xerrors.Errorf("failed to read %v: %w", rel.URL, err)
+	}
+	if rel.Size > 0 && tarRd.n != rel.Size {
+		return "", xerrors.Errorf("downloaded %v bytes of %v, expected %v", tarRd.n, rel.URL, rel.Size)
 	}
 
-	// TODO: make this actually atomic.
-	_ = os.Remove(cachePath)
-	err = os.Rename(tmpCachePath, cachePath)
+	err = tmp.Sync()
 	if err != nil {
-		return "", xerrors.Errorf("failed to rename %v to %v: %v", tmpCachePath, cachePath, err)
+		return "", err
+	}
+	err = tmp.Chmod(0750)
+	if err != nil {
+		return "", err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return "", xerrors.Errorf("failed to close %v: %w", tmp.Name(), err)
 	}
 
-	err = ioutil.WriteFile(downloadURLPath, []byte(downloadURL), 0640)
+	versionDir := filepath.Join(cacheDir, version)
+	err = os.MkdirAll(versionDir, 0750)
+	if err != nil {
+		return "", err
+	}
+
+	// The binary is renamed into place before the checksum is written, so the
+	// version only verifies once both are there.
+	// We can't just overwrite the binary, as that would cause a `text file busy` error
+	// if code-server is running, but renaming over it is fine.
+	binPath := filepath.Join(versionDir, codeServerBin)
+	err = os.Rename(tmp.Name(), binPath)
+	if err != nil {
+		return "", xerrors.Errorf("failed to rename %v to %v: %w", tmp.Name(), binPath, err)
+	}
+	err = writeFileAtomic(binPath+codeServerSumSuffix, []byte(hex.EncodeToString(h.Sum(nil))), 0640)
 	if err != nil {
 		return "", err
 	}
+	return binPath, nil
+}
 
-	flog.Info("loaded code-server in %v", time.Since(start))
+// gcCodeServerCache removes everything from the cache but the current version,
+// the binaries mounted into existing environments and those downloaded from a
+// code_server_url.
+func gcCodeServerCache(cacheDir, current string) error {
+	cnts, err := listContainers()
+	if err != nil {
+		return xerrors.Errorf("failed to list containers: %w", err)
+	}
+	var used []string
+	for _, cnt := range cnts {
+		for _, m := range cnt.Mounts {
+			if m.Destination == "/usr/bin/code-server" {
+				used = append(used, m.Source)
+			}
+		}
+	}
 
-	return cachePath, nil
+	for _, p := range unusedCodeServers(cacheDir, current, used) {
+		xlog.Info("removing unused code-server %v", p)
+		err = os.RemoveAll(p)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unusedCodeServers returns the entries of cacheDir that aren't current, or
+// used by an environment. Temporary files are left alone unless they're stale,
+// as another sail may be writing them. Versions of a code_server_url are kept,
+// as projects that aren't running, or whose container another sail is about to
+// create, still use them.
+func unusedCodeServers(cacheDir, current string, used []string) []string {
+	fis, err := ioutil.ReadDir(cacheDir)
+	if err != nil {
+		return nil
+	}
+
+	var unused []string
+outer:
+	for _, fi := range fis {
+		name := fi.Name()
+		p := filepath.Join(cacheDir, name)
+		switch {
+		case name == current || name == codeServerLatestFile || strings.HasPrefix(name, codeServerURLPrefix):
+			continue
+		case strings.HasPrefix(name, "."):
+			if fi.ModTime().Add(time.Hour).After(time.Now()) {
+				continue
+			}
+		}
+		for _, u := range used {
+			if u == p || strings.HasPrefix(u, p+string(filepath.Separator)) {
+				continue outer
+			}
+		}
+		unused = append(unused, p)
+	}
+	return unused
+}
+
+// writeFileAtomic writes b to path through a temporary file, so readers
+// never see a partial file.
+func writeFileAtomic(path string, b []byte, perm os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+"-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	_, err = tmp.Write(b)
+	if err != nil {
+		return xerrors.Errorf("failed to write %v: %w", tmp.Name(), err)
+	}
+	err = tmp.Chmod(perm)
+	if err != nil {
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tmp.Name(), path)
+	if err != nil {
+		return xerrors.Errorf("failed to rename %v to %v: %w", tmp.Name(), path, err)
+	}
+	return nil
+}
+
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// publishesCodeServer reports whether the container cntName publishes
+// code-server's port, rather than sharing the host's network.
+func publishesCodeServer(cntName string) (bool, error) {
+	cli := dockerClient()
+	defer cli.Close()
+
+	cnt, err := inspectContainer(context.Background(), cli, cntName)
+	if err != nil {
+		return false, xerrors.Errorf("failed to inspect %v: %w", cntName, err)
+	}
+	_, ok := cnt.HostConfig.PortBindings["8443/tcp"]
+	return ok, nil
 }
 
 // codeServerPort gets the port of the running code-server binary.
@@ -141,15 +503,16 @@ func codeServerPort(cntName string) (string, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
 	defer cancel()
 
-	var (
-		port string
-		err  error
-	)
+	published, err := publishesCodeServer(cntName)
+	if err != nil {
+		return "", err
+	}
 
+	var port string
 	for ctx.Err() == nil {
-		if runtime.GOOS == "darwin" {
-			// macOS uses port forwarding instead of host networking so netstat stuff below will not work
-			// as it will find the port inside the container, which we already know is 8443.
+		if published {
+			// Docker Desktop and rootless daemons use port forwarding instead of host networking so netstat stuff below
+			// will not work as it will find the port inside the container, which we already know is 8443.
 			cmd := exec.CommandContext(ctx, "docker", "port", cntName, "8443")
 			var out []byte
 			out, err = cmd.CombinedOutput()
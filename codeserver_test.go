@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_verifiedCodeServer(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "sail-code-server")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	binPath := filepath.Join(dir, "1.0", codeServerBin)
+	require.NoError(t, os.MkdirAll(filepath.Dir(binPath), 0750))
+	require.NoError(t, ioutil.WriteFile(binPath, []byte("code-server"), 0750))
+
+	// Missing checksum, as after an interrupted download.
+	_, err = verifiedCodeServer(dir, "1.0")
+	require.Error(t, err)
+
+	// sha256 of "code-server".
+	const sum = "d8622ea1c8959944340cc1c751456cb1cb6eb36487b024cc29970a2ffca6f5a7"
+	require.NoError(t, ioutil.WriteFile(binPath+codeServerSumSuffix, []byte(sum), 0640))
+	got, err := verifiedCodeServer(dir, "1.0")
+	require.NoError(t, err)
+	assert.Equal(t, binPath, got)
+
+	// Corrupted binary.
+	require.NoError(t, ioutil.WriteFile(binPath, []byte("code-serv"), 0750))
+	_, err = verifiedCodeServer(dir, "1.0")
+	require.Error(t, err)
+}
+
+func Test_unusedCodeServers(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "sail-code-server")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	for _, d := range []string{"1.0", "1.1", "1.2", urlCodeServerVersion("https://example.com/code-server.tar.gz")} {
+		require.NoError(t, os.Mkdir(filepath.Join(dir, d), 0750))
+	}
+	for _, f := range []string{codeServerLatestFile, codeServerTmpPrefix + "fresh", codeServerTmpPrefix + "stale", "code-server"} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, f), nil, 0640))
+	}
+	old := time.Now().Add(-time.Hour * 2)
+	require.NoError(t, os.Chtimes(filepath.Join(dir, codeServerTmpPrefix+"stale"), old, old))
+
+	unused := unusedCodeServers(dir, "1.2", []string{
+		filepath.Join(dir, "1.0", codeServerBin),
+	})
+	assert.Equal(t, []string{
+		filepath.Join(dir, codeServerTmpPrefix+"stale"),
+		filepath.Join(dir, "1.1"),
+		filepath.Join(dir, "code-server"),
+	}, unused)
+}
+
+func Test_codeServerSource(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "sail-code-server")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	binPath := filepath.Join(dir, "code-server")
+	src := codeServerSource{path: binPath}
+	_, err = src.load(context.Background(), "amd64")
+	require.Error(t, err)
+
+	require.NoError(t, ioutil.WriteFile(binPath, []byte("code-server"), 0640))
+	_, err = src.load(context.Background(), "amd64")
+	require.Error(t, err, "not executable")
+
+	require.NoError(t, os.Chmod(binPath, 0750))
+	got, err := src.load(context.Background(), "amd64")
+	require.NoError(t, err)
+	assert.Equal(t, binPath, got)
+	assert.Equal(t, binPath, src.cachedPath("amd64"))
+
+	// Mirrors are cached by URL, per architecture.
+	src = codeServerSource{url: "https://mirror/code-server-linux-{arch}.tar.gz"}
+	assert.Equal(t, "https://mirror/code-server-linux-arm64.tar.gz", src.archURL("arm64"))
+	amd64Path := src.cachedPath("amd64")
+	arm64Path := src.cachedPath("arm64")
+	assert.NotEqual(t, filepath.Base(filepath.Dir(amd64Path)), filepath.Base(filepath.Dir(arm64Path)))
+	assert.Equal(t, filepath.Join(codeServerArchCacheDir("arm64"), urlCodeServerVersion(src.archURL("arm64")), codeServerBin), arm64Path)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"golang.org/x/xerrors"
+
+	"go.coder.com/cli"
+	"go.coder.com/sail/internal/xlog"
+)
+
+type completioncmd struct{}
+
+func (c *completioncmd) Spec() cli.CommandSpec {
+	return cli.CommandSpec{
+		Name:  "completion",
+		Usage: "bash|zsh|fish",
+		Desc: `Prints the shell completion script for bash, zsh or fish.
+Subcommands, flags, hats and existing environments are completed.
+
+Load it in your shell's rc file, e.g. for bash:
+	source <(sail completion bash)`,
+	}
+}
+
+func (c *completioncmd) RegisterFlags(fl *flag.FlagSet) {}
+
+func (c *completioncmd) Run(fl *flag.FlagSet) {
+	script, err := completionScript(fl.Arg(0))
+	if err != nil {
+		xlog.Error("%v", err)
+		fl.Usage()
+		os.Exit(1)
+	}
+	fmt.Print(script)
+}
+
+// completionScript returns the completion script for shell.
+// The scripts call sail with the COMP_LINE environment variable set, which
+// makes it print the completions instead of running a command.
+func completionScript(shell string) (string, error) {
+	switch shell {
+	case "bash":
+		return "complete -C sail sail\n", nil
+	case "zsh":
+		return "autoload -U +X bashcompinit && bashcompinit\ncomplete -o nospace -C sail sail\n", nil
+	case "fish":
+		return `function __complete_sail
+    set -lx COMP_LINE (commandline -cp)
+    test -z (commandline -ct)
+    and set COMP_LINE "$COMP_LINE "
+    sail
+end
+complete -f -c sail -a "(__complete_sail)"
+`, nil
+	default:
+		return "", xerrors.Errorf("unsupported shell %q, must be bash, zsh or fish", shell)
+	}
+}
@@ -5,10 +5,12 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/BurntSushi/toml"
+	"golang.org/x/xerrors"
 
-	"go.coder.com/flog"
+	"go.coder.com/sail/internal/xlog"
 )
 
 func resolvePath(homedir string, path string) string {
@@ -38,6 +40,139 @@ type config struct {
 	DefaultSchema       string `toml:"default_schema"`
 	DefaultHost         string `toml:"default_host"`
 	DefaultOrganization string `toml:"default_organization"`
+
+	User       string   `toml:"user"`
+	GroupAdd   []string `toml:"group_add"`
+	UsernsMode string   `toml:"userns_mode"`
+	Platform   string   `toml:"platform"`
+
+	DisableBootstrap bool `toml:"disable_bootstrap"`
+
+	Trusted []string `toml:"trusted"`
+
+	Egress      map[string]egressPolicy `toml:"egress"`
+	EgressImage string                  `toml:"egress_image"`
+
+	Media map[string][]string `toml:"media"`
+
+	Timezone string `toml:"timezone"`
+	Locale   string `toml:"locale"`
+
+	DisableGitIdentity bool `toml:"disable_git_identity"`
+	GitCredentials     bool `toml:"git_credentials"`
+	CommitSigning      bool `toml:"commit_signing"`
+
+	GitAuth map[string]gitAuthConfig `toml:"git_auth"`
+
+	GUI      string `toml:"gui"`
+	GUIImage string `toml:"gui_image"`
+
+	Backend  string            `toml:"backend"`
+	Backends map[string]string `toml:"backends"`
+
+	CreateTimeout duration `toml:"create_timeout"`
+	StartTimeout  duration `toml:"start_timeout"`
+	PullTimeout   duration `toml:"pull_timeout"`
+	BuildTimeout  duration `toml:"build_timeout"`
+
+	Browser          string `toml:"browser"`
+	IsolatedProfiles bool   `toml:"isolated_profiles"`
+
+	IdleTimeout  duration `toml:"idle_timeout"`
+	EphemeralTTL duration `toml:"ephemeral_ttl"`
+
+	UpdateChannel string `toml:"update_channel"`
+
+	CodeServerPath string `toml:"code_server_path"`
+	CodeServerURL  string `toml:"code_server_url"`
+
+	WindowsCodeServerPath string `toml:"windows_code_server_path"`
+
+	ForwardProxy bool `toml:"forward_proxy"`
+
+	Warmup bool `toml:"warmup"`
+
+	BuildArgs        map[string]string            `toml:"build_args"`
+	ProjectBuildArgs map[string]map[string]string `toml:"project_build_args"`
+	HatArgs          map[string]string            `toml:"hat_args"`
+
+	Prebuilds        []string `toml:"prebuilds"`
+	PrebuildSchedule string   `toml:"prebuild_schedule"`
+
+	SyncURL string `toml:"sync_url"`
+
+	DockerContext  string            `toml:"docker_context"`
+	DockerContexts map[string]string `toml:"docker_contexts"`
+
+	Groups map[string][]string `toml:"groups"`
+
+	SharedVolumes map[string]string `toml:"shared_volumes"`
+}
+
+// duration is a time.Duration that can be decoded from a TOML string
+// such as "30s" or "5m".
+type duration time.Duration
+
+func (d *duration) UnmarshalText(text []byte) error {
+	dur, err := time.ParseDuration(string(text))
+	if err != nil {
+		return xerrors.Errorf("invalid duration %q: %w", text, err)
+	}
+	*d = duration(dur)
+	return nil
+}
+
+// Default timeouts for container operations.
+const (
+	defaultCreateTimeout = time.Second * 30
+	defaultStartTimeout  = time.Second * 30
+	defaultPullTimeout   = time.Minute * 10
+	defaultBuildTimeout  = time.Minute * 30
+
+	// pullTimeoutScale scales the default create and start timeouts when
+	// an image was just pulled, as the daemon is likely still busy with
+	// the new layers.
+	pullTimeoutScale = 3
+)
+
+// timeouts holds the timeouts for container operations.
+type timeouts struct {
+	create time.Duration
+	start  time.Duration
+	pull   time.Duration
+	build  time.Duration
+}
+
+// timeouts returns the configured timeouts, falling back to the defaults
+// for any that aren't set. If pulled is true, the default create and start
+// timeouts are scaled up.
+func (c config) timeouts(pulled bool) timeouts {
+	scale := time.Duration(1)
+	if pulled {
+		scale = pullTimeoutScale
+	}
+
+	orDefault := func(d duration, def time.Duration) time.Duration {
+		if d <= 0 {
+			return def
+		}
+		return time.Duration(d)
+	}
+
+	return timeouts{
+		create: orDefault(c.CreateTimeout, defaultCreateTimeout*scale),
+		start:  orDefault(c.StartTimeout, defaultStartTimeout*scale),
+		pull:   orDefault(c.PullTimeout, defaultPullTimeout),
+		build:  orDefault(c.BuildTimeout, defaultBuildTimeout),
+	}
+}
+
+// prebuildSchedule returns the schedule of sail prebuild --watch.
+func (c config) prebuildSchedule() string {
+	if c.PrebuildSchedule == "" {
+		return defaultPrebuildSchedule
+	}
+	return c.PrebuildSchedule
 }
 
 // DefaultConfig is the default configuration file string.
@@ -61,6 +196,233 @@ default_host = "github.com"
 # default_oranization lets you configure which username to use on default_host
 # when cloning a repo.
 # default_organization = ""
+
+# user overrides the user name or uid[:gid] the container runs as.
+# By default the image's guest_user label or user is used.
+# user = "1000:1000"
+
+# group_add lists supplementary groups for the container user. Groups
+# prefixed with "host:" are resolved to their gid on the host, which is
+# required for accessing host devices and sockets.
+# group_add = ["host:docker", "video"]
+
+# userns_mode sets the user namespace of the container, e.g. "host" to opt out of
+# the daemon's user namespace remapping.
+# userns_mode = ""
+
+# platform is the platform images are pulled and built for, e.g. "linux/amd64"
+# to run x86 environments under emulation on Apple Silicon. By default, it's the
+# daemon's platform.
+# platform = ""
+
+# disable_bootstrap stops sail from provisioning images that run as root
+# without a guest_user label, such as ubuntu. By default, sail builds an image
+# on top of them with git, curl and sudo, and a user named "user".
+# disable_bootstrap = false
+
+# backend runs the containers of environments, either "docker", "lxd", "incus"
+# or "ignite". With the others than docker, images are still built with Docker
+# and imported, and environments are reached without sail's proxy. lxd and incus
+# run them as system containers, ignite in Firecracker microVMs, which isolate
+# untrusted projects at the cost of startup time. This is experimental, see the
+# docs on the Docker integration.
+# backend = "docker"
+
+# trusted lists the projects that are trusted without asking, by glob patterns
+# of their names. Projects opened for the first time are otherwise only trusted if
+# you say so, and sandboxed if you don't, or when sail can't ask.
+# trusted = ["cdr/*", "myname/*"]
+
+# timezone is the time zone of new containers, like "UTC" or "Europe/Berlin".
+# By default it's the host's, set as TZ, with the host's /etc/localtime mounted
+# on Linux. "none" leaves it to the image.
+# timezone = ""
+
+# locale is the LANG of new containers, like "C.UTF-8". By default the host's
+# LANG, LANGUAGE and LC_* are set. "none" leaves it to the image, which must
+# have the locale installed.
+# locale = ""
+
+# disable_git_identity doesn't set your git user.name and user.email in new
+# containers. By default they're set from the host's config, as it applies to
+# the project, unless the image's git config sets them.
+# disable_git_identity = false
+
+# git_credentials lets git in new containers use your git credentials for the
+# host of the project's remote, which git on the host gets from its credential
+# helpers when asked for them through sail's proxy. Sandboxed containers never
+# get them. Linux only.
+# git_credentials = false
+
+# commit_signing lets git in new containers sign commits with your keys, as your
+# git config signs them. OpenPGP keys are used through your gpg-agent, which is
+# forwarded from Linux hosts, and SSH keys through your ssh-agent. Sandboxed
+# containers never get them. See sail doctor to check the setup.
+# commit_signing = false
+
+# gui picks how GUI applications of environments are shown. "auto" forwards the
+# host's X11 display on Linux, if DISPLAY is set. "x11" and "wayland" forward
+# the host's X11 or Wayland display, and fail if there's none. "vnc" runs a
+# virtual display in a sidecar container, shown in the browser with noVNC, which
+# also works on macOS, with remote daemons and for sandboxed projects. "off"
+# doesn't show them.
+# gui = "auto"
+
+# gui_image is the image of the sidecars running the virtual displays of gui
+# "vnc". It must have Xvfb, xauth, x11vnc and websockify with noVNC, or apk.
+# gui_image = "alpine:3"
+
+# egress_image is the image of the sidecars restricting the outbound network of
+# environments with an egress policy. It must have iptables or apk.
+# egress_image = "alpine:3"
+
+# timeouts for container operations, e.g. "30s" or "5m".
+# The create and start timeouts are scaled up by default when sail had to
+# pull the image first.
+# create_timeout = "30s"
+# start_timeout = "30s"
+# pull_timeout = "10m"
+# build_timeout = "30m"
+
+# browser is the command used to open projects. {url} is replaced with the
+# project's URL, or the URL is appended if it's absent. Set it to "default" to
+# use the system's default browser. By default, Chrome or Chromium is opened in
+# app mode if installed.
+# browser = "firefox --new-window"
+# browser = "chromium --profile-directory=Work --app={url}"
+
+# isolated_profiles opens each project in its own Chrome profile as an app
+# window named after the project, so its state and windows are kept apart
+# from other projects. It's ignored if browser is set.
+# isolated_profiles = false
+
+# idle_timeout stops environments once they haven't been open in a browser
+# or had a shell for this long, e.g. "2h". They're started again when they're
+# next opened. By default, environments are never stopped.
+# idle_timeout = "2h"
+
+# ephemeral_ttl is how long environments created with sail review or sail run
+# --ephemeral are kept once they're stopped. sail prune --ephemeral, and sail
+# daemon every hour, remove them after it, or once the pull request they review
+# is merged or closed. Running environments are kept.
+# ephemeral_ttl = "72h"
+
+# update_channel is the release channel sail selfupdate updates from, either
+# "stable" or "edge". Edge includes pre-releases, which pick up new code-server
+# releases sooner.
+# update_channel = "stable"
+
+# code_server_path is a code-server binary on the host to use instead of
+# downloading one, e.g. a development build. It must be built for linux on the
+# architecture of your images.
+# code_server_path = "~/src/code-server/release/code-server"
+
+# code_server_url is a code-server release tarball to download instead of the
+# latest release from GitHub, e.g. from an internal mirror. {arch} is replaced
+# with the architecture of the image, such as amd64 or arm64. The binary is
+# downloaded once, and used until the URL changes.
+# code_server_url = "https://mirror.example.com/code-server/code-server-3.4.1-linux-{arch}.tar.gz"
+
+# windows_code_server_path is a directory with a Windows build of code-server,
+# containing bin\code-server.cmd, which is mounted into Windows containers.
+# Without it, their images must have code-server on their PATH.
+# windows_code_server_path = "/mnt/c/tools/code-server"
+
+# forward_proxy sets the proxy environment variables of the host, such as
+# HTTPS_PROXY and NO_PROXY, in new containers. They're always passed to image
+# builds.
+# forward_proxy = false
+
+# warmup installs the dependencies of new containers before they're ready, with
+# go mod download, npm ci, pip install or bundle install depending on the
+# project's files, so the language server doesn't index while you edit. Images
+# can set the command with the com.coder.sail.warmup_cmd label.
+# warmup = false
+
+# prebuilds are the projects sail prebuild rebuilds against the latest base
+# images, so their next sail run doesn't wait on builds or pulls.
+# prebuilds = ["cdr/sail", "cdr/api"]
+
+# prebuild_schedule is when sail prebuild --watch rebuilds the prebuilds, as a
+# cron expression of minute, hour, day of month, month and day of week. By
+# default, they're rebuilt at 3am every day.
+# prebuild_schedule = "0 3 * * *"
+
+# sync_url is where sail sync push uploads the editor state and list of
+# environments, which sail sync pull restores. It's an s3://<bucket>/<prefix>
+# or gs://<bucket>/<prefix> URL, accessed with the aws CLI or gsutil.
+# sync_url = "s3://my-bucket/sail"
+
+# docker_context is the docker context of the daemon sail uses, see
+# docker context ls. By default, docker's current context is used. The
+# DOCKER_HOST and DOCKER_CONTEXT environment variables take precedence.
+# docker_context = "devbox"
+
+# docker_contexts picks the docker context of individual projects, overriding
+# docker_context. It must be at the end of the config, as it's a table.
+# [docker_contexts]
+# "cdr/sail" = "devbox"
+
+# backends picks the backend of individual projects, overriding backend, e.g.
+# to isolate projects you don't trust in microVMs. It's a table too.
+# [backends]
+# "someone/untrusted" = "ignite"
+
+# egress restricts the outbound network of the projects matching a glob pattern
+# of their names. mode "deny" drops all traffic but that to the allow list of
+# host[:port], where host is a name, address or CIDR. mode "proxy" also allows
+# the host's proxy. It's a table of tables too.
+# [egress."corp/*"]
+# mode = "deny"
+# allow = ["github.com:443", "10.0.0.0/8"]
+
+# git_auth configures the short-lived tokens sail login --git obtains for git
+# hosts with OAuth's device flow, by host. client_id is the OAuth application's,
+# which must allow the device flow. provider is "github" or "gitlab", by default
+# "gitlab" for hosts with gitlab in their names. With git_credentials, git in
+# containers uses the tokens for HTTPS remotes of the hosts instead of your
+# credentials. It's a table of tables too.
+# [git_auth."github.com"]
+# client_id = ""
+# scopes = ["repo"]
+
+# media passes the host's sound devices, /dev/snd, and video devices, such as
+# webcams at /dev/video*, through to the containers of projects, and adds their
+# users to the groups owning them. It's a table too, e.g.
+# [media]
+# "cdr/player" = ["audio", "webcam"]
+
+# groups are named sets of projects, named like in sail ls. sail up <group>
+# starts all of them, and sail ls --group <group> lists them. Their containers
+# reach each other by their hostnames. It's a table too, so it must also
+# be at the end of the config.
+# [groups]
+# backend-team = ["cdr/api", "cdr/db"]
+
+# build_args are passed to the builds of all projects' images and hats, which
+# declare them with ARG. $VAR and ${VAR} in values are expanded from the
+# environment and sail's build arguments. sail also passes SAIL_USER, SAIL_UID and SAIL_GID of the host
+# user, SAIL_PROJECT, and the host's proxy settings. Use sail run --secret for
+# secrets, as build arguments are stored in the image's history.
+# [build_args]
+# GOPROXY = "https://proxy.golang.org"
+
+# project_build_args are the build_args of individual projects, overriding
+# build_args.
+# [project_build_args."cdr/sail"]
+# GO_VERSION = "1.13"
+
+# hat_args are the values of the parameters of default_hat, declared in the
+# hat.toml next to its Dockerfile. sail run --hat-arg overrides them.
+# [hat_args]
+# go_version = "1.13"
+
+# shared_volumes are Docker volumes shared by every environment whose image
+# lists them in its shared_volumes label, e.g. a team's build cache on a shared
+# dev server. Keys name the volumes, which are created as sail-shared-<name> and
+# writable by every user, and values are where they're mounted.
+# [shared_volumes]
+# go-build = "~/.cache/go-build"
 `
 
 // metaRoot returns the root path of all metadata stored on the host.
@@ -73,28 +435,49 @@ func metaRoot() string {
 	return filepath.Join(homeDir, ".config", "sail")
 }
 
+// definedConfigKeys returns the keys set in the config at path, so defaults
+// can be told apart from values that were set explicitly.
+func definedConfigKeys(path string) (map[string]bool, error) {
+	var c config
+	md, err := toml.DecodeFile(path, &c)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to parse config @ %v: %w", path, err)
+	}
+
+	keys := make(map[string]bool)
+	for _, k := range md.Keys() {
+		keys[k.String()] = true
+	}
+	return keys, nil
+}
+
 func mustReadConfig(path string) config {
 	var c config
 
-	_, err := toml.DecodeFile(path, &c)
+	md, err := toml.DecodeFile(path, &c)
 	if err != nil {
 		if os.IsNotExist(err) {
-			flog.Info("No configuration exists at %v, writing default.", path)
+			xlog.Info("No configuration exists at %v, writing default.", path)
 
 			baseDir := filepath.Dir(path)
 			err = os.MkdirAll(baseDir, 0755)
 			if err != nil {
-				flog.Fatal("failed to mkdirall %v: %v", baseDir, err)
+				xlog.Fatal("failed to mkdirall %v: %v", baseDir, err)
 			}
 
 			err = ioutil.WriteFile(path, []byte(DefaultConfig), 0644)
 			if err != nil {
-				flog.Fatal("failed to write default config @ %v\n%v", path, err)
+				xlog.Fatal("failed to write default config @ %v\n%v", path, err)
 			}
 
 			return mustReadConfig(path)
 		}
-		flog.Fatal("failed to parse config @ %v\n%v", path, err)
+		xlog.Fatal("failed to parse config @ %v\n%v", path, err)
+	}
+
+	err = checkConfig(path, c, md)
+	if err != nil {
+		xlog.Fatal("%v", err)
 	}
 	return c
 }
@@ -3,7 +3,9 @@ package main
 import (
 	"os"
 	"testing"
+	"time"
 
+	"github.com/BurntSushi/toml"
 	"github.com/stretchr/testify/require"
 )
 
@@ -33,3 +35,26 @@ func Test_resolvePath(t *testing.T) {
 		})
 	}
 }
+
+func Test_configTimeouts(t *testing.T) {
+	var c config
+	_, err := toml.Decode(`
+create_timeout = "1m"
+build_timeout = "2h"
+`, &c)
+	require.NoError(t, err)
+
+	to := c.timeouts(false)
+	require.Equal(t, time.Minute, to.create)
+	require.Equal(t, defaultStartTimeout, to.start)
+	require.Equal(t, defaultPullTimeout, to.pull)
+	require.Equal(t, time.Hour*2, to.build)
+
+	// Only the defaults scale after a pull.
+	to = c.timeouts(true)
+	require.Equal(t, time.Minute, to.create)
+	require.Equal(t, defaultStartTimeout*pullTimeoutScale, to.start)
+
+	_, err = toml.Decode(`start_timeout = "soon"`, &c)
+	require.Error(t, err)
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+	"golang.org/x/xerrors"
+
+	"go.coder.com/cli"
+	"go.coder.com/sail/internal/xlog"
+)
+
+// daemonSocketPath returns the path of the unix socket sail daemon listens on.
+func daemonSocketPath() string {
+	return filepath.Join(metaRoot(), "sail.sock")
+}
+
+type daemoncmd struct {
+	gf *globalFlags
+
+	metricsAddr string
+}
+
+func (c *daemoncmd) Spec() cli.CommandSpec {
+	return cli.CommandSpec{
+		Name: "daemon",
+		Desc: `Serves the local sail API on a unix socket.
+
+The socket is only accessible by the current user. While the daemon runs,
+sail ls uses it instead of scanning Docker on every invocation. Every hour,
+it removes the ephemeral and review environments that expired, like sail prune
+--ephemeral.
+
+With --metrics-addr, the resource usage of environments is also exported
+for Prometheus at /metrics.`,
+	}
+}
+
+func (c *daemoncmd) RegisterFlags(fl *flag.FlagSet) {
+	fl.StringVar(&c.metricsAddr, "metrics-addr", "", "Address to serve Prometheus metrics on, e.g. localhost:9464. Disabled by default.")
+}
+
+func (c *daemoncmd) Run(fl *flag.FlagSet) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c.gf.selectDockerContext("")
+
+	sockPath := daemonSocketPath()
+	l, err := listenDaemon(sockPath)
+	if err != nil {
+		xlog.Fatal("failed to listen: %v", err)
+	}
+	defer os.Remove(sockPath)
+
+	cache := &projectCache{gf: c.gf}
+	go cache.watch(ctx)
+	go c.removeExpired(ctx)
+
+	if c.metricsAddr != "" {
+		go func() {
+			m := http.NewServeMux()
+			m.HandleFunc("/metrics", metricsHandler(c.gf))
+			xlog.Info("serving metrics on %v", c.metricsAddr)
+			err := http.ListenAndServe(c.metricsAddr, m)
+			if err != nil {
+				xlog.Fatal("failed to serve metrics: %v", err)
+			}
+		}()
+	}
+
+	api := &localAPI{listProjects: cache.list, config: c.gf.config, gf: c.gf}
+	xlog.Info("listening on %v", sockPath)
+	err = http.Serve(l, api.handler())
+	if err != nil {
+		xlog.Fatal("failed to serve: %v", err)
+	}
+}
+
+// removeExpired removes the ephemeral environments that expired, every
+// ephemeralGCInterval until ctx is done.
+func (c *daemoncmd) removeExpired(ctx context.Context) {
+	t := time.NewTicker(ephemeralGCInterval)
+	defer t.Stop()
+	for {
+		// The config may change while the daemon runs.
+		err := removeExpired(ctx, c.gf.config(), false)
+		if err != nil {
+			xlog.Error("%v", err)
+		}
+		// Removing containers runs their hooks with the docker CLI, so the
+		// other contexts are pruned by sail processes of their own.
+		for _, name := range c.gf.dockerContexts()[1:] {
+			out, err := c.gf.contextSailCmd(name, "prune", "--ephemeral").CombinedOutput()
+			if err != nil {
+				xlog.Error("failed to remove the expired environments of docker context %v: %s: %v", name, bytes.TrimSpace(out), err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// listenDaemon listens on the unix socket at sockPath, replacing it if it's
+// left over from a daemon that's no longer running.
+func listenDaemon(sockPath string) (net.Listener, error) {
+	err := os.MkdirAll(filepath.Dir(sockPath), 0750)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.Dial("unix", sockPath)
+	if err == nil {
+		conn.Close()
+		return nil, xerrors.Errorf("sail daemon is already running on %v", sockPath)
+	}
+	err = os.Remove(sockPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, xerrors.Errorf("failed to remove stale socket: %w", err)
+	}
+
+	l, err := net.Listen("unix", sockPath)
+	if err != nil {
+		return nil, err
+	}
+	err = os.Chmod(sockPath, 0600)
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// projectCacheTTL is how long the project cache is used without any
+// Docker events, so statuses such as uptime don't go stale.
+const projectCacheTTL = time.Minute
+
+// projectCache caches the project list of all docker contexts, invalidating
+// it on Docker events involving sail containers.
+type projectCache struct {
+	gf *globalFlags
+
+	mu        sync.Mutex
+	infos     []projectInfo
+	refreshed time.Time
+}
+
+// list returns the cached projects, scanning Docker if the cache is invalid.
+func (c *projectCache) list() ([]projectInfo, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if time.Since(c.refreshed) < projectCacheTTL {
+		return c.infos, nil
+	}
+
+	infos, err := c.gf.listAllProjects()
+	if err != nil {
+		return nil, err
+	}
+	c.infos = infos
+	c.refreshed = time.Now()
+	return infos, nil
+}
+
+func (c *projectCache) invalidate() {
+	c.mu.Lock()
+	c.refreshed = time.Time{}
+	c.mu.Unlock()
+}
+
+// watch invalidates the cache on container events of the daemons of all
+// docker contexts until ctx is done.
+func (c *projectCache) watch(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, name := range c.gf.dockerContexts() {
+		cli, err := c.gf.dockerContextClient(name)
+		if err != nil {
+			xlog.Error("failed to watch docker context %v: %v", name, err)
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer cli.Close()
+			c.watchDaemon(ctx, cli)
+		}()
+	}
+	wg.Wait()
+}
+
+// watchDaemon invalidates the cache on container events of the daemon of cli
+// until ctx is done.
+func (c *projectCache) watchDaemon(ctx context.Context, cli *client.Client) {
+
+	filter := filters.NewArgs()
+	filter.Add("type", "container")
+	filter.Add("label", sailLabel)
+
+	for ctx.Err() == nil {
+		msgs, errs := cli.Events(ctx, types.EventsOptions{Filters: filter})
+	events:
+		for {
+			select {
+			case <-msgs:
+				c.invalidate()
+			case err := <-errs:
+				if ctx.Err() == nil {
+					xlog.Error("failed to watch docker events: %v", err)
+				}
+				break events
+			}
+		}
+		// Events may have been missed.
+		c.invalidate()
+		time.Sleep(time.Second)
+	}
+}
+
+// daemonClient returns a client for the daemon socket at sockPath.
+func daemonClient(sockPath string) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", sockPath)
+			},
+		},
+		Timeout: time.Second * 10,
+	}
+}
+
+// daemonProjects lists projects through the sail daemon at sockPath.
+// It errors if the daemon isn't running.
+func daemonProjects(sockPath string) ([]projectInfo, error) {
+	resp, err := daemonClient(sockPath).Get("http://sail/api/v2/projects")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&apiErr)
+		return nil, xerrors.Errorf("daemon responded with %v: %v", resp.Status, apiErr.Error)
+	}
+
+	var projects []apiProject
+	err = json.NewDecoder(resp.Body).Decode(&projects)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to decode projects: %w", err)
+	}
+
+	infos := make([]projectInfo, 0, len(projects))
+	for _, p := range projects {
+		infos = append(infos, p.info())
+	}
+	return infos, nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_daemon(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sail-daemon")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	sockPath := filepath.Join(dir, "sail.sock")
+
+	_, err = daemonProjects(sockPath)
+	require.Error(t, err, "no daemon is running")
+
+	// A stale socket file is replaced.
+	require.NoError(t, ioutil.WriteFile(sockPath, nil, 0600))
+
+	l, err := listenDaemon(sockPath)
+	require.NoError(t, err)
+	defer l.Close()
+
+	fi, err := os.Stat(sockPath)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+
+	want := []projectInfo{{
+		cntName:       "cdr_sail",
+		name:          "cdr/sail",
+		url:           "http://127.0.0.1:8080",
+		status:        "Up 5 minutes",
+		running:       true,
+		image:         "codercom/ubuntu-dev",
+		host:          "github.com",
+		dockerContext: "devbox",
+	}}
+	api := &localAPI{listProjects: func() ([]projectInfo, error) {
+		return want, nil
+	}}
+	go http.Serve(l, api.handler())
+
+	got, err := daemonProjects(sockPath)
+	require.NoError(t, err)
+	require.Equal(t, want, got)
+
+	_, err = listenDaemon(sockPath)
+	require.Error(t, err, "the daemon is already running")
+}
@@ -0,0 +1,17 @@
+// +build !windows
+
+package main
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// detach makes cmd outlive sail, by starting it in its own process group so
+// it doesn't get the signals of sail's terminal.
+func detach(cmd *exec.Cmd) {
+	// See https://grokbase.com/t/gg/golang-nuts/147jmc4h0k/go-nuts-starting-detached-child-process#201407185ia7a7ldk3veno3linjktq4dve
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Setpgid: true,
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/xerrors"
+
+	"go.coder.com/sail/internal/devcontainer"
+	"go.coder.com/sail/internal/xexec"
+	"go.coder.com/sail/internal/xlog"
+)
+
+// devcontainerShareLabel names the share labels translated from
+// a devcontainer.json's mounts.
+const devcontainerShareLabel = shareLabelPrefix + "devcontainer_"
+
+// buildDevcontainerImage builds the project's image from its devcontainer.json.
+// It returns false if the project doesn't have one.
+//
+// The devcontainer.json is translated into sail labels on an image built
+// on top of the image it describes, so the rest of sail handles it like
+// any other project image.
+func (p *project) buildDevcontainerImage(imageID string) (string, bool, error) {
+	path := devcontainer.Find(p.localDir())
+	if path == "" {
+		return "", false, nil
+	}
+	xlog.Info("using %v", path)
+
+	conf, err := devcontainer.Load(path)
+	if err != nil {
+		return "", false, err
+	}
+
+	to := p.conf.timeouts(false)
+	ctx, cancel := context.WithTimeout(context.Background(), to.build)
+	defer cancel()
+
+	base := conf.Image
+	if df := conf.DockerfilePath(); df != "" {
+		base = imageID + "-devcontainer"
+		args := []string{p.buildOpts.network(), "-t", base, "-f", df}
+		for k, v := range conf.BuildArgs() {
+			args = append(args, "--build-arg", k+"="+v)
+		}
+		args = append(args, conf.ContextPath())
+
+		err = dockerBuild(ctx, p.buildOpts, args, "")
+		if err != nil {
+			return "", false, err
+		}
+	} else {
+		pullCtx, cancel := context.WithTimeout(ctx, to.pull)
+		_, err = ensureImage(pullCtx, base, p.buildOpts.platform)
+		cancel()
+		if err != nil {
+			return "", false, xerrors.Errorf("failed to ensure image %v: %w", base, err)
+		}
+	}
+
+	baseLabels, err := imageLabels(base)
+	if err != nil {
+		return "", false, err
+	}
+	vars := devcontainer.Vars{
+		LocalWorkspaceFolder:     p.localDir(),
+		ContainerWorkspaceFolder: containerProjectDir(baseLabels, p.repo.BaseName()),
+	}
+	labels, env, err := devcontainerLabels(conf, vars, baseLabels)
+	if err != nil {
+		return "", false, xerrors.Errorf("failed to translate %v: %w", path, err)
+	}
+	labels[baseImageLabel] = imageID
+
+	err = buildLabelImage(ctx, imageID, base, p.buildOpts.platform, labels, env)
+	if err != nil {
+		return "", false, err
+	}
+
+	err = validateImageLabels(imageID)
+	if err != nil {
+		return "", false, xerrors.Errorf("invalid mount in %v: %w", path, err)
+	}
+	return imageID, true, nil
+}
+
+// devcontainerLabels translates conf into the sail labels and environment
+// variables of the project image, expanding vars. baseLabels are the labels of
+// the image conf describes.
+func devcontainerLabels(conf *devcontainer.Config, vars devcontainer.Vars, baseLabels map[string]string) (map[string]string, map[string]string, error) {
+	labels := make(map[string]string)
+
+	// The project is mounted in the project root, under its own name.
+	if conf.WorkspaceFolder != "" {
+		folder := path.Clean(vars.Expand(conf.WorkspaceFolder))
+		if path.Base(folder) == path.Base(vars.ContainerWorkspaceFolder) {
+			labels[projectRootLabel] = path.Dir(folder)
+			vars.ContainerWorkspaceFolder = folder
+		} else {
+			xlog.Warn("ignoring workspaceFolder %v, the project is mounted at %v", folder, vars.ContainerWorkspaceFolder)
+		}
+	}
+	if conf.WorkspaceMount != "" {
+		m, err := devcontainer.ParseMount(conf.WorkspaceMount, vars)
+		if err != nil {
+			return nil, nil, err
+		}
+		if m.Type != "bind" || m.Source != vars.LocalWorkspaceFolder || m.Target != vars.ContainerWorkspaceFolder {
+			xlog.Warn("ignoring workspaceMount, the project is mounted at %v", vars.ContainerWorkspaceFolder)
+		}
+	}
+
+	for i, ms := range conf.Mounts {
+		m, err := devcontainer.ParseMount(ms, vars)
+		if err != nil {
+			return nil, nil, err
+		}
+		if m.Type != "bind" {
+			xlog.Warn("skipping %v mount of %v, only bind mounts are supported", m.Type, m.Target)
+			continue
+		}
+		labels[devcontainerShareLabel+strconv.Itoa(i)] = m.Source + ":" + m.Target
+	}
+
+	if len(conf.ForwardPorts) > 0 {
+		ports := make([]string, 0, len(conf.ForwardPorts))
+		for _, p := range conf.ForwardPorts {
+			ports = append(ports, strconv.Itoa(p))
+		}
+		labels[forwardPortsLabel] = strings.Join(ports, ",")
+	}
+
+	// Extensions and postCreateCommand run once the container is created,
+	// after any on_create_cmd of the base image.
+	var cmds []string
+	if cmd := baseLabels[onCreateCmdLabel]; cmd != "" {
+		cmds = append(cmds, cmd)
+	}
+	for _, ext := range conf.Extensions {
+		cmds = append(cmds, fmt.Sprintf(
+			"/usr/bin/code-server --extensions-dir ~/.vscode/extensions --install-extension %v",
+			xexec.Quote(ext),
+		))
+	}
+	postCreate, err := conf.PostCreate(vars)
+	if err != nil {
+		return nil, nil, err
+	}
+	if postCreate != "" {
+		cmds = append(cmds, postCreate)
+	}
+	if len(cmds) > 0 {
+		// Joined on one line so the label can be written in a Dockerfile.
+		labels[onCreateCmdLabel] = strings.Join(cmds, " && ")
+	}
+
+	env := make(map[string]string, len(conf.ContainerEnv))
+	for k, v := range conf.ContainerEnv {
+		env[k] = vars.Expand(v)
+	}
+	return labels, env, nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
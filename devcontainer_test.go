@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.coder.com/sail/internal/devcontainer"
+)
+
+func Test_devcontainerLabels(t *testing.T) {
+	t.Parallel()
+
+	conf, err := devcontainer.Parse([]byte(`{
+	"image": "golang:1.13",
+	"mounts": [
+		"source=${localWorkspaceFolder}/.cache,target=/home/user/.cache,type=bind",
+		"source=node_modules,target=/src/node_modules,type=volume"
+	],
+	"forwardPorts": [3000, 8080],
+	"extensions": ["ms-vscode.go"],
+	"postCreateCommand": "go mod download",
+	"workspaceFolder": "/workspaces/sail",
+	"workspaceMount": "source=${localWorkspaceFolder},target=/workspaces/sail,type=bind",
+	"containerEnv": {"GOPATH": "${containerWorkspaceFolder}/.go"}
+}`))
+	require.NoError(t, err)
+
+	labels, env, err := devcontainerLabels(conf, devcontainer.Vars{
+		LocalWorkspaceFolder:     "/src",
+		ContainerWorkspaceFolder: "/home/user/sail",
+	}, map[string]string{
+		onCreateCmdLabel: "make setup",
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]string{
+		devcontainerShareLabel + "0": "/src/.cache:/home/user/.cache",
+		forwardPortsLabel:            "3000,8080",
+		projectRootLabel:             "/workspaces",
+		onCreateCmdLabel: "make setup && " +
+			"/usr/bin/code-server --extensions-dir ~/.vscode/extensions --install-extension 'ms-vscode.go' && " +
+			"go mod download",
+	}, labels)
+	assert.Equal(t, map[string]string{"GOPATH": "/workspaces/sail/.go"}, env)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"golang.org/x/xerrors"
+)
+
+// parseDevice parses a device of form host_path[:container_path[:permissions]],
+// as accepted by `docker run --device`.
+func parseDevice(spec string) (container.DeviceMapping, error) {
+	dev := container.DeviceMapping{
+		CgroupPermissions: "rwm",
+	}
+
+	tokens := strings.Split(spec, ":")
+	switch len(tokens) {
+	case 3:
+		dev.CgroupPermissions = tokens[2]
+		fallthrough
+	case 2:
+		dev.PathInContainer = tokens[1]
+		fallthrough
+	case 1:
+		dev.PathOnHost = tokens[0]
+	default:
+		return container.DeviceMapping{}, xerrors.Errorf("invalid device %q", spec)
+	}
+	if dev.PathInContainer == "" {
+		dev.PathInContainer = dev.PathOnHost
+	}
+
+	if !strings.HasPrefix(dev.PathOnHost, "/") || !strings.HasPrefix(dev.PathInContainer, "/") {
+		return container.DeviceMapping{}, xerrors.Errorf("invalid device %q: paths must be absolute", spec)
+	}
+	for _, p := range dev.CgroupPermissions {
+		if !strings.ContainsRune("rwm", p) {
+			return container.DeviceMapping{}, xerrors.Errorf("invalid device %q: permissions must be a combination of r, w and m", spec)
+		}
+	}
+	return dev, nil
+}
+
+// parseDevices parses each device spec. Only the first device mapped to
+// a container path is kept.
+func parseDevices(specs []string) ([]container.DeviceMapping, error) {
+	devs := make([]container.DeviceMapping, 0, len(specs))
+	seen := make(map[string]struct{})
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+
+		dev, err := parseDevice(spec)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := seen[dev.PathInContainer]; ok {
+			continue
+		}
+		seen[dev.PathInContainer] = struct{}{}
+		devs = append(devs, dev)
+	}
+	return devs, nil
+}
+
+// deviceSpec formats dev as accepted by parseDevice.
+func deviceSpec(dev container.DeviceMapping) string {
+	return dev.PathOnHost + ":" + dev.PathInContainer + ":" + dev.CgroupPermissions
+}
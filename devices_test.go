@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseDevice(t *testing.T) {
+	var tests = []struct {
+		spec string
+		exp  container.DeviceMapping
+	}{
+		{"/dev/kvm", container.DeviceMapping{PathOnHost: "/dev/kvm", PathInContainer: "/dev/kvm", CgroupPermissions: "rwm"}},
+		{"/dev/sda:/dev/xvda", container.DeviceMapping{PathOnHost: "/dev/sda", PathInContainer: "/dev/xvda", CgroupPermissions: "rwm"}},
+		{"/dev/ttyUSB0:/dev/ttyUSB0:rw", container.DeviceMapping{PathOnHost: "/dev/ttyUSB0", PathInContainer: "/dev/ttyUSB0", CgroupPermissions: "rw"}},
+	}
+
+	for _, test := range tests {
+		dev, err := parseDevice(test.spec)
+		require.NoError(t, err, test.spec)
+		assert.Equal(t, test.exp, dev, test.spec)
+
+		// Specs round trip.
+		dev, err = parseDevice(deviceSpec(dev))
+		require.NoError(t, err, test.spec)
+		assert.Equal(t, test.exp, dev, test.spec)
+	}
+
+	for _, spec := range []string{"dev/kvm", "/dev/kvm:kvm", "/dev/kvm:/dev/kvm:x", "/a:/b:rw:extra"} {
+		_, err := parseDevice(spec)
+		assert.Error(t, err, spec)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"golang.org/x/xerrors"
+
+	"go.coder.com/cli"
+	"go.coder.com/sail/internal/xexec"
+	"go.coder.com/sail/internal/xlog"
+)
+
+type diffcmd struct {
+	gf *globalFlags
+
+	patch bool
+}
+
+func (c *diffcmd) Spec() cli.CommandSpec {
+	return cli.CommandSpec{
+		Name:  "diff",
+		Usage: "[flags] <repo>",
+		Desc: `Shows the changes of an overlay project that weren't applied to the host.
+
+Projects created with sail run --overlay see the host's project directory
+through an overlay, whose changes stay in ~/.config/sail until they're copied
+back with sail apply. Each changed path is listed with A if it was added, M if
+it was modified and D if it was deleted.`,
+	}
+}
+
+func (c *diffcmd) RegisterFlags(fl *flag.FlagSet) {
+	fl.BoolVar(&c.patch, "p", false, "Show the changes as a patch, with git diff.")
+}
+
+func (c *diffcmd) Run(fl *flag.FlagSet) {
+	proj := c.gf.project(schemaPrefs{}, fl)
+	c.gf.ensureDockerDaemon()
+
+	lower, upper, err := overlayLayers(proj)
+	if err != nil {
+		xlog.Fatal("%v", err)
+	}
+	changes, err := overlayChanges(lower, upper)
+	if err != nil {
+		xlog.Fatal("%v", err)
+	}
+
+	for _, ch := range changes {
+		if !c.patch {
+			fmt.Printf("%v %v\n", ch.kind, ch.path)
+			continue
+		}
+		err = diffChange(lower, upper, ch)
+		if err != nil {
+			xlog.Fatal("%v", err)
+		}
+	}
+}
+
+// overlayLayers returns the host directories of the lower and upper layers
+// of the overlay of proj's container.
+func overlayLayers(proj *project) (lower, upper string, _ error) {
+	labels := existingLabels(proj.cntName())
+	if labels == nil {
+		return "", "", xerrors.Errorf("%v doesn't exist, create it with sail run --overlay", proj.pathName())
+	}
+	if labels[overlayLabel] != "true" {
+		return "", "", xerrors.Errorf("%v isn't an overlay project, see sail run --overlay", proj.pathName())
+	}
+	lower = labels[projectLocalDirLabel]
+	if lower == "" {
+		lower = proj.localDir()
+	}
+	return lower, filepath.Join(overlayDir(proj.cntName()), "upper"), nil
+}
+
+// diffChange prints the patch of ch with git diff.
+func diffChange(lower, upper string, ch overlayChange) error {
+	a, b := filepath.Join(lower, ch.path), filepath.Join(upper, ch.path)
+	switch ch.kind {
+	case overlayAdded:
+		a = os.DevNull
+	case overlayDeleted:
+		b = os.DevNull
+	}
+	cmd := exec.Command("git", "diff", "--no-index", "--", a, b)
+	xexec.Attach(cmd)
+	err := cmd.Run()
+	// git diff exits with 1 if the files differ.
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+		return nil
+	}
+	if err != nil {
+		return xerrors.Errorf("git diff of %v failed: %w", ch.path, err)
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+	"github.com/docker/go-units"
+	"golang.org/x/xerrors"
+
+	"go.coder.com/cli"
+	"go.coder.com/sail/internal/xlog"
+)
+
+type diskcmd struct {
+	gf *globalFlags
+}
+
+func (c *diskcmd) Spec() cli.CommandSpec {
+	return cli.CommandSpec{
+		Name: "disk",
+		Desc: `Shows the disk usage of each sail environment.
+
+image is the size of the environment's image, including layers shared with other images.
+writable is the size of the files changed in the container.
+storage is the size of code-server's state on the host.`,
+	}
+}
+
+func (c *diskcmd) Run(fl *flag.FlagSet) {
+	usages, err := c.gf.diskUsages()
+	if err != nil {
+		xlog.Fatal("%v", err)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 10, ' ', 0)
+	fmt.Fprintf(tw, "name\timage\twritable\tstorage\n")
+	for _, u := range usages {
+		fmt.Fprintf(tw, "%v\t%v\t%v\t%v\n",
+			u.name, units.HumanSize(float64(u.image)), units.HumanSize(float64(u.writable)), units.HumanSize(float64(u.storage)),
+		)
+	}
+	tw.Flush()
+
+	cacheSize, err := dirSize(codeServerCacheDir())
+	if err != nil {
+		xlog.Error("failed to get size of code-server cache: %v", err)
+		return
+	}
+	fmt.Printf("\ncode-server cache: %v\n", units.HumanSize(float64(cacheSize)))
+}
+
+// diskUsage is the disk space used by an environment in bytes.
+type diskUsage struct {
+	name     string
+	image    int64
+	writable int64
+	storage  int64
+}
+
+// diskUsages returns the disk usage of the environments of all docker
+// contexts. Those of contexts other than the selected one have it in their
+// name.
+func (gf *globalFlags) diskUsages() ([]diskUsage, error) {
+	var all []diskUsage
+	for i, name := range gf.dockerContexts() {
+		usages, err := gf.contextDiskUsages(name)
+		if err != nil {
+			if i == 0 {
+				return nil, err
+			}
+			xlog.Warn("failed to get the disk usage of docker context %v: %v", name, err)
+			continue
+		}
+		if i > 0 {
+			for j := range usages {
+				usages[j].name = fmt.Sprintf("%v (docker context %v)", usages[j].name, name)
+			}
+		}
+		all = append(all, usages...)
+	}
+	return all, nil
+}
+
+func (gf *globalFlags) contextDiskUsages(name string) ([]diskUsage, error) {
+	cli, err := gf.dockerContextClient(name)
+	if err != nil {
+		return nil, err
+	}
+	defer cli.Close()
+	return diskUsagesOf(context.Background(), cli)
+}
+
+// diskUsagesOf returns the disk usage of the environments of the daemon of
+// cli.
+func diskUsagesOf(ctx context.Context, cli *client.Client) ([]diskUsage, error) {
+	cnts, err := cli.ContainerList(ctx, types.ContainerListOptions{
+		All:     true,
+		Size:    true,
+		Filters: filters.NewArgs(filters.Arg("label", sailLabel)),
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("failed to list containers: %w", err)
+	}
+
+	usages := make([]diskUsage, 0, len(cnts))
+	for _, cnt := range cnts {
+		dockerName := trimDockerName(cnt)
+		if dockerName == "" {
+			continue
+		}
+
+		u := diskUsage{
+			name:     toSailName(dockerName),
+			writable: cnt.SizeRw,
+		}
+		if name := cnt.Labels[nameLabel]; name != "" {
+			u.name = name
+		}
+
+		img, _, err := cli.ImageInspectWithRaw(ctx, cnt.ImageID)
+		if err != nil {
+			xlog.Error("failed to inspect image of %v: %v", u.name, err)
+		} else {
+			u.image = img.Size
+		}
+
+		u.storage, err = dirSize(filepath.Join(metaRoot(), dockerName))
+		if err != nil {
+			xlog.Error("failed to get storage size of %v: %v", u.name, err)
+		}
+
+		usages = append(usages, u)
+	}
+	return usages, nil
+}
+
+// dirSize returns the total size of the files in dir.
+// It's zero if dir doesn't exist.
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
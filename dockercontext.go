@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/docker/docker/client"
+	"github.com/docker/go-connections/tlsconfig"
+	"golang.org/x/xerrors"
+
+	"go.coder.com/sail/internal/xlog"
+)
+
+// defaultDockerContext is docker's context for the daemon of DOCKER_HOST,
+// or the local daemon.
+const defaultDockerContext = "default"
+
+// dockerConfigDir returns the directory of docker's config and contexts.
+func dockerConfigDir() string {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return dir
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		panic(err)
+	}
+	return filepath.Join(homeDir, ".docker")
+}
+
+// currentDockerContext returns the context selected with docker context use.
+func currentDockerContext(configDir string) (string, error) {
+	b, err := ioutil.ReadFile(filepath.Join(configDir, "config.json"))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	var conf struct {
+		CurrentContext string `json:"currentContext"`
+	}
+	err = json.Unmarshal(b, &conf)
+	if err != nil {
+		return "", xerrors.Errorf("failed to parse docker config: %w", err)
+	}
+	return conf.CurrentContext, nil
+}
+
+// dockerEndpoint is the Docker daemon of a docker context.
+type dockerEndpoint struct {
+	host          string
+	skipTLSVerify bool
+	// certPath is the directory holding the context's ca.pem, cert.pem and
+	// key.pem. It's empty if the context has no TLS material.
+	certPath string
+}
+
+// loadDockerContext reads the endpoint of the context name from docker's
+// context store in configDir.
+func loadDockerContext(configDir, name string) (dockerEndpoint, error) {
+	// The store names the directories of contexts after their digest.
+	sum := sha256.Sum256([]byte(name))
+	id := hex.EncodeToString(sum[:])
+
+	b, err := ioutil.ReadFile(filepath.Join(configDir, "contexts", "meta", id, "meta.json"))
+	if os.IsNotExist(err) {
+		return dockerEndpoint{}, xerrors.Errorf("docker context %q doesn't exist, see docker context ls", name)
+	}
+	if err != nil {
+		return dockerEndpoint{}, err
+	}
+
+	var meta struct {
+		Endpoints map[string]struct {
+			Host          string
+			SkipTLSVerify bool
+		}
+	}
+	err = json.Unmarshal(b, &meta)
+	if err != nil {
+		return dockerEndpoint{}, xerrors.Errorf("failed to parse docker context %q: %w", name, err)
+	}
+	docker, ok := meta.Endpoints["docker"]
+	if !ok || docker.Host == "" {
+		return dockerEndpoint{}, xerrors.Errorf("docker context %q has no docker endpoint", name)
+	}
+
+	ep := dockerEndpoint{
+		host:          docker.Host,
+		skipTLSVerify: docker.SkipTLSVerify,
+	}
+	certPath := filepath.Join(configDir, "contexts", "tls", id, "docker")
+	_, err = os.Stat(filepath.Join(certPath, "ca.pem"))
+	if err == nil {
+		ep.certPath = certPath
+	}
+	return ep, nil
+}
+
+// dockerContextEndpoint returns the endpoint of the context name, which sail
+// must be able to connect to.
+func dockerContextEndpoint(name string) (dockerEndpoint, error) {
+	ep, err := loadDockerContext(dockerConfigDir(), name)
+	if err != nil {
+		return dockerEndpoint{}, err
+	}
+	// Only the docker CLI can connect over SSH.
+	if strings.HasPrefix(ep.host, "ssh://") {
+		return dockerEndpoint{}, xerrors.Errorf("docker context %q connects over SSH, which sail doesn't support, forward the daemon's socket and use a context with its unix:// or tcp:// address instead", name)
+	}
+	return ep, nil
+}
+
+// useDockerContext points sail at the daemon of the context name. It's done
+// through the environment, so the docker CLI and the sail processes that sail
+// runs use it too.
+func useDockerContext(name string) error {
+	if name == "" || name == defaultDockerContext {
+		return nil
+	}
+
+	ep, err := dockerContextEndpoint(name)
+	if err != nil {
+		return err
+	}
+
+	os.Setenv("DOCKER_HOST", ep.host)
+	// DOCKER_HOST takes precedence anyway, this stops the CLI from warning
+	// about it.
+	os.Unsetenv("DOCKER_CONTEXT")
+	os.Unsetenv("DOCKER_CERT_PATH")
+	os.Unsetenv("DOCKER_TLS_VERIFY")
+	if ep.certPath != "" {
+		os.Setenv("DOCKER_CERT_PATH", ep.certPath)
+		if !ep.skipTLSVerify {
+			os.Setenv("DOCKER_TLS_VERIFY", "1")
+		}
+	}
+	xlog.Debug("using docker context %v at %v", name, ep.host)
+	return nil
+}
+
+// selectDockerContext points sail at the docker context of project, or the
+// default one if project is empty. Only the first call has an effect, so
+// a project's context stays selected.
+//
+// An explicit DOCKER_HOST or DOCKER_CONTEXT takes precedence over the config,
+// which takes precedence over docker's current context.
+func (gf *globalFlags) selectDockerContext(project string) {
+	if gf.dockerContext != "" {
+		return
+	}
+
+	name, source := gf.dockerContextFor(project)
+	gf.dockerContext, gf.dockerContextSource = name, source
+	err := useDockerContext(name)
+	if err != nil {
+		xlog.Fatal("failed to use docker context from %v: %v", source, err)
+	}
+}
+
+// dockerContextFor returns the name of the context of project, and where it
+// was selected.
+func (gf *globalFlags) dockerContextFor(project string) (name, source string) {
+	if os.Getenv("DOCKER_HOST") != "" {
+		return defaultDockerContext, "DOCKER_HOST"
+	}
+	if name := os.Getenv("DOCKER_CONTEXT"); name != "" {
+		return name, "DOCKER_CONTEXT"
+	}
+
+	conf := gf.config()
+	if name := conf.DockerContexts[project]; project != "" && name != "" {
+		return name, "config docker_contexts"
+	}
+	if conf.DockerContext != "" {
+		return conf.DockerContext, "config docker_context"
+	}
+
+	name, err := currentDockerContext(dockerConfigDir())
+	if err != nil {
+		xlog.Warn("%v", err)
+	}
+	if name == "" {
+		return defaultDockerContext, "default"
+	}
+	return name, "docker context use"
+}
+
+// dockerContexts returns the docker contexts sail's projects may run on, the
+// one selectDockerContext selects for commands without a project first,
+// followed by those of docker_contexts. If the environment selects the
+// daemon, it's the only one.
+func (gf *globalFlags) dockerContexts() []string {
+	gf.selectDockerContext("")
+	names := []string{gf.dockerContext}
+	if gf.dockerContextSource == "DOCKER_HOST" || gf.dockerContextSource == "DOCKER_CONTEXT" {
+		return names
+	}
+
+	var others []string
+	for _, name := range gf.config().DockerContexts {
+		if !stringsContain(names, name) && !stringsContain(others, name) {
+			others = append(others, name)
+		}
+	}
+	sort.Strings(others)
+	return append(names, others...)
+}
+
+// dockerContextClient returns a client of the daemon of the docker context
+// name. The selected context is reached through the environment like
+// dockerClient does, other contexts through their endpoint.
+func (gf *globalFlags) dockerContextClient(name string) (*client.Client, error) {
+	if name == gf.dockerContext {
+		return dockerClient(), nil
+	}
+
+	host := client.DefaultDockerHost
+	var opts []client.Opt
+	if name != defaultDockerContext {
+		ep, err := dockerContextEndpoint(name)
+		if err != nil {
+			return nil, err
+		}
+		host = ep.host
+		if ep.certPath != "" {
+			tlsc, err := tlsconfig.Client(tlsconfig.Options{
+				CAFile:             filepath.Join(ep.certPath, "ca.pem"),
+				CertFile:           filepath.Join(ep.certPath, "cert.pem"),
+				KeyFile:            filepath.Join(ep.certPath, "key.pem"),
+				InsecureSkipVerify: ep.skipTLSVerify,
+			})
+			if err != nil {
+				return nil, xerrors.Errorf("failed to load the TLS material of docker context %q: %w", name, err)
+			}
+			opts = append(opts, client.WithHTTPClient(&http.Client{
+				Transport:     &http.Transport{TLSClientConfig: tlsc},
+				CheckRedirect: client.CheckRedirect,
+			}))
+		}
+	}
+	// The host configures the transport, so it comes after the HTTP client.
+	cli, err := client.NewClientWithOpts(append(opts, client.WithHost(host))...)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to make docker client for context %q: %w", name, err)
+	}
+	cli.NegotiateAPIVersion(context.Background())
+	return cli, nil
+}
+
+// projectClient returns a client of the daemon of the docker context of
+// info, see listAllProjects.
+func (gf *globalFlags) projectClient(info projectInfo) (*client.Client, error) {
+	if info.dockerContext == "" {
+		return dockerClient(), nil
+	}
+	return gf.dockerContextClient(info.dockerContext)
+}
+
+// dockerContextEnv returns the environment of the processes sail runs, like
+// the docker CLI, to use the docker context name. It's nil for the selected
+// context, which processes inherit.
+func (gf *globalFlags) dockerContextEnv(name string) []string {
+	if name == "" || name == gf.dockerContext {
+		return nil
+	}
+	var env []string
+	for _, kv := range os.Environ() {
+		switch strings.SplitN(kv, "=", 2)[0] {
+		case "DOCKER_HOST", "DOCKER_CONTEXT", "DOCKER_CERT_PATH", "DOCKER_TLS_VERIFY":
+			continue
+		}
+		env = append(env, kv)
+	}
+	return append(env, "DOCKER_CONTEXT="+name)
+}
+
+// contextSailCmd returns a sail process running args with the docker context
+// name, e.g. to remove a project of another context. Hooks and the docker CLI
+// use the daemon of the environment, so it can't be done in this process.
+func (gf *globalFlags) contextSailCmd(name string, args ...string) *exec.Cmd {
+	sargs := []string{"--config", gf.configPath}
+	if gf.verbose {
+		sargs = append(sargs, "-v")
+	}
+	cmd := sailCmd(append(sargs, args...)...)
+	cmd.Env = gf.dockerContextEnv(name)
+	return cmd
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func dockerContextID(name string) string {
+	sum := sha256.Sum256([]byte(name))
+	return hex.EncodeToString(sum[:])
+}
+
+// writeDockerContext writes the context name to the context store in dir.
+func writeDockerContext(t *testing.T, dir, name, meta string, tls bool) {
+	metaDir := filepath.Join(dir, "contexts", "meta", dockerContextID(name))
+	require.NoError(t, os.MkdirAll(metaDir, 0750))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(metaDir, "meta.json"), []byte(meta), 0640))
+
+	if tls {
+		tlsDir := filepath.Join(dir, "contexts", "tls", dockerContextID(name), "docker")
+		require.NoError(t, os.MkdirAll(tlsDir, 0750))
+		for _, f := range []string{"ca.pem", "cert.pem", "key.pem"} {
+			require.NoError(t, ioutil.WriteFile(filepath.Join(tlsDir, f), nil, 0600))
+		}
+	}
+}
+
+func Test_loadDockerContext(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sail-docker")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	name, err := currentDockerContext(dir)
+	require.NoError(t, err)
+	assert.Equal(t, "", name)
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "config.json"), []byte(`{"currentContext": "devbox"}`), 0640))
+	name, err = currentDockerContext(dir)
+	require.NoError(t, err)
+	assert.Equal(t, "devbox", name)
+
+	writeDockerContext(t, dir, "devbox", `{"Name":"devbox","Endpoints":{"docker":{"Host":"tcp://devbox:2376","SkipTLSVerify":false}}}`, true)
+	ep, err := loadDockerContext(dir, "devbox")
+	require.NoError(t, err)
+	assert.Equal(t, dockerEndpoint{
+		host:     "tcp://devbox:2376",
+		certPath: filepath.Join(dir, "contexts", "tls", dockerContextID("devbox"), "docker"),
+	}, ep)
+
+	writeDockerContext(t, dir, "rootless", `{"Name":"rootless","Endpoints":{"docker":{"Host":"unix:///run/user/1000/docker.sock"}}}`, false)
+	ep, err = loadDockerContext(dir, "rootless")
+	require.NoError(t, err)
+	assert.Equal(t, dockerEndpoint{host: "unix:///run/user/1000/docker.sock"}, ep)
+
+	_, err = loadDockerContext(dir, "missing")
+	require.Error(t, err)
+}
+
+func Test_useDockerContext(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sail-docker")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	for _, k := range []string{"DOCKER_CONFIG", "DOCKER_HOST", "DOCKER_CONTEXT", "DOCKER_CERT_PATH", "DOCKER_TLS_VERIFY"} {
+		v, ok := os.LookupEnv(k)
+		if ok {
+			defer os.Setenv(k, v)
+		} else {
+			defer os.Unsetenv(k)
+		}
+	}
+	os.Setenv("DOCKER_CONFIG", dir)
+	os.Setenv("DOCKER_CONTEXT", "devbox")
+
+	writeDockerContext(t, dir, "devbox", `{"Name":"devbox","Endpoints":{"docker":{"Host":"tcp://devbox:2376"}}}`, true)
+	writeDockerContext(t, dir, "remote", `{"Name":"remote","Endpoints":{"docker":{"Host":"ssh://me@remote"}}}`, false)
+
+	require.NoError(t, useDockerContext("devbox"))
+	assert.Equal(t, "tcp://devbox:2376", os.Getenv("DOCKER_HOST"))
+	assert.Equal(t, filepath.Join(dir, "contexts", "tls", dockerContextID("devbox"), "docker"), os.Getenv("DOCKER_CERT_PATH"))
+	assert.Equal(t, "1", os.Getenv("DOCKER_TLS_VERIFY"))
+	_, ok := os.LookupEnv("DOCKER_CONTEXT")
+	assert.False(t, ok)
+
+	require.Error(t, useDockerContext("remote"))
+	require.NoError(t, useDockerContext(defaultDockerContext))
+	assert.Equal(t, "tcp://devbox:2376", os.Getenv("DOCKER_HOST"))
+}
+
+func Test_dockerContexts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sail-docker")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	for _, k := range []string{"DOCKER_CONFIG", "DOCKER_HOST", "DOCKER_CONTEXT", "DOCKER_CERT_PATH", "DOCKER_TLS_VERIFY"} {
+		v, ok := os.LookupEnv(k)
+		if ok {
+			defer os.Setenv(k, v)
+		} else {
+			defer os.Unsetenv(k)
+		}
+		os.Unsetenv(k)
+	}
+	os.Setenv("DOCKER_CONFIG", dir)
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "config.json"), []byte(`{"currentContext": "devbox"}`), 0640))
+	writeDockerContext(t, dir, "devbox", `{"Name":"devbox","Endpoints":{"docker":{"Host":"tcp://devbox:2376"}}}`, false)
+	writeDockerContext(t, dir, "lab", `{"Name":"lab","Endpoints":{"docker":{"Host":"tcp://lab:2376"}}}`, false)
+	configPath := filepath.Join(dir, "sail.toml")
+	require.NoError(t, ioutil.WriteFile(configPath, []byte(`[docker_contexts]
+"cdr/sail" = "lab"
+"cdr/api" = "devbox"
+"cdr/code-server" = "lab"
+`), 0640))
+
+	gf := &globalFlags{configPath: configPath}
+	assert.Equal(t, []string{"devbox", "lab"}, gf.dockerContexts())
+	assert.Nil(t, gf.dockerContextEnv("devbox"))
+	assert.Contains(t, gf.dockerContextEnv("lab"), "DOCKER_CONTEXT=lab")
+	assert.NotContains(t, gf.dockerContextEnv("lab"), "DOCKER_HOST=tcp://devbox:2376")
+
+	cli, err := gf.dockerContextClient("lab")
+	require.NoError(t, err)
+	defer cli.Close()
+	assert.Equal(t, "tcp://lab:2376", cli.DaemonHost())
+
+	// Processes of other contexts only use theirs.
+	os.Setenv("DOCKER_CONTEXT", "lab")
+	os.Unsetenv("DOCKER_HOST")
+	gf = &globalFlags{configPath: configPath}
+	assert.Equal(t, []string{"lab"}, gf.dockerContexts())
+}
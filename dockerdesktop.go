@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+
+	"github.com/docker/docker/api/types"
+	"golang.org/x/xerrors"
+)
+
+// Names of the ports sail assigns to a container, in its ports file.
+const (
+	// codeServerPortName is the port code-server is published on, when
+	// ports are published and it doesn't listen on a socket.
+	codeServerPortName = "code-server"
+	// guestPortName is the port the guest code-server is published on,
+	// see startGuest.
+	guestPortName = "guest"
+	// proxyPortName is the port of the sail proxy, which is the port of the
+	// environment's URL.
+	proxyPortName = "proxy"
+	// guiPortName is the port noVNC is published on, see createGUI.
+	guiPortName = "gui"
+)
+
+// isDockerDesktop reports whether the daemon of info runs in the VM of
+// Docker Desktop, whose containers can't share the host's network, nor be
+// reached at their IP. Docker for Mac and Windows are always in a VM, Docker
+// Desktop for Linux reports itself as its operating system.
+func isDockerDesktop(info types.Info) bool {
+	if runtime.GOOS == "darwin" || runtime.GOOS == "windows" {
+		return true
+	}
+	return info.OperatingSystem == "Docker Desktop"
+}
+
+// portsPath is the file holding the ports assigned to the container cntName.
+func portsPath(cntName string) string {
+	return filepath.Join(metaRoot(), cntName, "ports.json")
+}
+
+// loadPorts reads the ports assigned to the container cntName, by name.
+func loadPorts(cntName string) (map[string]string, error) {
+	ports := make(map[string]string)
+	b, err := ioutil.ReadFile(portsPath(cntName))
+	if os.IsNotExist(err) {
+		return ports, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(b, &ports)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to parse %v: %w", portsPath(cntName), err)
+	}
+	return ports, nil
+}
+
+// savePort records that the port name of the container cntName is port.
+func savePort(cntName, name, port string) error {
+	ports, err := loadPorts(cntName)
+	if err != nil {
+		return err
+	}
+	if ports[name] == port {
+		return nil
+	}
+	ports[name] = port
+
+	b, err := json.MarshalIndent(ports, "", "\t")
+	if err != nil {
+		return err
+	}
+	path := portsPath(cntName)
+	err = os.MkdirAll(filepath.Dir(path), 0750)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0640)
+}
+
+// assignPort returns the port name of the container cntName on the host's
+// loopback. The port assigned before is kept while it's free, so the
+// environment's URL stays the same when it's recreated. Otherwise a free
+// port is assigned.
+func assignPort(cntName, name string) (string, error) {
+	ports, err := loadPorts(cntName)
+	if err != nil {
+		return "", err
+	}
+	if port := ports[name]; port != "" && portFree(port) {
+		return port, nil
+	}
+
+	// The other ports of the container are free too until it's created.
+	taken := make(map[string]bool)
+	for n, p := range ports {
+		if n != name {
+			taken[p] = true
+		}
+	}
+	var port string
+	for port == "" || taken[port] {
+		port, err = freePort()
+		if err != nil {
+			return "", err
+		}
+	}
+	err = savePort(cntName, name, port)
+	if err != nil {
+		return "", xerrors.Errorf("failed to save %v port: %w", name, err)
+	}
+	return port, nil
+}
+
+// portFree reports whether port can be listened on on the host's loopback.
+func portFree(port string) bool {
+	l, err := net.Listen("tcp", "127.0.0.1:"+port)
+	if err != nil {
+		return false
+	}
+	l.Close()
+	return true
+}
+
+// freePort returns a port of the host's loopback that's free.
+func freePort() (string, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", xerrors.Errorf("failed to find a free port: %w", err)
+	}
+	defer l.Close()
+	return strconv.Itoa(l.Addr().(*net.TCPAddr).Port), nil
+}
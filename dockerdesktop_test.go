@@ -0,0 +1,53 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"runtime"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_isDockerDesktop(t *testing.T) {
+	assert.True(t, isDockerDesktop(types.Info{OperatingSystem: "Docker Desktop"}))
+	if runtime.GOOS == "linux" {
+		assert.False(t, isDockerDesktop(types.Info{OperatingSystem: "Ubuntu 20.04 LTS"}))
+	}
+}
+
+func Test_assignPort(t *testing.T) {
+	home, err := ioutil.TempDir("", "sail-ports")
+	require.NoError(t, err)
+	defer os.RemoveAll(home)
+	defer os.Setenv("HOME", os.Getenv("HOME"))
+	os.Setenv("HOME", home)
+
+	port, err := assignPort("cdr_sail", proxyPortName)
+	require.NoError(t, err)
+	assert.NotEqual(t, "0", port)
+
+	// The port is kept while it's free.
+	again, err := assignPort("cdr_sail", proxyPortName)
+	require.NoError(t, err)
+	assert.Equal(t, port, again)
+
+	other, err := assignPort("cdr_sail", codeServerPortName)
+	require.NoError(t, err)
+	assert.NotEqual(t, port, other)
+
+	// A new port is assigned once it's taken.
+	l, err := net.Listen("tcp", "127.0.0.1:"+port)
+	require.NoError(t, err)
+	defer l.Close()
+	moved, err := assignPort("cdr_sail", proxyPortName)
+	require.NoError(t, err)
+	assert.NotEqual(t, port, moved)
+
+	ports, err := loadPorts("cdr_sail")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{proxyPortName: moved, codeServerPortName: other}, ports)
+}
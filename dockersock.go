@@ -0,0 +1,61 @@
+package main
+
+import (
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/docker/docker/api/types/mount"
+	"golang.org/x/xerrors"
+)
+
+// containerDockerSocket is where the host's Docker socket is mounted
+// inside of the container.
+const containerDockerSocket = "/var/run/docker.sock"
+
+// hostDockerSocket returns the path of the host's Docker socket.
+func hostDockerSocket() string {
+	host := os.Getenv("DOCKER_HOST")
+	if strings.HasPrefix(host, "unix://") {
+		return strings.TrimPrefix(host, "unix://")
+	}
+	return "/var/run/docker.sock"
+}
+
+// mountDockerSocket mounts the host's Docker socket into the container so
+// projects can build and run containers from inside of sail.
+func mountDockerSocket(mounts []mount.Mount) ([]mount.Mount, error) {
+	sock := hostDockerSocket()
+	_, err := os.Stat(sock)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to find docker socket: %w", err)
+	}
+
+	return append(mounts, mount.Mount{
+		Type:   mount.TypeBind,
+		Source: sock,
+		Target: containerDockerSocket,
+	}), nil
+}
+
+// dockerSocketGroup returns the gid owning the host's Docker socket, so the
+// container user can be added to it. The empty string is returned on
+// platforms where the socket lives inside of a VM, as the host's gid is
+// meaningless there.
+func dockerSocketGroup() (string, error) {
+	if runtime.GOOS != "linux" {
+		return "", nil
+	}
+
+	fi, err := os.Stat(hostDockerSocket())
+	if err != nil {
+		return "", xerrors.Errorf("failed to stat docker socket: %w", err)
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return "", nil
+	}
+	return strconv.FormatUint(uint64(st.Gid), 10), nil
+}
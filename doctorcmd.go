@@ -0,0 +1,146 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+
+	"go.coder.com/cli"
+	"go.coder.com/sail/internal/xlog"
+)
+
+// inotifySysctlPath is where sail doctor --fix persists the inotify limit.
+const inotifySysctlPath = "/etc/sysctl.d/60-sail-inotify.conf"
+
+// doctorCheck is a check of the host's setup by sail doctor.
+type doctorCheck struct {
+	name string
+	// run returns the problem the check found, or "" if there's none.
+	run func() string
+	// fix fixes the problem, if it can be fixed automatically.
+	fix func() error
+}
+
+type doctorcmd struct {
+	gf *globalFlags
+
+	fix bool
+}
+
+func (c *doctorcmd) Spec() cli.CommandSpec {
+	return cli.CommandSpec{
+		Name:  "doctor",
+		Usage: "[flags]",
+		Desc: `Checks the host's setup for problems with sail's environments.
+
+It checks that the Docker daemon is reachable, and that the inotify watch limit
+of the daemon's kernel allows code-server to watch the files of every project.
+With commit_signing set, it checks that the host's gpg-agent socket exists and
+its pinentry can ask for passphrases, or that an ssh-agent runs for SSH keys.
+With --fix, problems that can be fixed automatically are, which may ask for
+your password with sudo.`,
+	}
+}
+
+func (c *doctorcmd) RegisterFlags(fl *flag.FlagSet) {
+	fl.BoolVar(&c.fix, "fix", false, "Fix the problems that can be fixed automatically.")
+}
+
+func (c *doctorcmd) Run(fl *flag.FlagSet) {
+	c.gf.selectDockerContext("")
+
+	var failed bool
+	for _, check := range c.checks() {
+		problem := check.run()
+		if problem == "" {
+			xlog.Success("%v: ok", check.name)
+			continue
+		}
+		if !c.fix || check.fix == nil {
+			xlog.Warn("%v: %v", check.name, problem)
+			failed = true
+			continue
+		}
+
+		xlog.Info("%v: %v, fixing it", check.name, problem)
+		err := check.fix()
+		if err != nil {
+			xlog.Error("%v: failed to fix: %v", check.name, err)
+			failed = true
+			continue
+		}
+		xlog.Success("%v: fixed", check.name)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func (c *doctorcmd) checks() []doctorCheck {
+	return []doctorCheck{
+		{name: "docker", run: checkDockerDaemon},
+		{name: "inotify", run: checkInotifyWatches, fix: fixInotifyWatches},
+		{name: "commit signing", run: func() string {
+			return checkCommitSigning(c.gf.config())
+		}},
+	}
+}
+
+func checkDockerDaemon() string {
+	_, err := dockerInfo()
+	if err != nil {
+		return fmt.Sprintf("the Docker daemon isn't reachable: %v", err)
+	}
+	return ""
+}
+
+// checkInotifyWatches checks that the inotify watch limit is high enough for
+// the largest project, so code-server doesn't have to poll for changes.
+func checkInotifyWatches() string {
+	if runtime.GOOS != "linux" {
+		return ""
+	}
+	info, err := dockerInfo()
+	if err != nil || isDockerDesktop(info) {
+		// The limit of daemons in a VM can't be read from the host.
+		return ""
+	}
+
+	limit, err := readInotifyWatches(inotifyWatchesPath)
+	if err != nil {
+		return fmt.Sprintf("failed to read the limit: %v", err)
+	}
+	infos, err := listProjects()
+	if err != nil {
+		return fmt.Sprintf("failed to list projects: %v", err)
+	}
+	for _, info := range infos {
+		if info.localDir == "" {
+			continue
+		}
+		dirs, err := watchedDirs(info.localDir, limit)
+		if err != nil || !needsPolling(dirs, limit) {
+			continue
+		}
+		return fmt.Sprintf("fs.inotify.max_user_watches is %v, too low for the directories of %v, so code-server polls for changes", limit, info.name)
+	}
+	return ""
+}
+
+// fixInotifyWatches raises the inotify watch limit with sudo, and persists it
+// across reboots.
+func fixInotifyWatches() error {
+	setting := fmt.Sprintf("fs.inotify.max_user_watches=%v", recommendedInotifyWatches)
+	cmd := exec.Command("sudo", "sh", "-c", fmt.Sprintf("echo %v > %v && sysctl -p %v", setting, inotifySysctlPath, inotifySysctlPath))
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	err := cmd.Run()
+	if err != nil {
+		return err
+	}
+	xlog.Info("rebuild projects with sail run --rebuild so they stop polling")
+	return nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+)
+
+// containerDrift returns how the existing container cnt of proj differs from
+// the one sail run would create with the hat and base image of b, if any.
+// b's base image is empty unless sail run was given one with --image.
+func containerDrift(proj *project, cnt types.ContainerJSON, b *hatBuilder) []string {
+	var (
+		drift  []string
+		labels = cnt.Config.Labels
+	)
+
+	hat := labels[hatLabel]
+	switch {
+	case hat != b.hatPath:
+		drift = append(drift, fmt.Sprintf("its hat is %v instead of %v", orNone(hat), orNone(b.hatPath)))
+	case hat != "":
+		args, _ := labelHatArgs(labels)
+		if (len(args) > 0 || len(b.args) > 0) && !reflect.DeepEqual(args, b.args) {
+			drift = append(drift, "its hat arguments changed")
+		} else if changedHat(labels, sourceImage(cnt)) {
+			drift = append(drift, "its hat changed")
+		}
+	}
+
+	base := sourceImage(cnt)
+	if hat != "" {
+		base = labels[baseImageLabel]
+	}
+	if b.baseImage != "" && normalizeImageName(b.baseImage) != normalizeImageName(unpinImage(base, labels[upstreamLabel])) {
+		drift = append(drift, fmt.Sprintf("it was created from %v instead of %v", base, b.baseImage))
+	}
+
+	// Images built before their Dockerfile's hash was recorded are only
+	// rebuilt with --rebuild.
+	if b.baseImage == "" && labels[dockerfileHashLabel] != "" {
+		hash, err := dockerfileHash(proj.dockerfilePath())
+		if err == nil && hash != labels[dockerfileHashLabel] {
+			drift = append(drift, ".sail/Dockerfile changed since it was created")
+		}
+	}
+
+	mounts, err := loadMounts(proj.cntName())
+	if err == nil && !sameMounts(mounts, splitMounts(labels[mountsLabel])) {
+		drift = append(drift, "its mounts changed")
+	}
+	return drift
+}
+
+// envDrift returns how the variables set with sail env, env, differ from the
+// ones the container cnt was created with. Containers that sail env updates in
+// place don't drift.
+func envDrift(cnt types.ContainerJSON, env []string) []string {
+	if containerEnvPath(cnt) != "" {
+		return nil
+	}
+
+	cntEnv := make(map[string]bool)
+	for _, v := range cnt.Config.Env {
+		cntEnv[v] = true
+	}
+	var changed []string
+	for _, v := range env {
+		if !cntEnv[v] {
+			changed = append(changed, envKeys([]string{v})[0])
+		}
+	}
+	for _, k := range strings.Split(cnt.Config.Labels[envKeysLabel], ",") {
+		if k != "" && !stringsContain(envKeys(env), k) {
+			changed = append(changed, k)
+		}
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+	sort.Strings(changed)
+	return []string{fmt.Sprintf("its environment variables changed: %v", strings.Join(changed, ", "))}
+}
+
+// imageDrift returns how img, the image the reference cnt was created from
+// points to now, differs from old, the image it was created from.
+func imageDrift(cnt types.ContainerJSON, old, img types.ImageInspect) []string {
+	if old.ID == img.ID {
+		return nil
+	}
+	drift := []string{fmt.Sprintf("%v is %v instead of %v", cnt.Config.Image, shortID(img.ID), shortID(old.ID))}
+	if old.Config == nil || img.Config == nil {
+		return drift
+	}
+
+	var labels []string
+	for k, v := range img.Config.Labels {
+		if old.Config.Labels[k] != v {
+			labels = append(labels, k)
+		}
+	}
+	for k := range old.Config.Labels {
+		if _, ok := img.Config.Labels[k]; !ok {
+			labels = append(labels, k)
+		}
+	}
+	sort.Strings(labels)
+	for _, k := range labels {
+		if stringsContain(stateLabels, k) {
+			continue
+		}
+		drift = append(drift, fmt.Sprintf("its image's %v label changed", k))
+	}
+	return drift
+}
+
+// shortID shortens the image ID id like docker images does.
+func shortID(id string) string {
+	id = strings.TrimPrefix(id, "sha256:")
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
+
+// sameMounts reports whether a and b have the same mounts in any order.
+func sameMounts(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a = append([]string(nil), a...)
+	b = append([]string(nil), b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	return reflect.DeepEqual(a, b)
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "none"
+	}
+	return s
+}
+
+// dockerfileHash returns the SHA-256 digest of the Dockerfile at path, which
+// the images built from it are labeled with.
+func dockerfileHash(path string) (string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
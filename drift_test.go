@@ -0,0 +1,113 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_containerDrift(t *testing.T) {
+	root, err := ioutil.TempDir("", "sail-drift")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	proj := &project{
+		conf: config{ProjectRoot: root},
+		repo: repo{URL: &url.URL{Path: "cdr/sail-drift-test"}},
+	}
+	cnt := func(labels map[string]string) types.ContainerJSON {
+		return types.ContainerJSON{
+			ContainerJSONBase: &types.ContainerJSONBase{
+				Created: time.Now().Format(time.RFC3339Nano),
+			},
+			Config: &container.Config{
+				Image:  "codercom/ubuntu-dev",
+				Labels: labels,
+			},
+		}
+	}
+
+	assert.Empty(t, containerDrift(proj, cnt(nil), &hatBuilder{}))
+	assert.Empty(t, containerDrift(proj, cnt(nil), &hatBuilder{baseImage: "codercom/ubuntu-dev:latest"}))
+
+	assert.Equal(t, []string{"its hat is none instead of ./hat-examples/fish"},
+		containerDrift(proj, cnt(nil), &hatBuilder{hatPath: "./hat-examples/fish"}))
+	assert.Equal(t, []string{"it was created from codercom/ubuntu-dev instead of ubuntu"},
+		containerDrift(proj, cnt(nil), &hatBuilder{baseImage: "ubuntu"}))
+	assert.Equal(t, []string{"its mounts changed"},
+		containerDrift(proj, cnt(map[string]string{mountsLabel: "~/datasets:~/datasets"}), &hatBuilder{}))
+
+	dockerfile := proj.dockerfilePath()
+	require.NoError(t, os.MkdirAll(filepath.Dir(dockerfile), 0750))
+	require.NoError(t, ioutil.WriteFile(dockerfile, []byte("FROM ubuntu\n"), 0644))
+	hash, err := dockerfileHash(dockerfile)
+	require.NoError(t, err)
+	assert.Empty(t, containerDrift(proj, cnt(map[string]string{dockerfileHashLabel: hash}), &hatBuilder{}))
+
+	// Touching the Dockerfile doesn't change it.
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(dockerfile, future, future))
+	assert.Empty(t, containerDrift(proj, cnt(map[string]string{dockerfileHashLabel: hash}), &hatBuilder{}))
+
+	require.NoError(t, ioutil.WriteFile(dockerfile, []byte("FROM debian\n"), 0644))
+	assert.Equal(t, []string{".sail/Dockerfile changed since it was created"},
+		containerDrift(proj, cnt(map[string]string{dockerfileHashLabel: hash}), &hatBuilder{}))
+}
+
+func Test_envDrift(t *testing.T) {
+	cnt := types.ContainerJSON{
+		Config: &container.Config{
+			Env:    []string{"PATH=/bin", "A=1", "B=2"},
+			Labels: map[string]string{envKeysLabel: "A,B"},
+		},
+	}
+	assert.Empty(t, envDrift(cnt, []string{"A=1", "B=2"}))
+	assert.Equal(t, []string{"its environment variables changed: A, B, C"},
+		envDrift(cnt, []string{"A=3", "C=4"}))
+
+	cnt.Config.Cmd = []string{"bash", "-c", launcherScript, launcherName, "/home/user/sail", resolvePath(guestHome(cnt.Config.Labels), codeServerEnvPath)}
+	assert.Empty(t, envDrift(cnt, []string{"A=3", "C=4"}))
+}
+
+func Test_containerEnvPath(t *testing.T) {
+	cnt := types.ContainerJSON{
+		Config: &container.Config{
+			Labels: map[string]string{guestHomeLabel: "/home/dev"},
+			Cmd:    []string{"bash", "-c", launcherScript, launcherName, "/home/dev/sail", "/home/dev/.sail-env"},
+		},
+	}
+	assert.Equal(t, "/home/dev/.sail-env", containerEnvPath(cnt))
+
+	cnt.Config.Cmd[5] = legacyCodeServerEnvPath
+	assert.Equal(t, legacyCodeServerEnvPath, containerEnvPath(cnt))
+
+	cnt.Config.Cmd = []string{"powershell", "-Command", "code-server"}
+	assert.Empty(t, containerEnvPath(cnt))
+}
+
+func Test_imageDrift(t *testing.T) {
+	cnt := types.ContainerJSON{Config: &container.Config{Image: "cdr/sail"}}
+	old := types.ImageInspect{
+		ID:     "sha256:0123456789abcdef",
+		Config: &container.Config{Labels: map[string]string{"share.ssh": "~/.ssh:~/.ssh", baseImageLabel: "a", "on_start": "make"}},
+	}
+	assert.Empty(t, imageDrift(cnt, old, old))
+
+	img := types.ImageInspect{
+		ID:     "sha256:fedcba9876543210",
+		Config: &container.Config{Labels: map[string]string{"share.ssh": "~/.ssh:/root/.ssh", baseImageLabel: "b"}},
+	}
+	assert.Equal(t, []string{
+		"cdr/sail is fedcba987654 instead of 0123456789ab",
+		"its image's on_start label changed",
+		"its image's share.ssh label changed",
+	}, imageDrift(cnt, old, img))
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"golang.org/x/xerrors"
+
+	"go.coder.com/sail/internal/xexec"
+	"go.coder.com/sail/internal/xlog"
+)
+
+// printPlan prints the container sail run would create for the project.
+// Images are only inspected, nothing is built, pulled or created.
+func (c *runcmd) printPlan(proj *project) {
+	exists, err := proj.cntExists()
+	if err != nil {
+		xlog.Fatal("%v", err)
+	}
+	if exists && !c.rebuild {
+		xlog.Info("%v already exists, sail run reuses it unless --rebuild is passed", proj.cntName())
+	}
+
+	image, err := c.plannedImage(proj)
+	if err != nil {
+		xlog.Fatal("%v", err)
+	}
+
+	r, err := c.runner(proj, false)
+	if err != nil {
+		xlog.Fatal("%v", err)
+	}
+	r.dryRun = true
+
+	cntConfig, hostConfig, err := r.containerSpec(image)
+	if err != nil {
+		xlog.Fatal("failed to assemble container: %v", err)
+	}
+	writeContainerSpec(os.Stdout, cntConfig, hostConfig)
+}
+
+// Sources of the image sail run creates a project's container from.
+const (
+	imageFromFlag     = "flag --image"
+	imageFromRepo     = "repo"
+	imageFromLanguage = "repo language"
+	imageFromConfig   = "default_image"
+)
+
+// baseImage returns the image sail run would apply the hat to, along with
+// where it came from.
+func (c *runcmd) baseImage(proj *project) (image, source string) {
+	switch {
+	case c.image != "":
+		return c.image, imageFromFlag
+	case proj.definesImage():
+		return proj.imageName(), imageFromRepo
+	}
+
+	image = proj.defaultRepoImage()
+	if image == proj.conf.DefaultImage {
+		return image, imageFromConfig
+	}
+	return image, imageFromLanguage
+}
+
+// plannedImage returns the image sail run would create the project's
+// container from. It must already exist, as it's not built or pulled.
+func (c *runcmd) plannedImage(proj *project) (string, error) {
+	_, err := os.Stat(proj.localDir())
+	if os.IsNotExist(err) {
+		xlog.Info("%v isn't cloned yet, assuming it doesn't define an image", proj.pathName())
+	}
+
+	image, source := c.baseImage(proj)
+	hint := "pull it"
+	if source == imageFromRepo {
+		hint = "build it with sail build"
+	}
+
+	b := c.hatBuilder(proj, image)
+	if b.hatPath != "" {
+		_, _, image, err = b.hatDockerfile()
+		if err != nil {
+			return "", err
+		}
+		hint = "run the project to build it"
+	}
+
+	ok, err := imageExists(image)
+	if err != nil {
+		return "", xerrors.Errorf("failed to inspect %v: %w", image, err)
+	}
+	if !ok {
+		return "", xerrors.Errorf("image %v doesn't exist yet, %v first", image, hint)
+	}
+	return image, nil
+}
+
+// imageExists reports whether image exists locally.
+func imageExists(image string) (bool, error) {
+	cli := dockerClient()
+	defer cli.Close()
+
+	_, _, err := cli.ImageInspectWithRaw(context.Background(), image)
+	if client.IsErrNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// writeContainerSpec writes a human readable description of a container's
+// configuration to w.
+func writeContainerSpec(w io.Writer, cntConfig *container.Config, hostConfig *container.HostConfig) {
+	fmt.Fprintf(w, "image: %v\n", cntConfig.Image)
+	fmt.Fprintf(w, "hostname: %v\n", cntConfig.Hostname)
+	if cntConfig.User != "" {
+		fmt.Fprintf(w, "user: %v\n", cntConfig.User)
+	}
+	if len(hostConfig.GroupAdd) > 0 {
+		fmt.Fprintf(w, "groups: %v\n", strings.Join(hostConfig.GroupAdd, ","))
+	}
+	if hostConfig.UsernsMode != "" {
+		fmt.Fprintf(w, "userns: %v\n", hostConfig.UsernsMode)
+	}
+	fmt.Fprintf(w, "rights: %v\n", describeRights(cntConfig.Labels))
+
+	network := string(hostConfig.NetworkMode)
+	if network == "" {
+		network = "default"
+	}
+	fmt.Fprintf(w, "network: %v\n", network)
+	writeSection(w, "extra hosts", hostConfig.ExtraHosts)
+
+	var ports []string
+	for port, bindings := range hostConfig.PortBindings {
+		for _, b := range bindings {
+			ports = append(ports, fmt.Sprintf("%v:%v -> %v", b.HostIP, b.HostPort, port))
+		}
+	}
+	sort.Strings(ports)
+	writeSection(w, "ports", ports)
+
+	var mounts []string
+	cntMounts, relabels := hostMounts(hostConfig)
+	for i, m := range cntMounts {
+		s := fmt.Sprintf("%v %v -> %v", m.Type, m.Source, m.Target)
+		if m.ReadOnly {
+			s += " (ro)"
+		}
+		if relabels[i] != "" {
+			s += fmt.Sprintf(" (%v)", relabels[i])
+		}
+		mounts = append(mounts, s)
+	}
+	writeSection(w, "mounts", mounts)
+
+	var devices []string
+	for _, d := range hostConfig.Devices {
+		devices = append(devices, deviceSpec(d))
+	}
+	writeSection(w, "devices", devices)
+
+	writeSection(w, "env", cntConfig.Env)
+
+	var labels []string
+	for k, v := range cntConfig.Labels {
+		if strings.Contains(v, "\n") {
+			v = fmt.Sprintf("%q", v)
+		}
+		labels = append(labels, k+"="+v)
+	}
+	sort.Strings(labels)
+	writeSection(w, "labels", labels)
+
+	writeCommand(w, cntConfig.Cmd)
+}
+
+// writeCommand writes the container's command, with the script of bash -c
+// on its own lines, followed by the script's arguments.
+func writeCommand(w io.Writer, cmd []string) {
+	if len(cmd) < 3 || cmd[1] != "-c" {
+		var args []string
+		for _, a := range cmd {
+			args = append(args, xexec.Quote(a))
+		}
+		writeSection(w, "command", []string{strings.Join(args, " ")})
+		return
+	}
+
+	writeSection(w, "command", []string{strings.Join(cmd[:2], " ")})
+	for _, l := range strings.Split(cmd[2], "\n") {
+		fmt.Fprintf(w, "\t\t%v\n", l)
+	}
+	for _, a := range cmd[3:] {
+		fmt.Fprintf(w, "\t%v\n", xexec.Quote(a))
+	}
+}
+
+// writeSection writes a titled list, omitting it if it's empty.
+func writeSection(w io.Writer, title string, lines []string) {
+	if len(lines) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "%v:\n", title)
+	for _, l := range lines {
+		fmt.Fprintf(w, "\t%v\n", l)
+	}
+}
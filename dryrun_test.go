@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/strslice"
+	"github.com/docker/go-connections/nat"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_writeContainerSpec(t *testing.T) {
+	var buf bytes.Buffer
+	writeContainerSpec(&buf, &container.Config{
+		Image:    "codercom/ubuntu-dev-go:latest",
+		Hostname: "sail",
+		Env:      []string{"DISPLAY=:0", "GOFLAGS=-mod=vendor"},
+		Cmd:      strslice.StrSlice{"bash", "-c", "cd \"$1\"\nexec code-server", launcherName, "/home/user/my project"},
+		Labels: map[string]string{
+			sailLabel:   "",
+			nameLabel:   "cdr/sail",
+			mountsLabel: "~/a:~/a\n~/b:~/b",
+		},
+	}, &container.HostConfig{
+		Privileged: true,
+		ExtraHosts: []string{"sail:127.0.0.1"},
+		PortBindings: nat.PortMap{
+			"8443/tcp": {{HostIP: "127.0.0.1", HostPort: "0"}},
+		},
+		Mounts: []mount.Mount{
+			{Type: mount.TypeBind, Source: "/home/user/go/src/cdr/sail", Target: "/home/user/sail"},
+			{Type: mount.TypeBind, Source: "/tmp/code-server", Target: "/usr/bin/code-server", ReadOnly: true},
+		},
+		Resources: container.Resources{
+			Devices: []container.DeviceMapping{
+				{PathOnHost: "/dev/kvm", PathInContainer: "/dev/kvm", CgroupPermissions: "rwm"},
+			},
+		},
+	})
+
+	assert.Equal(t, `image: codercom/ubuntu-dev-go:latest
+hostname: sail
+rights: privileged
+network: default
+extra hosts:
+	sail:127.0.0.1
+ports:
+	127.0.0.1:0 -> 8443/tcp
+mounts:
+	bind /home/user/go/src/cdr/sail -> /home/user/sail
+	bind /tmp/code-server -> /usr/bin/code-server (ro)
+devices:
+	/dev/kvm:/dev/kvm:rwm
+env:
+	DISPLAY=:0
+	GOFLAGS=-mod=vendor
+labels:
+	com.coder.sail.mounts="~/a:~/a\n~/b:~/b"
+	com.coder.sail.name=cdr/sail
+	com.coder.sail=
+command:
+	bash -c
+		cd "$1"
+		exec code-server
+	'sail-launcher'
+	'/home/user/my project'
+`, buf.String())
+}
@@ -9,14 +9,15 @@ import (
 	"time"
 
 	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
 	"golang.org/x/xerrors"
 
 	"go.coder.com/cli"
-	"go.coder.com/flog"
 	"go.coder.com/sail/internal/dockutil"
 	"go.coder.com/sail/internal/editor"
 	"go.coder.com/sail/internal/randstr"
 	"go.coder.com/sail/internal/xexec"
+	"go.coder.com/sail/internal/xlog"
 )
 
 type editcmd struct {
@@ -25,6 +26,7 @@ type editcmd struct {
 	noEditor bool
 	hatPath  string
 	hat      bool
+	hatArgs  stringsFlag
 }
 
 func (c *editcmd) Spec() cli.CommandSpec {
@@ -38,7 +40,8 @@ and the editor is closed, the environment will be rebuilt and rerun with minimal
 
 If no flags are set, this will open your project's Dockerfile. If the -hat flag is set, this
 will open the hat Dockerfile associated with your running project in the editor. If the -new-hat
-flag is set, the project will be adjusted to use the new hat.
+flag is set, the project will be adjusted to use the new hat. If the -hat-arg flag is set, the hat
+is rebuilt with the new values of its parameters.
 
 VS Code users can edit their environment by editing their .sail/Dockerfile within the editor. VS Code
 will rebuild the container when they click on the 'rebuild' button.`,
@@ -50,31 +53,37 @@ func (c *editcmd) Run(fl *flag.FlagSet) {
 
 	c.gf.ensureDockerDaemon()
 
-	err := os.MkdirAll(filepath.Dir(proj.dockerfilePath()), 0755)
+	// The lock is released when we exit.
+	_, err := proj.lock()
 	if err != nil {
-		flog.Fatal("failed to create intermediate dirs: %v", err)
+		xlog.Fatal("failed to lock project: %v", err)
+	}
+
+	err = os.MkdirAll(filepath.Dir(proj.dockerfilePath()), 0755)
+	if err != nil {
+		xlog.Fatal("failed to create intermediate dirs: %v", err)
 	}
 
 	// Create file if it doesn't already exist.
 	fi, err := os.OpenFile(proj.dockerfilePath(), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0640)
 	if err != nil && !os.IsExist(err) {
-		flog.Fatal("failed to open %v: %v", proj.dockerfilePath())
+		xlog.Fatal("failed to open %v: %v", proj.dockerfilePath())
 	} else if err == nil {
 		defer fi.Close()
 		// Provide a sensible default Dockerfile if the image hasn't been customized.
 		_, err = fi.WriteString("FROM codercom/ubuntu-dev\n")
 		if err != nil {
-			flog.Fatal("failed to write default Dockerfile: %v", err)
+			xlog.Fatal("failed to write default Dockerfile: %v", err)
 		}
 		err = fi.Close()
 		if err != nil {
-			flog.Fatal("failed to write default Dockerfile")
+			xlog.Fatal("failed to write default Dockerfile")
 		}
 	}
 
 	err = c.recreate(proj)
 	if err != nil {
-		flog.Fatal("%v", err)
+		xlog.Fatal("%v", err)
 	}
 	os.Exit(0)
 }
@@ -91,11 +100,25 @@ func (c *editcmd) recreate(proj *project) (err error) {
 	if err != nil {
 		return err
 	}
+	b.buildTimeout = proj.conf.timeouts(false).build
+	b.opts.buildArgs = proj.buildOpts.buildArgs
 
 	editFile := proj.dockerfilePath()
 	// If custom hat provided, use it.
 	if c.hatPath != "" {
 		b.hatPath = c.hatPath
+		// The arguments of the old hat don't apply to the new one.
+		b.args = nil
+	}
+	args, err := parseHatArgs(c.hatArgs)
+	if err != nil {
+		return err
+	}
+	if len(args) > 0 && b.args == nil {
+		b.args = make(map[string]string)
+	}
+	for k, v := range args {
+		b.args[k] = v
 	}
 
 	// If c.hat is set, then we want to edit the project's hat instead of the project's Dockerfile.
@@ -110,9 +133,9 @@ func (c *editcmd) recreate(proj *project) (err error) {
 		editFile = filepath.Join(hatPath, "Dockerfile")
 	}
 
-	// If we're just trying to change the underlying hat for the project, we don't want
-	// to prompt the user with the editor, instead just rebuild with the new hat.
-	if c.hatPath == "" || c.hat {
+	// If we're just trying to change the underlying hat for the project or its arguments,
+	// we don't want to prompt the user with the editor, instead just rebuild with the new hat.
+	if (c.hatPath == "" && len(c.hatArgs) == 0) || c.hat {
 		err = runEditor(editFile)
 		if err != nil {
 			return err
@@ -123,9 +146,16 @@ func (c *editcmd) recreate(proj *project) (err error) {
 	if err != nil {
 		return xerrors.Errorf("failed to initialize runner: %w", err)
 	}
+	if r.sandbox {
+		proj.sandboxBuild()
+		b.opts.sandbox = true
+		b.opts.buildArgs = proj.buildOpts.buildArgs
+	}
 
 	builderCntName := proj.cntName() + "-builder-" + randstr.Make(5)
 	r.cntName = builderCntName
+	r.timeouts = proj.conf.timeouts(false)
+	r.codeServer = proj.conf.codeServerSource()
 
 	image, ok, err := proj.buildImage()
 	if err != nil {
@@ -135,6 +165,8 @@ func (c *editcmd) recreate(proj *project) (err error) {
 	// to use the new base.
 	if ok {
 		b.baseImage = image
+		// The image was rebuilt, so its base images may have changed.
+		r.upstream = formatUpstream(proj.pinned)
 	}
 
 	// Apply the hat before we stop the original container in order to reduce the amount
@@ -146,28 +178,38 @@ func (c *editcmd) recreate(proj *project) (err error) {
 		}
 	}
 
-	// The base and hat images have been fully built, stop the original container to swap
-	// it with the new one.
-	err = cli.ContainerStop(ctx, proj.cntName(), dockutil.DurationPtr(time.Second))
+	// The base and hat images have been fully built, swap the original container
+	// with the new one.
+	return swapContainer(ctx, cli, proj.cntName(), r, image)
+}
+
+// swapContainer replaces the container named cntName with a new container
+// for image created by r, whose cntName must be a temporary name.
+// The original container is restored if anything fails.
+func swapContainer(ctx context.Context, cli *client.Client, cntName string, r *runner, image string) (err error) {
+	builderCntName := r.cntName
+
+	// Stop the original container to swap it with the new one.
+	err = cli.ContainerStop(ctx, cntName, dockutil.DurationPtr(time.Second))
 	if err != nil {
 		return err
 	}
 	defer func() {
 		if err != nil {
-			flog.Error("failed to build and run new container: %v", err)
-			flog.Info("rolling back...")
+			xlog.Error("failed to build and run new container: %v", err)
+			xlog.Info("rolling back...")
 
-			err := cli.ContainerStart(ctx, proj.cntName(), types.ContainerStartOptions{})
+			err := cli.ContainerStart(ctx, cntName, types.ContainerStartOptions{})
 			if err != nil {
-				flog.Fatal("failed to restart original container %v in rollback: %v", proj.cntName(), err)
+				xlog.Fatal("failed to restart original container %v in rollback: %v", cntName, err)
 			}
 		}
 	}()
 
 	// Rename OG container with a temporary name that we'll remove at the end if
 	// everything completes successfully.
-	oldCntName := proj.cntName() + "-old-" + randstr.Make(5)
-	err = cli.ContainerRename(ctx, proj.cntName(), oldCntName)
+	oldCntName := cntName + "-old-" + randstr.Make(5)
+	err = cli.ContainerRename(ctx, cntName, oldCntName)
 	if err != nil {
 		return xerrors.Errorf("failed to rename original container to %v: %w", oldCntName, err)
 	}
@@ -175,9 +217,9 @@ func (c *editcmd) recreate(proj *project) (err error) {
 		// Roll the container rename back if something failed, but remove the old container from
 		// the system if everything succeeded.
 		if err != nil {
-			err := cli.ContainerRename(ctx, oldCntName, proj.cntName())
+			err := cli.ContainerRename(ctx, oldCntName, cntName)
 			if err != nil {
-				flog.Fatal("failed to rename container from %v back to %v in rollback: %v", oldCntName, proj.cntName(), err)
+				xlog.Fatal("failed to rename container from %v back to %v in rollback: %v", oldCntName, cntName, err)
 			}
 		} else {
 			_ = dockutil.StopRemove(ctx, cli, oldCntName)
@@ -185,7 +227,12 @@ func (c *editcmd) recreate(proj *project) (err error) {
 	}()
 
 	// Start our new container and try to rename it to the project container name.
-	err = r.runContainer(image)
+	// Interrupting cancels the start, after which the deferred functions above
+	// roll back to the original container.
+	intCtx, intCancel := withInterrupt(ctx)
+	defer intCancel()
+
+	err = r.runContainer(intCtx, image)
 	if err != nil {
 		return err
 	}
@@ -193,17 +240,17 @@ func (c *editcmd) recreate(proj *project) (err error) {
 		if err != nil {
 			err := dockutil.StopRemove(ctx, cli, builderCntName)
 			if err != nil {
-				flog.Error("failed to stop remove builder container in rollback: %v", err)
+				xlog.Error("failed to stop remove builder container in rollback: %v", err)
 			}
 		}
 	}()
 
-	err = cli.ContainerRename(ctx, r.cntName, proj.cntName())
+	err = cli.ContainerRename(ctx, r.cntName, cntName)
 	if err != nil {
 		return xerrors.Errorf("failed to rename builder to project name: %w", err)
 	}
 
-	flog.Info("replaced container")
+	xlog.Info("replaced container")
 	return nil
 }
 
@@ -234,4 +281,5 @@ var _ cli.FlaggedCommand = new(editcmd)
 func (c *editcmd) RegisterFlags(fl *flag.FlagSet) {
 	fl.StringVar(&c.hatPath, "new-hat", "", "Path to new hat.")
 	fl.BoolVar(&c.hat, "hat", false, "Edit the hat associated with this project.")
+	fl.Var(&c.hatArgs, "hat-arg", "Value of a parameter of the hat, of form name=value. Can be repeated. Other parameters keep their values.")
 }
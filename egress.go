@@ -0,0 +1,324 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/strslice"
+	"golang.org/x/xerrors"
+
+	"go.coder.com/sail/internal/dockutil"
+)
+
+// Modes of egress policies.
+const (
+	// egressDeny only allows the destinations of the policy.
+	egressDeny = "deny"
+	// egressProxy only allows the host's proxy, which is forwarded into
+	// the container, and the destinations of the policy.
+	egressProxy = "proxy"
+)
+
+// Image labels restricting the outbound network of the container. The
+// egress config key takes precedence over them.
+const (
+	egressLabel      = sailLabel + ".egress"
+	egressAllowLabel = sailLabel + ".egress_allow"
+)
+
+// egressOfLabel names the container whose egress a sidecar enforces.
+const egressOfLabel = sailLabel + ".egress_of"
+
+// defaultEgressImage is the image of sidecars, which installs iptables.
+const defaultEgressImage = "alpine:3"
+
+// egressReadyPath is created in the sidecar once its rules are in place.
+const egressReadyPath = "/tmp/sail-egress-ready"
+
+// egressPolicy restricts the outbound network of an environment.
+type egressPolicy struct {
+	Mode string `toml:"mode"`
+	// Allow are the destinations that are allowed, of form host[:port],
+	// where host is a name, an IPv4 address or a CIDR.
+	Allow []string `toml:"allow"`
+}
+
+// restricted reports whether the policy restricts anything.
+func (p egressPolicy) restricted() bool {
+	return p.Mode != ""
+}
+
+// egressRule is a destination a policy allows.
+type egressRule struct {
+	host string
+	// port is any port if it's empty.
+	port string
+}
+
+// parseEgressRule parses an entry of egressPolicy.Allow.
+func parseEgressRule(s string) (egressRule, error) {
+	s = strings.TrimSpace(s)
+	rule := egressRule{host: s}
+	if strings.Contains(s, ":") {
+		host, port, err := net.SplitHostPort(s)
+		if err != nil {
+			return egressRule{}, xerrors.Errorf("invalid destination %q, must be of form host[:port]", s)
+		}
+		n, err := strconv.Atoi(port)
+		if err != nil || n < 1 || n > 65535 {
+			return egressRule{}, xerrors.Errorf("invalid port in destination %q", s)
+		}
+		rule = egressRule{host: host, port: port}
+	}
+	if rule.host == "" || strings.ContainsAny(rule.host, " '\"") {
+		return egressRule{}, xerrors.Errorf("invalid destination %q, must be of form host[:port]", s)
+	}
+	return rule, nil
+}
+
+// check validates the policy.
+func (p egressPolicy) check() error {
+	if p.Mode != "" && p.Mode != egressDeny && p.Mode != egressProxy {
+		return xerrors.Errorf("invalid mode %q, must be %v or %v", p.Mode, egressDeny, egressProxy)
+	}
+	if p.Mode == "" && len(p.Allow) > 0 {
+		return xerrors.Errorf("allow requires a mode")
+	}
+	for _, a := range p.Allow {
+		_, err := parseEgressRule(a)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkEgress validates the egress config key, whose policies are keyed by
+// glob patterns of project names.
+func checkEgress(policies map[string]egressPolicy) error {
+	for pattern, p := range policies {
+		_, err := path.Match(pattern, "")
+		if err != nil {
+			return xerrors.Errorf("invalid egress pattern %q: %w", pattern, err)
+		}
+		err = p.check()
+		if err != nil {
+			return xerrors.Errorf("egress of %v: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// egressOf returns the egress policy of the project name, from the first
+// pattern it matches in sorted order.
+func (c config) egressOf(name string) egressPolicy {
+	patterns := make([]string, 0, len(c.Egress))
+	for p := range c.Egress {
+		patterns = append(patterns, p)
+	}
+	sort.Strings(patterns)
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return c.Egress[p]
+		}
+	}
+	return egressPolicy{}
+}
+
+// labelEgress returns the egress policy of image labels.
+func labelEgress(labels map[string]string) egressPolicy {
+	p := egressPolicy{Mode: labels[egressLabel]}
+	for _, a := range strings.Split(labels[egressAllowLabel], ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			p.Allow = append(p.Allow, a)
+		}
+	}
+	return p
+}
+
+// rules returns the destinations the policy allows. Proxies are taken from
+// env, the host's proxy settings, of form KEY=VAL.
+func (p egressPolicy) rules(env []string) ([]egressRule, error) {
+	var rules []egressRule
+	for _, a := range p.Allow {
+		rule, err := parseEgressRule(a)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	if p.Mode != egressProxy {
+		return rules, nil
+	}
+
+	var proxies int
+	for _, kv := range env {
+		kv := strings.SplitN(kv, "=", 2)
+		if len(kv) != 2 || strings.ToUpper(kv[0]) == "NO_PROXY" {
+			continue
+		}
+		v := kv[1]
+		if !strings.Contains(v, "://") {
+			v = "http://" + v
+		}
+		u, err := url.Parse(v)
+		if err != nil || u.Hostname() == "" {
+			return nil, xerrors.Errorf("invalid proxy %v=%q", kv[0], kv[1])
+		}
+		port := u.Port()
+		if port == "" {
+			port = "80"
+			if u.Scheme == "https" {
+				port = "443"
+			}
+		}
+		rules = append(rules, egressRule{host: u.Hostname(), port: port})
+		proxies++
+	}
+	if proxies == 0 {
+		return nil, xerrors.New("egress mode proxy requires HTTPS_PROXY or HTTP_PROXY on the host")
+	}
+	return rules, nil
+}
+
+// egressScript returns the command of sidecars, which installs iptables and
+// drops all outbound traffic of the network namespace but that to rules and
+// the DNS servers. Names in rules are resolved once, by iptables.
+func egressScript(rules []egressRule) string {
+	var b strings.Builder
+	b.WriteString(`set -e
+command -v iptables >/dev/null || apk add --no-cache iptables ip6tables >/dev/null
+for t in iptables ip6tables; do
+$t -F OUTPUT
+$t -A OUTPUT -o lo -j ACCEPT
+$t -A OUTPUT -m conntrack --ctstate ESTABLISHED,RELATED -j ACCEPT
+$t -P OUTPUT DROP
+done
+for ns in $(awk '/^nameserver/ { print $2 }' /etc/resolv.conf); do
+case "$ns" in *:*) continue ;; esac
+iptables -A OUTPUT -d "$ns" -p udp --dport 53 -j ACCEPT
+iptables -A OUTPUT -d "$ns" -p tcp --dport 53 -j ACCEPT
+done
+`)
+	for _, r := range rules {
+		if r.port == "" {
+			fmt.Fprintf(&b, "iptables -A OUTPUT -d '%v' -j ACCEPT\n", r.host)
+			continue
+		}
+		fmt.Fprintf(&b, "iptables -A OUTPUT -d '%v' -p tcp --dport %v -j ACCEPT\n", r.host, r.port)
+	}
+	b.WriteString("touch " + egressReadyPath + `
+trap 'exit 0' TERM INT
+while true; do sleep 3600 & wait $!; done`)
+	return b.String()
+}
+
+// egressName returns the name of the sidecar of the container cntName.
+func egressName(cntName string) string {
+	return cntName + "-egress"
+}
+
+// applyEgress restricts the container's outbound network, if r.egress is
+// restricted. The container joins the network namespace of a sidecar, which
+// sets up its firewall with iptables, as the container's own root could undo
+// it. The container is unprivileged without NET_ADMIN and NET_RAW, so it
+// can't. The sidecar owns the network, so it gets the container's hostname,
+// hosts and published ports.
+func (r *runner) applyEgress(containerConfig *container.Config, hostConfig *container.HostConfig) (*container.Config, *container.HostConfig) {
+	if !r.egress.restricted() {
+		return nil, nil
+	}
+	containerConfig.Labels[egressLabel] = r.egress.Mode
+	if len(r.egress.Allow) > 0 {
+		containerConfig.Labels[egressAllowLabel] = strings.Join(r.egress.Allow, ",")
+	}
+	containerConfig.Labels[privilegedLabel] = "false"
+
+	sidecarConfig := &container.Config{
+		Hostname:     containerConfig.Hostname,
+		ExposedPorts: containerConfig.ExposedPorts,
+		Labels:       map[string]string{egressOfLabel: r.projectCntName()},
+	}
+	sidecarHostConfig := &container.HostConfig{
+		ExtraHosts:   hostConfig.ExtraHosts,
+		PortBindings: hostConfig.PortBindings,
+		CapAdd:       strslice.StrSlice{"NET_ADMIN", "NET_RAW"},
+		// The container can't start unless the sidecar runs.
+		RestartPolicy: container.RestartPolicy{Name: "unless-stopped"},
+	}
+
+	containerConfig.Hostname = ""
+	containerConfig.ExposedPorts = nil
+	hostConfig.ExtraHosts = nil
+	hostConfig.PortBindings = nil
+	hostConfig.NetworkMode = container.NetworkMode("container:" + egressName(r.projectCntName()))
+	hostConfig.Privileged = false
+	var caps strslice.StrSlice
+	for _, c := range hostConfig.CapAdd {
+		if c != "NET_ADMIN" && c != "NET_RAW" {
+			caps = append(caps, c)
+		}
+	}
+	hostConfig.CapAdd = caps
+	hostConfig.CapDrop = append(hostConfig.CapDrop, "NET_ADMIN", "NET_RAW")
+	return sidecarConfig, sidecarHostConfig
+}
+
+// createEgress creates and starts the sidecar enforcing r.egress, replacing
+// the one of a previous container, and waits until its rules are in place.
+func (r *runner) createEgress(ctx context.Context, containerConfig *container.Config, hostConfig *container.HostConfig, to timeouts) error {
+	cli := dockerClient()
+	defer cli.Close()
+
+	rules, err := r.egress.rules(proxyEnv())
+	if err != nil {
+		return err
+	}
+	image := r.egressImage
+	if image == "" {
+		image = defaultEgressImage
+	}
+	_, err = ensureImage(ctx, image, "")
+	if err != nil {
+		return xerrors.Errorf("failed to ensure egress image %v: %w", image, err)
+	}
+	containerConfig.Image = image
+	containerConfig.Cmd = strslice.StrSlice{"sh", "-c", egressScript(rules)}
+
+	name := egressName(r.projectCntName())
+	err = dockutil.RemovePartial(ctx, cli, name)
+	if err != nil {
+		return err
+	}
+	_, err = cli.ContainerCreate(ctx, containerConfig, hostConfig, nil, name)
+	if err != nil {
+		return xerrors.Errorf("failed to create egress container: %w", err)
+	}
+	err = cli.ContainerStart(ctx, name, types.ContainerStartOptions{})
+	if err != nil {
+		return xerrors.Errorf("failed to start egress container: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, to.create+to.start)
+	defer cancel()
+	for {
+		err = dockutil.Exec(name, "test", "-f", egressReadyPath).Run()
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return xerrors.Errorf("egress rules weren't set up, see docker logs %v", name)
+		case <-time.After(time.Millisecond * 200):
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseEgressRule(t *testing.T) {
+	for in, exp := range map[string]egressRule{
+		"github.com":      {host: "github.com"},
+		"github.com:443":  {host: "github.com", port: "443"},
+		"10.0.0.0/8":      {host: "10.0.0.0/8"},
+		"10.0.0.0/8:5432": {host: "10.0.0.0/8", port: "5432"},
+	} {
+		rule, err := parseEgressRule(in)
+		require.NoError(t, err, in)
+		assert.Equal(t, exp, rule, in)
+	}
+	for _, in := range []string{"", ":443", "github.com:https", "github.com:70000", "a b"} {
+		_, err := parseEgressRule(in)
+		assert.Error(t, err, in)
+	}
+}
+
+func Test_egressPolicy(t *testing.T) {
+	conf := config{Egress: map[string]egressPolicy{
+		"corp/*":    {Mode: egressProxy},
+		"corp/open": {Mode: egressDeny, Allow: []string{"github.com:443"}},
+	}}
+	require.NoError(t, checkEgress(conf.Egress))
+	assert.Equal(t, egressProxy, conf.egressOf("corp/secret").Mode)
+	assert.False(t, conf.egressOf("cdr/sail").restricted())
+
+	require.Error(t, checkEgress(map[string]egressPolicy{"corp/*": {Mode: "allow"}}))
+	require.Error(t, checkEgress(map[string]egressPolicy{"corp/*": {Allow: []string{"github.com"}}}))
+
+	p := labelEgress(map[string]string{egressLabel: egressDeny, egressAllowLabel: "github.com:443, 10.0.0.0/8"})
+	assert.Equal(t, egressPolicy{Mode: egressDeny, Allow: []string{"github.com:443", "10.0.0.0/8"}}, p)
+
+	rules, err := egressPolicy{Mode: egressProxy}.rules([]string{"HTTPS_PROXY=proxy.corp:3128", "NO_PROXY=localhost"})
+	require.NoError(t, err)
+	assert.Equal(t, []egressRule{{host: "proxy.corp", port: "3128"}}, rules)
+	_, err = egressPolicy{Mode: egressProxy}.rules(nil)
+	require.Error(t, err)
+
+	script := egressScript([]egressRule{{host: "github.com", port: "443"}, {host: "10.0.0.0/8"}})
+	assert.Contains(t, script, "iptables -A OUTPUT -d 'github.com' -p tcp --dport 443 -j ACCEPT\n")
+	assert.Contains(t, script, "iptables -A OUTPUT -d '10.0.0.0/8' -j ACCEPT\n")
+	assert.Contains(t, script, "$t -P OUTPUT DROP")
+}
+
+func Test_applyEgress(t *testing.T) {
+	r := &runner{
+		cntName:  "cdr_sail",
+		hostname: "sail",
+		port:     "8000",
+		egress:   egressPolicy{Mode: egressDeny},
+		desktop:  true,
+	}
+	cnt := &container.Config{Labels: map[string]string{}, Hostname: "sail"}
+	hostConfig, err := r.hostConfig(cnt, nil)
+	require.NoError(t, err)
+
+	require.NotNil(t, r.sidecarConfig)
+	assert.Equal(t, "sail", r.sidecarConfig.Hostname)
+	assert.NotEmpty(t, r.sidecarHostConfig.PortBindings)
+	assert.Equal(t, []string{"sail:127.0.0.1"}, r.sidecarHostConfig.ExtraHosts)
+
+	assert.Equal(t, container.NetworkMode("container:cdr_sail-egress"), hostConfig.NetworkMode)
+	assert.Empty(t, cnt.Hostname)
+	assert.Empty(t, hostConfig.PortBindings)
+	assert.Empty(t, hostConfig.ExtraHosts)
+	assert.False(t, hostConfig.Privileged)
+	assert.Contains(t, []string(hostConfig.CapDrop), "NET_ADMIN")
+	assert.Equal(t, egressDeny, cnt.Labels[egressLabel])
+}
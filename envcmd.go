@@ -0,0 +1,352 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"golang.org/x/xerrors"
+
+	"go.coder.com/cli"
+	"go.coder.com/sail/internal/xlog"
+)
+
+// envFile is the file in a container's directory of the host that keeps the
+// environment variables set with sail env.
+const envFile = "env.json"
+
+// envPath is the file that keeps the environment variables set with sail env.
+func envPath(cntName string) string {
+	return filepath.Join(metaRoot(), cntName, envFile)
+}
+
+// loadEnv loads the environment variables set for the container named cntName.
+func loadEnv(cntName string) ([]string, error) {
+	b, err := ioutil.ReadFile(envPath(cntName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var env []string
+	err = json.Unmarshal(b, &env)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to decode %v: %w", envPath(cntName), err)
+	}
+	return env, nil
+}
+
+func saveEnv(cntName string, env []string) error {
+	b, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+
+	path := envPath(cntName)
+	err = os.MkdirAll(filepath.Dir(path), 0750)
+	if err != nil {
+		return err
+	}
+	// The environment often holds tokens.
+	return writeFileAtomic(path, b, 0600)
+}
+
+var envKeyRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// parseEnvVar parses an environment variable of the form KEY=VAL.
+func parseEnvVar(v string) (key, val string, _ error) {
+	toks := strings.SplitN(v, "=", 2)
+	if len(toks) != 2 {
+		return "", "", xerrors.Errorf("invalid variable %q, must be of form KEY=VAL", v)
+	}
+	if !envKeyRegex.MatchString(toks[0]) {
+		return "", "", xerrors.Errorf("invalid variable name %q", toks[0])
+	}
+	return toks[0], toks[1], nil
+}
+
+// envKeys returns the names of the environment variables in env.
+func envKeys(env []string) []string {
+	keys := make([]string, 0, len(env))
+	for _, v := range env {
+		keys = append(keys, strings.SplitN(v, "=", 2)[0])
+	}
+	return keys
+}
+
+// setEnv returns env with the variables in vars set, replacing earlier values.
+func setEnv(env []string, vars ...string) []string {
+	for _, v := range vars {
+		env = unsetEnv(env, envKeys([]string{v})...)
+		env = append(env, v)
+	}
+	sort.Strings(env)
+	return env
+}
+
+// unsetEnv returns env without the variables named keys.
+func unsetEnv(env []string, keys ...string) []string {
+	var out []string
+	for _, v := range env {
+		if !stringsContain(keys, envKeys([]string{v})[0]) {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func stringsContain(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// envScript returns the script sourced before code-server starts.
+// Variables the container was created with are unset unless they're still in env.
+func envScript(createdKeys []string, env []string) string {
+	var b strings.Builder
+	keys := envKeys(env)
+	for _, k := range createdKeys {
+		if k != "" && !stringsContain(keys, k) {
+			fmt.Fprintf(&b, "unset %v\n", k)
+		}
+	}
+	for _, v := range env {
+		toks := strings.SplitN(v, "=", 2)
+		fmt.Fprintf(&b, "export %v='%v'\n", toks[0], strings.Replace(toks[1], "'", `'\''`, -1))
+	}
+	return b.String()
+}
+
+type envcmd struct {
+	gf *globalFlags
+}
+
+func (c *envcmd) Spec() cli.CommandSpec {
+	return cli.CommandSpec{
+		Name:  "env",
+		Usage: "[set|unset|ls]",
+		Desc: `Manages the environment variables of a project's code-server.
+
+Changing a variable restarts code-server in the project's container, without
+recreating the container.`,
+	}
+}
+
+func (c *envcmd) Run(fl *flag.FlagSet) {
+	fl.Usage()
+	os.Exit(1)
+}
+
+func (c *envcmd) Subcommands() []cli.Command {
+	return []cli.Command{
+		&envSetCmd{gf: c.gf},
+		&envUnsetCmd{gf: c.gf},
+		&envLsCmd{gf: c.gf},
+	}
+}
+
+type envSetCmd struct {
+	gf *globalFlags
+}
+
+func (c *envSetCmd) Spec() cli.CommandSpec {
+	return cli.CommandSpec{
+		Name:  "set",
+		Usage: "<repo> <KEY=VAL>...",
+		Desc:  "Sets environment variables and restarts code-server to apply them.",
+	}
+}
+
+func (c *envSetCmd) Run(fl *flag.FlagSet) {
+	if fl.NArg() < 2 {
+		fl.Usage()
+		os.Exit(1)
+	}
+	vars := fl.Args()[1:]
+	for _, v := range vars {
+		_, _, err := parseEnvVar(v)
+		if err != nil {
+			xlog.Fatal("%v", err)
+		}
+	}
+
+	proj := projectArg(c.gf, fl)
+	env, err := loadEnv(proj.cntName())
+	if err != nil {
+		xlog.Fatal("%v", err)
+	}
+	updateEnv(c.gf, proj, setEnv(env, vars...))
+}
+
+type envUnsetCmd struct {
+	gf *globalFlags
+}
+
+func (c *envUnsetCmd) Spec() cli.CommandSpec {
+	return cli.CommandSpec{
+		Name:  "unset",
+		Usage: "<repo> <KEY>...",
+		Desc:  "Unsets environment variables and restarts code-server to apply them.",
+	}
+}
+
+func (c *envUnsetCmd) Run(fl *flag.FlagSet) {
+	if fl.NArg() < 2 {
+		fl.Usage()
+		os.Exit(1)
+	}
+
+	proj := projectArg(c.gf, fl)
+	env, err := loadEnv(proj.cntName())
+	if err != nil {
+		xlog.Fatal("%v", err)
+	}
+	updateEnv(c.gf, proj, unsetEnv(env, fl.Args()[1:]...))
+}
+
+type envLsCmd struct {
+	gf *globalFlags
+}
+
+func (c *envLsCmd) Spec() cli.CommandSpec {
+	return cli.CommandSpec{
+		Name:  "ls",
+		Usage: "<repo>",
+		Desc:  "Lists the environment variables set with sail env set.",
+	}
+}
+
+func (c *envLsCmd) Run(fl *flag.FlagSet) {
+	if fl.NArg() != 1 {
+		fl.Usage()
+		os.Exit(1)
+	}
+
+	proj := projectArg(c.gf, fl)
+	env, err := loadEnv(proj.cntName())
+	if err != nil {
+		xlog.Fatal("%v", err)
+	}
+	for _, v := range env {
+		fmt.Println(v)
+	}
+}
+
+// updateEnv saves env for the project and applies it to its container.
+func updateEnv(gf *globalFlags, proj *project, env []string) {
+	err := saveEnv(proj.cntName(), env)
+	if err != nil {
+		xlog.Fatal("failed to save environment: %v", err)
+	}
+
+	gf.ensureDockerDaemon()
+	exists, err := proj.cntExists()
+	if err != nil {
+		xlog.Fatal("%v", err)
+	}
+	if !exists {
+		xlog.Success("saved the environment, it's used once %v is created with sail run", proj.pathName())
+		return
+	}
+
+	applied, err := applyEnv(proj.cntName(), env)
+	if err != nil {
+		xlog.Fatal("%v", err)
+	}
+	if !applied {
+		// Windows containers and containers of older versions of sail
+		// don't source the environment file.
+		xlog.Info("%v can't change its environment in place, recreate it with sail run --rebuild to use the new environment", proj.pathName())
+		return
+	}
+	xlog.Success("updated the environment of %v", proj.pathName())
+}
+
+// containerEnvPath returns the path of the environment file of the container
+// cnt, or "" if its launcher doesn't source one.
+func containerEnvPath(cnt types.ContainerJSON) string {
+	cmd := strings.Join(cnt.Config.Cmd, " ")
+	for _, p := range []string{resolvePath(guestHome(cnt.Config.Labels), codeServerEnvPath), legacyCodeServerEnvPath} {
+		if strings.Contains(cmd, p) {
+			return p
+		}
+	}
+	return ""
+}
+
+// applyEnv writes env into the container named cntName and restarts code-server
+// if it's running. applied is false if the container doesn't support it.
+func applyEnv(cntName string, env []string) (applied bool, _ error) {
+	cli := dockerClient()
+	defer cli.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	cnt, err := inspectContainer(ctx, cli, cntName)
+	if err != nil {
+		return false, err
+	}
+	envPath := containerEnvPath(cnt)
+	if envPath == "" {
+		return false, nil
+	}
+
+	script := envScript(strings.Split(cnt.Config.Labels[envKeysLabel], ","), env)
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	err = tw.WriteHeader(&tar.Header{
+		Name:    path.Base(envPath),
+		Mode:    0600,
+		Size:    int64(len(script)),
+		ModTime: time.Now(),
+	})
+	if err != nil {
+		return false, err
+	}
+	_, err = tw.Write([]byte(script))
+	if err != nil {
+		return false, err
+	}
+	err = tw.Close()
+	if err != nil {
+		return false, err
+	}
+
+	// Copying works whether or not the container is running. The file is
+	// owned by the container's user, who's the only one reading it.
+	err = cli.CopyToContainer(ctx, cntName, path.Dir(envPath), &buf, types.CopyToContainerOptions{
+		CopyUIDGID: true,
+	})
+	if err != nil {
+		return false, xerrors.Errorf("failed to copy environment into container: %w", err)
+	}
+
+	if !cnt.State.Running {
+		return true, nil
+	}
+	xlog.Info("restarting code-server")
+	_, err = restartCodeServer(ctx, cli, cntName, false)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseEnvVar(t *testing.T) {
+	k, v, err := parseEnvVar("TOKEN=a=b")
+	require.NoError(t, err)
+	assert.Equal(t, "TOKEN", k)
+	assert.Equal(t, "a=b", v)
+
+	for _, v := range []string{"TOKEN", "1TOKEN=a", "TO-KEN=a", "=a"} {
+		_, _, err := parseEnvVar(v)
+		assert.Error(t, err, v)
+	}
+}
+
+func Test_setEnv(t *testing.T) {
+	env := setEnv(nil, "B=1", "A=1")
+	assert.Equal(t, []string{"A=1", "B=1"}, env)
+
+	env = setEnv(env, "B=2")
+	assert.Equal(t, []string{"A=1", "B=2"}, env)
+
+	env = unsetEnv(env, "A", "C")
+	assert.Equal(t, []string{"B=2"}, env)
+}
+
+func Test_envScript(t *testing.T) {
+	script := envScript([]string{"OLD", "KEPT"}, []string{"KEPT=1", "QUOTED=it's"})
+	assert.Equal(t, "unset OLD\nexport KEPT='1'\nexport QUOTED='it'\\''s'\n", script)
+
+	assert.Equal(t, "", envScript([]string{""}, nil))
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"golang.org/x/xerrors"
+
+	"go.coder.com/sail/internal/xlog"
+)
+
+// ephemeralLabel marks environments created with sail run --ephemeral,
+// which are removed once they expire, like review environments.
+const ephemeralLabel = sailLabel + ".ephemeral"
+
+// defaultEphemeralTTL is how long ephemeral and review environments are kept
+// after they were last stopped, unless ephemeral_ttl says otherwise.
+const defaultEphemeralTTL = 72 * time.Hour
+
+// ephemeralGCInterval is how often sail daemon removes expired environments.
+const ephemeralGCInterval = time.Hour
+
+// existingLabels returns the labels of the container cntName, or nil if it
+// doesn't exist.
+func existingLabels(cntName string) map[string]string {
+	cli := dockerClient()
+	defer cli.Close()
+
+	cnt, err := inspectContainer(context.Background(), cli, cntName)
+	if err != nil {
+		return nil
+	}
+	return cnt.Config.Labels
+}
+
+// ephemeralTTL returns how long stopped ephemeral environments are kept.
+func (c config) ephemeralTTL() time.Duration {
+	if c.EphemeralTTL == 0 {
+		return defaultEphemeralTTL
+	}
+	return time.Duration(c.EphemeralTTL)
+}
+
+// isEphemeral reports whether the container with labels is removed once it
+// expires.
+func isEphemeral(labels map[string]string) bool {
+	return labels[ephemeralLabel] == "true" || labels[reviewLabel] != ""
+}
+
+// ephemeralExpiry returns why the ephemeral environment cnt expired, or ""
+// if it hasn't. prState is the state of the pull request it reviews, if
+// any. Running environments are in use, so they never expire.
+func ephemeralExpiry(cnt types.ContainerJSON, prState string, ttl time.Duration, now time.Time) string {
+	if cnt.State != nil && cnt.State.Running {
+		return ""
+	}
+	switch prState {
+	case prMerged, prClosed:
+		return fmt.Sprintf("%v was %v", cnt.Config.Labels[reviewLabel], prState)
+	}
+
+	// Containers that never ran expire from their creation.
+	stopped, _ := time.Parse(time.RFC3339Nano, cnt.Created)
+	if cnt.State != nil {
+		finished, err := time.Parse(time.RFC3339Nano, cnt.State.FinishedAt)
+		if err == nil && finished.After(stopped) {
+			stopped = finished
+		}
+	}
+	if stopped.IsZero() || now.Sub(stopped) < ttl {
+		return ""
+	}
+	return fmt.Sprintf("it was stopped for more than %v", ttl)
+}
+
+// reviewState returns the state of the pull request the container with labels
+// reviews, or "" if it doesn't review one.
+func reviewState(ctx context.Context, conf config, labels map[string]string) (string, error) {
+	review := labels[reviewLabel]
+	if review == "" {
+		return "", nil
+	}
+	path, number, err := parseReviewArg(review)
+	if err != nil {
+		return "", err
+	}
+	u, err := url.Parse(labels[repoLabel])
+	if err != nil || u.Hostname() == "" {
+		return "", xerrors.Errorf("the host of %v isn't known", review)
+	}
+	host := u.Hostname()
+	pr, err := fetchPullRequest(ctx, conf.GitAuth[host].provider(host), host, path, number)
+	if err != nil {
+		return "", err
+	}
+	return pr.state, nil
+}
+
+// expiredEnvironment is an ephemeral environment that expired.
+type expiredEnvironment struct {
+	cntName string
+	reason  string
+	// reviewDir is the checkout of a review environment, removed with it.
+	reviewDir string
+}
+
+// expiredEnvironments returns the ephemeral and review environments that
+// expired, because the pull request they review was merged or closed, or they
+// were stopped for longer than the config's ephemeral_ttl.
+func expiredEnvironments(ctx context.Context, conf config) ([]expiredEnvironment, error) {
+	cnts, err := listContainers()
+	if err != nil {
+		return nil, xerrors.Errorf("failed to list containers: %w", err)
+	}
+	cli := dockerClient()
+	defer cli.Close()
+
+	var expired []expiredEnvironment
+	for _, summary := range cnts {
+		if !isEphemeral(summary.Labels) {
+			continue
+		}
+		name := trimDockerName(summary)
+		cnt, err := inspectContainer(ctx, cli, name)
+		if err != nil {
+			return nil, xerrors.Errorf("failed to inspect %v: %w", name, err)
+		}
+
+		state, err := reviewState(ctx, conf, cnt.Config.Labels)
+		if err != nil {
+			// The TTL still applies.
+			xlog.Warn("failed to get the state of %v: %v", cnt.Config.Labels[reviewLabel], err)
+		}
+		reason := ephemeralExpiry(cnt, state, conf.ephemeralTTL(), time.Now())
+		if reason == "" {
+			continue
+		}
+		env := expiredEnvironment{cntName: name, reason: reason}
+		if cnt.Config.Labels[reviewLabel] != "" {
+			env.reviewDir = cnt.Config.Labels[projectLocalDirLabel]
+		}
+		expired = append(expired, env)
+	}
+
+	external, err := expiredExternal(ctx, conf)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to list environments of other backends: %w", err)
+	}
+	return append(expired, external...), nil
+}
+
+// removeExpired removes the ephemeral environments that expired. With
+// dryRun, it only reports them.
+func removeExpired(ctx context.Context, conf config, dryRun bool) error {
+	expired, err := expiredEnvironments(ctx, conf)
+	if err != nil {
+		return err
+	}
+	cli := dockerClient()
+	defer cli.Close()
+
+	var failed bool
+	for _, env := range expired {
+		name := toSailName(env.cntName)
+		if dryRun {
+			fmt.Printf("would remove %v, %v\n", name, env.reason)
+			continue
+		}
+		err := removeContainer(ctx, cli, env.cntName)
+		if err != nil {
+			xlog.Error("%v", err)
+			failed = true
+			continue
+		}
+		if env.reviewDir != "" {
+			removeReviewDir(env.reviewDir)
+		}
+		xlog.Success("removed %v, %v", name, env.reason)
+	}
+	if failed {
+		return xerrors.New("failed to remove some environments")
+	}
+	return nil
+}
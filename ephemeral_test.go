@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ephemeralExpiry(t *testing.T) {
+	now := time.Date(2019, 8, 1, 12, 0, 0, 0, time.UTC)
+	cnt := func(running bool, created, finished time.Time) types.ContainerJSON {
+		return types.ContainerJSON{
+			ContainerJSONBase: &types.ContainerJSONBase{
+				Created: created.Format(time.RFC3339Nano),
+				State: &types.ContainerState{
+					Running:    running,
+					FinishedAt: finished.Format(time.RFC3339Nano),
+				},
+			},
+			Config: &container.Config{Labels: map[string]string{reviewLabel: "cdr/sail#123"}},
+		}
+	}
+	ttl := 72 * time.Hour
+	week := now.Add(-7 * 24 * time.Hour)
+
+	assert.Equal(t, "cdr/sail#123 was merged", ephemeralExpiry(cnt(false, now, time.Time{}), prMerged, ttl, now))
+	assert.Equal(t, "it was stopped for more than 72h0m0s", ephemeralExpiry(cnt(false, week, week), prOpen, ttl, now))
+	// Environments that were used recently are kept.
+	assert.Empty(t, ephemeralExpiry(cnt(false, week, now.Add(-time.Hour)), prOpen, ttl, now))
+	// Running environments are in use.
+	assert.Empty(t, ephemeralExpiry(cnt(true, week, week), prClosed, ttl, now))
+	// Environments that never ran expire from their creation.
+	assert.NotEmpty(t, ephemeralExpiry(cnt(false, week, time.Time{}), "", ttl, now))
+
+	assert.True(t, isEphemeral(map[string]string{ephemeralLabel: "true"}))
+	assert.True(t, isEphemeral(map[string]string{reviewLabel: "cdr/sail#123"}))
+	assert.False(t, isEphemeral(map[string]string{nameLabel: "cdr/sail"}))
+}
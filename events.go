@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"golang.org/x/xerrors"
+
+	"go.coder.com/sail/internal/xlog"
+)
+
+// Sources of events.
+const (
+	eventSourceDocker = "docker"
+	eventSourceSail   = "sail"
+)
+
+// Sail lifecycle events. Docker events use the action reported by Docker,
+// e.g. start, die or destroy.
+const (
+	// eventBuilding is emitted when sail starts building a project's
+	// environment.
+	eventBuilding = "building"
+	// eventOnline is emitted once code-server is reachable.
+	eventOnline = "online"
+	// eventFailed is emitted when sail fails to bring up an environment.
+	eventFailed = "failed"
+	// eventRemoved is emitted when sail removes an environment.
+	eventRemoved = "removed"
+	// eventIdle is emitted when an idle environment is stopped.
+	eventIdle = "idle"
+	// eventShared is emitted when an environment is shared with sail share.
+	eventShared = "shared"
+	// eventPrebuilt is emitted when sail prebuild rebuilt a project's image.
+	eventPrebuilt = "prebuilt"
+)
+
+// event is an event of sail events.
+type event struct {
+	Time   time.Time `json:"time"`
+	Source string    `json:"source"`
+	Type   string    `json:"type"`
+	// Project is the sail name of the project, e.g. cdr/sail.
+	Project    string            `json:"project,omitempty"`
+	Container  string            `json:"container"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+// eventLogPath is the file sail appends its lifecycle events to.
+func eventLogPath() string {
+	return filepath.Join(metaRoot(), "events.jsonl")
+}
+
+// maxEventLogSize is the size at which the event log is rotated.
+const maxEventLogSize = 1 << 20
+
+// emitEvent records a sail lifecycle event for sail events to pick up.
+// Failing to record an event never fails the operation, so errors are only logged.
+func emitEvent(typ, cntName string, attrs map[string]string) {
+	err := appendEvent(eventLogPath(), event{
+		Time:       time.Now(),
+		Source:     eventSourceSail,
+		Type:       typ,
+		Project:    toSailName(cntName),
+		Container:  cntName,
+		Attributes: attrs,
+	})
+	if err != nil {
+		xlog.Error("failed to record %v event: %v", typ, err)
+	}
+}
+
+func appendEvent(path string, ev event) error {
+	fi, err := os.Stat(path)
+	if err == nil && fi.Size() > maxEventLogSize {
+		// Readers reopen the log once it's replaced.
+		err = os.Rename(path, path+".old")
+		if err != nil {
+			return err
+		}
+	}
+
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0750)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// A single append of the whole line keeps concurrent writers from
+	// interleaving.
+	_, err = f.Write(append(b, '\n'))
+	return err
+}
+
+// tailEvents sends the events appended to the event log at path to evs
+// until ctx is done. Events already in the log are skipped, ready is closed
+// once they are, unless it's nil.
+func tailEvents(ctx context.Context, path string, evs chan<- event, ready chan<- struct{}) error {
+	var (
+		fi     *os.File
+		rd     *bufio.Reader
+		offset int64
+	)
+	defer func() {
+		if fi != nil {
+			fi.Close()
+		}
+	}()
+
+	// open opens the log, skipping to its end if skip is set.
+	open := func(skip bool) error {
+		if fi != nil {
+			fi.Close()
+			fi = nil
+		}
+		f, err := os.Open(path)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		offset = 0
+		if skip {
+			offset, err = f.Seek(0, io.SeekEnd)
+			if err != nil {
+				f.Close()
+				return err
+			}
+		}
+		fi = f
+		rd = bufio.NewReader(f)
+		return nil
+	}
+
+	err := open(true)
+	if err != nil {
+		return err
+	}
+	if ready != nil {
+		close(ready)
+	}
+
+	ticker := time.NewTicker(time.Millisecond * 250)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		// Reopen the log if it was created, rotated or truncated.
+		st, err := os.Stat(path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err == nil {
+			var cur os.FileInfo
+			if fi != nil {
+				cur, _ = fi.Stat()
+			}
+			if cur == nil || !os.SameFile(cur, st) || st.Size() < offset {
+				// New logs are read from the start.
+				err = open(false)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		if fi == nil {
+			continue
+		}
+
+		for {
+			line, err := rd.ReadBytes('\n')
+			if err == io.EOF {
+				// Partially written lines are read again on the next tick.
+				_, err = fi.Seek(offset, io.SeekStart)
+				if err != nil {
+					return err
+				}
+				rd.Reset(fi)
+				break
+			}
+			if err != nil {
+				return xerrors.Errorf("failed to read %v: %w", path, err)
+			}
+			offset += int64(len(line))
+
+			var ev event
+			err = json.Unmarshal(line, &ev)
+			if err != nil {
+				xlog.Error("invalid event %q: %v", line, err)
+				continue
+			}
+			select {
+			case evs <- ev:
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	}
+}
+
+// dockerEvents sends the events of sail containers to evs until ctx is done.
+func dockerEvents(ctx context.Context, evs chan<- event) error {
+	cli := dockerClient()
+	defer cli.Close()
+
+	filter := filters.NewArgs()
+	filter.Add("type", "container")
+	filter.Add("label", sailLabel)
+
+	msgs, errs := cli.Events(ctx, types.EventsOptions{Filters: filter})
+	for {
+		select {
+		case msg := <-msgs:
+			select {
+			case evs <- newDockerEvent(msg):
+			case <-ctx.Done():
+				return nil
+			}
+		case err := <-errs:
+			if ctx.Err() != nil {
+				return nil
+			}
+			return xerrors.Errorf("failed to watch docker events: %w", err)
+		}
+	}
+}
+
+func newDockerEvent(msg events.Message) event {
+	attrs := msg.Actor.Attributes
+	ev := event{
+		Time:      time.Unix(0, msg.TimeNano),
+		Source:    eventSourceDocker,
+		Type:      msg.Action,
+		Container: attrs["name"],
+		Project:   attrs[nameLabel],
+	}
+	if ev.Project == "" {
+		ev.Project = toSailName(ev.Container)
+	}
+	// A non-zero exit code on die means the environment crashed.
+	if code, ok := attrs["exitCode"]; ok {
+		ev.Attributes = map[string]string{"exit_code": code}
+	}
+	return ev
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/events"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_tailEvents(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sail-events")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "events.jsonl")
+	old := event{Source: eventSourceSail, Type: eventOnline, Container: "cdr_old"}
+	require.NoError(t, appendEvent(path, old))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	evs := make(chan event)
+	ready := make(chan struct{})
+	go tailEvents(ctx, path, evs, ready)
+
+	next := func() event {
+		select {
+		case ev := <-evs:
+			return ev
+		case <-ctx.Done():
+			t.Fatal("timed out waiting for event")
+			return event{}
+		}
+	}
+
+	select {
+	case <-ready:
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for the existing events to be skipped")
+	}
+	want := event{Source: eventSourceSail, Type: eventBuilding, Container: "cdr_sail"}
+	require.NoError(t, appendEvent(path, want))
+	require.Equal(t, want, next())
+
+	// Rotated logs are followed.
+	require.NoError(t, os.Rename(path, path+".old"))
+	want.Type = eventRemoved
+	require.NoError(t, appendEvent(path, want))
+	require.Equal(t, want, next())
+}
+
+func Test_newDockerEvent(t *testing.T) {
+	ev := newDockerEvent(events.Message{
+		Action: "die",
+		Actor: events.Actor{
+			Attributes: map[string]string{
+				"name":     "cdr_sail",
+				"exitCode": "137",
+			},
+		},
+		TimeNano: time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC).UnixNano(),
+	})
+	require.Equal(t, "die", ev.Type)
+	require.Equal(t, eventSourceDocker, ev.Source)
+	require.Equal(t, "cdr/sail", ev.Project)
+	require.Equal(t, "137", ev.Attributes["exit_code"])
+}
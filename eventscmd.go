@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"os"
+
+	"go.coder.com/cli"
+	"go.coder.com/sail/internal/xlog"
+)
+
+type eventscmd struct {
+	gf *globalFlags
+}
+
+func (c *eventscmd) Spec() cli.CommandSpec {
+	return cli.CommandSpec{
+		Name: "events",
+		Desc: `Streams the events of sail environments as JSON, one per line.
+
+Docker events of sail containers, such as start, die and destroy, are
+included along with sail's own building, online, failed, removed, idle, shared and prebuilt events.`,
+	}
+}
+
+func (c *eventscmd) RegisterFlags(fl *flag.FlagSet) {}
+
+func (c *eventscmd) Run(fl *flag.FlagSet) {
+	c.gf.ensureDockerDaemon()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	evs := make(chan event)
+	errs := make(chan error, 2)
+	go func() {
+		errs <- dockerEvents(ctx, evs)
+	}()
+	go func() {
+		errs <- tailEvents(ctx, eventLogPath(), evs, nil)
+	}()
+
+	enc := json.NewEncoder(os.Stdout)
+	for {
+		select {
+		case ev := <-evs:
+			err := enc.Encode(ev)
+			if err != nil {
+				xlog.Fatal("failed to write event: %v", err)
+			}
+		case err := <-errs:
+			xlog.Fatal("%v", err)
+		}
+	}
+}
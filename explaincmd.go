@@ -0,0 +1,328 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/docker/docker/api/types/mount"
+
+	"go.coder.com/cli"
+	"go.coder.com/sail/internal/xlog"
+)
+
+type explaincmd struct {
+	gf *globalFlags
+
+	run runcmd
+}
+
+func (c *explaincmd) Spec() cli.CommandSpec {
+	return cli.CommandSpec{
+		Name:  "explain",
+		Usage: "[flags] <repo>",
+		Desc: `Shows the settings a project's container is created with, and where each comes from.
+
+Settings come from sail's defaults, the config, the project's repo, the image's
+labels and environment, the host, sail mount, sail env and flags, which change
+the container like they do for sail run.
+
+Images are only inspected, nothing is built, pulled or created.`,
+	}
+}
+
+func (c *explaincmd) RegisterFlags(fl *flag.FlagSet) {
+	c.run.registerContainerFlags(fl)
+}
+
+func (c *explaincmd) Run(fl *flag.FlagSet) {
+	c.run.gf = c.gf
+	proj := c.run.project(fl)
+	c.gf.ensureDockerDaemon()
+
+	defined, err := definedConfigKeys(c.gf.configPath)
+	if err != nil {
+		xlog.Fatal("%v", err)
+	}
+
+	image, err := c.run.plannedImage(proj)
+	if err != nil {
+		xlog.Fatal("%v", err)
+	}
+	cli := dockerClient()
+	defer cli.Close()
+	img, _, err := cli.ImageInspectWithRaw(context.Background(), image)
+	if err != nil {
+		xlog.Fatal("failed to inspect %v: %v", image, err)
+	}
+
+	r, err := c.run.runner(proj, false)
+	if err != nil {
+		xlog.Fatal("%v", err)
+	}
+	r.dryRun = true
+	cntConfig, hostConfig, err := r.containerSpec(image)
+	if err != nil {
+		xlog.Fatal("failed to assemble container: %v", err)
+	}
+
+	e := &explanation{defined: defined}
+
+	base, source := c.run.baseImage(proj)
+	if source == imageFromConfig {
+		source = e.configSource("default_image")
+	}
+	e.add("image", base, source)
+	switch {
+	case c.run.hat != "":
+		e.add("hat", c.run.hat, "flag --hat")
+	case proj.conf.DefaultHat != "":
+		e.add("hat", proj.conf.DefaultHat, e.configSource("default_hat"))
+	}
+	hatArgs := c.run.hatBuilder(proj, base).args
+	for _, k := range sortedKeys(hatArgs) {
+		source := "config hat_args"
+		if _, ok := c.run.hatArgs[k]; ok {
+			source = "flag --hat-arg"
+		}
+		e.add("hat arg "+k, hatArgs[k], source)
+	}
+	if image != base {
+		e.add("hat image", image, "hat")
+	}
+	switch {
+	case c.run.platform != "":
+		e.add("platform", c.run.platform, "flag --platform")
+	case proj.conf.Platform != "":
+		e.add("platform", proj.conf.Platform, e.configSource("platform"))
+	}
+
+	e.add("docker context", c.gf.dockerContext, c.gf.dockerContextSource)
+	e.add("project dir", proj.localDir(), e.configSource("project_root"))
+	if c.run.readOnly {
+		e.add("project read-only", "true", "flag --read-only")
+	}
+	if c.run.overlay {
+		e.add("project overlay", overlayDir(r.cntName), "flag --overlay")
+	}
+	if img.Config.Labels[projectRootLabel] != "" {
+		e.add("container project dir", cntConfig.Labels[projectDirLabel], "image label "+projectRootLabel)
+	} else {
+		e.add("container project dir", cntConfig.Labels[projectDirLabel], "default")
+	}
+
+	switch {
+	case c.run.user != "":
+		e.add("user", c.run.user, "flag --user")
+	case proj.conf.User != "":
+		e.add("user", proj.conf.User, e.configSource("user"))
+	case cntConfig.Labels[rootlessLabel] == "true":
+		e.add("user", rootlessUser, "rootless daemon")
+	case img.Config.Labels[guestUserLabel] != "":
+		e.add("user", img.Config.Labels[guestUserLabel], "image label "+guestUserLabel)
+	case img.Config.User != "":
+		e.add("user", img.Config.User, "image")
+	}
+	for _, g := range proj.conf.GroupAdd {
+		e.add("group", g, e.configSource("group_add"))
+	}
+	for _, g := range c.run.groupAdd {
+		e.add("group", g, "flag --group-add")
+	}
+	switch {
+	case c.run.usernsMode != "":
+		e.add("userns", c.run.usernsMode, "flag --userns")
+	case proj.conf.UsernsMode != "":
+		e.add("userns", proj.conf.UsernsMode, e.configSource("userns_mode"))
+	}
+
+	var rightsLabels []string
+	for _, l := range []string{capAddLabel, seccompLabel, apparmorLabel} {
+		if _, ok := img.Config.Labels[l]; ok {
+			rightsLabels = append(rightsLabels, "image label "+l)
+		}
+	}
+	if len(rightsLabels) > 0 {
+		e.add("rights", describeRights(cntConfig.Labels), strings.Join(rightsLabels, ", "))
+	} else {
+		e.add("rights", describeRights(cntConfig.Labels), "default")
+	}
+
+	dockerSource := ""
+	if r.shareDocker {
+		dockerSource = "image label " + shareDockerLabel
+		if c.run.docker {
+			dockerSource = "flag --docker"
+		}
+		e.add("docker socket", hostDockerSocket(), dockerSource)
+	}
+	flagDevices, err := parseDevices(c.run.devices)
+	if err != nil {
+		xlog.Fatal("%v", err)
+	}
+	for _, d := range hostConfig.Devices {
+		source := "image label " + devicesLabel
+		for _, fd := range flagDevices {
+			if fd.PathInContainer == d.PathInContainer {
+				source = "flag --device"
+			}
+		}
+		e.add("device", deviceSpec(d), source)
+	}
+	for _, p := range r.forwardPorts {
+		e.add("forwarded port", strings.TrimSpace(p), "image label "+forwardPortsLabel)
+	}
+	if cmd := img.Config.Labels[onStartLabel]; cmd != "" {
+		e.add("on_start", cmd, "image label "+onStartLabel)
+	}
+	if len(r.wrapper) > 0 {
+		e.add("command", strings.Join(r.wrapper, " "), "image label "+cmdLabel)
+	}
+
+	to := proj.conf.timeouts(false)
+	e.add("create timeout", to.create.String(), e.configSource("create_timeout"))
+	e.add("start timeout", to.start.String(), e.configSource("start_timeout"))
+	e.add("pull timeout", to.pull.String(), e.configSource("pull_timeout"))
+	e.add("build timeout", to.build.String(), e.configSource("build_timeout"))
+	idle := "never"
+	if proj.conf.IdleTimeout > 0 {
+		idle = time.Duration(proj.conf.IdleTimeout).String()
+	}
+	e.add("idle timeout", idle, e.configSource("idle_timeout"))
+
+	origins := mountOrigins(r, cntConfig.Labels[projectDirLabel], img.Config.Labels, dockerSource)
+	cntMounts, _ := hostMounts(hostConfig)
+	for _, m := range cntMounts {
+		source, ok := origins[m.Target]
+		if !ok {
+			source = "sail"
+		}
+		e.add("mount "+m.Target, m.Source, source)
+	}
+
+	e.rows = append(e.rows, envRows(img.Config.Env, r.environment(nil), r.env)...)
+
+	e.write(os.Stdout)
+}
+
+// explainRow is a setting, its value, and where it came from.
+type explainRow struct {
+	setting string
+	value   string
+	source  string
+}
+
+// explanation collects the rows printed by sail explain.
+type explanation struct {
+	// defined are the keys set in the config.
+	defined map[string]bool
+	rows    []explainRow
+}
+
+func (e *explanation) add(setting, value, source string) {
+	e.rows = append(e.rows, explainRow{setting: setting, value: value, source: source})
+}
+
+// configSource describes the source of the config key.
+func (e *explanation) configSource(key string) string {
+	if e.defined[key] {
+		return "config " + key
+	}
+	return "default"
+}
+
+func (e *explanation) write(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(tw, "SETTING\tVALUE\tSOURCE")
+	for _, r := range e.rows {
+		// Values such as on_start commands can span lines.
+		value := strings.Replace(r.value, "\n", `\n`, -1)
+		fmt.Fprintf(tw, "%v\t%v\t%v\n", r.setting, value, r.source)
+	}
+	tw.Flush()
+}
+
+// mountOrigins returns where the mounts of r that weren't added by sail
+// itself came from, by their resolved target. dockerSource is where sharing
+// the Docker socket was requested, if it was.
+func mountOrigins(r *runner, projectDir string, imgLabels map[string]string, dockerSource string) map[string]string {
+	origins := map[string]string{
+		resolvePath(r.guestHome(), projectDir):      "project",
+		resolvePath(r.guestHome(), "~/.hat"):        "hat",
+		"/tmp/.X11-unix":                            "host DISPLAY",
+		hostLocaltime:                               "host time zone",
+		resolvePath(r.guestHome(), "~/.Xauthority"): "host XAUTHORITY",
+	}
+	if sock, ok := os.LookupEnv("SSH_AUTH_SOCK"); ok {
+		origins[sock] = "host SSH_AUTH_SOCK"
+	}
+	if dockerSource != "" {
+		origins[containerDockerSocket] = dockerSource
+	}
+	if imgLabels[nixStoreLabel] != "" {
+		origins["/nix"] = "image label " + nixStoreLabel
+	}
+
+	for _, k := range shareLabels(imgLabels) {
+		m, err := parseShareLabel(k, imgLabels[k])
+		if err != nil || r.expandMount(&m) != nil || resolveMount(&m, r.guestHome()) != nil {
+			continue
+		}
+		shares := []mount.Mount{m}
+		if isGlob(m.Source) {
+			shares, _ = globMounts(m)
+		}
+		for _, m := range shares {
+			origins[m.Target] = "image label " + k
+		}
+	}
+	for _, spec := range r.extraMounts {
+		m, err := parseMount(spec)
+		if err != nil || r.expandMount(&m) != nil {
+			continue
+		}
+		origins[resolvePath(r.guestHome(), m.Target)] = "sail mount"
+	}
+	return origins
+}
+
+// envRows returns the environment of the container, with variables of the
+// image overridden by those forwarded from the host, which are overridden by
+// those set with sail env.
+func envRows(imgEnv, hostEnv, sailEnv []string) []explainRow {
+	var (
+		keys    []string
+		rows    = make(map[string]explainRow)
+		sources = []struct {
+			env    []string
+			source string
+		}{
+			{imgEnv, "image"},
+			{hostEnv, "host"},
+			{sailEnv, "sail env"},
+		}
+	)
+	for _, s := range sources {
+		for _, v := range s.env {
+			toks := strings.SplitN(v, "=", 2)
+			if len(toks) != 2 {
+				continue
+			}
+			if _, ok := rows[toks[0]]; !ok {
+				keys = append(keys, toks[0])
+			}
+			rows[toks[0]] = explainRow{setting: "env " + toks[0], value: toks[1], source: s.source}
+		}
+	}
+
+	out := make([]explainRow, 0, len(keys))
+	for _, k := range keys {
+		out = append(out, rows[k])
+	}
+	return out
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_envRows(t *testing.T) {
+	rows := envRows(
+		[]string{"PATH=/usr/bin", "GOPATH=/home/user/go", "INVALID"},
+		[]string{"DISPLAY=:0"},
+		[]string{"GOPATH=/go", "TOKEN=a=b"},
+	)
+	assert.Equal(t, []explainRow{
+		{setting: "env PATH", value: "/usr/bin", source: "image"},
+		{setting: "env GOPATH", value: "/go", source: "sail env"},
+		{setting: "env DISPLAY", value: ":0", source: "host"},
+		{setting: "env TOKEN", value: "a=b", source: "sail env"},
+	}, rows)
+}
+
+func Test_mountOrigins(t *testing.T) {
+	r := &runner{extraMounts: []string{"~/data:~/data"}}
+	origins := mountOrigins(r, "~/sail", map[string]string{
+		shareLabelPrefix + "go": "~/go:~/go",
+		nixStoreLabel:           nixStoreVolume,
+	}, "flag --docker")
+
+	assert.Equal(t, "project", origins["/home/user/sail"])
+	assert.Equal(t, "image label "+shareLabelPrefix+"go", origins["/home/user/go"])
+	assert.Equal(t, "sail mount", origins["/home/user/data"])
+	assert.Equal(t, "image label "+nixStoreLabel, origins["/nix"])
+	assert.Equal(t, "flag --docker", origins[containerDockerSocket])
+	_, ok := origins["/usr/bin/code-server"]
+	assert.False(t, ok)
+}
+
+func Test_explanation(t *testing.T) {
+	e := &explanation{defined: map[string]bool{"user": true}}
+	assert.Equal(t, "config user", e.configSource("user"))
+	assert.Equal(t, "default", e.configSource("userns_mode"))
+
+	e.add("user", "1000", e.configSource("user"))
+	e.add("on_start", "make\nmake test", "image label on_start")
+
+	var buf bytes.Buffer
+	e.write(&buf)
+	assert.Equal(t, `SETTING    VALUE             SOURCE
+user       1000              config user
+on_start   make\nmake test   image label on_start
+`, buf.String())
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"go.coder.com/cli"
+	"go.coder.com/sail/internal/devcontainer"
+	"go.coder.com/sail/internal/dockutil"
+	"go.coder.com/sail/internal/xlog"
+)
+
+type exportcmd struct {
+	gf *globalFlags
+
+	format string
+	out    string
+	force  bool
+}
+
+func (c *exportcmd) Spec() cli.CommandSpec {
+	return cli.CommandSpec{
+		Name:  "export",
+		Usage: "[flags] <repo>",
+		Desc: `Exports a project's environment so it can be used without sail.
+
+With --format=devcontainer, a .devcontainer directory with a devcontainer.json
+and Dockerfile is written for VS Code's Remote Containers extension.
+With --format=dockerfile, a standalone Dockerfile is written to stdout.
+
+The project must be running. Hats are left out as they're personal.`,
+	}
+}
+
+func (c *exportcmd) RegisterFlags(fl *flag.FlagSet) {
+	fl.StringVar(&c.format, "format", "devcontainer", "Export format, one of devcontainer or dockerfile.")
+	fl.StringVar(&c.out, "out", "", "Where to write the export. Defaults to ./.devcontainer for devcontainer, and stdout for dockerfile.")
+	fl.BoolVar(&c.force, "force", false, "Overwrite existing files.")
+}
+
+func (c *exportcmd) Run(fl *flag.FlagSet) {
+	proj := c.gf.project(schemaPrefs{}, fl)
+	c.gf.ensureDockerDaemon()
+
+	env, err := exportEnvironment(proj.cntName())
+	if err != nil {
+		xlog.Fatal("failed to export %v: %v", proj.pathName(), err)
+	}
+
+	switch c.format {
+	case "dockerfile":
+		if c.out == "" {
+			os.Stdout.Write(env.dockerfile())
+			return
+		}
+		err = c.writeFile(c.out, env.dockerfile())
+	case "devcontainer":
+		out := c.out
+		if out == "" {
+			out = ".devcontainer"
+		}
+		err = c.writeDevcontainer(out, env)
+	default:
+		xlog.Fatal("unknown format %q, must be devcontainer or dockerfile", c.format)
+	}
+	if err != nil {
+		xlog.Fatal("%v", err)
+	}
+}
+
+func (c *exportcmd) writeDevcontainer(dir string, env *exportedEnv) error {
+	conf, err := env.devcontainer()
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return xerrors.Errorf("failed to create %v: %w", dir, err)
+	}
+	err = c.writeFile(filepath.Join(dir, "Dockerfile"), env.dockerfile())
+	if err != nil {
+		return err
+	}
+	return c.writeFile(filepath.Join(dir, "devcontainer.json"), conf)
+}
+
+func (c *exportcmd) writeFile(path string, b []byte) error {
+	if !c.force {
+		_, err := os.Stat(path)
+		if err == nil {
+			return xerrors.Errorf("%v already exists, use --force to overwrite it", path)
+		}
+	}
+
+	err := ioutil.WriteFile(path, b, 0644)
+	if err != nil {
+		return xerrors.Errorf("failed to write %v: %w", path, err)
+	}
+	xlog.Info("wrote %v", path)
+	return nil
+}
+
+// stateLabels are the labels sail uses to track a container,
+// they're meaningless outside of it.
+var stateLabels = []string{
+	sailLabel,
+	baseImageLabel,
+	bootstrapLabel,
+	createdSourcesLabel,
+	dockerSocketLabel,
+	dockerfileHashLabel,
+	ephemeralLabel,
+	hatLabel,
+	hatArgsLabel,
+	nameLabel,
+	ownerLabel,
+	projectLocalDirLabel,
+	projectDirLabel,
+	projectNameLabel,
+	proxyURLLabel,
+	repoLabel,
+	reviewLabel,
+	schemaLabel,
+}
+
+// hostEnv are the environment variables sail forwards from the host, along
+// with the LC_* of its locale.
+var hostEnv = []string{"SSH_AUTH_SOCK", "DISPLAY", "WAYLAND_DISPLAY", "XAUTHORITY", "TZ", "LANG", "LANGUAGE"}
+
+// exportedEnv is the effective configuration of a project's environment.
+type exportedEnv struct {
+	name       string
+	image      string
+	projectDir string
+	// labels are the configuration labels of the environment.
+	labels map[string]string
+	// env are the variables set on the container in addition to the image's.
+	env        map[string]string
+	extensions []string
+}
+
+// exportEnvironment reads the effective configuration of the container cntName.
+func exportEnvironment(cntName string) (*exportedEnv, error) {
+	cli := dockerClient()
+	defer cli.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	cnt, err := inspectContainer(ctx, cli, cntName)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to inspect %v: %w", cntName, err)
+	}
+
+	e := &exportedEnv{
+		name:       cnt.Config.Labels[nameLabel],
+		image:      sourceImage(cnt),
+		projectDir: resolvePath(guestHome(cnt.Config.Labels), cnt.Config.Labels[projectDirLabel]),
+		labels:     make(map[string]string),
+		env:        make(map[string]string),
+	}
+	if e.name == "" {
+		e.name = cnt.Config.Labels[projectNameLabel]
+	}
+	// Hats are personal, so we export the project image underneath.
+	if cnt.Config.Labels[hatLabel] != "" {
+		e.image = cnt.Config.Labels[baseImageLabel]
+	}
+
+	img, _, err := cli.ImageInspectWithRaw(ctx, e.image)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to inspect %v: %w", e.image, err)
+	}
+	if len(img.RepoDigests) == 0 {
+		xlog.Warn("%v was built locally, push it to a registry so others can use the export", e.image)
+	}
+
+	for k, v := range img.Config.Labels {
+		e.labels[k] = v
+	}
+	for _, k := range stateLabels {
+		delete(e.labels, k)
+	}
+	// Settings from sail run flags become labels.
+	if cnt.Config.Labels[dockerSocketLabel] == "true" {
+		e.labels[shareDockerLabel] = "true"
+	}
+	if len(cnt.HostConfig.Devices) > 0 {
+		var devices []string
+		for _, d := range cnt.HostConfig.Devices {
+			devices = append(devices, deviceSpec(d))
+		}
+		e.labels[devicesLabel] = strings.Join(devices, ",")
+	}
+
+	e.env = exportedVars(img.Config.Env, cnt.Config.Env, cnt.Config.Labels)
+
+	out, err := dockutil.FmtExec(cntName, "ls -1 ~/.vscode/extensions").CombinedOutput()
+	if err != nil {
+		return nil, xerrors.Errorf("failed to list extensions: %w\n%s", err, out)
+	}
+	e.extensions = extensionIDs(strings.Fields(string(out)))
+
+	return e, nil
+}
+
+// exportedVars returns the variables of the container's env cntEnv that the
+// image's env imgEnv doesn't set. Those of the host, including its proxy
+// settings, and those set with sail env, which often hold tokens, aren't
+// exported.
+func exportedVars(imgEnv, cntEnv []string, labels map[string]string) map[string]string {
+	skip := make(map[string]bool)
+	for _, k := range strings.Split(labels[envKeysLabel], ",") {
+		skip[k] = true
+	}
+	for _, k := range proxyEnvVars {
+		skip[k] = true
+	}
+
+	vars := make(map[string]string)
+	for _, kv := range cntEnv {
+		if stringsContain(imgEnv, kv) {
+			continue
+		}
+		sp := strings.SplitN(kv, "=", 2)
+		if len(sp) != 2 || skip[sp[0]] || isHostEnv(sp[0]) {
+			continue
+		}
+		vars[sp[0]] = sp[1]
+	}
+	return vars
+}
+
+func isHostEnv(name string) bool {
+	if strings.HasPrefix(name, "LC_") {
+		return true
+	}
+	for _, n := range hostEnv {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// extensionVersionRegex matches the version suffix of an extension's directory.
+var extensionVersionRegex = regexp.MustCompile(`-\d+\.\d+\.\d+.*$`)
+
+// extensionIDs converts extension directory names like ms-vscode.go-0.11.4
+// into extension IDs.
+func extensionIDs(dirs []string) []string {
+	var ids []string
+	for _, d := range dirs {
+		if !strings.Contains(d, ".") {
+			continue
+		}
+		ids = append(ids, extensionVersionRegex.ReplaceAllString(d, ""))
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// dockerfile renders the environment as a standalone Dockerfile.
+func (e *exportedEnv) dockerfile() []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "# Exported from the sail project %v.\n", e.name)
+	fmt.Fprintf(&b, "FROM %v\n", e.image)
+
+	for _, k := range sortedKeys(e.labels) {
+		fmt.Fprintf(&b, "LABEL %v=%v\n", strconv.Quote(k), strconv.Quote(e.labels[k]))
+	}
+	for _, k := range sortedKeys(e.env) {
+		fmt.Fprintf(&b, "ENV %v=%v\n", k, strconv.Quote(e.env[k]))
+	}
+	if len(e.extensions) > 0 {
+		fmt.Fprintf(&b, "\n# Extensions: %v\n", strings.Join(e.extensions, ", "))
+	}
+	return b.Bytes()
+}
+
+// devcontainer renders the environment as a devcontainer.json that builds
+// the Dockerfile next to it.
+func (e *exportedEnv) devcontainer() ([]byte, error) {
+	conf := devcontainer.Config{
+		Name: e.name,
+		Build: &devcontainer.Build{
+			Dockerfile: "Dockerfile",
+		},
+		WorkspaceFolder: e.projectDir,
+		WorkspaceMount: devcontainer.Mount{
+			Type:   "bind",
+			Source: "${localWorkspaceFolder}",
+			Target: e.projectDir,
+		}.String(),
+		ContainerEnv: e.env,
+		Extensions:   e.extensions,
+	}
+
+	for _, k := range shareLabels(e.labels) {
+		m, err := parseShareLabel(k, e.labels[k])
+		if err != nil {
+			return nil, err
+		}
+		if isGlob(m.Source) {
+			xlog.Warn("%v: devcontainers can't mount host path patterns, it isn't exported", k)
+			continue
+		}
+		vars := map[string]string{
+			templateProject:       e.name,
+			templateContainerName: toDockerName(e.name),
+			templateHome:          "${localEnv:HOME}",
+		}
+		source := expandTemplate(m.Source, vars)
+		if source == "~" || strings.HasPrefix(source, "~/") {
+			source = "${localEnv:HOME}" + source[1:]
+		}
+		vars[templateHome] = guestHome(e.labels)
+		conf.Mounts = append(conf.Mounts, devcontainer.Mount{
+			Type:   "bind",
+			Source: source,
+			Target: resolvePath(guestHome(e.labels), expandTemplate(m.Target, vars)),
+		}.String())
+	}
+
+	if ports := e.labels[forwardPortsLabel]; ports != "" {
+		for _, p := range strings.Split(ports, ",") {
+			port, err := strconv.Atoi(strings.TrimSpace(p))
+			if err != nil {
+				return nil, xerrors.Errorf("invalid %v label %q: %w", forwardPortsLabel, ports, err)
+			}
+			conf.ForwardPorts = append(conf.ForwardPorts, port)
+		}
+	}
+
+	if cmd := e.labels[onCreateCmdLabel]; cmd != "" {
+		conf.PostCreateCommand, _ = json.Marshal(cmd)
+	}
+
+	b, err := json.MarshalIndent(conf, "", "\t")
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
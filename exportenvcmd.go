@@ -0,0 +1,265 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"flag"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"golang.org/x/xerrors"
+
+	"go.coder.com/cli"
+	"go.coder.com/sail/internal/xlog"
+)
+
+// The entries of an environment archive. The manifest comes first, so the
+// project is known before the rest is extracted.
+const (
+	envManifestEntry = "manifest.json"
+	envImageEntry    = "image.tar"
+	envBundleEntry   = "project.bundle"
+)
+
+// envManifest describes the environment of an archive written by
+// sail export-env.
+type envManifest struct {
+	Name string `json:"name"`
+	// Remote is the URI the project was cloned from, if any.
+	Remote string `json:"remote,omitempty"`
+	// Origin is the origin of the project's git repository, if any.
+	Origin    string `json:"origin,omitempty"`
+	Container string `json:"container"`
+	// Image is the committed container in image.tar.
+	Image   string            `json:"image"`
+	Labels  map[string]string `json:"labels"`
+	Devices []string          `json:"devices,omitempty"`
+	Created time.Time         `json:"created"`
+}
+
+type exportenvcmd struct {
+	gf *globalFlags
+
+	out   string
+	force bool
+}
+
+func (c *exportenvcmd) Spec() cli.CommandSpec {
+	return cli.CommandSpec{
+		Name:  "export-env",
+		Usage: "[flags] <repo>",
+		Desc: `Exports a project's entire environment to an archive, to move it to another
+machine with sail import-env or keep a snapshot of it.
+
+The archive holds the container committed to an image, its labels, the editor's
+state, environment variables and mounts, and a git bundle of the project's
+branches. Uncommitted changes to the project aren't exported.`,
+	}
+}
+
+func (c *exportenvcmd) RegisterFlags(fl *flag.FlagSet) {
+	fl.StringVar(&c.out, "out", "", "Where to write the archive. Defaults to ./<org>-<name>.sail.tar.gz.")
+	fl.BoolVar(&c.force, "force", false, "Overwrite an existing archive.")
+}
+
+func (c *exportenvcmd) Run(fl *flag.FlagSet) {
+	proj := c.gf.project(schemaPrefs{}, fl)
+	c.gf.ensureDockerDaemon()
+
+	out := c.out
+	if out == "" {
+		out = envArchiveName(proj.pathName())
+	}
+	if !c.force {
+		_, err := os.Stat(out)
+		if err == nil {
+			xlog.Fatal("%v already exists, use --force to overwrite it", out)
+		}
+	}
+
+	ctx, cancel := withInterrupt(context.Background())
+	defer cancel()
+
+	err := exportEnvArchive(ctx, proj, out)
+	if err != nil {
+		os.Remove(out)
+		xlog.Fatal("failed to export %v: %v", proj.pathName(), err)
+	}
+	xlog.Success("exported %v to %v", proj.pathName(), out)
+}
+
+// envArchiveName returns the default name of the archive of the project name.
+func envArchiveName(name string) string {
+	return strings.Replace(name, "/", "-", -1) + ".sail.tar.gz"
+}
+
+// exportEnvArchive writes the environment of proj as a gzipped tarball to
+// dst.
+func exportEnvArchive(ctx context.Context, proj *project, dst string) error {
+	cli := dockerClient()
+	defer cli.Close()
+
+	cnt, err := inspectContainer(ctx, cli, proj.cntName())
+	if err != nil {
+		return xerrors.Errorf("%v doesn't exist, create it with sail run: %w", proj.pathName(), err)
+	}
+	m := envManifest{
+		Name:      proj.pathName(),
+		Remote:    cnt.Config.Labels[repoLabel],
+		Container: proj.cntName(),
+		Image:     proj.imageName() + ":export",
+		Labels:    cnt.Config.Labels,
+		Created:   time.Now(),
+	}
+	for _, dev := range cnt.HostConfig.Devices {
+		m.Devices = append(m.Devices, deviceSpec(dev))
+	}
+
+	tmp, err := ioutil.TempDir("", "sail-export")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	xlog.Info("committing %v to %v", proj.pathName(), m.Image)
+	err = commitContainer(ctx, proj.cntName(), m.Image)
+	if err != nil {
+		return err
+	}
+	// The image only needs to exist in the archive.
+	defer cli.ImageRemove(context.Background(), m.Image, types.ImageRemoveOptions{})
+
+	xlog.Info("saving %v", m.Image)
+	out, err := exec.CommandContext(ctx, "docker", "save", "-o", filepath.Join(tmp, envImageEntry), m.Image).CombinedOutput()
+	if err != nil {
+		return xerrors.Errorf("failed to save %v: %s: %w", m.Image, out, err)
+	}
+
+	m.Origin, err = bundleProject(ctx, proj.localDir(), filepath.Join(tmp, envBundleEntry))
+	if err != nil {
+		return err
+	}
+
+	fi, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer fi.Close()
+	err = writeEnvArchive(fi, tmp, m)
+	if err != nil {
+		return err
+	}
+	return fi.Close()
+}
+
+// bundleProject writes the branches of the git repository dir to the file
+// bundle, and returns the repository's origin. Nothing is written if dir
+// isn't a git repository, as it can't be bundled.
+func bundleProject(ctx context.Context, dir, bundle string) (origin string, _ error) {
+	_, err := os.Stat(filepath.Join(dir, ".git"))
+	if err != nil {
+		xlog.Warn("%v isn't a git repository, its files aren't exported", dir)
+		return "", nil
+	}
+
+	out, err := exec.CommandContext(ctx, "git", "-C", dir, "status", "--porcelain").Output()
+	if err == nil && len(out) > 0 {
+		xlog.Warn("%v has uncommitted changes, they aren't exported", dir)
+	}
+
+	xlog.Info("bundling %v", dir)
+	out, err = exec.CommandContext(ctx, "git", "-C", dir, "bundle", "create", bundle, "--all").CombinedOutput()
+	if err != nil {
+		return "", xerrors.Errorf("failed to bundle %v: %s: %w", dir, out, err)
+	}
+
+	out, err = exec.CommandContext(ctx, "git", "-C", dir, "remote", "get-url", "origin").Output()
+	if err != nil {
+		// The repository has no origin.
+		return "", nil
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// writeEnvArchive writes the manifest m, the image and git bundle in dir,
+// and the state sail keeps on the host for the container as a gzipped
+// tarball to w.
+func writeEnvArchive(w io.Writer, dir string, m envManifest) error {
+	manifest, err := json.MarshalIndent(m, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	err = tw.WriteHeader(&tar.Header{
+		Name: envManifestEntry,
+		Mode: 0600,
+		Size: int64(len(manifest)),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = tw.Write(manifest)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range []string{envImageEntry, envBundleEntry} {
+		err = tarPath(tw, dir, name)
+		if err != nil {
+			return err
+		}
+	}
+	for _, name := range syncFiles(true) {
+		err = tarPath(tw, metaRoot(), path.Join(m.Container, name))
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tw.Close()
+	if err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+// importMetaPath returns where the entry name of the state of the container
+// cntName in an archive is extracted to, for the container dstCnt.
+func importMetaPath(root, name, cntName, dstCnt string) (string, error) {
+	toks := strings.SplitN(path.Clean(name), "/", 3)
+	if len(toks) < 2 || toks[0] != cntName || !stringsContain(syncFiles(true), toks[1]) {
+		return "", xerrors.Errorf("unexpected file %q in archive", name)
+	}
+	return filepath.Join(append([]string{root, dstCnt}, toks[1:]...)...), nil
+}
+
+// readEnvManifest reads the manifest at the start of the archive tr.
+func readEnvManifest(tr *tar.Reader) (*envManifest, error) {
+	hdr, err := tr.Next()
+	if err != nil {
+		return nil, xerrors.Errorf("failed to read archive: %w", err)
+	}
+	if hdr.Name != envManifestEntry {
+		return nil, xerrors.Errorf("not an environment archive, it doesn't start with %v", envManifestEntry)
+	}
+	var m envManifest
+	err = json.NewDecoder(tr).Decode(&m)
+	if err != nil {
+		return nil, xerrors.Errorf("invalid %v: %w", envManifestEntry, err)
+	}
+	if m.Name == "" || m.Container == "" || m.Image == "" {
+		return nil, xerrors.Errorf("invalid %v: missing name, container or image", envManifestEntry)
+	}
+	return &m, nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_envArchive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sail-export")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, envImageEntry), []byte("image"), 0600))
+
+	m := envManifest{
+		Name:      "cdr/sail",
+		Remote:    "https://github.com/cdr/sail",
+		Container: "cdr_sail-export-test",
+		Image:     "cdr/sail:export",
+	}
+	var buf bytes.Buffer
+	require.NoError(t, writeEnvArchive(&buf, dir, m))
+
+	gr, err := gzip.NewReader(&buf)
+	require.NoError(t, err)
+	tr := tar.NewReader(gr)
+	got, err := readEnvManifest(tr)
+	require.NoError(t, err)
+	assert.Equal(t, m.Name, got.Name)
+	assert.Equal(t, m.Image, got.Image)
+
+	hdr, err := tr.Next()
+	require.NoError(t, err)
+	assert.Equal(t, envImageEntry, hdr.Name)
+
+	assert.Equal(t, "cdr-sail.sail.tar.gz", envArchiveName("cdr/sail"))
+}
+
+func Test_importMetaPath(t *testing.T) {
+	p, err := importMetaPath("/meta", "cdr_sail/globalStorage/state.vscdb", "cdr_sail", "cdr_sail2")
+	require.NoError(t, err)
+	assert.Equal(t, "/meta/cdr_sail2/globalStorage/state.vscdb", p)
+
+	p, err = importMetaPath("/meta", "cdr_sail/env.json", "cdr_sail", "cdr_sail")
+	require.NoError(t, err)
+	assert.Equal(t, "/meta/cdr_sail/env.json", p)
+
+	for _, name := range []string{"cdr_sail/ports.json", "other/env.json", "../cdr_sail/env.json", "cdr_sail"} {
+		_, err = importMetaPath("/meta", name, "cdr_sail", "cdr_sail")
+		assert.Error(t, err, name)
+	}
+}
+
+func Test_bundleProject(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git isn't installed")
+	}
+	tmp, err := ioutil.TempDir("", "sail-bundle")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmp)
+
+	src := filepath.Join(tmp, "src")
+	git := func(dir string, args ...string) {
+		out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).CombinedOutput()
+		require.NoError(t, err, "%s", out)
+	}
+	require.NoError(t, os.MkdirAll(src, 0750))
+	git(src, "init", "--quiet")
+	require.NoError(t, ioutil.WriteFile(filepath.Join(src, "README.md"), []byte("sail"), 0644))
+	git(src, "add", "README.md")
+	git(src, "-c", "user.name=sail", "-c", "user.email=sail@coder.com", "commit", "--quiet", "-m", "init")
+	git(src, "remote", "add", "origin", "https://github.com/cdr/sail")
+
+	ctx := context.Background()
+	bundle := filepath.Join(tmp, envBundleEntry)
+	origin, err := bundleProject(ctx, src, bundle)
+	require.NoError(t, err)
+	assert.Equal(t, "https://github.com/cdr/sail", origin)
+
+	f, err := os.Open(bundle)
+	require.NoError(t, err)
+	defer f.Close()
+	dst := filepath.Join(tmp, "dst")
+	require.NoError(t, cloneBundle(ctx, f, dst, origin))
+
+	b, err := ioutil.ReadFile(filepath.Join(dst, "README.md"))
+	require.NoError(t, err)
+	assert.Equal(t, "sail", string(b))
+	out, err := exec.Command("git", "-C", dst, "remote", "get-url", "origin").Output()
+	require.NoError(t, err)
+	assert.Equal(t, "https://github.com/cdr/sail\n", string(out))
+}
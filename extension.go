@@ -20,30 +20,34 @@ import (
 	"nhooyr.io/websocket/wsjson"
 
 	"go.coder.com/cli"
-	"go.coder.com/flog"
+	"go.coder.com/sail/internal/randstr"
+	"go.coder.com/sail/internal/xlog"
 )
 
 func runNativeMsgHost() {
 	l, err := net.Listen("tcp", "localhost:0")
 	if err != nil {
-		flog.Fatal("failed to listen: %v", err)
+		xlog.Fatal("failed to listen: %v", err)
 	}
 	defer l.Close()
 
 	url := "http://" + l.Addr().String()
 
+	api := &localAPI{
+		token: randstr.Make(32),
+	}
+
 	err = writeNativeHostMessage(struct {
-		URL string `json:"url"`
-	}{url})
+		URL     string `json:"url"`
+		Token   string `json:"token"`
+		Version int    `json:"version"`
+	}{url, api.token, localAPIVersion})
 	if err != nil {
-		flog.Fatal("%v", err)
+		xlog.Fatal("%v", err)
 	}
 
-	m := http.NewServeMux()
-	m.HandleFunc("/api/v1/run", handleRun)
-
-	err = http.Serve(l, m)
-	flog.Fatal("failed to serve: %v", err)
+	err = http.Serve(l, api.handler())
+	xlog.Fatal("failed to serve: %v", err)
 }
 
 func writeNativeHostMessage(v interface{}) error {
@@ -105,28 +109,28 @@ This allows the sail extension to manage sail.`,
 func (c *installExtHostCmd) Run(fl *flag.FlagSet) {
 	binPath, err := os.Executable()
 	if err != nil {
-		flog.Fatal("failed to get sail binary location")
+		xlog.Fatal("failed to get sail binary location")
 	}
 
 	nativeHostDirsChrome, err := nativeMessageHostManifestDirectoriesChrome()
 	if err != nil {
-		flog.Fatal("failed to get chrome native message host manifest directory: %v", err)
+		xlog.Fatal("failed to get chrome native message host manifest directory: %v", err)
 	}
 	err = installManifests(nativeHostDirsChrome, "com.coder.sail.json", chromeManifest(binPath))
 	if err != nil {
-		flog.Fatal("failed to write chrome manifest files: %v", err)
+		xlog.Fatal("failed to write chrome manifest files: %v", err)
 	}
 
 	nativeHostDirsFirefox, err := nativeMessageHostManifestDirectoriesFirefox()
 	if err != nil {
-		flog.Fatal("failed to get firefox native message host manifest directory: %v", err)
+		xlog.Fatal("failed to get firefox native message host manifest directory: %v", err)
 	}
 	err = installManifests(nativeHostDirsFirefox, "com.coder.sail.json", firefoxManifest(binPath))
 	if err != nil {
-		flog.Fatal("failed to write firefox manifest files: %v", err)
+		xlog.Fatal("failed to write firefox manifest files: %v", err)
 	}
 
-	flog.Info("Successfully installed manifests.")
+	xlog.Info("Successfully installed manifests.")
 }
 
 func nativeMessageHostManifestDirectoriesChrome() ([]string, error) {
@@ -5,23 +5,32 @@ import {
 	addApprovedHost
 } from "./common";
 
+// SailHandshake is the first message from the native message host.
+export interface SailHandshake {
+	readonly url: string;
+	// token authenticates requests to version 2 of the API. It's only sent
+	// by sail versions supporting it.
+	readonly token?: string;
+	readonly version?: number;
+}
+
 export class SailConnector {
 	private port: chrome.runtime.Port;
-	private connectPromise: Promise<string>;
+	private connectPromise: Promise<SailHandshake>;
 
-	public connect(): Promise<string> {
+	public connect(): Promise<SailHandshake> {
 		if (this.connectPromise) {
 			return this.connectPromise;
 		}
 
-		this.connectPromise = new Promise<string>((resolve, reject) => {
+		this.connectPromise = new Promise<SailHandshake>((resolve, reject) => {
 			this.port = chrome.runtime.connectNative("com.coder.sail");
 			this.port.onMessage.addListener((message) => {
 				if (!message.url) {
 					return reject("Invalid handshake message");
 				}
 
-				resolve(message.url);
+				resolve(message);
 			});
 			this.port.onDisconnect.addListener(() => {
 				this.connectPromise = undefined;
@@ -50,6 +59,28 @@ connector.connect().then(() => connectError = undefined).catch((ex) => {
 	connectError = `Failed to connect: ${ex.toString()}`;
 });
 
+// apiRequest makes an authenticated request to version 2 of the sail API.
+const apiRequest = async (path: string, body?: object): Promise<any> => {
+	const sail = await connector.connect();
+	if (!sail.token || (sail.version || 1) < 2) {
+		throw new Error("Sail is too old to manage projects, please update it.");
+	}
+
+	const resp = await fetch(sail.url + "/api/v2" + path, {
+		method: body ? "POST" : "GET",
+		headers: {
+			"Authorization": `Bearer ${sail.token}`,
+			"Content-Type": "application/json",
+		},
+		body: body ? JSON.stringify(body) : undefined,
+	});
+	const data = await resp.json();
+	if (!resp.ok) {
+		throw new Error(data.error || resp.statusText);
+	}
+	return data;
+};
+
 // doConnection attempts to connect to Sail over WebSocket.
 const doConnection = (socketUrl: string, projectUrl: string, onMessage: (data: WebSocketMessage) => void): Promise<WebSocket> => {
 	return new Promise<WebSocket>((resolve, reject) => {
@@ -146,8 +177,11 @@ chrome.runtime.onConnect.addListener((port: chrome.runtime.Port): void => {
 						const onMessage = (message: WebSocketMessage) => {
 							port.postMessage(message);
 						};
-						connector.connect().then((sailUrl) => {
-							const socketUrl = sailUrl.replace("http:", "ws:") + "/api/v1/run";
+						connector.connect().then((sail) => {
+							let socketUrl = sail.url.replace("http:", "ws:") + "/api/v1/run";
+							if (sail.token) {
+								socketUrl = sail.url.replace("http:", "ws:") + "/api/v2/run?token=" + encodeURIComponent(sail.token);
+							}
 							return doConnection(socketUrl, data.projectUrl, onMessage).then((conn) => {
 								sendResponse({
 									type: "sail",
@@ -167,6 +201,36 @@ chrome.runtime.onConnect.addListener((port: chrome.runtime.Port): void => {
 						});
 
 					});
+			} else if (data.action) {
+				// Project management is only allowed from the extension's
+				// own pages.
+				if (port.sender.tab && !port.sender.url.startsWith(chrome.runtime.getURL("/"))) {
+					return;
+				}
+
+				let req: Promise<any>;
+				switch (data.action) {
+					case "list":
+						req = apiRequest("/projects");
+						break;
+					case "stop":
+					case "remove":
+						req = apiRequest(`/projects/${data.action}`, { container: data.container });
+						break;
+					default:
+						req = Promise.reject(`unknown action: ${data.action}`);
+				}
+				req.then((projects) => {
+					sendResponse({
+						type: "sail",
+						projects: data.action === "list" ? projects : undefined,
+					});
+				}).catch((ex) => {
+					sendResponse({
+						type: "sail",
+						error: ex.toString(),
+					});
+				});
 			} else {
 				// Check if we can get a sail URL.
 				connector.connect().then(() => {
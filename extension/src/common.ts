@@ -17,6 +17,21 @@ export interface ExtensionMessage {
 	readonly type: "sail";
 	readonly error?: string;
 	readonly projectUrl?: string;
+	// action manages projects, and requires container unless it's "list".
+	readonly action?: "list" | "stop" | "remove";
+	readonly container?: string;
+	readonly projects?: Project[];
+}
+
+// Project is a sail project as listed by the sail API.
+export interface Project {
+	readonly name: string;
+	readonly container: string;
+	readonly url: string;
+	readonly status: string;
+	readonly running: boolean;
+	readonly image: string;
+	readonly hat?: string;
 }
 
 // WebSocketMessage is a message from sail itself, sent over the WebSocket
@@ -84,6 +99,33 @@ export const sailAvailable = (): Promise<void> => {
 	});
 };
 
+// manageProjects sends a project management action to sail, resolving with
+// the projects for the "list" action.
+export const manageProjects = (action: "list" | "stop" | "remove", container?: string): Promise<Project[] | undefined> => {
+	return new Promise((resolve, reject) => {
+		const port = chrome.runtime.connect();
+
+		const responseListener = (response: ExtensionMessage): void => {
+			if (response.type === "sail") {
+				port.onMessage.removeListener(responseListener);
+				port.disconnect();
+				if (response.error) {
+					return reject(response.error);
+				}
+
+				resolve(response.projects);
+			}
+		};
+
+		port.onMessage.addListener(responseListener);
+		port.postMessage({
+			type: "sail",
+			action,
+			container,
+		});
+	});
+};
+
 // getApprovedHosts gets the approved hosts list from storage.
 export const getApprovedHosts = (): Promise<string[]> => {
 	return new Promise((resolve, reject) => {
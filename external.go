@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"golang.org/x/xerrors"
+
+	"go.coder.com/sail/internal/xlog"
+)
+
+// externalEnvFile is the record of an environment run by an external
+// backend in its container's directory of the host.
+const externalEnvFile = "backend.json"
+
+// externalShellScript starts the default shell of the user it runs as.
+const externalShellScript = `shell=$(grep "^.*:.*:$(id -u):" /etc/passwd | cut -d : -f 7-)
+exec "${shell:-/bin/sh}" -l`
+
+// externalEnv is an environment run by an external backend. Docker doesn't
+// know its container, so sail records it to list, stop, remove and prune it
+// and open shells in it.
+type externalEnv struct {
+	Backend string `json:"backend"`
+	// Image is the Docker image the container was created from.
+	Image string `json:"image"`
+	// UID and GID are the ids of the container's user.
+	UID     string            `json:"uid"`
+	GID     string            `json:"gid"`
+	Labels  map[string]string `json:"labels"`
+	Created time.Time         `json:"created"`
+}
+
+// newExternalEnv returns the record of the container created by the backend
+// named backend from the Docker spec containerConfig, running as uid and gid.
+func newExternalEnv(backend string, containerConfig *container.Config, uid, gid string) externalEnv {
+	return externalEnv{
+		Backend: backend,
+		Image:   containerConfig.Image,
+		UID:     uid,
+		GID:     gid,
+		Labels:  containerConfig.Labels,
+		Created: time.Now().UTC(),
+	}
+}
+
+func externalEnvPath(cntName string) string {
+	return filepath.Join(metaRoot(), cntName, externalEnvFile)
+}
+
+// saveExternalEnv records that the container cntName is run by an external
+// backend.
+func saveExternalEnv(cntName string, env externalEnv) error {
+	b, err := json.MarshalIndent(env, "", "\t")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(externalEnvPath(cntName)), 0750)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(externalEnvPath(cntName), b, 0600)
+}
+
+// loadExternalEnv returns the record of the container cntName, or nil if
+// it isn't run by an external backend.
+func loadExternalEnv(cntName string) (*externalEnv, error) {
+	b, err := ioutil.ReadFile(externalEnvPath(cntName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var env externalEnv
+	err = json.Unmarshal(b, &env)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to parse %v: %w", externalEnvPath(cntName), err)
+	}
+	return &env, nil
+}
+
+// removeExternalEnv removes the record of the container cntName.
+func removeExternalEnv(cntName string) error {
+	err := os.Remove(externalEnvPath(cntName))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// backend returns the backend running the environment.
+func (e externalEnv) backend() (externalBackend, error) {
+	b, ok := newBackend(e.Backend).(externalBackend)
+	if !ok {
+		return nil, xerrors.Errorf("unknown backend %q", e.Backend)
+	}
+	return b, nil
+}
+
+// externalEnvs returns the records of the environments of external backends,
+// by their container's name.
+func externalEnvs() (map[string]externalEnv, error) {
+	fis, err := ioutil.ReadDir(metaRoot())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	envs := make(map[string]externalEnv)
+	for _, fi := range fis {
+		if !fi.IsDir() {
+			continue
+		}
+		env, err := loadExternalEnv(fi.Name())
+		if err != nil {
+			return nil, err
+		}
+		if env != nil {
+			envs[fi.Name()] = *env
+		}
+	}
+	return envs, nil
+}
+
+// matchLabels reports whether labels match all of the selectors, of the
+// form key or key=value.
+func matchLabels(labels map[string]string, selectors []string) bool {
+	for _, s := range selectors {
+		kv := strings.SplitN(s, "=", 2)
+		v, ok := labels[kv[0]]
+		if !ok || (len(kv) == 2 && v != kv[1]) {
+			return false
+		}
+	}
+	return true
+}
+
+// externalProjects lists the projects of external backends, like
+// listProjects does for Docker. Only environments whose labels match all of
+// the label selectors are included. Containers that were removed outside of
+// sail are left out.
+func externalProjects(ctx context.Context, labels ...string) ([]projectInfo, error) {
+	envs, err := externalEnvs()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(envs))
+	for name := range envs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var infos []projectInfo
+	for _, cntName := range names {
+		env := envs[cntName]
+		if !matchLabels(env.Labels, labels) {
+			continue
+		}
+		b, err := env.backend()
+		if err != nil {
+			return nil, err
+		}
+		exists, running, err := b.status(ctx, cntName)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			continue
+		}
+		ports, err := loadPorts(cntName)
+		if err != nil {
+			return nil, err
+		}
+
+		info := projectInfo{
+			cntName:  cntName,
+			name:     toSailName(cntName),
+			hat:      env.Labels[hatLabel],
+			url:      "http://127.0.0.1:" + ports[codeServerPortName],
+			status:   fmt.Sprintf("stopped (%v)", env.Backend),
+			image:    env.Image,
+			localDir: env.Labels[projectLocalDirLabel],
+			running:  running,
+			rights:   describeRights(env.Labels),
+		}
+		if name := env.Labels[nameLabel]; name != "" {
+			info.name = name
+		}
+		if running {
+			info.status = fmt.Sprintf("running (%v)", env.Backend)
+		}
+		if remote := env.Labels[repoLabel]; remote != "" {
+			info.remote = remote
+			info.host = strings.SplitN(normalizeRemote(remote), "/", 2)[0]
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// removeExternal runs the pre_remove host hooks of the environment env of
+// an external backend, and then removes its container cntName.
+func removeExternal(ctx context.Context, cntName string, env externalEnv) error {
+	b, err := env.backend()
+	if err != nil {
+		return err
+	}
+	// Project hooks need Docker, so only host hooks run.
+	err = runHostHook(ctx, preRemoveHook, hookEnvFromLabels(cntName, env.Labels, false, false))
+	if err != nil {
+		xlog.Error("failed to run %v hooks for %s: %v", preRemoveHook, cntName, err)
+	}
+
+	err = b.remove(ctx, cntName)
+	if err != nil {
+		return xerrors.Errorf("failed to remove %s: %w", cntName, err)
+	}
+	emitEvent(eventRemoved, cntName, nil)
+	return nil
+}
+
+// expiredExternal returns the ephemeral and review environments of external
+// backends that expired, like expiredEnvironments.
+func expiredExternal(ctx context.Context, conf config) ([]expiredEnvironment, error) {
+	envs, err := externalEnvs()
+	if err != nil {
+		return nil, err
+	}
+	var expired []expiredEnvironment
+	for cntName, env := range envs {
+		if !isEphemeral(env.Labels) {
+			continue
+		}
+		b, err := env.backend()
+		if err != nil {
+			return nil, err
+		}
+		_, running, err := b.status(ctx, cntName)
+		if err != nil {
+			return nil, err
+		}
+
+		state, err := reviewState(ctx, conf, env.Labels)
+		if err != nil {
+			xlog.Warn("failed to get the state of %v: %v", env.Labels[reviewLabel], err)
+		}
+		// Backends don't tell when containers stopped, so they expire from
+		// their creation.
+		cnt := types.ContainerJSON{
+			ContainerJSONBase: &types.ContainerJSONBase{
+				Created: env.Created.Format(time.RFC3339Nano),
+				State:   &types.ContainerState{Running: running},
+			},
+			Config: &container.Config{Labels: env.Labels},
+		}
+		reason := ephemeralExpiry(cnt, state, conf.ephemeralTTL(), time.Now())
+		if reason == "" {
+			continue
+		}
+		e := expiredEnvironment{cntName: cntName, reason: reason}
+		if env.Labels[reviewLabel] != "" {
+			e.reviewDir = env.Labels[projectLocalDirLabel]
+		}
+		expired = append(expired, e)
+	}
+	sort.Slice(expired, func(i, j int) bool { return expired[i].cntName < expired[j].cntName })
+	return expired, nil
+}
+
+// externalContainers returns the environments of external backends as
+// containers of their Docker image, so the images they were created from
+// aren't pruned.
+func externalContainers() ([]types.Container, error) {
+	envs, err := externalEnvs()
+	if err != nil {
+		return nil, err
+	}
+	cnts := make([]types.Container, 0, len(envs))
+	for _, env := range envs {
+		cnts = append(cnts, types.Container{Image: env.Image, Labels: env.Labels})
+	}
+	return cnts, nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_externalEnvs(t *testing.T) {
+	home, err := ioutil.TempDir("", "sail-external")
+	require.NoError(t, err)
+	defer os.RemoveAll(home)
+	defer os.Setenv("HOME", os.Getenv("HOME"))
+	os.Setenv("HOME", home)
+
+	env, err := loadExternalEnv("cdr_sail")
+	require.NoError(t, err)
+	assert.Nil(t, env)
+
+	saved := newExternalEnv(backendLXD, &container.Config{
+		Image:  "codercom/ubuntu-dev",
+		Labels: map[string]string{nameLabel: "cdr/sail"},
+	}, "1000", "1000")
+	require.NoError(t, saveExternalEnv("cdr_sail", saved))
+	require.NoError(t, os.MkdirAll(filepath.Join(metaRoot(), "cdr_docker"), 0750))
+
+	envs, err := externalEnvs()
+	require.NoError(t, err)
+	require.Len(t, envs, 1)
+	assert.Equal(t, "codercom/ubuntu-dev", envs["cdr_sail"].Image)
+	assert.True(t, saved.Created.Equal(envs["cdr_sail"].Created))
+
+	b, err := envs["cdr_sail"].backend()
+	require.NoError(t, err)
+	assert.Equal(t, lxdBackend{cli: "lxc"}, b)
+
+	fi, err := os.Stat(externalEnvPath("cdr_sail"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+
+	require.NoError(t, removeExternalEnv("cdr_sail"))
+	require.NoError(t, removeExternalEnv("cdr_sail"))
+	envs, err = externalEnvs()
+	require.NoError(t, err)
+	assert.Empty(t, envs)
+}
+
+func Test_matchLabels(t *testing.T) {
+	labels := map[string]string{nameLabel: "cdr/sail", hatLabel: ""}
+	assert.True(t, matchLabels(labels, nil))
+	assert.True(t, matchLabels(labels, []string{hatLabel, nameLabel + "=cdr/sail"}))
+	assert.False(t, matchLabels(labels, []string{nameLabel + "=cdr/code-server"}))
+	assert.False(t, matchLabels(labels, []string{reviewLabel}))
+}
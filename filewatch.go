@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"go.coder.com/sail/internal/xlog"
+)
+
+// File watcher modes of fileWatcherLabel.
+const (
+	watchAuto    = "auto"
+	watchInotify = "inotify"
+	watchPoll    = "poll"
+)
+
+// inotifyWatchesPath holds how many inotify watches a user may have. It's
+// not namespaced, so containers share the limit of the daemon's kernel.
+const inotifyWatchesPath = "/proc/sys/fs/inotify/max_user_watches"
+
+// recommendedInotifyWatches is what sail doctor --fix raises the limit to.
+const recommendedInotifyWatches = 524288
+
+// inotifyHeadroom is how many times the directories of a project the limit
+// must allow, as other projects and editors use watches too.
+const inotifyHeadroom = 2
+
+// pollingEnv makes code-server's file watcher poll for changes instead of
+// using inotify.
+const pollingEnv = "CHOKIDAR_USEPOLLING=1"
+
+// readInotifyWatches reads the inotify watch limit from path.
+func readInotifyWatches(path string) (int, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return 0, xerrors.Errorf("invalid %v: %w", path, err)
+	}
+	return n, nil
+}
+
+// countWatchedDirs counts the directories in dir the editor watches, each of
+// which takes an inotify watch. It stops counting once there are more than
+// max. Like the editor, it skips git's objects and installed node modules.
+func countWatchedDirs(dir string, max int) (int, error) {
+	var n int
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			// Directories we can't read can't be watched either.
+			return nil
+		}
+		if !fi.IsDir() {
+			return nil
+		}
+		if fi.Name() == ".git" || fi.Name() == "node_modules" {
+			return filepath.SkipDir
+		}
+		n++
+		if n > max {
+			return errTooManyDirs
+		}
+		return nil
+	})
+	if err == errTooManyDirs {
+		err = nil
+	}
+	return n, err
+}
+
+var errTooManyDirs = xerrors.New("too many directories")
+
+// watchedDirsTTL is how long counts of watchedDirs are reused, as walking
+// large projects takes a while.
+const watchedDirsTTL = time.Hour
+
+// watchedDirsCount is a count of countWatchedDirs, which stopped past Max.
+type watchedDirsCount struct {
+	Dirs    int       `json:"dirs"`
+	Max     int       `json:"max"`
+	Counted time.Time `json:"counted"`
+}
+
+// watchedDirsCachePath is where the counts of watchedDirs are kept, by the
+// directory counted.
+func watchedDirsCachePath() string {
+	return filepath.Join(metaRoot(), "watched-dirs.json")
+}
+
+func readWatchedDirsCache() map[string]watchedDirsCount {
+	counts := make(map[string]watchedDirsCount)
+	b, err := ioutil.ReadFile(watchedDirsCachePath())
+	if err != nil {
+		return counts
+	}
+	err = json.Unmarshal(b, &counts)
+	if err != nil {
+		xlog.Debug("failed to decode %v: %v", watchedDirsCachePath(), err)
+		return make(map[string]watchedDirsCount)
+	}
+	return counts
+}
+
+// watchedDirs counts the directories in dir the editor watches, as far as
+// needed to tell whether they exceed the inotify watch limit limit, see
+// needsPolling. Counts are cached for watchedDirsTTL.
+func watchedDirs(dir string, limit int) (int, error) {
+	max := limit / inotifyHeadroom
+	counts := readWatchedDirsCache()
+	c, ok := counts[dir]
+	// Counts that stopped early only tell about maximums up to theirs.
+	if ok && time.Since(c.Counted) < watchedDirsTTL && (c.Dirs <= c.Max || max <= c.Max) {
+		return c.Dirs, nil
+	}
+
+	n, err := countWatchedDirs(dir, max)
+	if err != nil {
+		return 0, err
+	}
+	for d, c := range counts {
+		if time.Since(c.Counted) >= watchedDirsTTL {
+			delete(counts, d)
+		}
+	}
+	counts[dir] = watchedDirsCount{Dirs: n, Max: max, Counted: time.Now()}
+	b, err := json.MarshalIndent(counts, "", "\t")
+	if err != nil {
+		return 0, err
+	}
+	err = os.MkdirAll(metaRoot(), 0750)
+	if err == nil {
+		err = writeFileAtomic(watchedDirsCachePath(), b, 0600)
+	}
+	if err != nil {
+		xlog.Debug("failed to cache the directories of %v: %v", dir, err)
+	}
+	return n, nil
+}
+
+// needsPolling reports whether a project with dirs directories exceeds the
+// inotify watch limit.
+func needsPolling(dirs, limit int) bool {
+	return dirs*inotifyHeadroom > limit
+}
+
+// pollFiles reports whether code-server must poll for changes to the
+// project's files, as the image's fileWatcherLabel asks or because the
+// project is too large for the inotify watch limit.
+func (r *runner) pollFiles(labels map[string]string) bool {
+	switch labels[fileWatcherLabel] {
+	case watchPoll:
+		return true
+	case watchInotify:
+		return false
+	}
+	// The limit of daemons in a VM can't be read from the host, and Windows
+	// doesn't have inotify.
+	if runtime.GOOS != "linux" || r.desktop || r.windows {
+		return false
+	}
+
+	limit, err := readInotifyWatches(inotifyWatchesPath)
+	if err != nil {
+		xlog.Debug("failed to read inotify limit: %v", err)
+		return false
+	}
+	dirs, err := watchedDirs(r.projectLocalDir, limit)
+	if err != nil {
+		xlog.Debug("failed to count directories of %v: %v", r.projectLocalDir, err)
+		return false
+	}
+	if !needsPolling(dirs, limit) {
+		return false
+	}
+	xlog.Warn("%v has too many directories for fs.inotify.max_user_watches (%v), code-server polls for changes instead, raise the limit with sail doctor --fix", r.projectLocalDir, limit)
+	return true
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_countWatchedDirs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sail-watch")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	for _, d := range []string{"a/b", "c", ".git/objects", "node_modules/left-pad"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, d), 0755))
+	}
+
+	n, err := countWatchedDirs(dir, 100)
+	require.NoError(t, err)
+	assert.Equal(t, 4, n)
+
+	// Counting stops past max.
+	n, err = countWatchedDirs(dir, 2)
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+}
+
+func Test_watchedDirs(t *testing.T) {
+	home, err := ioutil.TempDir("", "sail-watch-home")
+	require.NoError(t, err)
+	defer os.RemoveAll(home)
+	defer os.Setenv("HOME", os.Getenv("HOME"))
+	os.Setenv("HOME", home)
+
+	dir, err := ioutil.TempDir("", "sail-watch")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	for _, d := range []string{"a", "b", "c", "d"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, d), 0755))
+	}
+
+	// Counting stops once the directories need polling.
+	n, err := watchedDirs(dir, 4)
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+	assert.True(t, needsPolling(n, 4))
+
+	// The cached count is reused for lower limits only.
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "e"), 0755))
+	n, err = watchedDirs(dir, 2)
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+	n, err = watchedDirs(dir, 100)
+	require.NoError(t, err)
+	assert.Equal(t, 6, n)
+	assert.False(t, needsPolling(n, 100))
+
+	// Full counts are reused for any limit.
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "f"), 0755))
+	n, err = watchedDirs(dir, 1000)
+	require.NoError(t, err)
+	assert.Equal(t, 6, n)
+}
+
+func Test_readInotifyWatches(t *testing.T) {
+	fi, err := ioutil.TempFile("", "sail-watches")
+	require.NoError(t, err)
+	defer os.Remove(fi.Name())
+	fi.WriteString("8192\n")
+	fi.Close()
+
+	limit, err := readInotifyWatches(fi.Name())
+	require.NoError(t, err)
+	assert.Equal(t, 8192, limit)
+	assert.True(t, needsPolling(5000, limit))
+	assert.False(t, needsPolling(100, limit))
+}
+
+func Test_pollFiles(t *testing.T) {
+	r := &runner{desktop: true}
+	assert.True(t, r.pollFiles(map[string]string{fileWatcherLabel: watchPoll}))
+	assert.False(t, r.pollFiles(map[string]string{fileWatcherLabel: watchInotify}))
+	assert.False(t, r.pollFiles(nil))
+}
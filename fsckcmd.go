@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/mount"
+	"golang.org/x/xerrors"
+
+	"go.coder.com/cli"
+	"go.coder.com/sail/internal/dockutil"
+	"go.coder.com/sail/internal/xlog"
+)
+
+// tempCntRx matches the temporary names of containers being swapped by
+// rebuilds, mounts and upgrades, see swapContainer.
+var tempCntRx = regexp.MustCompile(`^(.+)-(old|builder|mount|upgrade)-[0-9A-Za-z]{5}$`)
+
+// stateDirFiles are the files of an environment's metadata directory, which
+// tell them apart from the other directories of the metadata root.
+var stateDirFiles = append([]string{"last-used", "run", "ports.json", "browser-profile"}, syncFiles(true)...)
+
+// fsckProblem is an inconsistency between Docker and sail's state on disk.
+type fsckProblem struct {
+	desc string
+	// fix describes repair.
+	fix    string
+	repair func(ctx context.Context) error
+}
+
+type fsckcmd struct {
+	gf *globalFlags
+
+	dryRun bool
+	yes    bool
+}
+
+func (c *fsckcmd) Spec() cli.CommandSpec {
+	return cli.CommandSpec{
+		Name:  "fsck",
+		Usage: "[flags]",
+		Desc: `Cross-checks sail's containers against its state on disk, and repairs what's out of sync.
+
+It finds containers left behind by interrupted rebuilds, containers whose project
+directory was deleted, containers whose state directory is missing, and state
+directories of containers that were removed. Each repair is confirmed first,
+and only made without a terminal with --yes.`,
+	}
+}
+
+func (c *fsckcmd) RegisterFlags(fl *flag.FlagSet) {
+	fl.BoolVar(&c.dryRun, "dry-run", false, "Only print the problems.")
+	fl.BoolVar(&c.yes, "yes", false, "Make every repair without confirming it.")
+}
+
+func (c *fsckcmd) Run(fl *flag.FlagSet) {
+	c.gf.selectDockerContext("")
+	c.gf.ensureDockerDaemon()
+
+	ctx := context.Background()
+
+	cnts, err := listContainers()
+	if err != nil {
+		xlog.Fatal("failed to list containers: %v", err)
+	}
+	// The state of every daemon's containers is kept in the same directory,
+	// so it can only be told whether it's orphaned with a single daemon.
+	orphans := len(c.gf.config().DockerContexts) == 0
+	if !orphans {
+		xlog.Info("docker_contexts is set, so state directories of removed containers aren't checked")
+	}
+	problems, err := fsckProblems(metaRoot(), cnts, orphans)
+	if err != nil {
+		xlog.Fatal("%v", err)
+	}
+	if len(problems) == 0 {
+		xlog.Success("no problems found")
+		return
+	}
+
+	var failed bool
+	for _, p := range problems {
+		if c.dryRun {
+			fmt.Printf("%v\n", p.desc)
+			continue
+		}
+		xlog.Warn("%v", p.desc)
+		if !c.yes && !confirmDestructive(p.fix+"?") {
+			continue
+		}
+		err = p.repair(ctx)
+		if err != nil {
+			xlog.Error("failed to repair: %v", err)
+			failed = true
+			continue
+		}
+		xlog.Success("repaired")
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// fsckProblems returns the problems of the containers cnts and the metadata
+// root. With orphans, the state directories of removed containers are found.
+func fsckProblems(root string, cnts []types.Container, orphans bool) ([]fsckProblem, error) {
+	var problems []fsckProblem
+	for _, s := range strayContainers(cnts) {
+		s := s
+		if s.restore {
+			problems = append(problems, fsckProblem{
+				desc: fmt.Sprintf("%v was left behind by an interrupted rebuild, and %v doesn't exist", s.name, s.base),
+				fix:  fmt.Sprintf("rename it to %v", s.base),
+				repair: func(ctx context.Context) error {
+					cli := dockerClient()
+					defer cli.Close()
+					return cli.ContainerRename(ctx, s.name, s.base)
+				},
+			})
+			continue
+		}
+		problems = append(problems, fsckProblem{
+			desc:   fmt.Sprintf("%v was left behind by an interrupted rebuild of %v", s.name, s.base),
+			fix:    "remove it",
+			repair: removeStray(s.name),
+		})
+	}
+
+	for _, cnt := range cnts {
+		name := trimDockerName(cnt)
+		if name == "" || tempCntRx.MatchString(name) {
+			continue
+		}
+		if dir := cnt.Labels[projectLocalDirLabel]; dir != "" {
+			if _, err := os.Stat(dir); os.IsNotExist(err) {
+				problems = append(problems, fsckProblem{
+					desc:   fmt.Sprintf("the project directory %v of %v was deleted", dir, toSailName(name)),
+					fix:    "remove the container",
+					repair: removeStray(name),
+				})
+				continue
+			}
+		}
+		for _, dir := range missingStateDirs(root, cnt.Mounts) {
+			dir := dir
+			problems = append(problems, fsckProblem{
+				desc: fmt.Sprintf("%v mounts %v, which doesn't exist, so it can't start", toSailName(name), dir),
+				fix:  "recreate it empty",
+				repair: func(ctx context.Context) error {
+					return os.MkdirAll(dir, 0750)
+				},
+			})
+		}
+	}
+
+	if !orphans {
+		return problems, nil
+	}
+	dirs, err := orphanedStateDirs(root, cnts)
+	if err != nil {
+		return nil, err
+	}
+	for _, dir := range dirs {
+		dir := dir
+		desc := fmt.Sprintf("%v is the state of a container that was swapped out", dir)
+		if !tempCntRx.MatchString(filepath.Base(dir)) {
+			desc = fmt.Sprintf("%v is the state of the removed environment %v, which is reused if it's created again", dir, toSailName(filepath.Base(dir)))
+		}
+		problems = append(problems, fsckProblem{
+			desc: desc,
+			fix:  "remove it",
+			repair: func(ctx context.Context) error {
+				return os.RemoveAll(dir)
+			},
+		})
+	}
+	return problems, nil
+}
+
+// removeStray returns a repair removing the container name.
+func removeStray(name string) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		cli := dockerClient()
+		defer cli.Close()
+		// Temporary containers don't run the pre_remove hooks of the
+		// environment.
+		if tempCntRx.MatchString(name) {
+			return dockutil.StopRemove(ctx, cli, name)
+		}
+		return removeContainer(ctx, cli, name)
+	}
+}
+
+// strayContainer is a container with a temporary name of a swap that was
+// interrupted.
+type strayContainer struct {
+	name string
+	// base is the name of the container it was swapped with.
+	base string
+	// restore is set if base doesn't exist, so the container is renamed to
+	// it instead of being removed. Original containers are restored before
+	// new ones. Builder containers may be half-built, so they're never
+	// restored.
+	restore bool
+}
+
+// strayContainers returns the containers of cnts left behind by interrupted
+// swaps.
+func strayContainers(cnts []types.Container) []strayContainer {
+	names := make(map[string]bool, len(cnts))
+	for _, cnt := range cnts {
+		names[trimDockerName(cnt)] = true
+	}
+
+	var strays []strayContainer
+	for _, cnt := range cnts {
+		name := trimDockerName(cnt)
+		sm := tempCntRx.FindStringSubmatch(name)
+		if sm == nil {
+			continue
+		}
+		strays = append(strays, strayContainer{name: name, base: sm[1]})
+	}
+	sort.SliceStable(strays, func(i, j int) bool {
+		return strings.Contains(strays[i].name, "-old-") && !strings.Contains(strays[j].name, "-old-")
+	})
+	for i, s := range strays {
+		if !names[s.base] && !strings.Contains(s.name, "-builder-") {
+			strays[i].restore = true
+			names[s.base] = true
+		}
+	}
+	return strays
+}
+
+// missingStateDirs returns the sources of mounts in root that don't exist.
+// They're all directories.
+func missingStateDirs(root string, mounts []types.MountPoint) []string {
+	var missing []string
+	for _, m := range mounts {
+		if m.Type != mount.TypeBind || !strings.HasPrefix(m.Source, root+string(filepath.Separator)) {
+			continue
+		}
+		if _, err := os.Stat(m.Source); os.IsNotExist(err) {
+			missing = append(missing, m.Source)
+		}
+	}
+	return missing
+}
+
+// orphanedStateDirs returns the metadata directories in root of containers
+// that don't exist in cnts. Rebuilt containers keep using the state of the
+// container that built them, so directories of mounts are in use too.
+func orphanedStateDirs(root string, cnts []types.Container) ([]string, error) {
+	used := make(map[string]bool)
+	for _, cnt := range cnts {
+		used[filepath.Join(root, trimDockerName(cnt))] = true
+		for _, m := range cnt.Mounts {
+			rel, err := filepath.Rel(root, m.Source)
+			if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+				continue
+			}
+			used[filepath.Join(root, strings.SplitN(rel, string(filepath.Separator), 2)[0])] = true
+		}
+	}
+
+	fis, err := ioutil.ReadDir(root)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, xerrors.Errorf("failed to read %v: %w", root, err)
+	}
+	var orphans []string
+	for _, fi := range fis {
+		dir := filepath.Join(root, fi.Name())
+		if !fi.IsDir() || used[dir] || !isStateDir(dir) {
+			continue
+		}
+		// Docker doesn't know the containers of external backends.
+		if _, err := os.Stat(filepath.Join(dir, externalEnvFile)); err == nil {
+			continue
+		}
+		orphans = append(orphans, dir)
+	}
+	return orphans, nil
+}
+
+// isStateDir reports whether dir is the metadata directory of a container.
+func isStateDir(dir string) bool {
+	for _, name := range stateDirFiles {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			return true
+		}
+	}
+	return false
+}
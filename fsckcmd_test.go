@@ -0,0 +1,63 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_strayContainers(t *testing.T) {
+	strays := strayContainers([]types.Container{
+		{Names: []string{"/cdr_sail"}},
+		{Names: []string{"/cdr_sail-builder-a1B2c"}},
+		{Names: []string{"/cdr_code-server-builder-d3E4f"}},
+		{Names: []string{"/cdr_code-server-old-g5H6i"}},
+		{Names: []string{"/cdr_tidb-builder-j7K8l"}},
+	})
+	assert.Equal(t, []strayContainer{
+		{name: "cdr_code-server-old-g5H6i", base: "cdr_code-server", restore: true},
+		{name: "cdr_sail-builder-a1B2c", base: "cdr_sail"},
+		{name: "cdr_code-server-builder-d3E4f", base: "cdr_code-server"},
+		// Half-built containers aren't restored.
+		{name: "cdr_tidb-builder-j7K8l", base: "cdr_tidb"},
+	}, strays)
+}
+
+func Test_orphanedStateDirs(t *testing.T) {
+	root, err := ioutil.TempDir("", "sail-fsck")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	for _, dir := range []string{
+		"cdr_sail/globalStorage",
+		"cdr_sail-builder-a1B2c/globalStorage",
+		"cdr_code-server/globalStorage",
+		"locks",
+		"hooks/pre_create",
+	} {
+		require.NoError(t, os.MkdirAll(filepath.Join(root, dir), 0750))
+	}
+
+	cnts := []types.Container{{
+		Names: []string{"/cdr_sail"},
+		Mounts: []types.MountPoint{{
+			Type:   mount.TypeBind,
+			Source: filepath.Join(root, "cdr_sail-builder-a1B2c", "globalStorage"),
+		}},
+	}}
+	orphans, err := orphanedStateDirs(root, cnts)
+	require.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(root, "cdr_code-server")}, orphans)
+
+	cnts[0].Mounts = append(cnts[0].Mounts, types.MountPoint{
+		Type:   mount.TypeBind,
+		Source: filepath.Join(root, "cdr_sail", "run"),
+	})
+	assert.Equal(t, []string{filepath.Join(root, "cdr_sail", "run")}, missingStateDirs(root, cnts[0].Mounts))
+}
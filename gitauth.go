@@ -0,0 +1,337 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"go.coder.com/sail/internal/flock"
+)
+
+const (
+	gitAuthGitHub = "github"
+	gitAuthGitLab = "gitlab"
+)
+
+// gitAuthConfig configures the tokens of a git host, which sail login --git
+// obtains with OAuth's device flow.
+type gitAuthConfig struct {
+	// Provider is gitAuthGitHub or gitAuthGitLab. By default it's
+	// gitAuthGitLab for hosts whose name contains gitlab.
+	Provider string `toml:"provider"`
+	// ClientID is the ID of the OAuth application the tokens are for. It
+	// must allow the device flow.
+	ClientID string   `toml:"client_id"`
+	Scopes   []string `toml:"scopes"`
+}
+
+// provider returns the provider of the host's tokens.
+func (c gitAuthConfig) provider(host string) string {
+	if c.Provider != "" {
+		return c.Provider
+	}
+	if strings.Contains(host, "gitlab") {
+		return gitAuthGitLab
+	}
+	return gitAuthGitHub
+}
+
+// checkGitAuth validates the git_auth table of the config.
+func checkGitAuth(auth map[string]gitAuthConfig) error {
+	for host, c := range auth {
+		if c.ClientID == "" {
+			return xerrors.Errorf("git_auth of %v: client_id is required", host)
+		}
+		if c.Provider != "" && c.Provider != gitAuthGitHub && c.Provider != gitAuthGitLab {
+			return xerrors.Errorf("git_auth of %v: invalid provider %q, must be %v or %v", host, c.Provider, gitAuthGitHub, gitAuthGitLab)
+		}
+	}
+	return nil
+}
+
+// gitAuthEndpoints are the OAuth endpoints of a git host.
+type gitAuthEndpoints struct {
+	deviceURL string
+	tokenURL  string
+	// username is the username git authenticates with alongside tokens.
+	username string
+}
+
+// gitAuthEndpointsOf returns the endpoints of the provider at host.
+func gitAuthEndpointsOf(provider, host string) gitAuthEndpoints {
+	if provider == gitAuthGitLab {
+		return gitAuthEndpoints{
+			deviceURL: "https://" + host + "/oauth/authorize_device",
+			tokenURL:  "https://" + host + "/oauth/token",
+			username:  "oauth2",
+		}
+	}
+	return gitAuthEndpoints{
+		deviceURL: "https://" + host + "/login/device/code",
+		tokenURL:  "https://" + host + "/login/oauth/access_token",
+		username:  "x-access-token",
+	}
+}
+
+// gitToken is a token sail login --git obtained for a git host.
+type gitToken struct {
+	Username     string `json:"username"`
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	// ExpiresAt is zero if the token doesn't expire.
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+	// TokenURL and ClientID refresh the token.
+	TokenURL string `json:"token_url"`
+	ClientID string `json:"client_id"`
+}
+
+// expired reports whether the token expires within a minute, so it's not
+// handed out only to expire in the middle of a push.
+func (t gitToken) expired(now time.Time) bool {
+	return !t.ExpiresAt.IsZero() && now.Add(time.Minute).After(t.ExpiresAt)
+}
+
+// gitTokensPath is where the tokens of sail login --git are kept, by host.
+func gitTokensPath() string {
+	return filepath.Join(metaRoot(), "git-tokens.json")
+}
+
+func readGitTokens() (map[string]gitToken, error) {
+	b, err := ioutil.ReadFile(gitTokensPath())
+	if os.IsNotExist(err) {
+		return map[string]gitToken{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	tokens := make(map[string]gitToken)
+	err = json.Unmarshal(b, &tokens)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to decode %v: %w", gitTokensPath(), err)
+	}
+	return tokens, nil
+}
+
+// lockGitTokens acquires the lock of the tokens file, which sail processes
+// hold while they change it. Refreshing a token can invalidate its refresh
+// token, so concurrent refreshes must not race each other.
+func lockGitTokens() (*flock.Lock, error) {
+	return flock.New(filepath.Join(metaRoot(), "locks", "git-tokens.lock"))
+}
+
+func saveGitToken(host string, t gitToken) error {
+	l, err := lockGitTokens()
+	if err != nil {
+		return err
+	}
+	defer l.Unlock()
+	return writeGitToken(host, t)
+}
+
+// writeGitToken saves the token of host. The caller holds the lock of the
+// tokens file.
+func writeGitToken(host string, t gitToken) error {
+	tokens, err := readGitTokens()
+	if err != nil {
+		return err
+	}
+	tokens[host] = t
+	b, err := json.Marshal(tokens)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(metaRoot(), 0750)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(gitTokensPath(), b, 0600)
+}
+
+// oauthResponse holds the fields of the responses of the device flow's
+// endpoints that sail uses.
+type oauthResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	Interval                int    `json:"interval"`
+
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+
+	Error string `json:"error"`
+}
+
+// postOAuth posts the form to the OAuth endpoint u. GitHub only answers
+// with JSON if asked to.
+func postOAuth(ctx context.Context, u string, form url.Values) (oauthResponse, error) {
+	req, err := http.NewRequest(http.MethodPost, u, strings.NewReader(form.Encode()))
+	if err != nil {
+		return oauthResponse{}, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return oauthResponse{}, err
+	}
+	defer resp.Body.Close()
+
+	var r oauthResponse
+	err = json.NewDecoder(resp.Body).Decode(&r)
+	if err != nil {
+		return oauthResponse{}, xerrors.Errorf("failed to decode the response of %v, status %v: %w", u, resp.Status, err)
+	}
+	// Pending device flows are errors with status 400.
+	if resp.StatusCode >= 300 && r.Error == "" {
+		return oauthResponse{}, xerrors.Errorf("%v responded with %v", u, resp.Status)
+	}
+	return r, nil
+}
+
+// tokenOf returns the token of a token response of the endpoints e.
+func tokenOf(r oauthResponse, e gitAuthEndpoints, clientID string, now time.Time) gitToken {
+	t := gitToken{
+		Username:     e.username,
+		AccessToken:  r.AccessToken,
+		RefreshToken: r.RefreshToken,
+		TokenURL:     e.tokenURL,
+		ClientID:     clientID,
+	}
+	if r.ExpiresIn > 0 {
+		t.ExpiresAt = now.Add(time.Duration(r.ExpiresIn) * time.Second)
+	}
+	return t
+}
+
+// deviceLogin obtains a token with OAuth's device flow. prompt shows the
+// user where to enter the code, and the flow waits until they have.
+func deviceLogin(ctx context.Context, e gitAuthEndpoints, c gitAuthConfig, prompt func(uri, code string)) (gitToken, error) {
+	r, err := postOAuth(ctx, e.deviceURL, url.Values{
+		"client_id": {c.ClientID},
+		"scope":     {strings.Join(c.Scopes, " ")},
+	})
+	if err != nil {
+		return gitToken{}, xerrors.Errorf("failed to start the device flow: %w", err)
+	}
+	if r.Error != "" {
+		return gitToken{}, xerrors.Errorf("failed to start the device flow: %v", r.Error)
+	}
+	uri := r.VerificationURI
+	if r.VerificationURIComplete != "" {
+		uri = r.VerificationURIComplete
+	}
+	prompt(uri, r.UserCode)
+
+	interval := time.Duration(r.Interval) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return gitToken{}, ctx.Err()
+		case <-time.After(interval):
+		}
+
+		t, err := postOAuth(ctx, e.tokenURL, url.Values{
+			"client_id":   {c.ClientID},
+			"device_code": {r.DeviceCode},
+			"grant_type":  {"urn:ietf:params:oauth:grant-type:device_code"},
+		})
+		if err != nil {
+			return gitToken{}, err
+		}
+		switch t.Error {
+		case "":
+			return tokenOf(t, e, c.ClientID, time.Now()), nil
+		case "authorization_pending":
+		case "slow_down":
+			interval += 5 * time.Second
+		default:
+			return gitToken{}, xerrors.Errorf("failed to obtain a token: %v", t.Error)
+		}
+	}
+}
+
+// refreshGitToken exchanges the refresh token of t for a new token.
+func refreshGitToken(ctx context.Context, t gitToken) (gitToken, error) {
+	r, err := postOAuth(ctx, t.TokenURL, url.Values{
+		"client_id":     {t.ClientID},
+		"refresh_token": {t.RefreshToken},
+		"grant_type":    {"refresh_token"},
+	})
+	if err != nil {
+		return gitToken{}, err
+	}
+	if r.Error != "" {
+		return gitToken{}, xerrors.Errorf("failed to refresh the token: %v", r.Error)
+	}
+	e := gitAuthEndpoints{tokenURL: t.TokenURL, username: t.Username}
+	refreshed := tokenOf(r, e, t.ClientID, time.Now())
+	if refreshed.RefreshToken == "" {
+		refreshed.RefreshToken = t.RefreshToken
+	}
+	return refreshed, nil
+}
+
+// validGitToken returns the token of host, refreshed if it expired. ok is
+// false if there's no token for host, or it expired and can't be refreshed.
+func validGitToken(ctx context.Context, host string) (t gitToken, ok bool, _ error) {
+	tokens, err := readGitTokens()
+	if err != nil {
+		return gitToken{}, false, err
+	}
+	t, ok = tokens[host]
+	if !ok || !t.expired(time.Now()) {
+		return t, ok, nil
+	}
+
+	l, err := lockGitTokens()
+	if err != nil {
+		return gitToken{}, false, err
+	}
+	defer l.Unlock()
+	// Another process may have refreshed the token while this one waited
+	// for the lock.
+	tokens, err = readGitTokens()
+	if err != nil {
+		return gitToken{}, false, err
+	}
+	t, ok = tokens[host]
+	if !ok || !t.expired(time.Now()) {
+		return t, ok, nil
+	}
+	if t.RefreshToken == "" {
+		return gitToken{}, false, nil
+	}
+	t, err = refreshGitToken(ctx, t)
+	if err != nil {
+		return gitToken{}, false, err
+	}
+	return t, true, writeGitToken(host, t)
+}
+
+// gitTokenAnswer returns the answer of git credential-cache with the token
+// of the credential's host, if its protocol is https and sail has one. The
+// credential is the one allowGitCredential checked.
+func gitTokenAnswer(ctx context.Context, credential gitCredential) ([]byte, bool, error) {
+	if credential.protocol != "https" || credential.host == "" {
+		return nil, false, nil
+	}
+	t, ok, err := validGitToken(ctx, credential.host)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	return []byte("username=" + t.Username + "\npassword=" + t.AccessToken + "\n"), true, nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_checkGitAuth(t *testing.T) {
+	require.NoError(t, checkGitAuth(map[string]gitAuthConfig{"github.com": {ClientID: "id"}}))
+	require.Error(t, checkGitAuth(map[string]gitAuthConfig{"github.com": {}}))
+	require.Error(t, checkGitAuth(map[string]gitAuthConfig{"git.example.com": {ClientID: "id", Provider: "gitea"}}))
+
+	assert.Equal(t, gitAuthGitLab, gitAuthConfig{}.provider("gitlab.example.com"))
+	assert.Equal(t, gitAuthGitHub, gitAuthConfig{}.provider("github.example.com"))
+}
+
+func Test_deviceLogin(t *testing.T) {
+	var polls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		assert.Equal(t, "id", r.Form.Get("client_id"))
+		switch r.URL.Path {
+		case "/device":
+			assert.Equal(t, "repo read:org", r.Form.Get("scope"))
+			json.NewEncoder(w).Encode(oauthResponse{DeviceCode: "dev", UserCode: "ABCD-1234", VerificationURI: "https://example.com/device", Interval: 1})
+		case "/token":
+			assert.Equal(t, "dev", r.Form.Get("device_code"))
+			polls++
+			if polls == 1 {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(oauthResponse{Error: "authorization_pending"})
+				return
+			}
+			json.NewEncoder(w).Encode(oauthResponse{AccessToken: "tok", RefreshToken: "ref", ExpiresIn: 3600})
+		}
+	}))
+	defer srv.Close()
+
+	e := gitAuthEndpoints{deviceURL: srv.URL + "/device", tokenURL: srv.URL + "/token", username: "oauth2"}
+	var code string
+	tok, err := deviceLogin(context.Background(), e, gitAuthConfig{ClientID: "id", Scopes: []string{"repo", "read:org"}}, func(uri, c string) {
+		assert.Equal(t, "https://example.com/device", uri)
+		code = c
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "ABCD-1234", code)
+	assert.Equal(t, 2, polls)
+	assert.Equal(t, "tok", tok.AccessToken)
+	assert.Equal(t, "ref", tok.RefreshToken)
+	assert.Equal(t, srv.URL+"/token", tok.TokenURL)
+	assert.False(t, tok.expired(time.Now()))
+	assert.True(t, tok.expired(time.Now().Add(time.Hour)))
+}
+
+func Test_gitTokenAnswer(t *testing.T) {
+	home, err := ioutil.TempDir("", "sail-gitauth")
+	require.NoError(t, err)
+	defer os.RemoveAll(home)
+	defer os.Setenv("HOME", os.Getenv("HOME"))
+	os.Setenv("HOME", home)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		assert.Equal(t, "refresh_token", r.Form.Get("grant_type"))
+		assert.Equal(t, "ref", r.Form.Get("refresh_token"))
+		json.NewEncoder(w).Encode(oauthResponse{AccessToken: "new", ExpiresIn: 7200})
+	}))
+	defer srv.Close()
+
+	require.NoError(t, saveGitToken("gitlab.example.com", gitToken{
+		Username:     "oauth2",
+		AccessToken:  "old",
+		RefreshToken: "ref",
+		ExpiresAt:    time.Now().Add(-time.Minute),
+		TokenURL:     srv.URL,
+		ClientID:     "id",
+	}))
+
+	ctx := context.Background()
+	answer, ok, err := gitTokenAnswer(ctx, gitCredential{protocol: "https", host: "gitlab.example.com"})
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, "username=oauth2\npassword=new\n", string(answer))
+
+	// The refreshed token is saved, with the refresh token it still needs.
+	tokens, err := readGitTokens()
+	require.NoError(t, err)
+	assert.Equal(t, "new", tokens["gitlab.example.com"].AccessToken)
+	assert.Equal(t, "ref", tokens["gitlab.example.com"].RefreshToken)
+
+	_, ok, err = gitTokenAnswer(ctx, gitCredential{protocol: "ssh", host: "gitlab.example.com"})
+	require.NoError(t, err)
+	assert.False(t, ok)
+	_, ok, err = gitTokenAnswer(ctx, gitCredential{protocol: "https", host: "github.com"})
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func Test_validGitTokenConcurrent(t *testing.T) {
+	home, err := ioutil.TempDir("", "sail-gitauth")
+	require.NoError(t, err)
+	defer os.RemoveAll(home)
+	defer os.Setenv("HOME", os.Getenv("HOME"))
+	os.Setenv("HOME", home)
+
+	// The server rotates refresh tokens, so a second refresh with the old
+	// one fails.
+	var refreshes int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		if r.Form.Get("refresh_token") != "ref" {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		atomic.AddInt32(&refreshes, 1)
+		json.NewEncoder(w).Encode(oauthResponse{AccessToken: "new", RefreshToken: "ref2", ExpiresIn: 7200})
+	}))
+	defer srv.Close()
+
+	require.NoError(t, saveGitToken("gitlab.example.com", gitToken{
+		Username:     "oauth2",
+		AccessToken:  "old",
+		RefreshToken: "ref",
+		ExpiresAt:    time.Now().Add(-time.Minute),
+		TokenURL:     srv.URL,
+		ClientID:     "id",
+	}))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tok, ok, err := validGitToken(context.Background(), "gitlab.example.com")
+			assert.NoError(t, err)
+			assert.True(t, ok)
+			assert.Equal(t, "new", tok.AccessToken)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&refreshes))
+}
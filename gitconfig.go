@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"go.coder.com/sail/internal/dockutil"
+	"go.coder.com/sail/internal/xlog"
+)
+
+// gitCredentialsLabel records that the container may use the host's git
+// credentials, see serveGitCredentials.
+const gitCredentialsLabel = sailLabel + ".git_credentials"
+
+// gitCredentialSocketName is the name of the socket git in the container
+// asks for credentials on, in the directory of code-server's socket.
+const gitCredentialSocketName = "git-credential.sock"
+
+// containerGitCredentialHelper is the credential.helper of containers that
+// use the host's credentials. git's cache helper speaks to a daemon on a unix
+// socket, which the proxy serves, so the image needs nothing but git.
+const containerGitCredentialHelper = "cache --socket " + containerSocketDir + "/" + gitCredentialSocketName
+
+// gitConfigScript configures git in the container, with the identity $1 and
+// $2 and the credential helper $3, where they're set and the container's
+// own config doesn't set them already.
+const gitConfigScript = `command -v git >/dev/null || exit 0
+if [ -n "$1" ] && ! git config --global user.name >/dev/null; then
+	git config --global user.name "$1"
+fi
+if [ -n "$2" ] && ! git config --global user.email >/dev/null; then
+	git config --global user.email "$2"
+fi
+if [ -n "$3" ] && ! git config --global --get-all credential.helper | grep -qxF "$3"; then
+	git config --global --add credential.helper "$3"
+fi`
+
+// hostGitConfig returns the value of the git config key in the host's
+// config, as it applies to the repository in dir. It's empty if it's unset.
+func hostGitConfig(dir, key string) string {
+	out, err := exec.Command("git", "-C", dir, "config", "--get", key).Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// configureGit sets the host's git identity in the container, so commits
+// don't fail or get the image's identity, and makes git ask the host for
+// credentials if r.gitCredentials. Images without git are left alone.
+func (r *runner) configureGit() error {
+	if r.windows {
+		return nil
+	}
+	var name, email string
+	if !r.disableGitIdentity {
+		name = hostGitConfig(r.projectLocalDir, "user.name")
+		email = hostGitConfig(r.projectLocalDir, "user.email")
+	}
+	var helper string
+	if r.gitCredentials {
+		helper = containerGitCredentialHelper
+	}
+	if name == "" && email == "" && helper == "" {
+		return nil
+	}
+
+	out, err := dockutil.Exec(r.cntName, "sh", "-c", gitConfigScript, "sh", name, email, helper).CombinedOutput()
+	if err != nil {
+		return xerrors.Errorf("%s: %w", bytes.TrimSpace(out), err)
+	}
+	return nil
+}
+
+// serveGitCredentials serves the host's git credentials to the container
+// cntName on its git credential socket, for the lifetime of the proxy. It
+// speaks the protocol of git credential-cache, and answers with git
+// credential on the host, so the credentials come from the host's helpers.
+// Containers not created with gitCredentialsLabel get no answers.
+func serveGitCredentials(log *xlog.Logger, cntName string) error {
+	dir := socketDir(cntName)
+	err := os.MkdirAll(dir, 0750)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, gitCredentialSocketName)
+	// A previous proxy's socket is replaced.
+	os.Remove(path)
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return xerrors.Errorf("failed to listen on %v: %w", path, err)
+	}
+	defer l.Close()
+	// The container's user may not be the host user.
+	err = os.Chmod(path, 0666)
+	if err != nil {
+		return err
+	}
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			err := handleGitCredential(conn, cntName)
+			if err != nil {
+				log.Error("failed to answer git credential request: %v", err)
+			}
+		}()
+	}
+}
+
+// gitCredentialRequest is a request of git credential-cache: an action,
+// then the credential in git's credential format.
+type gitCredentialRequest struct {
+	action     string
+	credential []string
+}
+
+// readGitCredentialRequest reads a request, up to the blank line or EOF
+// ending the credential.
+func readGitCredentialRequest(r io.Reader) (gitCredentialRequest, error) {
+	var req gitCredentialRequest
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := s.Text()
+		if line == "" {
+			break
+		}
+		switch {
+		case strings.HasPrefix(line, "action="):
+			req.action = strings.TrimPrefix(line, "action=")
+		case strings.HasPrefix(line, "timeout="):
+		default:
+			req.credential = append(req.credential, line)
+		}
+	}
+	if s.Err() != nil {
+		return gitCredentialRequest{}, s.Err()
+	}
+	if req.action == "" {
+		return gitCredentialRequest{}, xerrors.New("request has no action")
+	}
+	return req, nil
+}
+
+// gitCredential is the part of a credential in git's credential format that
+// sail checks and passes on to the host's helpers.
+type gitCredential struct {
+	protocol string
+	// host has its port, if it has one.
+	host     string
+	username string
+}
+
+// parseGitCredential parses the lines of a credential. git credential fill
+// takes the last of repeated attributes, and all of them from url, so those
+// are rejected rather than checking a different host than the one filled.
+// Other attributes, e.g. path, are left out.
+func parseGitCredential(lines []string) (gitCredential, error) {
+	var (
+		c    gitCredential
+		seen = make(map[string]bool)
+	)
+	for _, line := range lines {
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			return gitCredential{}, xerrors.Errorf("invalid credential line %q", line)
+		}
+		var v *string
+		switch kv[0] {
+		case "protocol":
+			v = &c.protocol
+		case "host":
+			v = &c.host
+		case "username":
+			v = &c.username
+		case "url":
+			return gitCredential{}, xerrors.New("credentials with a url aren't supported")
+		default:
+			continue
+		}
+		if seen[kv[0]] {
+			return gitCredential{}, xerrors.Errorf("credential has more than one %v", kv[0])
+		}
+		seen[kv[0]] = true
+		*v = kv[1]
+	}
+	c.host = strings.ToLower(c.host)
+	return c, nil
+}
+
+// String returns c in git's credential format.
+func (c gitCredential) String() string {
+	s := "protocol=" + c.protocol + "\nhost=" + c.host + "\n"
+	if c.username != "" {
+		s += "username=" + c.username + "\n"
+	}
+	return s
+}
+
+// allowGitCredential reports whether the container, whose labels are labels,
+// may get credential. Containers only get the credentials of the host of
+// their project's remote, so a project can't ask for those of other hosts.
+func allowGitCredential(labels map[string]string, credential gitCredential) bool {
+	if labels[gitCredentialsLabel] != "true" || labels[repoLabel] == "" {
+		return false
+	}
+	return credential.host != "" && credential.host == strings.SplitN(normalizeRemote(labels[repoLabel]), "/", 2)[0]
+}
+
+// handleGitCredential answers the get requests of git credential-cache.
+// Containers can't store or erase the host's credentials, so credentials
+// typed in the container aren't kept, and other actions get no answer, like
+// exit, which would stop a real cache daemon.
+func handleGitCredential(conn net.Conn, cntName string) error {
+	conn.SetDeadline(time.Now().Add(time.Minute))
+
+	req, err := readGitCredentialRequest(conn)
+	if err != nil {
+		return err
+	}
+	if req.action != "get" {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	cli := dockerClient()
+	defer cli.Close()
+	cnt, err := inspectContainer(ctx, cli, cntName)
+	if err != nil {
+		return xerrors.Errorf("failed to inspect %v: %w", cntName, err)
+	}
+	credential, err := parseGitCredential(req.credential)
+	if err != nil {
+		return err
+	}
+	if !allowGitCredential(cnt.Config.Labels, credential) {
+		return nil
+	}
+
+	// Hosts sail has tokens for get those.
+	answer, ok, err := gitTokenAnswer(ctx, credential)
+	if err != nil {
+		return xerrors.Errorf("failed to get the token: %w", err)
+	}
+	if ok {
+		_, err = conn.Write(answer)
+		return err
+	}
+
+	cmd := exec.CommandContext(ctx, "git", "credential", "fill")
+	// Only the checked attributes are filled.
+	cmd.Stdin = strings.NewReader(credential.String() + "\n")
+	// There's no terminal to ask on, the host's helpers must answer.
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	out, err := cmd.Output()
+	if err != nil {
+		// Like an empty cache, git in the container asks the user.
+		return nil
+	}
+	_, err = conn.Write(gitCredentialAnswer(out))
+	return err
+}
+
+// gitCredentialAnswer returns the username and password of the output of git
+// credential fill, which is all git credential-cache answers with.
+func gitCredentialAnswer(out []byte) []byte {
+	var b bytes.Buffer
+	for _, line := range strings.Split(string(out), "\n") {
+		if strings.HasPrefix(line, "username=") || strings.HasPrefix(line, "password=") {
+			b.WriteString(line + "\n")
+		}
+	}
+	return b.Bytes()
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_readGitCredentialRequest(t *testing.T) {
+	req, err := readGitCredentialRequest(strings.NewReader("action=get\ntimeout=900\nprotocol=https\nhost=github.com\n\n"))
+	require.NoError(t, err)
+	assert.Equal(t, gitCredentialRequest{
+		action:     "get",
+		credential: []string{"protocol=https", "host=github.com"},
+	}, req)
+
+	// Older clients end requests with EOF.
+	req, err = readGitCredentialRequest(strings.NewReader("action=erase\ntimeout=900\nhost=github.com\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "erase", req.action)
+
+	_, err = readGitCredentialRequest(strings.NewReader("host=github.com\n"))
+	require.Error(t, err)
+}
+
+func Test_gitCredentialAnswer(t *testing.T) {
+	out := "protocol=https\nhost=github.com\nusername=octocat\npassword=hunter2\n"
+	assert.Equal(t, "username=octocat\npassword=hunter2\n", string(gitCredentialAnswer([]byte(out))))
+	assert.Empty(t, gitCredentialAnswer(nil))
+}
+
+func Test_parseGitCredential(t *testing.T) {
+	c, err := parseGitCredential([]string{"protocol=https", "host=GitHub.com", "path=cdr/sail.git", "username=octocat"})
+	require.NoError(t, err)
+	assert.Equal(t, gitCredential{protocol: "https", host: "github.com", username: "octocat"}, c)
+	assert.Equal(t, "protocol=https\nhost=github.com\nusername=octocat\n", c.String())
+
+	// git credential fill takes the last host, and the host of urls.
+	_, err = parseGitCredential([]string{"protocol=https", "host=github.com", "host=internal.example.com"})
+	require.Error(t, err)
+	_, err = parseGitCredential([]string{"protocol=https", "host=github.com", "url=https://internal.example.com"})
+	require.Error(t, err)
+	_, err = parseGitCredential([]string{"protocol=https", "host"})
+	require.Error(t, err)
+}
+
+func Test_allowGitCredential(t *testing.T) {
+	labels := map[string]string{
+		gitCredentialsLabel: "true",
+		repoLabel:           "git@github.com:cdr/sail.git",
+	}
+	assert.True(t, allowGitCredential(labels, gitCredential{protocol: "https", host: "github.com"}))
+	assert.False(t, allowGitCredential(labels, gitCredential{protocol: "https", host: "gitlab.com"}))
+	assert.False(t, allowGitCredential(labels, gitCredential{protocol: "https"}))
+
+	labels[repoLabel] = "https://git.example.com:8443/cdr/sail"
+	assert.True(t, allowGitCredential(labels, gitCredential{protocol: "https", host: "git.example.com:8443"}))
+	assert.False(t, allowGitCredential(labels, gitCredential{protocol: "https", host: "git.example.com"}))
+
+	// Projects without a remote get no credentials.
+	assert.False(t, allowGitCredential(map[string]string{gitCredentialsLabel: "true"}, gitCredential{host: "github.com"}))
+	assert.False(t, allowGitCredential(map[string]string{repoLabel: "https://github.com/cdr/sail"}, gitCredential{host: "github.com"}))
+}
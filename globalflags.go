@@ -9,28 +9,65 @@ import (
 	"os/user"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 
-	"github.com/fatih/color"
 	"golang.org/x/xerrors"
 
-	"go.coder.com/flog"
+	"go.coder.com/sail/internal/xlog"
 )
 
+// stringsFlag is a flag.Value that can be repeated, collecting each value.
+type stringsFlag []string
+
+func (s *stringsFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringsFlag) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
 type globalFlags struct {
 	verbose    bool
 	configPath string
+
+	// dockerContext is the docker context selected by selectDockerContext,
+	// and dockerContextSource is where it was selected.
+	dockerContext       string
+	dockerContextSource string
 }
 
-func (gf *globalFlags) debug(msg string, args ...interface{}) {
-	if !gf.verbose {
-		return
+// verboseFlag is the -v flag, which enables debug logging.
+type verboseFlag struct {
+	verbose *bool
+}
+
+func (f verboseFlag) String() string {
+	if f.verbose == nil {
+		return "false"
 	}
+	return strconv.FormatBool(*f.verbose)
+}
 
-	flog.Log(
-		flog.Level(color.New(color.FgHiMagenta).Sprint("DEBUG")),
-		msg, args...,
-	)
+func (f verboseFlag) Set(v string) error {
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return err
+	}
+	*f.verbose = b
+
+	if b {
+		xlog.SetLevel(xlog.LevelDebug)
+	} else {
+		xlog.SetLevel(xlog.LevelInfo)
+	}
+	return nil
+}
+
+func (f verboseFlag) IsBoolFlag() bool {
+	return true
 }
 
 func (gf *globalFlags) config() config {
@@ -39,6 +76,8 @@ func (gf *globalFlags) config() config {
 
 // ensureDockerDaemon verifies that Docker is running.
 func (gf *globalFlags) ensureDockerDaemon() {
+	gf.selectDockerContext("")
+
 	// docker is installed in /usr/local/bin on MacOS, but this isn't in
 	// $PATH when launched by a browser that was opened via Finder.
 	if runtime.GOOS == "darwin" {
@@ -51,9 +90,9 @@ func (gf *globalFlags) ensureDockerDaemon() {
 	}
 	out, err := exec.Command("docker", "info").CombinedOutput()
 	if err != nil {
-		flog.Fatal("failed to run `docker info`: %v\n%s", err, out)
+		xlog.Fatal("failed to run `docker info`: %v\n%s", err, out)
 	}
-	gf.debug("verified Docker is running")
+	xlog.Debug("verified Docker is running")
 }
 
 func requireRepo(conf config, prefs schemaPrefs, fl *flag.FlagSet) repo {
@@ -64,14 +103,14 @@ func requireRepo(conf config, prefs schemaPrefs, fl *flag.FlagSet) repo {
 	)
 
 	if repoURI == "" {
-		flog.Fatal("Argument <repo> must be provided.")
+		xlog.Fatal("Argument <repo> must be provided.")
 	}
 
 	// if this returns a non-empty string know it's pointing to a valid project on disk
 	// an error indicates an existing path outside of the project dir
 	repoName, err := pathIsRunnable(conf, repoURI)
 	if err != nil {
-		flog.Fatal(err.Error())
+		xlog.Fatal(err.Error())
 	}
 
 	if repoName != "" {
@@ -81,7 +120,7 @@ func requireRepo(conf config, prefs schemaPrefs, fl *flag.FlagSet) repo {
 	} else {
 		r, err = parseRepo(defaultSchema(conf, prefs), conf.DefaultHost, conf.DefaultOrganization, repoURI)
 		if err != nil {
-			flog.Fatal("failed to parse repo %q: %v", repoURI, err)
+			xlog.Fatal("failed to parse repo %q: %v", repoURI, err)
 		}
 	}
 
@@ -153,8 +192,14 @@ func defaultSchema(conf config, prefs schemaPrefs) string {
 // project reads the project as the first parameter.
 func (gf *globalFlags) project(prefs schemaPrefs, fl *flag.FlagSet) *project {
 	conf := gf.config()
-	return &project{
+	proj := &project{
 		conf: conf,
 		repo: requireRepo(conf, prefs, fl),
+		buildOpts: buildOpts{
+			platform: conf.Platform,
+		},
 	}
+	proj.buildOpts.buildArgs = conf.buildArgs(proj.pathName())
+	gf.selectDockerContext(proj.pathName())
+	return proj
 }
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/client"
+	"golang.org/x/xerrors"
+)
+
+// groupNetworkPrefix prefixes the names of the Docker networks of groups.
+const groupNetworkPrefix = "sail-group-"
+
+// groupLabel holds the name of the group of a group's network.
+const groupLabel = sailLabel + ".group"
+
+// groupNameRegex matches the group names that make valid network names.
+var groupNameRegex = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// groupNetwork returns the name of the Docker network of group.
+func groupNetwork(group string) string {
+	return groupNetworkPrefix + group
+}
+
+// checkGroups validates the groups of the config.
+func checkGroups(groups map[string][]string) error {
+	for name, projects := range groups {
+		if !groupNameRegex.MatchString(name) {
+			return xerrors.Errorf("invalid group name %q, must only contain letters, digits, _, . and -", name)
+		}
+		if len(projects) == 0 {
+			return xerrors.Errorf("group %q has no projects", name)
+		}
+	}
+	return nil
+}
+
+// groupsOf returns the sorted names of the groups the project name is in.
+func (c config) groupsOf(name string) []string {
+	var groups []string
+	for group, projects := range c.Groups {
+		if stringsContain(projects, name) {
+			groups = append(groups, group)
+		}
+	}
+	sort.Strings(groups)
+	return groups
+}
+
+// group returns the projects of the group name.
+func (c config) group(name string) ([]string, error) {
+	projects, ok := c.Groups[name]
+	if !ok {
+		var names []string
+		for group := range c.Groups {
+			names = append(names, group)
+		}
+		return nil, xerrors.Errorf("no group %q in the config%v", name, didYouMean(name, names))
+	}
+	return projects, nil
+}
+
+// ensureGroupNetwork creates the network of group, unless it exists.
+func ensureGroupNetwork(ctx context.Context, cli *client.Client, group string) error {
+	_, err := cli.NetworkInspect(ctx, groupNetwork(group), types.NetworkInspectOptions{})
+	if err == nil {
+		return nil
+	}
+	if !client.IsErrNotFound(err) {
+		return xerrors.Errorf("failed to inspect network of group %v: %w", group, err)
+	}
+
+	_, err = cli.NetworkCreate(ctx, groupNetwork(group), types.NetworkCreate{
+		CheckDuplicate: true,
+		Labels: map[string]string{
+			sailLabel:  "",
+			groupLabel: group,
+		},
+	})
+	if err != nil {
+		return xerrors.Errorf("failed to create network of group %v: %w", group, err)
+	}
+	return nil
+}
+
+// joinGroups connects the container cntName to the networks of groups, so
+// the group's environments reach each other by their hostnames, and by their
+// project names with the / replaced by a -, see groupAliases.
+//
+// Containers that share the host's network can't join other networks, they
+// reach each other through the host already.
+func joinGroups(ctx context.Context, cntName string, groups []string) error {
+	if len(groups) == 0 {
+		return nil
+	}
+
+	cli := dockerClient()
+	defer cli.Close()
+
+	cnt, err := inspectContainer(ctx, cli, cntName)
+	if err != nil {
+		return xerrors.Errorf("failed to inspect %v: %w", cntName, err)
+	}
+	if cnt.HostConfig.NetworkMode.IsHost() {
+		return nil
+	}
+
+	for _, group := range groups {
+		if _, ok := cnt.NetworkSettings.Networks[groupNetwork(group)]; ok {
+			continue
+		}
+		err = ensureGroupNetwork(ctx, cli, group)
+		if err != nil {
+			return err
+		}
+		err = cli.NetworkConnect(ctx, groupNetwork(group), cntName, &network.EndpointSettings{
+			Aliases: groupAliases(cnt),
+		})
+		if err != nil {
+			return xerrors.Errorf("failed to join group %v: %w", group, err)
+		}
+	}
+	return nil
+}
+
+// groupAliases returns the names the container cnt is reached at on the
+// networks of its groups. Project names contain a /, which isn't valid in
+// hostnames.
+func groupAliases(cnt types.ContainerJSON) []string {
+	aliases := []string{strings.Replace(cnt.Config.Labels[nameLabel], "/", "-", -1)}
+	if cnt.Config.Hostname != "" && cnt.Config.Hostname != aliases[0] {
+		aliases = append(aliases, cnt.Config.Hostname)
+	}
+	return aliases
+}
+
+// containerGroups returns the groups whose networks the container cnt joined.
+func containerGroups(cnt types.ContainerJSON) []string {
+	var groups []string
+	if cnt.NetworkSettings == nil {
+		return nil
+	}
+	for name := range cnt.NetworkSettings.Networks {
+		if strings.HasPrefix(name, groupNetworkPrefix) {
+			groups = append(groups, strings.TrimPrefix(name, groupNetworkPrefix))
+		}
+	}
+	sort.Strings(groups)
+	return groups
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_checkGroups(t *testing.T) {
+	assert.NoError(t, checkGroups(map[string][]string{
+		"backend-team": {"cdr/api", "cdr/db"},
+	}))
+	assert.Error(t, checkGroups(map[string][]string{
+		"backend team": {"cdr/api"},
+	}))
+	assert.Error(t, checkGroups(map[string][]string{
+		"empty": nil,
+	}))
+}
+
+func Test_configGroups(t *testing.T) {
+	c := config{
+		Groups: map[string][]string{
+			"backend":  {"cdr/api", "cdr/db"},
+			"all":      {"cdr/sail", "cdr/api"},
+			"frontend": {"cdr/sail"},
+		},
+	}
+	assert.Equal(t, []string{"all", "backend"}, c.groupsOf("cdr/api"))
+	assert.Empty(t, c.groupsOf("nhooyr/websocket"))
+
+	projects, err := c.group("backend")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"cdr/api", "cdr/db"}, projects)
+
+	_, err = c.group("backnd")
+	assert.Error(t, err)
+}
+
+func Test_containerGroups(t *testing.T) {
+	var cnt types.ContainerJSON
+	assert.Empty(t, containerGroups(cnt))
+
+	cnt.NetworkSettings = &types.NetworkSettings{
+		Networks: map[string]*network.EndpointSettings{
+			"bridge":                 {},
+			groupNetwork("frontend"): {},
+			groupNetwork("backend"):  {},
+		},
+	}
+	assert.Equal(t, []string{"backend", "frontend"}, containerGroups(cnt))
+}
+
+func Test_groupAliases(t *testing.T) {
+	cnt := types.ContainerJSON{
+		Config: &container.Config{
+			Hostname: "db",
+			Labels: map[string]string{
+				nameLabel: "cdr/db",
+			},
+		},
+	}
+	assert.Equal(t, []string{"cdr-db", "db"}, groupAliases(cnt))
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"os/user"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+// hostGroupPrefix marks a supplementary group that should be resolved to
+// its gid on the host, e.g. host:docker. This is required when the group
+// grants access to a host resource such as a socket or device, as the
+// container's /etc/group usually doesn't agree with the host's.
+const hostGroupPrefix = "host:"
+
+// resolveGroupAdd resolves the supplementary groups for the container user.
+// Groups prefixed with hostGroupPrefix are resolved to the host's gid,
+// everything else is passed through to Docker as is.
+func resolveGroupAdd(groups []string) ([]string, error) {
+	resolved := make([]string, 0, len(groups))
+	for _, g := range groups {
+		g = strings.TrimSpace(g)
+		if g == "" {
+			continue
+		}
+
+		if !strings.HasPrefix(g, hostGroupPrefix) {
+			resolved = append(resolved, g)
+			continue
+		}
+
+		name := strings.TrimPrefix(g, hostGroupPrefix)
+		grp, err := user.LookupGroup(name)
+		if err != nil {
+			return nil, xerrors.Errorf("failed to find host group %q: %w", name, err)
+		}
+		resolved = append(resolved, grp.Gid)
+	}
+	return resolved, nil
+}
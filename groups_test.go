@@ -0,0 +1,16 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_resolveGroupAdd(t *testing.T) {
+	groups, err := resolveGroupAdd([]string{"video", " ", "host:root", "1001"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"video", "0", "1001"}, groups)
+
+	_, err = resolveGroupAdd([]string{"host:sail-group-does-not-exist"})
+	require.Error(t, err)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/docker/go-connections/nat"
+	"golang.org/x/xerrors"
+
+	"go.coder.com/sail/internal/dockutil"
+)
+
+// Guests get their own code-server in the environment, with its own editor
+// state, sharing the filesystem and processes with the owner's.
+const (
+	// guestCodeServerPort is the container port the guest code-server listens
+	// on when ports are published.
+	guestCodeServerPort = "8444"
+	// guestSocketName is the name of the guest code-server's socket, next to
+	// the owner's.
+	guestSocketName = "guest.sock"
+	// guestDataDir holds the guest's settings and editor state.
+	guestDataDir = "~/.local/share/code-server-guest"
+	// guestLogPath is the location of the guest code-server's log.
+	guestLogPath = "/tmp/code-server-guest.log"
+)
+
+// guestScript runs the guest code-server in the container. Its arguments are:
+//
+//	$1 the environment file
+//	$2 the project directory
+//	$3 the socket to listen on, or empty to listen on guestCodeServerPort
+//	$4 the extensions directory
+//	$5 the data directory
+//	$6 the log file
+//
+// The socket is opened up for the host user like the owner's, see
+// launcherScript.
+const guestScript = `set -eo pipefail
+if [ -f "$1" ]; then . "$1"; fi
+cd "$2"
+listen=(--host 0.0.0.0 --port ` + guestCodeServerPort + `)
+if [ -n "$3" ]; then
+rm -f "$3"
+listen=(--socket "$3")
+( for _ in $(seq 600); do if [ -S "$3" ]; then chmod 666 "$3"; exit; fi; sleep 0.1; done ) &
+fi
+exec /usr/bin/code-server "${listen[@]}" --user-data-dir "$5" --extensions-dir "$4" --extra-extensions-dir ~/.vscode/extensions --auth=none \
+--allow-http >"$6" 2>&1`
+
+// guestName is $0 of guestScript.
+const guestName = "sail-guest"
+
+// guest is the guest code-server of a container.
+type guest struct {
+	// endpoint is where the host reaches it.
+	endpoint *codeServerEndpoint
+	// socket is the socket it listens on in the container, if any.
+	socket string
+	// projectDir is the container's project directory.
+	projectDir string
+	// home is the home directory of the container's user.
+	home string
+	// envPath is the container's environment file, see containerEnvPath.
+	envPath string
+}
+
+// loadGuest returns the guest code-server of the container cntName.
+//
+// Containers that share the host's network have no port for it, as the
+// owner's port is found by looking for code-server's.
+func loadGuest(cntName string) (*guest, error) {
+	cli := dockerClient()
+	defer cli.Close()
+
+	cnt, err := inspectContainer(context.Background(), cli, cntName)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to inspect %v: %w", cntName, err)
+	}
+	g := &guest{
+		home:    guestHome(cnt.Config.Labels),
+		envPath: containerEnvPath(cnt),
+	}
+	g.projectDir = resolvePath(g.home, cnt.Config.Labels[projectDirLabel])
+	if socket := cnt.Config.Labels[codeServerSocketLabel]; socket != "" {
+		g.endpoint = newSocketEndpoint(filepath.Join(filepath.Dir(socket), guestSocketName))
+		g.socket = containerSocketDir + "/" + guestSocketName
+		return g, nil
+	}
+
+	bindings := cnt.NetworkSettings.Ports[nat.Port(guestCodeServerPort+"/tcp")]
+	if len(bindings) == 0 {
+		return nil, xerrors.Errorf("%v has no port for guests, rebuild it with sail edit", toSailName(cntName))
+	}
+	g.endpoint = newPortEndpoint(bindings[0].HostPort)
+	return g, nil
+}
+
+// startGuest starts the guest code-server of the container cntName, unless
+// it's running already, and returns its endpoint.
+func startGuest(cntName string) (*codeServerEndpoint, error) {
+	g, err := loadGuest(cntName)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+
+	if g.endpoint.online(ctx) == nil {
+		return g.endpoint, nil
+	}
+
+	out, err := dockutil.DetachedExec(cntName, "bash", "-c", guestScript, guestName,
+		g.envPath,
+		g.projectDir,
+		g.socket,
+		resolvePath(g.home, hostExtensionsDir),
+		resolvePath(g.home, guestDataDir),
+		guestLogPath,
+	).CombinedOutput()
+	if err != nil {
+		return nil, xerrors.Errorf("failed to start guest code-server: %s: %w", out, err)
+	}
+
+	for ctx.Err() == nil {
+		err = g.endpoint.online(ctx)
+		if err == nil {
+			return g.endpoint, nil
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+	return nil, xerrors.Errorf("guest code-server didn't come up, see %v in the container: %w", guestLogPath, err)
+}
+
+// stopGuest stops the guest code-server of the container cntName. Its editor
+// state is kept for the next guest.
+func stopGuest(cntName string) error {
+	home, err := cntGuestHome(cntName)
+	if err != nil {
+		return err
+	}
+	out, err := dockutil.Exec(cntName, "pkill", "-f", "--", "--user-data-dir "+resolvePath(home, guestDataDir)).CombinedOutput()
+	// pkill exits with 1 if nothing matched.
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+		return nil
+	}
+	if err != nil {
+		return xerrors.Errorf("failed to stop guest code-server: %s: %w", out, err)
+	}
+	return nil
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/strslice"
+	"github.com/docker/docker/client"
+	"github.com/docker/go-connections/nat"
+	"golang.org/x/xerrors"
+
+	"go.coder.com/sail/internal/dockutil"
+	"go.coder.com/sail/internal/randstr"
+	"go.coder.com/sail/internal/xlog"
+)
+
+// Modes of the gui config key, which picks how GUI applications of the
+// environment are shown.
+const (
+	// guiAuto forwards the host's X11 display, if it has one.
+	guiAuto = "auto"
+	// guiX11 forwards the host's X11 display.
+	guiX11 = "x11"
+	// guiWayland forwards the host's Wayland display, and its X11 display
+	// for XWayland.
+	guiWayland = "wayland"
+	// guiVNC runs a virtual display in a sidecar, shown in the browser
+	// with noVNC. It works without a display on the host.
+	guiVNC = "vnc"
+	// guiOff doesn't show GUI applications.
+	guiOff = "off"
+)
+
+// guiLabel records the gui mode of containers created with one other than
+// guiAuto.
+const guiLabel = sailLabel + ".gui"
+
+// guiOfLabel names the container whose display a GUI sidecar runs.
+const guiOfLabel = sailLabel + ".gui_of"
+
+// defaultGUIImage is the image of GUI sidecars, which installs Xvfb,
+// x11vnc and noVNC.
+const defaultGUIImage = "alpine:3"
+
+// The virtual display of GUI sidecars. Its socket is shared with the
+// container through a volume.
+const (
+	guiDisplay   = ":99"
+	guiSocketDir = "/tmp/.X11-unix"
+)
+
+// guiWaylandSocket is where the host's Wayland socket is mounted.
+const guiWaylandSocket = "/tmp/sail-wayland-0"
+
+// guiXauthority is the cookie of the virtual display, next to its socket so
+// the container can read it.
+const guiXauthority = guiSocketDir + "/.Xauthority"
+
+// guiPasswordEnv passes the VNC password to GUI sidecars.
+const guiPasswordEnv = "SAIL_VNC_PASSWORD"
+
+// guiScript is the command of GUI sidecars. Xvfb only accepts clients with
+// the cookie of guiXauthority. x11vnc serves the display on the sidecar's
+// loopback, requiring the password of guiPasswordEnv, and websockify serves
+// noVNC and proxies it on 6080.
+const guiScript = `set -e
+command -v Xvfb >/dev/null || apk add --no-cache xvfb xauth x11vnc novnc websockify >/dev/null
+mkdir -p ` + guiSocketDir + `
+chmod 1777 ` + guiSocketDir + `
+rm -f ` + guiSocketDir + `/X99 /tmp/.X99-lock ` + guiXauthority + `
+xauth -f ` + guiXauthority + ` add ` + guiDisplay + ` . "$(od -An -N16 -tx1 /dev/urandom | tr -d ' \n')"
+chmod 644 ` + guiXauthority + `
+Xvfb ` + guiDisplay + ` -screen 0 1920x1080x24 -auth ` + guiXauthority + ` -nolisten tcp &
+while [ ! -e ` + guiSocketDir + `/X99 ]; do sleep 0.1; done
+x11vnc -storepasswd "$` + guiPasswordEnv + `" /tmp/vncpasswd >/dev/null
+x11vnc -display ` + guiDisplay + ` -auth ` + guiXauthority + ` -forever -shared -rfbauth /tmp/vncpasswd -localhost -rfbport 5900 -quiet -bg
+novnc=/usr/share/novnc
+[ -d "$novnc" ] || novnc=/usr/share/webapps/novnc
+exec websockify --web "$novnc" 6080 localhost:5900`
+
+// guiName returns the name of the GUI sidecar of the container cntName, and
+// of the volume holding its display's socket.
+func guiName(cntName string) string {
+	return cntName + "-gui"
+}
+
+// guiMode returns the runner's effective gui mode. Sandboxed containers
+// don't get the host's displays, only virtual ones.
+func (r *runner) guiMode() string {
+	switch r.gui {
+	case "", guiAuto:
+		if runtime.GOOS != "linux" || r.windows || r.sandbox || os.Getenv("DISPLAY") == "" {
+			return guiOff
+		}
+		return guiX11
+	case guiX11, guiWayland:
+		if r.sandbox || r.windows {
+			return guiOff
+		}
+	}
+	return r.gui
+}
+
+// checkGUI validates the runner's gui mode against the host and daemon.
+func (r *runner) checkGUI() error {
+	switch r.gui {
+	case guiX11, guiWayland:
+		if r.sandbox {
+			xlog.Warn("sandboxed projects can't use the host's display, use gui = %q", guiVNC)
+			return nil
+		}
+		if runtime.GOOS != "linux" || r.desktop {
+			return xerrors.Errorf("gui %v needs Docker on a Linux host, use gui = %q", r.gui, guiVNC)
+		}
+		if r.gui == guiWayland && os.Getenv("WAYLAND_DISPLAY") == "" {
+			return xerrors.New("gui wayland needs WAYLAND_DISPLAY")
+		}
+		if r.gui == guiX11 && os.Getenv("DISPLAY") == "" {
+			return xerrors.New("gui x11 needs DISPLAY")
+		}
+	case guiVNC:
+		if r.windows || !r.usesDocker() {
+			return xerrors.New("gui vnc needs Linux containers run by Docker")
+		}
+	}
+	return nil
+}
+
+// waylandSocket returns the host's Wayland socket.
+func waylandSocket() string {
+	display := os.Getenv("WAYLAND_DISPLAY")
+	if display == "" || filepath.IsAbs(display) {
+		return display
+	}
+	return filepath.Join(os.Getenv("XDG_RUNTIME_DIR"), display)
+}
+
+// mountGUI mounts the sockets of the host's displays, and the Xauthority
+// file so X11 clients can authenticate, as the gui mode asks for.
+func (r *runner) mountGUI(mounts []mount.Mount) []mount.Mount {
+	mode := r.guiMode()
+	if mode != guiX11 && mode != guiWayland {
+		return mounts
+	}
+
+	if mode == guiWayland {
+		mounts = append(mounts, mount.Mount{
+			Type:   "bind",
+			Source: waylandSocket(),
+			Target: guiWaylandSocket,
+		})
+	}
+	// XWayland also serves X11 clients on Wayland.
+	if os.Getenv("DISPLAY") == "" {
+		return mounts
+	}
+	mounts = append(mounts, mount.Mount{
+		Type:   "bind",
+		Source: guiSocketDir,
+		Target: guiSocketDir,
+	})
+	if os.Getenv("XAUTHORITY") != "" {
+		mounts = append(mounts, mount.Mount{
+			Type:   "bind",
+			Source: os.Getenv("XAUTHORITY"),
+			Target: "~/.Xauthority",
+		})
+	}
+	return mounts
+}
+
+// mountVNC mounts the volume of the GUI sidecar's display socket, if the gui
+// mode is guiVNC.
+func (r *runner) mountVNC(mounts []mount.Mount) []mount.Mount {
+	if r.guiMode() != guiVNC {
+		return mounts
+	}
+	return append(mounts, mount.Mount{
+		Type:   mount.TypeVolume,
+		Source: guiName(r.projectCntName()),
+		Target: guiSocketDir,
+	})
+}
+
+// guiEnv returns the environment variables pointing GUI applications at the
+// display of the gui mode.
+func (r *runner) guiEnv() []string {
+	var envs []string
+	switch r.guiMode() {
+	case guiVNC:
+		return []string{"DISPLAY=" + guiDisplay, "XAUTHORITY=" + guiXauthority}
+	case guiWayland:
+		envs = append(envs, "WAYLAND_DISPLAY="+guiWaylandSocket)
+	case guiX11:
+	default:
+		return nil
+	}
+	if os.Getenv("DISPLAY") != "" {
+		envs = append(envs, "DISPLAY="+os.Getenv("DISPLAY"))
+	}
+	if os.Getenv("XAUTHORITY") != "" {
+		envs = append(envs, "XAUTHORITY="+filepath.Join(r.guestHome(), ".Xauthority"))
+	}
+	return envs
+}
+
+// guiURL returns the URL of noVNC for the port it's published on, which logs
+// in with the VNC password. The password is in the fragment, so it isn't
+// sent to websockify.
+func guiURL(port, password string) string {
+	return "http://127.0.0.1:" + port + "/vnc.html?autoconnect=true&resize=scale#password=" + url.QueryEscape(password)
+}
+
+// guiPasswordPath is where the VNC password of the GUI sidecar of the
+// container cntName is kept.
+func guiPasswordPath(cntName string) string {
+	return filepath.Join(metaRoot(), cntName, "gui-password")
+}
+
+// loadGUIPassword returns the VNC password of the GUI sidecar of the
+// container cntName.
+func loadGUIPassword(cntName string) (string, error) {
+	b, err := ioutil.ReadFile(guiPasswordPath(cntName))
+	if err != nil {
+		return "", xerrors.Errorf("failed to read the VNC password: %w", err)
+	}
+	return string(b), nil
+}
+
+// newGUIPassword generates the VNC password of the GUI sidecar of the
+// container cntName, which only the host user may read. VNC only uses the
+// first 8 characters of passwords.
+func newGUIPassword(cntName string) (string, error) {
+	password := randstr.Make(8)
+	err := os.MkdirAll(filepath.Dir(guiPasswordPath(cntName)), 0750)
+	if err != nil {
+		return "", err
+	}
+	err = writeFileAtomic(guiPasswordPath(cntName), []byte(password), 0600)
+	if err != nil {
+		return "", xerrors.Errorf("failed to write the VNC password: %w", err)
+	}
+	return password, nil
+}
+
+// createGUI creates and starts the GUI sidecar of the runner's container,
+// replacing the one of a previous container, and waits until noVNC serves
+// its display.
+func (r *runner) createGUI(ctx context.Context, to timeouts) error {
+	cli := dockerClient()
+	defer cli.Close()
+
+	image := r.guiImage
+	if image == "" {
+		image = defaultGUIImage
+	}
+	_, err := ensureImage(ctx, image, "")
+	if err != nil {
+		return xerrors.Errorf("failed to ensure gui image %v: %w", image, err)
+	}
+	port, err := assignPort(r.projectCntName(), guiPortName)
+	if err != nil {
+		return err
+	}
+	password, err := newGUIPassword(r.projectCntName())
+	if err != nil {
+		return err
+	}
+	exposed, bindings, err := nat.ParsePortSpecs([]string{"127.0.0.1:" + port + ":6080/tcp"})
+	if err != nil {
+		return xerrors.Errorf("failed to parse port spec: %w", err)
+	}
+
+	containerConfig := &container.Config{
+		Image:        image,
+		Cmd:          strslice.StrSlice{"sh", "-c", guiScript},
+		Env:          []string{guiPasswordEnv + "=" + password},
+		ExposedPorts: exposed,
+		Labels:       map[string]string{guiOfLabel: r.projectCntName()},
+	}
+	hostConfig := &container.HostConfig{
+		PortBindings: bindings,
+		Mounts: []mount.Mount{{
+			Type:   mount.TypeVolume,
+			Source: guiName(r.projectCntName()),
+			Target: guiSocketDir,
+		}},
+		RestartPolicy: container.RestartPolicy{Name: "unless-stopped"},
+	}
+
+	name := guiName(r.projectCntName())
+	err = dockutil.RemovePartial(ctx, cli, name)
+	if err != nil {
+		return err
+	}
+	_, err = cli.ContainerCreate(ctx, containerConfig, hostConfig, nil, name)
+	if err != nil {
+		return xerrors.Errorf("failed to create gui container: %w", err)
+	}
+	err = cli.ContainerStart(ctx, name, types.ContainerStartOptions{})
+	if err != nil {
+		return xerrors.Errorf("failed to start gui container: %w", err)
+	}
+
+	err = waitURL(ctx, guiURL(port, password), to.create+to.start)
+	if err != nil {
+		return xerrors.Errorf("noVNC didn't start, see docker logs %v: %w", name, err)
+	}
+	xlog.Info("GUI applications are shown at %v", guiURL(port, password))
+	return nil
+}
+
+// removeSidecars removes the containers sail runs next to the container
+// cntName, see applyEgress and createGUI, and the volume of its display.
+func removeSidecars(ctx context.Context, cli *client.Client, cntName string) error {
+	for _, name := range []string{egressName(cntName), guiName(cntName)} {
+		err := dockutil.RemovePartial(ctx, cli, name)
+		if err != nil {
+			return xerrors.Errorf("failed to remove %v: %w", name, err)
+		}
+	}
+	err := cli.VolumeRemove(ctx, guiName(cntName), true)
+	if err != nil && !client.IsErrNotFound(err) {
+		return xerrors.Errorf("failed to remove volume %v: %w", guiName(cntName), err)
+	}
+	return nil
+}
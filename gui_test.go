@@ -0,0 +1,101 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"runtime"
+	"testing"
+
+	"github.com/docker/docker/api/types/mount"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setenv sets the environment variable k to v, unsetting it if v is empty,
+// and returns a func restoring it.
+func setenv(k, v string) func() {
+	old, ok := os.LookupEnv(k)
+	if v == "" {
+		os.Unsetenv(k)
+	} else {
+		os.Setenv(k, v)
+	}
+	return func() {
+		if ok {
+			os.Setenv(k, old)
+		} else {
+			os.Unsetenv(k)
+		}
+	}
+}
+
+func Test_guiMode(t *testing.T) {
+	defer setenv("DISPLAY", ":0")()
+	defer setenv("XAUTHORITY", "")()
+
+	auto := guiOff
+	if runtime.GOOS == "linux" {
+		auto = guiX11
+	}
+	assert.Equal(t, auto, (&runner{}).guiMode())
+	assert.Equal(t, guiOff, (&runner{sandbox: true}).guiMode())
+	assert.Equal(t, guiOff, (&runner{gui: guiWayland, sandbox: true}).guiMode())
+	assert.Equal(t, guiVNC, (&runner{gui: guiVNC, sandbox: true}).guiMode())
+	assert.Equal(t, guiOff, (&runner{gui: guiOff}).guiMode())
+
+	defer setenv("DISPLAY", "")()
+	assert.Equal(t, guiOff, (&runner{}).guiMode())
+	require.Error(t, (&runner{gui: guiX11}).checkGUI())
+	require.Error(t, (&runner{gui: guiVNC, windows: true}).checkGUI())
+	require.NoError(t, (&runner{gui: guiVNC}).checkGUI())
+}
+
+func Test_guiVNC(t *testing.T) {
+	r := &runner{cntName: "cdr_sail", gui: guiVNC}
+	assert.Equal(t, []string{"DISPLAY=" + guiDisplay, "XAUTHORITY=" + guiXauthority}, r.guiEnv())
+	assert.Equal(t, []mount.Mount{{
+		Type:   mount.TypeVolume,
+		Source: "cdr_sail-gui",
+		Target: guiSocketDir,
+	}}, r.mountVNC(nil))
+	// The host's display isn't mounted.
+	assert.Empty(t, r.mountGUI(nil))
+}
+
+func Test_guiPassword(t *testing.T) {
+	home, err := ioutil.TempDir("", "sail-gui")
+	require.NoError(t, err)
+	defer os.RemoveAll(home)
+	defer setenv("HOME", home)()
+
+	_, err = loadGUIPassword("cdr_sail")
+	assert.Error(t, err)
+
+	password, err := newGUIPassword("cdr_sail")
+	require.NoError(t, err)
+	assert.Len(t, password, 8)
+	loaded, err := loadGUIPassword("cdr_sail")
+	require.NoError(t, err)
+	assert.Equal(t, password, loaded)
+
+	fi, err := os.Stat(guiPasswordPath("cdr_sail"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+
+	assert.Equal(t, "http://127.0.0.1:6080/vnc.html?autoconnect=true&resize=scale#password=a%2Bb", guiURL("6080", "a+b"))
+}
+
+func Test_guiWayland(t *testing.T) {
+	defer setenv("DISPLAY", "")()
+	defer setenv("WAYLAND_DISPLAY", "wayland-1")()
+	defer setenv("XDG_RUNTIME_DIR", "/run/user/1000")()
+	assert.Equal(t, "/run/user/1000/wayland-1", waylandSocket())
+
+	r := &runner{gui: guiWayland}
+	assert.Equal(t, []string{"WAYLAND_DISPLAY=" + guiWaylandSocket}, r.guiEnv())
+	assert.Equal(t, []mount.Mount{{
+		Type:   "bind",
+		Source: "/run/user/1000/wayland-1",
+		Target: guiWaylandSocket,
+	}}, r.mountGUI(nil))
+}
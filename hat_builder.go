@@ -4,17 +4,18 @@ import (
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/docker/docker/client"
 	"golang.org/x/xerrors"
 
-	"go.coder.com/flog"
 	"go.coder.com/sail/internal/hat"
-	"go.coder.com/sail/internal/xexec"
+	"go.coder.com/sail/internal/xlog"
 )
 
 // hatBuilder is responsible for applying a hat to a base image.
@@ -26,6 +27,14 @@ type hatBuilder struct {
 	hatPath string
 	// baseImage is the image before the hat is applied.
 	baseImage string
+	// buildTimeout limits how long building the hat may take.
+	// There is no limit if it's zero.
+	buildTimeout time.Duration
+	// opts are used when building the hat.
+	opts buildOpts
+	// args are the values of the hat's parameters, see hat.Config. The
+	// parameters that aren't set use their defaults.
+	args map[string]string
 }
 
 // dockerClient returns an instantiated docker client that
@@ -44,12 +53,14 @@ func dockerClient() *client.Client {
 	return cli
 }
 
-func (b *hatBuilder) resolveHatPath() (string, error) {
-	const ghPrefix = "github:"
+// githubHatPrefix marks hats that are cloned from a GitHub repository, e.g.
+// github:ammario/dotfiles.
+const githubHatPrefix = "github:"
 
+func (b *hatBuilder) resolveHatPath() (string, error) {
 	hatPath := b.hatPath
-	if strings.HasPrefix(b.hatPath, ghPrefix) {
-		hatPath = strings.TrimLeft(b.hatPath, ghPrefix)
+	if strings.HasPrefix(b.hatPath, githubHatPrefix) {
+		hatPath = strings.TrimLeft(b.hatPath, githubHatPrefix)
 		return hat.ResolveGitHubPath(hatPath)
 	}
 
@@ -61,27 +72,117 @@ func (b *hatBuilder) resolveHatPath() (string, error) {
 	return resolvePath(hostHomeDir, hatPath), nil
 }
 
-// applyHat applies the hat to the base image.
-func (b *hatBuilder) applyHat() (string, error) {
+// hatDockerfile returns the resolved hat path and its Dockerfile based on the
+// base image, along with the name of the image it builds.
+func (b *hatBuilder) hatDockerfile() (hatPath string, dockerFileByt []byte, imageName string, _ error) {
 	if b.hatPath == "" {
-		return "", xerrors.New("unable to apply hat, none specified")
+		return "", nil, "", xerrors.New("unable to apply hat, none specified")
 	}
 
 	hatPath, err := b.resolveHatPath()
 	if err != nil {
-		return "", xerrors.Errorf("failed to resolve hat path: %w", err)
+		return "", nil, "", xerrors.Errorf("failed to resolve hat path: %w", err)
 	}
 
-	dockerFilePath := hatPath
+	dockerFilePath := hatDockerfilePath(hatPath)
+	dockerFileByt, err = ioutil.ReadFile(dockerFilePath)
+	if err != nil {
+		return "", nil, "", xerrors.Errorf("failed to read %v: %w", dockerFilePath, err)
+	}
+	dockerFileByt = hat.DockerReplaceFrom(dockerFileByt, b.baseImage)
+
+	args, err := b.hatArgs(hatPath)
+	if err != nil {
+		return "", nil, "", err
+	}
+
+	// We tag based on the checksum of the Dockerfile to avoid spamming
+	// images. The hat's arguments are part of it, as they change the image.
+	h := sha256.New()
+	h.Write(dockerFileByt)
+	for _, k := range sortedKeys(args) {
+		fmt.Fprintf(h, "\n%v=%v", k, args[k])
+	}
+	imageName = hatImageName(b.baseImage, hex.EncodeToString(h.Sum(nil))[:16])
+	return hatPath, dockerFileByt, imageName, nil
+}
+
+// hatDockerfilePath returns the path of the Dockerfile of the hat at
+// hatPath, which is either the Dockerfile or its directory.
+func hatDockerfilePath(hatPath string) string {
 	if base := filepath.Base(hatPath); strings.ToLower(base) != "dockerfile" {
-		dockerFilePath = filepath.Join(hatPath, "Dockerfile")
+		return filepath.Join(hatPath, "Dockerfile")
 	}
+	return hatPath
+}
 
-	dockerFileByt, err := ioutil.ReadFile(dockerFilePath)
+// hatArgs returns the values of the parameters of the hat at hatPath,
+// declared in the hat.toml next to its Dockerfile.
+func (b *hatBuilder) hatArgs(hatPath string) (map[string]string, error) {
+	conf, err := hat.LoadConfig(filepath.Dir(hatDockerfilePath(hatPath)))
 	if err != nil {
-		return "", xerrors.Errorf("failed to read %v: %w", dockerFilePath, err)
+		return nil, err
+	}
+	args, err := conf.Args(b.args)
+	if err != nil {
+		return nil, xerrors.Errorf("hat %v: %w", b.hatPath, err)
+	}
+	return args, nil
+}
+
+// parseHatArgs parses the values of hat parameters of form name=value.
+func parseHatArgs(kvs []string) (map[string]string, error) {
+	args := make(map[string]string, len(kvs))
+	for _, kv := range kvs {
+		toks := strings.SplitN(kv, "=", 2)
+		if len(toks) != 2 || !buildArgNameRx.MatchString(toks[0]) {
+			return nil, xerrors.Errorf("invalid hat argument %q, must be of form name=value", kv)
+		}
+		args[toks[0]] = toks[1]
+	}
+	return args, nil
+}
+
+// formatHatArgs formats args as the value of hatArgsLabel.
+func formatHatArgs(args map[string]string) string {
+	kvs := make([]string, 0, len(args))
+	for _, k := range sortedKeys(args) {
+		kvs = append(kvs, k+"="+args[k])
+	}
+	return strings.Join(kvs, "\n")
+}
+
+// labelHatArgs returns the hat arguments in hatArgsLabel of labels.
+func labelHatArgs(labels map[string]string) (map[string]string, error) {
+	v := labels[hatArgsLabel]
+	if v == "" {
+		return nil, nil
+	}
+	return parseHatArgs(strings.Split(v, "\n"))
+}
+
+// hatImageName returns the name of the image of a hat with checksum sum
+// applied to base.
+func hatImageName(base, sum string) string {
+	return derivedImageName(base, "hat", sum)
+}
+
+// derivedImageName returns the name of the image built on base by kind, with
+// checksum sum. Bases pinned to a digest are tagged after their digest, as
+// digests can't be extended.
+func derivedImageName(base, kind, sum string) string {
+	if i := strings.LastIndex(base, "@"); i >= 0 {
+		base = untaggedRef(base) + ":" + strings.Replace(base[i+1:], ":", "-", 1)
+	}
+	return base + "-" + kind + "-" + sum
+}
+
+// applyHat applies the hat to the base image.
+func (b *hatBuilder) applyHat() (string, error) {
+	hatPath, dockerFileByt, imageName, err := b.hatDockerfile()
+	if err != nil {
+		return "", err
 	}
-	dockerFileByt = hat.DockerReplaceFrom(dockerFileByt, b.baseImage)
 
 	fi, err := ioutil.TempFile("", "hat")
 	if err != nil {
@@ -95,21 +196,43 @@ func (b *hatBuilder) applyHat() (string, error) {
 		return "", xerrors.Errorf("failed to write to %v: %w", fi.Name(), err)
 	}
 
-	// We tag based on the checksum of the Dockerfile to avoid spamming
-	// images.
-	csm := sha256.Sum256(dockerFileByt)
-	imageName := b.baseImage + "-hat-" + hex.EncodeToString(csm[:])[:16]
-
-	flog.Info("building hat image %v", imageName)
-	cmd := xexec.Fmt("docker build --network=host -t %v -f %v %v --label %v=%v --label %v=%v",
-		imageName, fi.Name(), hatPath, baseImageLabel, b.baseImage, hatLabel, b.hatPath,
-	)
-	xexec.Attach(cmd)
-	err = cmd.Run()
+	ctx := context.Background()
+	if b.buildTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, b.buildTimeout)
+		defer cancel()
+	}
+
+	args, err := b.hatArgs(hatPath)
+	if err != nil {
+		return "", err
+	}
+	opts := b.opts
+	opts.buildArgs = append([]string(nil), opts.buildArgs...)
+	for _, k := range sortedKeys(args) {
+		opts.buildArgs = append(opts.buildArgs, k+"="+args[k])
+	}
+
+	buildArgs := []string{
+		opts.network(), "-t", imageName, "-f", fi.Name(),
+		"--label", baseImageLabel + "=" + b.baseImage,
+		"--label", hatLabel + "=" + b.hatPath,
+	}
+	if len(b.args) > 0 {
+		buildArgs = append(buildArgs, "--label", hatArgsLabel+"="+formatHatArgs(b.args))
+	}
+
+	xlog.Info("building hat image %v", imageName)
+	err = dockerBuild(ctx, opts, append(buildArgs, hatPath), "")
 	if err != nil {
 		return "", xerrors.Errorf("failed to build hatted baseImage: %w", err)
 	}
 
+	err = validateImageLabels(imageName)
+	if err != nil {
+		return "", xerrors.Errorf("invalid label in hat %v: %w", b.hatPath, err)
+	}
+
 	return imageName, nil
 }
 
@@ -119,13 +242,19 @@ func hatBuilderFromContainer(name string) (*hatBuilder, error) {
 	cli := dockerClient()
 	defer cli.Close()
 
-	cnt, err := cli.ContainerInspect(context.Background(), name)
+	cnt, err := inspectContainer(context.Background(), cli, name)
 	if err != nil {
 		return nil, xerrors.Errorf("failed to inspect %v: %w", name, err)
 	}
 
+	hatArgs, err := labelHatArgs(cnt.Config.Labels)
+	if err != nil {
+		return nil, err
+	}
+
 	return &hatBuilder{
 		baseImage: cnt.Config.Labels[baseImageLabel],
 		hatPath:   cnt.Config.Labels[hatLabel],
+		args:      hatArgs,
 	}, nil
 }
@@ -3,6 +3,7 @@ package main
 import (
 	"testing"
 
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
@@ -71,3 +72,33 @@ func Test_hatBuilder(t *testing.T) {
 		})
 	}
 }
+
+func Test_hatArgs(t *testing.T) {
+	b := &hatBuilder{
+		baseImage: "codercom/ubuntu-dev",
+		hatPath:   "./hat-examples/go",
+	}
+	_, _, defaultImage, err := b.hatDockerfile()
+	require.NoError(t, err)
+
+	b.args = map[string]string{"go_version": "1.12.9"}
+	_, _, image, err := b.hatDockerfile()
+	require.NoError(t, err)
+	assert.NotEqual(t, defaultImage, image)
+
+	args, err := b.hatArgs(b.hatPath)
+	require.NoError(t, err)
+	assert.Equal(t, b.args, args)
+
+	b.args = map[string]string{"go_versoin": "1.12.9"}
+	_, _, _, err = b.hatDockerfile()
+	require.Error(t, err)
+
+	labels := map[string]string{hatArgsLabel: formatHatArgs(map[string]string{"a": "1", "b": "x=y"})}
+	args, err = labelHatArgs(labels)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"a": "1", "b": "x=y"}, args)
+
+	_, err = parseHatArgs([]string{"go version=1.13"})
+	require.Error(t, err)
+}
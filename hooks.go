@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+
+	"golang.org/x/xerrors"
+
+	"go.coder.com/sail/internal/xexec"
+	"go.coder.com/sail/internal/xlog"
+)
+
+// Lifecycle hooks.
+//
+// Host hooks are executables in <metaRoot>/hooks named after the hook. They
+// are only configured globally, as running scripts from a freshly cloned
+// repository on the host would be unsafe.
+//
+// Project hooks are scripts in the project's .sail/hooks directory named
+// <hook>.sh, or <hook>.ps1 for Windows containers. They run inside of the
+// container, in the project directory.
+const (
+	// preCreateHook runs on the host before the container is created.
+	preCreateHook = "pre_create"
+	// postStartHook runs once code-server is online, each time sail run
+	// starts the container.
+	postStartHook = "post_start"
+	// preRemoveHook runs before the container is removed.
+	preRemoveHook = "pre_remove"
+)
+
+// hookEnv is the environment hooks run with.
+type hookEnv struct {
+	// project is the sail name of the project, e.g. cdr/sail.
+	project string
+	cntName string
+	// localDir is the project directory on the host.
+	localDir string
+	// cntDir is the project directory inside of the container.
+	cntDir string
+	// url is the URL code-server is available at, if it's running.
+	url string
+	// running is whether the container is running. Project hooks can
+	// only run in running containers.
+	running bool
+	// windows is whether the container is a Windows container, whose
+	// commands run with PowerShell.
+	windows bool
+}
+
+func (e hookEnv) environ() []string {
+	return []string{
+		"SAIL_PROJECT=" + e.project,
+		"SAIL_CONTAINER=" + e.cntName,
+		"SAIL_PROJECT_DIR=" + e.localDir,
+		"SAIL_CONTAINER_PROJECT_DIR=" + e.guestPath(e.cntDir),
+		"SAIL_URL=" + e.url,
+	}
+}
+
+// guestPath converts the guest path p to a path of the container's platform.
+func (e hookEnv) guestPath(p string) string {
+	if e.windows {
+		return windowsGuestPath(p)
+	}
+	return p
+}
+
+func hostHookPath(hook string) string {
+	return filepath.Join(metaRoot(), "hooks", hook)
+}
+
+// runHostHook runs the global host hook named hook, if one exists.
+func runHostHook(ctx context.Context, hook string, env hookEnv) error {
+	hookPath := hostHookPath(hook)
+	_, err := os.Stat(hookPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return xerrors.Errorf("failed to stat %v hook: %w", hook, err)
+	}
+
+	xlog.Info("running %v host hook", hook)
+	cmd := exec.CommandContext(ctx, hookPath)
+	cmd.Dir = env.localDir
+	cmd.Env = append(os.Environ(), env.environ()...)
+	xexec.Attach(cmd)
+
+	err = cmd.Run()
+	if err != nil {
+		return xerrors.Errorf("%v host hook failed: %w", hook, err)
+	}
+	return nil
+}
+
+// runProjectHook runs the project's hook named hook inside of the container,
+// if the project has one.
+func runProjectHook(hook string, env hookEnv) error {
+	if !env.running {
+		return nil
+	}
+
+	script := hook + ".sh"
+	if env.windows {
+		script = hook + ".ps1"
+	}
+	rel := path.Join(".sail", "hooks", script)
+	_, err := os.Stat(filepath.Join(env.localDir, filepath.FromSlash(rel)))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return xerrors.Errorf("failed to stat %v hook: %w", hook, err)
+	}
+
+	xlog.Info("running %v project hook", hook)
+
+	cmd := []string{"/bin/bash", path.Join(env.cntDir, rel)}
+	if env.windows {
+		cmd = []string{"powershell", "-NoProfile", "-NonInteractive", "-File", env.guestPath(path.Join(env.cntDir, rel))}
+	}
+	err = execInProject(env, cmd...)
+	if err != nil {
+		return xerrors.Errorf("%v project hook failed: %w", hook, err)
+	}
+	return nil
+}
+
+// execInProject runs cmd inside of the container in the project directory,
+// attached to sail's stdio.
+func execInProject(env hookEnv, cmd ...string) error {
+	args := []string{"exec", "-w", env.guestPath(env.cntDir), "-i"}
+	for _, e := range env.environ() {
+		args = append(args, "-e", e)
+	}
+	args = append(args, env.cntName)
+	args = append(args, cmd...)
+
+	c := exec.Command("docker", args...)
+	xexec.Attach(c)
+	return c.Run()
+}
+
+// Image labels with commands that run inside of the container once
+// code-server is online, for image authors that can't ship hook scripts
+// in the repository. The commands run with /bin/bash in the project directory,
+// or PowerShell in Windows containers.
+const (
+	// onCreateCmdLabel runs after a new container is created.
+	onCreateCmdLabel = sailLabel + ".on_create_cmd"
+	// postStartCmdLabel runs each time sail run starts the container,
+	// following on_create_cmd.
+	postStartCmdLabel = sailLabel + ".post_start_cmd"
+)
+
+// runLabelCommands runs the command labels in labels that apply to a
+// container that was just started, and created if created.
+func runLabelCommands(env hookEnv, labels map[string]string, created bool) error {
+	for _, l := range []string{onCreateCmdLabel, postStartCmdLabel} {
+		if l == onCreateCmdLabel && !created {
+			continue
+		}
+		cmd, ok := labels[l]
+		if !ok || cmd == "" {
+			continue
+		}
+
+		xlog.Info("running %v", l)
+		err := execInProject(env, shellCommand(env.windows, cmd)...)
+		if err != nil {
+			return xerrors.Errorf("%v failed: %w", l, err)
+		}
+	}
+	return nil
+}
+
+// runStartHooks runs the command labels of image, and then the post_start
+// hooks, in the container cntName that was just started, and created if
+// created. The environment is usable even if they fail, so failures are only
+// logged.
+func runStartHooks(ctx context.Context, cntName, image string, created bool) {
+	env, err := hookEnvFromContainer(ctx, cntName)
+	if err != nil {
+		xlog.Error("failed to run %v hooks: %v", postStartHook, err)
+		return
+	}
+	labels, err := imageLabels(image)
+	if err == nil {
+		err = runLabelCommands(env, labels, created)
+	}
+	if err != nil {
+		xlog.Error("%v", err)
+	}
+
+	err = runHooks(ctx, postStartHook, env)
+	if err != nil {
+		xlog.Error("%v", err)
+	}
+}
+
+// runHooks runs the host hook, and then the project hook.
+func runHooks(ctx context.Context, hook string, env hookEnv) error {
+	err := runHostHook(ctx, hook, env)
+	if err != nil {
+		return err
+	}
+	return runProjectHook(hook, env)
+}
+
+// hookEnvFromContainer builds the hook environment of an existing container.
+func hookEnvFromContainer(ctx context.Context, cntName string) (hookEnv, error) {
+	cli := dockerClient()
+	defer cli.Close()
+
+	cnt, err := inspectContainer(ctx, cli, cntName)
+	if err != nil {
+		return hookEnv{}, xerrors.Errorf("failed to inspect %v: %w", cntName, err)
+	}
+
+	return hookEnvFromLabels(cntName, cnt.Config.Labels, cnt.State.Running, cnt.Platform == windowsOSType), nil
+}
+
+// hookEnvFromLabels builds the hook environment of the container cntName
+// from its labels.
+func hookEnvFromLabels(cntName string, labels map[string]string, running, windows bool) hookEnv {
+	env := hookEnv{
+		project:  labels[nameLabel],
+		cntName:  cntName,
+		localDir: labels[projectLocalDirLabel],
+		cntDir:   resolvePath(guestHome(labels), labels[projectDirLabel]),
+		url:      labels[proxyURLLabel],
+		running:  running,
+		windows:  windows,
+	}
+	if env.project == "" {
+		env.project = toSailName(cntName)
+	}
+	return env
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/go-connections/nat"
+	"golang.org/x/xerrors"
+
+	"go.coder.com/sail/internal/xexec"
+	"go.coder.com/sail/internal/xlog"
+)
+
+// Resources of microVMs, which unlike containers must be sized up front.
+// The memory of the container's quota is used instead if it's set.
+const (
+	igniteCPUs   = "2"
+	igniteMemory = "4GB"
+	igniteSize   = "20GB"
+)
+
+// igniteKernelArgs are Ignite's default kernel arguments, with sail's init.
+const igniteKernelArgs = "console=ttyS0 reboot=k panic=1 pci=off ip=dhcp init=" + lxdInitPath
+
+// igniteEnvPath holds the environment of VMs, which their init process and
+// shells read. It's only readable by the container's user.
+const igniteEnvPath = "/usr/local/etc/sail-env"
+
+// igniteBackend runs containers in Firecracker microVMs with Ignite, so
+// untrusted projects are isolated by a VM with its own kernel rather than by
+// the container's namespaces. VMs don't share the host's filesystem: the
+// sources of bind mounts are copied into the VM when it's created, and
+// changes made in it stay there.
+type igniteBackend struct {
+	// projectOnly copies only the project directory and sail's own files
+	// into the VM, for projects that weren't trusted.
+	projectOnly bool
+}
+
+// command returns the command running ignite with args. Ignite needs root,
+// so it's run with sudo otherwise.
+func (igniteBackend) command(ctx context.Context, args ...string) *exec.Cmd {
+	if os.Geteuid() != 0 {
+		return exec.CommandContext(ctx, "sudo", append([]string{"-n", "ignite"}, args...)...)
+	}
+	return exec.CommandContext(ctx, "ignite", args...)
+}
+
+// run runs ignite with args, returning its output.
+func (b igniteBackend) run(ctx context.Context, args ...string) ([]byte, error) {
+	out, err := b.command(ctx, args...).CombinedOutput()
+	if err != nil {
+		return nil, xerrors.Errorf("ignite %v: %s: %w", args[0], bytes.TrimSpace(out), err)
+	}
+	return out, nil
+}
+
+func (b igniteBackend) create(ctx context.Context, cntName string, containerConfig *container.Config, hostConfig *container.HostConfig) error {
+	image := containerConfig.Image
+	xlog.Info("importing %v into ignite", image)
+	_, err := b.run(ctx, "image", "import", "--runtime", "docker", image)
+	if err != nil {
+		return err
+	}
+	uid, gid, err := imageUser(ctx, image, containerConfig.User)
+	if err != nil {
+		return err
+	}
+	imageEnv, err := imageEnv(ctx, image)
+	if err != nil {
+		return err
+	}
+
+	copies, skipped := igniteCopies(hostConfig.Mounts)
+	for _, s := range skipped {
+		xlog.Warn("%v can't be copied into the VM, it's skipped", s)
+	}
+	if b.projectOnly {
+		labels := containerConfig.Labels
+		var dropped []string
+		copies, dropped = igniteProjectCopies(copies, resolvePath(guestHome(labels), labels[projectDirLabel]), filepath.Join(metaRoot(), cntName))
+		if len(dropped) > 0 {
+			xlog.Info("the project isn't trusted, so %v aren't copied into the VM, trust it with sail run --rebuild --trust", strings.Join(dropped, ", "))
+		}
+	}
+	for _, d := range hostConfig.Devices {
+		xlog.Warn("the device %v isn't supported by ignite VMs, it's skipped", d.PathOnHost)
+	}
+
+	env := append(append(append([]string(nil), imageEnv...), "HOME="+guestHome(containerConfig.Labels)), containerConfig.Env...)
+	envFile, err := writeTempFile("sail-env", igniteEnvFile(env), 0600)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(envFile)
+	initFile, err := writeTempFile("sail-init", igniteInitScript(uid, gid, copies, containerConfig.Cmd), 0700)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(initFile)
+
+	memory := igniteMemory
+	if hostConfig.Memory > 0 {
+		memory = fmt.Sprintf("%vMB", hostConfig.Memory>>20)
+	}
+	args := []string{
+		"create", image,
+		"--name", backendName(cntName),
+		"--cpus", igniteCPUs,
+		"--memory", memory,
+		"--size", igniteSize,
+		"--kernel-args", igniteKernelArgs,
+		"--copy-files", initFile + ":" + lxdInitPath,
+		"--copy-files", envFile + ":" + igniteEnvPath,
+	}
+	for _, c := range copies {
+		args = append(args, "--copy-files", c.Source+":"+c.Target)
+	}
+	for _, p := range ignitePorts(hostConfig.PortBindings) {
+		args = append(args, "--ports", p)
+	}
+	_, err = b.run(ctx, args...)
+	if err != nil {
+		return err
+	}
+	return saveExternalEnv(cntName, newExternalEnv(backendIgnite, containerConfig, uid, gid))
+}
+
+// writeTempFile writes s to a new temporary file with the mode perm,
+// returning its path.
+func writeTempFile(prefix, s string, perm os.FileMode) (string, error) {
+	f, err := ioutil.TempFile("", prefix)
+	if err != nil {
+		return "", err
+	}
+	_, err = f.WriteString(s)
+	if err == nil {
+		err = f.Chmod(perm)
+	}
+	f.Close()
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+func (b igniteBackend) start(ctx context.Context, cntName string) error {
+	_, err := b.run(ctx, "start", backendName(cntName))
+	return err
+}
+
+func (b igniteBackend) remove(ctx context.Context, cntName string) error {
+	exists, _, err := b.status(ctx, cntName)
+	if err != nil {
+		return err
+	}
+	if exists {
+		_, err = b.run(ctx, "rm", "--force", backendName(cntName))
+		if err != nil {
+			return err
+		}
+	}
+	return removeExternalEnv(cntName)
+}
+
+func (b igniteBackend) stop(ctx context.Context, cntName string) error {
+	_, err := b.run(ctx, "stop", backendName(cntName))
+	return err
+}
+
+// shell runs the shell over Ignite's SSH connection, which joins the
+// arguments of the command, so it's quoted as one.
+func (b igniteBackend) shell(cntName string, env externalEnv, dir string) (*exec.Cmd, error) {
+	script := ". " + igniteEnvPath + " && cd \"$1\" && " + externalShellScript
+	cmd := "setpriv --reuid=" + env.UID + " --regid=" + env.GID + " --init-groups sh -c " + xexec.Quote(script) + " sail-shell " + xexec.Quote(dir)
+	return b.command(context.Background(), "exec", "--tty", backendName(cntName), cmd), nil
+}
+
+func (b igniteBackend) status(ctx context.Context, cntName string) (bool, bool, error) {
+	name := backendName(cntName)
+	out, err := b.run(ctx, "ps", "--all", "--template", "{{.Name}} {{.Status.Running}}")
+	if err != nil {
+		return false, false, err
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == name {
+			return true, fields[1] == "true", nil
+		}
+	}
+	return false, false, nil
+}
+
+// imageEnv returns the environment of the config of image, which is lost
+// when it's imported by another backend.
+func imageEnv(ctx context.Context, image string) ([]string, error) {
+	cli := dockerClient()
+	defer cli.Close()
+
+	img, _, err := cli.ImageInspectWithRaw(ctx, image)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to inspect %v: %w", image, err)
+	}
+	if img.Config == nil {
+		return nil, nil
+	}
+	return img.Config.Env, nil
+}
+
+// igniteCopies returns the bind mounts of mounts whose sources can be copied
+// into a VM, and descriptions of the others, like volumes and sockets.
+func igniteCopies(mounts []mount.Mount) (copies []mount.Mount, skipped []string) {
+	for _, m := range mounts {
+		if m.Type != mount.TypeBind {
+			skipped = append(skipped, fmt.Sprintf("the volume %v mounted at %v", m.Source, m.Target))
+			continue
+		}
+		fi, err := os.Stat(m.Source)
+		if err != nil || !(fi.IsDir() || fi.Mode().IsRegular()) {
+			skipped = append(skipped, m.Source)
+			continue
+		}
+		copies = append(copies, m)
+	}
+	return copies, skipped
+}
+
+// ignitePorts returns the --ports of the published ports of a container.
+func ignitePorts(bindings nat.PortMap) []string {
+	var ports []string
+	for p, bs := range bindings {
+		for _, b := range bs {
+			ip := b.HostIP
+			if ip == "" {
+				ip = "127.0.0.1"
+			}
+			ports = append(ports, ip+":"+b.HostPort+":"+p.Port()+"/"+p.Proto())
+		}
+	}
+	sort.Strings(ports)
+	return ports
+}
+
+// igniteProjectCopies returns the copies of copies that are the project
+// directory, at projectDir, code-server, or sail's files of the container in
+// stateDir, and the sources of the others.
+func igniteProjectCopies(copies []mount.Mount, projectDir, stateDir string) (kept []mount.Mount, dropped []string) {
+	for _, m := range copies {
+		if m.Target == projectDir || m.Target == "/usr/bin/code-server" || m.Source == stateDir || strings.HasPrefix(m.Source, stateDir+string(filepath.Separator)) {
+			kept = append(kept, m)
+			continue
+		}
+		dropped = append(dropped, m.Source)
+	}
+	return kept, dropped
+}
+
+// igniteEnvFile returns the file at igniteEnvPath, which exports env.
+func igniteEnvFile(env []string) string {
+	var b strings.Builder
+	for _, kv := range env {
+		b.WriteString("export " + xexec.Quote(kv) + "\n")
+	}
+	return b.String()
+}
+
+// igniteInitScript returns the init process of VMs. It mounts the kernel's
+// filesystems, hands the writable copies of mounts and the environment file
+// to the container's user, with the ids uid and gid, and runs cmd as it with
+// that environment.
+func igniteInitScript(uid, gid string, copies []mount.Mount, cmd []string) string {
+	var b strings.Builder
+	b.WriteString(`#!/bin/sh
+mount -t proc proc /proc
+mount -t sysfs sysfs /sys
+mkdir -p /dev/pts /dev/shm
+mount -t devpts devpts /dev/pts
+mount -t tmpfs tmpfs /dev/shm
+`)
+	for _, m := range copies {
+		if !m.ReadOnly {
+			b.WriteString("chown -R " + uid + ":" + gid + " " + xexec.Quote(m.Target) + "\n")
+		}
+	}
+	b.WriteString("chown " + uid + ":" + gid + " " + igniteEnvPath + "\nchmod 0600 " + igniteEnvPath + "\n. " + igniteEnvPath + "\n")
+	quoted := make([]string, len(cmd))
+	for i, arg := range cmd {
+		quoted[i] = xexec.Quote(arg)
+	}
+	b.WriteString("exec setpriv --reuid=" + uid + " --regid=" + gid + " --init-groups " + strings.Join(quoted, " ") + "\n")
+	return b.String()
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/go-connections/nat"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_backendOf(t *testing.T) {
+	c := config{Backend: backendLXD, Backends: map[string]string{"someone/untrusted": backendIgnite}}
+	assert.Equal(t, backendIgnite, c.backendOf("someone/untrusted"))
+	assert.Equal(t, backendLXD, c.backendOf("cdr/sail"))
+	assert.IsType(t, igniteBackend{}, newBackend(c.backendOf("someone/untrusted")))
+	assert.IsType(t, dockerBackend{}, newBackend(config{}.backendOf("cdr/sail")))
+}
+
+func Test_igniteCopies(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sail-ignite")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	bin := filepath.Join(dir, "code-server")
+	require.NoError(t, ioutil.WriteFile(bin, nil, 0755))
+
+	copies, skipped := igniteCopies([]mount.Mount{
+		{Type: mount.TypeBind, Source: dir, Target: "/home/user/sail"},
+		{Type: mount.TypeBind, Source: bin, Target: "/usr/bin/code-server", ReadOnly: true},
+		{Type: mount.TypeBind, Source: filepath.Join(dir, "ssh-agent.sock"), Target: "/run/ssh-agent.sock"},
+		{Type: mount.TypeVolume, Source: "sail-shared-go", Target: "/home/user/go"},
+	})
+	assert.Equal(t, []mount.Mount{
+		{Type: mount.TypeBind, Source: dir, Target: "/home/user/sail"},
+		{Type: mount.TypeBind, Source: bin, Target: "/usr/bin/code-server", ReadOnly: true},
+	}, copies)
+	assert.Equal(t, []string{filepath.Join(dir, "ssh-agent.sock"), "the volume sail-shared-go mounted at /home/user/go"}, skipped)
+
+	script := igniteInitScript("1000", "1000", copies, []string{"bash", "-c", "code-server"})
+	assert.Contains(t, script, "chown -R 1000:1000 '/home/user/sail'\n")
+	assert.NotContains(t, script, "/usr/bin/code-server")
+	assert.NotContains(t, script, "export")
+	assert.Contains(t, script, ". "+igniteEnvPath+"\nexec setpriv --reuid=1000 --regid=1000 --init-groups 'bash' '-c' 'code-server'\n")
+	assert.Equal(t, "export 'HOME=/home/user'\nexport 'A=it'\\''s'\n", igniteEnvFile([]string{"HOME=/home/user", "A=it's"}))
+}
+
+func Test_igniteProjectCopies(t *testing.T) {
+	kept, dropped := igniteProjectCopies([]mount.Mount{
+		{Type: mount.TypeBind, Source: "/home/user/Projects/cdr/sail", Target: "/home/user/sail"},
+		{Type: mount.TypeBind, Source: "/home/user/.config/Code", Target: "/home/user/.config/Code"},
+		{Type: mount.TypeBind, Source: "/home/user/.config/sail/cdr_sail/globalStorage", Target: "/home/user/.local/share/code-server/globalStorage/"},
+		{Type: mount.TypeBind, Source: "/home/user/.cache/sail/code-server", Target: "/usr/bin/code-server"},
+		{Type: mount.TypeBind, Source: "/home/user/.aws", Target: "/home/user/.aws"},
+	}, "/home/user/sail", "/home/user/.config/sail/cdr_sail")
+	assert.Equal(t, []mount.Mount{
+		{Type: mount.TypeBind, Source: "/home/user/Projects/cdr/sail", Target: "/home/user/sail"},
+		{Type: mount.TypeBind, Source: "/home/user/.config/sail/cdr_sail/globalStorage", Target: "/home/user/.local/share/code-server/globalStorage/"},
+		{Type: mount.TypeBind, Source: "/home/user/.cache/sail/code-server", Target: "/usr/bin/code-server"},
+	}, kept)
+	assert.Equal(t, []string{"/home/user/.config/Code", "/home/user/.aws"}, dropped)
+}
+
+func Test_ignitePorts(t *testing.T) {
+	_, bindings, err := nat.ParsePortSpecs([]string{"127.0.0.1:8000:8443/tcp", "127.0.0.1:3000:3000/tcp"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"127.0.0.1:3000:3000/tcp", "127.0.0.1:8000:8443/tcp"}, ignitePorts(bindings))
+}
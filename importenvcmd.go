@@ -0,0 +1,237 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"flag"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/xerrors"
+
+	"go.coder.com/cli"
+	"go.coder.com/sail/internal/dockutil"
+	"go.coder.com/sail/internal/xlog"
+)
+
+type importenvcmd struct {
+	gf *globalFlags
+
+	noOpen bool
+}
+
+func (c *importenvcmd) Spec() cli.CommandSpec {
+	return cli.CommandSpec{
+		Name:  "import-env",
+		Usage: "[flags] <archive>",
+		Desc: `Restores an environment from an archive written by sail export-env.
+
+The image is loaded into the Docker daemon, the project is cloned from the git
+bundle, and the container is created with the editor's state, environment
+variables and mounts of the exported environment. The project mustn't exist.`,
+	}
+}
+
+func (c *importenvcmd) RegisterFlags(fl *flag.FlagSet) {
+	fl.BoolVar(&c.noOpen, "no-open", false, "Don't open the project.")
+}
+
+func (c *importenvcmd) Run(fl *flag.FlagSet) {
+	if fl.NArg() != 1 {
+		fl.Usage()
+		os.Exit(1)
+	}
+
+	fi, err := os.Open(fl.Arg(0))
+	if err != nil {
+		xlog.Fatal("%v", err)
+	}
+	defer fi.Close()
+	gr, err := gzip.NewReader(fi)
+	if err != nil {
+		xlog.Fatal("failed to read %v: %v", fl.Arg(0), err)
+	}
+	tr := tar.NewReader(gr)
+	m, err := readEnvManifest(tr)
+	if err != nil {
+		xlog.Fatal("%v", err)
+	}
+
+	proj := c.project(fl, m)
+	c.gf.ensureDockerDaemon()
+
+	_, err = proj.lock()
+	if err != nil {
+		xlog.Fatal("failed to lock project: %v", err)
+	}
+	exists, err := proj.cntExists()
+	if err != nil {
+		xlog.Fatal("%v", err)
+	}
+	if exists {
+		xlog.Fatal("%v already exists, remove it with sail rm to import it", proj.pathName())
+	}
+	_, err = os.Stat(proj.localDir())
+	if err == nil {
+		xlog.Fatal("%v already exists", proj.localDir())
+	}
+
+	ctx, cancel := withInterrupt(context.Background())
+	defer cancel()
+
+	err = c.extract(ctx, tr, proj, m)
+	if err != nil {
+		xlog.Fatal("failed to import %v: %v", proj.pathName(), err)
+	}
+
+	r, err := new(runcmd).runner(proj, false)
+	if err != nil {
+		xlog.Fatal("%v", err)
+	}
+	// Settings from sail run flags are kept, the rest comes from the config.
+	r.shareDocker = m.Labels[dockerSocketLabel] == "true"
+	r.devices = m.Devices
+	r.upstream = m.Labels[upstreamLabel]
+
+	emitEvent(eventBuilding, proj.cntName(), nil)
+	err = new(runcmd).build(ctx, c.gf, proj, &hatBuilder{baseImage: m.Image}, r)
+	if err != nil {
+		emitEvent(eventFailed, proj.cntName(), map[string]string{"error": err.Error()})
+		rmErr := dockutil.StopRemove(context.Background(), dockerClient(), proj.cntName())
+		if rmErr != nil {
+			xlog.Error("failed to remove %v: %v", proj.cntName(), rmErr)
+		}
+		xlog.Fatal("failed to create %v: %v", proj.pathName(), err)
+	}
+	xlog.Success("imported %v exported at %v", proj.pathName(), m.Created.Format("2006-01-02 15:04"))
+
+	if c.noOpen {
+		return
+	}
+	err = proj.open()
+	if err != nil {
+		xlog.Fatal("failed to open project: %v", err)
+	}
+}
+
+// project returns the project of the environment of m.
+func (c *importenvcmd) project(fl *flag.FlagSet, m *envManifest) *project {
+	arg := m.Name
+	if m.Remote != "" {
+		arg = m.Remote
+	}
+	projFl := flag.NewFlagSet(fl.Name(), flag.ExitOnError)
+	projFl.Parse([]string{arg})
+	proj := c.gf.project(schemaPrefs{}, projFl)
+
+	err := validateProjectName(m.Name)
+	if err != nil {
+		xlog.Fatal("invalid archive: %v", err)
+	}
+	proj.name = m.Name
+	return proj
+}
+
+// extract loads the image of the archive tr, clones the project from its git
+// bundle, and restores the state sail keeps on the host for the container.
+func (c *importenvcmd) extract(ctx context.Context, tr *tar.Reader, proj *project, m *envManifest) error {
+	// Any state left from a removed project of the same name is stale.
+	for _, name := range syncFiles(true) {
+		err := os.RemoveAll(filepath.Join(metaRoot(), proj.cntName(), name))
+		if err != nil {
+			return err
+		}
+	}
+
+	var loaded, cloned bool
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return xerrors.Errorf("failed to read archive: %w", err)
+		}
+
+		switch hdr.Name {
+		case envImageEntry:
+			xlog.Info("loading %v", m.Image)
+			var stderr tailWriter
+			stderr.max = 4096
+			cmd := exec.CommandContext(ctx, "docker", "load", "--quiet")
+			cmd.Stdin = tr
+			cmd.Stderr = &stderr
+			err = cmd.Run()
+			if err != nil {
+				return xerrors.Errorf("failed to load image: %v: %w", strings.TrimSpace(stderr.String()), err)
+			}
+			loaded = true
+			continue
+		case envBundleEntry:
+			err = cloneBundle(ctx, tr, proj.localDir(), m.Origin)
+			if err != nil {
+				return err
+			}
+			cloned = true
+			continue
+		}
+
+		dst, err := importMetaPath(metaRoot(), hdr.Name, m.Container, proj.cntName())
+		if err != nil {
+			return err
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(dst, 0750)
+		case tar.TypeReg:
+			err = extractFile(tr, dst, os.FileMode(hdr.Mode).Perm())
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if !loaded {
+		return xerrors.Errorf("archive has no %v", envImageEntry)
+	}
+	if !cloned {
+		// The project wasn't a git repository, so it's created like sail run does.
+		return proj.ensureDir()
+	}
+	return nil
+}
+
+// cloneBundle clones the git bundle r to dir, and sets the clone's origin to
+// origin, or removes it if origin is empty.
+func cloneBundle(ctx context.Context, r io.Reader, dir, origin string) error {
+	fi, err := ioutil.TempFile("", "sail-bundle")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(fi.Name())
+	_, err = io.Copy(fi, r)
+	fi.Close()
+	if err != nil {
+		return err
+	}
+
+	xlog.Info("cloning %v", dir)
+	out, err := exec.CommandContext(ctx, "git", "clone", "--quiet", fi.Name(), dir).CombinedOutput()
+	if err != nil {
+		return xerrors.Errorf("failed to clone bundle: %s: %w", out, err)
+	}
+	args := []string{"remote", "set-url", "origin", origin}
+	if origin == "" {
+		args = []string{"remote", "remove", "origin"}
+	}
+	out, err = exec.CommandContext(ctx, "git", append([]string{"-C", dir}, args...)...).CombinedOutput()
+	if err != nil {
+		return xerrors.Errorf("failed to set origin of %v: %s: %w", dir, out, err)
+	}
+	return nil
+}
@@ -5,6 +5,7 @@ package browserapp
 import (
 	"os"
 	"os/exec"
+	"strings"
 
 	"github.com/pkg/browser"
 
@@ -15,31 +16,87 @@ import (
 //
 // TODO: move this into a location where sshcode and sail can use this.
 func Open(url string) error {
-	switch {
-	case commandExists("google-chrome"):
-		return nohup.Start("google-chrome", chromeOptions(url)...)
+	chrome := findChrome()
+	if chrome == "" {
+		return browser.OpenURL(url)
+	}
+	return nohup.Start(chrome, chromeOptions(url)...)
+}
 
-	case commandExists("google-chrome-stable"):
-		return nohup.Start("google-chrome-stable", chromeOptions(url)...)
+// OpenApp opens a URL as a Chrome app window using its own profile in
+// profileDir, so that the window keeps its own state and windows of
+// different profiles don't get mixed up. name sets the window class on
+// Linux so window managers and docks can tell the windows apart.
+// If Chrome isn't installed, it falls back to Open.
+func OpenApp(url, profileDir, name string) error {
+	chrome := findChrome()
+	if chrome == "" {
+		return Open(url)
+	}
+	return nohup.Start(chrome, appOptions(url, profileDir, name)...)
+}
 
-	case commandExists("chromium"):
-		return nohup.Start("chromium", chromeOptions(url)...)
+// findChrome returns the Chrome or Chromium binary, or the empty string if
+// neither is installed.
+func findChrome() string {
+	for _, name := range []string{"google-chrome", "google-chrome-stable", "chromium", "chromium-browser"} {
+		if commandExists(name) {
+			return name
+		}
+	}
+	const macChrome = "/Applications/Google Chrome.app/Contents/MacOS/Google Chrome"
+	if pathExists(macChrome) {
+		return macChrome
+	}
+	return ""
+}
 
-	case commandExists("chromium-browser"):
-		return nohup.Start("chromium-browser", chromeOptions(url)...)
+// OpenWith opens a URL with the browser command. {url} in the command is
+// replaced with the URL, or the URL is appended if it's absent.
+// If the command is empty, Open is used, and if it's "default" the system's
+// default browser is used.
+func OpenWith(browserCmd, url string) error {
+	switch browserCmd {
+	case "":
+		return Open(url)
+	case "default":
+		return browser.OpenURL(url)
+	}
 
-	case pathExists("/Applications/Google Chrome.app/Contents/MacOS/Google Chrome"):
-		return nohup.Start("/Applications/Google Chrome.app/Contents/MacOS/Google Chrome", chromeOptions(url)...)
+	args := BrowserArgs(browserCmd, url)
+	return nohup.Start(args[0], args[1:]...)
+}
 
-	default:
-		return browser.OpenURL(url)
+// BrowserArgs returns the arguments to open url with browserCmd.
+func BrowserArgs(browserCmd, url string) []string {
+	args := strings.Fields(browserCmd)
+	replaced := false
+	for i, a := range args {
+		if strings.Contains(a, "{url}") {
+			args[i] = strings.Replace(a, "{url}", url, -1)
+			replaced = true
+		}
 	}
+	if !replaced {
+		args = append(args, url)
+	}
+	return args
 }
 
 func chromeOptions(url string) []string {
 	return []string{"--app=" + url, "--disable-extensions", "--disable-plugins", "--incognito"}
 }
 
+func appOptions(url, profileDir, name string) []string {
+	return []string{
+		"--app=" + url,
+		"--user-data-dir=" + profileDir,
+		"--class=" + name,
+		"--no-first-run",
+		"--no-default-browser-check",
+	}
+}
+
 // Checks if a command exists locally.
 func commandExists(name string) bool {
 	_, err := exec.LookPath(name)
@@ -0,0 +1,27 @@
+package browserapp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBrowserArgs(t *testing.T) {
+	t.Parallel()
+
+	const url = "http://localhost:8080"
+	assert.Equal(t, []string{"firefox", "--new-window", url}, BrowserArgs("firefox --new-window", url))
+	assert.Equal(t, []string{"chromium", "--app=" + url, "--profile-directory=Work"}, BrowserArgs("chromium --app={url} --profile-directory=Work", url))
+}
+
+func TestAppOptions(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, []string{
+		"--app=http://localhost:8080",
+		"--user-data-dir=/home/user/.config/sail/cdr_sail/browser-profile",
+		"--class=cdr/sail",
+		"--no-first-run",
+		"--no-default-browser-check",
+	}, appOptions("http://localhost:8080", "/home/user/.config/sail/cdr_sail/browser-profile", "cdr/sail"))
+}
@@ -12,21 +12,63 @@ import (
 	"golang.org/x/xerrors"
 )
 
-// DownloadURL gets a URL for the latest version of code-server.
-func DownloadURL(ctx context.Context) (string, error) {
+// Release is a code-server release for the container's platform.
+type Release struct {
+	Version string
+	URL     string
+	// Size is the size of the release tarball in bytes.
+	Size int64
+}
+
+// assetArchs are the names release assets use for architectures, by GOARCH.
+var assetArchs = map[string][]string{
+	"amd64": {"x86_64", "amd64"},
+	"arm64": {"arm64", "aarch64"},
+	"arm":   {"armv7l", "armhf"},
+}
+
+// IsAsset reports whether name is the release tarball for linux on arch,
+// which is a GOARCH such as amd64 or arm64.
+func IsAsset(name, arch string) bool {
+	if !strings.HasSuffix(name, ".tar.gz") {
+		return false
+	}
+	for _, a := range assetArchs[arch] {
+		if strings.Contains(name, "linux-"+a) {
+			return true
+		}
+	}
+	return false
+}
+
+// LatestRelease gets the latest release of code-server for linux on arch.
+func LatestRelease(ctx context.Context, arch string) (Release, error) {
 	client := github.NewClient(nil)
 	rel, _, err := client.Repositories.GetLatestRelease(ctx, "cdr", "code-server")
 	if err != nil {
-		return "", xerrors.Errorf("failed to get latest code-server release: %w", err)
+		return Release{}, xerrors.Errorf("failed to get latest code-server release: %w", err)
 	}
 	for _, v := range rel.Assets {
-		// TODO: fix this jank, detect container architecture instead of hardcoding to x86_64
-		if strings.Index(*v.Name, "linux-x86_64") < 0 {
+		if !IsAsset(v.GetName(), arch) {
 			continue
 		}
-		return *v.BrowserDownloadURL, nil
+		return Release{
+			Version: rel.GetTagName(),
+			URL:     v.GetBrowserDownloadURL(),
+			Size:    int64(v.GetSize()),
+		}, nil
+	}
+	return Release{}, xerrors.Errorf("no code-server %v release for linux/%v", rel.GetTagName(), arch)
+}
+
+// DownloadURL gets a URL for the latest version of code-server for linux
+// on arch.
+func DownloadURL(ctx context.Context, arch string) (string, error) {
+	rel, err := LatestRelease(ctx, arch)
+	if err != nil {
+		return "", err
 	}
-	return "", xerrors.New("no released found for platform")
+	return rel.URL, nil
 }
 
 // Extract takes a code-server release tar and writes out the main binary to bin.
@@ -19,7 +19,7 @@ func TestDownloadURL_Extract(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*2)
 	defer cancel()
 
-	url, err := DownloadURL(ctx)
+	url, err := DownloadURL(ctx, "amd64")
 	require.NoError(t, err)
 
 	resp, err := http.Get(url)
@@ -49,3 +49,14 @@ func TestDownloadURL_Extract(t *testing.T) {
 	_, err = exec.Command(tmpfi.Name(), "--help").CombinedOutput()
 	require.NoError(t, err)
 }
+
+func TestIsAsset(t *testing.T) {
+	t.Parallel()
+
+	require.True(t, IsAsset("code-server2.1692-vsc1.39.2-linux-x86_64.tar.gz", "amd64"))
+	require.True(t, IsAsset("code-server-3.4.1-linux-amd64.tar.gz", "amd64"))
+	require.True(t, IsAsset("code-server-3.4.1-linux-arm64.tar.gz", "arm64"))
+	require.False(t, IsAsset("code-server-3.4.1-linux-arm64.tar.gz", "amd64"))
+	require.False(t, IsAsset("code-server_3.4.1_amd64.deb", "amd64"))
+	require.False(t, IsAsset("code-server-3.4.1-linux-amd64.tar.gz", "s390x"))
+}
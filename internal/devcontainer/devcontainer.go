@@ -0,0 +1,300 @@
+// Package devcontainer parses the subset of VS Code's devcontainer.json
+// that sail understands.
+// See https://code.visualstudio.com/docs/remote/containers#_devcontainerjson-reference
+package devcontainer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/xerrors"
+
+	"go.coder.com/sail/internal/xexec"
+)
+
+// Config is a devcontainer.json.
+type Config struct {
+	Name  string `json:"name,omitempty"`
+	Image string `json:"image,omitempty"`
+	// DockerFile is the legacy location of Build.Dockerfile.
+	DockerFile string `json:"dockerFile,omitempty"`
+	Build      *Build `json:"build,omitempty"`
+
+	WorkspaceFolder string `json:"workspaceFolder,omitempty"`
+	WorkspaceMount  string `json:"workspaceMount,omitempty"`
+
+	Mounts            []string          `json:"mounts,omitempty"`
+	ContainerEnv      map[string]string `json:"containerEnv,omitempty"`
+	ForwardPorts      []int             `json:"forwardPorts,omitempty"`
+	Extensions        []string          `json:"extensions,omitempty"`
+	PostCreateCommand json.RawMessage   `json:"postCreateCommand,omitempty"`
+
+	// dir is the directory containing the devcontainer.json.
+	dir string
+}
+
+// Build describes how to build the image.
+type Build struct {
+	Dockerfile string            `json:"dockerfile,omitempty"`
+	Context    string            `json:"context,omitempty"`
+	Args       map[string]string `json:"args,omitempty"`
+}
+
+// Find returns the path of the devcontainer.json in projectDir,
+// or the empty string if there isn't one.
+func Find(projectDir string) string {
+	for _, p := range []string{
+		filepath.Join(projectDir, ".devcontainer", "devcontainer.json"),
+		filepath.Join(projectDir, ".devcontainer.json"),
+	} {
+		_, err := os.Stat(p)
+		if err == nil {
+			return p
+		}
+	}
+	return ""
+}
+
+// Load reads the devcontainer.json at path.
+func Load(path string) (*Config, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to read %v: %w", path, err)
+	}
+
+	c, err := Parse(b)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to parse %v: %w", path, err)
+	}
+	c.dir = filepath.Dir(path)
+	return c, nil
+}
+
+// Parse parses a devcontainer.json. Like VS Code, comments and trailing
+// commas are allowed.
+func Parse(b []byte) (*Config, error) {
+	var c Config
+	err := json.Unmarshal(stripTrailingCommas(stripComments(b)), &c)
+	if err != nil {
+		return nil, err
+	}
+	if c.Image == "" && c.dockerfile() == "" {
+		return nil, xerrors.New("one of image, dockerFile or build.dockerfile must be set")
+	}
+	return &c, nil
+}
+
+func (c *Config) dockerfile() string {
+	if c.Build != nil && c.Build.Dockerfile != "" {
+		return c.Build.Dockerfile
+	}
+	return c.DockerFile
+}
+
+// DockerfilePath returns the absolute path of the Dockerfile to build, or the
+// empty string if the config uses a prebuilt image.
+func (c *Config) DockerfilePath() string {
+	df := c.dockerfile()
+	if df == "" {
+		return ""
+	}
+	return filepath.Join(c.dir, df)
+}
+
+// ContextPath returns the absolute path of the build context.
+// Paths are relative to the devcontainer.json, and the context defaults
+// to the Dockerfile's directory.
+func (c *Config) ContextPath() string {
+	if c.Build != nil && c.Build.Context != "" {
+		return filepath.Join(c.dir, c.Build.Context)
+	}
+	return filepath.Dir(c.DockerfilePath())
+}
+
+// BuildArgs returns the build args for the Dockerfile.
+func (c *Config) BuildArgs() map[string]string {
+	if c.Build == nil {
+		return nil
+	}
+	return c.Build.Args
+}
+
+// Vars are the values of the variables that strings of a devcontainer.json
+// may refer to, besides ${localEnv:VAR}.
+type Vars struct {
+	// LocalWorkspaceFolder is the project directory on the host.
+	LocalWorkspaceFolder string
+	// ContainerWorkspaceFolder is the project directory in the container.
+	ContainerWorkspaceFolder string
+}
+
+// Expand replaces the variables in s.
+func (v Vars) Expand(s string) string {
+	s = strings.Replace(s, "${localWorkspaceFolder}", v.LocalWorkspaceFolder, -1)
+	s = strings.Replace(s, "${containerWorkspaceFolder}", v.ContainerWorkspaceFolder, -1)
+	for {
+		i := strings.Index(s, "${localEnv:")
+		if i < 0 {
+			return s
+		}
+		j := strings.Index(s[i:], "}")
+		if j < 0 {
+			return s
+		}
+		name := s[i+len("${localEnv:") : i+j]
+		s = s[:i] + os.Getenv(name) + s[i+j+1:]
+	}
+}
+
+// PostCreate returns postCreateCommand as a shell command, with its variables
+// expanded. Like VS Code, the array form runs its first element with the
+// others as arguments, without a shell, so they're quoted.
+func (c *Config) PostCreate(vars Vars) (string, error) {
+	if len(c.PostCreateCommand) == 0 {
+		return "", nil
+	}
+
+	var s string
+	err := json.Unmarshal(c.PostCreateCommand, &s)
+	if err == nil {
+		return vars.Expand(s), nil
+	}
+
+	var args []string
+	err = json.Unmarshal(c.PostCreateCommand, &args)
+	if err != nil || len(args) == 0 {
+		return "", xerrors.Errorf("postCreateCommand must be a string or a non-empty array of strings")
+	}
+	for i, arg := range args {
+		args[i] = xexec.Quote(vars.Expand(arg))
+	}
+	return strings.Join(args, " "), nil
+}
+
+// Mount is a parsed entry of mounts.
+type Mount struct {
+	Type   string
+	Source string
+	Target string
+}
+
+// ParseMount parses a mount of form "source=...,target=...,type=bind",
+// expanding vars.
+func ParseMount(s string, vars Vars) (Mount, error) {
+	m := Mount{
+		Type: "bind",
+	}
+	for _, kv := range strings.Split(s, ",") {
+		sp := strings.SplitN(kv, "=", 2)
+		if len(sp) != 2 {
+			continue
+		}
+		v := vars.Expand(strings.TrimSpace(sp[1]))
+		switch strings.TrimSpace(sp[0]) {
+		case "type":
+			m.Type = v
+		case "source", "src":
+			m.Source = v
+		case "target", "dst", "destination":
+			m.Target = v
+		}
+	}
+	if m.Source == "" || m.Target == "" {
+		return Mount{}, xerrors.Errorf("invalid mount %q: source and target must be set", s)
+	}
+	return m, nil
+}
+
+// String formats m as a mount string, the inverse of ParseMount.
+func (m Mount) String() string {
+	return fmt.Sprintf("source=%v,target=%v,type=%v", m.Source, m.Target, m.Type)
+}
+
+// stripComments removes // and /* */ comments outside of strings.
+func stripComments(b []byte) []byte {
+	var (
+		out      bytes.Buffer
+		inString bool
+	)
+	for i := 0; i < len(b); i++ {
+		c := b[i]
+		if inString {
+			out.WriteByte(c)
+			switch c {
+			case '\\':
+				if i+1 < len(b) {
+					i++
+					out.WriteByte(b[i])
+				}
+			case '"':
+				inString = false
+			}
+			continue
+		}
+
+		switch {
+		case c == '"':
+			inString = true
+			out.WriteByte(c)
+		case c == '/' && i+1 < len(b) && b[i+1] == '/':
+			for i < len(b) && b[i] != '\n' {
+				i++
+			}
+			out.WriteByte('\n')
+		case c == '/' && i+1 < len(b) && b[i+1] == '*':
+			i += 2
+			for i+1 < len(b) && !(b[i] == '*' && b[i+1] == '/') {
+				i++
+			}
+			i++
+		default:
+			out.WriteByte(c)
+		}
+	}
+	return out.Bytes()
+}
+
+// stripTrailingCommas removes commas before the end of objects and arrays,
+// outside of strings. Comments must be stripped first.
+func stripTrailingCommas(b []byte) []byte {
+	var (
+		out      bytes.Buffer
+		inString bool
+	)
+	for i := 0; i < len(b); i++ {
+		c := b[i]
+		if inString {
+			out.WriteByte(c)
+			switch c {
+			case '\\':
+				if i+1 < len(b) {
+					i++
+					out.WriteByte(b[i])
+				}
+			case '"':
+				inString = false
+			}
+			continue
+		}
+
+		switch c {
+		case '"':
+			inString = true
+		case ',':
+			j := i + 1
+			for j < len(b) && (b[j] == ' ' || b[j] == '\t' || b[j] == '\n' || b[j] == '\r') {
+				j++
+			}
+			if j < len(b) && (b[j] == '}' || b[j] == ']') {
+				continue
+			}
+		}
+		out.WriteByte(c)
+	}
+	return out.Bytes()
+}
@@ -0,0 +1,77 @@
+package devcontainer
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+
+	c, err := Parse([]byte(`{
+	// The image to use.
+	"name": "Go // not a comment",
+	"build": {
+		"dockerfile": "Dockerfile",
+		"context": "..",
+		"args": { "VARIANT": "1.13" }
+	},
+	/* Ports to forward. */
+	"forwardPorts": [3000, 8080,],
+	"extensions": ["ms-vscode.go"],
+	"postCreateCommand": ["go", "run", "${containerWorkspaceFolder}/gen.go", "a,]", "it's"],
+}`))
+	require.NoError(t, err)
+
+	c.dir = "/src/.devcontainer"
+	assert.Equal(t, "Go // not a comment", c.Name)
+	assert.Equal(t, "/src/.devcontainer/Dockerfile", c.DockerfilePath())
+	assert.Equal(t, "/src", c.ContextPath())
+	assert.Equal(t, map[string]string{"VARIANT": "1.13"}, c.BuildArgs())
+	assert.Equal(t, []int{3000, 8080}, c.ForwardPorts)
+	assert.Equal(t, []string{"ms-vscode.go"}, c.Extensions)
+
+	cmd, err := c.PostCreate(Vars{ContainerWorkspaceFolder: "/home/user/sail"})
+	require.NoError(t, err)
+	assert.Equal(t, `'go' 'run' '/home/user/sail/gen.go' 'a,]' 'it'\''s'`, cmd)
+
+	c, err = Parse([]byte(`{"image": "golang", "postCreateCommand": "cd ${containerWorkspaceFolder} && make",}`))
+	require.NoError(t, err)
+	cmd, err = c.PostCreate(Vars{ContainerWorkspaceFolder: "/home/user/sail"})
+	require.NoError(t, err)
+	assert.Equal(t, "cd /home/user/sail && make", cmd)
+
+	c, err = Parse([]byte(`{"image": "golang", "postCreateCommand": []}`))
+	require.NoError(t, err)
+	_, err = c.PostCreate(Vars{})
+	require.Error(t, err)
+
+	_, err = Parse([]byte(`{"name": "no image"}`))
+	require.Error(t, err)
+}
+
+func TestParseMount(t *testing.T) {
+	t.Parallel()
+
+	vars := Vars{LocalWorkspaceFolder: "/src", ContainerWorkspaceFolder: "/home/user/src"}
+	m, err := ParseMount("source=${localWorkspaceFolder}/cache,target=${containerWorkspaceFolder}/cache,type=bind,consistency=cached", vars)
+	require.NoError(t, err)
+	assert.Equal(t, Mount{Type: "bind", Source: "/src/cache", Target: "/home/user/src/cache"}, m)
+
+	m, err = ParseMount("source=${localEnv:HOME}/.aws,target=/home/user/.aws", vars)
+	require.NoError(t, err)
+	assert.Equal(t, os.Getenv("HOME")+"/.aws", m.Source)
+
+	_, err = ParseMount("type=volume,target=/cache", vars)
+	require.Error(t, err)
+}
+
+func TestStripTrailingCommas(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, `{"a": [1, 2 ], "b": ", }" }`, string(stripTrailingCommas([]byte(`{"a": [1, 2, ], "b": ", }", }`))))
+	assert.Equal(t, `{"a": "\",]"}`, string(stripTrailingCommas([]byte(`{"a": "\",]"}`))))
+}
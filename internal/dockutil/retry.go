@@ -0,0 +1,94 @@
+package dockutil
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"golang.org/x/xerrors"
+)
+
+// Retry parameters for Docker API calls.
+const (
+	retryAttempts   = 5
+	retryBaseDelay  = time.Millisecond * 250
+	retryMaxBackoff = time.Second * 4
+)
+
+// IsTransient reports whether err is a failure to reach the daemon, or a
+// timeout, meaning the call that caused it may succeed if retried. Errors of
+// the daemon, even internal ones, aren't retried, as the call may have had
+// effects.
+func IsTransient(err error) bool {
+	return client.IsErrConnectionFailed(err) || isTimeout(err)
+}
+
+// isTimeout reports whether err, or an error it wraps, is a network timeout.
+func isTimeout(err error) bool {
+	for err != nil {
+		if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+			return true
+		}
+		if c, ok := err.(interface{ Cause() error }); ok && c.Cause() != err {
+			err = c.Cause()
+			continue
+		}
+		err = xerrors.Unwrap(err)
+	}
+	return false
+}
+
+// Retry calls fn until it succeeds, returns an error that isn't transient,
+// or the attempts are exhausted. The delay between attempts grows
+// exponentially.
+func Retry(ctx context.Context, fn func(ctx context.Context) error) error {
+	return retry(ctx, retryAttempts, retryBaseDelay, fn)
+}
+
+func retry(ctx context.Context, attempts int, delay time.Duration, fn func(ctx context.Context) error) error {
+	var err error
+	for i := 0; i < attempts; i++ {
+		err = fn(ctx)
+		if err == nil || !IsTransient(err) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return xerrors.Errorf("%v: %w", err, ctx.Err())
+		case <-time.After(delay):
+		}
+
+		delay *= 2
+		if delay > retryMaxBackoff {
+			delay = retryMaxBackoff
+		}
+	}
+	return xerrors.Errorf("failed after %v attempts: %w", attempts, err)
+}
+
+// ContainerInspect inspects the container named cntName, retrying
+// on transient errors.
+func ContainerInspect(ctx context.Context, cli *client.Client, cntName string) (types.ContainerJSON, error) {
+	var cnt types.ContainerJSON
+	err := Retry(ctx, func(ctx context.Context) error {
+		var err error
+		cnt, err = cli.ContainerInspect(ctx, cntName)
+		return err
+	})
+	return cnt, err
+}
+
+// RemovePartial force removes a container that may have been only partially
+// created. It's a no-op if the container doesn't exist.
+func RemovePartial(ctx context.Context, cli *client.Client, cntName string) error {
+	err := cli.ContainerRemove(ctx, cntName, types.ContainerRemoveOptions{
+		Force: true,
+	})
+	if err != nil && !client.IsErrNotFound(err) {
+		return xerrors.Errorf("failed to remove container %v: %w", cntName, err)
+	}
+	return nil
+}
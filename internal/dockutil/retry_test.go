@@ -0,0 +1,67 @@
+package dockutil
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+)
+
+func TestRetry(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	t.Run("Transient", func(t *testing.T) {
+		var calls int
+		err := retry(ctx, 3, time.Millisecond, func(ctx context.Context) error {
+			calls++
+			if calls < 3 {
+				return client.ErrorConnectionFailed("unix:///var/run/docker.sock")
+			}
+			return nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, 3, calls)
+	})
+
+	t.Run("Exhausted", func(t *testing.T) {
+		var calls int
+		err := retry(ctx, 3, time.Millisecond, func(ctx context.Context) error {
+			calls++
+			return xerrors.Errorf("error during connect: %w", timeoutError{})
+		})
+		require.Error(t, err)
+		require.Equal(t, 3, calls)
+	})
+
+	t.Run("Daemon", func(t *testing.T) {
+		var calls int
+		err := retry(ctx, 3, time.Millisecond, func(ctx context.Context) error {
+			calls++
+			return errdefs.System(xerrors.New("failed to create shim"))
+		})
+		require.True(t, errdefs.IsSystem(err))
+		require.Equal(t, 1, calls)
+	})
+
+	t.Run("Permanent", func(t *testing.T) {
+		var calls int
+		err := retry(ctx, 3, time.Millisecond, func(ctx context.Context) error {
+			calls++
+			return errdefs.NotFound(xerrors.New("no such container"))
+		})
+		require.True(t, errdefs.IsNotFound(err))
+		require.Equal(t, 1, calls)
+	})
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
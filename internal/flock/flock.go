@@ -0,0 +1,137 @@
+// +build linux darwin freebsd
+
+// Package flock provides advisory file locks that can be used to
+// coordinate multiple processes.
+package flock
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"golang.org/x/xerrors"
+)
+
+// ErrLocked is returned by TryLock when the lock is held by another process.
+var ErrLocked = xerrors.New("lock is held by another process")
+
+// Lock is an exclusive lock on a file.
+// The lock is released automatically by the OS if the process exits.
+type Lock struct {
+	fi *os.File
+}
+
+func open(path string) (*os.File, error) {
+	err := os.MkdirAll(filepath.Dir(path), 0750)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to create lock dir: %w", err)
+	}
+
+	fi, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0640)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to open lock file %v: %w", path, err)
+	}
+	return fi, nil
+}
+
+// New acquires the lock at path, blocking until it's available.
+func New(path string) (*Lock, error) {
+	fi, err := open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	err = syscall.Flock(int(fi.Fd()), syscall.LOCK_EX)
+	if err != nil {
+		fi.Close()
+		return nil, xerrors.Errorf("failed to lock %v: %w", path, err)
+	}
+	return &Lock{fi: fi}, nil
+}
+
+// openShared opens the lock file at path so that other users can open it
+// too. Its directory is world-writable and sticky, like /tmp. Existing files
+// are opened without O_CREATE, which the kernel refuses for files of other
+// users in such directories with fs.protected_regular.
+func openShared(path string) (*os.File, error) {
+	dir := filepath.Dir(path)
+	err := os.Mkdir(dir, 0777)
+	if err == nil {
+		// The umask may have taken permissions away.
+		err = os.Chmod(dir, 0777|os.ModeSticky)
+	}
+	if err != nil && !os.IsExist(err) {
+		return nil, xerrors.Errorf("failed to create lock dir: %w", err)
+	}
+
+	for {
+		fi, err := os.OpenFile(path, os.O_RDWR, 0)
+		if err == nil {
+			return fi, nil
+		}
+		if !os.IsNotExist(err) {
+			return nil, xerrors.Errorf("failed to open lock file %v: %w", path, err)
+		}
+
+		fi, err = os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_RDWR, 0666)
+		if os.IsExist(err) {
+			// Another process created it meanwhile.
+			continue
+		}
+		if err != nil {
+			return nil, xerrors.Errorf("failed to create lock file %v: %w", path, err)
+		}
+		err = fi.Chmod(0666)
+		if err != nil {
+			fi.Close()
+			return nil, xerrors.Errorf("failed to chmod lock file %v: %w", path, err)
+		}
+		return fi, nil
+	}
+}
+
+// NewShared acquires the lock at path like New, but the lock can be taken by
+// the processes of other users too, e.g. to coordinate the use of a resource
+// of the host. Only the last directory of path is created.
+func NewShared(path string) (*Lock, error) {
+	fi, err := openShared(path)
+	if err != nil {
+		return nil, err
+	}
+
+	err = syscall.Flock(int(fi.Fd()), syscall.LOCK_EX)
+	if err != nil {
+		fi.Close()
+		return nil, xerrors.Errorf("failed to lock %v: %w", path, err)
+	}
+	return &Lock{fi: fi}, nil
+}
+
+// TryLock acquires the lock at path without blocking.
+// ErrLocked is returned if another process holds the lock.
+func TryLock(path string) (*Lock, error) {
+	fi, err := open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	err = syscall.Flock(int(fi.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err != nil {
+		fi.Close()
+		if err == syscall.EWOULDBLOCK {
+			return nil, ErrLocked
+		}
+		return nil, xerrors.Errorf("failed to lock %v: %w", path, err)
+	}
+	return &Lock{fi: fi}, nil
+}
+
+// Unlock releases the lock.
+func (l *Lock) Unlock() error {
+	err := syscall.Flock(int(l.fi.Fd()), syscall.LOCK_UN)
+	if err != nil {
+		l.fi.Close()
+		return xerrors.Errorf("failed to unlock %v: %w", l.fi.Name(), err)
+	}
+	return l.fi.Close()
+}
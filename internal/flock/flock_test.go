@@ -0,0 +1,58 @@
+package flock
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+)
+
+func TestLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flock")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "nested", "test.lock")
+
+	l, err := New(path)
+	require.NoError(t, err)
+
+	_, err = TryLock(path)
+	require.True(t, xerrors.Is(err, ErrLocked), "expected ErrLocked, got %v", err)
+
+	require.NoError(t, l.Unlock())
+
+	l, err = TryLock(path)
+	require.NoError(t, err)
+	require.NoError(t, l.Unlock())
+}
+
+func TestNewShared(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flock")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "shared", "test.lock")
+
+	l, err := NewShared(path)
+	require.NoError(t, err)
+
+	fi, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0666), fi.Mode().Perm())
+	fi, err = os.Stat(filepath.Dir(path))
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0777), fi.Mode().Perm())
+	require.NotZero(t, fi.Mode()&os.ModeSticky)
+
+	_, err = TryLock(path)
+	require.True(t, xerrors.Is(err, ErrLocked), "expected ErrLocked, got %v", err)
+	require.NoError(t, l.Unlock())
+
+	l, err = NewShared(path)
+	require.NoError(t, err)
+	require.NoError(t, l.Unlock())
+}
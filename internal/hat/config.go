@@ -0,0 +1,86 @@
+package hat
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"golang.org/x/xerrors"
+)
+
+// ConfigName is the name of the file declaring a hat's parameters, next to
+// its Dockerfile.
+const ConfigName = "hat.toml"
+
+// Param is a parameter of a hat, passed to its Dockerfile as a build
+// argument of the same name.
+type Param struct {
+	Description string `toml:"description"`
+	// Default is used if the parameter isn't set. Parameters without one
+	// must be set.
+	Default *string `toml:"default"`
+}
+
+// Config describes a hat.toml.
+type Config struct {
+	Params map[string]Param `toml:"params"`
+}
+
+// LoadConfig reads the hat.toml in dir. Hats without one have no parameters.
+func LoadConfig(dir string) (*Config, error) {
+	path := filepath.Join(dir, ConfigName)
+
+	var c Config
+	_, err := toml.DecodeFile(path, &c)
+	if os.IsNotExist(err) {
+		return &c, nil
+	}
+	if err != nil {
+		return nil, xerrors.Errorf("failed to read %v: %w", path, err)
+	}
+	return &c, nil
+}
+
+// Args returns the values of the hat's parameters, taken from args or their
+// defaults. It fails if args sets parameters the hat doesn't declare, or
+// doesn't set required ones.
+func (c *Config) Args(args map[string]string) (map[string]string, error) {
+	for k := range args {
+		if _, ok := c.Params[k]; !ok {
+			return nil, xerrors.Errorf("hat has no parameter %q, it has %v", k, c.paramNames())
+		}
+	}
+
+	vals := make(map[string]string, len(c.Params))
+	var missing []string
+	for k, p := range c.Params {
+		v, ok := args[k]
+		switch {
+		case ok:
+			vals[k] = v
+		case p.Default != nil:
+			vals[k] = *p.Default
+		default:
+			missing = append(missing, k)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return nil, xerrors.Errorf("hat parameters %v must be set", strings.Join(missing, ", "))
+	}
+	return vals, nil
+}
+
+func (c *Config) paramNames() string {
+	if len(c.Params) == 0 {
+		return "none"
+	}
+	names := make([]string, 0, len(c.Params))
+	for k := range c.Params {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
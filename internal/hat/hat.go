@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"bytes"
 	"io/ioutil"
+	"os/exec"
 
 	"golang.org/x/xerrors"
 
@@ -37,7 +38,7 @@ func ResolveGitHubPath(ghPath string) (string, error) {
 		return "", xerrors.Errorf("failed to create tempdir: %w", err)
 	}
 
-	cmd := xexec.Fmt("git clone git@github.com:%v.git %v", ghPath, dir)
+	cmd := exec.Command("git", "clone", "git@github.com:"+ghPath+".git", dir)
 	xexec.Attach(cmd)
 	err = cmd.Run()
 	if err != nil {
@@ -1,9 +1,13 @@
 package hat
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func Test_dockerReplaceFrom(t *testing.T) {
@@ -13,3 +17,39 @@ func Test_dockerReplaceFrom(t *testing.T) {
 		),
 	)
 }
+
+func TestConfig_Args(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hat")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c, err := LoadConfig(dir)
+	require.NoError(t, err)
+	_, err = c.Args(map[string]string{"go_version": "1.13"})
+	assert.Error(t, err)
+
+	err = ioutil.WriteFile(filepath.Join(dir, ConfigName), []byte(`
+[params.go_version]
+description = "Version of Go to install."
+default = "1.12"
+
+[params.node_version]
+`), 0644)
+	require.NoError(t, err)
+
+	c, err = LoadConfig(dir)
+	require.NoError(t, err)
+
+	args, err := c.Args(map[string]string{"node_version": "12"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"go_version": "1.12", "node_version": "12"}, args)
+
+	args, err = c.Args(map[string]string{"go_version": "1.13", "node_version": "12"})
+	require.NoError(t, err)
+	assert.Equal(t, "1.13", args["go_version"])
+
+	_, err = c.Args(nil)
+	assert.EqualError(t, err, "hat parameters node_version must be set")
+	_, err = c.Args(map[string]string{"node_version": "12", "rust_version": "1.40"})
+	assert.Error(t, err)
+}
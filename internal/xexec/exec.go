@@ -2,17 +2,29 @@
 package xexec
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"os/exec"
+	"strings"
 )
 
 func Fmt(cmdFmt string, args ...interface{}) *exec.Cmd {
 	return exec.Command("bash", "-c", fmt.Sprintf(cmdFmt, args...))
 }
 
+// FmtContext is like Fmt, but the command is killed when ctx is done.
+func FmtContext(ctx context.Context, cmdFmt string, args ...interface{}) *exec.Cmd {
+	return exec.CommandContext(ctx, "bash", "-c", fmt.Sprintf(cmdFmt, args...))
+}
+
 func Attach(cmd *exec.Cmd) {
 	cmd.Stderr = os.Stderr
 	cmd.Stdout = os.Stdout
 	cmd.Stdin = os.Stdin
 }
+
+// Quote quotes s for sh.
+func Quote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
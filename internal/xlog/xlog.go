@@ -0,0 +1,299 @@
+// Package xlog provides leveled, structured logging.
+//
+// Messages at or above the configured level are written to stderr in a
+// human readable format. Every message, including debug ones, is also
+// written as JSON to the log file if one is set.
+package xlog
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// Level is the severity of a message.
+type Level int
+
+// Levels in increasing severity.
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelSuccess
+	LevelWarn
+	LevelError
+	// LevelFatal messages exit the process after they're logged.
+	LevelFatal
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelSuccess:
+		return "success"
+	case LevelWarn:
+		return "warn"
+	case LevelError:
+		return "error"
+	case LevelFatal:
+		return "fatal"
+	default:
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+}
+
+// label is the level as shown on stderr.
+func (l Level) label() string {
+	switch l {
+	case LevelDebug:
+		return color.New(color.FgHiMagenta).Sprint("DEBUG")
+	case LevelInfo:
+		return color.HiBlueString("INFO")
+	case LevelSuccess:
+		return color.HiGreenString("SUCCESS")
+	case LevelWarn:
+		return color.New(color.FgHiYellow).Sprint("WARN")
+	default:
+		return color.RedString(strings.ToUpper(l.String()))
+	}
+}
+
+// Fields are structured context attached to messages.
+type Fields map[string]interface{}
+
+// Entry is a message as written to the log file.
+type Entry struct {
+	Time   time.Time `json:"time"`
+	Level  string    `json:"level"`
+	Msg    string    `json:"msg"`
+	Fields Fields    `json:"fields,omitempty"`
+}
+
+var (
+	mu       sync.Mutex
+	level    = LevelInfo
+	stderr   = io.Writer(os.Stderr)
+	filePath string
+	file     *os.File
+	// fileFailed is set once opening the log file failed, so it's only
+	// reported once.
+	fileFailed bool
+	// base are fields attached to every message written to the file.
+	base Fields
+	// exit is replaced in tests.
+	exit = os.Exit
+)
+
+// SetLevel sets the minimum level of messages written to stderr.
+func SetLevel(l Level) {
+	mu.Lock()
+	defer mu.Unlock()
+	level = l
+}
+
+// SetOutput sets where messages are written in the human readable format.
+// A nil w restores stderr.
+func SetOutput(w io.Writer) {
+	mu.Lock()
+	defer mu.Unlock()
+	if w == nil {
+		w = os.Stderr
+	}
+	stderr = w
+}
+
+// SetFile sets the file messages are written to as JSON, along with fields.
+// The file is only created once the first message is logged.
+func SetFile(path string, fields Fields) {
+	mu.Lock()
+	defer mu.Unlock()
+	if file != nil {
+		file.Close()
+		file = nil
+	}
+	filePath = path
+	fileFailed = false
+	base = fields
+}
+
+// Logger logs messages with fields.
+type Logger struct {
+	fields Fields
+}
+
+// With returns a logger that attaches fields to its messages.
+func With(fields Fields) *Logger {
+	return (&Logger{}).With(fields)
+}
+
+// With returns a logger that attaches fields to its messages, in addition to
+// the fields of l.
+func (l *Logger) With(fields Fields) *Logger {
+	merged := make(Fields, len(fields))
+	if l != nil {
+		for k, v := range l.fields {
+			merged[k] = v
+		}
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	return &Logger{fields: merged}
+}
+
+func (l *Logger) Debug(msg string, args ...interface{}) {
+	l.Log(LevelDebug, msg, args...)
+}
+
+func (l *Logger) Info(msg string, args ...interface{}) {
+	l.Log(LevelInfo, msg, args...)
+}
+
+func (l *Logger) Success(msg string, args ...interface{}) {
+	l.Log(LevelSuccess, msg, args...)
+}
+
+// Warn logs something that is likely a mistake, but doesn't prevent the
+// operation from continuing.
+func (l *Logger) Warn(msg string, args ...interface{}) {
+	l.Log(LevelWarn, msg, args...)
+}
+
+func (l *Logger) Error(msg string, args ...interface{}) {
+	l.Log(LevelError, msg, args...)
+}
+
+// Fatal logs the message and exits with status 1.
+func (l *Logger) Fatal(msg string, args ...interface{}) {
+	l.Log(LevelFatal, msg, args...)
+}
+
+// Log logs a message at lvl. A nil logger logs without fields.
+func (l *Logger) Log(lvl Level, msg string, args ...interface{}) {
+	now := time.Now()
+	if len(args) > 0 {
+		msg = fmt.Sprintf(msg, args...)
+	}
+	var fields Fields
+	if l != nil {
+		fields = l.fields
+	}
+
+	mu.Lock()
+	if lvl >= level {
+		fmt.Fprintf(stderr, "%v %v\t%v%v\n", now.Format("2006-01-02 15:04:05"), lvl.label(), msg, formatFields(fields))
+	}
+	writeFile(Entry{
+		Time:   now,
+		Level:  lvl.String(),
+		Msg:    msg,
+		Fields: fields,
+	})
+	mu.Unlock()
+
+	if lvl == LevelFatal {
+		exit(1)
+	}
+}
+
+// writeFile writes e to the log file. mu must be held.
+func writeFile(e Entry) {
+	if filePath == "" || fileFailed {
+		return
+	}
+	if file == nil {
+		err := os.MkdirAll(filepath.Dir(filePath), 0750)
+		if err == nil {
+			file, err = os.OpenFile(filePath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+		}
+		if err != nil {
+			fileFailed = true
+			fmt.Fprintf(stderr, "%v %v\tfailed to open log file: %v\n", time.Now().Format("2006-01-02 15:04:05"), LevelError.label(), err)
+			return
+		}
+	}
+
+	if len(base) > 0 {
+		fields := make(Fields, len(base)+len(e.Fields))
+		for k, v := range base {
+			fields[k] = v
+		}
+		for k, v := range e.Fields {
+			fields[k] = v
+		}
+		e.Fields = fields
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	file.Write(append(b, '\n'))
+}
+
+// formatFields formats fields for stderr, sorted by key.
+func formatFields(fields Fields) string {
+	if len(fields) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, k := range keys {
+		fmt.Fprintf(&b, " %v=%v", k, fields[k])
+	}
+	return b.String()
+}
+
+// FormatEntry formats e like messages written to stderr.
+func FormatEntry(e Entry) string {
+	lvl := LevelInfo
+	for l := LevelDebug; l <= LevelFatal; l++ {
+		if l.String() == e.Level {
+			lvl = l
+		}
+	}
+	return fmt.Sprintf("%v %v\t%v%v", e.Time.Local().Format("2006-01-02 15:04:05"), lvl.label(), e.Msg, formatFields(e.Fields))
+}
+
+var std = &Logger{}
+
+func Debug(msg string, args ...interface{}) {
+	std.Log(LevelDebug, msg, args...)
+}
+
+func Info(msg string, args ...interface{}) {
+	std.Log(LevelInfo, msg, args...)
+}
+
+func Success(msg string, args ...interface{}) {
+	std.Log(LevelSuccess, msg, args...)
+}
+
+// Warn logs something that is likely a mistake, but doesn't prevent the
+// operation from continuing.
+func Warn(msg string, args ...interface{}) {
+	std.Log(LevelWarn, msg, args...)
+}
+
+func Error(msg string, args ...interface{}) {
+	std.Log(LevelError, msg, args...)
+}
+
+// Fatal logs the message and exits with status 1.
+func Fatal(msg string, args ...interface{}) {
+	std.Log(LevelFatal, msg, args...)
+}
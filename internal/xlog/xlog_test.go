@@ -0,0 +1,66 @@
+package xlog
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "xlog")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var buf bytes.Buffer
+	stderr = &buf
+	defer func() { stderr = os.Stderr }()
+
+	var exited int
+	exit = func(code int) { exited = code }
+	defer func() { exit = os.Exit }()
+
+	path := filepath.Join(dir, "logs", "1.jsonl")
+	SetFile(path, Fields{"pid": 1})
+	defer SetFile("", nil)
+
+	Debug("hidden %v", 1)
+	With(Fields{"container": "cdr-sail"}).Info("started")
+	Fatal("failed: %v", "oops")
+	assert.Equal(t, 1, exited)
+
+	out := buf.String()
+	assert.NotContains(t, out, "hidden")
+	assert.Contains(t, out, "started container=cdr-sail")
+	assert.Contains(t, out, "failed: oops")
+
+	SetLevel(LevelDebug)
+	defer SetLevel(LevelInfo)
+	Debug("shown")
+	assert.Contains(t, buf.String(), "shown")
+
+	b, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	require.Len(t, lines, 4)
+	assert.Equal(t, `"level":"debug","msg":"hidden 1","fields":{"pid":1}}`, lines[0][strings.Index(lines[0], `"level"`):])
+	assert.Contains(t, lines[1], `"fields":{"container":"cdr-sail","pid":1}`)
+	assert.Contains(t, lines[2], `"level":"fatal"`)
+}
+
+func TestNilLogger(t *testing.T) {
+	var buf bytes.Buffer
+	stderr = &buf
+	defer func() { stderr = os.Stderr }()
+
+	var l *Logger
+	l.Info("no fields")
+	assert.Contains(t, buf.String(), "no fields\n")
+	l.With(Fields{"a": 1}).Info("fields")
+	assert.Contains(t, buf.String(), "fields a=1\n")
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"go.coder.com/sail/internal/xlog"
+)
+
+// withInterrupt returns a context that's canceled when the process receives
+// SIGINT or SIGTERM, giving in-flight operations a chance to clean up after
+// themselves. A second signal exits immediately.
+func withInterrupt(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		select {
+		case <-sigs:
+		case <-ctx.Done():
+			signal.Stop(sigs)
+			return
+		}
+
+		xlog.Info("interrupted, cleaning up... (interrupt again to force quit)")
+		cancel()
+
+		<-sigs
+		os.Exit(1)
+	}()
+
+	return ctx, cancel
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"golang.org/x/xerrors"
+
+	"go.coder.com/sail/internal/xexec"
+)
+
+// launcher is a desktop launcher that opens a project.
+type launcher struct {
+	// name is shown in the launcher, and is the window class of
+	// isolated profile windows.
+	name string
+	// cntName names the launcher's file.
+	cntName string
+	// args is the command opening the project.
+	args []string
+}
+
+// install writes the launcher for the current OS and returns its path.
+func (l launcher) install() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	switch runtime.GOOS {
+	case "linux", "freebsd":
+		path := filepath.Join(homeDir, ".local", "share", "applications", "sail-"+l.cntName+".desktop")
+		return path, writeLauncherFile(path, l.desktopEntry(), 0644)
+	case "darwin":
+		app := filepath.Join(homeDir, "Applications", "sail-"+l.cntName+".app")
+		err = writeLauncherFile(filepath.Join(app, "Contents", "Info.plist"), l.infoPlist(), 0644)
+		if err != nil {
+			return "", err
+		}
+		return app, writeLauncherFile(filepath.Join(app, "Contents", "MacOS", "sail-"+l.cntName), l.script(), 0755)
+	default:
+		return "", xerrors.Errorf("launchers aren't supported on %v", runtime.GOOS)
+	}
+}
+
+func writeLauncherFile(path string, b []byte, perm os.FileMode) error {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(path, b, perm)
+	if err != nil {
+		return xerrors.Errorf("failed to write %v: %w", path, err)
+	}
+	return nil
+}
+
+// desktopEntry returns a freedesktop.org desktop entry for the launcher.
+// See https://specifications.freedesktop.org/desktop-entry-spec/latest/
+func (l launcher) desktopEntry() []byte {
+	exec := make([]string, len(l.args))
+	for i, a := range l.args {
+		exec[i] = desktopQuote(a)
+	}
+
+	return []byte(fmt.Sprintf(`[Desktop Entry]
+Type=Application
+Name=%v
+Comment=Open %v with sail
+Exec=%v
+Terminal=false
+Categories=Development;
+StartupWMClass=%v
+`, l.name, l.name, strings.Join(exec, " "), l.name))
+}
+
+// desktopQuote quotes an argument of a desktop entry's Exec key.
+func desktopQuote(arg string) string {
+	if !strings.ContainsAny(arg, " \t\n\"'\\><~|&;$*?#()`%") {
+		return arg
+	}
+	r := strings.NewReplacer(`"`, `\\"`, "`", "\\\\`", `$`, `\\$`, `\`, `\\\\`, `%`, `%%`)
+	return `"` + r.Replace(arg) + `"`
+}
+
+// script returns the executable of the macOS app bundle.
+func (l launcher) script() []byte {
+	args := make([]string, len(l.args))
+	for i, a := range l.args {
+		args[i] = xexec.Quote(a)
+	}
+	return []byte("#!/bin/sh\nexec " + strings.Join(args, " ") + "\n")
+}
+
+// infoPlist returns the Info.plist of the macOS app bundle.
+func (l launcher) infoPlist() []byte {
+	return []byte(fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>CFBundleName</key>
+	<string>%v</string>
+	<key>CFBundleExecutable</key>
+	<string>sail-%v</string>
+	<key>CFBundleIdentifier</key>
+	<string>com.coder.sail.%v</string>
+	<key>CFBundlePackageType</key>
+	<string>APPL</string>
+</dict>
+</plist>
+`, xmlEscape(l.name), l.cntName, l.cntName))
+}
+
+func xmlEscape(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
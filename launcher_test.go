@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_launcher(t *testing.T) {
+	t.Parallel()
+
+	l := launcher{
+		name:    "cdr/sail",
+		cntName: "cdr_sail",
+		args:    []string{"/home/user/my bin/sail", "run", "cdr/sail"},
+	}
+
+	assert.Equal(t, `[Desktop Entry]
+Type=Application
+Name=cdr/sail
+Comment=Open cdr/sail with sail
+Exec="/home/user/my bin/sail" run cdr/sail
+Terminal=false
+Categories=Development;
+StartupWMClass=cdr/sail
+`, string(l.desktopEntry()))
+
+	assert.Equal(t, "#!/bin/sh\nexec '/home/user/my bin/sail' 'run' 'cdr/sail'\n", string(l.script()))
+	assert.Equal(t, `"100\\$%%"`, desktopQuote("100$%"))
+}
@@ -0,0 +1,441 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/docker/docker/client"
+	"golang.org/x/xerrors"
+
+	"go.coder.com/sail/internal/dockutil"
+	"go.coder.com/sail/internal/xlog"
+)
+
+// localAPIVersion is the latest version of the local API. It's sent in the
+// native message host handshake.
+const localAPIVersion = 2
+
+// localAPI is the local API used to manage sail. It's served to the browser
+// extension by the native message host, and on a unix socket by sail daemon.
+//
+// When served to the extension, version 2 endpoints require the token from
+// the handshake, which only the extension receives through native messaging,
+// so web pages can't use the API. It's passed as a bearer token, or as the
+// token query parameter for WebSockets as browsers can't set their headers.
+type localAPI struct {
+	// token is required by requests unless it's empty.
+	token string
+	// listProjects lists the projects. It defaults to scanning Docker.
+	listProjects func() ([]projectInfo, error)
+	// config returns sail's config, for the idle timeout of the proxies
+	// the API starts. Without it, proxies never stop their container.
+	config func() config
+	// gf reaches the daemons of the docker contexts of the projects listed
+	// by listProjects. Without it, only the selected daemon is used.
+	gf *globalFlags
+}
+
+func (a *localAPI) handler() http.Handler {
+	m := http.NewServeMux()
+	// Deprecated: kept for old versions of the extension.
+	m.HandleFunc("/api/v1/run", handleRun)
+
+	m.Handle("/api/v2/run", a.auth(http.HandlerFunc(handleRun)))
+	m.Handle("/api/v2/projects", a.auth(http.HandlerFunc(a.handleListProjects)))
+	m.Handle("/api/v2/projects/create", a.auth(http.HandlerFunc(handleCreateProject)))
+	m.Handle("/api/v2/projects/stop", a.auth(a.handleProjectAction(func(ctx context.Context, cli *client.Client, _, name string) error {
+		return stopContainer(ctx, cli, name)
+	})))
+	m.Handle("/api/v2/projects/remove", a.auth(a.handleProjectAction(a.removeProject)))
+	m.Handle("/api/v2/projects/proxy", a.auth(http.HandlerFunc(a.handleProxyProject)))
+	return m
+}
+
+// auth rejects requests without the API token.
+func (a *localAPI) auth(h http.Handler) http.Handler {
+	if a.token == "" {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.URL.Query().Get("token")
+		if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+			token = strings.TrimPrefix(auth, "Bearer ")
+		}
+		if subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) != 1 {
+			writeAPIError(w, http.StatusUnauthorized, xerrors.New("invalid token"))
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// apiProject is a project as returned by the local API.
+type apiProject struct {
+	Name      string `json:"name"`
+	Container string `json:"container"`
+	URL       string `json:"url"`
+	Status    string `json:"status"`
+	Running   bool   `json:"running"`
+	Image     string `json:"image"`
+	Hat       string `json:"hat,omitempty"`
+	Host      string `json:"host,omitempty"`
+	Rights    string `json:"rights,omitempty"`
+	// DockerContext is the docker context of the daemon running the
+	// container, empty for other backends.
+	DockerContext string `json:"docker_context,omitempty"`
+}
+
+func newAPIProject(info projectInfo) apiProject {
+	return apiProject{
+		Name:          info.name,
+		Container:     info.cntName,
+		URL:           info.url,
+		Status:        info.status,
+		Running:       info.running,
+		Image:         info.image,
+		Hat:           info.hat,
+		Host:          info.host,
+		Rights:        info.rights,
+		DockerContext: info.dockerContext,
+	}
+}
+
+func (p apiProject) info() projectInfo {
+	return projectInfo{
+		cntName:       p.Container,
+		name:          p.Name,
+		url:           p.URL,
+		status:        p.Status,
+		running:       p.Running,
+		image:         p.Image,
+		hat:           p.Hat,
+		host:          p.Host,
+		rights:        p.Rights,
+		dockerContext: p.DockerContext,
+	}
+}
+
+func (a *localAPI) handleListProjects(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeAPIError(w, http.StatusMethodNotAllowed, xerrors.Errorf("method %v not allowed", r.Method))
+		return
+	}
+
+	list := a.listProjects
+	if list == nil {
+		list = func() ([]projectInfo, error) {
+			return listProjects()
+		}
+	}
+	infos, err := list()
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	projects := make([]apiProject, 0, len(infos))
+	for _, info := range infos {
+		projects = append(projects, newAPIProject(info))
+	}
+	writeAPIResponse(w, projects)
+}
+
+type createProjectRequest struct {
+	// Project is the repo to run, as passed to sail run.
+	Project string `json:"project"`
+}
+
+// handleCreateProject creates the project in the request without opening it.
+// It responds once the project is online.
+func handleCreateProject(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeAPIError(w, http.StatusMethodNotAllowed, xerrors.Errorf("method %v not allowed", r.Method))
+		return
+	}
+
+	var req createProjectRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, xerrors.Errorf("invalid request: %w", err))
+		return
+	}
+	err = validateAPIProject(req.Project)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, xerrors.Errorf("invalid request: %w", err))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Minute*30)
+	defer cancel()
+
+	stderr := &tailWriter{max: 4096}
+	cmd := exec.CommandContext(ctx, os.Args[0], "run", "--url-only", "--", req.Project)
+	cmd.Stderr = stderr
+	out, err := cmd.Output()
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, xerrors.Errorf("failed to run %v: %v: %w", req.Project, strings.TrimSpace(stderr.String()), err))
+		return
+	}
+	writeAPIResponse(w, struct {
+		URL string `json:"url"`
+	}{strings.TrimSpace(string(out))})
+}
+
+// validateAPIProject checks that project, as passed to sail run by the API,
+// is a repo. It must not be taken for a flag.
+func validateAPIProject(project string) error {
+	if project == "" {
+		return xerrors.New("project must be set")
+	}
+	if strings.HasPrefix(project, "-") {
+		return xerrors.Errorf("invalid project %q", project)
+	}
+	for _, r := range project {
+		if unicode.IsSpace(r) || unicode.IsControl(r) {
+			return xerrors.Errorf("invalid project %q", project)
+		}
+	}
+	_, err := parseRepo("ssh", "github.com", "", project)
+	if err != nil {
+		return xerrors.Errorf("invalid project %q: %w", project, err)
+	}
+	return nil
+}
+
+type projectActionRequest struct {
+	// Container is the container name of the project, as listed
+	// by /api/v2/projects.
+	Container string `json:"container"`
+}
+
+// projectDaemon returns the docker context of the container cntName, as
+// listed by listProjects, and a client of its daemon. It's the selected
+// daemon for containers that aren't listed.
+func (a *localAPI) projectDaemon(cntName string) (string, *client.Client, error) {
+	if a.gf == nil || a.listProjects == nil {
+		return "", dockerClient(), nil
+	}
+	infos, err := a.listProjects()
+	if err != nil {
+		return "", nil, err
+	}
+	for _, info := range infos {
+		if info.cntName == cntName && info.dockerContext != "" {
+			cli, err := a.gf.dockerContextClient(info.dockerContext)
+			return info.dockerContext, cli, err
+		}
+	}
+	return "", dockerClient(), nil
+}
+
+// removeProject removes the container name. Projects of docker contexts other
+// than the selected one are removed by a sail process of their context, as
+// their hooks run with the docker CLI.
+func (a *localAPI) removeProject(ctx context.Context, cli *client.Client, dockerContext, name string) error {
+	if a.gf == nil || a.gf.dockerContextEnv(dockerContext) == nil {
+		return removeContainer(ctx, cli, name)
+	}
+	out, err := a.gf.contextSailCmd(dockerContext, "rm", toSailName(name)).CombinedOutput()
+	if err != nil {
+		return xerrors.Errorf("failed to remove %v: %s: %w", name, bytes.TrimSpace(out), err)
+	}
+	return nil
+}
+
+// handleProjectAction handles a POST of a projectActionRequest by running fn
+// on the project's container, with a client of its daemon.
+func (a *localAPI) handleProjectAction(fn func(ctx context.Context, cli *client.Client, dockerContext, name string) error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeAPIError(w, http.StatusMethodNotAllowed, xerrors.Errorf("method %v not allowed", r.Method))
+			return
+		}
+
+		var req projectActionRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, xerrors.Errorf("invalid request: %w", err))
+			return
+		}
+
+		dockerContext, cli, err := a.projectDaemon(req.Container)
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, err)
+			return
+		}
+		defer cli.Close()
+
+		ctx, cancel := context.WithTimeout(r.Context(), time.Minute)
+		defer cancel()
+
+		// Only sail containers may be managed through the API.
+		external, err := loadExternalEnv(req.Container)
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if external != nil {
+			err = fn(ctx, cli, dockerContext, req.Container)
+			if err != nil {
+				writeAPIError(w, http.StatusInternalServerError, err)
+				return
+			}
+			writeAPIResponse(w, struct{}{})
+			return
+		}
+		cnt, err := inspectContainer(ctx, cli, req.Container)
+		if err != nil {
+			if isContainerNotFoundError(err) {
+				writeAPIError(w, http.StatusNotFound, xerrors.Errorf("project %q not found", req.Container))
+				return
+			}
+			writeAPIError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if _, ok := cnt.Config.Labels[sailLabel]; !ok {
+			writeAPIError(w, http.StatusNotFound, xerrors.Errorf("project %q not found", req.Container))
+			return
+		}
+
+		err = fn(ctx, cli, dockerContext, req.Container)
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeAPIResponse(w, struct{}{})
+	})
+}
+
+// handleProxyProject starts the proxy of the project in the request, unless
+// it's running, and responds with its URL. The proxy starts the container
+// once it's visited.
+func (a *localAPI) handleProxyProject(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeAPIError(w, http.StatusMethodNotAllowed, xerrors.Errorf("method %v not allowed", r.Method))
+		return
+	}
+
+	var req projectActionRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, xerrors.Errorf("invalid request: %w", err))
+		return
+	}
+
+	external, err := loadExternalEnv(req.Container)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if external != nil {
+		writeAPIError(w, http.StatusBadRequest, xerrors.Errorf("%v backends serve code-server without a proxy", external.Backend))
+		return
+	}
+
+	dockerContext, cli, err := a.projectDaemon(req.Container)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer cli.Close()
+	if a.gf != nil && a.gf.dockerContextEnv(dockerContext) != nil {
+		writeAPIError(w, http.StatusBadRequest, xerrors.Errorf("%v runs on docker context %v, only projects of the daemon's context %v are proxied", req.Container, dockerContext, a.gf.dockerContext))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Minute)
+	defer cancel()
+
+	cnt, err := inspectContainer(ctx, cli, req.Container)
+	if err != nil {
+		if isContainerNotFoundError(err) {
+			writeAPIError(w, http.StatusNotFound, xerrors.Errorf("project %q not found", req.Container))
+			return
+		}
+		writeAPIError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if _, ok := cnt.Config.Labels[sailLabel]; !ok {
+		writeAPIError(w, http.StatusNotFound, xerrors.Errorf("project %q not found", req.Container))
+		return
+	}
+
+	u := cnt.Config.Labels[proxyURLLabel]
+	if u != "" {
+		resp, err := http.Get(u + "/sail/api/v1/healthz")
+		if err == nil {
+			resp.Body.Close()
+			writeAPIResponse(w, struct {
+				URL string `json:"url"`
+			}{u})
+			return
+		}
+	}
+
+	var idleTimeout time.Duration
+	if a.config != nil {
+		idleTimeout = time.Duration(a.config().IdleTimeout)
+	}
+	port, err := assignPort(req.Container, proxyPortName)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err)
+		return
+	}
+	u, err = forkProxy(req.Container, idleTimeout, port)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, xerrors.Errorf("failed to start proxy: %w", err))
+		return
+	}
+	writeAPIResponse(w, struct {
+		URL string `json:"url"`
+	}{u})
+}
+
+// stopContainer stops the container name.
+func stopContainer(ctx context.Context, cli *client.Client, name string) error {
+	external, err := loadExternalEnv(name)
+	if err != nil {
+		return err
+	}
+	if external != nil {
+		b, err := external.backend()
+		if err != nil {
+			return err
+		}
+		err = b.stop(ctx, name)
+		if err != nil {
+			return xerrors.Errorf("failed to stop %v: %w", name, err)
+		}
+		return nil
+	}
+
+	err = cli.ContainerStop(ctx, name, dockutil.DurationPtr(time.Second*10))
+	if err != nil {
+		return xerrors.Errorf("failed to stop %v: %w", name, err)
+	}
+	return nil
+}
+
+func writeAPIResponse(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		xlog.Error("failed to write response: %v", err)
+	}
+}
+
+func writeAPIError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(struct {
+		Error string `json:"error"`
+	}{err.Error()})
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_localAPIAuth(t *testing.T) {
+	t.Parallel()
+
+	api := &localAPI{token: "secret"}
+	h := api.auth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for _, tc := range []struct {
+		name   string
+		url    string
+		header string
+		status int
+	}{
+		{"none", "/", "", http.StatusUnauthorized},
+		{"wrong", "/", "Bearer nope", http.StatusUnauthorized},
+		{"header", "/", "Bearer secret", http.StatusOK},
+		{"query", "/?token=secret", "", http.StatusOK},
+	} {
+		r := httptest.NewRequest(http.MethodGet, tc.url, nil)
+		if tc.header != "" {
+			r.Header.Set("Authorization", tc.header)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		assert.Equal(t, tc.status, w.Code, tc.name)
+	}
+}
+
+func Test_validateAPIProject(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		project string
+		valid   bool
+	}{
+		{"cdr/sail", true},
+		{"https://github.com/cdr/sail", true},
+		{"", false},
+		{"--config=/tmp/evil.toml", false},
+		{"-h", false},
+		{"cdr/sail --rebuild", false},
+		{"cdr/sail\n", false},
+	} {
+		err := validateAPIProject(tc.project)
+		assert.Equal(t, tc.valid, err == nil, "%q: %v", tc.project, err)
+	}
+}
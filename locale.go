@@ -0,0 +1,137 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/docker/docker/api/types/mount"
+)
+
+// Image labels setting the time zone and locale of the container. The
+// timezone and locale config keys take precedence over them.
+const (
+	timezoneLabel = sailLabel + ".timezone"
+	localeLabel   = sailLabel + ".locale"
+)
+
+// localeNone turns off the propagation of the host's time zone or locale,
+// as the value of the timezone or locale config key or label.
+const localeNone = "none"
+
+// hostLocaltime is the host's time zone file, which is mounted into
+// containers that use the host's zone.
+const hostLocaltime = "/etc/localtime"
+
+// hostTimezone returns the host's time zone, like Europe/Berlin, from TZ,
+// the link /etc/localtime or /etc/timezone. It's empty if it's unknown.
+func hostTimezone() string {
+	if tz := strings.TrimPrefix(os.Getenv("TZ"), ":"); tz != "" && !strings.HasPrefix(tz, "/") {
+		return tz
+	}
+	if target, err := os.Readlink(hostLocaltime); err == nil {
+		if tz := zoneFromPath(target); tz != "" {
+			return tz
+		}
+	}
+	b, err := ioutil.ReadFile("/etc/timezone")
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// zoneFromPath returns the name of the time zone of the zoneinfo file at
+// path, like /usr/share/zoneinfo/Europe/Berlin or, on macOS,
+// /var/db/timezone/zoneinfo/Europe/Berlin.
+func zoneFromPath(path string) string {
+	i := strings.LastIndex(path, "zoneinfo/")
+	if i < 0 {
+		return ""
+	}
+	tz := path[i+len("zoneinfo/"):]
+	// The posix and right trees hold the same zones.
+	tz = strings.TrimPrefix(strings.TrimPrefix(tz, "posix/"), "right/")
+	return tz
+}
+
+// hostLocale returns the host's locale environment variables, LANG, LANGUAGE
+// and LC_*, sorted.
+func hostLocale() []string {
+	var env []string
+	for _, kv := range os.Environ() {
+		k := strings.SplitN(kv, "=", 2)[0]
+		if k == "LANG" || k == "LANGUAGE" || strings.HasPrefix(k, "LC_") {
+			env = append(env, kv)
+		}
+	}
+	sort.Strings(env)
+	return env
+}
+
+// localeSetting returns the setting of conf, or of the image's label if it's
+// empty.
+func localeSetting(conf string, labels map[string]string, label string) string {
+	if conf != "" {
+		return conf
+	}
+	return labels[label]
+}
+
+// localeEnv returns the container's TZ and locale environment variables. The
+// config keys and image labels pick a zone and a LANG, otherwise the host's
+// are used. Either is left to the image if it's localeNone.
+func (r *runner) localeEnv(labels map[string]string) []string {
+	if r.windows {
+		return nil
+	}
+
+	var env []string
+	switch tz := localeSetting(r.timezone, labels, timezoneLabel); tz {
+	case localeNone:
+	case "":
+		if tz = hostTimezone(); tz != "" {
+			env = append(env, "TZ="+tz)
+		}
+	default:
+		env = append(env, "TZ="+tz)
+	}
+
+	switch locale := localeSetting(r.locale, labels, localeLabel); locale {
+	case localeNone:
+	case "":
+		env = append(env, hostLocale()...)
+	default:
+		env = append(env, "LANG="+locale)
+	}
+	return env
+}
+
+// mountLocaltime mounts the host's /etc/localtime, so programs of images
+// without the zone's data still use the host's zone, if the container uses
+// it. It's only mounted from Linux hosts whose daemon shares their files,
+// unless the container already mounts something there.
+func (r *runner) mountLocaltime(mounts []mount.Mount, labels map[string]string) []mount.Mount {
+	if runtime.GOOS != "linux" || r.desktop || r.windows || !r.usesDocker() {
+		return mounts
+	}
+	if localeSetting(r.timezone, labels, timezoneLabel) != "" {
+		return mounts
+	}
+	if _, err := os.Stat(hostLocaltime); err != nil {
+		return mounts
+	}
+	for _, m := range mounts {
+		if m.Target == hostLocaltime {
+			return mounts
+		}
+	}
+	return append(mounts, mount.Mount{
+		Type:     mount.TypeBind,
+		Source:   hostLocaltime,
+		Target:   hostLocaltime,
+		ReadOnly: true,
+	})
+}
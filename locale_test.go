@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types/mount"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_zoneFromPath(t *testing.T) {
+	assert.Equal(t, "Europe/Berlin", zoneFromPath("/usr/share/zoneinfo/Europe/Berlin"))
+	assert.Equal(t, "Europe/Berlin", zoneFromPath("../usr/share/zoneinfo/posix/Europe/Berlin"))
+	assert.Equal(t, "America/New_York", zoneFromPath("/var/db/timezone/zoneinfo/America/New_York"))
+	assert.Equal(t, "", zoneFromPath("/etc/localtime.bak"))
+}
+
+func Test_localeEnv(t *testing.T) {
+	defer setenv("TZ", "Asia/Tokyo")()
+	defer setenv("LANG", "de_DE.UTF-8")()
+	defer setenv("LC_TIME", "en_GB.UTF-8")()
+
+	r := &runner{}
+	env := r.localeEnv(nil)
+	assert.Contains(t, env, "TZ=Asia/Tokyo")
+	assert.Contains(t, env, "LANG=de_DE.UTF-8")
+	assert.Contains(t, env, "LC_TIME=en_GB.UTF-8")
+
+	// Labels are overridden by the config.
+	labels := map[string]string{timezoneLabel: "UTC", localeLabel: localeNone}
+	assert.Equal(t, []string{"TZ=UTC"}, r.localeEnv(labels))
+	r = &runner{timezone: "Europe/Paris", locale: "C.UTF-8"}
+	assert.Equal(t, []string{"TZ=Europe/Paris", "LANG=C.UTF-8"}, r.localeEnv(labels))
+	r = &runner{timezone: localeNone, locale: localeNone}
+	assert.Empty(t, r.localeEnv(nil))
+	assert.Empty(t, (&runner{windows: true}).localeEnv(nil))
+}
+
+func Test_mountLocaltime(t *testing.T) {
+	// Zones other than the host's aren't mounted.
+	r := &runner{timezone: "UTC"}
+	assert.Empty(t, r.mountLocaltime(nil, nil))
+	r = &runner{desktop: true}
+	assert.Empty(t, r.mountLocaltime(nil, nil))
+
+	// Mounts of the container take precedence.
+	mounts := []mount.Mount{{Type: mount.TypeBind, Source: "/tmp/zone", Target: hostLocaltime}}
+	assert.Equal(t, mounts, (&runner{}).mountLocaltime(mounts, nil))
+}
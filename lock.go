@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/xerrors"
+
+	"go.coder.com/sail/internal/xlog"
+)
+
+// lockHeader starts the lock files sail lock writes.
+const lockHeader = `# The images this environment is built from, pinned to their digests.
+# Written by sail lock, sail honors it when building the environment.
+`
+
+// imageLock pins the images of a project's environment to their digests,
+// from the project's .sail/lock.
+type imageLock struct {
+	pins []pinnedImage
+}
+
+// lockPath is the project's lock file, which is committed to its repo.
+func (p *project) lockPath() string {
+	return filepath.Join(p.localDir(), ".sail", "lock")
+}
+
+// loadLock reads the project's lock file. It returns nil if the project has
+// none, or if the lock is ignored.
+func (p *project) loadLock() (*imageLock, error) {
+	if p.buildOpts.unlocked {
+		return nil, nil
+	}
+	b, err := ioutil.ReadFile(p.lockPath())
+	if os.IsNotExist(err) {
+		if p.buildOpts.frozen {
+			return nil, xerrors.Errorf("%v doesn't exist, write it with sail lock", p.lockPath())
+		}
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	l, err := parseImageLock(b)
+	if err != nil {
+		return nil, xerrors.Errorf("invalid %v: %w", p.lockPath(), err)
+	}
+	return l, nil
+}
+
+// parseImageLock parses a lock file, of one image pinned to its digest per
+// line, e.g. codercom/ubuntu-dev:latest@sha256:...
+func parseImageLock(b []byte) (*imageLock, error) {
+	l := &imageLock{}
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		pins := parseUpstream(line)
+		if len(pins) != 1 || pins[0].ref == "" || !strings.HasPrefix(pins[0].digest, "sha256:") {
+			return nil, xerrors.Errorf("invalid line %q, must be of form <image>@sha256:<digest>", line)
+		}
+		l.pins = append(l.pins, pins[0])
+	}
+	return l, nil
+}
+
+// formatImageLock formats pins as a lock file.
+func formatImageLock(pins []pinnedImage) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(lockHeader)
+	for _, p := range pins {
+		buf.WriteString(p.String() + "\n")
+	}
+	return buf.Bytes()
+}
+
+// find returns the pin of the image ref.
+func (l *imageLock) find(ref string) (pinnedImage, bool) {
+	for _, p := range l.pins {
+		if normalizeImageName(p.ref) == normalizeImageName(ref) {
+			return p, true
+		}
+	}
+	return pinnedImage{}, false
+}
+
+// checkUpstream returns an error unless the images of a container, the value
+// of its upstreamLabel, are all pinned at the digests of the lock.
+func (l *imageLock) checkUpstream(upstream string) error {
+	pins := parseUpstream(upstream)
+	if len(pins) == 0 {
+		return xerrors.New("it wasn't created from locked images")
+	}
+	for _, p := range pins {
+		lp, ok := l.find(p.ref)
+		if !ok || lp.digest != p.digest {
+			return xerrors.Errorf("%v isn't locked at %v", p.ref, p.digest)
+		}
+	}
+	return nil
+}
+
+// pinDockerfile returns the Dockerfile df with the images it's built from
+// replaced by their pinned references, along with their pins. It fails if
+// any of them isn't locked.
+func (l *imageLock) pinDockerfile(df []byte) ([]byte, []pinnedImage, error) {
+	var (
+		pins    []pinnedImage
+		missing []string
+	)
+	froms := dockerfileFroms(df)
+	for _, ref := range froms {
+		p, ok := l.find(ref)
+		if !ok {
+			missing = append(missing, ref)
+			continue
+		}
+		pins = append(pins, p)
+	}
+	if len(missing) > 0 {
+		return nil, nil, xerrors.Errorf("%v isn't locked", strings.Join(missing, ", "))
+	}
+
+	var buf bytes.Buffer
+	sc := bufio.NewScanner(bytes.NewReader(df))
+	for sc.Scan() {
+		line := sc.Text()
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && strings.EqualFold(fields[0], "FROM") {
+			for i, f := range fields[1:] {
+				if strings.HasPrefix(f, "--") {
+					continue
+				}
+				if stringsContain(froms, f) {
+					p, _ := l.find(f)
+					fields[i+1] = p.pinnedRef()
+					line = strings.Join(fields, " ")
+				}
+				break
+			}
+		}
+		buf.WriteString(line + "\n")
+	}
+	return buf.Bytes(), pins, nil
+}
+
+// pinnedRef returns the reference that pulls the image at its digest.
+func (p pinnedImage) pinnedRef() string {
+	return untaggedRef(p.ref) + "@" + p.digest
+}
+
+// untaggedRef returns the image reference ref without its tag or digest.
+func untaggedRef(ref string) string {
+	if i := strings.Index(ref, "@"); i >= 0 {
+		ref = ref[:i]
+	}
+	name, _ := splitImageTag(ref)
+	return name
+}
+
+// pullImage pulls the registry image the project runs, pinned to its digest
+// if the project's lock has it. It returns the reference of the pulled image,
+// and whether it had to be downloaded.
+func (p *project) pullImage(ctx context.Context, image string) (string, bool, error) {
+	lock, err := p.loadLock()
+	if err != nil {
+		return "", false, err
+	}
+	if lock != nil {
+		pin, ok := lock.find(image)
+		if !ok {
+			err = xerrors.Errorf("%v isn't locked in %v", image, p.lockPath())
+		} else {
+			var pulled bool
+			pulled, err = ensureImage(ctx, pin.pinnedRef(), p.buildOpts.platform)
+			if err == nil {
+				p.pinned = []pinnedImage{pin}
+				return pin.pinnedRef(), pulled, nil
+			}
+			err = xerrors.Errorf("failed to pull %v at its locked digest: %w", image, err)
+		}
+		if p.buildOpts.frozen {
+			return "", false, err
+		}
+		xlog.Warn("%v, using %v", err, image)
+	}
+
+	pulled, err := ensureImage(ctx, image, p.buildOpts.platform)
+	return image, pulled, err
+}
+
+// buildLocked builds the Dockerfile at path with the docker build args, with
+// the images it's built from pinned to their digests in the project's lock.
+func (p *project) buildLocked(ctx context.Context, path string, args []string) error {
+	lock, err := p.loadLock()
+	if err != nil {
+		return err
+	}
+	if lock != nil {
+		var df []byte
+		df, err = ioutil.ReadFile(path)
+		if err != nil {
+			return xerrors.Errorf("failed to read %v: %w", path, err)
+		}
+
+		var pins []pinnedImage
+		df, pins, err = lock.pinDockerfile(df)
+		if err == nil {
+			// The pinned Dockerfile is read from stdin, with the same context.
+			err = dockerBuild(ctx, p.buildOpts, append(args, "-f", "-", p.localDir()), string(df))
+			if err != nil {
+				return err
+			}
+			p.pinned = pins
+			return nil
+		}
+		err = xerrors.Errorf("%v: %w", p.lockPath(), err)
+		if p.buildOpts.frozen {
+			return err
+		}
+		xlog.Warn("%v, building with the images' tags", err)
+	}
+
+	return dockerBuild(ctx, p.buildOpts, append(args, "-f", path, p.localDir()), "")
+}
+
+// unpinImage returns the reference image was pinned from, if it's pinned to a
+// digest of upstream, the value of upstreamLabel.
+func unpinImage(image, upstream string) string {
+	if !strings.Contains(image, "@") {
+		return image
+	}
+	for _, p := range parseUpstream(upstream) {
+		if p.pinnedRef() == image {
+			return p.ref
+		}
+	}
+	return image
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_imageLock(t *testing.T) {
+	pins := []pinnedImage{
+		{ref: "codercom/ubuntu-dev:latest", digest: "sha256:abc"},
+		{ref: "registry:5000/node", digest: "sha256:def"},
+	}
+	l, err := parseImageLock(formatImageLock(pins))
+	require.NoError(t, err)
+	assert.Equal(t, pins, l.pins)
+
+	p, ok := l.find("codercom/ubuntu-dev")
+	assert.True(t, ok)
+	assert.Equal(t, "sha256:abc", p.digest)
+	_, ok = l.find("codercom/ubuntu-dev:18.04")
+	assert.False(t, ok)
+
+	_, err = parseImageLock([]byte("codercom/ubuntu-dev:latest\n"))
+	assert.Error(t, err)
+}
+
+func Test_checkUpstream(t *testing.T) {
+	l := &imageLock{pins: []pinnedImage{
+		{ref: "golang:1.12", digest: "sha256:abc"},
+		{ref: "codercom/ubuntu-dev", digest: "sha256:def"},
+	}}
+	assert.NoError(t, l.checkUpstream("golang:1.12@sha256:abc,codercom/ubuntu-dev@sha256:def"))
+	assert.Error(t, l.checkUpstream("golang:1.12@sha256:123"))
+	assert.Error(t, l.checkUpstream("node@sha256:abc"))
+	assert.Error(t, l.checkUpstream(""))
+}
+
+func Test_pinDockerfile(t *testing.T) {
+	l := &imageLock{pins: []pinnedImage{
+		{ref: "golang:1.12", digest: "sha256:abc"},
+		{ref: "codercom/ubuntu-dev", digest: "sha256:def"},
+	}}
+
+	df := `FROM --platform=linux/amd64 golang:1.12 AS build
+RUN go build
+FROM codercom/ubuntu-dev
+COPY --from=build /app /app
+`
+	pinned, pins, err := l.pinDockerfile([]byte(df))
+	require.NoError(t, err)
+	assert.Equal(t, l.pins, pins)
+	assert.Equal(t, `FROM --platform=linux/amd64 golang@sha256:abc AS build
+RUN go build
+FROM codercom/ubuntu-dev@sha256:def
+COPY --from=build /app /app
+`, string(pinned))
+
+	_, _, err = l.pinDockerfile([]byte("FROM node:12\n"))
+	assert.Error(t, err)
+}
+
+func Test_pinnedRef(t *testing.T) {
+	assert.Equal(t, "codercom/ubuntu-dev@sha256:abc", pinnedImage{ref: "codercom/ubuntu-dev:latest", digest: "sha256:abc"}.pinnedRef())
+	assert.Equal(t, "registry:5000/dev@sha256:abc", pinnedImage{ref: "registry:5000/dev", digest: "sha256:abc"}.pinnedRef())
+}
+
+func Test_unpinImage(t *testing.T) {
+	upstream := "codercom/ubuntu-dev:latest@sha256:abc"
+	assert.Equal(t, "codercom/ubuntu-dev:latest", unpinImage("codercom/ubuntu-dev@sha256:abc", upstream))
+	assert.Equal(t, "codercom/ubuntu-dev@sha256:def", unpinImage("codercom/ubuntu-dev@sha256:def", upstream))
+	assert.Equal(t, "ubuntu", unpinImage("ubuntu", upstream))
+}
+
+func Test_hatImageName(t *testing.T) {
+	assert.Equal(t, "codercom/ubuntu-dev:latest-hat-0123", hatImageName("codercom/ubuntu-dev:latest", "0123"))
+	assert.Equal(t, "codercom/ubuntu-dev:sha256-abc-hat-0123", hatImageName("codercom/ubuntu-dev@sha256:abc", "0123"))
+}
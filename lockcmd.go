@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"go.coder.com/cli"
+	"go.coder.com/sail/internal/xlog"
+)
+
+type lockcmd struct {
+	gf *globalFlags
+}
+
+func (c *lockcmd) Spec() cli.CommandSpec {
+	return cli.CommandSpec{
+		Name:  "lock",
+		Usage: "<repo>",
+		Desc: `Pins the images a project's environment is built from to the digests its
+container was created with, in the project's .sail/lock.
+
+Commit .sail/lock to the repo so everyone builds the environment from the same
+images. sail run, sail build and sail edit honor it, and fall back to the
+images' tags with a warning if the lock is missing an image or a digest can't
+be pulled. With sail run --frozen and sail build --frozen, they fail instead.
+
+sail upgrade ignores the lock. Run sail lock again after upgrading to pin the
+new versions.`,
+	}
+}
+
+func (c *lockcmd) Run(fl *flag.FlagSet) {
+	proj := c.gf.project(schemaPrefs{}, fl)
+	c.gf.ensureDockerDaemon()
+
+	cli := dockerClient()
+	defer cli.Close()
+
+	cnt, err := inspectContainer(context.Background(), cli, proj.cntName())
+	if err != nil {
+		xlog.Fatal("%v doesn't exist, create it with sail run: %v", proj.pathName(), err)
+	}
+
+	_, err = os.Stat(proj.dockerfilePath())
+	if os.IsNotExist(err) && proj.definesImage() {
+		xlog.Fatal("only images built from .sail/Dockerfile can be locked")
+	}
+
+	pins := parseUpstream(cnt.Config.Labels[upstreamLabel])
+	if len(pins) == 0 {
+		xlog.Fatal("%v has no registry images to lock, recreate it with sail run --rebuild if it predates sail lock", proj.pathName())
+	}
+
+	err = os.MkdirAll(filepath.Dir(proj.lockPath()), 0755)
+	if err != nil {
+		xlog.Fatal("%v", err)
+	}
+	err = ioutil.WriteFile(proj.lockPath(), formatImageLock(pins), 0644)
+	if err != nil {
+		xlog.Fatal("failed to write lock: %v", err)
+	}
+	for _, p := range pins {
+		xlog.Info("locked %v", p)
+	}
+	xlog.Success("wrote %v", proj.lockPath())
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/pkg/browser"
+
+	"go.coder.com/cli"
+	"go.coder.com/sail/internal/xlog"
+)
+
+type logincmd struct {
+	gf *globalFlags
+
+	username      string
+	passwordStdin bool
+	git           bool
+}
+
+func (c *logincmd) Spec() cli.CommandSpec {
+	return cli.CommandSpec{
+		Name:  "login",
+		Usage: "[flags] [registry | --git host]",
+		Desc: `Logs in to a private registry, or Docker Hub without one, with docker login.
+
+sail pulls and builds images from private registries with the credentials of
+docker login, including those of docker credential helpers. sail outdated and
+the pinning of base images use them to query registries too.
+
+With --git, it obtains a short-lived token for the git host, default_host
+without one, with the OAuth application of its git_auth in the config. With
+git_credentials, git in containers uses it for the host's HTTPS remotes, and
+sail refreshes it when it expires.`,
+	}
+}
+
+func (c *logincmd) RegisterFlags(fl *flag.FlagSet) {
+	fl.StringVar(&c.username, "username", "", "Username to log in with.")
+	fl.BoolVar(&c.passwordStdin, "password-stdin", false, "Read the password from stdin.")
+	fl.BoolVar(&c.git, "git", false, "Obtain a token for a git host instead.")
+}
+
+func (c *logincmd) Run(fl *flag.FlagSet) {
+	if fl.NArg() > 1 {
+		xlog.Fatal("sail login takes at most one registry")
+	}
+	if c.git {
+		c.loginGit(fl.Arg(0))
+		return
+	}
+	registry := fl.Arg(0)
+
+	args := []string{"login"}
+	if c.username != "" {
+		args = append(args, "--username", c.username)
+	}
+	if c.passwordStdin {
+		args = append(args, "--password-stdin")
+	}
+	if registry != "" {
+		args = append(args, registry)
+	}
+
+	cmd := exec.Command("docker", args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	err := cmd.Run()
+	if err != nil {
+		xlog.Fatal("failed to log in: %v", err)
+	}
+
+	if registry == "" {
+		registry = dockerHubRegistry
+	}
+	_, ok, err := registryCredentials(dockerConfigDir(), registry)
+	if err != nil {
+		xlog.Fatal("%v", err)
+	}
+	if !ok {
+		xlog.Warn("docker login stored no credentials for %v that sail can read", registry)
+	}
+}
+
+// loginGit obtains a token for the git host with the device flow.
+func (c *logincmd) loginGit(host string) {
+	conf := c.gf.config()
+	if host == "" {
+		host = conf.DefaultHost
+	}
+	auth, ok := conf.GitAuth[host]
+	if !ok {
+		xlog.Fatal("%v has no git_auth in %v", host, c.gf.configPath)
+	}
+
+	e := gitAuthEndpointsOf(auth.provider(host), host)
+	t, err := deviceLogin(context.Background(), e, auth, func(uri, code string) {
+		fmt.Fprintf(os.Stderr, "Enter the code %v at %v\n", code, uri)
+		_ = browser.OpenURL(uri)
+	})
+	if err != nil {
+		xlog.Fatal("failed to log in to %v: %v", host, err)
+	}
+	err = saveGitToken(host, t)
+	if err != nil {
+		xlog.Fatal("failed to save the token: %v", err)
+	}
+	if !conf.GitCredentials {
+		xlog.Warn("git in containers only uses the token with git_credentials = true")
+	}
+	xlog.Success("logged in to %v", host)
+}
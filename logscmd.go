@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+
+	"go.coder.com/cli"
+	"go.coder.com/sail/internal/xexec"
+	"go.coder.com/sail/internal/xlog"
+)
+
+type logscmd struct {
+	gf *globalFlags
+
+	self   bool
+	lines  int
+	follow bool
+	json   bool
+}
+
+func (c *logscmd) Spec() cli.CommandSpec {
+	return cli.CommandSpec{
+		Name:  "logs",
+		Usage: "[flags] <repo>",
+		Desc: `Shows the logs of a project's container, or sail's own logs with --self.
+
+Every sail invocation logs to its own file in ~/.config/sail/logs, including
+debug messages that are only shown with -v.`,
+	}
+}
+
+func (c *logscmd) RegisterFlags(fl *flag.FlagSet) {
+	fl.BoolVar(&c.self, "self", false, "Show the logs of recent sail invocations instead.")
+	fl.IntVar(&c.lines, "n", 100, "Number of lines to show.")
+	fl.BoolVar(&c.follow, "f", false, "Follow the container's logs.")
+	fl.BoolVar(&c.json, "json", false, "Print sail's own logs as JSON.")
+}
+
+func (c *logscmd) Run(fl *flag.FlagSet) {
+	if c.self {
+		c.showSelf()
+		return
+	}
+
+	proj := c.gf.project(schemaPrefs{}, fl)
+	c.gf.ensureDockerDaemon()
+
+	args := []string{"logs", "--tail", fmt.Sprint(c.lines)}
+	if c.follow {
+		args = append(args, "-f")
+	}
+	cmd := exec.Command("docker", append(args, proj.cntName())...)
+	xexec.Attach(cmd)
+	err := cmd.Run()
+	if err != nil {
+		xlog.Fatal("failed to get logs of %v: %v", proj.pathName(), err)
+	}
+}
+
+func (c *logscmd) showSelf() {
+	entries, err := recentSelfLogs(selfLogDir(), c.lines)
+	if err != nil {
+		xlog.Fatal("failed to read logs: %v", err)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	for _, e := range entries {
+		if c.json {
+			err = enc.Encode(e)
+			if err != nil {
+				xlog.Fatal("%v", err)
+			}
+			continue
+		}
+		fmt.Println(xlog.FormatEntry(e))
+	}
+}
@@ -5,19 +5,33 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
 	"text/tabwriter"
+	"time"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+	"github.com/docker/go-units"
 	"golang.org/x/xerrors"
 
 	"go.coder.com/cli"
-	"go.coder.com/flog"
+	"go.coder.com/sail/internal/xlog"
 )
 
 type lscmd struct {
-	all bool
+	gf *globalFlags
+
+	all   bool
+	stats bool
+
+	filter  projectFilter
+	labels  string
+	group   string
+	groupBy string
 }
 
 func (c *lscmd) Spec() cli.CommandSpec {
@@ -29,20 +43,190 @@ func (c *lscmd) Spec() cli.CommandSpec {
 
 func (c *lscmd) RegisterFlags(fl *flag.FlagSet) {
 	fl.BoolVar(&c.all, "all", false, "Show stopped container.")
+	fl.StringVar(&c.filter.org, "org", "", "Only show projects belonging to org.")
+	fl.StringVar(&c.filter.hat, "hat", "", "Only show projects using hat.")
+	fl.StringVar(&c.filter.image, "image", "", "Only show projects using image.")
+	fl.StringVar(&c.filter.status, "status", "", "Only show projects with status (running or stopped).")
+	fl.StringVar(&c.labels, "label", "", "Only show projects matching the comma separated label selectors (key or key=value).")
+	fl.StringVar(&c.group, "group", "", "Only show the projects of the group, see groups in the config.")
+	fl.StringVar(&c.groupBy, "group-by", "", "Group projects by host or org.")
+	fl.BoolVar(&c.stats, "stats", false, "Show the CPU, memory and disk usage of projects.")
 }
 
 // projectInfo contains high-level project metadata as returned by the ls
 // command.
 type projectInfo struct {
-	name   string
+	// cntName is the name of the project's container.
+	cntName string
+	name    string
+	hat     string
+	url     string
+	status  string
+	image   string
+	host    string
+	// remote is the URI the project was cloned from, if any.
+	remote string
+	// localDir is the project directory on the host.
+	localDir string
+	running  bool
+	// rights describes any elevated rights of the container.
+	rights string
+	// dockerContext is the docker context of the daemon running the
+	// container. It's empty for projects of external backends.
+	dockerContext string
+}
+
+// org returns the organization of the project, or the empty string
+// if the project isn't org-qualified.
+func (info projectInfo) org() string {
+	sp := strings.SplitN(info.name, "/", 2)
+	if len(sp) != 2 {
+		return ""
+	}
+	return sp[0]
+}
+
+// projectFilter narrows down the projects shown by ls.
+// Empty fields match everything.
+type projectFilter struct {
+	org    string
 	hat    string
-	url    string
+	image  string
 	status string
+	// projects are the names of the projects to show, if set.
+	projects []string
+}
+
+func (f projectFilter) validate() error {
+	switch f.status {
+	case "", "running", "stopped":
+		return nil
+	default:
+		return xerrors.Errorf("invalid status %q, must be running or stopped", f.status)
+	}
+}
+
+func (f projectFilter) match(info projectInfo) bool {
+	switch {
+	case f.org != "" && f.org != info.org():
+		return false
+	case f.hat != "" && f.hat != info.hat:
+		return false
+	case f.image != "" && f.image != info.image:
+		return false
+	case f.status == "running" && !info.running:
+		return false
+	case f.status == "stopped" && info.running:
+		return false
+	case f.projects != nil && !stringsContain(f.projects, info.name):
+		return false
+	}
+	return true
+}
+
+// filterProjects returns the projects that match f.
+func filterProjects(infos []projectInfo, f projectFilter) []projectInfo {
+	filtered := make([]projectInfo, 0, len(infos))
+	for _, info := range infos {
+		if f.match(info) {
+			filtered = append(filtered, info)
+		}
+	}
+	return filtered
+}
+
+// groupProjects groups projects by host or org. The returned keys are sorted
+// and projects keep their relative order within a group.
+func groupProjects(infos []projectInfo, by string) ([]string, map[string][]projectInfo, error) {
+	var key func(projectInfo) string
+	switch by {
+	case "host":
+		key = func(info projectInfo) string { return info.host }
+	case "org":
+		key = projectInfo.org
+	default:
+		return nil, nil, xerrors.Errorf("invalid group %q, must be host or org", by)
+	}
+
+	groups := make(map[string][]projectInfo)
+	var keys []string
+	for _, info := range infos {
+		k := key(info)
+		if _, ok := groups[k]; !ok {
+			keys = append(keys, k)
+		}
+		groups[k] = append(groups[k], info)
+	}
+	sort.Strings(keys)
+
+	return keys, groups, nil
+}
+
+// listProjects grabs a list of all projects of the selected daemon.
+// Only containers matching all of the label selectors are included.
+func listProjects(labels ...string) ([]projectInfo, error) {
+	cli := dockerClient()
+	defer cli.Close()
+	return listProjectsOf(context.Background(), cli, labels...)
+}
+
+// dockerContextListTimeout bounds how long listing the projects of docker
+// contexts other than the selected one takes, so a daemon that's down doesn't
+// hang sail.
+const dockerContextListTimeout = time.Second * 10
+
+// listAllProjects lists the projects of all docker contexts, see
+// dockerContexts. Projects of contexts other than the selected one have it in
+// their status. Contexts whose daemon can't be reached are left out with a
+// warning.
+func (gf *globalFlags) listAllProjects(labels ...string) ([]projectInfo, error) {
+	var all []projectInfo
+	for i, name := range gf.dockerContexts() {
+		infos, err := gf.listContextProjects(name, labels...)
+		if err != nil {
+			if i == 0 {
+				return nil, err
+			}
+			xlog.Warn("failed to list projects of docker context %v: %v", name, err)
+			continue
+		}
+		if i > 0 {
+			for j := range infos {
+				infos[j].status = fmt.Sprintf("%v (docker context %v)", infos[j].status, name)
+			}
+		}
+		all = append(all, infos...)
+	}
+	return all, nil
+}
+
+// listContextProjects lists the projects of the docker context name.
+func (gf *globalFlags) listContextProjects(name string, labels ...string) ([]projectInfo, error) {
+	cli, err := gf.dockerContextClient(name)
+	if err != nil {
+		return nil, err
+	}
+	defer cli.Close()
+
+	ctx := context.Background()
+	if name != gf.dockerContext {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, dockerContextListTimeout)
+		defer cancel()
+	}
+	infos, err := listProjectsOf(ctx, cli, labels...)
+	if err != nil {
+		return nil, err
+	}
+	for i := range infos {
+		infos[i].dockerContext = name
+	}
+	return infos, nil
 }
 
-// listProjects grabs a list of all projects.:
-func listProjects() ([]projectInfo, error) {
-	cnts, err := listContainers()
+// listProjectsOf lists the projects of the daemon of cli, like listProjects.
+func listProjectsOf(ctx context.Context, cli *client.Client, labels ...string) ([]projectInfo, error) {
+	cnts, err := listContainersOf(ctx, cli, labels...)
 	if err != nil {
 		return nil, xerrors.Errorf("failed to list containers: %w", err)
 	}
@@ -54,20 +238,32 @@ func listProjects() ([]projectInfo, error) {
 
 		dockerName := trimDockerName(cnt)
 		if dockerName == "" {
-			flog.Error("container %v doesn't have a name.", cnt.ID)
+			xlog.Error("container %v doesn't have a name.", cnt.ID)
 			continue
 		}
+		info.cntName = dockerName
 		info.name = toSailName(dockerName)
+		if name := cnt.Labels[nameLabel]; name != "" {
+			info.name = name
+		}
 
-		url, err := proxyURL(dockerName)
-		if err != nil {
-			flog.Error("container %v doesn't have a proxy URL.", info.name)
+		url, ok := cnt.Labels[proxyURLLabel]
+		if !ok {
+			xlog.Error("container %v doesn't have a proxy URL.", info.name)
 			continue
 		}
 
 		info.url = url
 		info.hat = cnt.Labels[hatLabel]
 		info.status = cnt.Status
+		info.image = cnt.Image
+		info.running = cnt.State == "running"
+		info.rights = describeRights(cnt.Labels)
+		info.localDir = cnt.Labels[projectLocalDirLabel]
+		if remote := cnt.Labels[repoLabel]; remote != "" {
+			info.remote = remote
+			info.host = strings.SplitN(normalizeRemote(remote), "/", 2)[0]
+		}
 
 		infos = append(infos, info)
 	}
@@ -76,38 +272,126 @@ func listProjects() ([]projectInfo, error) {
 }
 
 func (c *lscmd) Run(fl *flag.FlagSet) {
-	infos, err := listProjects()
+	c.gf.selectDockerContext("")
+
+	err := c.filter.validate()
 	if err != nil {
-		flog.Fatal("failed to list projects: %v", err)
+		xlog.Fatal("%v", err)
+	}
+	if c.group != "" {
+		c.filter.projects, err = c.gf.config().group(c.group)
+		if err != nil {
+			xlog.Fatal("%v", err)
+		}
+	}
+
+	var labels []string
+	if c.labels != "" {
+		labels = strings.Split(c.labels, ",")
+	}
+
+	var infos []projectInfo
+	if len(labels) == 0 {
+		// Labels aren't part of the API, so only use the daemon without them.
+		infos, err = daemonProjects(daemonSocketPath())
+	}
+	if len(labels) > 0 || err != nil {
+		infos, err = c.gf.listAllProjects(labels...)
+		if err != nil {
+			xlog.Fatal("failed to list projects: %v", err)
+		}
+	}
+	external, err := externalProjects(context.Background(), labels...)
+	if err != nil {
+		xlog.Error("failed to list projects of other backends: %v", err)
+	}
+	infos = filterProjects(append(infos, external...), c.filter)
+
+	var stats map[string]lsStats
+	if c.stats {
+		stats = c.gf.projectStats(infos, lsStatsTimeout)
 	}
 
 	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
 
-	fmt.Fprintf(tw, "name\that\turl\tstatus\n")
-	for _, info := range infos {
-		fmt.Fprintf(tw, "%v\t%v\t%v\t%v\n", info.name, info.hat, info.url, info.status)
+	if c.groupBy == "" {
+		writeHeader(tw, c.stats)
+		writeProjects(tw, infos, stats)
+		tw.Flush()
+		os.Exit(0)
+	}
+
+	keys, groups, err := groupProjects(infos, c.groupBy)
+	if err != nil {
+		xlog.Fatal("%v", err)
+	}
+	for i, k := range keys {
+		if i > 0 {
+			fmt.Fprintln(tw)
+		}
+		title := k
+		if title == "" {
+			title = "<none>"
+		}
+		fmt.Fprintf(tw, "%v: %v\n", c.groupBy, title)
+		writeHeader(tw, c.stats)
+		writeProjects(tw, groups[k], stats)
 	}
 	tw.Flush()
 
 	os.Exit(0)
 }
 
+func writeHeader(tw *tabwriter.Writer, stats bool) {
+	if stats {
+		fmt.Fprintf(tw, "name\that\turl\tstatus\trights\tcpu\trss\tdisk\n")
+		return
+	}
+	fmt.Fprintf(tw, "name\that\turl\tstatus\trights\n")
+}
+
+// writeProjects writes infos to tw, including their stats unless stats is nil.
+func writeProjects(tw *tabwriter.Writer, infos []projectInfo, stats map[string]lsStats) {
+	for _, info := range infos {
+		fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t%v", info.name, info.hat, info.url, info.status, info.rights)
+		if stats != nil {
+			st := stats[info.cntName]
+			fmt.Fprintf(tw, "\t%v\t%v\t%v", st.cpu(), st.rss(), st.disk())
+		}
+		fmt.Fprintln(tw)
+	}
+}
+
 // listContainers lists the sail containers on the host that
 // are filterable by the sail label: com.coder.sail
-func listContainers() ([]types.Container, error) {
+// Additional label selectors of the form key or key=value narrow down
+// the result.
+func listContainers(labels ...string) ([]types.Container, error) {
 	cli := dockerClient()
 	defer cli.Close()
+	return listContainersOf(context.Background(), cli, labels...)
+}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
+// listContainersOf lists the sail containers of the daemon of cli, like
+// listContainers.
+func listContainersOf(ctx context.Context, cli *client.Client, labels ...string) ([]types.Container, error) {
 	filter := filters.NewArgs()
 	filter.Add("label", sailLabel)
+	for _, l := range labels {
+		filter.Add("label", strings.TrimSpace(l))
+	}
 
-	return cli.ContainerList(ctx, types.ContainerListOptions{
+	cnts, err := cli.ContainerList(ctx, types.ContainerListOptions{
 		All:     true,
 		Filters: filter,
 	})
+	if err != nil {
+		return nil, err
+	}
+	for _, cnt := range cnts {
+		migrateContainerLabels(trimDockerName(cnt), cnt.Labels)
+	}
+	return cnts, nil
 }
 
 // trimDockerName trims the `/` prefix from the docker container name.
@@ -135,3 +419,155 @@ func toSailName(dockerName string) string {
 func toDockerName(sailName string) string {
 	return strings.Replace(sailName, "/", "_", 1)
 }
+
+// lsStatsTimeout bounds how long ls waits for the stats of projects.
+const lsStatsTimeout = time.Second * 5
+
+// lsStats is the resource usage of a project shown by ls --stats.
+// Fields are negative if they're unknown, e.g. because the project is stopped.
+type lsStats struct {
+	cpuPercent float64
+	rssBytes   int64
+	diskBytes  int64
+}
+
+func (s lsStats) cpu() string {
+	if s.cpuPercent < 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%%", s.cpuPercent)
+}
+
+func (s lsStats) rss() string {
+	if s.rssBytes < 0 {
+		return "-"
+	}
+	return units.BytesSize(float64(s.rssBytes))
+}
+
+func (s lsStats) disk() string {
+	if s.diskBytes < 0 {
+		return "-"
+	}
+	return units.BytesSize(float64(s.diskBytes))
+}
+
+// projectStats gets the stats of infos in parallel, from the daemon of their
+// docker context, giving up on any that take longer than timeout.
+func (gf *globalFlags) projectStats(infos []projectInfo, timeout time.Duration) map[string]lsStats {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var (
+		mu        sync.Mutex
+		wg        sync.WaitGroup
+		stats     = make(map[string]lsStats, len(infos))
+		byContext = make(map[string][]projectInfo)
+	)
+	for _, info := range infos {
+		stats[info.cntName] = lsStats{cpuPercent: -1, rssBytes: -1, diskBytes: -1}
+		if info.dockerContext != "" {
+			byContext[info.dockerContext] = append(byContext[info.dockerContext], info)
+		}
+	}
+	set := func(cntName string, f func(*lsStats)) {
+		mu.Lock()
+		defer mu.Unlock()
+		st, ok := stats[cntName]
+		if !ok {
+			return
+		}
+		f(&st)
+		stats[cntName] = st
+	}
+
+	for name, infos := range byContext {
+		cli, err := gf.dockerContextClient(name)
+		if err != nil {
+			xlog.Error("failed to get stats of docker context %v: %v", name, err)
+			continue
+		}
+		defer cli.Close()
+
+		wg.Add(1)
+		go func(infos []projectInfo) {
+			defer wg.Done()
+			for cntName, st := range sampleStats(ctx, cli, infos) {
+				st := st
+				set(cntName, func(s *lsStats) {
+					s.cpuPercent = cpuPercent(st)
+					s.rssBytes = int64(rssUsage(st))
+				})
+			}
+		}(infos)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			usages, err := containerDiskUsages(ctx, cli)
+			if err != nil {
+				xlog.Error("failed to get disk usage: %v", err)
+				return
+			}
+			for cntName, size := range usages {
+				set(cntName, func(s *lsStats) {
+					s.diskBytes = size
+				})
+			}
+		}()
+	}
+
+	wg.Wait()
+	return stats
+}
+
+// cpuPercent computes the CPU usage of a stats sample like docker stats does.
+func cpuPercent(st types.StatsJSON) float64 {
+	cpuDelta := float64(st.CPUStats.CPUUsage.TotalUsage) - float64(st.PreCPUStats.CPUUsage.TotalUsage)
+	systemDelta := float64(st.CPUStats.SystemUsage) - float64(st.PreCPUStats.SystemUsage)
+	cpus := float64(st.CPUStats.OnlineCPUs)
+	if cpus == 0 {
+		cpus = float64(len(st.CPUStats.CPUUsage.PercpuUsage))
+	}
+	if cpuDelta <= 0 || systemDelta <= 0 {
+		return 0
+	}
+	return cpuDelta / systemDelta * cpus * 100
+}
+
+// rssUsage returns the resident memory of the container. It falls back to
+// the memory usage without the page cache if the kernel doesn't report it.
+func rssUsage(st types.StatsJSON) uint64 {
+	if rss, ok := st.MemoryStats.Stats["rss"]; ok {
+		return rss
+	}
+	// cgroup v2 calls it anon.
+	if anon, ok := st.MemoryStats.Stats["anon"]; ok {
+		return anon
+	}
+	return memoryUsage(st)
+}
+
+// containerDiskUsages returns the size of the writable layer and storage on
+// the host of each sail container, by container name.
+func containerDiskUsages(ctx context.Context, cli *client.Client) (map[string]int64, error) {
+	cnts, err := cli.ContainerList(ctx, types.ContainerListOptions{
+		All:     true,
+		Size:    true,
+		Filters: filters.NewArgs(filters.Arg("label", sailLabel)),
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("failed to list containers: %w", err)
+	}
+
+	usages := make(map[string]int64, len(cnts))
+	for _, cnt := range cnts {
+		cntName := trimDockerName(cnt)
+		storage, err := dirSize(filepath.Join(metaRoot(), cntName))
+		if err != nil {
+			xlog.Error("failed to get storage size of %v: %v", cntName, err)
+		}
+		usages[cntName] = cnt.SizeRw + storage
+	}
+	return usages, nil
+}
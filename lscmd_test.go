@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"text/tabwriter"
+
+	"github.com/docker/docker/api/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_filterProjects(t *testing.T) {
+	infos := []projectInfo{
+		{name: "cdr/sail", hat: "~/hat", image: "cdr_sail", host: "github.com", running: true},
+		{name: "cdr/api", image: "codercom/ubuntu-dev", host: "gitlab.com"},
+		{name: "nhooyr/websocket", image: "codercom/ubuntu-dev-go", host: "github.com", running: true},
+	}
+
+	names := func(infos []projectInfo) []string {
+		var names []string
+		for _, info := range infos {
+			names = append(names, info.name)
+		}
+		return names
+	}
+
+	assert.Equal(t, []string{"cdr/sail", "cdr/api", "nhooyr/websocket"}, names(filterProjects(infos, projectFilter{})))
+	assert.Equal(t, []string{"cdr/sail", "cdr/api"}, names(filterProjects(infos, projectFilter{org: "cdr"})))
+	assert.Equal(t, []string{"cdr/sail"}, names(filterProjects(infos, projectFilter{hat: "~/hat"})))
+	assert.Equal(t, []string{"cdr/api"}, names(filterProjects(infos, projectFilter{status: "stopped"})))
+	assert.Equal(t, []string{"nhooyr/websocket"}, names(filterProjects(infos, projectFilter{image: "codercom/ubuntu-dev-go", status: "running"})))
+	assert.Equal(t, []string{"cdr/sail", "nhooyr/websocket"}, names(filterProjects(infos, projectFilter{projects: []string{"nhooyr/websocket", "cdr/sail"}})))
+
+	keys, groups, err := groupProjects(infos, "host")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"github.com", "gitlab.com"}, keys)
+	assert.Equal(t, []string{"cdr/sail", "nhooyr/websocket"}, names(groups["github.com"]))
+
+	_, _, err = groupProjects(infos, "image")
+	assert.Error(t, err)
+	assert.Error(t, projectFilter{status: "paused"}.validate())
+}
+
+func Test_lsStats(t *testing.T) {
+	var st types.StatsJSON
+	st.CPUStats.CPUUsage.TotalUsage = 300
+	st.PreCPUStats.CPUUsage.TotalUsage = 100
+	st.CPUStats.SystemUsage = 2000
+	st.PreCPUStats.SystemUsage = 1000
+	st.CPUStats.OnlineCPUs = 4
+	assert.Equal(t, 80.0, cpuPercent(st))
+
+	st.MemoryStats.Usage = 100
+	st.MemoryStats.Stats = map[string]uint64{"cache": 40}
+	assert.Equal(t, uint64(60), rssUsage(st))
+	st.MemoryStats.Stats["rss"] = 50
+	assert.Equal(t, uint64(50), rssUsage(st))
+
+	var buf bytes.Buffer
+	tw := tabwriter.NewWriter(&buf, 0, 0, 1, ' ', 0)
+	writeProjects(tw, []projectInfo{
+		{cntName: "cdr_sail", name: "cdr/sail"},
+		{cntName: "cdr_api", name: "cdr/api"},
+	}, map[string]lsStats{
+		"cdr_sail": {cpuPercent: 12.34, rssBytes: 1 << 20, diskBytes: 1 << 30},
+		"cdr_api":  {cpuPercent: -1, rssBytes: -1, diskBytes: 1 << 10},
+	})
+	tw.Flush()
+	lines := strings.Split(buf.String(), "\n")
+	assert.Regexp(t, `^cdr/sail .* 12\.3% 1MiB +1GiB$`, lines[0])
+	assert.Regexp(t, `^cdr/api .* - +- +1KiB$`, lines[1])
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/go-connections/nat"
+	"golang.org/x/xerrors"
+
+	"go.coder.com/sail/internal/xexec"
+	"go.coder.com/sail/internal/xlog"
+)
+
+// lxdInitPath is the init process of LXD containers, a script running the
+// command of their Docker spec, which is code-server's launcher.
+const lxdInitPath = "/usr/local/bin/sail-init"
+
+// lxdRunning is the state of running LXD containers.
+const lxdRunning = "RUNNING"
+
+// lxdBackend runs containers as LXD or Incus system containers with their
+// client, cli. Their images are imported from the Docker images sail builds,
+// and code-server is their init process, like in Docker, so they're only up
+// while it is.
+type lxdBackend struct {
+	cli string
+}
+
+// run runs the LXD client with args, returning its output.
+func (b lxdBackend) run(ctx context.Context, args ...string) ([]byte, error) {
+	out, err := exec.CommandContext(ctx, b.cli, args...).CombinedOutput()
+	if err != nil {
+		return nil, xerrors.Errorf("%v %v: %s: %w", b.cli, args[0], bytes.TrimSpace(out), err)
+	}
+	return out, nil
+}
+
+func (b lxdBackend) create(ctx context.Context, cntName string, containerConfig *container.Config, hostConfig *container.HostConfig) error {
+	alias, imageEnv, err := b.importImage(ctx, containerConfig.Image)
+	if err != nil {
+		return err
+	}
+	uid, gid, err := imageUser(ctx, containerConfig.Image, containerConfig.User)
+	if err != nil {
+		return err
+	}
+
+	// The image's environment is lost when it's imported, so it's set along
+	// with the container's.
+	env := append(append(append([]string(nil), imageEnv...), "HOME="+guestHome(containerConfig.Labels)), containerConfig.Env...)
+
+	name := backendName(cntName)
+	args := []string{"init", alias, name}
+	for _, kv := range lxdConfig(containerConfig, hostConfig, env, uid, gid) {
+		args = append(args, "-c", kv)
+	}
+	_, err = b.run(ctx, args...)
+	if err != nil {
+		return err
+	}
+
+	devices, skipped := lxdDevices(hostConfig)
+	for _, s := range skipped {
+		xlog.Warn("%v isn't supported by %v containers, it's skipped", s, b.cli)
+	}
+	for _, d := range devices {
+		_, err = b.run(ctx, append([]string{"config", "device", "add", name}, d...)...)
+		if err != nil {
+			return err
+		}
+	}
+
+	f, err := ioutil.TempFile("", "sail-init")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(lxdInitScript(containerConfig.Cmd))
+	f.Close()
+	if err != nil {
+		return err
+	}
+	_, err = b.run(ctx, "file", "push", "--create-dirs", "--mode", "0700", "--uid", uid, "--gid", gid, f.Name(), name+lxdInitPath)
+	if err != nil {
+		return err
+	}
+	return saveExternalEnv(cntName, newExternalEnv(b.name(), containerConfig, uid, gid))
+}
+
+// name returns the backend's name in the config.
+func (b lxdBackend) name() string {
+	if b.cli == "incus" {
+		return backendIncus
+	}
+	return backendLXD
+}
+
+func (b lxdBackend) start(ctx context.Context, cntName string) error {
+	_, err := b.run(ctx, "start", backendName(cntName))
+	return err
+}
+
+func (b lxdBackend) remove(ctx context.Context, cntName string) error {
+	exists, _, err := b.status(ctx, cntName)
+	if err != nil {
+		return err
+	}
+	if exists {
+		_, err = b.run(ctx, "delete", "--force", backendName(cntName))
+		if err != nil {
+			return err
+		}
+	}
+	return removeExternalEnv(cntName)
+}
+
+func (b lxdBackend) stop(ctx context.Context, cntName string) error {
+	_, err := b.run(ctx, "stop", "--timeout", "10", backendName(cntName))
+	if err != nil {
+		xlog.Debug("%v, forcing it", err)
+		_, err = b.run(ctx, "stop", "--force", backendName(cntName))
+	}
+	return err
+}
+
+func (b lxdBackend) shell(cntName string, env externalEnv, dir string) (*exec.Cmd, error) {
+	return exec.Command(b.cli, "exec",
+		"--user", env.UID, "--group", env.GID, "--cwd", dir,
+		backendName(cntName), "--", "sh", "-c", externalShellScript,
+	), nil
+}
+
+func (b lxdBackend) status(ctx context.Context, cntName string) (bool, bool, error) {
+	name := backendName(cntName)
+	out, err := b.run(ctx, "list", "--format", "csv", "-c", "ns", "^"+name+"$")
+	if err != nil {
+		return false, false, err
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.SplitN(strings.TrimSpace(line), ",", 2)
+		if len(fields) == 2 && fields[0] == name {
+			return true, fields[1] == lxdRunning, nil
+		}
+	}
+	return false, false, nil
+}
+
+// importImage imports the Docker image into LXD, unless it was already,
+// returning its alias and the environment of its config.
+func (b lxdBackend) importImage(ctx context.Context, image string) (string, []string, error) {
+	cli := dockerClient()
+	defer cli.Close()
+
+	img, _, err := cli.ImageInspectWithRaw(ctx, image)
+	if err != nil {
+		return "", nil, xerrors.Errorf("failed to inspect %v: %w", image, err)
+	}
+	var env []string
+	if img.Config != nil {
+		env = img.Config.Env
+	}
+	// Aliases are keyed by the image's ID, so rebuilt images are imported
+	// again.
+	alias := "sail-" + strings.TrimPrefix(img.ID, "sha256:")
+	if len(alias) > 17 {
+		alias = alias[:17]
+	}
+	_, err = b.run(ctx, "image", "info", alias)
+	if err == nil {
+		return alias, env, nil
+	}
+
+	xlog.Info("importing %v into %v", image, b.cli)
+	dir, err := ioutil.TempDir("", "sail-lxd")
+	if err != nil {
+		return "", nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	rootfs := filepath.Join(dir, "rootfs.tar")
+	err = exportRootfs(ctx, image, rootfs)
+	if err != nil {
+		return "", nil, err
+	}
+	meta := filepath.Join(dir, "metadata.tar.gz")
+	err = writeLXDMetadata(meta, lxdMetadata(image, lxdArch(img.Architecture), time.Now()))
+	if err != nil {
+		return "", nil, err
+	}
+	_, err = b.run(ctx, "image", "import", meta, rootfs, "--alias", alias)
+	if err != nil {
+		return "", nil, err
+	}
+	return alias, env, nil
+}
+
+// exportRootfs writes the filesystem of image to the tarball path.
+func exportRootfs(ctx context.Context, image, path string) error {
+	cli := dockerClient()
+	defer cli.Close()
+
+	// The container is never started, so its command needn't exist.
+	cnt, err := cli.ContainerCreate(ctx, &container.Config{
+		Image:      image,
+		Entrypoint: []string{"true"},
+	}, nil, nil, "")
+	if err != nil {
+		return xerrors.Errorf("failed to create container of %v: %w", image, err)
+	}
+	defer cli.ContainerRemove(context.Background(), cnt.ID, types.ContainerRemoveOptions{Force: true})
+
+	rc, err := cli.ContainerExport(ctx, cnt.ID)
+	if err != nil {
+		return xerrors.Errorf("failed to export %v: %w", image, err)
+	}
+	defer rc.Close()
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(f, rc)
+	if err != nil {
+		return xerrors.Errorf("failed to export %v: %w", image, err)
+	}
+	return f.Close()
+}
+
+// lxdArch returns the LXD architecture of the Docker architecture arch.
+func lxdArch(arch string) string {
+	switch arch {
+	case "amd64":
+		return "x86_64"
+	case "arm64":
+		return "aarch64"
+	case "arm":
+		return "armv7l"
+	default:
+		return arch
+	}
+}
+
+// lxdMetadata returns the metadata.yaml of the LXD image imported from the
+// Docker image, created at t.
+func lxdMetadata(image, arch string, t time.Time) string {
+	return fmt.Sprintf("architecture: %v\ncreation_date: %v\nproperties:\n  description: %q\n", arch, t.Unix(), "sail image of "+image)
+}
+
+// writeLXDMetadata writes the metadata tarball of an LXD image, with its
+// metadata.yaml, meta, to path.
+func writeLXDMetadata(path, meta string) error {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	err := tw.WriteHeader(&tar.Header{
+		Name:    "metadata.yaml",
+		Mode:    0644,
+		Size:    int64(len(meta)),
+		ModTime: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = tw.Write([]byte(meta))
+	if err != nil {
+		return err
+	}
+	err = tw.Close()
+	if err != nil {
+		return err
+	}
+	err = gw.Close()
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, buf.Bytes(), 0644)
+}
+
+// imageUser returns the uid and gid of user in image, or of the image's user
+// if it's empty. LXD runs the init process with numeric ids.
+func imageUser(ctx context.Context, image, user string) (string, string, error) {
+	args := []string{"run", "--rm", "--entrypoint", "sh"}
+	if user != "" {
+		args = append(args, "--user", user)
+	}
+	args = append(args, image, "-c", "id -u; id -g")
+	out, err := exec.CommandContext(ctx, "docker", args...).CombinedOutput()
+	if err != nil {
+		return "", "", xerrors.Errorf("failed to find the user of %v: %s: %w", image, bytes.TrimSpace(out), err)
+	}
+	ids := strings.Fields(string(out))
+	if len(ids) != 2 {
+		return "", "", xerrors.Errorf("failed to find the user of %v: unexpected output %q", image, out)
+	}
+	return ids[0], ids[1], nil
+}
+
+// lxdConfig returns the config keys of the LXD container of the Docker spec,
+// whose user has the ids uid and gid and whose environment is env. The host
+// user is mapped to the container's user, so it owns the files of bind mounts
+// like in Docker. Labels are kept in user keys. code-server is the init
+// process, so it's stopped with SIGTERM like in Docker.
+func lxdConfig(containerConfig *container.Config, hostConfig *container.HostConfig, env []string, uid, gid string) []string {
+	config := []string{
+		fmt.Sprintf("raw.idmap=uid %v %v\ngid %v %v", os.Getuid(), uid, os.Getgid(), gid),
+		fmt.Sprintf("raw.lxc=lxc.init.cmd = %v\nlxc.init.uid = %v\nlxc.init.gid = %v\nlxc.signal.halt = SIGTERM", lxdInitPath, uid, gid),
+	}
+	if hostConfig.Memory > 0 {
+		config = append(config, "limits.memory="+strconv.FormatInt(hostConfig.Memory, 10)+"B")
+	}
+	for _, k := range sortedKeys(containerConfig.Labels) {
+		config = append(config, "user."+k+"="+containerConfig.Labels[k])
+	}
+	// LXD sets them for the init process and lxc exec.
+	for _, kv := range env {
+		config = append(config, "environment."+kv)
+	}
+	return config
+}
+
+// lxdDevices returns the arguments of the LXD devices of the mounts and
+// published ports of hostConfig, and descriptions of those that can't be
+// added. Only host paths can be mounted, LXD doesn't know Docker's volumes.
+func lxdDevices(hostConfig *container.HostConfig) (devices [][]string, skipped []string) {
+	for i, m := range hostConfig.Mounts {
+		if m.Type != mount.TypeBind {
+			skipped = append(skipped, fmt.Sprintf("the volume %v mounted at %v", m.Source, m.Target))
+			continue
+		}
+		d := []string{fmt.Sprintf("mount%v", i), "disk", "source=" + m.Source, "path=" + m.Target}
+		if m.ReadOnly {
+			d = append(d, "readonly=true")
+		}
+		devices = append(devices, d)
+	}
+
+	ports := make([]nat.Port, 0, len(hostConfig.PortBindings))
+	for p := range hostConfig.PortBindings {
+		ports = append(ports, p)
+	}
+	sort.Slice(ports, func(i, j int) bool { return ports[i] < ports[j] })
+	for _, p := range ports {
+		for _, binding := range hostConfig.PortBindings[p] {
+			ip := binding.HostIP
+			if ip == "" {
+				ip = "127.0.0.1"
+			}
+			devices = append(devices, []string{
+				"port" + binding.HostPort, "proxy",
+				"listen=tcp:" + ip + ":" + binding.HostPort,
+				"connect=tcp:127.0.0.1:" + p.Port(),
+			})
+		}
+	}
+
+	for _, d := range hostConfig.Devices {
+		skipped = append(skipped, "the device "+d.PathOnHost)
+	}
+	return devices, skipped
+}
+
+// lxdInitScript returns the init process of LXD containers, which runs cmd.
+// Their environment is in their config, see lxdConfig.
+func lxdInitScript(cmd []string) string {
+	var b strings.Builder
+	b.WriteString("#!/bin/sh\n")
+	quoted := make([]string, len(cmd))
+	for i, arg := range cmd {
+		quoted[i] = xexec.Quote(arg)
+	}
+	b.WriteString("exec " + strings.Join(quoted, " ") + "\n")
+	return b.String()
+}
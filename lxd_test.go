@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/go-connections/nat"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_backendName(t *testing.T) {
+	assert.Equal(t, "sail-cdr-sail-js", backendName("cdr_sail.js"))
+
+	long := backendName("cdr_" + fmt.Sprintf("%070d", 0))
+	assert.Len(t, long, 63)
+}
+
+func Test_lxdDevices(t *testing.T) {
+	_, bindings, err := nat.ParsePortSpecs([]string{"127.0.0.1:8000:8443/tcp"})
+	require.NoError(t, err)
+
+	devices, skipped := lxdDevices(&container.HostConfig{
+		Mounts: []mount.Mount{
+			{Type: mount.TypeBind, Source: "/home/user/Projects/cdr/sail", Target: "/home/user/sail"},
+			{Type: mount.TypeVolume, Source: "sail-shared-go", Target: "/home/user/go"},
+			{Type: mount.TypeBind, Source: "/usr/bin/code-server", Target: "/usr/bin/code-server", ReadOnly: true},
+		},
+		PortBindings: bindings,
+		Resources: container.Resources{
+			Devices: []container.DeviceMapping{{PathOnHost: "/dev/kvm"}},
+		},
+	})
+	assert.Equal(t, [][]string{
+		{"mount0", "disk", "source=/home/user/Projects/cdr/sail", "path=/home/user/sail"},
+		{"mount2", "disk", "source=/usr/bin/code-server", "path=/usr/bin/code-server", "readonly=true"},
+		{"port8000", "proxy", "listen=tcp:127.0.0.1:8000", "connect=tcp:127.0.0.1:8443"},
+	}, devices)
+	assert.Equal(t, []string{"the volume sail-shared-go mounted at /home/user/go", "the device /dev/kvm"}, skipped)
+}
+
+func Test_lxdConfig(t *testing.T) {
+	config := lxdConfig(&container.Config{
+		Env:    []string{"GOFLAGS=-mod=vendor"},
+		Labels: map[string]string{nameLabel: "cdr/sail", sailLabel: ""},
+	}, &container.HostConfig{
+		Resources: container.Resources{Memory: 1 << 30},
+	}, []string{"HOME=/home/user", "GOFLAGS=-mod=vendor"}, "1000", "1001")
+
+	assert.Equal(t, []string{
+		fmt.Sprintf("raw.idmap=uid %v 1000\ngid %v 1001", os.Getuid(), os.Getgid()),
+		"raw.lxc=lxc.init.cmd = " + lxdInitPath + "\nlxc.init.uid = 1000\nlxc.init.gid = 1001\nlxc.signal.halt = SIGTERM",
+		"limits.memory=1073741824B",
+		"user.com.coder.sail=",
+		"user.com.coder.sail.name=cdr/sail",
+		"environment.HOME=/home/user",
+		"environment.GOFLAGS=-mod=vendor",
+	}, config)
+}
+
+func Test_lxdInitScript(t *testing.T) {
+	script := lxdInitScript([]string{"bash", "-c", `echo "$1"`, "x y"})
+	assert.Equal(t, "#!/bin/sh\nexec 'bash' '-c' 'echo \"$1\"' 'x y'\n", script)
+}
+
+func Test_lxdMetadata(t *testing.T) {
+	assert.Equal(t, "x86_64", lxdArch("amd64"))
+	assert.Equal(t, "ppc64le", lxdArch("ppc64le"))
+	assert.Contains(t, lxdMetadata("codercom/ubuntu-dev", "aarch64", time.Unix(0, 0)), "architecture: aarch64\ncreation_date: 0\n")
+}
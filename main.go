@@ -9,6 +9,7 @@ import (
 	"github.com/posener/complete"
 
 	"go.coder.com/cli"
+	"go.coder.com/sail/internal/xlog"
 )
 
 // Dedicated to nhooyr_software.
@@ -46,7 +47,8 @@ func (r *rootCmd) Run(fl *flag.FlagSet) {
 }
 
 func (r *rootCmd) RegisterFlags(fl *flag.FlagSet) {
-	fl.BoolVar(&r.verbose, "v", false, "Enable debug logging.")
+	fl.Var(verboseFlag{&r.verbose}, "v", "Enable debug logging.")
+	fl.Var(verboseFlag{&r.verbose}, "verbose", "Enable debug logging.")
 	fl.StringVar(&r.configPath, "config",
 		filepath.Join(metaRoot(), "sail.toml"),
 		"Path to config.",
@@ -62,11 +64,46 @@ func (r rootCmd) Subcommands() []cli.Command {
 
 	return []cli.Command{
 		&runcmd{gf: &r.globalFlags},
+		&upcmd{gf: &r.globalFlags},
+		&buildcmd{gf: &r.globalFlags},
+		&prebuildcmd{gf: &r.globalFlags},
+		&outdatedcmd{gf: &r.globalFlags},
+		&upgradecmd{gf: &r.globalFlags},
+		&lockcmd{gf: &r.globalFlags},
+		&logincmd{gf: &r.globalFlags},
 		&shellcmd{gf: &r.globalFlags},
+		&opencmd{gf: &r.globalFlags},
+		&sharecmd{gf: &r.globalFlags},
 		&editcmd{gf: &r.globalFlags},
-		&lscmd{},
+		&clonecmd{gf: &r.globalFlags},
+		&reviewcmd{gf: &r.globalFlags},
+		&diffcmd{gf: &r.globalFlags},
+		&applycmd{gf: &r.globalFlags},
+		&synccmd{gf: &r.globalFlags},
+		&lscmd{gf: &r.globalFlags},
+		&uicmd{gf: &r.globalFlags},
+		&diskcmd{gf: &r.globalFlags},
+		&prunecmd{gf: &r.globalFlags},
 		&rmcmd{gf: &r.globalFlags},
+		&restartcmd{gf: &r.globalFlags},
+		&mountcmd{gf: &r.globalFlags},
+		&envcmd{gf: &r.globalFlags},
+		&selfupdatecmd{gf: &r.globalFlags},
+		&telemetrycmd{},
+		&bugcmd{gf: &r.globalFlags},
+		&logscmd{gf: &r.globalFlags},
+		&explaincmd{gf: &r.globalFlags},
+		&statuscmd{gf: &r.globalFlags},
+		&doctorcmd{gf: &r.globalFlags},
+		&fsckcmd{gf: &r.globalFlags},
+		&exportcmd{gf: &r.globalFlags},
+		&exportenvcmd{gf: &r.globalFlags},
+		&importenvcmd{gf: &r.globalFlags},
+		&adoptcmd{gf: &r.globalFlags},
 		&proxycmd{},
+		&daemoncmd{gf: &r.globalFlags},
+		&eventscmd{gf: &r.globalFlags},
+		&completioncmd{},
 		extHostCmd,
 		&chromeExtInstallCmd{cmd: extHostCmd},
 		&versioncmd{},
@@ -83,6 +120,27 @@ func main() {
 		return
 	}
 
+	// Shells invoke sail with the words around the cursor as arguments when
+	// completing, so they mustn't be routed to subcommands.
+	if os.Getenv("COMP_LINE") != "" {
+		root.handleAutocomplete()
+		return
+	}
+
+	name := commandName(os.Args[1:], root.Subcommands())
+	if name != "" {
+		setupSelfLog(name)
+
+		err := migrateMetaRoot(metaRoot())
+		if err != nil {
+			xlog.Error("failed to migrate sail's state: %v", err)
+		}
+	}
+	// The background sender and the proxy aren't run by users.
+	if name != "" && name != "telemetry send" && name != "proxy" {
+		recordTelemetry(telemetryEvent{Type: telemetryCommand, Command: name})
+	}
+
 	cli.RunRoot(root)
 }
 
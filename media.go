@@ -0,0 +1,114 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"syscall"
+
+	"golang.org/x/xerrors"
+)
+
+// Media devices of the media config key and of sail run --audio and
+// --webcam.
+const (
+	mediaAudio  = "audio"
+	mediaWebcam = "webcam"
+)
+
+// Image labels passing the host's media devices through. The audio and webcam
+// labels on containers record that they were.
+const (
+	audioLabel  = sailLabel + ".audio"
+	webcamLabel = sailLabel + ".webcam"
+)
+
+// mediaOf returns the media devices the media config key passes through to
+// the project name.
+func (c config) mediaOf(name string) (audio, webcam bool) {
+	for _, m := range c.Media[name] {
+		switch m {
+		case mediaAudio:
+			audio = true
+		case mediaWebcam:
+			webcam = true
+		}
+	}
+	return audio, webcam
+}
+
+// checkMedia validates the media config key.
+func checkMedia(media map[string][]string) error {
+	projects := make([]string, 0, len(media))
+	for project := range media {
+		projects = append(projects, project)
+	}
+	sort.Strings(projects)
+	for _, project := range projects {
+		for _, m := range media[project] {
+			if m != mediaAudio && m != mediaWebcam {
+				return xerrors.Errorf("invalid media %q of %v, must be %v or %v", m, project, mediaAudio, mediaWebcam)
+			}
+		}
+	}
+	return nil
+}
+
+// mediaDevices returns the host's sound devices if audio, and its video
+// devices if webcam, as accepted by parseDevice, and the gids owning them.
+// The container's user is added to the groups, so it can use the devices
+// without the container running privileged.
+func mediaDevices(root string, audio, webcam bool) (devices, gids []string, _ error) {
+	if !audio && !webcam {
+		return nil, nil, nil
+	}
+	if runtime.GOOS != "linux" {
+		return nil, nil, xerrors.New("audio and webcam devices can only be passed through on Linux hosts")
+	}
+
+	var paths []string
+	if audio {
+		snd, err := filepath.Glob(filepath.Join(root, "snd", "*"))
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(snd) == 0 {
+			return nil, nil, xerrors.Errorf("the host has no sound devices in %v", filepath.Join(root, "snd"))
+		}
+		// The directory is passed through with the devices in it.
+		devices = append(devices, filepath.Join(root, "snd"))
+		paths = append(paths, snd...)
+	}
+	if webcam {
+		video, err := filepath.Glob(filepath.Join(root, "video*"))
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(video) == 0 {
+			return nil, nil, xerrors.Errorf("the host has no video devices in %v", root)
+		}
+		devices = append(devices, video...)
+		paths = append(paths, video...)
+	}
+
+	seen := make(map[string]bool)
+	for _, p := range paths {
+		fi, err := os.Stat(p)
+		if err != nil {
+			return nil, nil, xerrors.Errorf("failed to stat %v: %w", p, err)
+		}
+		st, ok := fi.Sys().(*syscall.Stat_t)
+		if !ok || st.Gid == 0 {
+			continue
+		}
+		gid := strconv.FormatUint(uint64(st.Gid), 10)
+		if !seen[gid] {
+			seen[gid] = true
+			gids = append(gids, gid)
+		}
+	}
+	sort.Strings(gids)
+	return devices, gids, nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_mediaOf(t *testing.T) {
+	conf := config{Media: map[string][]string{"cdr/player": {mediaAudio, mediaWebcam}}}
+	require.NoError(t, checkMedia(conf.Media))
+	audio, webcam := conf.mediaOf("cdr/player")
+	assert.True(t, audio)
+	assert.True(t, webcam)
+	audio, webcam = conf.mediaOf("cdr/sail")
+	assert.False(t, audio || webcam)
+
+	require.Error(t, checkMedia(map[string][]string{"cdr/player": {"mic"}}))
+}
+
+func Test_mediaDevices(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("media devices are only passed through on Linux")
+	}
+
+	root, err := ioutil.TempDir("", "sail-dev")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	devs, _, err := mediaDevices(root, false, false)
+	require.NoError(t, err)
+	assert.Empty(t, devs)
+	_, _, err = mediaDevices(root, true, false)
+	require.Error(t, err)
+	_, _, err = mediaDevices(root, false, true)
+	require.Error(t, err)
+
+	require.NoError(t, os.Mkdir(filepath.Join(root, "snd"), 0755))
+	for _, p := range []string{"snd/pcmC0D0p", "snd/controlC0", "video0", "video1"} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(root, p), nil, 0644))
+	}
+	devs, gids, err := mediaDevices(root, true, true)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		filepath.Join(root, "snd"),
+		filepath.Join(root, "video0"),
+		filepath.Join(root, "video1"),
+	}, devs)
+	// The devices are owned by the test's group, unless it's root's.
+	if os.Getgid() != 0 {
+		assert.Len(t, gids, 1)
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"golang.org/x/xerrors"
+
+	"go.coder.com/sail/internal/dockutil"
+	"go.coder.com/sail/internal/xlog"
+)
+
+// envStats is the resource usage of an environment.
+type envStats struct {
+	name    string
+	cntName string
+	running bool
+	// cpuSeconds is the CPU time used since the container started.
+	cpuSeconds  float64
+	memory      uint64
+	memoryLimit uint64
+	// uptime is how long code-server has been running. It's zero if it
+	// isn't, e.g. while it's restarted.
+	uptime time.Duration
+	disk   diskUsage
+}
+
+// collectStats gathers the resource usage of the environments of all docker
+// contexts. Stats of running containers are fetched in parallel.
+func (gf *globalFlags) collectStats(ctx context.Context) ([]envStats, error) {
+	var all []envStats
+	for i, name := range gf.dockerContexts() {
+		stats, err := gf.collectContextStats(ctx, name)
+		if err != nil {
+			if i == 0 {
+				return nil, err
+			}
+			xlog.Error("failed to get the stats of docker context %v: %v", name, err)
+			continue
+		}
+		all = append(all, stats...)
+	}
+	return all, nil
+}
+
+// collectContextStats gathers the resource usage of the environments of the
+// docker context name.
+func (gf *globalFlags) collectContextStats(ctx context.Context, name string) ([]envStats, error) {
+	cli, err := gf.dockerContextClient(name)
+	if err != nil {
+		return nil, err
+	}
+	defer cli.Close()
+
+	infos, err := listProjectsOf(ctx, cli)
+	if err != nil {
+		return nil, err
+	}
+
+	samples := sampleStats(ctx, cli, infos)
+	stats := make([]envStats, len(infos))
+	var wg sync.WaitGroup
+	for i, info := range infos {
+		stats[i] = envStats{
+			name:    info.name,
+			cntName: info.cntName,
+			running: info.running,
+		}
+		if st, ok := samples[info.cntName]; ok {
+			stats[i].cpuSeconds = float64(st.CPUStats.CPUUsage.TotalUsage) / float64(time.Second)
+			stats[i].memory = rssUsage(st)
+			stats[i].memoryLimit = st.MemoryStats.Limit
+		}
+		if !info.running {
+			continue
+		}
+
+		wg.Add(1)
+		go func(s *envStats) {
+			defer wg.Done()
+			uptime, err := codeServerUptime(gf.dockerContextEnv(name), s.cntName)
+			if err != nil {
+				xlog.Debug("failed to get the uptime of code-server in %v: %v", s.name, err)
+				return
+			}
+			s.uptime = uptime
+		}(&stats[i])
+	}
+	wg.Wait()
+
+	usages, err := diskUsagesOf(ctx, cli)
+	if err != nil {
+		xlog.Error("failed to get disk usage: %v", err)
+	}
+	for _, u := range usages {
+		for i := range stats {
+			if stats[i].name == u.name {
+				stats[i].disk = u
+			}
+		}
+	}
+	return stats, nil
+}
+
+// codeServerProcess matches the command line of the code-server process the
+// container's init process runs.
+const codeServerProcess = "^/usr/bin/code-server"
+
+// codeServerUptime returns how long the code-server process of the container
+// cntName has been running. It's restarted in place by sail restart, so the
+// container may have been running for longer. The docker CLI runs with the
+// environment env, see dockerContextEnv.
+func codeServerUptime(env []string, cntName string) (time.Duration, error) {
+	cmd := dockutil.FmtExec(cntName, `set -eu
+pid=$(pgrep -o -f '%v')
+ps -o etimes= -p "$pid"`, codeServerProcess)
+	cmd.Env = env
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return 0, xerrors.Errorf("%s: %w", bytes.TrimSpace(out), err)
+	}
+	secs, err := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+	if err != nil {
+		return 0, xerrors.Errorf("failed to parse the elapsed time %q: %w", out, err)
+	}
+	return time.Duration(secs) * time.Second, nil
+}
+
+// sampleStats gets a sample of the stats of the running projects of infos in
+// parallel, by container name. Projects whose stats can't be fetched, e.g.
+// because ctx expired, are left out.
+func sampleStats(ctx context.Context, cli *client.Client, infos []projectInfo) map[string]types.StatsJSON {
+	var (
+		mu    sync.Mutex
+		wg    sync.WaitGroup
+		stats = make(map[string]types.StatsJSON, len(infos))
+	)
+	for _, info := range infos {
+		if !info.running {
+			continue
+		}
+		wg.Add(1)
+		go func(cntName string) {
+			defer wg.Done()
+			st, err := containerStats(ctx, cli, cntName)
+			if err != nil {
+				xlog.Error("failed to get stats of %v: %v", cntName, err)
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			stats[cntName] = st
+		}(info.cntName)
+	}
+	wg.Wait()
+	return stats
+}
+
+// containerStats returns a single sample of the container's stats.
+func containerStats(ctx context.Context, cli *client.Client, cntName string) (types.StatsJSON, error) {
+	var st types.StatsJSON
+
+	resp, err := cli.ContainerStats(ctx, cntName, false)
+	if err != nil {
+		return st, xerrors.Errorf("failed to get stats: %w", err)
+	}
+	defer resp.Body.Close()
+
+	err = json.NewDecoder(resp.Body).Decode(&st)
+	if err != nil {
+		return st, xerrors.Errorf("failed to decode stats: %w", err)
+	}
+	return st, nil
+}
+
+// memoryUsage returns the memory used by the container, excluding the page
+// cache like docker stats does.
+func memoryUsage(st types.StatsJSON) uint64 {
+	cache := st.MemoryStats.Stats["cache"]
+	if cache > st.MemoryStats.Usage {
+		return 0
+	}
+	return st.MemoryStats.Usage - cache
+}
+
+// metricsHandler serves the usage of environments in the Prometheus text format.
+func metricsHandler(gf *globalFlags) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), time.Second*30)
+		defer cancel()
+
+		stats, err := gf.collectStats(ctx)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		writeMetrics(w, stats)
+	}
+}
+
+// writeMetrics writes stats in the Prometheus text format.
+func writeMetrics(w io.Writer, stats []envStats) {
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].name < stats[j].name
+	})
+
+	metric := func(name, typ, help string, value func(envStats) (float64, bool)) {
+		fmt.Fprintf(w, "# HELP %v %v\n", name, help)
+		fmt.Fprintf(w, "# TYPE %v %v\n", name, typ)
+		for _, s := range stats {
+			v, ok := value(s)
+			if !ok {
+				continue
+			}
+			fmt.Fprintf(w, "%v{project=%v,container=%v} %v\n", name, quoteLabel(s.name), quoteLabel(s.cntName), v)
+		}
+	}
+	running := func(f func(envStats) float64) func(envStats) (float64, bool) {
+		return func(s envStats) (float64, bool) {
+			return f(s), s.running
+		}
+	}
+	always := func(f func(envStats) float64) func(envStats) (float64, bool) {
+		return func(s envStats) (float64, bool) {
+			return f(s), true
+		}
+	}
+
+	metric("sail_environment_running", "gauge", "Whether the environment is running.", always(func(s envStats) float64 {
+		if s.running {
+			return 1
+		}
+		return 0
+	}))
+	metric("sail_environment_cpu_seconds_total", "counter", "CPU time used by the environment since it started.", running(func(s envStats) float64 {
+		return s.cpuSeconds
+	}))
+	metric("sail_environment_memory_bytes", "gauge", "Resident memory used by the environment, like sail ls --stats shows.", running(func(s envStats) float64 {
+		return float64(s.memory)
+	}))
+	metric("sail_environment_memory_limit_bytes", "gauge", "Memory limit of the environment.", running(func(s envStats) float64 {
+		return float64(s.memoryLimit)
+	}))
+	metric("sail_environment_writable_layer_bytes", "gauge", "Size of the environment container's writable layer.", always(func(s envStats) float64 {
+		return float64(s.disk.writable)
+	}))
+	metric("sail_environment_storage_bytes", "gauge", "Size of the environment's storage on the host, e.g. extensions and settings.", always(func(s envStats) float64 {
+		return float64(s.disk.storage)
+	}))
+	metric("sail_code_server_uptime_seconds", "gauge", "How long code-server has been running.", func(s envStats) (float64, bool) {
+		return s.uptime.Seconds(), s.running && s.uptime > 0
+	})
+}
+
+// quoteLabel quotes a Prometheus label value.
+func quoteLabel(v string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v) + `"`
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_writeMetrics(t *testing.T) {
+	var buf bytes.Buffer
+	writeMetrics(&buf, []envStats{
+		{
+			name:        "cdr/sail",
+			cntName:     "cdr_sail",
+			running:     true,
+			cpuSeconds:  1.5,
+			memory:      1024,
+			memoryLimit: 4096,
+			uptime:      time.Minute,
+			disk:        diskUsage{writable: 10, storage: 20},
+		},
+		{
+			name:    `cdr/"code-server"`,
+			cntName: "cdr_code-server",
+		},
+		{
+			name:    "cdr/api",
+			cntName: "cdr_api",
+			running: true,
+		},
+	})
+
+	out := buf.String()
+	require.Contains(t, out, "# TYPE sail_environment_cpu_seconds_total counter\n")
+	require.Contains(t, out, `sail_environment_cpu_seconds_total{project="cdr/sail",container="cdr_sail"} 1.5`+"\n")
+	require.Contains(t, out, `sail_code_server_uptime_seconds{project="cdr/sail",container="cdr_sail"} 60`+"\n")
+	require.Contains(t, out, `sail_environment_running{project="cdr/\"code-server\"",container="cdr_code-server"} 0`+"\n")
+	require.NotContains(t, out, `sail_environment_memory_bytes{project="cdr/\"code-server\""`, "stopped environments have no memory usage")
+	require.NotContains(t, out, `sail_code_server_uptime_seconds{project="cdr/api"`, "code-server isn't running")
+}
+
+func Test_memoryUsage(t *testing.T) {
+	var st types.StatsJSON
+	st.MemoryStats.Usage = 100
+	st.MemoryStats.Stats = map[string]uint64{"cache": 40}
+	require.Equal(t, uint64(60), memoryUsage(st))
+}
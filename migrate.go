@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"golang.org/x/xerrors"
+
+	"go.coder.com/sail/internal/dockutil"
+	"go.coder.com/sail/internal/flock"
+	"go.coder.com/sail/internal/xlog"
+)
+
+// stateSchema is the version of the schema of sail's labels and metadata
+// root. Changes to either that break older environments bump it, with a
+// migration in stateMigrations.
+const stateSchema = 2
+
+// schemaFile holds the schema version of the metadata root. Roots without it
+// are version 1.
+const schemaFile = "schema"
+
+// stateMigration migrates sail's state from the previous schema version.
+type stateMigration struct {
+	// version is the schema version the migration migrates to.
+	version int
+	// labels migrates the labels of a container in place. Docker can't
+	// change the labels of containers, so they're migrated on every read.
+	labels func(labels map[string]string)
+	// meta migrates the metadata root.
+	meta func(root string) error
+}
+
+// stateMigrations are applied in order to state of older versions.
+var stateMigrations = []stateMigration{
+	{
+		version: 2,
+		// Project directories were relative to the guest user's home when the
+		// image's project_root was.
+		labels: func(labels map[string]string) {
+			if dir := labels[projectDirLabel]; dir != "" {
+				labels[projectDirLabel] = resolvePath(guestHome(labels), dir)
+			}
+		},
+	},
+}
+
+// parseSchema parses the schema version v, which is 1 if it's empty.
+func parseSchema(v string) (int, error) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 1, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 {
+		return 0, xerrors.Errorf("invalid schema version %q", v)
+	}
+	return n, nil
+}
+
+// migrateLabels migrates the labels of a sail container to the current
+// schema. Labels of containers created by newer versions of sail are left
+// as they are.
+func migrateLabels(labels map[string]string) error {
+	if _, ok := labels[sailLabel]; !ok {
+		return nil
+	}
+	version, err := parseSchema(labels[schemaLabel])
+	if err != nil {
+		return err
+	}
+	if version > stateSchema {
+		return errNewerSchema
+	}
+	for _, m := range stateMigrations {
+		if m.version <= version || m.labels == nil {
+			continue
+		}
+		m.labels(labels)
+	}
+	labels[schemaLabel] = strconv.Itoa(stateSchema)
+	return nil
+}
+
+var errNewerSchema = xerrors.New("created by a newer version of sail, update it with sail self-update")
+
+var warnedSchemas sync.Map
+
+// migrateContainerLabels migrates the labels of the container name, warning
+// once about those it can't.
+func migrateContainerLabels(name string, labels map[string]string) {
+	err := migrateLabels(labels)
+	if err == nil {
+		return
+	}
+	if _, warned := warnedSchemas.LoadOrStore(name, true); !warned {
+		xlog.Warn("%v: %v", name, err)
+	}
+}
+
+// inspectContainer inspects the container named cntName like
+// dockutil.ContainerInspect, and migrates its labels to the current schema.
+func inspectContainer(ctx context.Context, cli *client.Client, cntName string) (types.ContainerJSON, error) {
+	cnt, err := dockutil.ContainerInspect(ctx, cli, cntName)
+	if err != nil {
+		return cnt, err
+	}
+	if cnt.Config != nil {
+		migrateContainerLabels(cntName, cnt.Config.Labels)
+	}
+	return cnt, nil
+}
+
+// migrateMetaRoot migrates the metadata root to the current schema.
+func migrateMetaRoot(root string) error {
+	path := filepath.Join(root, schemaFile)
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		_, err = os.Stat(root)
+		if os.IsNotExist(err) {
+			// Nothing to migrate in a new root.
+			return writeSchema(path)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	version, err := parseSchema(string(b))
+	if err != nil {
+		return xerrors.Errorf("%v: %w", path, err)
+	}
+	if version >= stateSchema {
+		return nil
+	}
+
+	// Concurrent invocations wait for the first to migrate.
+	l, err := flock.New(filepath.Join(root, "locks", "schema.lock"))
+	if err != nil {
+		return err
+	}
+	defer l.Unlock()
+	b, err = ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	version, err = parseSchema(string(b))
+	if err != nil {
+		return xerrors.Errorf("%v: %w", path, err)
+	}
+
+	for _, m := range stateMigrations {
+		if m.version <= version || m.meta == nil {
+			continue
+		}
+		xlog.Debug("migrating %v to schema version %v", root, m.version)
+		err = m.meta(root)
+		if err != nil {
+			return xerrors.Errorf("failed to migrate %v to schema version %v: %w", root, m.version, err)
+		}
+	}
+	return writeSchema(path)
+}
+
+func writeSchema(path string) error {
+	err := os.MkdirAll(filepath.Dir(path), 0750)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, []byte(strconv.Itoa(stateSchema)+"\n"), 0640)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_migrateLabels(t *testing.T) {
+	labels := map[string]string{
+		sailLabel:       "",
+		guestHomeLabel:  "/home/dev",
+		projectDirLabel: "~/go/src/sail",
+	}
+	require.NoError(t, migrateLabels(labels))
+	assert.Equal(t, "/home/dev/go/src/sail", labels[projectDirLabel])
+	assert.Equal(t, "2", labels[schemaLabel])
+
+	labels = map[string]string{
+		sailLabel:       "",
+		schemaLabel:     "3",
+		projectDirLabel: "~/sail",
+	}
+	require.Equal(t, errNewerSchema, migrateLabels(labels))
+	assert.Equal(t, "~/sail", labels[projectDirLabel])
+
+	// Other containers aren't sail's.
+	labels = map[string]string{projectDirLabel: "~/sail"}
+	require.NoError(t, migrateLabels(labels))
+	assert.Equal(t, map[string]string{projectDirLabel: "~/sail"}, labels)
+}
+
+func Test_migrateMetaRoot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sail-migrate")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var migrated []string
+	defer func(migrations []stateMigration) {
+		stateMigrations = migrations
+	}(stateMigrations)
+	stateMigrations = []stateMigration{{
+		version: 2,
+		meta: func(root string) error {
+			migrated = append(migrated, root)
+			return nil
+		},
+	}}
+
+	// New roots are created with the current schema.
+	root := filepath.Join(dir, "new")
+	require.NoError(t, migrateMetaRoot(root))
+	assert.Empty(t, migrated)
+
+	root = filepath.Join(dir, "old")
+	require.NoError(t, os.MkdirAll(root, 0750))
+	require.NoError(t, migrateMetaRoot(root))
+	assert.Equal(t, []string{root}, migrated)
+	b, err := ioutil.ReadFile(filepath.Join(root, schemaFile))
+	require.NoError(t, err)
+	assert.Equal(t, "2\n", string(b))
+
+	// Migrated roots aren't migrated again.
+	require.NoError(t, migrateMetaRoot(root))
+	assert.Len(t, migrated, 1)
+}
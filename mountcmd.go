@@ -0,0 +1,337 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/mount"
+	"golang.org/x/xerrors"
+
+	"go.coder.com/cli"
+	"go.coder.com/sail/internal/randstr"
+	"go.coder.com/sail/internal/xlog"
+)
+
+// mountsPath is the file that keeps the mounts added with sail mount, so
+// they're also used when the container is created again by sail run.
+func mountsPath(cntName string) string {
+	return filepath.Join(metaRoot(), cntName, "mounts")
+}
+
+// loadMounts loads the mounts added to the container named cntName.
+func loadMounts(cntName string) ([]string, error) {
+	b, err := ioutil.ReadFile(mountsPath(cntName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return splitMounts(string(b)), nil
+}
+
+func saveMounts(cntName string, mounts []string) error {
+	path := mountsPath(cntName)
+	err := os.MkdirAll(filepath.Dir(path), 0750)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, []byte(strings.Join(mounts, "\n")), 0640)
+}
+
+// splitMounts splits newline separated mounts.
+func splitMounts(s string) []string {
+	var mounts []string
+	for _, m := range strings.Split(s, "\n") {
+		m = strings.TrimSpace(m)
+		if m != "" {
+			mounts = append(mounts, m)
+		}
+	}
+	return mounts
+}
+
+// parseMount parses a mount of the form host_path:guest_path[:z|Z].
+func parseMount(spec string) (mount.Mount, error) {
+	err := checkTemplate(spec)
+	if err != nil {
+		return mount.Mount{}, xerrors.Errorf("invalid mount %q: %w", spec, err)
+	}
+	m, err := parseShareLabel(mountsLabel, spec)
+	if err != nil {
+		return mount.Mount{}, xerrors.Errorf("invalid mount %q, must be of form host_path:guest_path[:z|Z], with guest_path absolute or starting with ~/", spec)
+	}
+	return m, nil
+}
+
+// sameTarget reports whether the guest paths a and b are the same, with home
+// the container user's home directory.
+func sameTarget(home, a, b string) bool {
+	return resolvePath(home, a) == resolvePath(home, b)
+}
+
+// addExtraMounts adds the mounts added with sail mount to mounts, checking
+// them like shares against sailMounts.
+func (r *runner) addExtraMounts(projectDir string, sailMounts, mounts []mount.Mount) ([]mount.Mount, error) {
+	for _, spec := range r.extraMounts {
+		m, err := parseMount(spec)
+		if err != nil {
+			return nil, err
+		}
+
+		if merr := r.expandMount(&m); merr != nil {
+			return nil, merr
+		}
+		if merr := resolveMount(&m, r.guestHome()); merr != nil {
+			return nil, merr
+		}
+
+		err = checkShare(mountsLabel, m, sailMounts, projectDir, r.guestHome())
+		if err != nil {
+			return nil, err
+		}
+		if _, opt := splitRelabel(spec); opt != "" {
+			r.relabel(m.Target, opt)
+		}
+		r.setMountSource(m, mountSource{origin: originConfig, desc: "sail mount " + spec})
+
+		mounts = append(mounts, m)
+	}
+	return mounts, nil
+}
+
+type mountcmd struct {
+	gf *globalFlags
+}
+
+func (c *mountcmd) Spec() cli.CommandSpec {
+	return cli.CommandSpec{
+		Name:  "mount",
+		Usage: "[add|remove|ls]",
+		Desc: `Manages the mounts of a project's container.
+
+Changing the mounts recreates the container, so running processes and changes
+outside of mounts and the project directory are lost.`,
+	}
+}
+
+func (c *mountcmd) Run(fl *flag.FlagSet) {
+	fl.Usage()
+	os.Exit(1)
+}
+
+func (c *mountcmd) Subcommands() []cli.Command {
+	return []cli.Command{
+		&mountAddCmd{gf: c.gf},
+		&mountRemoveCmd{gf: c.gf},
+		&mountLsCmd{gf: c.gf},
+	}
+}
+
+type mountAddCmd struct {
+	gf *globalFlags
+}
+
+func (c *mountAddCmd) Spec() cli.CommandSpec {
+	return cli.CommandSpec{
+		Name:  "add",
+		Usage: "<repo> <host_path>:<guest_path>[:z|Z]",
+		Desc:  "Mounts host_path into the project's container at guest_path.",
+	}
+}
+
+func (c *mountAddCmd) Run(fl *flag.FlagSet) {
+	if fl.NArg() != 2 {
+		fl.Usage()
+		os.Exit(1)
+	}
+	spec := fl.Arg(1)
+	m, err := parseMount(spec)
+	if err != nil {
+		xlog.Fatal("%v", err)
+	}
+	// Relative host paths are relative to the current directory, not
+	// wherever the container is recreated from later.
+	if !strings.HasPrefix(m.Source, "~/") {
+		m.Source, err = filepath.Abs(m.Source)
+		if err != nil {
+			xlog.Fatal("failed to resolve host path: %v", err)
+		}
+		spec = m.Source + ":" + m.Target
+	}
+
+	proj := projectArg(c.gf, fl)
+	c.gf.ensureDockerDaemon()
+
+	mounts, home := containerMounts(proj)
+	for _, existing := range mounts {
+		em, err := parseMount(existing)
+		if err == nil && sameTarget(home, em.Target, m.Target) {
+			xlog.Fatal("%v is already mounted at %v", em.Source, em.Target)
+		}
+	}
+
+	err = recreateWithMounts(proj, append(mounts, spec))
+	if err != nil {
+		xlog.Fatal("%v", err)
+	}
+	xlog.Success("mounted %v at %v", m.Source, m.Target)
+}
+
+type mountRemoveCmd struct {
+	gf *globalFlags
+}
+
+func (c *mountRemoveCmd) Spec() cli.CommandSpec {
+	return cli.CommandSpec{
+		Name:  "remove",
+		Usage: "<repo> <guest_path>",
+		Desc:  "Removes the mount at guest_path that was added with sail mount add.",
+	}
+}
+
+func (c *mountRemoveCmd) Run(fl *flag.FlagSet) {
+	if fl.NArg() != 2 {
+		fl.Usage()
+		os.Exit(1)
+	}
+	target := fl.Arg(1)
+
+	proj := projectArg(c.gf, fl)
+	c.gf.ensureDockerDaemon()
+
+	var (
+		mounts  []string
+		removed bool
+	)
+	specs, home := containerMounts(proj)
+	for _, spec := range specs {
+		m, err := parseMount(spec)
+		if err == nil && sameTarget(home, m.Target, target) {
+			removed = true
+			continue
+		}
+		mounts = append(mounts, spec)
+	}
+	if !removed {
+		xlog.Fatal("nothing was mounted at %v with sail mount add", target)
+	}
+
+	err := recreateWithMounts(proj, mounts)
+	if err != nil {
+		xlog.Fatal("%v", err)
+	}
+	xlog.Success("removed the mount at %v", target)
+}
+
+type mountLsCmd struct {
+	gf *globalFlags
+}
+
+func (c *mountLsCmd) Spec() cli.CommandSpec {
+	return cli.CommandSpec{
+		Name:  "ls",
+		Usage: "<repo>",
+		Desc:  "Lists the mounts added to the project's container with sail mount add.",
+	}
+}
+
+func (c *mountLsCmd) Run(fl *flag.FlagSet) {
+	proj := projectArg(c.gf, fl)
+	c.gf.ensureDockerDaemon()
+
+	mounts, _ := containerMounts(proj)
+	for _, spec := range mounts {
+		fmt.Println(spec)
+	}
+}
+
+// projectArg reads the project from the first argument only, as sail mount
+// and sail env take more arguments.
+func projectArg(gf *globalFlags, fl *flag.FlagSet) *project {
+	args := fl.Args()
+	if len(args) > 1 {
+		args = args[:1]
+	}
+	projFl := flag.NewFlagSet(fl.Name(), flag.ExitOnError)
+	projFl.Parse(args)
+	return gf.project(schemaPrefs{}, projFl)
+}
+
+// containerMounts returns the mounts added to the project's container, and
+// the home directory of its user.
+func containerMounts(proj *project) (_ []string, home string) {
+	cli := dockerClient()
+	defer cli.Close()
+
+	cnt, err := inspectContainer(context.Background(), cli, proj.cntName())
+	if err != nil {
+		xlog.Fatal("%v doesn't exist, create it with sail run: %v", proj.pathName(), err)
+	}
+	return splitMounts(cnt.Config.Labels[mountsLabel]), guestHome(cnt.Config.Labels)
+}
+
+// recreateWithMounts recreates the project's container with mounts, keeping
+// it stopped if it was. The mounts are saved, so sail run uses them too.
+func recreateWithMounts(proj *project, mounts []string) (err error) {
+	cli := dockerClient()
+	defer cli.Close()
+
+	ctx := context.Background()
+
+	// The lock is released when we exit.
+	_, err = proj.lock()
+	if err != nil {
+		return xerrors.Errorf("failed to lock project: %w", err)
+	}
+
+	cnt, err := inspectContainer(ctx, cli, proj.cntName())
+	if err != nil {
+		return err
+	}
+
+	xlog.Info("%v will be recreated to change its mounts", proj.pathName())
+	xlog.Info("running processes and changes outside of mounts and %v will be lost", cnt.Config.Labels[projectDirLabel])
+	if !confirm("continue?") {
+		return xerrors.New("aborted")
+	}
+
+	// The runner needs the running container's code-server port, so the
+	// proxy keeps working.
+	wasRunning := cnt.State.Running
+	if !wasRunning {
+		err = cli.ContainerStart(ctx, proj.cntName(), types.ContainerStartOptions{})
+		if err != nil {
+			return xerrors.Errorf("failed to start container: %w", err)
+		}
+	}
+
+	r, err := runnerFromContainer(proj.cntName())
+	if err != nil {
+		return xerrors.Errorf("failed to initialize runner: %w", err)
+	}
+	r.cntName = proj.cntName() + "-mount-" + randstr.Make(5)
+	r.timeouts = proj.conf.timeouts(false)
+	r.codeServer = proj.conf.codeServerSource()
+	r.extraMounts = mounts
+
+	err = swapContainer(ctx, cli, proj.cntName(), r, cnt.Image)
+	if err != nil {
+		return err
+	}
+
+	if !wasRunning {
+		err = stopContainer(ctx, cli, proj.cntName())
+		if err != nil {
+			return err
+		}
+	}
+
+	return saveMounts(proj.cntName(), mounts)
+}
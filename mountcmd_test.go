@@ -0,0 +1,25 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_splitMounts(t *testing.T) {
+	assert.Nil(t, splitMounts(""))
+	assert.Equal(t, []string{"/a:/b", "~/c:~/d"}, splitMounts("/a:/b\n\n ~/c:~/d \n"))
+}
+
+func Test_parseMount(t *testing.T) {
+	m, err := parseMount("/tmp/data:~/data")
+	require.NoError(t, err)
+	assert.Equal(t, "/tmp/data", m.Source)
+	assert.True(t, sameTarget(guestHomeDir, m.Target, "/home/user/data"))
+
+	_, err = parseMount("/tmp/data")
+	require.Error(t, err)
+	_, err = parseMount("/tmp/data:data")
+	require.Error(t, err)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/docker/docker/api/types/mount"
+	"golang.org/x/xerrors"
+
+	"go.coder.com/sail/internal/xlog"
+)
+
+// mountOrigin is where a mount of a container comes from. When mounts
+// conflict, the one with the higher origin wins.
+type mountOrigin int
+
+const (
+	// originImage mounts are defined by share labels of the project's image.
+	originImage mountOrigin = iota
+	// originHat mounts are defined by share labels the hat added.
+	originHat
+	// originConfig mounts were added with sail mount.
+	originConfig
+	// originSail mounts are sail's own, including those of flags like
+	// --docker. Shares can't replace them, see checkShare.
+	originSail
+)
+
+// mountSource describes where a mount comes from.
+type mountSource struct {
+	origin mountOrigin
+	// desc describes the origin, e.g. the label defining the mount.
+	desc string
+}
+
+// mountKey identifies a resolved mount.
+func mountKey(m mount.Mount) string {
+	return m.Source + "\x00" + m.Target
+}
+
+// setMountSource records where the resolved mount m comes from. Mounts
+// without a recorded source are sail's own.
+func (r *runner) setMountSource(m mount.Mount, src mountSource) {
+	if r.mountSources == nil {
+		r.mountSources = make(map[string]mountSource)
+	}
+	r.mountSources[mountKey(m)] = src
+}
+
+func (r *runner) mountSource(m mount.Mount) mountSource {
+	src, ok := r.mountSources[mountKey(m)]
+	if !ok {
+		return mountSource{origin: originSail, desc: "sail"}
+	}
+	return src
+}
+
+// mountConflict is a mount that was dropped, as another one has its target.
+type mountConflict struct {
+	dropped, kept       mount.Mount
+	droppedSrc, keptSrc mountSource
+}
+
+func (c mountConflict) String() string {
+	return fmt.Sprintf("%v from %v is dropped, %v from %v is mounted there", c.dropped.Source, c.droppedSrc.desc, c.kept.Source, c.keptSrc.desc)
+}
+
+// resolveMountConflicts keeps one mount per target, as Docker refuses to
+// create containers with duplicate targets. The mount of the highest origin
+// wins, of equal ones the first. It returns the mounts that were dropped.
+func (r *runner) resolveMountConflicts(mounts []mount.Mount) ([]mount.Mount, []mountConflict) {
+	winners := make(map[string]int)
+	for i, m := range mounts {
+		w, ok := winners[m.Target]
+		if !ok || r.mountSource(m).origin > r.mountSource(mounts[w]).origin {
+			winners[m.Target] = i
+		}
+	}
+
+	var (
+		kept      = make([]mount.Mount, 0, len(mounts))
+		conflicts []mountConflict
+	)
+	for i, m := range mounts {
+		w := winners[m.Target]
+		if w == i {
+			kept = append(kept, m)
+			continue
+		}
+		// Duplicates of the same mount aren't conflicts.
+		if mountKey(m) == mountKey(mounts[w]) {
+			continue
+		}
+		conflicts = append(conflicts, mountConflict{
+			dropped:    m,
+			kept:       mounts[w],
+			droppedSrc: r.mountSource(m),
+			keptSrc:    r.mountSource(mounts[w]),
+		})
+	}
+	return kept, conflicts
+}
+
+// stripDuplicateMounts resolves the conflicts of mounts, warning about the
+// mounts that were dropped. With strictMounts, conflicts are errors.
+func (r *runner) stripDuplicateMounts(mounts []mount.Mount) ([]mount.Mount, error) {
+	mounts, conflicts := r.resolveMountConflicts(mounts)
+	if len(conflicts) == 0 {
+		return mounts, nil
+	}
+	msgs := make([]string, 0, len(conflicts))
+	for _, c := range conflicts {
+		msgs = append(msgs, fmt.Sprintf("%v: %v", c.dropped.Target, c))
+	}
+	if r.strictMounts {
+		return nil, xerrors.Errorf("conflicting mounts:\n%v", strings.Join(msgs, "\n"))
+	}
+	for _, msg := range msgs {
+		xlog.Warn("conflicting mounts at %v", msg)
+	}
+	return mounts, nil
+}
+
+// hatShareLabels returns the share labels of image, whose labels are labels,
+// that its hat added or changed.
+func hatShareLabels(image string, labels map[string]string) map[string]bool {
+	base := labels[baseImageLabel]
+	if base == "" || base == image {
+		return nil
+	}
+	baseLabels, err := imageLabels(base)
+	if err != nil {
+		xlog.Debug("failed to get the labels of %v: %v", base, err)
+		return nil
+	}
+	hat := make(map[string]bool)
+	for _, k := range shareLabels(labels) {
+		if v, ok := baseLabels[k]; !ok || v != labels[k] {
+			hat[k] = true
+		}
+	}
+	return hat
+}
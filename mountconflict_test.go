@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types/mount"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_resolveMountConflicts(t *testing.T) {
+	var (
+		own    = mount.Mount{Source: "/home/user/.vscode", Target: "/home/coder/.vscode"}
+		image  = mount.Mount{Source: "/srv/image", Target: "/data"}
+		hat    = mount.Mount{Source: "/srv/hat", Target: "/data"}
+		extra  = mount.Mount{Source: "/srv/extra", Target: "/data"}
+		cache  = mount.Mount{Source: "/srv/cache", Target: "/cache"}
+		cache2 = mount.Mount{Source: "/srv/cache2", Target: "/cache"}
+	)
+	r := &runner{}
+	r.setMountSource(image, mountSource{origin: originImage, desc: "image label share.data"})
+	r.setMountSource(hat, mountSource{origin: originHat, desc: "hat label share.data"})
+	r.setMountSource(extra, mountSource{origin: originConfig, desc: "sail mount /srv/extra:/data"})
+	r.setMountSource(cache, mountSource{origin: originImage, desc: "image label share.cache"})
+	r.setMountSource(cache2, mountSource{origin: originImage, desc: "image label share.cache2"})
+
+	kept, conflicts := r.resolveMountConflicts([]mount.Mount{own, image, hat, own, cache, cache2, extra})
+	assert.Equal(t, []mount.Mount{own, cache, extra}, kept)
+	require.Len(t, conflicts, 3)
+	assert.Equal(t, image, conflicts[0].dropped)
+	assert.Equal(t, extra, conflicts[0].kept)
+	assert.Equal(t, "hat label share.data", conflicts[1].droppedSrc.desc)
+	// Of equal origins, the first wins.
+	assert.Equal(t, cache2, conflicts[2].dropped)
+	assert.Equal(t, cache, conflicts[2].kept)
+
+	_, err := r.stripDuplicateMounts([]mount.Mount{image, hat})
+	require.NoError(t, err)
+
+	r.strictMounts = true
+	_, err = r.stripDuplicateMounts([]mount.Mount{image, hat})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "image label share.data")
+	_, err = r.stripDuplicateMounts([]mount.Mount{own, own})
+	require.NoError(t, err)
+}
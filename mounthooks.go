@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"golang.org/x/xerrors"
+)
+
+// hostUser is the user that sail creates host files for. It's the user that
+// invoked sail, even if sail is being run through sudo.
+type hostUser struct {
+	uid int
+	gid int
+}
+
+// currentHostUser returns the user that invoked sail.
+func currentHostUser() hostUser {
+	u := hostUser{
+		uid: os.Getuid(),
+		gid: os.Getgid(),
+	}
+
+	// sudo records the invoking user, without it every directory
+	// we create would be owned by root.
+	if uid, err := strconv.Atoi(os.Getenv("SUDO_UID")); err == nil {
+		u.uid = uid
+	}
+	if gid, err := strconv.Atoi(os.Getenv("SUDO_GID")); err == nil {
+		u.gid = gid
+	}
+	return u
+}
+
+// mountSourceHook is called for every directory sail creates on the host
+// while ensuring mount sources exist.
+type mountSourceHook func(dir string) error
+
+// mountSourceHooks are run in order for each created directory.
+var mountSourceHooks = []mountSourceHook{
+	chownHostUser,
+}
+
+// chownHostUser gives ownership of dir to the host user.
+func chownHostUser(dir string) error {
+	u := currentHostUser()
+	if u.uid == os.Getuid() && u.gid == os.Getgid() {
+		// Already owned by the right user.
+		return nil
+	}
+
+	err := os.Chown(dir, u.uid, u.gid)
+	if err != nil {
+		return xerrors.Errorf("failed to chown %v to %v:%v: %w", dir, u.uid, u.gid, err)
+	}
+	return nil
+}
+
+// mkdirAllHooked is like os.MkdirAll, but runs the mount source hooks
+// on every directory it creates. The created directories are returned
+// from the top down.
+func mkdirAllHooked(dir string, perm os.FileMode) ([]string, error) {
+	dir = filepath.Clean(dir)
+
+	// Find the directories that don't exist yet.
+	var missing []string
+	for p := dir; ; p = filepath.Dir(p) {
+		_, err := os.Stat(p)
+		if err == nil {
+			break
+		}
+		if !os.IsNotExist(err) {
+			return nil, xerrors.Errorf("failed to stat %v: %w", p, err)
+		}
+		missing = append([]string{p}, missing...)
+
+		if filepath.Dir(p) == p {
+			break
+		}
+	}
+
+	err := os.MkdirAll(dir, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range missing {
+		for _, hook := range mountSourceHooks {
+			err = hook(p)
+			if err != nil {
+				return missing, err
+			}
+		}
+	}
+	return missing, nil
+}
+
+// encodeCreatedSources encodes the mount sources sail created for the
+// createdSourcesLabel.
+func encodeCreatedSources(dirs []string) string {
+	b, _ := json.Marshal(dirs)
+	return string(b)
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_mkdirAllHooked(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sail-mounthooks")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var hooked []string
+	defer func(hooks []mountSourceHook) {
+		mountSourceHooks = hooks
+	}(mountSourceHooks)
+	mountSourceHooks = []mountSourceHook{func(dir string) error {
+		hooked = append(hooked, dir)
+		return nil
+	}}
+
+	created, err := mkdirAllHooked(filepath.Join(dir, "a", "b"), 0755)
+	require.NoError(t, err)
+
+	exp := []string{filepath.Join(dir, "a"), filepath.Join(dir, "a", "b")}
+	require.Equal(t, exp, created)
+	require.Equal(t, exp, hooked)
+
+	created, err = mkdirAllHooked(filepath.Join(dir, "a", "b"), 0755)
+	require.NoError(t, err)
+	require.Empty(t, created)
+}
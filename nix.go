@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/docker/api/types/mount"
+	"golang.org/x/xerrors"
+
+	"go.coder.com/sail/internal/xexec"
+	"go.coder.com/sail/internal/xlog"
+)
+
+// nixStoreLabel names the Docker volume mounted at /nix.
+// The volume is shared by all nix projects so the store is only
+// downloaded once.
+const nixStoreLabel = sailLabel + ".nix_store"
+
+// nixStoreVolume is the volume holding the shared nix store.
+const nixStoreVolume = "sail-nix"
+
+// nixEnvPath is where the project's nix environment is saved in the container,
+// it's sourced by interactive shells.
+const nixEnvPath = "~/.sail-nix-env.sh"
+
+// nixFiles are the files that define a nix environment, in order of preference.
+var nixFiles = []string{"flake.nix", "shell.nix"}
+
+// findNixFile returns the name of the nix file defining the project's
+// environment, or the empty string if there isn't one.
+func findNixFile(projectDir string) string {
+	for _, f := range nixFiles {
+		_, err := os.Stat(filepath.Join(projectDir, f))
+		if err == nil {
+			return f
+		}
+	}
+	return ""
+}
+
+// buildNixImage builds the project's image for a flake.nix or shell.nix.
+// It returns false if the project doesn't have either.
+//
+// The toolchain isn't baked into the image. Instead, nix is installed into a
+// shared volume at /nix when the container is created and the environment
+// is built there. The environment is then loaded by every shell.
+func (p *project) buildNixImage(imageID string) (string, bool, error) {
+	nixFile := findNixFile(p.localDir())
+	if nixFile == "" {
+		return "", false, nil
+	}
+	xlog.Info("using %v", nixFile)
+
+	to := p.conf.timeouts(false)
+	ctx, cancel := context.WithTimeout(context.Background(), to.build)
+	defer cancel()
+
+	base := p.conf.DefaultImage
+	pullCtx, cancel := context.WithTimeout(ctx, to.pull)
+	_, err := ensureImage(pullCtx, base, p.buildOpts.platform)
+	cancel()
+	if err != nil {
+		return "", false, xerrors.Errorf("failed to ensure image %v: %w", base, err)
+	}
+
+	err = buildLabelImage(ctx, imageID, base, p.buildOpts.platform, map[string]string{
+		baseImageLabel:   imageID,
+		nixStoreLabel:    nixStoreVolume,
+		onCreateCmdLabel: nixSetupCmd(nixFile),
+	}, nil)
+	if err != nil {
+		return "", false, err
+	}
+	return imageID, true, nil
+}
+
+// nixSetupCmd returns the command that installs nix if needed, and then
+// builds and saves the environment defined by nixFile.
+func nixSetupCmd(nixFile string) string {
+	printEnv := "nix --extra-experimental-features 'nix-command flakes' print-dev-env"
+	if nixFile != "flake.nix" {
+		printEnv += " -f " + xexec.Quote(nixFile)
+	}
+
+	return strings.Join([]string{
+		// Sandboxed containers don't mount the volume, and it's created
+		// owned by root. The container's user may not be the image's.
+		"sudo mkdir -p /nix",
+		`sudo chown "$(id -u):$(id -g)" /nix`,
+		"([ -e ~/.nix-profile ] || curl -fsSL https://nixos.org/nix/install | sh -s -- --no-daemon)",
+		". ~/.nix-profile/etc/profile.d/nix.sh",
+		printEnv + " > " + nixEnvPath,
+		"echo '. ~/.nix-profile/etc/profile.d/nix.sh; . " + nixEnvPath + "' >> ~/.bashrc",
+	}, " && ")
+}
+
+// mountNixStore mounts the nix store volume if labels requests it.
+func mountNixStore(mounts []mount.Mount, labels map[string]string) []mount.Mount {
+	vol := labels[nixStoreLabel]
+	if vol == "" {
+		return mounts
+	}
+	return append(mounts, mount.Mount{
+		Type:   mount.TypeVolume,
+		Source: vol,
+		Target: "/nix",
+	})
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_findNixFile(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "sail-nix")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	assert.Equal(t, "", findNixFile(dir))
+
+	err = ioutil.WriteFile(filepath.Join(dir, "shell.nix"), nil, 0644)
+	require.NoError(t, err)
+	assert.Equal(t, "shell.nix", findNixFile(dir))
+
+	err = ioutil.WriteFile(filepath.Join(dir, "flake.nix"), nil, 0644)
+	require.NoError(t, err)
+	assert.Equal(t, "flake.nix", findNixFile(dir))
+}
+
+func Test_nixSetupCmd(t *testing.T) {
+	t.Parallel()
+
+	assert.Contains(t, nixSetupCmd("flake.nix"), "print-dev-env > "+nixEnvPath)
+	assert.True(t, strings.HasPrefix(nixSetupCmd("flake.nix"), `sudo mkdir -p /nix && sudo chown "$(id -u):$(id -g)" /nix && `))
+	assert.Contains(t, nixSetupCmd("shell.nix"), "print-dev-env -f 'shell.nix' > "+nixEnvPath)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"flag"
+	"os"
+
+	"go.coder.com/cli"
+	"go.coder.com/sail/internal/xlog"
+)
+
+type opencmd struct {
+	gf *globalFlags
+
+	name string
+	app  bool
+}
+
+func (c *opencmd) Spec() cli.CommandSpec {
+	return cli.CommandSpec{
+		Name:  "open",
+		Usage: "[flags] <repo>",
+		Desc: `Opens an existing project in the browser.
+
+With --app, a launcher for the project is installed instead, so it can be
+pinned to docks and application launchers. On Linux it's a .desktop entry
+in ~/.local/share/applications, and on macOS an app in ~/Applications.
+The launcher runs sail run, so it works even after the project's container
+is removed.`,
+	}
+}
+
+func (c *opencmd) RegisterFlags(fl *flag.FlagSet) {
+	fl.StringVar(&c.name, "name", "", "Custom project name the project was run with.")
+	fl.BoolVar(&c.app, "app", false, "Install a desktop launcher for the project.")
+}
+
+func (c *opencmd) Run(fl *flag.FlagSet) {
+	proj := c.gf.project(schemaPrefs{}, fl)
+	if c.name != "" {
+		err := validateProjectName(c.name)
+		if err != nil {
+			xlog.Fatal("%v", err)
+		}
+		proj.name = c.name
+	}
+
+	if c.app {
+		c.installLauncher(proj, fl.Arg(0))
+		return
+	}
+
+	c.gf.ensureDockerDaemon()
+
+	exists, err := proj.cntExists()
+	if err != nil {
+		xlog.Fatal("%v", err)
+	}
+	if !exists {
+		xlog.Fatal("%v doesn't exist, create it with sail run", proj.pathName())
+	}
+
+	err = proj.open()
+	if err != nil {
+		xlog.Fatal("failed to open project: %v", err)
+	}
+}
+
+func (c *opencmd) installLauncher(proj *project, repoArg string) {
+	sail, err := os.Executable()
+	if err != nil {
+		xlog.Fatal("failed to find sail executable: %v", err)
+	}
+
+	args := []string{sail, "run"}
+	if c.name != "" {
+		args = append(args, "--name", c.name)
+	}
+	args = append(args, repoArg)
+
+	path, err := launcher{
+		name:    proj.pathName(),
+		cntName: proj.cntName(),
+		args:    args,
+	}.install()
+	if err != nil {
+		xlog.Fatal("failed to install launcher: %v", err)
+	}
+	xlog.Success("installed launcher %v", path)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"go.coder.com/cli"
+	"go.coder.com/sail/internal/xlog"
+)
+
+// outdatedTimeout bounds how long sail outdated waits on registries.
+const outdatedTimeout = time.Minute
+
+type outdatedcmd struct {
+	gf *globalFlags
+}
+
+func (c *outdatedcmd) Spec() cli.CommandSpec {
+	return cli.CommandSpec{
+		Name: "outdated",
+		Desc: `Lists the projects whose base images have newer versions in their registry,
+or whose hat changed since they were created. sail upgrade upgrades them.
+
+Private registries are queried with the credentials of docker login, see sail
+login. Hats from GitHub aren't checked. Projects created before sail tracked
+their base images are checked once they're recreated.`,
+	}
+}
+
+func (c *outdatedcmd) Run(fl *flag.FlagSet) {
+	c.gf.ensureDockerDaemon()
+
+	cnts, err := listContainers()
+	if err != nil {
+		xlog.Fatal("failed to list containers: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), outdatedTimeout)
+	defer cancel()
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	var outdated int
+	for _, cnt := range cnts {
+		name := toSailName(trimDockerName(cnt))
+		if n := cnt.Labels[nameLabel]; n != "" {
+			name = n
+		}
+
+		reasons := outdatedImages(parseUpstream(cnt.Labels[upstreamLabel]), func(ref string) (string, error) {
+			return remoteDigest(ctx, ref)
+		})
+		if changedHat(cnt.Labels, cnt.Image) {
+			reasons = append(reasons, "hat "+cnt.Labels[hatLabel])
+		}
+		if len(reasons) == 0 {
+			continue
+		}
+		if outdated == 0 {
+			fmt.Fprintln(tw, "name\toutdated")
+		}
+		outdated++
+		fmt.Fprintf(tw, "%v\t%v\n", name, strings.Join(reasons, ", "))
+	}
+	tw.Flush()
+
+	if outdated == 0 {
+		xlog.Success("all projects are up to date")
+		return
+	}
+	xlog.Info("upgrade them with sail upgrade <project>")
+}
+
+// outdatedImages returns the references of pins whose digest differs from
+// the one remote returns, which are outdated.
+func outdatedImages(pins []pinnedImage, remote func(ref string) (string, error)) []string {
+	var outdated []string
+	for _, pin := range pins {
+		digest, err := remote(pin.ref)
+		if err != nil {
+			xlog.Warn("%v", err)
+			continue
+		}
+		if digest != pin.digest {
+			outdated = append(outdated, pin.ref)
+		}
+	}
+	return outdated
+}
+
+// changedHat reports whether the hat of the container with labels changed
+// since its image was built.
+func changedHat(labels map[string]string, image string) bool {
+	hat := labels[hatLabel]
+	if hat == "" || strings.HasPrefix(hat, githubHatPrefix) {
+		return false
+	}
+
+	args, err := labelHatArgs(labels)
+	if err != nil {
+		xlog.Warn("%v", err)
+		return false
+	}
+	b := &hatBuilder{
+		baseImage: labels[baseImageLabel],
+		hatPath:   hat,
+		args:      args,
+	}
+	// Hat images are named after their Dockerfile.
+	_, _, hatImage, err := b.hatDockerfile()
+	if err != nil {
+		xlog.Warn("%v", err)
+		return false
+	}
+	return normalizeImageName(hatImage) != normalizeImageName(image)
+}
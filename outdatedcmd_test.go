@@ -0,0 +1,54 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+)
+
+func Test_outdatedImages(t *testing.T) {
+	remote := map[string]string{
+		"codercom/ubuntu-dev": "sha256:new",
+		"node:12":             "sha256:same",
+	}
+	pins := []pinnedImage{
+		{ref: "codercom/ubuntu-dev", digest: "sha256:old"},
+		{ref: "node:12", digest: "sha256:same"},
+		{ref: "private.registry/dev", digest: "sha256:old"},
+	}
+	outdated := outdatedImages(pins, func(ref string) (string, error) {
+		digest, ok := remote[ref]
+		if !ok {
+			return "", xerrors.Errorf("failed to query registry for %v", ref)
+		}
+		return digest, nil
+	})
+	assert.Equal(t, []string{"codercom/ubuntu-dev"}, outdated)
+}
+
+func Test_changedHat(t *testing.T) {
+	hat, err := ioutil.TempDir("", "sail-hat")
+	require.NoError(t, err)
+	defer os.RemoveAll(hat)
+	dockerfile := filepath.Join(hat, "Dockerfile")
+	require.NoError(t, ioutil.WriteFile(dockerfile, []byte("FROM ubuntu\nRUN echo hi\n"), 0644))
+
+	labels := map[string]string{
+		baseImageLabel: "cdr_sail",
+		hatLabel:       hat,
+	}
+	b := &hatBuilder{baseImage: "cdr_sail", hatPath: hat}
+	_, _, image, err := b.hatDockerfile()
+	require.NoError(t, err)
+
+	assert.False(t, changedHat(labels, image))
+	assert.False(t, changedHat(map[string]string{}, "cdr_sail"))
+
+	require.NoError(t, ioutil.WriteFile(dockerfile, []byte("FROM ubuntu\nRUN echo bye\n"), 0644))
+	assert.True(t, changedHat(labels, image))
+}
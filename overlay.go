@@ -0,0 +1,361 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/docker/docker/api/types/mount"
+	"golang.org/x/xerrors"
+)
+
+// overlayLabel marks containers whose project directory is an overlay of the
+// host's, see runner.overlay.
+const overlayLabel = sailLabel + ".overlay"
+
+// The paths of the overlay's layers in the container. The host's project
+// directory is the read-only lower layer, the changes go to the upper layer,
+// which is in the container's directory of the host.
+const (
+	containerOverlayLower  = "/var/lib/sail-overlay/lower"
+	containerOverlayLayers = "/var/lib/sail-overlay/layers"
+)
+
+// overlayScript mounts the overlay of the project directory before running
+// its arguments, as mounts don't outlive the container's processes:
+//
+//	$1 the lower layer
+//	$2 the directory of the upper layer and overlayfs' work directory
+//	$3 the project directory
+//
+// The layers are created on the host, so the project directory is owned by
+// the host user, like its bind mount would be. Where the kernel supports it,
+// overlayfs keeps its xattrs in the user namespace, so the host user can
+// read them, see overlayChanges.
+const overlayScript = `set -euo pipefail || exit 1
+sudo mkdir -p "$3"
+opts="lowerdir=$1,upperdir=$2/upper,workdir=$2/work"
+sudo mount -t overlay overlay -o "$opts,userxattr" "$3" 2>/dev/null || sudo mount -t overlay overlay -o "$opts" "$3"
+shift 3
+exec "$@"`
+
+// overlayDir returns the host directory of the layers of the overlay of
+// the container cntName.
+func overlayDir(cntName string) string {
+	return filepath.Join(metaRoot(), cntName, "overlay")
+}
+
+// overlayCommand wraps the container's command cmd to mount the overlay of
+// the project directory first, if r.overlay.
+func (r *runner) overlayCommand(cmd []string, projectDir string) []string {
+	if !r.overlay {
+		return cmd
+	}
+	return append([]string{
+		"bash", "-c", overlayScript, "sail-overlay",
+		containerOverlayLower,
+		containerOverlayLayers,
+		resolvePath(r.guestHome(), projectDir),
+	}, cmd...)
+}
+
+// mountOverlay mounts the host's project directory as the lower layer of the
+// overlay, if r.overlay, along with the directory of the upper layer. mounts
+// must contain the mount of the project directory projectDir.
+func (r *runner) mountOverlay(mounts []mount.Mount, projectDir string) ([]mount.Mount, error) {
+	if !r.overlay {
+		return mounts, nil
+	}
+	if r.sandbox || r.windows {
+		return nil, xerrors.New("overlay projects need privileged Linux containers")
+	}
+	if !r.dryRun {
+		for _, d := range []string{"upper", "work"} {
+			err := os.MkdirAll(filepath.Join(overlayDir(r.cntName), d), 0750)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	projectDir = resolvePath(r.guestHome(), projectDir)
+	for i, m := range mounts {
+		if m.Target == projectDir {
+			mounts[i].Target = containerOverlayLower
+			mounts[i].ReadOnly = true
+		}
+	}
+	return append(mounts, mount.Mount{
+		Type:   mount.TypeBind,
+		Source: overlayDir(r.cntName),
+		Target: containerOverlayLayers,
+	}), nil
+}
+
+// The kinds of overlay changes.
+const (
+	overlayAdded    = "A"
+	overlayModified = "M"
+	overlayDeleted  = "D"
+)
+
+// overlayChange is a change of a path of the project in its overlay.
+type overlayChange struct {
+	kind string
+	// path is relative to the project directory.
+	path string
+}
+
+// overlayXattrPrefixes are the namespaces of overlayfs' xattrs, trusted by
+// default and user for mounts with userxattr.
+var overlayXattrPrefixes = []string{"trusted.overlay.", "user.overlay."}
+
+// hasOverlayXattr reports whether the file at path has the overlayfs xattr
+// name, e.g. opaque, of either namespace, with value unless it's empty.
+// Only root can read trusted xattrs.
+func hasOverlayXattr(path, name, value string) bool {
+	for _, prefix := range overlayXattrPrefixes {
+		v, ok := getXattr(path, prefix+name)
+		if ok && (value == "" || v == value) {
+			return true
+		}
+	}
+	return false
+}
+
+// isWhiteout reports whether the file at path, described by fi, is an
+// overlayfs whiteout, which marks a path of the lower layer as deleted. They
+// are 0/0 character devices, or, where those can't be created, empty files
+// with the whiteout xattr.
+func isWhiteout(path string, fi os.FileInfo) bool {
+	if fi.Mode()&os.ModeCharDevice != 0 {
+		return isNullDevice(fi)
+	}
+	return fi.Mode().IsRegular() && fi.Size() == 0 && hasOverlayXattr(path, "whiteout", "")
+}
+
+// isOpaque reports whether the directory at path, described by fi, is
+// opaque, which hides the directory of the lower layer it replaces, e.g.
+// because it was deleted and created again.
+func isOpaque(path string, fi os.FileInfo) bool {
+	return fi.IsDir() && hasOverlayXattr(path, "opaque", "y")
+}
+
+// overlayChanges returns the changes of the upper layer upper to the lower
+// layer lower, sorted by path. Changes that match the lower layer, e.g.
+// because they were applied, are left out.
+func overlayChanges(lower, upper string) ([]overlayChange, error) {
+	var (
+		changes []overlayChange
+		// opaque are the opaque directories. Their subdirectories hide
+		// the lower layer too.
+		opaque []string
+	)
+	err := filepath.Walk(upper, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(upper, path)
+		if err != nil || rel == "." {
+			return err
+		}
+
+		lfi, err := os.Lstat(filepath.Join(lower, rel))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		exists := err == nil
+		switch {
+		case isWhiteout(path, fi):
+			if exists {
+				changes = append(changes, overlayChange{kind: overlayDeleted, path: rel})
+			}
+		case fi.IsDir():
+			// Files in new directories are added one by one.
+			if exists && !lfi.IsDir() {
+				changes = append(changes, overlayChange{kind: overlayModified, path: rel})
+				return filepath.SkipDir
+			}
+			if isOpaque(path, fi) {
+				opaque = append(opaque, rel)
+			}
+			if exists && underAnyPath(rel, opaque) {
+				deleted, err := opaqueDeletions(filepath.Join(lower, rel), path, rel)
+				if err != nil {
+					return err
+				}
+				changes = append(changes, deleted...)
+			}
+		case !exists:
+			changes = append(changes, overlayChange{kind: overlayAdded, path: rel})
+		default:
+			same, err := sameFile(filepath.Join(lower, rel), path, lfi, fi)
+			if err != nil {
+				return err
+			}
+			if !same {
+				changes = append(changes, overlayChange{kind: overlayModified, path: rel})
+			}
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		// The container never started.
+		return nil, nil
+	}
+	if err != nil {
+		return nil, xerrors.Errorf("failed to read the overlay: %w", err)
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].path < changes[j].path })
+	return changes, nil
+}
+
+// opaqueDeletions returns the deletions of the files of the lower directory
+// lower that the opaque upper directory upper hides, as it doesn't have them.
+// rel is the path of both in the project.
+func opaqueDeletions(lower, upper, rel string) ([]overlayChange, error) {
+	fis, err := ioutil.ReadDir(lower)
+	if err != nil {
+		return nil, err
+	}
+	var changes []overlayChange
+	for _, fi := range fis {
+		_, err := os.Lstat(filepath.Join(upper, fi.Name()))
+		if err == nil {
+			continue
+		}
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+		changes = append(changes, overlayChange{kind: overlayDeleted, path: filepath.Join(rel, fi.Name())})
+	}
+	return changes, nil
+}
+
+// sameFile reports whether the file a, described by afi, has the type, mode
+// and contents of b.
+func sameFile(a, b string, afi, bfi os.FileInfo) (bool, error) {
+	if afi.Mode() != bfi.Mode() {
+		return false, nil
+	}
+	if afi.Mode()&os.ModeSymlink != 0 {
+		at, err := os.Readlink(a)
+		if err != nil {
+			return false, err
+		}
+		bt, err := os.Readlink(b)
+		return at == bt, err
+	}
+	if afi.Size() != bfi.Size() {
+		return false, nil
+	}
+	ab, err := ioutil.ReadFile(a)
+	if err != nil {
+		return false, err
+	}
+	bb, err := ioutil.ReadFile(b)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(ab, bb), nil
+}
+
+// applyOverlayChange applies c of the upper layer upper to the lower layer
+// lower.
+func applyOverlayChange(lower, upper string, c overlayChange) error {
+	dst := filepath.Join(lower, c.path)
+	if c.kind == overlayDeleted {
+		return os.RemoveAll(dst)
+	}
+
+	src := filepath.Join(upper, c.path)
+	fi, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(dst), 0755)
+	if err != nil {
+		return err
+	}
+	// Files may be replaced by other types, e.g. directories.
+	err = os.RemoveAll(dst)
+	if err != nil {
+		return err
+	}
+	switch {
+	case fi.Mode()&os.ModeSymlink != 0:
+		target, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+		return os.Symlink(target, dst)
+	case fi.IsDir():
+		return copyDir(src, dst)
+	default:
+		return copyFile(src, dst, fi.Mode())
+	}
+}
+
+// copyDir copies the directory src to dst, along with the files in it.
+// Whiteouts are skipped.
+func copyDir(src, dst string) error {
+	return filepath.Walk(src, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		switch {
+		case isWhiteout(path, fi):
+			return nil
+		case fi.IsDir():
+			return os.MkdirAll(target, fi.Mode().Perm())
+		case fi.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		default:
+			return copyFile(path, target, fi.Mode())
+		}
+	})
+}
+
+func copyFile(src, dst string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode.Perm())
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// underAnyPath reports whether path is one of paths, or beneath one of them.
+// The paths are relative to the project directory.
+func underAnyPath(path string, paths []string) bool {
+	for _, p := range paths {
+		p = filepath.Clean(p)
+		if p == "." || path == p || strings.HasPrefix(path, p+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
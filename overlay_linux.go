@@ -0,0 +1,26 @@
+package main
+
+import (
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// getXattr returns the value of the xattr name of the file at path, without
+// following symlinks, and whether it's set and readable.
+func getXattr(path, name string) (string, bool) {
+	buf := make([]byte, 256)
+	n, err := unix.Lgetxattr(path, name, buf)
+	if err != nil {
+		return "", false
+	}
+	return string(buf[:n]), true
+}
+
+// isNullDevice reports whether fi is the character device 0/0 overlayfs
+// creates as whiteouts.
+func isNullDevice(fi os.FileInfo) bool {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	return ok && st.Rdev == 0
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func Test_overlayChangesXattrs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sail-overlay")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	lower, upper := filepath.Join(dir, "lower"), filepath.Join(dir, "upper")
+	writeFiles(t, lower, map[string]string{
+		"main.go":           "package main",
+		"lib/lib.go":        "package lib",
+		"lib/old.go":        "package lib",
+		"lib/sub/old.go":    "package sub",
+		"vendor/dep/dep.go": "package dep",
+	})
+	writeFiles(t, upper, map[string]string{
+		"main.go":        "",
+		"lib/lib.go":     "package lib",
+		"lib/sub/new.go": "package sub",
+	})
+	// The project was mounted with userxattr: lib was deleted and created
+	// again, and main.go is a whiteout of a mount that can't create devices.
+	err = unix.Lsetxattr(filepath.Join(upper, "lib"), "user.overlay.opaque", []byte("y"), 0)
+	if err == unix.ENOTSUP || err == unix.EOPNOTSUPP {
+		t.Skipf("user xattrs aren't supported in %v", dir)
+	}
+	require.NoError(t, err)
+	require.NoError(t, unix.Lsetxattr(filepath.Join(upper, "main.go"), "user.overlay.whiteout", nil, 0))
+
+	changes, err := overlayChanges(lower, upper)
+	require.NoError(t, err)
+	assert.Equal(t, []overlayChange{
+		{kind: overlayDeleted, path: filepath.Join("lib", "old.go")},
+		{kind: overlayAdded, path: filepath.Join("lib", "sub", "new.go")},
+		{kind: overlayDeleted, path: filepath.Join("lib", "sub", "old.go")},
+		{kind: overlayDeleted, path: "main.go"},
+	}, changes)
+
+	for _, c := range changes {
+		require.NoError(t, applyOverlayChange(lower, upper, c))
+	}
+	changes, err = overlayChanges(lower, upper)
+	require.NoError(t, err)
+	assert.Empty(t, changes)
+}
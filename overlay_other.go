@@ -0,0 +1,20 @@
+// +build !linux
+
+package main
+
+import (
+	"os"
+)
+
+// getXattr returns the value of the xattr name of the file at path. Overlays
+// only run on Linux, so it's never set elsewhere.
+func getXattr(path, name string) (string, bool) {
+	return "", false
+}
+
+// isNullDevice reports whether fi is the character device 0/0 overlayfs
+// creates as whiteouts. Device numbers aren't known elsewhere, so any
+// character device is taken for one.
+func isNullDevice(fi os.FileInfo) bool {
+	return true
+}
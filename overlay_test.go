@@ -0,0 +1,78 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+	}
+}
+
+func Test_overlayChanges(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sail-overlay")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	lower, upper := filepath.Join(dir, "lower"), filepath.Join(dir, "upper")
+	writeFiles(t, lower, map[string]string{
+		"main.go":     "package main",
+		"same.go":     "package same",
+		"docs":        "a file",
+		"lib/lib.go":  "package lib",
+		"lib/keep.go": "package lib",
+	})
+	writeFiles(t, upper, map[string]string{
+		"main.go":       "package main\n",
+		"same.go":       "package same",
+		"docs/index.md": "# docs",
+		"lib/lib.go":    "package lib // changed",
+		"new/new.go":    "package new",
+	})
+
+	changes, err := overlayChanges(lower, upper)
+	require.NoError(t, err)
+	assert.Equal(t, []overlayChange{
+		{kind: overlayModified, path: "docs"},
+		{kind: overlayModified, path: filepath.Join("lib", "lib.go")},
+		{kind: overlayModified, path: "main.go"},
+		{kind: overlayAdded, path: filepath.Join("new", "new.go")},
+	}, changes)
+
+	for _, c := range changes {
+		if underAnyPath(c.path, []string{"lib/", "docs"}) {
+			require.NoError(t, applyOverlayChange(lower, upper, c))
+		}
+	}
+	b, err := ioutil.ReadFile(filepath.Join(lower, "docs", "index.md"))
+	require.NoError(t, err)
+	assert.Equal(t, "# docs", string(b))
+
+	changes, err = overlayChanges(lower, upper)
+	require.NoError(t, err)
+	assert.Equal(t, []overlayChange{
+		{kind: overlayModified, path: "main.go"},
+		{kind: overlayAdded, path: filepath.Join("new", "new.go")},
+	}, changes)
+
+	changes, err = overlayChanges(lower, filepath.Join(dir, "missing"))
+	require.NoError(t, err)
+	assert.Empty(t, changes)
+}
+
+func Test_underAnyPath(t *testing.T) {
+	assert.True(t, underAnyPath("lib/lib.go", []string{"lib"}))
+	assert.True(t, underAnyPath("lib/lib.go", []string{"lib/lib.go"}))
+	assert.True(t, underAnyPath("lib/lib.go", []string{"."}))
+	assert.False(t, underAnyPath("library/lib.go", []string{"lib"}))
+	assert.False(t, underAnyPath("lib/lib.go", []string{"main.go"}))
+}
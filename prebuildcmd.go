@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"go.coder.com/cli"
+	"go.coder.com/sail/internal/xlog"
+)
+
+type prebuildcmd struct {
+	gf *globalFlags
+
+	watch bool
+}
+
+func (c *prebuildcmd) Spec() cli.CommandSpec {
+	return cli.CommandSpec{
+		Name:  "prebuild",
+		Usage: "[flags] [repo]",
+		Desc: `Rebuilds projects' images against the latest versions of their base images,
+so their next sail run doesn't wait on builds or pulls.
+
+Without a repo, the prebuilds of the config are rebuilt. Projects that don't
+define their image have their default image pulled instead. The default hat is
+applied too, so its build is cached. Running containers are left as they are,
+sail run --rebuild or sail edit picks up the new image.
+
+With --watch, sail prebuild keeps running and rebuilds the prebuilds on
+prebuild_schedule of the config, at 3am every day by default. sail prebuild
+can also be run from cron.`,
+	}
+}
+
+func (c *prebuildcmd) RegisterFlags(fl *flag.FlagSet) {
+	fl.BoolVar(&c.watch, "watch", false, "Keep running and rebuild the prebuilds on prebuild_schedule.")
+}
+
+func (c *prebuildcmd) Run(fl *flag.FlagSet) {
+	if fl.NArg() > 0 {
+		if c.watch {
+			xlog.Fatal("--watch rebuilds the prebuilds of the config, it takes no repo")
+		}
+		proj := c.gf.project(schemaPrefs{}, fl)
+		c.gf.ensureDockerDaemon()
+
+		err := prebuild(proj)
+		if err != nil {
+			xlog.Fatal("failed to prebuild %v: %v", proj.pathName(), err)
+		}
+		xlog.Success("prebuilt %v", proj.pathName())
+		return
+	}
+
+	conf := c.gf.config()
+	if len(conf.Prebuilds) == 0 {
+		xlog.Fatal("no prebuilds in the config, see prebuilds in %v", c.gf.configPath)
+	}
+	if !c.watch {
+		err := c.prebuildAll(conf.Prebuilds)
+		if err != nil {
+			xlog.Fatal("%v", err)
+		}
+		return
+	}
+
+	sch, err := parseSchedule(conf.prebuildSchedule())
+	if err != nil {
+		xlog.Fatal("%v", err)
+	}
+	ctx, cancel := withInterrupt(context.Background())
+	defer cancel()
+	for {
+		next := sch.next(time.Now())
+		if next.IsZero() {
+			xlog.Fatal("prebuild_schedule %q never runs", conf.prebuildSchedule())
+		}
+		xlog.Info("prebuilding %v at %v", strings.Join(conf.Prebuilds, ", "), next.Format(time.RFC1123))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(next)):
+		}
+		err = c.prebuildAll(conf.Prebuilds)
+		if err != nil {
+			xlog.Error("%v", err)
+		}
+	}
+}
+
+// prebuildAll prebuilds projects one after the other, each with its own
+// sail prebuild, as the project picks the Docker daemon.
+func (c *prebuildcmd) prebuildAll(projects []string) error {
+	var failed []string
+	for _, name := range projects {
+		args := []string{"--config", c.gf.configPath}
+		if c.gf.verbose {
+			args = append(args, "-v")
+		}
+		cmd := sailCmd(append(args, "prebuild", name)...)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		err := cmd.Run()
+		if err != nil {
+			failed = append(failed, name)
+		}
+	}
+	if len(failed) > 0 {
+		return xerrors.Errorf("failed to prebuild %v", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// prebuild rebuilds the image of proj and its default hat, pulling their base
+// images. The project is cloned if it doesn't exist yet.
+func prebuild(proj *project) error {
+	_, err := proj.lock()
+	if err != nil {
+		return xerrors.Errorf("failed to lock project: %w", err)
+	}
+	err = proj.ensureDir()
+	if err != nil {
+		return err
+	}
+
+	proj.buildOpts.pull = true
+	image, ok, err := proj.buildImage()
+	if err != nil {
+		return xerrors.Errorf("failed to build image: %w", err)
+	}
+	if !ok {
+		image = proj.defaultRepoImage()
+		ctx, cancel := context.WithTimeout(context.Background(), proj.conf.timeouts(false).pull)
+		defer cancel()
+		var pulled string
+		pulled, _, err = proj.pullImage(ctx, image)
+		if err != nil {
+			return xerrors.Errorf("failed to pull %v: %w", image, err)
+		}
+		image = pulled
+	}
+
+	b := new(runcmd).hatBuilder(proj, image)
+	if b.hatPath != "" {
+		image, err = b.applyHat()
+		if err != nil {
+			return xerrors.Errorf("failed to apply hat: %w", err)
+		}
+	}
+
+	emitEvent(eventPrebuilt, proj.cntName(), map[string]string{"image": image})
+	return nil
+}
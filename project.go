@@ -3,19 +3,25 @@ package main
 import (
 	"context"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"regexp"
 	"strings"
 	"time"
 
 	"github.com/docker/docker/api/types"
 	"golang.org/x/xerrors"
 
-	"go.coder.com/flog"
 	"go.coder.com/sail/internal/browserapp"
 	"go.coder.com/sail/internal/codeserver"
+	"go.coder.com/sail/internal/devcontainer"
 	"go.coder.com/sail/internal/dockutil"
+	"go.coder.com/sail/internal/flock"
 	"go.coder.com/sail/internal/xexec"
+	"go.coder.com/sail/internal/xlog"
 )
 
 type projectStatus string
@@ -29,10 +35,26 @@ const (
 type project struct {
 	conf config
 	repo repo
+
+	// name overrides the repository path when naming the container
+	// and the project directory. It's empty unless the user explicitly
+	// provided one.
+	name string
+
+	// buildOpts are used when building the project's image.
+	buildOpts buildOpts
+
+	// pinned are the images of the project's .sail/lock that its image was
+	// built or pulled from.
+	pinned []pinnedImage
 }
 
+// pathName returns the org-qualified name of the project, e.g. cdr/sail.
 func (p *project) pathName() string {
-	return strings.TrimSuffix(p.repo.Path, ".git")
+	if p.name != "" {
+		return p.name
+	}
+	return strings.TrimPrefix(strings.TrimSuffix(p.repo.Path, ".git"), "/")
 }
 
 func (p *project) localDir() string {
@@ -41,8 +63,7 @@ func (p *project) localDir() string {
 		panic(err)
 	}
 
-	path := strings.TrimSuffix(p.repo.Path, ".git")
-	projectDir := filepath.Join(p.conf.ProjectRoot, path)
+	projectDir := filepath.Join(p.conf.ProjectRoot, p.pathName())
 
 	projectDir = resolvePath(hostHomeDir, projectDir)
 	return projectDir
@@ -55,7 +76,7 @@ func (p *project) dockerfilePath() string {
 // clone clones a git repository to dir.
 func clone(r repo, dir string) error {
 	uri := r.CloneURI()
-	cmd := xexec.Fmt("git clone %v %v", uri, dir)
+	cmd := exec.Command("git", "clone", uri, dir)
 	xexec.Attach(cmd)
 
 	err := cmd.Run()
@@ -76,7 +97,7 @@ func (p *project) cntExists() (bool, error) {
 	cli := dockerClient()
 	defer cli.Close()
 
-	_, err := cli.ContainerInspect(context.Background(), p.cntName())
+	_, err := inspectContainer(context.Background(), cli, p.cntName())
 	if err != nil {
 		if isContainerNotFoundError(err) {
 			return false, nil
@@ -90,7 +111,7 @@ func (p *project) running() (bool, error) {
 	cli := dockerClient()
 	defer cli.Close()
 
-	cnt, err := cli.ContainerInspect(context.Background(), p.cntName())
+	cnt, err := inspectContainer(context.Background(), cli, p.cntName())
 	if err != nil {
 		return false, xerrors.Errorf("failed to get container %v: %v", p.cntName(), err)
 	}
@@ -100,10 +121,10 @@ func (p *project) running() (bool, error) {
 func (p *project) requireRunning() {
 	running, err := p.running()
 	if err != nil {
-		flog.Fatal("%v", err)
+		xlog.Fatal("%v", err)
 	}
 	if !running {
-		flog.Fatal("container %v is not running", p.cntName())
+		xlog.Fatal("container %v is not running", p.cntName())
 	}
 }
 
@@ -132,31 +153,69 @@ func (p *project) buildImage() (string, bool, error) {
 	const relPath = ".sail/Dockerfile"
 	path := filepath.Join(p.localDir(), relPath)
 
+	imageID := p.imageName()
+
 	_, err := os.Stat(path)
 	if err != nil {
 		if !os.IsNotExist(err) {
 			return "", false, xerrors.Errorf("failed to stat %v: %w", path, err)
 		}
 
+		if p.buildOpts.frozen && p.definesImage() {
+			return "", false, xerrors.New("only images built from .sail/Dockerfile can be locked")
+		}
+
+		// Fall back to other ways of defining the environment so repos
+		// setup for other tools work as is.
+		for _, build := range []func(string) (string, bool, error){
+			p.buildDevcontainerImage,
+			p.buildNixImage,
+		} {
+			image, ok, err := build(imageID)
+			if err != nil || ok {
+				return image, ok, err
+			}
+		}
 		return "", false, nil
 	}
 
-	// Docker image names must be completely lowercase.
-	imageID := strings.ToLower(p.repo.DockerName())
+	ctx, cancel := context.WithTimeout(context.Background(), p.conf.timeouts(false).build)
+	defer cancel()
 
-	cmdStr := fmt.Sprintf("docker build --network=host -t %v -f %v %v --label %v=%v",
-		imageID, path, p.localDir(), baseImageLabel, imageID,
-	)
-	flog.Info("running %v", cmdStr)
-	cmd := xexec.Fmt(cmdStr)
-	xexec.Attach(cmd)
-	err = cmd.Run()
+	hash, err := dockerfileHash(path)
+	if err != nil {
+		return "", false, err
+	}
+	args := []string{
+		p.buildOpts.network(), "-t", imageID,
+		"--label", baseImageLabel + "=" + imageID,
+		"--label", dockerfileHashLabel + "=" + hash,
+	}
+	err = p.buildLocked(ctx, path, args)
+	if err != nil {
+		return "", false, err
+	}
+
+	err = validateImageLabels(imageID)
 	if err != nil {
-		return "", false, xerrors.Errorf("failed to build: %w", err)
+		return "", false, xerrors.Errorf("invalid label in %v: %w", relPath, err)
 	}
 	return imageID, true, nil
 }
 
+// imageName is the name of the image built from the project's repo.
+func (p *project) imageName() string {
+	// Docker image names must be completely lowercase.
+	return strings.ToLower(p.cntName())
+}
+
+// definesImage reports whether the project's repo defines its own image,
+// which buildImage builds.
+func (p *project) definesImage() bool {
+	_, err := os.Stat(p.dockerfilePath())
+	return err == nil || devcontainer.Find(p.localDir()) != "" || findNixFile(p.localDir()) != ""
+}
+
 func fmtImage(img string) string {
 	return fmt.Sprintf("codercom/ubuntu-dev-%s:latest", img)
 }
@@ -165,8 +224,62 @@ func fmtImage(img string) string {
 // repo's language. If the repo language isn't able to be determined, this
 // returns the default image from the sail config.
 func (p *project) defaultRepoImage() string {
-	lang := p.repo.language()
+	image, _ := p.inferImage()
+	return image
+}
+
+// stackFiles are files at the root of a project that tell its language, in
+// order of precedence.
+var stackFiles = []struct {
+	name string
+	lang string
+}{
+	{"go.mod", "go"},
+	{"package.json", "javascript"},
+	{"requirements.txt", "python"},
+	{"pyproject.toml", "python"},
+	{"setup.py", "python"},
+	{"Gemfile", "ruby"},
+	{"pom.xml", "java"},
+	{"build.gradle", "java"},
+	{"CMakeLists.txt", "c++"},
+}
+
+// detectStack returns the language of the project in dir, and the file it
+// was detected from. Both are empty if there's none of stackFiles.
+func detectStack(dir string) (lang, file string) {
+	for _, f := range stackFiles {
+		_, err := os.Stat(filepath.Join(dir, f.name))
+		if err == nil {
+			return f.lang, f.name
+		}
+	}
+	return "", ""
+}
+
+// inferImage returns the default image of the project, picked from the
+// files of the project or else the language GitHub reports for the repo, and
+// the reason it was picked. The reason is empty if the image is the default
+// image of the config.
+func (p *project) inferImage() (image, reason string) {
+	lang, file := detectStack(p.localDir())
+	if lang != "" {
+		reason = "its " + file
+	} else {
+		lang = p.repo.language()
+		reason = "its GitHub language " + lang
+	}
+
+	image = languageImage(lang)
+	if image == "" {
+		return p.conf.DefaultImage, ""
+	}
+	return image, reason
+}
 
+// languageImage returns the curated image for development with lang, or ""
+// if there's none.
+func languageImage(lang string) string {
 	switch strings.ToLower(lang) {
 	case "go":
 		return fmtImage("go")
@@ -181,21 +294,148 @@ func (p *project) defaultRepoImage() string {
 	case "ruby":
 		return fmtImage("ruby2.6")
 	default:
-		return p.conf.DefaultImage
+		return ""
 	}
 }
 
-func ensureImage(image string) error {
-	flog.Info("ensuring image %v exists", image)
+// ensureImage pulls image for platform, or the daemon's platform if it's
+// empty. It reports whether the image had to be downloaded because it didn't
+// exist locally.
+func ensureImage(ctx context.Context, image, platform string) (pulled bool, _ error) {
+	xlog.Info("ensuring image %v exists", image)
+
+	cli := dockerClient()
+	defer cli.Close()
 
-	cmd := xexec.Fmt("docker pull %s", image)
+	_, _, err := cli.ImageInspectWithRaw(ctx, image)
+	pulled = err != nil
+
+	args := []string{"pull"}
+	if platform != "" {
+		args = append(args, "--platform", platform)
+	}
+	cmd := exec.CommandContext(ctx, "docker", append(args, image)...)
 	xexec.Attach(cmd)
+	stderr := &tailWriter{max: 4096}
+	cmd.Stderr = io.MultiWriter(os.Stderr, stderr)
+
+	err = cmd.Run()
+	if err != nil {
+		if ctx.Err() != nil {
+			return pulled, ctx.Err()
+		}
+		return pulled, checkAuthError(err, stderr.String(), imageRegistry(image))
+	}
+	return pulled, nil
+}
+
+// lock acquires the project's lock, waiting for any other sail invocation
+// operating on the same project to release it.
+func (p *project) lock() (*flock.Lock, error) {
+	return lockContainer(p.cntName())
+}
 
-	return cmd.Run()
+// lockContainer acquires the lock for the container named cntName.
+// The lock is held until it's unlocked or the process exits.
+func lockContainer(cntName string) (*flock.Lock, error) {
+	path := filepath.Join(metaRoot(), "locks", cntName+".lock")
+
+	l, err := flock.TryLock(path)
+	if err == nil {
+		return l, nil
+	}
+	if !xerrors.Is(err, flock.ErrLocked) {
+		return nil, err
+	}
+
+	xlog.Info("waiting for another sail process to finish with %v", cntName)
+	return flock.New(path)
 }
 
 func (p *project) cntName() string {
-	return p.repo.DockerName()
+	return toDockerName(p.pathName())
+}
+
+// remoteURI returns the clone URI of the project's repository, or the
+// empty string if the project was referenced by its local path.
+func (p *project) remoteURI() string {
+	if p.repo.Host == "" {
+		return ""
+	}
+	return p.repo.CloneURI()
+}
+
+// validProjectName matches names that can be used both as a directory
+// under the project root and, once converted, as a Docker container name.
+var validProjectName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*(/[a-zA-Z0-9][a-zA-Z0-9_.-]*)?$`)
+
+// validateProjectName ensures that name is usable as a project name.
+func validateProjectName(name string) error {
+	if !validProjectName.MatchString(name) {
+		return xerrors.Errorf("invalid project name %q: must be of form <name> or <org>/<name> and only contain [a-zA-Z0-9_.-]", name)
+	}
+	return nil
+}
+
+// checkCollision ensures that neither the project's container nor its
+// project directory belong to a different repository. This happens when
+// two repos share the same org-qualified path, e.g. github.com/cdr/api
+// and gitlab.com/cdr/api.
+func (p *project) checkCollision() error {
+	// Projects referenced by their local path have no remote to compare against.
+	remote := p.remoteURI()
+	if remote == "" {
+		return nil
+	}
+
+	cli := dockerClient()
+	defer cli.Close()
+
+	cnt, err := inspectContainer(context.Background(), cli, p.cntName())
+	if err != nil && !isContainerNotFoundError(err) {
+		return xerrors.Errorf("failed to inspect %v: %w", p.cntName(), err)
+	}
+	if err == nil {
+		cntRepo := cnt.Config.Labels[repoLabel]
+		if cntRepo != "" && !sameRemote(cntRepo, remote) {
+			return xerrors.Errorf("container %v already exists for %v, use --name to choose a different name", p.cntName(), cntRepo)
+		}
+	}
+
+	out, err := exec.Command("git", "-C", p.localDir(), "config", "--get", "remote.origin.url").Output()
+	if err != nil {
+		// Either the directory doesn't exist yet, or it isn't a git repo
+		// with an origin. Neither are collisions.
+		return nil
+	}
+	dirRepo := strings.TrimSpace(string(out))
+	if dirRepo != "" && !sameRemote(dirRepo, remote) {
+		return xerrors.Errorf("project directory %v is already a clone of %v, use --name to choose a different name", p.localDir(), dirRepo)
+	}
+
+	return nil
+}
+
+// sameRemote reports whether two git remotes point to the same repository,
+// ignoring differences in schema, user and the .git suffix.
+func sameRemote(a, b string) bool {
+	return normalizeRemote(a) == normalizeRemote(b)
+}
+
+// normalizeRemote reduces a git remote to <host>/<path>.
+func normalizeRemote(remote string) string {
+	if i := strings.Index(remote, "://"); i >= 0 {
+		remote = remote[i+3:]
+	} else {
+		// scp-like syntax, e.g. git@github.com:cdr/sail.git.
+		remote = strings.Replace(remote, ":", "/", 1)
+	}
+	if i := strings.Index(remote, "@"); i >= 0 {
+		remote = remote[i+1:]
+	}
+	remote = strings.TrimSuffix(remote, "/")
+	remote = strings.TrimSuffix(remote, ".git")
+	return strings.ToLower(remote)
 }
 
 // containerDir returns the directory of which the project is mounted within the container.
@@ -203,7 +443,7 @@ func (p *project) containerDir() (string, error) {
 	client := dockerClient()
 	defer client.Close()
 
-	cnt, err := client.ContainerInspect(context.Background(), p.cntName())
+	cnt, err := inspectContainer(context.Background(), client, p.cntName())
 	if err != nil {
 		return "", err
 	}
@@ -223,7 +463,7 @@ func proxyURL(cntName string) (string, error) {
 	client := dockerClient()
 	defer client.Close()
 
-	cnt, err := client.ContainerInspect(context.Background(), cntName)
+	cnt, err := inspectContainer(context.Background(), client, cntName)
 	if err != nil {
 		return "", err
 	}
@@ -235,7 +475,7 @@ func proxyURL(cntName string) (string, error) {
 }
 
 func (p *project) readCodeServerLog() ([]byte, error) {
-	cmd := xexec.Fmt("docker logs %v", p.cntName())
+	cmd := exec.Command("docker", "logs", p.cntName())
 
 	out, err := cmd.CombinedOutput()
 	if err != nil {
@@ -246,15 +486,15 @@ func (p *project) readCodeServerLog() ([]byte, error) {
 }
 
 // waitOnline waits until code-server has bound to it's port.
-func (p *project) waitOnline() error {
+func (p *project) waitOnline(ctx context.Context) error {
 	cli := dockerClient()
 	defer cli.Close()
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
 	defer cancel()
 
 	for ctx.Err() == nil {
-		cnt, err := cli.ContainerInspect(ctx, p.cntName())
+		cnt, err := inspectContainer(ctx, cli, p.cntName())
 		if err != nil {
 			return err
 		}
@@ -273,32 +513,81 @@ func (p *project) waitOnline() error {
 	return ctx.Err()
 }
 
-func (p *project) open() error {
+// start starts the project's container and returns its URL.
+func (p *project) start() (string, error) {
 	cli := dockerClient()
 	defer cli.Close()
 
 	err := cli.ContainerStart(context.Background(), p.cntName(), types.ContainerStartOptions{})
 	if err != nil {
-		return xerrors.Errorf("failed to start container: %w", err)
+		return "", xerrors.Errorf("failed to start container: %w", err)
+	}
+
+	err = markUsed(p.cntName())
+	if err != nil {
+		xlog.Error("failed to record project use: %v", err)
 	}
 
-	u, err := p.proxyURL()
+	return p.proxyURL()
+}
+
+// lastUsedPath is the file whose modification time records when the
+// container named cntName was last opened or shelled into.
+func lastUsedPath(cntName string) string {
+	return filepath.Join(metaRoot(), cntName, "last-used")
+}
+
+// markUsed records that the container named cntName was just used.
+func markUsed(cntName string) error {
+	path := lastUsedPath(cntName)
+	err := os.MkdirAll(filepath.Dir(path), 0750)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(path, nil, 0640)
 	if err != nil {
 		return err
 	}
+	now := time.Now()
+	return os.Chtimes(path, now, now)
+}
 
-	if os.Getenv("DISPLAY") == "" {
-		flog.Info("please visit %v", u)
-		return nil
+// lastUsed returns when the container named cntName was last used,
+// or the zero time if it never was.
+func lastUsed(cntName string) time.Time {
+	fi, err := os.Stat(lastUsedPath(cntName))
+	if err != nil {
+		return time.Time{}
 	}
+	return fi.ModTime()
+}
 
-	flog.Info("opening %v", u)
+// browserProfileDir is where the project's isolated browser profile is kept.
+func (p *project) browserProfileDir() string {
+	return filepath.Join(metaRoot(), p.cntName(), "browser-profile")
+}
 
-	err = browserapp.Open(u)
+func (p *project) open() error {
+	u, err := p.start()
 	if err != nil {
 		return err
 	}
-	return nil
+	return p.openURL(u)
+}
+
+// openURL opens the project's URL u in the configured browser.
+func (p *project) openURL(u string) error {
+	if os.Getenv("DISPLAY") == "" && p.conf.Browser == "" {
+		xlog.Info("please visit %v", u)
+		return nil
+	}
+
+	xlog.Info("opening %v", u)
+
+	if p.conf.IsolatedProfiles && p.conf.Browser == "" {
+		return browserapp.OpenApp(u, p.browserProfileDir(), p.pathName())
+	}
+	return browserapp.OpenWith(p.conf.Browser, u)
 }
 
 func (p *project) delete() error {
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"testing"
@@ -105,3 +106,50 @@ func Test_project(t *testing.T) {
 		})
 	}
 }
+
+func Test_sameRemote(t *testing.T) {
+	var tests = []struct {
+		a, b string
+		exp  bool
+	}{
+		{"ssh://git@github.com/cdr/sail.git", "https://github.com/cdr/sail", true},
+		{"git@github.com:cdr/sail.git", "ssh://git@github.com/cdr/sail.git", true},
+		{"https://github.com/cdr/api.git", "https://gitlab.com/cdr/api.git", false},
+		{"https://github.com/cdr/api.git", "https://github.com/coder/api.git", false},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.exp, sameRemote(test.a, test.b), "%v == %v", test.a, test.b)
+	}
+}
+
+func Test_validateProjectName(t *testing.T) {
+	for _, name := range []string{"api", "cdr/api", "cdr/api-2", "cdr/api.v2"} {
+		assert.NoError(t, validateProjectName(name), name)
+	}
+	for _, name := range []string{"", "/api", "cdr/", "a/b/c", "cdr/api 2", "-api"} {
+		assert.Error(t, validateProjectName(name), name)
+	}
+}
+
+func Test_detectStack(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sail-stack")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	lang, file := detectStack(dir)
+	assert.Equal(t, "", lang)
+	assert.Equal(t, "", file)
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "requirements.txt"), nil, 0644))
+	lang, file = detectStack(dir)
+	assert.Equal(t, "python", lang)
+	assert.Equal(t, "requirements.txt", file)
+
+	// go.mod takes precedence, e.g. over the package.json of a frontend.
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "go.mod"), nil, 0644))
+	lang, _ = detectStack(dir)
+	assert.Equal(t, "go", lang)
+	assert.Equal(t, fmtImage("go"), languageImage(lang))
+	assert.Equal(t, "", languageImage("haskell"))
+}
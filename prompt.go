@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/mattn/go-isatty"
+)
+
+// confirm asks the user a yes/no question on the terminal, defaulting to yes.
+// If sail isn't attached to a terminal, e.g. when started by the browser
+// extension, the question is answered with the default.
+func confirm(question string) bool {
+	return ask(question, true)
+}
+
+// confirmDestructive is confirm for questions whose yes loses state, which
+// default to no. Without a terminal, they're never answered with yes.
+func confirmDestructive(question string) bool {
+	return ask(question, false)
+}
+
+func ask(question string, def bool) bool {
+	if !isatty.IsTerminal(os.Stdin.Fd()) || !isatty.IsTerminal(os.Stderr.Fd()) {
+		return def
+	}
+
+	choices := "[Y/n]"
+	if !def {
+		choices = "[y/N]"
+	}
+	fmt.Fprintf(os.Stderr, "%v %v ", question, choices)
+
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return def
+	}
+
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	case "n", "no":
+		return false
+	default:
+		return def
+	}
+}
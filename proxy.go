@@ -0,0 +1,50 @@
+package main
+
+import (
+	"os"
+	"strings"
+)
+
+// proxyEnvVars are the environment variables that configure proxies. Docker
+// builds accept them as build arguments without the Dockerfile declaring
+// them, and leaves them out of the image's history.
+var proxyEnvVars = []string{
+	"HTTP_PROXY", "http_proxy",
+	"HTTPS_PROXY", "https_proxy",
+	"FTP_PROXY", "ftp_proxy",
+	"NO_PROXY", "no_proxy",
+	"ALL_PROXY", "all_proxy",
+}
+
+// forwardProxyLabel records that the host's proxy settings are forwarded
+// into the container.
+const forwardProxyLabel = sailLabel + ".forward_proxy"
+
+// proxyEnv returns the proxy settings of the host, of form KEY=VAL.
+func proxyEnv() []string {
+	var env []string
+	for _, k := range proxyEnvVars {
+		if v, ok := os.LookupEnv(k); ok && v != "" {
+			env = append(env, k+"="+v)
+		}
+	}
+	return env
+}
+
+// proxyBuildArgs returns the docker build arguments passing the host's proxy
+// settings into builds, skipping those set explicitly in buildArgs.
+func proxyBuildArgs(buildArgs []string) []string {
+	var args []string
+outer:
+	for _, kv := range proxyEnv() {
+		k := strings.SplitN(kv, "=", 2)[0]
+		for _, a := range buildArgs {
+			if strings.SplitN(a, "=", 2)[0] == k {
+				continue outer
+			}
+		}
+		// The value is taken from the environment, so it isn't logged.
+		args = append(args, "--build-arg", k)
+	}
+	return args
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_proxyBuildArgs(t *testing.T) {
+	for _, k := range proxyEnvVars {
+		v, ok := os.LookupEnv(k)
+		if ok {
+			defer os.Setenv(k, v)
+		} else {
+			defer os.Unsetenv(k)
+		}
+		os.Unsetenv(k)
+	}
+
+	assert.Empty(t, proxyBuildArgs(nil))
+
+	os.Setenv("HTTPS_PROXY", "http://proxy:3128")
+	os.Setenv("no_proxy", "localhost,.corp")
+	os.Setenv("HTTP_PROXY", "")
+	assert.Equal(t, []string{"HTTPS_PROXY=http://proxy:3128", "no_proxy=localhost,.corp"}, proxyEnv())
+	assert.Equal(t, []string{"--build-arg", "HTTPS_PROXY", "--build-arg", "no_proxy"}, proxyBuildArgs(nil))
+	assert.Equal(t, []string{"--build-arg", "no_proxy"}, proxyBuildArgs([]string{"HTTPS_PROXY=http://other:3128"}))
+}
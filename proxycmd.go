@@ -17,18 +17,20 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/docker/docker/api/types"
 	"golang.org/x/xerrors"
 	"nhooyr.io/websocket"
 
 	"go.coder.com/cli"
-	"go.coder.com/flog"
+	"go.coder.com/sail/internal/xlog"
 )
 
-func codeServerProxy(w http.ResponseWriter, r *http.Request, port string) {
+func codeServerProxy(w http.ResponseWriter, r *http.Request, e *codeServerEndpoint) {
 	rp := httputil.NewSingleHostReverseProxy(&url.URL{
 		Scheme: "http",
-		Host:   "localhost:" + port,
+		Host:   e.host(),
 	})
+	rp.Transport = e.transport
 	rp.ModifyResponse = func(resp *http.Response) error {
 		if r.URL.Path != "/" || resp.Header.Get("Upgrade") == "websocket" {
 			return nil
@@ -75,18 +77,128 @@ type proxy struct {
 	url        string
 	cntName    string
 	refreshing int64
+	// log attaches the container to the proxy's messages.
+	log *xlog.Logger
+
+	mu          sync.Mutex
+	endpoint    *codeServerEndpoint
+	endpointErr error
+
+	// idleTimeout is how long the environment may be idle before it's
+	// stopped. Zero disables it.
+	idleTimeout time.Duration
+	// active counts the requests in flight. Open code-server windows hold
+	// a WebSocket, so they keep the environment active.
+	active int64
+
+	activityMu   sync.Mutex
+	lastActivity time.Time
+	// asleep is set once the environment was stopped for being idle.
+	// The next request starts it again.
+	asleep bool
+	// wakeMu serializes stopping and starting the environment, so requests
+	// arriving together start it once. activityMu isn't held meanwhile, so
+	// the activity of other requests is still recorded.
+	wakeMu sync.Mutex
+}
+
+// track records the activity of requests handled by h.
+func (p *proxy) track(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&p.active, 1)
+		defer func() {
+			atomic.AddInt64(&p.active, -1)
+			p.touch()
+		}()
+		p.touch()
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+func (p *proxy) touch() {
+	p.activityMu.Lock()
+	p.lastActivity = time.Now()
+	p.activityMu.Unlock()
+}
+
+func (p *proxy) isAsleep() bool {
+	p.activityMu.Lock()
+	defer p.activityMu.Unlock()
+	return p.asleep
+}
+
+// idle reports whether the environment has been idle for longer than the
+// idle timeout. Shells in the container count as activity.
+func (p *proxy) idle(cnt types.ContainerJSON) bool {
+	if p.idleTimeout <= 0 || atomic.LoadInt64(&p.active) > 0 || len(cnt.ExecIDs) > 0 {
+		return false
+	}
+
+	p.activityMu.Lock()
+	defer p.activityMu.Unlock()
+	return !p.asleep && time.Since(p.lastActivity) > p.idleTimeout
+}
+
+// sleep stops the idle environment.
+func (p *proxy) sleep() error {
+	cli := dockerClient()
+	defer cli.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	p.wakeMu.Lock()
+	defer p.wakeMu.Unlock()
+	p.activityMu.Lock()
+	p.asleep = true
+	p.activityMu.Unlock()
+
+	p.log.Info("stopping %v after being idle for %v", p.cntName, p.idleTimeout)
+	err := stopContainer(ctx, cli, p.cntName)
+	if err != nil {
+		return err
+	}
+	emitEvent(eventIdle, p.cntName, map[string]string{"idle_timeout": p.idleTimeout.String()})
+	return nil
+}
+
+// wake starts the environment if it was stopped for being idle.
+func (p *proxy) wake() error {
+	p.wakeMu.Lock()
+	defer p.wakeMu.Unlock()
+	if !p.isAsleep() {
+		return nil
+	}
+
+	cli := dockerClient()
+	defer cli.Close()
 
-	mu             sync.Mutex
-	codeServerPort string
-	portErr        error
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	p.log.Info("starting idle %v", p.cntName)
+	err := cli.ContainerStart(ctx, p.cntName, types.ContainerStartOptions{})
+	if err != nil {
+		return xerrors.Errorf("failed to start container: %w", err)
+	}
+	p.refreshPort()
+
+	p.activityMu.Lock()
+	p.asleep = false
+	p.lastActivity = time.Now()
+	p.activityMu.Unlock()
+	return nil
 }
 
-func (p *proxy) getCodeServerPort() (string, error) {
+func (p *proxy) getEndpoint() (*codeServerEndpoint, error) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	return p.codeServerPort, p.portErr
+	return p.endpoint, p.endpointErr
 }
 
+// refreshPort finds where code-server listens again, which changes when
+// the container is recreated or code-server restarts.
 func (p *proxy) refreshPort() {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
 	defer cancel()
@@ -95,10 +207,10 @@ func (p *proxy) refreshPort() {
 	defer atomic.StoreInt64(&p.refreshing, 0)
 
 	for {
-		port, err := codeServerPort(p.cntName)
+		e, err := codeServerEndpointOf(p.cntName)
 		p.mu.Lock()
-		p.codeServerPort = port
-		p.portErr = err
+		p.endpoint = e
+		p.endpointErr = err
 		p.mu.Unlock()
 		if err == nil {
 			return
@@ -106,7 +218,7 @@ func (p *proxy) refreshPort() {
 
 		time.Sleep(time.Millisecond * 100)
 		if ctx.Err() != nil {
-			flog.Fatal("failed to refresh code-server port: %v", err)
+			p.log.Fatal("failed to refresh code-server port: %v", err)
 		}
 	}
 }
@@ -128,9 +240,27 @@ func (p *proxy) shouldDie() error {
 	}
 
 	if cnt.State.Status != "running" {
+		// The proxy outlives environments stopped for being idle,
+		// so it can start them again.
+		if p.isAsleep() {
+			return nil
+		}
 		return xerrors.Errorf("container is not running: %v", cnt.State.Status)
 	}
 
+	if p.isAsleep() {
+		// It was started by something else, e.g. sail open.
+		p.wake()
+		return nil
+	}
+
+	if p.idle(cnt) {
+		err = p.sleep()
+		if err != nil {
+			p.log.Error("failed to stop idle container: %v", err)
+		}
+	}
+
 	return nil
 }
 
@@ -142,7 +272,7 @@ func (p *proxy) gc() {
 	for range t.C {
 		err := p.shouldDie()
 		if err != nil {
-			flog.Error("%v", err)
+			p.log.Error("%v", err)
 			errs++
 		} else {
 			errs = 0
@@ -150,7 +280,7 @@ func (p *proxy) gc() {
 		// On the 2nd error we fatal. We wait till the 2nd in case
 		// the container is being restarted.
 		if errs == 2 {
-			flog.Fatal("terminating due to too many should die errors")
+			p.log.Fatal("terminating due to too many should die errors")
 		}
 	}
 }
@@ -181,10 +311,43 @@ func (p *proxy) reload(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// restart restarts code-server, and refreshes its port.
+func (p *proxy) restart(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	cli := dockerClient()
+	defer cli.Close()
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Minute)
+	defer cancel()
+
+	inPlace, err := restartCodeServer(ctx, cli, p.cntName, r.URL.Query().Get("full") == "true")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	p.refreshPort()
+
+	if inPlace {
+		fmt.Fprintf(w, "restarted code-server in %v\n", toSailName(p.cntName))
+		return
+	}
+	fmt.Fprintf(w, "restarted %v\n", toSailName(p.cntName))
+}
+
 func (p *proxy) proxy(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), time.Second*45)
 	defer cancel()
 
+	err := p.wake()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to start idle environment\n%v", err), http.StatusInternalServerError)
+		return
+	}
+
 	// Wait until the port refresh goroutine is done.
 	for {
 		if atomic.LoadInt64(&p.refreshing) == 0 {
@@ -203,24 +366,41 @@ please try to reload soon
 		}
 	}
 
-	port, portErr := p.getCodeServerPort()
-	if portErr != nil {
+	e, endpointErr := p.getEndpoint()
+	if endpointErr != nil {
 		msg := fmt.Sprintf(`failed to get code server port
 %v
 
 please try to reload soon
-`, portErr)
+`, endpointErr)
 		http.Error(w, msg, http.StatusInternalServerError)
 		return
 	}
-	codeServerProxy(w, r, port)
+	codeServerProxy(w, r, e)
 }
 
 type proxycmd struct {
+	idleTimeout time.Duration
+	port        string
+}
+
+// listen listens on the proxy's port of the host's loopback, or on a free
+// one if it's taken.
+func (c *proxycmd) listen() (net.Listener, error) {
+	port := c.port
+	if port == "" {
+		port = "0"
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:"+port)
+	if err == nil || port == "0" {
+		return l, err
+	}
+	xlog.Warn("port %v is taken, the environment's URL changes: %v", port, err)
+	return net.Listen("tcp", "127.0.0.1:0")
 }
 
 func (c *proxycmd) proxy(cntName string) (addr string, err error) {
-	l, err := net.Listen("tcp", "localhost:0")
+	l, err := c.listen()
 	if err != nil {
 		return "", xerrors.Errorf("failed to listen: %w", err)
 	}
@@ -231,11 +411,20 @@ func (c *proxycmd) proxy(cntName string) (addr string, err error) {
 	}()
 
 	p := &proxy{
-		url:     "http://" + l.Addr().String(),
-		cntName: cntName,
+		url:          "http://" + l.Addr().String(),
+		cntName:      cntName,
+		log:          xlog.With(xlog.Fields{"container": cntName}),
+		idleTimeout:  c.idleTimeout,
+		lastActivity: time.Now(),
 	}
 	go p.refreshPort()
 	go p.gc()
+	go func() {
+		err := serveGitCredentials(p.log, cntName)
+		if err != nil {
+			p.log.Error("failed to serve git credentials: %v", err)
+		}
+	}()
 
 	go func() {
 		m := http.NewServeMux()
@@ -246,11 +435,14 @@ func (c *proxycmd) proxy(cntName string) (addr string, err error) {
 			w.Write([]byte("ok\n"))
 		})
 		m.HandleFunc("/sail/api/v1/reload", p.reload)
-		m.HandleFunc("/", p.proxy)
+		m.HandleFunc("/sail/api/v1/restart", p.restart)
+		m.HandleFunc("/sail/api/v1/upload", p.upload)
+		m.HandleFunc("/sail/api/v1/clipboard", p.clipboard)
+		m.Handle("/", p.track(http.HandlerFunc(p.proxy)))
 		http.Serve(l, m)
 	}()
 
-	flog.Info("listening on %v", p.url)
+	p.log.Info("listening on %v", p.url)
 
 	return p.url, nil
 }
@@ -264,10 +456,15 @@ func (c *proxycmd) Spec() cli.CommandSpec {
 	}
 }
 
+func (c *proxycmd) RegisterFlags(fl *flag.FlagSet) {
+	fl.DurationVar(&c.idleTimeout, "idle-timeout", 0, "Stop the container once it's idle for this long.")
+	fl.StringVar(&c.port, "port", "0", "Port to listen on, a free one is used if it's 0 or taken.")
+}
+
 func (c *proxycmd) Run(fl *flag.FlagSet) {
 	u, err := c.proxy(fl.Arg(0))
 	if err != nil {
-		flog.Fatal("failed to proxy: %v", err)
+		xlog.Fatal("failed to proxy: %v", err)
 	}
 	fmt.Println(u)
 	select {}
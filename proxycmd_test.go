@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_proxyIdle(t *testing.T) {
+	cnt := types.ContainerJSON{ContainerJSONBase: &types.ContainerJSONBase{}}
+	p := &proxy{
+		idleTimeout:  time.Minute,
+		lastActivity: time.Now().Add(-time.Minute * 2),
+	}
+	require.True(t, p.idle(cnt))
+
+	shell := types.ContainerJSON{ContainerJSONBase: &types.ContainerJSONBase{ExecIDs: []string{"exec"}}}
+	require.False(t, p.idle(shell), "shells keep the environment active")
+
+	// Requests in flight, like code-server's WebSocket, keep it active.
+	inFlight := make(chan struct{})
+	done := make(chan struct{})
+	served := make(chan struct{})
+	h := p.track(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(inFlight)
+		<-done
+	}))
+	go func() {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		close(served)
+	}()
+	<-inFlight
+	require.False(t, p.idle(cnt))
+	close(done)
+	<-served
+
+	require.False(t, p.idle(cnt), "the request was just active")
+
+	p.idleTimeout = 0
+	p.lastActivity = time.Time{}
+	require.False(t, p.idle(cnt), "idle timeout is disabled")
+}
+
+func Test_proxyWakeDoesntBlockActivity(t *testing.T) {
+	p := &proxy{asleep: true}
+
+	// While the environment is starting, requests are still tracked.
+	p.wakeMu.Lock()
+	defer p.wakeMu.Unlock()
+	served := make(chan struct{})
+	go func() {
+		p.track(http.NotFoundHandler()).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		close(served)
+	}()
+	select {
+	case <-served:
+	case <-time.After(time.Second * 5):
+		t.Fatal("the request blocked on the wake")
+	}
+	require.True(t, p.isAsleep())
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+
+	"go.coder.com/cli"
+	"go.coder.com/sail/internal/xlog"
+)
+
+type prunecmd struct {
+	gf *globalFlags
+
+	images    bool
+	ephemeral bool
+	dryRun    bool
+}
+
+func (c *prunecmd) Spec() cli.CommandSpec {
+	return cli.CommandSpec{
+		Name: "prune",
+		Desc: `Removes unused sail resources.
+With --images, images built by sail that aren't used by any environment are removed.
+With --ephemeral, review environments and those created with sail run --ephemeral
+are removed once the pull request they review was merged or closed, or they were
+stopped for longer than ephemeral_ttl of the config.`,
+	}
+}
+
+func (c *prunecmd) RegisterFlags(fl *flag.FlagSet) {
+	fl.BoolVar(&c.images, "images", false, "Remove images built by sail that no environment uses.")
+	fl.BoolVar(&c.ephemeral, "ephemeral", false, "Remove ephemeral and review environments that expired.")
+	fl.BoolVar(&c.dryRun, "dry-run", false, "Only print what would be removed.")
+}
+
+func (c *prunecmd) Run(fl *flag.FlagSet) {
+	if !c.images && !c.ephemeral {
+		xlog.Fatal("nothing to prune, see sail prune -h")
+	}
+
+	contexts := c.gf.dockerContexts()
+	// Removing containers runs their hooks with the docker CLI, so the other
+	// contexts are pruned by sail processes of their own.
+	for _, name := range contexts[1:] {
+		xlog.Info("pruning docker context %v", name)
+		cmd := c.gf.contextSailCmd(name, c.args()...)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		err := cmd.Run()
+		if err != nil {
+			xlog.Error("failed to prune docker context %v: %v", name, err)
+		}
+	}
+
+	if len(contexts) > 1 {
+		xlog.Info("pruning docker context %v", contexts[0])
+	}
+	ctx := context.Background()
+	if c.ephemeral {
+		err := removeExpired(ctx, c.gf.config(), c.dryRun)
+		if err != nil {
+			xlog.Fatal("%v", err)
+		}
+	}
+	if c.images {
+		c.pruneImages(ctx)
+	}
+}
+
+// args returns the arguments of sail prune with c's flags.
+func (c *prunecmd) args() []string {
+	args := []string{"prune"}
+	if c.images {
+		args = append(args, "--images")
+	}
+	if c.ephemeral {
+		args = append(args, "--ephemeral")
+	}
+	if c.dryRun {
+		args = append(args, "--dry-run")
+	}
+	return args
+}
+
+// pruneImages removes the images built by sail that no environment uses.
+func (c *prunecmd) pruneImages(ctx context.Context) {
+	cli := dockerClient()
+	defer cli.Close()
+
+	images, err := cli.ImageList(ctx, types.ImageListOptions{
+		Filters: filters.NewArgs(filters.Arg("label", baseImageLabel)),
+	})
+	if err != nil {
+		xlog.Fatal("failed to list images: %v", err)
+	}
+	cnts, err := listContainers()
+	if err != nil {
+		xlog.Fatal("failed to list containers: %v", err)
+	}
+	external, err := externalContainers()
+	if err != nil {
+		xlog.Fatal("failed to list environments of other backends: %v", err)
+	}
+	cnts = append(cnts, external...)
+
+	var failed bool
+	for _, img := range unusedImages(images, cnts) {
+		name := img.ID
+		if len(img.RepoTags) > 0 {
+			name = img.RepoTags[0]
+		}
+		if c.dryRun {
+			fmt.Printf("would remove %v\n", name)
+			continue
+		}
+
+		_, err = cli.ImageRemove(ctx, img.ID, types.ImageRemoveOptions{
+			PruneChildren: true,
+		})
+		if err != nil {
+			xlog.Error("failed to remove %v: %v", name, err)
+			failed = true
+			continue
+		}
+		xlog.Success("removed %v", name)
+	}
+	if failed {
+		xlog.Fatal("failed to remove some images")
+	}
+}
+
+// unusedImages returns the images that aren't used by cnts, either directly
+// or as the base of a hatted image.
+func unusedImages(images []types.ImageSummary, cnts []types.Container) []types.ImageSummary {
+	used := make(map[string]bool)
+	for _, cnt := range cnts {
+		used[cnt.ImageID] = true
+		used[normalizeImageName(cnt.Image)] = true
+		if base := cnt.Labels[baseImageLabel]; base != "" {
+			used[normalizeImageName(base)] = true
+		}
+	}
+
+	var unused []types.ImageSummary
+outer:
+	for _, img := range images {
+		if used[img.ID] {
+			continue
+		}
+		for _, tag := range img.RepoTags {
+			if used[normalizeImageName(tag)] {
+				continue outer
+			}
+		}
+		unused = append(unused, img)
+	}
+	return unused
+}
+
+// splitImageTag splits the image reference ref, without a digest, into its
+// name and tag, which is empty if it has none.
+func splitImageTag(ref string) (string, string) {
+	// The tag comes after the last slash, a colon before that is a registry port.
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		return ref[:i], ref[i+1:]
+	}
+	return ref, ""
+}
+
+// normalizeImageName adds the implicit latest tag to image.
+func normalizeImageName(image string) string {
+	if strings.HasPrefix(image, "sha256:") || strings.Contains(image, "@") {
+		return image
+	}
+	if _, tag := splitImageTag(image); tag == "" {
+		return image + ":latest"
+	}
+	return image
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_unusedImages(t *testing.T) {
+	t.Parallel()
+
+	images := []types.ImageSummary{
+		{ID: "sha256:1", RepoTags: []string{"cdr_sail:latest"}},
+		{ID: "sha256:2", RepoTags: []string{"cdr_sail-hat-0123456789abcdef:latest"}},
+		{ID: "sha256:3", RepoTags: []string{"nhooyr_websocket:latest"}},
+		{ID: "sha256:4"},
+		{ID: "sha256:5", RepoTags: []string{"localhost:5000/cdr_sshcode:latest"}},
+	}
+	cnts := []types.Container{
+		{
+			Image:   "cdr_sail-hat-0123456789abcdef",
+			ImageID: "sha256:2",
+			Labels:  map[string]string{baseImageLabel: "cdr_sail"},
+		},
+		{Image: "sha256:4", ImageID: "sha256:4"},
+		{Image: "localhost:5000/cdr_sshcode", ImageID: "sha256:6"},
+	}
+
+	assert.Equal(t, []types.ImageSummary{images[2]}, unusedImages(images, cnts))
+}
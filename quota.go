@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/user"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/go-units"
+	"golang.org/x/xerrors"
+)
+
+// policyPath is the policy an admin of a shared host limits its users with.
+const policyPath = "/etc/sail/policy.toml"
+
+// policy describes the policy.toml.
+type policy struct {
+	// Quota applies to every user of the host.
+	Quota quota `toml:"quota"`
+	// Users override the quota of some users, by name.
+	Users map[string]quota `toml:"users"`
+}
+
+// quota limits the environments of a user. Zero values are unlimited.
+type quota struct {
+	MaxEnvironments int      `toml:"max_environments"`
+	MaxMemory       byteSize `toml:"max_memory"`
+	MaxDisk         byteSize `toml:"max_disk"`
+	// Memory limits the memory of each environment, so max_memory can be
+	// enforced.
+	Memory byteSize `toml:"memory"`
+}
+
+// byteSize is a size like 4g in the policy.
+type byteSize int64
+
+func (s *byteSize) UnmarshalText(text []byte) error {
+	n, err := units.RAMInBytes(string(text))
+	if err != nil {
+		return xerrors.Errorf("invalid size %q: %w", text, err)
+	}
+	*s = byteSize(n)
+	return nil
+}
+
+func (s byteSize) String() string {
+	return units.BytesSize(float64(s))
+}
+
+// readPolicy reads the policy at path. Hosts without one have no limits.
+func readPolicy(path string) (policy, error) {
+	var p policy
+	_, err := toml.DecodeFile(path, &p)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return policy{}, nil
+		}
+		return policy{}, xerrors.Errorf("failed to parse policy @ %v: %w", path, err)
+	}
+	err = p.check()
+	if err != nil {
+		return policy{}, xerrors.Errorf("%v: %w", path, err)
+	}
+	return p, nil
+}
+
+func (p policy) check() error {
+	check := func(name string, q quota) error {
+		if q.MaxEnvironments < 0 || q.MaxMemory < 0 || q.MaxDisk < 0 || q.Memory < 0 {
+			return xerrors.Errorf("negative limit in quota of %v", name)
+		}
+		if q.MaxMemory > 0 && q.Memory == 0 {
+			return xerrors.Errorf("max_memory of %v requires memory, the limit of each environment", name)
+		}
+		return nil
+	}
+	err := check("every user", p.Quota)
+	if err != nil {
+		return err
+	}
+	for name := range p.Users {
+		err = check(name, p.quota(name))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// quota returns the quota of the user named name, the policy's quota with
+// the limits set for the user replacing it.
+func (p policy) quota(name string) quota {
+	q := p.Quota
+	uq, ok := p.Users[name]
+	if !ok {
+		return q
+	}
+	if uq.MaxEnvironments != 0 {
+		q.MaxEnvironments = uq.MaxEnvironments
+	}
+	if uq.MaxMemory != 0 {
+		q.MaxMemory = uq.MaxMemory
+	}
+	if uq.MaxDisk != 0 {
+		q.MaxDisk = uq.MaxDisk
+	}
+	if uq.Memory != 0 {
+		q.Memory = uq.Memory
+	}
+	return q
+}
+
+// limited reports whether the quota limits anything.
+func (q quota) limited() bool {
+	return q != quota{}
+}
+
+// memoryLimit returns the memory limit of an environment that would be
+// limited to memory without the quota. The lower limit wins, so the quota
+// doesn't raise limits.
+func (q quota) memoryLimit(memory int64) int64 {
+	if q.Memory > 0 && (memory == 0 || int64(q.Memory) < memory) {
+		return int64(q.Memory)
+	}
+	return memory
+}
+
+// envUsage is what an existing environment counts against its owner's quota.
+type envUsage struct {
+	name   string
+	memory int64
+	disk   int64
+}
+
+// check returns an error pointing at the environments to remove if owner,
+// whose environments are envs, can't create one with a memory limit of
+// memory.
+func (q quota) check(owner string, envs []envUsage, memory int64) error {
+	if q.MaxEnvironments > 0 && len(envs) >= q.MaxEnvironments {
+		return xerrors.Errorf("%v already has %v environments, the most allowed on this host, remove one with sail rm: %v",
+			owner, len(envs), formatUsages(envs, nil),
+		)
+	}
+
+	if q.MaxMemory > 0 {
+		total := memory
+		for _, env := range envs {
+			total += env.memory
+		}
+		if total > int64(q.MaxMemory) {
+			sort.SliceStable(envs, func(i, j int) bool { return envs[i].memory > envs[j].memory })
+			return xerrors.Errorf("the environments of %v would be limited to %v of memory, more than the %v allowed on this host, remove some with sail rm: %v",
+				owner, units.BytesSize(float64(total)), q.MaxMemory, formatUsages(envs, func(u envUsage) int64 { return u.memory }),
+			)
+		}
+	}
+
+	if q.MaxDisk > 0 {
+		var total int64
+		for _, env := range envs {
+			total += env.disk
+		}
+		if total >= int64(q.MaxDisk) {
+			sort.SliceStable(envs, func(i, j int) bool { return envs[i].disk > envs[j].disk })
+			return xerrors.Errorf("the environments of %v use %v of disk, the %v allowed on this host, remove some with sail rm: %v",
+				owner, units.BytesSize(float64(total)), q.MaxDisk, formatUsages(envs, func(u envUsage) int64 { return u.disk }),
+			)
+		}
+	}
+	return nil
+}
+
+// formatUsages lists the names of envs, with their size if size is set.
+func formatUsages(envs []envUsage, size func(envUsage) int64) string {
+	names := make([]string, 0, len(envs))
+	for _, env := range envs {
+		if size == nil {
+			names = append(names, env.name)
+			continue
+		}
+		names = append(names, fmt.Sprintf("%v (%v)", env.name, units.BytesSize(float64(size(env)))))
+	}
+	return strings.Join(names, ", ")
+}
+
+// currentOwner returns the name of the host user that invoked sail, even if
+// sail is being run through sudo.
+func currentOwner() string {
+	if name := sudoUser(os.Geteuid()); name != "" {
+		return name
+	}
+	u, err := user.Current()
+	if err != nil {
+		return fmt.Sprint(os.Getuid())
+	}
+	return u.Username
+}
+
+// sudoUser returns the user that ran sail through sudo, if sail runs as
+// root, whose euid is euid. Anyone can set SUDO_USER, so it's only trusted
+// when sudo could have set it, or users could spend the quota of others.
+func sudoUser(euid int) string {
+	if euid != 0 {
+		return ""
+	}
+	return os.Getenv("SUDO_USER")
+}
+
+// ownerUsages returns the usage of the environments of owner, except those
+// of the project named name, whose container is being replaced.
+func ownerUsages(ctx context.Context, owner, name string, q quota) ([]envUsage, error) {
+	cli := dockerClient()
+	defer cli.Close()
+
+	cnts, err := cli.ContainerList(ctx, types.ContainerListOptions{
+		All: true,
+		// Sizes are slow to compute, so only get them if they're limited.
+		Size: q.MaxDisk > 0,
+		Filters: filters.NewArgs(
+			filters.Arg("label", sailLabel),
+			filters.Arg("label", ownerLabel+"="+owner),
+		),
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("failed to list containers: %w", err)
+	}
+
+	var envs []envUsage
+	for _, cnt := range cnts {
+		env := envUsage{
+			name: toSailName(trimDockerName(cnt)),
+			disk: cnt.SizeRw,
+		}
+		if n := cnt.Labels[nameLabel]; n != "" {
+			env.name = n
+		}
+		if env.name == name {
+			continue
+		}
+		if q.MaxMemory > 0 {
+			info, err := cli.ContainerInspect(ctx, cnt.ID)
+			if err != nil {
+				return nil, xerrors.Errorf("failed to inspect %v: %w", env.name, err)
+			}
+			env.memory = info.HostConfig.Memory
+		}
+		envs = append(envs, env)
+	}
+	return envs, nil
+}
+
+// applyQuota checks that the runner's container fits in its owner's quota,
+// and limits its memory as the quota says.
+func (r *runner) applyQuota(ctx context.Context) error {
+	p, err := readPolicy(policyPath)
+	if err != nil {
+		return err
+	}
+	r.owner = currentOwner()
+	q := p.quota(r.owner)
+	if !q.limited() {
+		return nil
+	}
+	r.memory = q.memoryLimit(r.memory)
+
+	envs, err := ownerUsages(ctx, r.owner, r.name, q)
+	if err != nil {
+		return xerrors.Errorf("failed to check quota: %w", err)
+	}
+	return q.check(r.owner, envs, r.memory)
+}
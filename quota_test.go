@@ -0,0 +1,86 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_readPolicy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sail-policy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	p, err := readPolicy(filepath.Join(dir, "policy.toml"))
+	require.NoError(t, err)
+	assert.False(t, p.quota("alice").limited())
+
+	path := filepath.Join(dir, "policy.toml")
+	err = ioutil.WriteFile(path, []byte(`
+[quota]
+max_environments = 5
+max_memory = "16g"
+memory = "4g"
+
+[users.alice]
+max_environments = 10
+max_disk = "50g"
+`), 0644)
+	require.NoError(t, err)
+
+	p, err = readPolicy(path)
+	require.NoError(t, err)
+	assert.Equal(t, quota{MaxEnvironments: 5, MaxMemory: 16 << 30, Memory: 4 << 30}, p.quota("bob"))
+	assert.Equal(t, quota{MaxEnvironments: 10, MaxMemory: 16 << 30, MaxDisk: 50 << 30, Memory: 4 << 30}, p.quota("alice"))
+
+	err = ioutil.WriteFile(path, []byte(`
+[users.alice]
+max_memory = "16g"
+`), 0644)
+	require.NoError(t, err)
+	_, err = readPolicy(path)
+	require.Error(t, err)
+}
+
+func Test_quotaCheck(t *testing.T) {
+	envs := []envUsage{
+		{name: "cdr/sail", memory: 2 << 30, disk: 1 << 30},
+		{name: "cdr/code-server", memory: 4 << 30, disk: 3 << 30},
+	}
+
+	require.NoError(t, quota{}.check("alice", envs, 0))
+	require.NoError(t, quota{MaxEnvironments: 3}.check("alice", envs, 0))
+
+	err := quota{MaxEnvironments: 2}.check("alice", envs, 0)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "cdr/sail, cdr/code-server")
+
+	require.NoError(t, quota{MaxMemory: 8 << 30}.check("alice", envs, 2<<30))
+	err = quota{MaxMemory: 8 << 30}.check("alice", envs, 4<<30)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "cdr/code-server (4GiB), cdr/sail (2GiB)")
+
+	err = quota{MaxDisk: 4 << 30}.check("alice", envs, 0)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "cdr/code-server (3GiB), cdr/sail (1GiB)")
+}
+
+func Test_quotaMemoryLimit(t *testing.T) {
+	assert.Equal(t, int64(0), quota{}.memoryLimit(0))
+	assert.Equal(t, int64(2<<30), quota{}.memoryLimit(2<<30))
+	assert.Equal(t, int64(4<<30), quota{Memory: 4 << 30}.memoryLimit(0))
+	assert.Equal(t, int64(2<<30), quota{Memory: 4 << 30}.memoryLimit(2<<30))
+	assert.Equal(t, int64(4<<30), quota{Memory: 4 << 30}.memoryLimit(8<<30))
+}
+
+func Test_sudoUser(t *testing.T) {
+	defer os.Setenv("SUDO_USER", os.Getenv("SUDO_USER"))
+	os.Setenv("SUDO_USER", "bob")
+
+	assert.Equal(t, "bob", sudoUser(0))
+	assert.Equal(t, "", sudoUser(1000))
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"github.com/docker/docker/api/types/mount"
+)
+
+// readOnlyLabel marks containers whose project directory is mounted
+// read-only, see runner.readOnly.
+const readOnlyLabel = sailLabel + ".read_only"
+
+// scratchDir is where editors of read-only projects can save files. It's a
+// tmpfs, so its files are lost when the container stops.
+const scratchDir = "~/scratch"
+
+// mountReadOnly mounts the project directory projectDir read-only, if
+// r.readOnly, and adds a scratch directory in its place for files that are
+// only needed while browsing.
+func (r *runner) mountReadOnly(mounts []mount.Mount, projectDir string) []mount.Mount {
+	if !r.readOnly {
+		return mounts
+	}
+	projectDir = resolvePath(r.guestHome(), projectDir)
+	for i, m := range mounts {
+		if m.Target == projectDir {
+			mounts[i].ReadOnly = true
+		}
+	}
+	return append(mounts, mount.Mount{
+		Type:   mount.TypeTmpfs,
+		Target: resolvePath(r.guestHome(), scratchDir),
+	})
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types/mount"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_mountReadOnly(t *testing.T) {
+	mounts := []mount.Mount{
+		{Type: mount.TypeBind, Source: "/src/sail", Target: "/home/user/sail"},
+		{Type: mount.TypeBind, Source: "/cache", Target: "/home/user/.cache"},
+	}
+
+	r := &runner{home: "/home/user"}
+	assert.Equal(t, mounts, r.mountReadOnly(mounts, "~/sail"))
+
+	r.readOnly = true
+	got := r.mountReadOnly(append([]mount.Mount(nil), mounts...), "~/sail")
+	assert.Equal(t, []mount.Mount{
+		{Type: mount.TypeBind, Source: "/src/sail", Target: "/home/user/sail", ReadOnly: true},
+		{Type: mount.TypeBind, Source: "/cache", Target: "/home/user/.cache"},
+		{Type: mount.TypeTmpfs, Target: "/home/user/scratch"},
+	}, got)
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/errdefs"
+	"golang.org/x/xerrors"
+)
+
+// dockerHubRegistry is the registry of images without one, e.g. ubuntu.
+const dockerHubRegistry = "docker.io"
+
+// dockerHubAuthKey is the key docker login stores Docker Hub's credentials
+// under.
+const dockerHubAuthKey = "https://index.docker.io/v1/"
+
+// imageRegistry returns the registry of the image reference ref.
+func imageRegistry(ref string) string {
+	i := strings.Index(ref, "/")
+	if i < 0 {
+		return dockerHubRegistry
+	}
+	// Like docker, the first component is a registry if it looks like a host.
+	host := ref[:i]
+	if !strings.ContainsAny(host, ".:") && host != "localhost" {
+		return dockerHubRegistry
+	}
+	if host == "index.docker.io" {
+		return dockerHubRegistry
+	}
+	return host
+}
+
+// dockerAuthConfig is the part of docker's config.json holding registry
+// credentials, as written by docker login.
+type dockerAuthConfig struct {
+	Auths map[string]struct {
+		Auth          string `json:"auth"`
+		IdentityToken string `json:"identitytoken"`
+	} `json:"auths"`
+	CredsStore  string            `json:"credsStore"`
+	CredHelpers map[string]string `json:"credHelpers"`
+}
+
+// registryCredentials returns the credentials docker login stored for
+// registry in configDir, reading them from the credential helper if docker
+// uses one. ok is false if there are none.
+func registryCredentials(configDir, registry string) (_ types.AuthConfig, ok bool, _ error) {
+	b, err := ioutil.ReadFile(filepath.Join(configDir, "config.json"))
+	if os.IsNotExist(err) {
+		return types.AuthConfig{}, false, nil
+	}
+	if err != nil {
+		return types.AuthConfig{}, false, err
+	}
+	var conf dockerAuthConfig
+	err = json.Unmarshal(b, &conf)
+	if err != nil {
+		return types.AuthConfig{}, false, xerrors.Errorf("failed to parse docker config: %w", err)
+	}
+
+	key := registry
+	if registry == dockerHubRegistry {
+		key = dockerHubAuthKey
+	}
+
+	helper := conf.CredsStore
+	if h, ok := conf.CredHelpers[registry]; ok {
+		helper = h
+	}
+	if helper != "" {
+		return helperCredentials(helper, key)
+	}
+
+	for k, a := range conf.Auths {
+		if k != key && strings.TrimPrefix(strings.TrimPrefix(k, "https://"), "http://") != registry {
+			continue
+		}
+		auth := types.AuthConfig{
+			ServerAddress: key,
+			IdentityToken: a.IdentityToken,
+		}
+		if a.Auth != "" {
+			dec, err := base64.StdEncoding.DecodeString(a.Auth)
+			if err != nil {
+				return types.AuthConfig{}, false, xerrors.Errorf("invalid credentials of %v in docker config: %w", registry, err)
+			}
+			toks := strings.SplitN(string(dec), ":", 2)
+			if len(toks) != 2 {
+				return types.AuthConfig{}, false, xerrors.Errorf("invalid credentials of %v in docker config", registry)
+			}
+			auth.Username, auth.Password = toks[0], toks[1]
+		}
+		return auth, true, nil
+	}
+	return types.AuthConfig{}, false, nil
+}
+
+// helperCredentials returns the credentials of the server key from the docker
+// credential helper named helper, e.g. osxkeychain.
+func helperCredentials(helper, key string) (_ types.AuthConfig, ok bool, _ error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("docker-credential-"+helper, "get")
+	cmd.Stdin = strings.NewReader(key)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err != nil {
+		// Helpers fail with this message if they have no credentials.
+		if strings.Contains(stdout.String()+stderr.String(), "credentials not found") {
+			return types.AuthConfig{}, false, nil
+		}
+		return types.AuthConfig{}, false, xerrors.Errorf("failed to run docker-credential-%v: %v: %w", helper, strings.TrimSpace(stderr.String()), err)
+	}
+
+	var creds struct {
+		Username string
+		Secret   string
+	}
+	err = json.Unmarshal(stdout.Bytes(), &creds)
+	if err != nil {
+		return types.AuthConfig{}, false, xerrors.Errorf("invalid output of docker-credential-%v: %w", helper, err)
+	}
+	auth := types.AuthConfig{ServerAddress: key}
+	// Helpers return identity tokens with this username.
+	if creds.Username == "<token>" {
+		auth.IdentityToken = creds.Secret
+	} else {
+		auth.Username, auth.Password = creds.Username, creds.Secret
+	}
+	return auth, true, nil
+}
+
+// registryAuth returns the encoded credentials of the registry of the image
+// ref for the Docker API, or "" if docker login has none.
+func registryAuth(ref string) (string, error) {
+	auth, ok, err := registryCredentials(dockerConfigDir(), imageRegistry(ref))
+	if err != nil || !ok {
+		return "", err
+	}
+	b, err := json.Marshal(auth)
+	if err != nil {
+		return "", err
+	}
+	return base64.URLEncoding.EncodeToString(b), nil
+}
+
+// registryAuthError is returned when a registry refuses to serve an image
+// without credentials.
+type registryAuthError struct {
+	// registry is empty if it's unknown, e.g. for builds.
+	registry string
+	msg      string
+}
+
+func (e *registryAuthError) Error() string {
+	if e.registry == "" {
+		return fmt.Sprintf("%v: the registry requires authentication, log in with sail login <registry>", e.msg)
+	}
+	login := "sail login"
+	if e.registry != dockerHubRegistry {
+		login += " " + e.registry
+	}
+	return fmt.Sprintf("%v: %v requires authentication, log in with %v", e.msg, e.registry, login)
+}
+
+// authErrorMessages are parts of the messages of docker and registries when
+// credentials are missing or wrong.
+var authErrorMessages = []string{
+	"unauthorized",
+	"authentication required",
+	"pull access denied",
+	"requested access to the resource is denied",
+}
+
+// authErrorLine returns the line of docker's output out reporting missing
+// or wrong registry credentials, if any.
+func authErrorLine(out string) (string, bool) {
+	for _, line := range strings.Split(out, "\n") {
+		l := strings.ToLower(line)
+		for _, m := range authErrorMessages {
+			if strings.Contains(l, m) {
+				return strings.TrimSpace(line), true
+			}
+		}
+	}
+	return "", false
+}
+
+// isRegistryAuthError reports whether err of the Docker API is from a
+// registry refusing missing or wrong credentials.
+func isRegistryAuthError(err error) bool {
+	if errdefs.IsUnauthorized(err) || errdefs.IsForbidden(err) {
+		return true
+	}
+	_, ok := authErrorLine(err.Error())
+	return ok
+}
+
+// tailWriter keeps the last max bytes written to it, to report errors from
+// the output of long running commands.
+type tailWriter struct {
+	max int
+	buf []byte
+}
+
+func (w *tailWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	if len(w.buf) > w.max {
+		w.buf = w.buf[len(w.buf)-w.max:]
+	}
+	return len(p), nil
+}
+
+func (w *tailWriter) String() string {
+	return string(w.buf)
+}
+
+// checkAuthError returns a registryAuthError for registry if the output out
+// of a failed docker command reports missing credentials, and err otherwise.
+func checkAuthError(err error, out, registry string) error {
+	line, ok := authErrorLine(out)
+	if !ok {
+		return err
+	}
+	return &registryAuthError{registry: registry, msg: line}
+}
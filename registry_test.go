@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+)
+
+func Test_imageRegistry(t *testing.T) {
+	assert.Equal(t, "docker.io", imageRegistry("ubuntu"))
+	assert.Equal(t, "docker.io", imageRegistry("codercom/ubuntu-dev:latest"))
+	assert.Equal(t, "docker.io", imageRegistry("index.docker.io/codercom/ubuntu-dev"))
+	assert.Equal(t, "ghcr.io", imageRegistry("ghcr.io/cdr/dev"))
+	assert.Equal(t, "registry:5000", imageRegistry("registry:5000/dev:1.0"))
+	assert.Equal(t, "localhost", imageRegistry("localhost/dev"))
+}
+
+func Test_registryCredentials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sail-docker")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	_, ok, err := registryCredentials(dir, "ghcr.io")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	auth := base64.StdEncoding.EncodeToString([]byte("colin:hunter2"))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "config.json"), []byte(`{
+	"auths": {
+		"https://index.docker.io/v1/": {"auth": "`+auth+`"},
+		"ghcr.io": {"identitytoken": "token"}
+	},
+	"credHelpers": {"gcr.io": "sail-test"}
+}`), 0600))
+
+	creds, ok, err := registryCredentials(dir, "docker.io")
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, "colin", creds.Username)
+	assert.Equal(t, "hunter2", creds.Password)
+
+	creds, ok, err = registryCredentials(dir, "ghcr.io")
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, "token", creds.IdentityToken)
+
+	// The credential helper is a script on the PATH.
+	helper := "#!/bin/sh\nread server\necho '{\"ServerURL\":\"'$server'\",\"Username\":\"_json_key\",\"Secret\":\"key\"}'\n"
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "docker-credential-sail-test"), []byte(helper), 0700))
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", dir+string(filepath.ListSeparator)+os.Getenv("PATH"))
+
+	creds, ok, err = registryCredentials(dir, "gcr.io")
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, "_json_key", creds.Username)
+	assert.Equal(t, "key", creds.Password)
+	assert.Equal(t, "gcr.io", creds.ServerAddress)
+}
+
+func Test_checkAuthError(t *testing.T) {
+	err := xerrors.New("exit status 1")
+	assert.Equal(t, err, checkAuthError(err, "Error response from daemon: manifest unknown", "docker.io"))
+
+	out := "Using default tag: latest\nError response from daemon: pull access denied for cdr/secret, repository does not exist or may require 'docker login'\n"
+	aerr := checkAuthError(err, out, "docker.io")
+	assert.EqualError(t, aerr, "Error response from daemon: pull access denied for cdr/secret, repository does not exist or may require 'docker login': docker.io requires authentication, log in with sail login")
+
+	aerr = checkAuthError(err, "failed to authorize: 401 Unauthorized", "ghcr.io")
+	assert.Contains(t, aerr.Error(), "log in with sail login ghcr.io")
+	assert.Equal(t, "registry_auth", errorCategory(xerrors.Errorf("failed to build: %w", aerr)))
+}
+
+func Test_tailWriter(t *testing.T) {
+	w := &tailWriter{max: 4}
+	w.Write([]byte("abc"))
+	w.Write([]byte("def"))
+	assert.Equal(t, "cdef", w.String())
+}
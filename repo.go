@@ -11,7 +11,7 @@ import (
 	"github.com/google/go-github/v24/github"
 	"golang.org/x/xerrors"
 
-	"go.coder.com/flog"
+	"go.coder.com/sail/internal/xlog"
 )
 
 type repo struct {
@@ -119,7 +119,7 @@ func (r repo) language() string {
 
 	repo, resp, err := github.NewClient(nil).Repositories.Get(context.Background(), orgRepo[0], orgRepo[1])
 	if err != nil {
-		flog.Error("unable to get repo language: %v", err)
+		xlog.Error("unable to get repo language: %v", err)
 		return ""
 	}
 
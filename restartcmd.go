@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/client"
+	"golang.org/x/xerrors"
+
+	"go.coder.com/cli"
+	"go.coder.com/sail/internal/dockutil"
+	"go.coder.com/sail/internal/xlog"
+)
+
+type restartcmd struct {
+	gf *globalFlags
+
+	full bool
+}
+
+func (c *restartcmd) Spec() cli.CommandSpec {
+	return cli.CommandSpec{
+		Name:  "restart",
+		Usage: "[flags] <repo>",
+		Desc: `Restarts code-server in a project's container.
+
+Only the code-server process is restarted when possible, which is enough to
+pick up new extensions or settings. Containers created by older versions of
+sail are restarted entirely.`,
+	}
+}
+
+func (c *restartcmd) RegisterFlags(fl *flag.FlagSet) {
+	fl.BoolVar(&c.full, "full", false, "Restart the whole container.")
+}
+
+func (c *restartcmd) Run(fl *flag.FlagSet) {
+	proj := c.gf.project(schemaPrefs{}, fl)
+	c.gf.ensureDockerDaemon()
+
+	exists, err := proj.cntExists()
+	if err != nil {
+		xlog.Fatal("%v", err)
+	}
+	if !exists {
+		xlog.Fatal("%v doesn't exist, create it with sail run", proj.pathName())
+	}
+
+	u, err := proj.proxyURL()
+	if err != nil {
+		xlog.Fatal("%v", err)
+	}
+
+	// The proxy restarts code-server so that it can pick up its new port.
+	resp, err := http.Post(fmt.Sprintf("%v/sail/api/v1/restart?full=%v", u, c.full), "", nil)
+	if err != nil {
+		xlog.Info("proxy isn't running, restarting the container")
+
+		cli := dockerClient()
+		defer cli.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+
+		err = restartContainer(ctx, cli, proj.cntName())
+		if err != nil {
+			xlog.Fatal("%v", err)
+		}
+		xlog.Success("restarted %v, open it with sail run", proj.pathName())
+		return
+	}
+	defer resp.Body.Close()
+
+	b, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		xlog.Fatal("failed to restart %v: %s", proj.pathName(), strings.TrimSpace(string(b)))
+	}
+	xlog.Success("%s", strings.TrimSpace(string(b)))
+	os.Exit(0)
+}
+
+// restartCodeServer restarts code-server in the container named cntName.
+// Unless full is set, only the code-server process is restarted if the
+// container supports it. inPlace reports whether it was.
+func restartCodeServer(ctx context.Context, cli *client.Client, cntName string, full bool) (inPlace bool, _ error) {
+	if !full {
+		cnt, err := inspectContainer(ctx, cli, cntName)
+		if err != nil {
+			return false, err
+		}
+		if cnt.State.Running && strings.Contains(strings.Join(cnt.Config.Cmd, " "), codeServerRestartPath) {
+			err = restartCodeServerProcess(ctx, cntName)
+			if err == nil {
+				return true, nil
+			}
+			xlog.Error("failed to restart code-server in place, restarting the container: %v", err)
+		}
+	}
+
+	return false, restartContainer(ctx, cli, cntName)
+}
+
+// restartCodeServerProcess kills code-server after asking the container's
+// init process to start it again, and waits for it to exit.
+func restartCodeServerProcess(ctx context.Context, cntName string) error {
+	cmd := dockutil.FmtExec(cntName, `set -eu
+pid=$(pgrep -o -f '%v')
+touch %v
+kill "$pid"
+while kill -0 "$pid" 2> /dev/null; do sleep 0.1; done`, codeServerProcess, codeServerRestartPath)
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return xerrors.Errorf("%s: %w", bytes.TrimSpace(out), err)
+	}
+	return nil
+}
+
+func restartContainer(ctx context.Context, cli *client.Client, cntName string) error {
+	err := cli.ContainerRestart(ctx, cntName, dockutil.DurationPtr(time.Second*10))
+	if err != nil {
+		return xerrors.Errorf("failed to restart %v: %w", cntName, err)
+	}
+	return nil
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v24/github"
+	"golang.org/x/xerrors"
+
+	"go.coder.com/cli"
+	"go.coder.com/sail/internal/xexec"
+	"go.coder.com/sail/internal/xlog"
+)
+
+// reviewLabel holds the pull request a review environment checks out, of
+// form <org>/<repo>#<number>.
+const reviewLabel = sailLabel + ".review"
+
+type reviewcmd struct {
+	gf *globalFlags
+
+	run runcmd
+}
+
+func (c *reviewcmd) Spec() cli.CommandSpec {
+	return cli.CommandSpec{
+		Name:  "review",
+		Usage: "[flags] <repo>#<number>",
+		Desc: `Creates a disposable environment checked out to a pull request.
+
+The pull request, or merge request on GitLab hosts, is looked up with the
+host's API, with the token of sail login --git if there is one, and its head
+is fetched into a clone of the repo of its own, on the branch review/<number>.
+The project is named <org>/<repo>-review-<number> unless --name is given, and
+is otherwise created and opened like sail run, which takes the same flags.
+
+Running it again fetches the pull request's new commits, if the checkout has no
+changes. sail rm removes the checkout along with the container, unless it has
+changes or commits that weren't pushed.`,
+	}
+}
+
+func (c *reviewcmd) RegisterFlags(fl *flag.FlagSet) {
+	c.run.RegisterFlags(fl)
+}
+
+// pullRequest is a pull request or merge request under review.
+type pullRequest struct {
+	number  int
+	title   string
+	headSHA string
+	// ref is the ref the host serves the request's head at.
+	ref string
+	// state is prOpen, prClosed or prMerged.
+	state string
+}
+
+const (
+	prOpen   = "open"
+	prClosed = "closed"
+	prMerged = "merged"
+)
+
+// parseReviewArg parses an argument of form <repo>#<number>.
+func parseReviewArg(arg string) (repoArg string, number int, _ error) {
+	i := strings.LastIndex(arg, "#")
+	if i <= 0 {
+		return "", 0, xerrors.Errorf("invalid pull request %q, must be of form <repo>#<number>", arg)
+	}
+	number, err := strconv.Atoi(arg[i+1:])
+	if err != nil || number <= 0 {
+		return "", 0, xerrors.Errorf("invalid pull request number in %q", arg)
+	}
+	return arg[:i], number, nil
+}
+
+// reviewName returns the name of the review environment of the pull request
+// number of the project name.
+func reviewName(name string, number int) string {
+	return fmt.Sprintf("%v-review-%v", name, number)
+}
+
+// reviewBranch is the branch review environments check out.
+func reviewBranch(number int) string {
+	return fmt.Sprintf("review/%v", number)
+}
+
+func (c *reviewcmd) Run(fl *flag.FlagSet) {
+	if fl.NArg() != 1 {
+		fl.Usage()
+		os.Exit(1)
+	}
+	repoArg, number, err := parseReviewArg(fl.Arg(0))
+	if err != nil {
+		xlog.Fatal("%v", err)
+	}
+	repoFl := flag.NewFlagSet(fl.Name(), flag.ExitOnError)
+	repoFl.Parse([]string{repoArg})
+
+	proj := c.gf.project(c.run.schemaPrefs, repoFl)
+	repoPath := proj.pathName()
+	if c.run.name == "" {
+		c.run.name = reviewName(repoPath, number)
+	}
+	proj.name = c.run.name
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	host := proj.repo.Hostname()
+	if host == "" {
+		xlog.Fatal("the host of %v isn't known, give the repo's URL", repoArg)
+	}
+	pr, err := fetchPullRequest(ctx, proj.conf.GitAuth[host].provider(host), host, repoPath, number)
+	if err != nil {
+		xlog.Fatal("failed to get %v#%v: %v", repoPath, number, err)
+	}
+	xlog.Info("reviewing %v#%v: %v", repoPath, number, pr.title)
+
+	err = checkoutPullRequest(proj, pr)
+	if err != nil {
+		xlog.Fatal("failed to check out %v#%v: %v", repoPath, number, err)
+	}
+
+	c.run.gf = c.gf
+	c.run.review = fmt.Sprintf("%v#%v", repoPath, number)
+	c.run.Run(repoFl)
+}
+
+// fetchPullRequest looks up the pull request number of the repo path at host
+// with the API of the provider, see gitAuthConfig.
+func fetchPullRequest(ctx context.Context, provider, host, path string, number int) (pullRequest, error) {
+	var token string
+	t, ok, err := validGitToken(ctx, host)
+	if err != nil {
+		xlog.Debug("failed to get the token of %v: %v", host, err)
+	}
+	if ok {
+		token = t.AccessToken
+	}
+
+	if provider == gitAuthGitLab {
+		return fetchMergeRequest(ctx, "https://"+host+"/api/v4/", token, path, number)
+	}
+	apiURL := "https://api.github.com/"
+	if host != "github.com" {
+		apiURL = "https://" + host + "/api/v3/"
+	}
+	return fetchGitHubPullRequest(ctx, apiURL, token, path, number)
+}
+
+// bearerTransport authenticates requests with a token.
+type bearerTransport struct {
+	token string
+}
+
+func (t bearerTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	// Requests mustn't be modified by transports.
+	h := make(http.Header, len(r.Header)+1)
+	for k, v := range r.Header {
+		h[k] = v
+	}
+	h.Set("Authorization", "Bearer "+t.token)
+	r2 := *r
+	r2.Header = h
+	return http.DefaultTransport.RoundTrip(&r2)
+}
+
+// apiClient returns the client of the host APIs, authenticated with token if
+// it isn't empty.
+func apiClient(token string) *http.Client {
+	if token == "" {
+		return http.DefaultClient
+	}
+	return &http.Client{Transport: bearerTransport{token: token}}
+}
+
+func fetchGitHubPullRequest(ctx context.Context, apiURL, token, path string, number int) (pullRequest, error) {
+	orgRepo := strings.SplitN(path, "/", 2)
+	if len(orgRepo) != 2 {
+		return pullRequest{}, xerrors.Errorf("%v isn't of form <org>/<repo>", path)
+	}
+	client := github.NewClient(apiClient(token))
+	u, err := url.Parse(apiURL)
+	if err != nil {
+		return pullRequest{}, err
+	}
+	client.BaseURL = u
+
+	pr, _, err := client.PullRequests.Get(ctx, orgRepo[0], orgRepo[1], number)
+	if err != nil {
+		return pullRequest{}, err
+	}
+	state := pr.GetState()
+	if pr.GetMerged() {
+		state = prMerged
+	}
+	return pullRequest{
+		number:  number,
+		title:   pr.GetTitle(),
+		headSHA: pr.GetHead().GetSHA(),
+		ref:     fmt.Sprintf("refs/pull/%v/head", number),
+		state:   state,
+	}, nil
+}
+
+func fetchMergeRequest(ctx context.Context, apiURL, token, path string, number int) (pullRequest, error) {
+	u := fmt.Sprintf("%vprojects/%v/merge_requests/%v", apiURL, url.PathEscape(path), number)
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return pullRequest{}, err
+	}
+	resp, err := apiClient(token).Do(req.WithContext(ctx))
+	if err != nil {
+		return pullRequest{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return pullRequest{}, xerrors.Errorf("%v responded with %v", u, resp.Status)
+	}
+
+	var mr struct {
+		Title string `json:"title"`
+		SHA   string `json:"sha"`
+		State string `json:"state"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&mr)
+	if err != nil {
+		return pullRequest{}, xerrors.Errorf("failed to decode the merge request: %w", err)
+	}
+	return pullRequest{
+		number:  number,
+		title:   mr.Title,
+		headSHA: mr.SHA,
+		ref:     fmt.Sprintf("refs/merge-requests/%v/head", number),
+		state:   mergeRequestState(mr.State),
+	}, nil
+}
+
+// mergeRequestState returns the pull request state of the GitLab merge
+// request state s.
+func mergeRequestState(s string) string {
+	switch s {
+	case "merged":
+		return prMerged
+	case "closed":
+		return prClosed
+	default:
+		// Opened and locked merge requests may still be merged.
+		return prOpen
+	}
+}
+
+// checkoutPullRequest clones the project's repo, if it isn't yet, and checks
+// out the head of pr on its review branch. The head is fetched to a remote
+// branch too, so sail rm can tell commits that weren't pushed.
+func checkoutPullRequest(proj *project, pr pullRequest) error {
+	dir := proj.localDir()
+	err := proj.ensureDir()
+	if err != nil {
+		return err
+	}
+	if changed, _ := gitChanges(dir); changed {
+		xlog.Warn("%v has changes, it stays at its commit", dir)
+		return nil
+	}
+
+	branch := reviewBranch(pr.number)
+	err = gitRun(dir, "fetch", "origin", fmt.Sprintf("+%v:refs/remotes/origin/%v", pr.ref, branch))
+	if err != nil {
+		return err
+	}
+	head := "refs/remotes/origin/" + branch
+	if pr.headSHA != "" {
+		head = pr.headSHA
+	}
+	return gitRun(dir, "checkout", "-B", branch, head)
+}
+
+func gitRun(dir string, args ...string) error {
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	xexec.Attach(cmd)
+	err := cmd.Run()
+	if err != nil {
+		return xerrors.Errorf("git %v failed: %w", args[0], err)
+	}
+	return nil
+}
+
+// gitChanges reports whether the checkout at dir has uncommitted changes, or
+// commits on its branches that no remote branch has.
+func gitChanges(dir string) (bool, error) {
+	for _, args := range [][]string{
+		{"status", "--porcelain"},
+		{"log", "--oneline", "--branches", "--not", "--remotes"},
+	} {
+		out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).Output()
+		if err != nil {
+			return false, err
+		}
+		if len(strings.TrimSpace(string(out))) > 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// removeReviewDir removes the checkout of a review environment, unless it
+// has changes that would be lost.
+func removeReviewDir(dir string) {
+	if _, err := os.Stat(filepath.Join(dir, ".git")); err != nil {
+		return
+	}
+	changed, err := gitChanges(dir)
+	if err != nil || changed {
+		xlog.Warn("%v has changes that weren't pushed, it's kept", dir)
+		return
+	}
+	err = os.RemoveAll(dir)
+	if err != nil {
+		xlog.Error("failed to remove %v: %v", dir, err)
+	}
+}
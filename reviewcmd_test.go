@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseReviewArg(t *testing.T) {
+	repoArg, number, err := parseReviewArg("cdr/sail#123")
+	require.NoError(t, err)
+	assert.Equal(t, "cdr/sail", repoArg)
+	assert.Equal(t, 123, number)
+
+	repoArg, _, err = parseReviewArg("https://gitlab.com/inkscape/inkscape#4567")
+	require.NoError(t, err)
+	assert.Equal(t, "https://gitlab.com/inkscape/inkscape", repoArg)
+
+	for _, arg := range []string{"cdr/sail", "#123", "cdr/sail#", "cdr/sail#abc", "cdr/sail#0"} {
+		_, _, err = parseReviewArg(arg)
+		assert.Error(t, err, arg)
+	}
+	assert.Equal(t, "cdr/sail-review-123", reviewName("cdr/sail", 123))
+}
+
+func Test_fetchPullRequest(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer tok", r.Header.Get("Authorization"))
+		switch r.URL.EscapedPath() {
+		case "/repos/cdr/sail/pulls/123":
+			w.Write([]byte(`{"number": 123, "title": "Add review", "state": "closed", "merged": true, "head": {"sha": "abc"}}`))
+		case "/projects/inkscape%2Finkscape/merge_requests/4567":
+			json.NewEncoder(w).Encode(map[string]string{"title": "Fix export", "sha": "def", "state": "opened"})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	pr, err := fetchGitHubPullRequest(ctx, srv.URL+"/", "tok", "cdr/sail", 123)
+	require.NoError(t, err)
+	assert.Equal(t, pullRequest{number: 123, title: "Add review", headSHA: "abc", ref: "refs/pull/123/head", state: prMerged}, pr)
+
+	pr, err = fetchMergeRequest(ctx, srv.URL+"/", "tok", "inkscape/inkscape", 4567)
+	require.NoError(t, err)
+	assert.Equal(t, pullRequest{number: 4567, title: "Fix export", headSHA: "def", ref: "refs/merge-requests/4567/head", state: prOpen}, pr)
+
+	_, err = fetchMergeRequest(ctx, srv.URL+"/", "tok", "inkscape/inkscape", 1)
+	require.Error(t, err)
+}
+
+func Test_removeReviewDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sail-review")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	git := func(dir string, args ...string) {
+		cmd := exec.Command("git", append([]string{"-C", dir, "-c", "user.name=sail", "-c", "user.email=sail@example.com"}, args...)...)
+		out, err := cmd.CombinedOutput()
+		require.NoError(t, err, "%s", out)
+	}
+	origin := filepath.Join(dir, "origin")
+	git(dir, "init", "-q", origin)
+	git(origin, "commit", "-q", "--allow-empty", "-m", "init")
+	checkout := filepath.Join(dir, "checkout")
+	git(dir, "clone", "-q", origin, checkout)
+
+	// Uncommitted changes are kept.
+	require.NoError(t, ioutil.WriteFile(filepath.Join(checkout, "notes"), []byte("lgtm"), 0644))
+	removeReviewDir(checkout)
+	require.DirExists(t, checkout)
+
+	// So are commits that weren't pushed.
+	git(checkout, "add", "notes")
+	git(checkout, "commit", "-q", "-m", "notes")
+	removeReviewDir(checkout)
+	require.DirExists(t, checkout)
+
+	git(checkout, "push", "-q", "origin", "HEAD:refs/heads/notes")
+	git(checkout, "fetch", "-q", "origin")
+	removeReviewDir(checkout)
+	_, err = os.Stat(checkout)
+	require.True(t, os.IsNotExist(err))
+}
@@ -5,11 +5,15 @@ import (
 	"flag"
 	"os"
 	"path/filepath"
+	"sort"
 	"time"
 
+	"github.com/docker/docker/client"
+	"golang.org/x/xerrors"
+
 	"go.coder.com/cli"
-	"go.coder.com/flog"
 	"go.coder.com/sail/internal/dockutil"
+	"go.coder.com/sail/internal/xlog"
 )
 
 type rmcmd struct {
@@ -26,7 +30,9 @@ func (c *rmcmd) Spec() cli.CommandSpec {
 		Usage: "[flags] <repo>",
 		Desc: `Remove a sail container from the system.
 This command allows for removing a single container
-or all of the containers on a system with the -all flag.`,
+or all of the containers on a system with the -all flag.
+The checkouts of review environments are removed too,
+unless they have changes that weren't pushed.`,
 	}
 }
 
@@ -43,6 +49,9 @@ func (c *rmcmd) Run(fl *flag.FlagSet) {
 		os.Exit(1)
 	}
 
+	if !c.all {
+		c.gf.selectDockerContext(c.repoArg)
+	}
 	c.gf.ensureDockerDaemon()
 
 	names := c.getRemovalList()
@@ -59,20 +68,30 @@ func (c *rmcmd) getRemovalList() []string {
 
 	cnts, err := listContainers()
 	if err != nil {
-		flog.Fatal("failed to list sail containers: %v", err)
+		xlog.Fatal("failed to list sail containers: %v", err)
 	}
 
 	var names = make([]string, 0, len(cnts))
 	for _, cnt := range cnts {
 		name := trimDockerName(cnt)
 		if name == "" {
-			flog.Error("container %v doesn't have a name.", cnt.ID)
+			xlog.Error("container %v doesn't have a name.", cnt.ID)
 			continue
 		}
 
 		names = append(names, name)
 	}
 
+	external, err := externalEnvs()
+	if err != nil {
+		xlog.Fatal("failed to list environments of other backends: %v", err)
+	}
+	start := len(names)
+	for name := range external {
+		names = append(names, name)
+	}
+	sort.Strings(names[start:])
+
 	return names
 }
 
@@ -84,19 +103,69 @@ func (c *rmcmd) removeContainers(names ...string) {
 	defer cancel()
 
 	for _, name := range names {
-		err := dockutil.StopRemove(ctx, cli, name)
+		// Review environments are disposable, their checkouts go with them.
+		var reviewDir string
+		cnt, err := inspectContainer(ctx, cli, name)
+		if err == nil && cnt.Config.Labels[reviewLabel] != "" {
+			reviewDir = cnt.Config.Labels[projectLocalDirLabel]
+		}
+		if external, err := loadExternalEnv(name); err == nil && external != nil && external.Labels[reviewLabel] != "" {
+			reviewDir = external.Labels[projectLocalDirLabel]
+		}
+
+		err = removeContainer(ctx, cli, name)
 		if err != nil {
-			flog.Error("failed to remove %s: %v", name, err)
+			xlog.Error("%v", err)
 			continue
 		}
+		if reviewDir != "" {
+			removeReviewDir(reviewDir)
+		}
 		if c.withData {
 			root := c.gf.config().ProjectRoot
 			path := filepath.Join(root, c.repoArg)
 			err = os.RemoveAll(path)
 			if err != nil {
-				flog.Error("Failed to remove cloned directory: %v", err)
+				xlog.Error("Failed to remove cloned directory: %v", err)
 			}
 		}
-		flog.Info("removed %s", name)
+		xlog.Info("removed %s", name)
+	}
+}
+
+// removeContainer runs the pre_remove hooks of the container name,
+// and then removes it.
+func removeContainer(ctx context.Context, cli *client.Client, name string) error {
+	l, err := lockContainer(name)
+	if err != nil {
+		return xerrors.Errorf("failed to lock %s: %w", name, err)
+	}
+	defer l.Unlock()
+
+	external, err := loadExternalEnv(name)
+	if err != nil {
+		return err
+	}
+	if external != nil {
+		return removeExternal(ctx, name, *external)
+	}
+
+	env, err := hookEnvFromContainer(ctx, name)
+	if err == nil {
+		err = runHooks(ctx, preRemoveHook, env)
+	}
+	if err != nil {
+		xlog.Error("failed to run %v hooks for %s: %v", preRemoveHook, name, err)
+	}
+
+	err = dockutil.StopRemove(ctx, cli, name)
+	if err != nil {
+		return xerrors.Errorf("failed to remove %s: %w", name, err)
+	}
+	err = removeSidecars(ctx, cli, name)
+	if err != nil {
+		return err
 	}
+	emitEvent(eventRemoved, name, nil)
+	return nil
 }
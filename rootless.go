@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"golang.org/x/xerrors"
+)
+
+// rootlessLabel records that the container was created by a rootless
+// Docker daemon.
+const rootlessLabel = sailLabel + ".rootless"
+
+// rootlessUser is the user containers run as on rootless daemons.
+//
+// Rootless daemons map the container's root to the host user, and every other
+// uid to one of the host user's subordinate uids. The image's user would own
+// neither the project nor any of the mounts, so the container runs as root
+// instead, which owns both.
+const rootlessUser = "0:0"
+
+// dockerInfo returns the info of the Docker daemon, whose security options
+// include e.g. name=rootless or name=selinux.
+func dockerInfo() (types.Info, error) {
+	cli := dockerClient()
+	defer cli.Close()
+
+	info, err := cli.Info(context.Background())
+	if err != nil {
+		return types.Info{}, xerrors.Errorf("failed to get docker info: %w", err)
+	}
+	return info, nil
+}
+
+// hasSecurityOption reports whether the security options of a daemon from
+// docker info include name.
+func hasSecurityOption(securityOptions []string, name string) bool {
+	for _, opt := range securityOptions {
+		for _, field := range strings.Split(opt, ",") {
+			if field == "name="+name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// publishesPorts reports whether the container's ports must be published,
+// as the container can't share the host's network. Docker Desktop and
+// Windows containers don't support host networking, and the host network of
+// rootless daemons is their own network namespace. Other backends than Docker
+// don't share the host's network either.
+func (r *runner) publishesPorts() bool {
+	return r.desktop || r.rootless || r.windows || !r.usesDocker() || r.egress.restricted()
+}
+
+// applyRootless adapts the container to a rootless daemon, if r.rootless.
+func (r *runner) applyRootless(containerConfig *container.Config) {
+	if !r.rootless {
+		return
+	}
+	containerConfig.Labels[rootlessLabel] = "true"
+	if containerConfig.User == "" {
+		containerConfig.User = rootlessUser
+	}
+	// root's home is /root, but sail's mounts are in the image user's home.
+	// It's set first so sail env can still override it.
+	containerConfig.Env = append([]string{"HOME=" + r.guestHome()}, containerConfig.Env...)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_hasSecurityOption(t *testing.T) {
+	opts := []string{"name=seccomp,profile=default", "name=rootless", "name=selinux"}
+	assert.True(t, hasSecurityOption(opts, "rootless"))
+	assert.True(t, hasSecurityOption(opts, "selinux"))
+	assert.True(t, hasSecurityOption(opts, "seccomp"))
+	assert.False(t, hasSecurityOption(opts, "apparmor"))
+	assert.False(t, hasSecurityOption(nil, "rootless"))
+}
+
+func Test_applyRootless(t *testing.T) {
+	t.Run("Rootful", func(t *testing.T) {
+		r := &runner{}
+		cnt := &container.Config{Labels: map[string]string{}, Env: []string{"A=b"}}
+		r.applyRootless(cnt)
+
+		assert.Equal(t, "", cnt.User)
+		assert.Equal(t, []string{"A=b"}, cnt.Env)
+		assert.NotContains(t, cnt.Labels, rootlessLabel)
+	})
+
+	t.Run("Rootless", func(t *testing.T) {
+		r := &runner{rootless: true}
+		cnt := &container.Config{Labels: map[string]string{}, Env: []string{"HOME=/home/me"}}
+		r.applyRootless(cnt)
+
+		assert.Equal(t, rootlessUser, cnt.User)
+		assert.Equal(t, []string{"HOME=" + guestHomeDir, "HOME=/home/me"}, cnt.Env)
+		assert.Equal(t, "true", cnt.Labels[rootlessLabel])
+	})
+
+	t.Run("User", func(t *testing.T) {
+		r := &runner{rootless: true}
+		cnt := &container.Config{Labels: map[string]string{}, User: "1001"}
+		r.applyRootless(cnt)
+
+		assert.Equal(t, "1001", cnt.User)
+	})
+}
@@ -3,15 +3,17 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
 	"net/http"
 	"os"
 	"time"
 
+	"github.com/docker/docker/api/types"
 	"golang.org/x/xerrors"
 
 	"go.coder.com/cli"
-	"go.coder.com/flog"
 	"go.coder.com/sail/internal/dockutil"
+	"go.coder.com/sail/internal/xlog"
 )
 
 type runcmd struct {
@@ -19,13 +21,51 @@ type runcmd struct {
 
 	image   string
 	hat     string
+	name    string
 	keep    bool
 	testCmd string
 
 	schemaPrefs
 
 	rebuild bool
+	dryRun  bool
 	noOpen  bool
+	urlOnly bool
+	browser string
+
+	isolatedProfile bool
+	warmup          bool
+
+	user       string
+	groupAdd   stringsFlag
+	usernsMode string
+	platform   string
+	docker     bool
+	devices    stringsFlag
+	audio      bool
+	webcam     bool
+
+	strictMounts bool
+	readOnly     bool
+	overlay      bool
+
+	hatArgFlags stringsFlag
+	hatArgs     map[string]string
+
+	secrets stringsFlag
+	frozen  bool
+
+	trust   bool
+	sandbox bool
+
+	// review is the pull request the environment reviews, see reviewcmd.
+	review    string
+	ephemeral bool
+
+	createTimeout time.Duration
+	startTimeout  time.Duration
+	pullTimeout   time.Duration
+	buildTimeout  time.Duration
 }
 
 type schemaPrefs struct {
@@ -82,8 +122,7 @@ This will work:
 }
 
 func (c *runcmd) RegisterFlags(fl *flag.FlagSet) {
-	fl.StringVar(&c.image, "image", "", "Custom docker image to use.")
-	fl.StringVar(&c.hat, "hat", "", "Custom hat to use.")
+	c.registerContainerFlags(fl)
 	fl.BoolVar(&c.keep, "keep", false, "Keep container when it fails to build.")
 	fl.StringVar(&c.testCmd, "test-cmd", "", "A command to use in-place of starting code-server for testing purposes.")
 
@@ -91,36 +130,182 @@ func (c *runcmd) RegisterFlags(fl *flag.FlagSet) {
 	fl.BoolVar(&c.http, "http", false, "Clone repo over HTTP")
 	fl.BoolVar(&c.https, "https", false, "Clone repo over HTTPS")
 	fl.BoolVar(&c.rebuild, "rebuild", false, "Delete existing container")
+	fl.BoolVar(&c.dryRun, "dry-run", false, "Print the container that would be created instead of creating it.")
 	fl.BoolVar(&c.noOpen, "no-open", false, "Don't open an editor session")
+	fl.BoolVar(&c.urlOnly, "url-only", false, "Print the project's URL instead of opening it.")
+	fl.BoolVar(&c.urlOnly, "print-url", false, "Alias for --url-only.")
+	fl.StringVar(&c.browser, "browser", "", "Browser command to open the project with. Overrides browser in the config.")
+	fl.BoolVar(&c.isolatedProfile, "isolated-profile", false, "Open the project as an app window in its own Chrome profile. See isolated_profiles in the config.")
+	fl.BoolVar(&c.ephemeral, "ephemeral", false, "Remove the environment once it was stopped for ephemeral_ttl of the config.")
+	fl.BoolVar(&c.warmup, "warmup", false, "Install the project's dependencies before it's ready, when it's created. See warmup in the config.")
+
+	fl.Var(&c.secrets, "secret", "Secret to expose to the image build, of form id=<id>,src=<path>. Can be repeated.")
+	fl.BoolVar(&c.frozen, "frozen", false, "Fail if the image can't be built or pulled at the digests of .sail/lock.")
+	fl.BoolVar(&c.trust, "trust", false, "Trust the project, so new containers get the host's mounts and run privileged.")
+	fl.BoolVar(&c.sandbox, "sandbox", false, "Sandbox the project, so new containers run unprivileged with only the project mounted.")
+
+	fl.DurationVar(&c.createTimeout, "create-timeout", 0, "Timeout for creating the container. Overrides create_timeout in the config.")
+	fl.DurationVar(&c.startTimeout, "start-timeout", 0, "Timeout for starting the container. Overrides start_timeout in the config.")
+	fl.DurationVar(&c.pullTimeout, "pull-timeout", 0, "Timeout for pulling the image. Overrides pull_timeout in the config.")
+	fl.DurationVar(&c.buildTimeout, "build-timeout", 0, "Timeout for building the image. Overrides build_timeout in the config.")
+}
+
+// registerContainerFlags registers the flags that change the container, which
+// sail explain also takes.
+func (c *runcmd) registerContainerFlags(fl *flag.FlagSet) {
+	fl.StringVar(&c.image, "image", "", "Run from this image instead of the project's Dockerfile or the default image. It's pulled if it doesn't exist. Hats still apply.")
+	fl.StringVar(&c.hat, "hat", "", "Custom hat to use.")
+	fl.Var(&c.hatArgFlags, "hat-arg", "Value of a parameter of the hat, of form name=value. Can be repeated. Overrides hat_args in the config.")
+	fl.StringVar(&c.name, "name", "", "Custom project name of form <name> or <org>/<name>. Defaults to the repo's <org>/<repo>.")
+
+	fl.StringVar(&c.user, "user", "", "Run as uid[:gid] instead of the image's user. Overrides user in the config.")
+	fl.Var(&c.groupAdd, "group-add", "Supplementary group for the container user, prefix with host: to use the host's gid. Can be repeated.")
+	fl.Var(&c.devices, "device", "Host device to pass through to the container, of form host_path[:container_path[:permissions]]. Can be repeated.")
+	fl.BoolVar(&c.docker, "docker", false, "Share the host's Docker socket with the container.")
+	fl.BoolVar(&c.audio, "audio", false, "Pass the host's sound devices through to the container.")
+	fl.BoolVar(&c.webcam, "webcam", false, "Pass the host's video devices, such as webcams, through to the container.")
+	fl.StringVar(&c.usernsMode, "userns", "", "User namespace mode for the container. Overrides userns_mode in the config.")
+	fl.StringVar(&c.platform, "platform", "", "Platform to pull and build images for, e.g. linux/amd64. Overrides platform in the config.")
+	fl.BoolVar(&c.readOnly, "read-only", false, "Mount the project directory read-only, with a scratch directory at "+scratchDir+" for other files.")
+	fl.BoolVar(&c.overlay, "overlay", false, "Mount the project directory as an overlay of the host's, whose changes are shown with sail diff and copied back with sail apply.")
+	fl.BoolVar(&c.strictMounts, "strict-mounts", false, "Fail if mounts have the same guest path, instead of keeping the one of highest precedence.")
+}
+
+// applyTimeouts overrides the configured timeouts with any set by flags.
+func (c *runcmd) applyTimeouts(conf *config) {
+	if c.createTimeout > 0 {
+		conf.CreateTimeout = duration(c.createTimeout)
+	}
+	if c.startTimeout > 0 {
+		conf.StartTimeout = duration(c.startTimeout)
+	}
+	if c.pullTimeout > 0 {
+		conf.PullTimeout = duration(c.pullTimeout)
+	}
+	if c.buildTimeout > 0 {
+		conf.BuildTimeout = duration(c.buildTimeout)
+	}
 }
 
 const guestHomeDir = "/home/user"
 
+// project reads the project from the arguments, applying the flags
+// overriding its config.
+func (c *runcmd) project(fl *flag.FlagSet) *project {
+	proj := c.gf.project(c.schemaPrefs, fl)
+	if c.name != "" {
+		err := validateProjectName(c.name)
+		if err != nil {
+			xlog.Fatal("%v", err)
+		}
+		proj.name = c.name
+	}
+	c.applyTimeouts(&proj.conf)
+	if c.browser != "" {
+		proj.conf.Browser = c.browser
+	}
+	if c.isolatedProfile {
+		proj.conf.IsolatedProfiles = true
+	}
+	for _, s := range c.secrets {
+		err := validateSecret(s)
+		if err != nil {
+			xlog.Fatal("%v", err)
+		}
+	}
+	proj.buildOpts.secrets = c.secrets
+	if c.platform != "" {
+		err := validatePlatform(c.platform)
+		if err != nil {
+			xlog.Fatal("%v", err)
+		}
+		proj.buildOpts.platform = c.platform
+	}
+	var err error
+	c.hatArgs, err = parseHatArgs(c.hatArgFlags)
+	if err != nil {
+		xlog.Fatal("%v", err)
+	}
+	if c.frozen && c.image != "" {
+		xlog.Fatal("--image isn't locked, it can't be used with --frozen")
+	}
+	proj.buildOpts.frozen = c.frozen
+	return proj
+}
+
 func (c *runcmd) Run(fl *flag.FlagSet) {
+	// The project selects the Docker daemon.
+	proj := c.project(fl)
 	c.gf.ensureDockerDaemon()
 
-	proj := c.gf.project(c.schemaPrefs, fl)
+	ctx, cancel := withInterrupt(context.Background())
+	defer cancel()
+
+	if c.dryRun {
+		c.printPlan(proj)
+		return
+	}
+
+	// Hold the project lock until we exit so that concurrent invocations
+	// wait for this one to finish creating the container, and then reuse it.
+	_, err := proj.lock()
+	if err != nil {
+		xlog.Fatal("failed to lock project: %v", err)
+	}
+
+	err = proj.checkCollision()
+	if err != nil {
+		xlog.Fatal("%v", err)
+	}
+
+	if b, ok := newBackend(proj.conf.backendOf(proj.pathName())).(externalBackend); ok {
+		c.runExternal(ctx, proj, b)
+		return
+	}
 
 	// Abort if container already exists.
 	exists, err := proj.cntExists()
 	if err != nil {
-		flog.Fatal("%v", err)
+		xlog.Fatal("%v", err)
 	}
 
 	if exists && c.rebuild {
 		err = proj.delete()
 		if err != nil {
-			flog.Fatal("failed to delete existing container: %v", err)
+			xlog.Fatal("failed to delete existing container: %v", err)
 		}
 		exists = false
 	}
 
 	if exists {
-		c.gf.debug("opening existing project")
+		xlog.Debug("opening existing project")
+
+		labels := existingLabels(proj.cntName())
+		if c.readOnly && labels[readOnlyLabel] != "true" {
+			xlog.Warn("%v was created writable, run with --rebuild to mount it read-only", proj.pathName())
+		}
+		if c.overlay && labels[overlayLabel] != "true" {
+			xlog.Warn("%v was created without an overlay, run with --rebuild to mount one", proj.pathName())
+		}
+		if c.frozen {
+			lock, err := proj.loadLock()
+			if err == nil {
+				err = lock.checkUpstream(labels[upstreamLabel])
+			}
+			if err != nil {
+				xlog.Fatal("%v can't be reused with --frozen: %v, run with --rebuild to recreate it", proj.pathName(), err)
+			}
+		}
+
+		// The project may have been added to groups since it was created.
+		err = joinGroups(ctx, proj.cntName(), proj.conf.groupsOf(proj.pathName()))
+		if err != nil {
+			xlog.Warn("%v", err)
+		}
 
 		u, err := proj.proxyURL()
 		if err != nil {
-			flog.Fatal("%v", err)
+			xlog.Fatal("%v", err)
 		}
 
 		resp, err := http.Get(u + "/sail/api/v1/heartbeat")
@@ -132,10 +317,10 @@ func (c *runcmd) Run(fl *flag.FlagSet) {
 			}
 			err = proj.open()
 			if err != nil {
-				flog.Error("failed to open project: %v", err)
+				xlog.Error("failed to open project: %v", err)
 				err = proj.delete()
 				if err != nil {
-					flog.Error("failed to delete project container: %v", err)
+					xlog.Error("failed to delete project container: %v", err)
 				}
 				os.Exit(1)
 			}
@@ -143,7 +328,22 @@ func (c *runcmd) Run(fl *flag.FlagSet) {
 		}
 
 		// Proxy is not up, meaning the container shut down at some point, or the proxy
-		// was killed. We're going to restart the proxy and update the container label.
+		// was killed. The container is started again with a new proxy, unless it
+		// drifted from what we'd create and is rebuilt.
+		reused, err := c.reuse(ctx, proj)
+		if err != nil {
+			xlog.Fatal("failed to start %v: %v", proj.pathName(), err)
+		}
+		if reused {
+			if c.noOpen {
+				os.Exit(0)
+			}
+			err = c.open(proj)
+			if err != nil {
+				xlog.Fatal("failed to open project: %v", err)
+			}
+			os.Exit(0)
+		}
 
 		cli := dockerClient()
 		defer cli.Close()
@@ -153,97 +353,315 @@ func (c *runcmd) Run(fl *flag.FlagSet) {
 
 		err = dockutil.StopRemove(ctx, cli, proj.cntName())
 		if err != nil {
-			flog.Fatal("failed to remove container without running proxy: %v", err)
+			xlog.Fatal("failed to remove container without running proxy: %v", err)
 		}
 
 		// The container will be rebuilt properly.
 	}
 
+	sandbox, err := c.sandboxed(proj)
+	if err != nil {
+		xlog.Fatal("%v", err)
+	}
+	if sandbox {
+		proj.sandboxBuild()
+	}
+
 	err = proj.ensureDir()
 	if err != nil {
-		flog.Fatal("%v", err)
+		xlog.Fatal("%v", err)
 	}
 
-	var image string
+	image, pulled := c.resolveImage(ctx, proj)
+
+	hostHomeDir, err := os.UserHomeDir()
+	if err != nil {
+		panic(err)
+	}
+	xlog.Debug("host home dir: %v", hostHomeDir)
+
+	b := c.hatBuilder(proj, image)
+	r, err := c.runner(proj, pulled)
+	if err != nil {
+		xlog.Fatal("%v", err)
+	}
+	r.sandbox = sandbox
+
+	start := time.Now()
+	emitEvent(eventBuilding, proj.cntName(), nil)
+	err = c.build(ctx, c.gf, proj, b, r)
+	if err != nil {
+		emitEvent(eventFailed, proj.cntName(), map[string]string{"error": err.Error()})
+		recordTelemetry(telemetryEvent{Type: telemetryError, Command: "run", Category: errorCategory(err)})
+		xlog.Error("build run failed: %v", err)
+		// An interrupted run never leaves its container behind, as it may
+		// only be partially created.
+		if !c.keep || ctx.Err() != nil {
+			// We remove the container if it fails to start as that means the developer
+			// can iterate w/o having to do the obnoxious `docker rm` step.
+			xlog.Debug("removing %v", proj.cntName())
+			err = dockutil.StopRemove(context.Background(), dockerClient(), proj.cntName())
+			if err != nil {
+				xlog.Error("failed to remove %v", proj.cntName())
+			}
+		}
+		os.Exit(1)
+	}
+	recordTelemetry(telemetryEvent{Type: telemetryStartup, Command: "run", DurationMS: int64(time.Since(start) / time.Millisecond)})
+
+	if c.noOpen {
+		os.Exit(0)
+	}
+
+	err = c.open(proj)
+	if err != nil {
+		xlog.Fatal("failed to open project: %v", err)
+	}
+
+	os.Exit(0)
+}
+
+// resolveImage returns the image the project's container is created from,
+// building or pulling it if needed, and whether it had to be pulled.
+func (c *runcmd) resolveImage(ctx context.Context, proj *project) (string, bool) {
+	var (
+		image  string
+		pulled bool
+		err    error
+	)
 	if c.image != "" {
 		image = c.image
+		pulled, err = c.pullCustomImage(ctx, proj)
+		if err != nil {
+			xlog.Fatal("failed to ensure image %v: %v", c.image, err)
+		}
+		xlog.Info("using image %v", image)
 	} else {
 		var customImageExists bool
 		image, customImageExists, err = proj.buildImage()
 		if err != nil {
-			flog.Fatal("failed to build image: %v", err)
+			xlog.Fatal("failed to build image: %v", err)
 		}
 		if !customImageExists {
-			image = proj.defaultRepoImage()
-			flog.Info("using default image %v", image)
+			var reason string
+			image, reason = proj.inferImage()
+			if reason != "" {
+				xlog.Info("inferred image %v from %v, override it with --image or a .sail/Dockerfile", image, reason)
+			} else {
+				xlog.Info("using default image %v", image)
+			}
 
-			err = ensureImage(image)
+			pullCtx, cancel := context.WithTimeout(ctx, proj.conf.timeouts(false).pull)
+			image, pulled, err = proj.pullImage(pullCtx, image)
+			cancel()
 			if err != nil {
-				flog.Fatal("failed to ensure image %v: %v", image, err)
+				xlog.Fatal("failed to ensure image %v: %v", image, err)
 			}
 		} else {
-			flog.Info("using repo image %v", image)
+			xlog.Info("using repo image %v", image)
+		}
+	}
+	return image, pulled
+}
+
+// reuse starts the project's existing container, and a proxy for it, running
+// what runs on every start like build does. If the container drifted from the
+// one sail run would create, the user is offered to rebuild it instead, which
+// loses its state, so it isn't rebuilt without asking. It reports whether the
+// container was reused.
+func (c *runcmd) reuse(ctx context.Context, proj *project) (bool, error) {
+	cli := dockerClient()
+	defer cli.Close()
+
+	cnt, err := inspectContainer(ctx, cli, proj.cntName())
+	if err != nil {
+		return false, err
+	}
+	drift := containerDrift(proj, cnt, c.hatBuilder(proj, c.image))
+	if len(drift) > 0 {
+		for _, d := range drift {
+			xlog.Info("%v drifted: %v", proj.pathName(), d)
+		}
+		if confirmDestructive("Rebuild it? Changes outside of the project directory and mounts are lost.") {
+			return false, nil
 		}
+		xlog.Info("run with --rebuild to rebuild it")
 	}
 
-	// Apply hat if configured.
-	var hatPath string
+	xlog.Info("starting existing %v", proj.pathName())
+	err = cli.ContainerStart(ctx, proj.cntName(), types.ContainerStartOptions{})
+	if err != nil {
+		return false, xerrors.Errorf("failed to start container: %w", err)
+	}
+	r := &runner{cntName: proj.cntName()}
+	err = r.runOnStart(cnt.Image)
+	if err != nil {
+		return false, xerrors.Errorf("failed to run on_start label in container: %w", err)
+	}
+
+	port, err := assignPort(proj.cntName(), proxyPortName)
+	if err != nil {
+		return false, err
+	}
+	u, err := forkProxy(proj.cntName(), time.Duration(proj.conf.IdleTimeout), port)
+	if err != nil {
+		return false, xerrors.Errorf("failed to start proxy: %w", err)
+	}
+	// The proxy's URL is a label of the container, so if its port was taken
+	// since, the container must be recreated. The new proxy exits by itself.
+	if u != cnt.Config.Labels[proxyURLLabel] {
+		xlog.Info("the port of %v was taken, recreating it", proj.pathName())
+		return false, nil
+	}
+
+	err = proj.waitOnline(ctx)
+	if err != nil {
+		return false, err
+	}
+	emitEvent(eventOnline, proj.cntName(), map[string]string{"url": u})
+
+	runStartHooks(ctx, proj.cntName(), cnt.Image, false)
+	return true, nil
+}
+
+// open opens the project, or prints its URL with --url-only.
+func (c *runcmd) open(proj *project) error {
+	if !c.urlOnly {
+		return proj.open()
+	}
+
+	u, err := proj.start()
+	if err != nil {
+		return err
+	}
+	fmt.Println(u)
+	return nil
+}
+
+// pullCustomImage pulls the image given with --image, unless it exists
+// already, e.g. because it was built locally. It returns whether it had to be
+// downloaded.
+func (c *runcmd) pullCustomImage(ctx context.Context, proj *project) (bool, error) {
+	exists, err := imageExists(c.image)
+	if err != nil || exists {
+		return false, err
+	}
+
+	pullCtx, cancel := context.WithTimeout(ctx, proj.conf.timeouts(false).pull)
+	defer cancel()
+	return ensureImage(pullCtx, c.image, proj.buildOpts.platform)
+}
+
+// hatBuilder returns the builder applying the configured hat, if any, to
+// image.
+func (c *runcmd) hatBuilder(proj *project, image string) *hatBuilder {
+	var (
+		hatPath string
+		args    = make(map[string]string)
+	)
 	switch {
 	case c.hat != "":
 		hatPath = c.hat
-	case c.gf.config().DefaultHat != "":
-		hatPath = c.gf.config().DefaultHat
+	case proj.conf.DefaultHat != "":
+		hatPath = proj.conf.DefaultHat
+		// hat_args are the arguments of the default hat.
+		for k, v := range proj.conf.HatArgs {
+			args[k] = v
+		}
 	}
-
-	hostHomeDir, err := os.UserHomeDir()
-	if err != nil {
-		panic(err)
+	for k, v := range c.hatArgs {
+		args[k] = v
 	}
-	c.gf.debug("host home dir: %v", hostHomeDir)
 
-	b := &hatBuilder{
-		baseImage: image,
-		hatPath:   hatPath,
+	return &hatBuilder{
+		baseImage:    image,
+		hatPath:      hatPath,
+		buildTimeout: proj.conf.timeouts(false).build,
+		opts:         proj.buildOpts,
+		args:         args,
 	}
+}
 
+// runner returns the runner creating the project's container.
+func (c *runcmd) runner(proj *project, pulled bool) (*runner, error) {
+	if c.readOnly && c.overlay {
+		return nil, xerrors.New("--read-only and --overlay can't be combined")
+	}
 	r := &runner{
 		projectName:     proj.repo.BaseName(),
 		projectLocalDir: proj.localDir(),
 		cntName:         proj.cntName(),
+		name:            proj.pathName(),
+		repoURI:         proj.remoteURI(),
 		hostname:        proj.repo.BaseName(),
 		// Use `0` as the port so that the host assigns an available one.
-		port:    "0",
-		testCmd: c.testCmd,
+		port:     "0",
+		testCmd:  c.testCmd,
+		timeouts: proj.conf.timeouts(pulled),
+
+		idleTimeout: time.Duration(proj.conf.IdleTimeout),
+
+		user:       proj.conf.User,
+		groupAdd:   append(proj.conf.GroupAdd, c.groupAdd...),
+		usernsMode: proj.conf.UsernsMode,
+		bootstrap:  !proj.conf.DisableBootstrap,
+
+		sharedVolumes: proj.conf.SharedVolumes,
+
+		codeServer:   proj.conf.codeServerSource(),
+		forwardProxy: proj.conf.ForwardProxy,
+		shareDocker:  c.docker,
+		strictMounts: c.strictMounts,
+		readOnly:     c.readOnly,
+		overlay:      c.overlay,
+		devices:      c.devices,
+		groups:       proj.conf.groupsOf(proj.pathName()),
+		upstream:     formatUpstream(proj.pinned),
+		egress:       proj.conf.egressOf(proj.pathName()),
+		egressImage:  proj.conf.EgressImage,
+		timezone:     proj.conf.Timezone,
+		locale:       proj.conf.Locale,
+		gui:          proj.conf.GUI,
+		guiImage:     proj.conf.GUIImage,
+
+		disableGitIdentity: proj.conf.DisableGitIdentity,
+		gitCredentials:     proj.conf.GitCredentials,
+		commitSigning:      proj.conf.CommitSigning,
+
+		review:    c.review,
+		ephemeral: c.ephemeral,
 	}
-
-	err = c.build(c.gf, proj, b, r)
-	if err != nil {
-		flog.Error("build run failed: %v", err)
-		if !c.keep {
-			// We remove the container if it fails to start as that means the developer
-			// can iterate w/o having to do the obnoxious `docker rm` step.
-			c.gf.debug("removing %v", proj.cntName())
-			err = dockutil.StopRemove(context.Background(), dockerClient(), proj.cntName())
-			if err != nil {
-				flog.Error("failed to remove %v", proj.cntName())
-			}
+	if r.review == "" || !r.ephemeral {
+		// Rebuilt review and ephemeral environments stay what they are.
+		labels := existingLabels(proj.cntName())
+		if r.review == "" {
+			r.review = labels[reviewLabel]
 		}
-		os.Exit(1)
+		r.ephemeral = r.ephemeral || labels[ephemeralLabel] == "true"
 	}
+	r.audio, r.webcam = proj.conf.mediaOf(proj.pathName())
+	r.audio = r.audio || c.audio
+	r.webcam = r.webcam || c.webcam
 
-	if c.noOpen {
-		os.Exit(0)
+	var err error
+	r.extraMounts, err = loadMounts(proj.cntName())
+	if err != nil {
+		return nil, xerrors.Errorf("failed to load mounts: %w", err)
 	}
-
-	err = proj.open()
+	r.env, err = loadEnv(proj.cntName())
 	if err != nil {
-		flog.Fatal("failed to open project: %w", err)
+		return nil, xerrors.Errorf("failed to load environment: %w", err)
 	}
-
-	os.Exit(0)
+	if c.user != "" {
+		r.user = c.user
+	}
+	if c.usernsMode != "" {
+		r.usernsMode = c.usernsMode
+	}
+	return r, nil
 }
 
-func (c *runcmd) build(gf *globalFlags, proj *project, b *hatBuilder, r *runner) error {
+func (c *runcmd) build(ctx context.Context, gf *globalFlags, proj *project, b *hatBuilder, r *runner) error {
 	var err error
 	image := b.baseImage
 	if b.hatPath != "" {
@@ -254,21 +672,49 @@ func (c *runcmd) build(gf *globalFlags, proj *project, b *hatBuilder, r *runner)
 	}
 
 	// TODO proxy if container already exists.
-	err = r.forkProxy()
+	if r.usesDocker() {
+		err = r.forkProxy()
+		if err != nil {
+			return xerrors.Errorf("failed to start proxy: %w", err)
+		}
+	}
+
+	cntDir, err := r.projectDir(image)
 	if err != nil {
-		return xerrors.Errorf("failed to start proxy: %w", err)
+		return err
+	}
+	err = runHostHook(ctx, preCreateHook, hookEnv{
+		project:  proj.pathName(),
+		cntName:  r.cntName,
+		localDir: r.projectLocalDir,
+		cntDir:   cntDir,
+		url:      r.proxyURL,
+	})
+	if err != nil {
+		return err
 	}
 
-	err = r.runContainer(image)
+	err = r.runContainer(ctx, image)
 	if err != nil {
 		return xerrors.Errorf("failed to run container: %w", err)
 	}
 
-	gf.debug("started container")
+	xlog.Debug("started container")
+
+	// Other backends are reached without the proxy, and sail can't run
+	// commands in their containers.
+	if !r.usesDocker() {
+		err = waitURL(ctx, r.proxyURL, r.timeouts.start)
+		if err != nil {
+			return xerrors.Errorf("failed to wait for code-server: %w", err)
+		}
+		emitEvent(eventOnline, r.cntName, map[string]string{"url": r.proxyURL})
+		return nil
+	}
 
-	err = proj.waitOnline()
+	err = proj.waitOnline(ctx)
 	if err != nil {
-		flog.Error("failed to wait for project to be online: %v", err)
+		xlog.Error("failed to wait for project to be online: %v", err)
 
 		logs, logErr := proj.readCodeServerLog()
 		if logErr != nil {
@@ -279,6 +725,19 @@ func (c *runcmd) build(gf *globalFlags, proj *project, b *hatBuilder, r *runner)
 		return err
 	}
 
-	gf.debug("code-server online")
+	xlog.Debug("code-server online")
+
+	if (c.warmup || proj.conf.Warmup) && r.readOnly {
+		xlog.Warn("the dependencies of read-only projects aren't installed")
+	} else if c.warmup || proj.conf.Warmup {
+		err = warmup(ctx, r.cntName, image)
+		if err != nil {
+			// The environment is usable without its dependencies.
+			xlog.Error("%v", err)
+		}
+	}
+	emitEvent(eventOnline, r.cntName, map[string]string{"url": r.proxyURL})
+
+	runStartHooks(ctx, r.cntName, image, true)
 	return nil
 }
@@ -2,53 +2,77 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"runtime"
+	"strconv"
 	"strings"
-	"syscall"
 	"time"
 
-	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/mount"
 	"github.com/docker/docker/api/types/strslice"
+	"github.com/docker/docker/errdefs"
 	"github.com/docker/go-connections/nat"
 	"golang.org/x/xerrors"
 
-	"go.coder.com/flog"
 	"go.coder.com/sail/internal/dockutil"
+	"go.coder.com/sail/internal/xlog"
 )
 
 // containerLogPath is the location of the code-server log.
 const containerLogPath = "/tmp/code-server.log"
 
-// containerHome is the location of the user's home directory
-// inside of the container. This is only used in places where
-// docker won't expand the `~` path or the `$HOME` variable.
-// For example, when setting environment variables for the container.
-const containerHome = "/home/user"
+// codeServerRestartPath is created inside of the container to have code-server
+// started again once it exits.
+const codeServerRestartPath = "/tmp/.sail-restart-code-server"
+
+// codeServerEnvPath is sourced inside of the container before code-server
+// starts, so environment variables set with sail env apply on restart. It's
+// in the home of the container's user, as it may hold secrets.
+const codeServerEnvPath = "~/.sail-env"
+
+// legacyCodeServerEnvPath is where containers created before the environment
+// file moved to the user's home have it.
+const legacyCodeServerEnvPath = "/tmp/.sail-env"
 
 // Docker labels for sail state.
 const (
 	sailLabel = "com.coder.sail"
 
 	baseImageLabel       = sailLabel + ".base_image"
+	bootstrapLabel       = sailLabel + ".bootstrap"
+	createdSourcesLabel  = sailLabel + ".created_sources"
+	dockerSocketLabel    = sailLabel + ".docker_socket"
+	dockerfileHashLabel  = sailLabel + ".dockerfile_hash"
+	envKeysLabel         = sailLabel + ".env_keys"
 	hatLabel             = sailLabel + ".hat"
+	hatArgsLabel         = sailLabel + ".hat_args"
+	mountsLabel          = sailLabel + ".mounts"
+	nameLabel            = sailLabel + ".name"
+	ownerLabel           = sailLabel + ".owner"
 	projectLocalDirLabel = sailLabel + ".project_local_dir"
 	projectDirLabel      = sailLabel + ".project_dir"
 	projectNameLabel     = sailLabel + ".project_name"
 	proxyURLLabel        = sailLabel + ".proxy_url"
+	repoLabel            = sailLabel + ".repo"
+	schemaLabel          = sailLabel + ".schema"
 )
 
 // Docker labels for user configuration.
 const (
-	onStartLabel     = "on_start"
-	projectRootLabel = "project_root"
+	devicesLabel      = "devices"
+	fileWatcherLabel  = "file_watcher"
+	forwardPortsLabel = "forward_ports"
+	guestHomeLabel    = "guest_home"
+	guestUserLabel    = "guest_user"
+	onStartLabel      = "on_start"
+	projectRootLabel  = "project_root"
+	shareDockerLabel  = "share_docker"
 )
 
 // runner holds all the information needed to assemble a new sail container.
@@ -59,15 +83,179 @@ type runner struct {
 	cntName     string
 	projectName string
 
+	// name is the sail name of the project, e.g. cdr/sail.
+	name string
+	// repoURI is the clone URI of the project's repository.
+	repoURI string
+
 	hostname string
 
 	port string
+	// guestPort is the host port of the guest code-server, when ports are
+	// published.
+	guestPort string
 
 	projectLocalDir string
 
 	testCmd string
+	// wrapper is the command of the image's cmdLabel, which runs the
+	// launcher.
+	wrapper []string
+	// home is the home directory of the container's user, see guestHome.
+	home string
+	// bootstrap provisions images without a user for sail, see
+	// bootstrapImage.
+	bootstrap bool
+	// windows is set if the daemon runs Windows containers, see
+	// applyWindows.
+	windows bool
+	// sharedVolumes are the shared volumes images may mount, by name, with
+	// their targets.
+	sharedVolumes map[string]string
+	// owner is the host user the container counts against the quota of,
+	// see applyQuota.
+	owner string
+	// memory limits the memory of the container in bytes. Zero is unlimited.
+	memory int64
 
 	proxyURL string
+	// idleTimeout is how long the environment may be idle before the
+	// proxy stops it. Zero disables it.
+	idleTimeout time.Duration
+
+	// timeouts for creating and starting the container.
+	// The defaults are used if unset.
+	timeouts timeouts
+
+	// user overrides the uid[:gid] of the container's user.
+	user string
+	// groupAdd are supplementary groups for the container's user.
+	groupAdd []string
+	// usernsMode is the user namespace mode of the container.
+	usernsMode string
+
+	// devices are passed through to the container, in addition to those
+	// defined by the image's devices label.
+	devices []string
+	// audio and webcam pass the host's sound and video devices through,
+	// see mediaDevices. They're also enabled by the image's labels.
+	audio  bool
+	webcam bool
+	// mediaGroups are the gids owning the media devices, which the
+	// container's user is added to.
+	mediaGroups []string
+
+	// codeServer is where the code-server binary comes from.
+	codeServer codeServerSource
+
+	// forwardProxy sets the host's proxy environment variables in the
+	// container.
+	forwardProxy bool
+
+	// shareDocker mounts the host's Docker socket into the container.
+	// It's also enabled by the image's share_docker label.
+	shareDocker bool
+
+	// forwardPorts are container ports published on the host's loopback.
+	// They're only needed if publishesPorts, otherwise the container shares
+	// the host's network.
+	forwardPorts []string
+
+	// extraMounts are mounts of the form host_path:guest_path added
+	// with sail mount.
+	extraMounts []string
+
+	// strictMounts fails on conflicting mounts, rather than dropping those
+	// of lower precedence, see stripDuplicateMounts.
+	strictMounts bool
+	// mountSources are where mounts come from, by mountKey.
+	mountSources map[string]mountSource
+
+	// env are environment variables of the form KEY=VAL set with sail env.
+	env []string
+
+	// groups are the groups whose networks the container joins.
+	groups []string
+
+	// createdSources are the mount sources that sail created on the host
+	// because they didn't exist.
+	createdSources []string
+
+	// rootless is set if the daemon runs rootless, see applyRootless.
+	rootless bool
+	// desktop is set if the daemon runs in Docker Desktop's VM, in which
+	// case ports are published on the host's loopback.
+	desktop bool
+	// selinux is set if the daemon labels containers with SELinux, in
+	// which case sail's mounts are relabeled.
+	selinux bool
+	// relabels are the SELinux relabeling options of bind mounts, by their
+	// resolved target. See relabelMounts.
+	relabels map[string]string
+	// apparmor is set if the daemon confines containers with AppArmor, see
+	// checkAppArmor.
+	apparmor bool
+
+	// socket is the host path of the unix socket code-server listens on,
+	// see listenOnSocket. code-server listens on port if it's empty.
+	socket string
+
+	// upstream is the value of upstreamLabel, if the images the container's
+	// image is built from are already pinned. They're looked up otherwise.
+	upstream string
+
+	// sandbox runs the container unprivileged with only the project and
+	// sail's own directories mounted, for projects that aren't trusted.
+	sandbox bool
+
+	// egress restricts the container's outbound network, see applyEgress.
+	egress egressPolicy
+	// egressImage is the image of the sidecar enforcing egress.
+	egressImage string
+	// sidecarConfig and sidecarHostConfig are the spec of the sidecar
+	// enforcing egress, assembled by applyEgress.
+	sidecarConfig     *container.Config
+	sidecarHostConfig *container.HostConfig
+
+	// timezone and locale override the host's time zone and LANG in the
+	// container, see localeEnv.
+	timezone string
+	locale   string
+
+	// disableGitIdentity doesn't set the host's git identity in the
+	// container, see configureGit.
+	disableGitIdentity bool
+	// gitCredentials lets git in the container use the host's credentials,
+	// see serveGitCredentials.
+	gitCredentials bool
+	// commitSigning lets git in the container sign commits with the host's
+	// keys, see configureSigning.
+	commitSigning bool
+
+	// readOnly mounts the project directory read-only, see mountReadOnly.
+	readOnly bool
+	// overlay mounts the project directory as an overlay of the host's, whose
+	// changes are applied with sail apply, see mountOverlay.
+	overlay bool
+
+	// review is the pull request the container reviews, see reviewcmd.
+	review string
+	// ephemeral removes the container once it expires, see
+	// ephemeralExpiry.
+	ephemeral bool
+
+	// gui picks how GUI applications are shown, see guiMode.
+	gui string
+	// guiImage is the image of the sidecar running the virtual display of
+	// guiVNC.
+	guiImage string
+
+	// backend runs the container, Docker if it's nil.
+	backend containerBackend
+
+	// dryRun assembles the container's spec without creating anything on
+	// the host, for sail run --dry-run.
+	dryRun bool
 }
 
 // runContainer creates and runs a new container.
@@ -77,44 +265,236 @@ type runner struct {
 // the container is only online when code-server is working.
 // Additionally, runContainer also runs the image's `on_start` label as a bash
 // command inside of the project directory.
-func (r *runner) runContainer(image string) error {
-	cli := dockerClient()
-	defer cli.Close()
+// If ctx is canceled, the partially created container is removed.
+func (r *runner) runContainer(ctx context.Context, image string) error {
+	to := r.timeouts
+	if to == (timeouts{}) {
+		to = config{}.timeouts(false)
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	image, err := r.bootstrapImage(ctx, image)
+	if err != nil {
+		return err
+	}
+	err = r.applyQuota(ctx)
+	if err != nil {
+		return err
+	}
+
+	containerConfig, hostConfig, err := r.containerSpec(image)
+	if err != nil {
+		return err
+	}
+
+	if r.sidecarConfig != nil {
+		err = r.createEgress(ctx, r.sidecarConfig, r.sidecarHostConfig, to)
+		if err != nil {
+			r.removePartial()
+			return xerrors.Errorf("failed to restrict egress: %w", err)
+		}
+	}
+	if r.guiMode() == guiVNC {
+		err = r.createGUI(ctx, to)
+		if err != nil {
+			r.removePartial()
+			return xerrors.Errorf("failed to start virtual display: %w", err)
+		}
+	}
+
+	b := r.containerBackend()
+	createCtx, cancel := context.WithTimeout(ctx, to.create)
 	defer cancel()
+	err = b.create(createCtx, r.cntName, containerConfig, hostConfig)
+	if err != nil {
+		// The daemon may have created the container before failing. On
+		// conflicts another container has the name, which isn't ours to
+		// remove.
+		if !errdefs.IsConflict(err) {
+			r.removePartial()
+		}
+		return xerrors.Errorf("failed to create container: %w", err)
+	}
+	if len(r.groups) > 0 && r.egress.restricted() {
+		xlog.Warn("%v doesn't join its groups, as its egress is restricted", r.name)
+	} else if r.usesDocker() {
+		err = joinGroups(createCtx, r.cntName, r.groups)
+		if err != nil {
+			r.removePartial()
+			return err
+		}
+	}
+
+	startCtx, cancel := context.WithTimeout(ctx, to.start)
+	defer cancel()
+	err = b.start(startCtx, r.cntName)
+	if err != nil {
+		r.removePartial()
+		return xerrors.Errorf("failed to start container: %w", err)
+	}
+
+	if r.usesDocker() {
+		err = r.configureGit()
+		if err != nil {
+			xlog.Warn("failed to configure git in %v: %v", r.name, err)
+		}
+		err = r.configureSigning()
+		if err != nil {
+			xlog.Warn("failed to set up commit signing in %v: %v", r.name, err)
+		}
+		err = r.runOnStart(image)
+		if err != nil {
+			return xerrors.Errorf("failed to run on_start label in container: %w", err)
+		}
+	}
+
+	if ctx.Err() != nil {
+		r.removePartial()
+		return ctx.Err()
+	}
 
+	return nil
+}
+
+// containerSpec assembles the configuration of the container for image.
+func (r *runner) containerSpec(image string) (*container.Config, *container.HostConfig, error) {
 	projectDir, err := r.projectDir(image)
 	if err != nil {
-		return err
+		return nil, nil, err
+	}
+
+	labels, err := imageLabels(image)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := dockerInfo()
+	if err != nil {
+		return nil, nil, err
+	}
+	r.home = guestHome(labels)
+	// Only Docker runs the container, the daemon just builds its image.
+	r.rootless = hasSecurityOption(info.SecurityOptions, "rootless") && r.usesDocker()
+	r.selinux = hasSecurityOption(info.SecurityOptions, "selinux")
+	r.apparmor = hasSecurityOption(info.SecurityOptions, "apparmor")
+	r.desktop = isDockerDesktop(info)
+	r.windows = info.OSType == windowsOSType
+	if r.windows && labels[guestHomeLabel] == "" {
+		r.home = windowsGuestHome
+	}
+	if r.windows && !r.usesDocker() {
+		return nil, nil, xerrors.New("Windows containers can only be run by Docker")
+	}
+	if r.windows && r.shareDocker {
+		return nil, nil, xerrors.New("the Docker socket can't be shared with Windows containers")
+	}
+	if !r.egress.restricted() {
+		r.egress = labelEgress(labels)
+	}
+	if r.egress.restricted() && (r.windows || !r.usesDocker()) {
+		return nil, nil, xerrors.New("egress can only be restricted for Linux containers run by Docker")
+	}
+	if r.egress.Mode == egressProxy {
+		r.forwardProxy = true
+	}
+	err = r.checkGUI()
+	if err != nil {
+		return nil, nil, err
+	}
+	r.listenOnSocket()
+	if r.publishesPorts() && r.socket == "" && !r.dryRun {
+		r.port, err = assignPort(r.projectCntName(), codeServerPortName)
+		if err != nil {
+			return nil, nil, err
+		}
+		r.guestPort, err = assignPort(r.projectCntName(), guestPortName)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !r.usesDocker() {
+			// Other backends have no proxy, code-server is opened directly.
+			r.proxyURL = "http://127.0.0.1:" + r.port
+		}
+	}
+	// Images sail builds were checked already, so only report the warnings
+	// of others with -v.
+	warnings, err := checkLabels(labels)
+	for _, w := range warnings {
+		xlog.Debug("%v: %v", image, w)
+	}
+	if err != nil {
+		return nil, nil, xerrors.Errorf("invalid label in %v: %w", image, err)
+	}
+	if !r.shareDocker {
+		r.shareDocker = labels[shareDockerLabel] == "true"
+	}
+	if devs := labels[devicesLabel]; devs != "" {
+		r.devices = append(r.devices, strings.Split(devs, ",")...)
+	}
+	r.audio = r.audio || labels[audioLabel] == "true"
+	r.webcam = r.webcam || labels[webcamLabel] == "true"
+	if r.sandbox {
+		r.shareDocker = false
+		r.devices = nil
+		r.audio = false
+		r.webcam = false
+	}
+	// Containers with the Docker socket could run others on the host's
+	// network, around the egress rules.
+	if r.shareDocker && r.egress.restricted() {
+		xlog.Warn("the Docker socket isn't shared with %v, as its egress is restricted", r.name)
+		r.shareDocker = false
+	}
+	mediaDevs, mediaGroups, err := mediaDevices("/dev", r.audio, r.webcam)
+	if err != nil {
+		return nil, nil, err
+	}
+	r.devices = append(r.devices, mediaDevs...)
+	r.mediaGroups = mediaGroups
+	if ports := labels[forwardPortsLabel]; ports != "" {
+		r.forwardPorts = strings.Split(ports, ",")
+	}
+	r.wrapper, err = parseCmdLabel(labels[cmdLabel])
+	if err != nil {
+		return nil, nil, err
 	}
 
 	var envs []string
 	envs = r.environment(envs)
+	envs = append(envs, r.localeEnv(labels)...)
+	if r.pollFiles(labels) {
+		envs = append(envs, pollingEnv)
+	}
+	envs = append(envs, r.env...)
 
 	containerConfig := &container.Config{
 		Hostname: r.hostname,
 		Env:      envs,
-		Cmd: strslice.StrSlice{
-			"bash", "-c", r.constructCommand(projectDir),
-		},
-		Image: image,
+		Cmd:      strslice.StrSlice(r.command(projectDir)),
+		Image:    image,
 		Labels: map[string]string{
 			sailLabel:            "",
+			nameLabel:            r.name,
 			projectDirLabel:      projectDir,
 			projectLocalDirLabel: r.projectLocalDir,
 			projectNameLabel:     r.projectName,
 			proxyURLLabel:        r.proxyURL,
+			repoLabel:            r.repoURI,
+			schemaLabel:          strconv.Itoa(stateSchema),
 		},
 		// The user inside has uid 1000. This works even on macOS where the default user has uid 501.
 		// See https://stackoverflow.com/questions/43097341/docker-on-macosx-does-not-translate-file-ownership-correctly-in-volumes
-		// The docker image runs it as uid 1000 so we don't need to set anything.
-		User: "",
+		// The docker image runs it as uid 1000 so we don't need to set anything unless the
+		// user asked for a different uid.
+		User: r.user,
+	}
+	r.applyRootless(containerConfig)
+	// The image's user is only overridden by the config and rootless daemons.
+	if containerConfig.User == "" {
+		containerConfig.User = labels[guestUserLabel]
 	}
 
 	err = r.addImageDefinedLabels(image, containerConfig.Labels)
 	if err != nil {
-		return xerrors.Errorf("failed to add image defined labels: %w", err)
+		return nil, nil, xerrors.Errorf("failed to add image defined labels: %w", err)
 	}
 
 	var mounts []mount.Mount
@@ -122,62 +502,246 @@ func (r *runner) runContainer(image string) error {
 
 	mounts, err = r.mounts(mounts, image)
 	if err != nil {
-		return xerrors.Errorf("failed to assemble mounts: %w", err)
+		return nil, nil, xerrors.Errorf("failed to assemble mounts: %w", err)
+	}
+	if !r.sandbox {
+		mounts = mountNixStore(mounts, labels)
+		mounts, err = r.mountSharedVolumes(context.Background(), mounts, image, labels)
+		if err != nil {
+			return nil, nil, xerrors.Errorf("failed to mount shared volumes: %w", err)
+		}
+	}
+	mounts = r.mountVNC(mounts)
+	mounts = r.mountReadOnly(mounts, projectDir)
+	mounts, err = r.mountOverlay(mounts, projectDir)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("failed to mount overlay: %w", err)
+	}
+	if r.gitCredentials && (r.sandbox || r.socket == "") {
+		// The credential socket is in the directory of code-server's.
+		xlog.Warn("git credentials are only shared with trusted containers on Linux hosts")
+		r.gitCredentials = false
+	}
+	if r.commitSigning && r.sandbox {
+		xlog.Warn("commit signing keys are only shared with trusted containers")
+		r.commitSigning = false
+	}
+	mounts = r.mountLocaltime(mounts, labels)
+	mounts, err = r.mountSocketDir(mounts)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("failed to mount code-server socket: %w", err)
+	}
+	if r.windows {
+		mounts, err = r.windowsMounts(mounts, projectDir)
+		if err != nil {
+			return nil, nil, xerrors.Errorf("failed to assemble mounts: %w", err)
+		}
+	}
+	if r.socket != "" {
+		containerConfig.Labels[codeServerSocketLabel] = r.socket
+	}
+	if r.shareDocker {
+		containerConfig.Labels[dockerSocketLabel] = "true"
+	}
+	if r.forwardProxy {
+		containerConfig.Labels[forwardProxyLabel] = "true"
+	}
+	if r.audio {
+		containerConfig.Labels[audioLabel] = "true"
+	}
+	if r.webcam {
+		containerConfig.Labels[webcamLabel] = "true"
+	}
+	if r.gitCredentials {
+		containerConfig.Labels[gitCredentialsLabel] = "true"
+	}
+	if r.commitSigning {
+		containerConfig.Labels[commitSigningLabel] = "true"
+	}
+	if r.review != "" {
+		containerConfig.Labels[reviewLabel] = r.review
+	}
+	if r.ephemeral {
+		containerConfig.Labels[ephemeralLabel] = "true"
+	}
+	if r.readOnly {
+		containerConfig.Labels[readOnlyLabel] = "true"
+	}
+	if r.overlay {
+		containerConfig.Labels[overlayLabel] = "true"
+	}
+	if r.gui != "" && r.gui != guiAuto {
+		containerConfig.Labels[guiLabel] = r.gui
+	}
+	if r.owner != "" {
+		containerConfig.Labels[ownerLabel] = r.owner
+	}
+	if len(r.createdSources) > 0 {
+		containerConfig.Labels[createdSourcesLabel] = encodeCreatedSources(r.createdSources)
+	}
+	if len(r.extraMounts) > 0 {
+		containerConfig.Labels[mountsLabel] = strings.Join(r.extraMounts, "\n")
+	}
+	if len(r.env) > 0 {
+		containerConfig.Labels[envKeysLabel] = strings.Join(envKeys(r.env), ",")
+	}
+	switch {
+	case r.upstream != "":
+		containerConfig.Labels[upstreamLabel] = r.upstream
+	case !r.dryRun:
+		containerConfig.Labels[upstreamLabel] = r.pinUpstream(image, labels)
 	}
 
 	hostConfig, err := r.hostConfig(containerConfig, mounts)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
+	return containerConfig, hostConfig, nil
+}
 
-	_, err = cli.ContainerCreate(ctx, containerConfig, hostConfig, nil, r.cntName)
+// pinUpstream returns the value of upstreamLabel for image, whose labels are
+// labels. The container is created without it if the images can't be pinned.
+func (r *runner) pinUpstream(image string, labels map[string]string) string {
+	ctx, cancel := context.WithTimeout(context.Background(), upstreamTimeout)
+	defer cancel()
+
+	pins, err := pinUpstream(ctx, image, labels, r.projectLocalDir)
 	if err != nil {
-		return xerrors.Errorf("failed to create container: %w", err)
+		xlog.Debug("failed to pin upstream images: %v", err)
+		return ""
 	}
+	return formatUpstream(pins)
+}
 
-	err = cli.ContainerStart(ctx, r.cntName, types.ContainerStartOptions{})
+// removePartial removes the runner's container after it failed to be
+// created or started, so it doesn't get left behind half-initialized.
+func (r *runner) removePartial() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+
+	err := r.containerBackend().remove(ctx, r.cntName)
 	if err != nil {
-		return xerrors.Errorf("failed to start container: %w", err)
+		xlog.Error("failed to clean up %v: %v", r.cntName, err)
 	}
+	if r.sidecarConfig != nil || r.guiMode() == guiVNC {
+		cli := dockerClient()
+		defer cli.Close()
+		err = removeSidecars(ctx, cli, r.projectCntName())
+		if err != nil {
+			xlog.Error("%v", err)
+		}
+	}
+}
 
-	err = r.runOnStart(image)
+// launcherScript is the Sail container's init process, which runs code-server.
+// Its arguments are passed after it, so they may contain any characters:
+//
+//	$1 the project directory
+//	$2 the environment file
+//	$3 and $4 the address and port code-server listens on
+//	$5 the extensions directory
+//	$6 the log file
+//	$7 the restart file
+//	$8 the socket code-server listens on instead of $3 and $4, if set
+//
+// We want the code-server logs to be available inside the container for easy
+// access during development, but also going to stdout so `docker logs` can be used
+// to debug a failed code-server startup.
+//
+// We start code-server such that extensions installed through the UI are placed in the host's extension dir.
+//
+// code-server is started again if it's killed after the restart file was created, so
+// sail restart can restart it without restarting the container.
+//
+// The environment file is sourced in the pipeline's subshell, so variables removed
+// from it don't linger, and without tracing, so their values aren't logged.
+//
+// code-server creates its socket only writable by the container's user, which may
+// not be the host user, so the socket is opened up once it appears. Its directory
+// on the host is only reachable by the host user.
+const launcherScript = `set -euxo pipefail || exit 1
+cd "$1"
+# This is necessary in case the .vscode directory wasn't created inside the container, as mounting to the host
+# extension dir will create it as root.
+sudo chown "$(id -u):$(id -g)" ~/.vscode
+listen=(--host "$3" --port "$4")
+if [ -n "$8" ]; then listen=(--socket "$8"); fi
+while true; do
+status=0
+if [ -n "$8" ]; then
+rm -f "$8"
+( set +x; for _ in $(seq 600); do if [ -S "$8" ]; then chmod 666 "$8"; exit; fi; sleep 0.1; done ) &
+fi
+{ set +x; if [ -f "$2" ]; then . "$2"; fi
+/usr/bin/code-server "${listen[@]}" --user-data-dir ~/.config/Code --extensions-dir "$5" --extra-extensions-dir ~/.vscode/extensions --auth=none \
+--allow-http; } 2>&1 | tee "$6" || status=$?
+if [ ! -f "$7" ]; then exit $status; fi
+rm -f "$7"
+done`
+
+// launcherName is $0 of launcherScript, which shows up in its errors.
+const launcherName = "sail-launcher"
+
+// cmdLabel is an image label with a command wrapping sail's launcher, e.g. to
+// set up the container before code-server starts. The launcher's argv is
+// appended to it, so the command must run its arguments, e.g. with exec "$@".
+const cmdLabel = sailLabel + ".cmd"
+
+// parseCmdLabel parses the value of cmdLabel, either a JSON array like the
+// exec form of a Dockerfile CMD, or arguments separated by spaces.
+func parseCmdLabel(v string) ([]string, error) {
+	v = strings.TrimSpace(v)
+	if !strings.HasPrefix(v, "[") {
+		return strings.Fields(v), nil
+	}
+	var cmd []string
+	err := json.Unmarshal([]byte(v), &cmd)
 	if err != nil {
-		return xerrors.Errorf("failed to run on_start label in container: %w", err)
+		return nil, xerrors.Errorf("invalid command %q, must be a JSON array of strings or arguments separated by spaces", v)
 	}
-
-	return nil
+	return cmd, nil
 }
 
-// constructCommand constructs the code-server command that will be used
-// as the Sail container's init process.
-func (r *runner) constructCommand(projectDir string) string {
+// command returns the argv of the Sail container's init process.
+func (r *runner) command(projectDir string) []string {
+	if r.testCmd != "" {
+		return []string{"bash", "-c", r.testCmd + "\n exit 1"}
+	}
+
 	containerAddr := "localhost"
 	containerPort := r.port
-	if runtime.GOOS == "darwin" {
+	if r.publishesPorts() {
 		// See justification in `runner.hostConfig`.
 		containerPort = "8443"
 		containerAddr = "0.0.0.0"
 	}
-
-	// We want the code-server logs to be available inside the container for easy
-	// access during development, but also going to stdout so `docker logs` can be used
-	// to debug a failed code-server startup.
-	//
-	// We start code-server such that extensions installed through the UI are placed in the host's extension dir.
-	cmd := fmt.Sprintf(`set -euxo pipefail || exit 1
-cd %v
-# This is necessary in case the .vscode directory wasn't created inside the container, as mounting to the host
-# extension dir will create it as root.
-sudo chown user:user ~/.vscode
-/usr/bin/code-server --host %v --port %v --user-data-dir ~/.config/Code --extensions-dir %v --extra-extensions-dir ~/.vscode/extensions --auth=none \
---allow-http 2>&1 | tee %v`,
-		projectDir, containerAddr, containerPort, hostExtensionsDir, containerLogPath)
-
-	if r.testCmd != "" {
-		cmd = r.testCmd + "\n exit 1"
+	var socket string
+	if r.socket != "" {
+		socket = containerSocketDir + "/" + codeServerSocketName
+	}
+	if r.windows {
+		return append(append([]string(nil), r.wrapper...), windowsCommand(
+			windowsGuestPath(resolvePath(r.guestHome(), projectDir)),
+			containerAddr,
+			containerPort,
+			windowsGuestPath(resolvePath(r.guestHome(), hostExtensionsDir)),
+			windowsGuestPath(containerLogPath),
+			windowsGuestPath(codeServerRestartPath),
+			windowsGuestPath(windowsCodeServerDir)+`\bin\code-server.cmd`,
+		)...)
 	}
 
-	return cmd
+	return r.overlayCommand(append(append([]string(nil), r.wrapper...),
+		"bash", "-c", launcherScript, launcherName,
+		resolvePath(r.guestHome(), projectDir),
+		resolvePath(r.guestHome(), codeServerEnvPath),
+		containerAddr,
+		containerPort,
+		resolvePath(r.guestHome(), hostExtensionsDir),
+		containerLogPath,
+		codeServerRestartPath,
+		socket,
+	), projectDir)
 }
 
 // hostConfig constructs the container.HostConfig required for starting the sail container.
@@ -185,24 +749,79 @@ func (r *runner) hostConfig(containerConfig *container.Config, mounts []mount.Mo
 	hostConfig := &container.HostConfig{
 		Mounts:      mounts,
 		NetworkMode: "host",
-		Privileged:  true,
 		ExtraHosts: []string{
 			r.hostname + ":127.0.0.1",
 		},
+		UsernsMode: container.UsernsMode(r.usernsMode),
+		Resources: container.Resources{
+			Memory: r.memory,
+		},
+	}
+	r.relabelMounts(hostConfig)
+
+	err := applySecurity(hostConfig, containerConfig.Labels)
+	if err != nil {
+		return nil, err
+	}
+	err = r.checkAppArmor(containerConfig.Labels)
+	if err != nil {
+		return nil, err
 	}
 
-	// macOS does not support host networking.
+	groups, err := resolveGroupAdd(r.groupAdd)
+	if err != nil {
+		return nil, err
+	}
+	hostConfig.Devices, err = parseDevices(r.devices)
+	if err != nil {
+		return nil, err
+	}
+
+	// The socket of rootless daemons is owned by the host user, which is
+	// already root in the container.
+	if r.shareDocker && !r.rootless {
+		gid, err := dockerSocketGroup()
+		if err != nil {
+			return nil, err
+		}
+		if gid != "" {
+			groups = append(groups, gid)
+		}
+	}
+	for _, gid := range r.mediaGroups {
+		if !stringsContain(groups, gid) {
+			groups = append(groups, gid)
+		}
+	}
+	hostConfig.GroupAdd = groups
+
+	// Docker Desktop does not support host networking, and rootless daemons
+	// don't share the host's network. code-server's port is only published if it
+	// doesn't listen on a socket.
 	// See https://github.com/docker/for-mac/issues/2716
-	if runtime.GOOS == "darwin" {
-		portSpec := fmt.Sprintf("127.0.0.1:%v:%v/tcp", r.port, "8443")
+	if r.publishesPorts() {
+		var portSpecs []string
+		if r.socket == "" {
+			portSpecs = append(portSpecs,
+				fmt.Sprintf("127.0.0.1:%v:%v/tcp", r.port, "8443"),
+				fmt.Sprintf("127.0.0.1:%v:%v/tcp", r.guestPort, guestCodeServerPort),
+			)
+		}
+		for _, p := range r.forwardPorts {
+			p = strings.TrimSpace(p)
+			portSpecs = append(portSpecs, fmt.Sprintf("127.0.0.1:%v:%v/tcp", p, p))
+		}
 		hostConfig.NetworkMode = ""
-		exposed, bindings, err := nat.ParsePortSpecs([]string{portSpec})
+		exposed, bindings, err := nat.ParsePortSpecs(portSpecs)
 		if err != nil {
 			return nil, xerrors.Errorf("failed to parse port spec: %w", err)
 		}
 		containerConfig.ExposedPorts = exposed
 		hostConfig.PortBindings = bindings
 	}
+	r.applySandbox(containerConfig, hostConfig)
+	r.applyWindows(containerConfig, hostConfig)
+	r.sidecarConfig, r.sidecarHostConfig = r.applyEgress(containerConfig, hostConfig)
 
 	return hostConfig, nil
 }
@@ -216,18 +835,13 @@ func (r *runner) environment(envs []string) []string {
 		envs = append(envs, s)
 	}
 
-	if runtime.GOOS == "linux" {
-		// When on linux and the display variable exists we forward it so
-		// that GUI applications can run.
-		if os.Getenv("DISPLAY") != "" {
-			envs = append(envs, "DISPLAY="+os.Getenv("DISPLAY"))
-		}
-
-		if os.Getenv("XAUTHORITY") != "" {
-			envs = append(envs, "XAUTHORITY="+filepath.Join(containerHome, ".Xauthority"))
-		}
+	if r.forwardProxy {
+		envs = append(envs, proxyEnv()...)
 	}
 
+	// GUI applications are shown on the host's display, or a virtual one.
+	envs = append(envs, r.guiEnv()...)
+
 	return envs
 }
 
@@ -238,6 +852,7 @@ func (r *runner) addHatMount(mounts []mount.Mount, labels map[string]string) []m
 		return mounts
 	}
 
+	r.relabelOwn("~/.hat")
 	return append(mounts, mount.Mount{
 		Type:   "bind",
 		Source: hatPath,
@@ -248,36 +863,28 @@ func (r *runner) addHatMount(mounts []mount.Mount, labels map[string]string) []m
 const hostExtensionsDir = "~/.vscode/host-extensions"
 
 func (r *runner) mounts(mounts []mount.Mount, image string) ([]mount.Mount, error) {
-	// Mount in VS Code configs.
-	mounts = append(mounts, mount.Mount{
-		Type:   "bind",
-		Source: vscodeConfigDir(),
-		Target: "~/.config/Code",
-	})
-	mounts = append(mounts, mount.Mount{
-		Type:   "bind",
-		Source: vscodeExtensionsDir(),
-		Target: hostExtensionsDir,
-	})
-
-	mounts = mountGUI(mounts)
-
-	// 'SSH_AUTH_SOCK' is provided by a running ssh-agent. Passing in the
-	// socket to the container allows for using the user's existing setup for
-	// ssh authentication instead of having to create a new keys or explicity
-	// pass them in.
-	if sshAuthSock, exists := os.LookupEnv("SSH_AUTH_SOCK"); exists {
+	if r.sandbox {
+		// Extensions are installed in the container's own directory, which
+		// also creates ~/.vscode.
 		mounts = append(mounts, mount.Mount{
 			Type:   "bind",
-			Source: sshAuthSock,
-			Target: sshAuthSock,
+			Source: filepath.Join(metaRoot(), r.cntName, "extensions"),
+			Target: hostExtensionsDir,
 		})
+	} else {
+		var err error
+		mounts, err = r.hostMounts(mounts)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	localGlobalStorageDir := filepath.Join(metaRoot(), r.cntName, "globalStorage")
-	err := os.MkdirAll(localGlobalStorageDir, 0750)
-	if err != nil {
-		return nil, err
+	if !r.dryRun {
+		err := os.MkdirAll(localGlobalStorageDir, 0750)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	// globalStorage holds the UI state, and other code-server specific
@@ -287,6 +894,11 @@ func (r *runner) mounts(mounts []mount.Mount, image string) ([]mount.Mount, erro
 		Source: localGlobalStorageDir,
 		Target: "~/.local/share/code-server/globalStorage/",
 	})
+	// Sail's directories and the project are relabeled for SELinux, the
+	// sockets of the host aren't, as it would break them for the host.
+	r.relabelOwn("~/.config/Code")
+	r.relabelOwn(hostExtensionsDir)
+	r.relabelOwn("~/.local/share/code-server/globalStorage/")
 
 	projectDir, err := r.projectDir(image)
 	if err != nil {
@@ -298,66 +910,134 @@ func (r *runner) mounts(mounts []mount.Mount, image string) ([]mount.Mount, erro
 		Source: r.projectLocalDir,
 		Target: projectDir,
 	})
+	r.relabelOwn(projectDir)
 
-	// Mount in code-server
-	codeServerBinPath, err := loadCodeServer(context.Background())
-	if err != nil {
-		return nil, xerrors.Errorf("failed to load code-server: %w", err)
+	if r.windows {
+		mounts = r.mountWindowsCodeServer(mounts)
+	} else {
+		mounts, err = r.mountCodeServer(mounts, image)
+		if err != nil {
+			return nil, err
+		}
 	}
-	mounts = append(mounts, mount.Mount{
-		Type:   mount.TypeBind,
-		Source: codeServerBinPath,
-		Target: "/usr/bin/code-server",
-	})
 
-	// We take the mounts from the final image so that it includes the hat and the baseImage.
-	mounts, err = r.imageDefinedMounts(image, mounts)
+	if !r.sandbox {
+		sailMounts := mounts
+		// We take the mounts from the final image so that it includes the hat and the baseImage.
+		mounts, err = r.imageDefinedMounts(image, mounts)
+		if err != nil {
+			return nil, err
+		}
+
+		// Mounts added with sail mount take precedence over shares, so they
+		// are only checked against sail's own mounts.
+		mounts, err = r.addExtraMounts(projectDir, sailMounts, mounts)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = r.resolveMounts(mounts)
 	if err != nil {
 		return nil, err
 	}
-
-	r.resolveMounts(mounts)
-
-	err = r.ensureMountSources(mounts)
+	// Docker refuses to create containers with duplicate mount targets.
+	mounts, err = r.stripDuplicateMounts(mounts)
 	if err != nil {
 		return nil, err
 	}
 
+	if !r.dryRun {
+		err = r.ensureMountSources(mounts)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return mounts, nil
 }
 
-// mountGUI mounts in any x11 sockets so that they can be used
-// inside the container.
-func mountGUI(mounts []mount.Mount) []mount.Mount {
-	if runtime.GOOS == "linux" {
-		// Only mount in the x11 socket if the DISPLAY env exists.
-		if os.Getenv("DISPLAY") == "" {
-			return mounts
+// hostMounts adds the mounts of the host's VS Code settings and sockets,
+// which aren't shared with sandboxed containers.
+func (r *runner) hostMounts(mounts []mount.Mount) ([]mount.Mount, error) {
+	// Mount in VS Code configs.
+	mounts = append(mounts, mount.Mount{
+		Type:   "bind",
+		Source: vscodeConfigDir(),
+		Target: "~/.config/Code",
+	})
+	mounts = append(mounts, mount.Mount{
+		Type:   "bind",
+		Source: vscodeExtensionsDir(),
+		Target: hostExtensionsDir,
+	})
+
+	mounts = r.mountGUI(mounts)
+
+	if r.shareDocker {
+		var err error
+		mounts, err = mountDockerSocket(mounts)
+		if err != nil {
+			return nil, err
 		}
+	}
 
-		const xsock = "/tmp/.X11-unix"
+	// 'SSH_AUTH_SOCK' is provided by a running ssh-agent. Passing in the
+	// socket to the container allows for using the user's existing setup for
+	// ssh authentication instead of having to create a new keys or explicity
+	// pass them in.
+	if sshAuthSock, exists := os.LookupEnv("SSH_AUTH_SOCK"); exists && !r.windows {
 		mounts = append(mounts, mount.Mount{
 			Type:   "bind",
-			Source: xsock,
-			Target: xsock,
+			Source: sshAuthSock,
+			Target: sshAuthSock,
 		})
+	}
+	mounts = r.mountGPGAgent(mounts)
+	return mounts, nil
+}
 
-		// We also mount the xauthority file in so any xsessions can store
-		// session cookies.
-		if os.Getenv("XAUTHORITY") != "" {
-			mounts = append(mounts, mount.Mount{
-				Type:   "bind",
-				Source: os.Getenv("XAUTHORITY"),
-				Target: "~/.Xauthority",
-			})
+// mountCodeServer mounts in code-server, built for the image's architecture,
+// which may be emulated.
+func (r *runner) mountCodeServer(mounts []mount.Mount, image string) ([]mount.Mount, error) {
+	arch, err := imageArch(image)
+	if err != nil {
+		return nil, err
+	}
+	var codeServerBinPath string
+	if r.dryRun {
+		codeServerBinPath = r.codeServer.cachedPath(arch)
+	} else {
+		codeServerBinPath, err = r.codeServer.load(context.Background(), arch)
+		if err != nil {
+			return nil, xerrors.Errorf("failed to load code-server: %w", err)
 		}
 	}
+	mounts = append(mounts, mount.Mount{
+		Type:   mount.TypeBind,
+		Source: codeServerBinPath,
+		Target: "/usr/bin/code-server",
+	})
+	r.relabelOwn("/usr/bin/code-server")
+	return mounts, nil
+}
 
-	return mounts
+// mountWindowsCodeServer mounts in the Windows build of code-server, if one
+// is configured. Otherwise the image provides it.
+func (r *runner) mountWindowsCodeServer(mounts []mount.Mount) []mount.Mount {
+	if r.codeServer.windowsPath == "" {
+		return mounts
+	}
+	return append(mounts, mount.Mount{
+		Type:   mount.TypeBind,
+		Source: r.codeServer.windowsLocalPath(),
+		Target: windowsCodeServerDir,
+	})
 }
 
 // ensureMountSources ensures that the mount's source exists. If the source
-// doesn't exist, it will be created as a directory on the host.
+// doesn't exist, it will be created as a directory on the host, owned by
+// the host user. The created directories are recorded in r.createdSources.
 func (r *runner) ensureMountSources(mounts []mount.Mount) error {
 	for _, mount := range mounts {
 		_, err := os.Stat(mount.Source)
@@ -368,7 +1048,8 @@ func (r *runner) ensureMountSources(mounts []mount.Mount) error {
 			return xerrors.Errorf("failed to stat mount source %v: %w", mount.Source, err)
 		}
 
-		err = os.MkdirAll(mount.Source, 0755)
+		created, err := mkdirAllHooked(mount.Source, 0755)
+		r.createdSources = append(r.createdSources, created...)
 		if err != nil {
 			return xerrors.Errorf("failed to create mount source %v: %w", mount.Source, err)
 		}
@@ -377,7 +1058,9 @@ func (r *runner) ensureMountSources(mounts []mount.Mount) error {
 	return nil
 }
 
-// imageDefinedMounts adds a list of shares to the shares map from the image.
+// imageDefinedMounts adds the shares defined by the image's labels to mounts.
+// mounts should only contain sail's own mounts, as the shares are checked
+// for collisions with them.
 func (r *runner) imageDefinedMounts(image string, mounts []mount.Mount) ([]mount.Mount, error) {
 	cli := dockerClient()
 	defer cli.Close()
@@ -387,22 +1070,54 @@ func (r *runner) imageDefinedMounts(image string, mounts []mount.Mount) ([]mount
 		return nil, xerrors.Errorf("failed to inspect %v: %w", image, err)
 	}
 
-	for k, v := range ins.ContainerConfig.Labels {
-		const prefix = "share."
-		if !strings.HasPrefix(k, prefix) {
-			continue
+	projectDir, err := r.projectDir(image)
+	if err != nil {
+		return nil, err
+	}
+
+	labels := ins.Config.Labels
+	hatLabels := hatShareLabels(image, labels)
+	for _, k := range shareLabels(labels) {
+		m, err := parseShareLabel(k, labels[k])
+		if err != nil {
+			return nil, err
 		}
 
-		tokens := strings.Split(v, ":")
-		if len(tokens) != 2 {
-			return nil, xerrors.Errorf("invalid share %q", v)
+		// Resolve the mount now, while we still know which label defined it.
+		if merr := r.expandMount(&m); merr != nil {
+			merr.label = k
+			return nil, merr
+		}
+		if merr := resolveMount(&m, r.guestHome()); merr != nil {
+			merr.label = k
+			return nil, merr
 		}
 
-		mounts = append(mounts, mount.Mount{
-			Type:   mount.TypeBind,
-			Source: tokens[0],
-			Target: tokens[1],
-		})
+		shares := []mount.Mount{m}
+		if isGlob(m.Source) {
+			shares, err = globMounts(m)
+			if err != nil {
+				return nil, &mountError{label: k, mount: m, err: err}
+			}
+			if len(shares) == 0 {
+				xlog.Debug("%v: nothing matches %v, it's skipped", k, m.Source)
+			}
+		}
+		for _, m := range shares {
+			err = checkShare(k, m, mounts, projectDir, r.guestHome())
+			if err != nil {
+				return nil, err
+			}
+			if _, opt := splitRelabel(labels[k]); opt != "" {
+				r.relabel(m.Target, opt)
+			}
+			src := mountSource{origin: originImage, desc: "image label " + k}
+			if hatLabels[k] {
+				src = mountSource{origin: originHat, desc: "hat label " + k}
+			}
+			r.setMountSource(m, src)
+			mounts = append(mounts, m)
+		}
 	}
 	return mounts, nil
 }
@@ -417,7 +1132,7 @@ func (r *runner) addImageDefinedLabels(image string, labels map[string]string) e
 		return xerrors.Errorf("failed to inspect %v: %w", image, err)
 	}
 
-	for k, v := range ins.ContainerConfig.Labels {
+	for k, v := range ins.Config.Labels {
 		if !strings.HasPrefix(k, sailLabel) {
 			continue
 		}
@@ -428,39 +1143,58 @@ func (r *runner) addImageDefinedLabels(image string, labels map[string]string) e
 	return nil
 }
 
-func (r *runner) stripDuplicateMounts(mounts []mount.Mount) []mount.Mount {
-	rmounts := make([]mount.Mount, 0, len(mounts))
-
-	dests := make(map[string]struct{})
-
-	for _, mnt := range mounts {
-		if _, ok := dests[mnt.Target]; ok {
-			continue
-		}
-		dests[mnt.Target] = struct{}{}
-		rmounts = append(rmounts, mnt)
-	}
-	return rmounts
-}
-
 func panicf(fmtStr string, args ...interface{}) {
 	panic(fmt.Sprintf(fmtStr, args...))
 }
 
 // resolveMounts replaces ~ with appropriate home paths with
 // each mount.
-func (r *runner) resolveMounts(mounts []mount.Mount) {
+func (r *runner) resolveMounts(mounts []mount.Mount) error {
+	for i := range mounts {
+		// Avoid returning a typed nil.
+		if err := resolveMount(&mounts[i], r.guestHome()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveMount replaces ~ with the appropriate home path in m's
+// source and target, with home the container user's.
+func resolveMount(m *mount.Mount, home string) *mountError {
 	hostHomeDir, err := os.UserHomeDir()
 	if err != nil {
-		panic(err)
+		return &mountError{
+			mount: *m,
+			err:   xerrors.Errorf("failed to get host home dir: %w", err),
+			hint:  "ensure $HOME is set",
+		}
 	}
-	for i := range mounts {
-		mounts[i].Source, err = filepath.Abs(resolvePath(hostHomeDir, mounts[i].Source))
-		if err != nil {
-			panicf("failed to resolve %v: %v", mounts[i].Source, err)
+
+	src, err := filepath.Abs(resolvePath(hostHomeDir, m.Source))
+	if err != nil {
+		return &mountError{
+			mount: *m,
+			err:   xerrors.Errorf("failed to resolve host path: %w", err),
+			hint:  "use an absolute host path or one starting with ~/",
 		}
-		mounts[i].Target = resolvePath(guestHomeDir, mounts[i].Target)
 	}
+
+	m.Source = src
+	m.Target = resolvePath(home, m.Target)
+	return nil
+}
+
+// imageLabels returns the labels of image.
+func imageLabels(image string) (map[string]string, error) {
+	cli := dockerClient()
+	defer cli.Close()
+
+	img, _, err := cli.ImageInspectWithRaw(context.Background(), image)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to inspect image: %w", err)
+	}
+	return img.Config.Labels, nil
 }
 
 func (r *runner) projectDir(image string) (string, error) {
@@ -472,12 +1206,49 @@ func (r *runner) projectDir(image string) (string, error) {
 		return "", xerrors.Errorf("failed to inspect image: %w", err)
 	}
 
-	proot, ok := img.Config.Labels[projectRootLabel]
+	return containerProjectDir(img.Config.Labels, r.projectName), nil
+}
+
+// containerProjectDir returns the directory the project named projectName is
+// mounted at in containers of an image with labels.
+func containerProjectDir(labels map[string]string, projectName string) string {
+	home := guestHome(labels)
+	proot, ok := labels[projectRootLabel]
 	if ok {
-		return filepath.Join(proot, r.projectName), nil
+		return resolvePath(home, filepath.Join(proot, projectName))
 	}
+	return filepath.Join(home, projectName)
+}
 
-	return filepath.Join(guestHomeDir, r.projectName), nil
+// guestHome returns the home directory of the container user of an image or
+// container with labels.
+func guestHome(labels map[string]string) string {
+	if home := labels[guestHomeLabel]; home != "" {
+		return filepath.Clean(home)
+	}
+	return guestHomeDir
+}
+
+// cntGuestHome returns the home directory of the user of the container
+// cntName.
+func cntGuestHome(cntName string) (string, error) {
+	cli := dockerClient()
+	defer cli.Close()
+
+	cnt, err := inspectContainer(context.Background(), cli, cntName)
+	if err != nil {
+		return "", xerrors.Errorf("failed to inspect %v: %w", cntName, err)
+	}
+	return guestHome(cnt.Config.Labels), nil
+}
+
+// guestHome returns the home directory of the user of r's container. It's
+// only known once the container's image is, guestHomeDir is assumed until then.
+func (r *runner) guestHome() string {
+	if r.home == "" {
+		return guestHomeDir
+	}
+	return r.home
 }
 
 // runnerFromContainer gets a runner from container named
@@ -486,23 +1257,73 @@ func runnerFromContainer(name string) (*runner, error) {
 	cli := dockerClient()
 	defer cli.Close()
 
-	cnt, err := cli.ContainerInspect(context.Background(), name)
+	cnt, err := inspectContainer(context.Background(), cli, name)
 	if err != nil {
 		return nil, xerrors.Errorf("failed to inspect %v: %w", name, err)
 	}
 
-	port, err := codeServerPort(name)
+	// Containers whose code-server listens on a socket have no port to keep.
+	port := "0"
+	if cnt.Config.Labels[codeServerSocketLabel] == "" {
+		port, err = codeServerPort(name)
+		if err != nil {
+			return nil, xerrors.Errorf("failed to find code server port: %w", err)
+		}
+	}
+
+	// The image's devices are added again when the container is recreated,
+	// parseDevices drops the duplicates.
+	var devices []string
+	for _, dev := range cnt.HostConfig.Devices {
+		devices = append(devices, deviceSpec(dev))
+	}
+
+	env, err := loadEnv(name)
 	if err != nil {
-		return nil, xerrors.Errorf("failed to find code server port: %w", err)
+		return nil, xerrors.Errorf("failed to load environment: %w", err)
+	}
+
+	// The user is picked again when the container is recreated, in case
+	// the daemon changed.
+	user := cnt.Config.User
+	if cnt.Config.Labels[rootlessLabel] == "true" && user == rootlessUser {
+		user = ""
 	}
 
 	return &runner{
 		cntName:         name,
+		name:            cnt.Config.Labels[nameLabel],
+		repoURI:         cnt.Config.Labels[repoLabel],
 		hostname:        cnt.Config.Hostname,
 		port:            port,
 		projectLocalDir: cnt.Config.Labels[projectLocalDirLabel],
 		projectName:     cnt.Config.Labels[projectNameLabel],
 		proxyURL:        cnt.Config.Labels[proxyURLLabel],
+		user:            user,
+		groupAdd:        cnt.HostConfig.GroupAdd,
+		usernsMode:      string(cnt.HostConfig.UsernsMode),
+		shareDocker:     cnt.Config.Labels[dockerSocketLabel] == "true",
+		forwardProxy:    cnt.Config.Labels[forwardProxyLabel] == "true",
+		devices:         devices,
+		extraMounts:     splitMounts(cnt.Config.Labels[mountsLabel]),
+		env:             env,
+		groups:          containerGroups(cnt),
+		upstream:        cnt.Config.Labels[upstreamLabel],
+		// Rebuilds of bootstrapped containers are bootstrapped too.
+		bootstrap:      cnt.Config.Labels[bootstrapLabel] != "",
+		sharedVolumes:  containerSharedVolumes(cnt.Mounts),
+		memory:         cnt.HostConfig.Memory,
+		sandbox:        cnt.Config.Labels[sandboxLabel] == "true",
+		egress:         labelEgress(cnt.Config.Labels),
+		gui:            cnt.Config.Labels[guiLabel],
+		gitCredentials: cnt.Config.Labels[gitCredentialsLabel] == "true",
+		commitSigning:  cnt.Config.Labels[commitSigningLabel] == "true",
+		review:         cnt.Config.Labels[reviewLabel],
+		ephemeral:      cnt.Config.Labels[ephemeralLabel] == "true",
+		readOnly:       cnt.Config.Labels[readOnlyLabel] == "true",
+		overlay:        cnt.Config.Labels[overlayLabel] == "true",
+		audio:          cnt.Config.Labels[audioLabel] == "true",
+		webcam:         cnt.Config.Labels[webcamLabel] == "true",
 	}, nil
 }
 
@@ -511,13 +1332,6 @@ func (r *runner) runOnStart(image string) error {
 	cli := dockerClient()
 	defer cli.Close()
 
-	// Get project directory.
-	projectDir, err := r.projectDir(image)
-	if err != nil {
-		return err
-	}
-	projectDir = resolvePath(containerHome, projectDir)
-
 	// Get on_start label from image.
 	img, _, err := cli.ImageInspectWithRaw(context.Background(), image)
 	if err != nil {
@@ -529,19 +1343,50 @@ func (r *runner) runOnStart(image string) error {
 		return nil
 	}
 
+	// The container knows its project directory and platform.
+	env, err := hookEnvFromContainer(context.Background(), r.cntName)
+	if err != nil {
+		return err
+	}
+
 	// Execute the command detached in the container.
-	cmd := dockutil.DetachedExecDir(r.cntName, projectDir, "/bin/bash", "-c", onStartCmd)
+	shell := shellCommand(env.windows, onStartCmd)
+	cmd := dockutil.DetachedExecDir(r.cntName, env.guestPath(env.cntDir), shell[0], shell[1:]...)
 	return cmd.Run()
 }
 
+// forkProxy starts the container's sail proxy. The proxy keeps the port it
+// was assigned before, so the environment's URL stays the same when it's
+// recreated.
 func (r *runner) forkProxy() error {
-	var err error
-	r.proxyURL, err = forkProxy(r.cntName)
-	return err
+	port, err := assignPort(r.projectCntName(), proxyPortName)
+	if err != nil {
+		return err
+	}
+	r.proxyURL, err = forkProxy(r.cntName, r.idleTimeout, port)
+	if err != nil {
+		return err
+	}
+
+	// The proxy falls back to another port if its own was taken since.
+	u, err := url.Parse(r.proxyURL)
+	if err != nil {
+		return err
+	}
+	return savePort(r.projectCntName(), proxyPortName, u.Port())
 }
 
-func forkProxy(cntName string) (proxyURL string, _ error) {
-	sailProxy := exec.Command(os.Args[0], "proxy", cntName)
+// projectCntName returns the name of the project's container, which r.cntName
+// only is once a rebuilt container was swapped in.
+func (r *runner) projectCntName() string {
+	if r.name == "" {
+		return r.cntName
+	}
+	return toDockerName(r.name)
+}
+
+func forkProxy(cntName string, idleTimeout time.Duration, port string) (proxyURL string, _ error) {
+	sailProxy := exec.Command(os.Args[0], "proxy", "--idle-timeout", idleTimeout.String(), "--port", port, cntName)
 	stdout, err := sailProxy.StdoutPipe()
 	if err != nil {
 		return "", xerrors.Errorf("failed to create stdout pipe: %v", err)
@@ -554,14 +1399,11 @@ func forkProxy(cntName string) (proxyURL string, _ error) {
 	}
 	defer f.Close()
 
-	flog.Info("writing sail proxy logs to %v", f.Name())
+	xlog.Info("writing sail proxy logs to %v", f.Name())
 
 	sailProxy.Stderr = f
 
-	sailProxy.SysProcAttr = &syscall.SysProcAttr{
-		// See https://grokbase.com/t/gg/golang-nuts/147jmc4h0k/go-nuts-starting-detached-child-process#201407185ia7a7ldk3veno3linjktq4dve
-		Setpgid: true,
-	}
+	detach(sailProxy)
 	err = sailProxy.Start()
 
 	_, err = fmt.Fscan(stdout, &proxyURL)
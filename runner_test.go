@@ -1,9 +1,12 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"testing"
 
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-connections/nat"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
@@ -48,7 +51,7 @@ func Test_runner(t *testing.T) {
 	// starts up inside the container.
 	codeServerStarts := func(t *testing.T, p *params) {
 		t.Run("CodeServerStarts", func(t *testing.T) {
-			err := p.proj.waitOnline()
+			err := p.proj.waitOnline(context.Background())
 			require.NoError(t, err)
 		})
 	}
@@ -83,7 +86,7 @@ func Test_runner(t *testing.T) {
 			t.Run(name, func(t *testing.T) {
 				cntDir, err := p.proj.containerDir()
 				require.NoError(t, err)
-				cntDir = resolvePath(containerHome, cntDir)
+				cntDir = resolvePath(guestHomeDir, cntDir)
 
 				// Run the file existence check using /bin/sh.
 				cmdStr := fmt.Sprintf(`[ -f "%s" ]`, path)
@@ -126,3 +129,88 @@ func Test_runner(t *testing.T) {
 		containsFile("ContainsOnStartFile", "did_on_start"),
 	)
 }
+
+func Test_runnerCommand(t *testing.T) {
+	r := &runner{port: "8443"}
+	cmd := r.command("~/my project")
+	assert.Equal(t, []string{"bash", "-c", launcherScript, launcherName}, cmd[:4])
+	assert.Equal(t, "/home/user/my project", cmd[4])
+	assert.Contains(t, cmd, "/home/user/.vscode/host-extensions")
+	assert.Contains(t, cmd, codeServerRestartPath)
+	assert.Equal(t, "", cmd[len(cmd)-1])
+
+	r.socket = "/home/me/.config/sail/cdr_sail/run/code-server.sock"
+	cmd = r.command("~/sail")
+	assert.Equal(t, containerSocketDir+"/"+codeServerSocketName, cmd[len(cmd)-1])
+
+	r.wrapper = []string{"/usr/local/bin/launch", "--"}
+	cmd = r.command("~/sail")
+	assert.Equal(t, []string{"/usr/local/bin/launch", "--", "bash", "-c", launcherScript, launcherName}, cmd[:6])
+
+	r.testCmd = "echo hi"
+	assert.Equal(t, []string{"bash", "-c", "echo hi\n exit 1"}, r.command("~/sail"))
+}
+
+func Test_guestHome(t *testing.T) {
+	assert.Equal(t, guestHomeDir, guestHome(nil))
+	assert.Equal(t, "/home/coder", guestHome(map[string]string{guestHomeLabel: "/home/coder/"}))
+
+	r := &runner{}
+	assert.Equal(t, guestHomeDir, r.guestHome())
+	r.home = "/home/coder"
+	cmd := r.command("~/sail")
+	assert.Equal(t, "/home/coder/sail", cmd[4])
+	assert.Contains(t, cmd, "/home/coder/.vscode/host-extensions")
+}
+
+func Test_containerProjectDir(t *testing.T) {
+	assert.Equal(t, "/home/user/sail", containerProjectDir(nil, "sail"))
+	assert.Equal(t, "/home/user/go/src/sail", containerProjectDir(map[string]string{projectRootLabel: "~/go/src"}, "sail"))
+	assert.Equal(t, "/src/sail", containerProjectDir(map[string]string{projectRootLabel: "/src"}, "sail"))
+}
+
+func Test_parseCmdLabel(t *testing.T) {
+	cmd, err := parseCmdLabel(`["/usr/local/bin/launch", "--log file"]`)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/usr/local/bin/launch", "--log file"}, cmd)
+
+	cmd, err = parseCmdLabel("tini -s --")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"tini", "-s", "--"}, cmd)
+
+	cmd, err = parseCmdLabel("")
+	require.NoError(t, err)
+	assert.Empty(t, cmd)
+
+	_, err = parseCmdLabel(`["tini", 1]`)
+	require.Error(t, err)
+}
+
+func Test_runnerProjectCntName(t *testing.T) {
+	r := &runner{cntName: "cdr_sail-builder-abcde", name: "cdr/sail"}
+	assert.Equal(t, "cdr_sail", r.projectCntName())
+
+	r = &runner{cntName: "cdr_sail"}
+	assert.Equal(t, "cdr_sail", r.projectCntName())
+}
+
+func Test_runnerHostConfigPorts(t *testing.T) {
+	r := &runner{desktop: true, port: "40000", guestPort: "40001", forwardPorts: []string{"3000"}}
+	cnt := &container.Config{Labels: map[string]string{}}
+	hostConfig, err := r.hostConfig(cnt, nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, container.NetworkMode(""), hostConfig.NetworkMode)
+	for port, hostPort := range map[string]string{"8443": "40000", guestCodeServerPort: "40001", "3000": "3000"} {
+		bindings := hostConfig.PortBindings[nat.Port(port+"/tcp")]
+		if assert.Len(t, bindings, 1, port) {
+			assert.Equal(t, hostPort, bindings[0].HostPort)
+		}
+	}
+
+	// code-server's socket needs no port.
+	r.socket = "/home/me/.config/sail/cdr_sail/run/code-server.sock"
+	hostConfig, err = r.hostConfig(cnt, nil)
+	require.NoError(t, err)
+	assert.Len(t, hostConfig.PortBindings, 1)
+}
@@ -87,4 +87,54 @@
             command: "sail.rebuild"
         }, 0)
     })
+
+    // Large pastes and dropped files go into the container through sail's
+    // bridge rather than code-server's connection, which drops them.
+    const bridgeThreshold = 1 << 20
+
+    function notify(msg) {
+        const div = document.createElement("div")
+        div.className = "sail-bridge-notification"
+        div.style.cssText = "position: fixed; bottom: 30px; right: 10px; z-index: 10000; padding: 8px 12px; background: #333; color: #fff; border-radius: 3px"
+        div.textContent = msg
+        document.body.appendChild(div)
+        setTimeout(() => div.remove(), 5000)
+    }
+
+    function bridge(path, body) {
+        return fetch(path, {
+            method: "POST",
+            headers: {"X-Sail-Bridge": "1"},
+            body: body,
+        }).then(resp => {
+            if (!resp.ok) {
+                return resp.text().then(text => {
+                    throw new Error(text)
+                })
+            }
+            return resp.json()
+        }).then(resp => notify("sail: saved " + resp.path), err => notify("sail: upload failed: " + err.message))
+    }
+
+    document.addEventListener("paste", ev => {
+        const text = ev.clipboardData && ev.clipboardData.getData("text/plain")
+        if (!text || text.length < bridgeThreshold) {
+            return
+        }
+        ev.preventDefault()
+        ev.stopPropagation()
+        bridge("/sail/api/v1/clipboard", text)
+    }, true)
+
+    document.addEventListener("drop", ev => {
+        const files = ev.dataTransfer && ev.dataTransfer.files
+        if (!files || files.length === 0) {
+            return
+        }
+        ev.preventDefault()
+        ev.stopPropagation()
+        for (const f of files) {
+            bridge("/sail/api/v1/upload?name=" + encodeURIComponent(f.name), f)
+        }
+    }, true)
 }())
@@ -1,4 +1,4 @@
 package main
 
 //go:generate go run sail.js_gen.go
-const sailJS = "(function() {\n    let oldonkeydown\n    function startReloadUI() {\n        const div = document.createElement(\"div\")\n        div.className = \"msgbox-overlay\"\n        div.style.opacity = 1\n        div.style.textAlign = \"center\"\n        div.innerHTML = `<div class=\"msgbox\">\n    <div class=\"msg\">Rebuilding container</div>\n    </div>`\n        // Prevent keypresses.\n        oldonkeydown = document.body.onkeydown\n        document.body.onkeydown = ev => {\n            ev.stopPropagation()\n        }\n        document.querySelector(\".monaco-workbench\").appendChild(div)\n    }\n\n    function removeElementsByClass(className) {\n        let elements = document.getElementsByClassName(className);\n        for (let e of elements) {\n            e.parentNode.removeChild(e)\n        }\n    }\n\n    function stopReloadUI() {\n        document.body.onkeydown = oldonkeydown\n        removeElementsByClass(\"msgbox-overlay\")\n    }\n\n    let tty\n    let rebuilding\n    function rebuild() {\n        if (rebuilding) {\n            return\n        }\n        rebuilding = true\n\n        const tsrv = window.ide.workbench.terminalService\n\n        if (tty == null) {\n            tty = tsrv.createTerminal({\n                name: \"sail\",\n                isRendererOnly: true,\n            }, false)\n        } else {\n            tty.clear()\n        }\n        let oldTTY = tsrv.getActiveInstance()\n        tsrv.setActiveInstance(tty)\n        tsrv.showPanel(true)\n\n        startReloadUI()\n\n        const ws = new WebSocket(\"ws://\" + location.host + \"/sail/api/v1/reload\")\n        ws.onmessage = (ev) => {\n            const msg = JSON.parse(ev.data)\n            const out = atob(msg.v).replace(/\\n/g, \"\\n\\r\")\n            tty.write(out)\n        }\n        ws.onclose = (ev) => {\n            if (ev.code === 1000) {\n                tsrv.setActiveInstance(oldTTY)\n            } else {\n                alert(\"reload failed; please see logs in sail terminal\")\n            }\n            stopReloadUI()\n            rebuilding = false\n        }\n    }\n\n    window.addEventListener(\"ide-ready\", () => {\n        class rebuildAction extends window.ide.workbench.action {\n            run() {\n                rebuild()\n            }\n        }\n\n        window.ide.workbench.actionsRegistry.registerWorkbenchAction(new window.ide.workbench.syncActionDescriptor(rebuildAction, \"sail.rebuild\", \"Rebuild container\", {\n            primary: ((1 << 11) >>> 0) | 48 // That's cmd + R. See vscode source for the magic numbers.\n        }), \"sail: Rebuild container\", \"sail\");\n\n        const statusBarService = window.ide.workbench.statusbarService\n        statusBarService.addEntry({\n            text: \"rebuild\",\n            tooltip: \"Rebuild sail container\",\n            command: \"sail.rebuild\"\n        }, 0)\n    })\n}())\n"
+const sailJS = "(function() {\n    let oldonkeydown\n    function startReloadUI() {\n        const div = document.createElement(\"div\")\n        div.className = \"msgbox-overlay\"\n        div.style.opacity = 1\n        div.style.textAlign = \"center\"\n        div.innerHTML = `<div class=\"msgbox\">\n    <div class=\"msg\">Rebuilding container</div>\n    </div>`\n        // Prevent keypresses.\n        oldonkeydown = document.body.onkeydown\n        document.body.onkeydown = ev => {\n            ev.stopPropagation()\n        }\n        document.querySelector(\".monaco-workbench\").appendChild(div)\n    }\n\n    function removeElementsByClass(className) {\n        let elements = document.getElementsByClassName(className);\n        for (let e of elements) {\n            e.parentNode.removeChild(e)\n        }\n    }\n\n    function stopReloadUI() {\n        document.body.onkeydown = oldonkeydown\n        removeElementsByClass(\"msgbox-overlay\")\n    }\n\n    let tty\n    let rebuilding\n    function rebuild() {\n        if (rebuilding) {\n            return\n        }\n        rebuilding = true\n\n        const tsrv = window.ide.workbench.terminalService\n\n        if (tty == null) {\n            tty = tsrv.createTerminal({\n                name: \"sail\",\n                isRendererOnly: true,\n            }, false)\n        } else {\n            tty.clear()\n        }\n        let oldTTY = tsrv.getActiveInstance()\n        tsrv.setActiveInstance(tty)\n        tsrv.showPanel(true)\n\n        startReloadUI()\n\n        const ws = new WebSocket(\"ws://\" + location.host + \"/sail/api/v1/reload\")\n        ws.onmessage = (ev) => {\n            const msg = JSON.parse(ev.data)\n            const out = atob(msg.v).replace(/\\n/g, \"\\n\\r\")\n            tty.write(out)\n        }\n        ws.onclose = (ev) => {\n            if (ev.code === 1000) {\n                tsrv.setActiveInstance(oldTTY)\n            } else {\n                alert(\"reload failed; please see logs in sail terminal\")\n            }\n            stopReloadUI()\n            rebuilding = false\n        }\n    }\n\n    window.addEventListener(\"ide-ready\", () => {\n        class rebuildAction extends window.ide.workbench.action {\n            run() {\n                rebuild()\n            }\n        }\n\n        window.ide.workbench.actionsRegistry.registerWorkbenchAction(new window.ide.workbench.syncActionDescriptor(rebuildAction, \"sail.rebuild\", \"Rebuild container\", {\n            primary: ((1 << 11) >>> 0) | 48 // That's cmd + R. See vscode source for the magic numbers.\n        }), \"sail: Rebuild container\", \"sail\");\n\n        const statusBarService = window.ide.workbench.statusbarService\n        statusBarService.addEntry({\n            text: \"rebuild\",\n            tooltip: \"Rebuild sail container\",\n            command: \"sail.rebuild\"\n        }, 0)\n    })\n\n    // Large pastes and dropped files go into the container through sail's\n    // bridge rather than code-server's connection, which drops them.\n    const bridgeThreshold = 1 << 20\n\n    function notify(msg) {\n        const div = document.createElement(\"div\")\n        div.className = \"sail-bridge-notification\"\n        div.style.cssText = \"position: fixed; bottom: 30px; right: 10px; z-index: 10000; padding: 8px 12px; background: #333; color: #fff; border-radius: 3px\"\n        div.textContent = msg\n        document.body.appendChild(div)\n        setTimeout(() => div.remove(), 5000)\n    }\n\n    function bridge(path, body) {\n        return fetch(path, {\n            method: \"POST\",\n            headers: {\"X-Sail-Bridge\": \"1\"},\n            body: body,\n        }).then(resp => {\n            if (!resp.ok) {\n                return resp.text().then(text => {\n                    throw new Error(text)\n                })\n            }\n            return resp.json()\n        }).then(resp => notify(\"sail: saved \" + resp.path), err => notify(\"sail: upload failed: \" + err.message))\n    }\n\n    document.addEventListener(\"paste\", ev => {\n        const text = ev.clipboardData && ev.clipboardData.getData(\"text/plain\")\n        if (!text || text.length < bridgeThreshold) {\n            return\n        }\n        ev.preventDefault()\n        ev.stopPropagation()\n        bridge(\"/sail/api/v1/clipboard\", text)\n    }, true)\n\n    document.addEventListener(\"drop\", ev => {\n        const files = ev.dataTransfer && ev.dataTransfer.files\n        if (!files || files.length === 0) {\n            return\n        }\n        ev.preventDefault()\n        ev.stopPropagation()\n        for (const f of files) {\n            bridge(\"/sail/api/v1/upload?name=\" + encodeURIComponent(f.name), f)\n        }\n    }, true)\n}())\n"
@@ -102,7 +102,7 @@ func run(t *testing.T, name, repo, hatPath string, fns ...func(t *testing.T, p *
 			port:            p.port,
 		}
 
-		err = p.runner.runContainer(image)
+		err = p.runner.runContainer(context.Background(), image)
 		require.NoError(t, err)
 		p.rb.add(func() {
 			requireContainerRemove(t, p.proj.cntName())
@@ -130,7 +130,7 @@ func requireProjectsNotRunning(t *testing.T, projects ...string) {
 }
 
 func requireGetImageLabels(t *testing.T, image string) map[string]string {
-	return requireImageInspect(t, image).ContainerConfig.Labels
+	return requireImageInspect(t, image).Config.Labels
 }
 
 func requireImageInspect(t *testing.T, image string) types.ImageInspect {
@@ -186,7 +186,8 @@ func requireContainerRemove(t *testing.T, cntName string) {
 }
 
 func requireUbuntuDevImage(t *testing.T) {
-	require.NoError(t, ensureImage("codercom/ubuntu-dev"))
+	_, err := ensureImage(context.Background(), "codercom/ubuntu-dev", "")
+	require.NoError(t, err)
 }
 
 type rollback struct {
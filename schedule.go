@@ -0,0 +1,157 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// defaultPrebuildSchedule rebuilds the prebuilds at 3am every day.
+const defaultPrebuildSchedule = "0 3 * * *"
+
+// scheduleAliases are the cron shorthands parseSchedule accepts.
+var scheduleAliases = map[string]string{
+	"@hourly":   "0 * * * *",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@weekly":   "0 0 * * 0",
+	"@monthly":  "0 0 1 * *",
+}
+
+// schedule is a cron schedule. Each field is a bitset of the values it
+// matches.
+type schedule struct {
+	minute, hour, dom, month, dow uint64
+	// Like cron, if both the day of month and the day of week are
+	// restricted, days matching either of them match.
+	domStar, dowStar bool
+}
+
+// parseSchedule parses the cron expression s of minute, hour, day of month,
+// month and day of week. Fields are *, values, ranges such as 1-5, steps
+// such as */15 or 0-30/10, or comma separated lists of them.
+func parseSchedule(s string) (*schedule, error) {
+	expr := s
+	if alias, ok := scheduleAliases[strings.TrimSpace(s)]; ok {
+		expr = alias
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, xerrors.Errorf("invalid schedule %q, must be of form <minute> <hour> <day of month> <month> <day of week>", s)
+	}
+
+	var (
+		sch schedule
+		err error
+	)
+	bounds := []struct {
+		name     string
+		field    *uint64
+		min, max int
+	}{
+		{"minute", &sch.minute, 0, 59},
+		{"hour", &sch.hour, 0, 23},
+		{"day of month", &sch.dom, 1, 31},
+		{"month", &sch.month, 1, 12},
+		// 7 is Sunday too.
+		{"day of week", &sch.dow, 0, 7},
+	}
+	for i, b := range bounds {
+		*b.field, err = parseScheduleField(fields[i], b.min, b.max)
+		if err != nil {
+			return nil, xerrors.Errorf("invalid %v in schedule %q: %w", b.name, s, err)
+		}
+	}
+	if sch.dow&(1<<7) != 0 {
+		sch.dow |= 1
+	}
+	sch.domStar = fields[2] == "*"
+	sch.dowStar = fields[4] == "*"
+	return &sch, nil
+}
+
+// parseScheduleField parses a field of a cron expression whose values are
+// between min and max.
+func parseScheduleField(field string, min, max int) (uint64, error) {
+	var set uint64
+	for _, item := range strings.Split(field, ",") {
+		rng, step := item, 1
+		if i := strings.Index(item, "/"); i >= 0 {
+			var err error
+			step, err = strconv.Atoi(item[i+1:])
+			if err != nil || step < 1 {
+				return 0, xerrors.Errorf("invalid step in %q", item)
+			}
+			rng = item[:i]
+		}
+
+		lo, hi := min, max
+		switch {
+		case rng == "*":
+		case strings.Contains(rng, "-"):
+			parts := strings.SplitN(rng, "-", 2)
+			var err error
+			lo, err = strconv.Atoi(parts[0])
+			if err != nil {
+				return 0, xerrors.Errorf("invalid range %q", item)
+			}
+			hi, err = strconv.Atoi(parts[1])
+			if err != nil {
+				return 0, xerrors.Errorf("invalid range %q", item)
+			}
+		default:
+			v, err := strconv.Atoi(rng)
+			if err != nil {
+				return 0, xerrors.Errorf("invalid value %q", item)
+			}
+			lo = v
+			// A value with a step starts a range, as in cron.
+			if step == 1 {
+				hi = v
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, xerrors.Errorf("%q is out of range %v-%v", item, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			set |= 1 << uint(v)
+		}
+	}
+	return set, nil
+}
+
+// matchDay reports whether the schedule runs on the day of t.
+func (s *schedule) matchDay(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// next returns the first time after t the schedule runs at, or the zero time
+// if it never runs, e.g. on February 30th.
+func (s *schedule) next(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	// Every day of the week and month comes around within a few years.
+	end := t.AddDate(5, 0, 0)
+	for t.Before(end) {
+		switch {
+		case s.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		case !s.matchDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+		case s.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseSchedule(t *testing.T) {
+	for _, s := range []string{
+		defaultPrebuildSchedule,
+		"*/15 * * * *",
+		"0 9-17/2 * * 1-5",
+		"0 0 1,15 * *",
+		"0 0 * * 7",
+		"@daily",
+	} {
+		_, err := parseSchedule(s)
+		assert.NoError(t, err, s)
+	}
+
+	for _, s := range []string{
+		"",
+		"0 3 * *",
+		"60 * * * *",
+		"0 24 * * *",
+		"0 0 0 * *",
+		"0 0 * 13 *",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"mon * * * *",
+		"@yearly",
+	} {
+		_, err := parseSchedule(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func Test_scheduleNext(t *testing.T) {
+	// Thursday.
+	now := time.Date(2019, time.August, 1, 12, 30, 15, 0, time.UTC)
+
+	next := func(s string) time.Time {
+		sch, err := parseSchedule(s)
+		require.NoError(t, err)
+		return sch.next(now)
+	}
+
+	assert.Equal(t, time.Date(2019, time.August, 2, 3, 0, 0, 0, time.UTC), next(defaultPrebuildSchedule))
+	assert.Equal(t, time.Date(2019, time.August, 1, 12, 45, 0, 0, time.UTC), next("*/15 * * * *"))
+	assert.Equal(t, time.Date(2019, time.August, 1, 13, 0, 0, 0, time.UTC), next("@hourly"))
+	// Sunday, as 7.
+	assert.Equal(t, time.Date(2019, time.August, 4, 0, 0, 0, 0, time.UTC), next("0 0 * * 7"))
+	assert.Equal(t, time.Date(2019, time.September, 1, 0, 0, 0, 0, time.UTC), next("@monthly"))
+	// Monday the 5th matches the day of week before the 15th matches the day of month.
+	assert.Equal(t, time.Date(2019, time.August, 5, 0, 0, 0, 0, time.UTC), next("0 0 15 * 1"))
+	assert.Equal(t, time.Date(2020, time.February, 29, 0, 0, 0, 0, time.UTC), next("0 0 29 2 *"))
+	assert.True(t, next("0 0 30 2 *").IsZero())
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/BurntSushi/toml"
+	"golang.org/x/xerrors"
+
+	"go.coder.com/sail/internal/xlog"
+)
+
+// configKeys returns the keys of the config, from the toml tags of config.
+func configKeys() []string {
+	t := reflect.TypeOf(config{})
+	keys := make([]string, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		keys = append(keys, t.Field(i).Tag.Get("toml"))
+	}
+	return keys
+}
+
+// warnedConfigs holds the configs whose unknown keys were already reported,
+// as the config is read several times by some commands.
+var warnedConfigs sync.Map
+
+// checkConfig warns about keys of the config at path that sail doesn't know,
+// and validates the values of the ones it does.
+func checkConfig(path string, c config, md toml.MetaData) error {
+	if _, warned := warnedConfigs.LoadOrStore(path, true); !warned {
+		for _, k := range md.Undecoded() {
+			xlog.Warn("%v: unknown key %q is ignored%v", path, k.String(), didYouMean(k.String(), configKeys()))
+		}
+	}
+
+	check := func(key, value string, valid ...string) error {
+		if value == "" || stringsContain(valid, value) {
+			return nil
+		}
+		return xerrors.Errorf("%v: invalid %v %q, must be one of %v%v", path, key, value, strings.Join(valid, ", "), didYouMean(value, valid))
+	}
+	err := check("default_schema", c.DefaultSchema, "ssh", "https", "http")
+	if err != nil {
+		return err
+	}
+	backends := []string{backendDocker, backendLXD, backendIncus, backendIgnite}
+	err = check("backend", c.Backend, backends...)
+	if err != nil {
+		return err
+	}
+	for _, project := range sortedKeys(c.Backends) {
+		err = check(fmt.Sprintf("backend of %v", project), c.Backends[project], backends...)
+		if err != nil {
+			return err
+		}
+	}
+	if c.CodeServerURL != "" {
+		u, err := url.Parse(c.CodeServerURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return xerrors.Errorf("%v: invalid code_server_url %q, must be an http or https URL", path, c.CodeServerURL)
+		}
+	}
+	err = checkGroups(c.Groups)
+	if err != nil {
+		return xerrors.Errorf("%v: %w", path, err)
+	}
+	err = checkBuildArgs(c)
+	if err != nil {
+		return xerrors.Errorf("%v: %w", path, err)
+	}
+	if c.PrebuildSchedule != "" {
+		_, err = parseSchedule(c.PrebuildSchedule)
+		if err != nil {
+			return xerrors.Errorf("%v: prebuild_schedule: %w", path, err)
+		}
+	}
+	if c.SyncURL != "" {
+		err = validateSyncURL(c.SyncURL)
+		if err != nil {
+			return xerrors.Errorf("%v: %w", path, err)
+		}
+	}
+	if c.Platform != "" {
+		err = validatePlatform(c.Platform)
+		if err != nil {
+			return xerrors.Errorf("%v: %w", path, err)
+		}
+	}
+	err = checkTrusted(c.Trusted)
+	if err != nil {
+		return xerrors.Errorf("%v: %w", path, err)
+	}
+	err = check("gui", c.GUI, guiAuto, guiX11, guiWayland, guiVNC, guiOff)
+	if err != nil {
+		return err
+	}
+	err = checkMedia(c.Media)
+	if err != nil {
+		return xerrors.Errorf("%v: %w", path, err)
+	}
+	err = checkGitAuth(c.GitAuth)
+	if err != nil {
+		return xerrors.Errorf("%v: %w", path, err)
+	}
+	err = checkEgress(c.Egress)
+	if err != nil {
+		return xerrors.Errorf("%v: %w", path, err)
+	}
+	err = checkSharedVolumes(c.SharedVolumes)
+	if err != nil {
+		return xerrors.Errorf("%v: %w", path, err)
+	}
+	return check("update_channel", c.UpdateChannel, channelStable, channelEdge)
+}
+
+// labelSchema validates the values of the image labels sail reads, by key.
+// A nil validator accepts any value.
+var labelSchema = map[string]func(string) error{
+	egressLabel: func(v string) error {
+		return egressPolicy{Mode: v}.check()
+	},
+	egressAllowLabel: func(v string) error {
+		for _, a := range strings.Split(v, ",") {
+			if a = strings.TrimSpace(a); a == "" {
+				continue
+			}
+			_, err := parseEgressRule(a)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	devicesLabel: func(v string) error {
+		for _, spec := range strings.Split(v, ",") {
+			if spec = strings.TrimSpace(spec); spec == "" {
+				continue
+			}
+			_, err := parseDevice(spec)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	forwardPortsLabel: func(v string) error {
+		for _, p := range strings.Split(v, ",") {
+			p = strings.TrimSpace(p)
+			port, err := strconv.Atoi(p)
+			if err != nil || port < 1 || port > 65535 {
+				return xerrors.Errorf("invalid port %q, must be a comma separated list of ports", p)
+			}
+		}
+		return nil
+	},
+	onStartLabel: nil,
+	fileWatcherLabel: func(v string) error {
+		if v != watchAuto && v != watchInotify && v != watchPoll {
+			return xerrors.Errorf("invalid value %q, must be auto, inotify or poll", v)
+		}
+		return nil
+	},
+	projectRootLabel: func(v string) error {
+		if !strings.HasPrefix(v, "/") && v != "~" && !strings.HasPrefix(v, "~/") {
+			return xerrors.Errorf("invalid path %q, must be absolute or start with ~/", v)
+		}
+		return nil
+	},
+	sharedVolumesLabel: func(v string) error {
+		_, err := parseSharedVolumesLabel(v)
+		return err
+	},
+	audioLabel:       checkBoolLabel,
+	webcamLabel:      checkBoolLabel,
+	shareDockerLabel: checkBoolLabel,
+	cmdLabel: func(v string) error {
+		_, err := parseCmdLabel(v)
+		return err
+	},
+	guestHomeLabel: func(v string) error {
+		if !strings.HasPrefix(v, "/") {
+			return xerrors.Errorf("invalid path %q, must be absolute", v)
+		}
+		return nil
+	},
+	timezoneLabel: checkLocaleLabel,
+	localeLabel:   checkLocaleLabel,
+	uploadsDirLabel: func(v string) error {
+		if !strings.HasPrefix(v, "/") && v != "~" && !strings.HasPrefix(v, "~/") {
+			return xerrors.Errorf("invalid path %q, must be absolute or start with ~/", v)
+		}
+		return nil
+	},
+	guestUserLabel: func(v string) error {
+		if v == "" || strings.ContainsAny(v, " \t") {
+			return xerrors.Errorf("invalid user %q, must be a user name or uid[:gid]", v)
+		}
+		return nil
+	},
+
+	apparmorLabel:     nil,
+	baseImageLabel:    nil,
+	capAddLabel:       nil,
+	hatLabel:          nil,
+	nixStoreLabel:     nil,
+	onCreateCmdLabel:  nil,
+	postStartCmdLabel: nil,
+	warmupCmdLabel:    nil,
+	seccompLabel:      nil,
+}
+
+// checkBoolLabel validates the labels that are true or false.
+func checkBoolLabel(v string) error {
+	if v != "true" && v != "false" {
+		return xerrors.Errorf("invalid value %q, must be true or false", v)
+	}
+	return nil
+}
+
+// checkLocaleLabel validates the labels naming a time zone or locale.
+func checkLocaleLabel(v string) error {
+	if v == "" || strings.ContainsAny(v, " \t") {
+		return xerrors.Errorf("invalid value %q, must be a name like UTC or C.UTF-8, or none", v)
+	}
+	return nil
+}
+
+// runnerOnlyLabels are the labels the runner only sets on containers when
+// they apply, along with the value that unsets them. Every label the runner
+// sets conditionally must be listed, as sail clone unsets them in the images
+// it commits, see commitContainer.
+var runnerOnlyLabels = map[string]string{
+	// Images may set these too, so they're unset with a valid value.
+	audioLabel:  "false",
+	webcamLabel: "false",
+
+	codeServerSocketLabel: "",
+	commitSigningLabel:    "",
+	createdSourcesLabel:   "",
+	dockerSocketLabel:     "",
+	envKeysLabel:          "",
+	ephemeralLabel:        "",
+	forwardProxyLabel:     "",
+	gitCredentialsLabel:   "",
+	guiLabel:              "",
+	mountsLabel:           "",
+	overlayLabel:          "",
+	ownerLabel:            "",
+	readOnlyLabel:         "",
+	reviewLabel:           "",
+	rootlessLabel:         "",
+	sandboxLabel:          "",
+}
+
+// knownLabels returns the keys of labelSchema and the labels sail sets on
+// containers.
+func knownLabels() []string {
+	keys := append([]string(nil), stateLabels...)
+	keys = append(keys, privilegedLabel, upstreamLabel)
+	for k := range runnerOnlyLabels {
+		keys = append(keys, k)
+	}
+	for k := range labelSchema {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// checkLabels validates the image labels sail reads. It returns warnings for
+// labels that look like misspelled sail labels, as images carry labels
+// of other tools too.
+func checkLabels(labels map[string]string) (warnings []string, _ error) {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	known := knownLabels()
+	for _, k := range keys {
+		v := labels[k]
+		if strings.HasPrefix(k, shareLabelPrefix) {
+			_, err := parseShareLabel(k, v)
+			if err != nil {
+				return warnings, err
+			}
+			continue
+		}
+
+		validate, ok := labelSchema[k]
+		if ok {
+			if validate == nil {
+				continue
+			}
+			err := validate(v)
+			if err != nil {
+				return warnings, xerrors.Errorf("label %v: %w", k, err)
+			}
+			continue
+		}
+		if stringsContain(known, k) {
+			continue
+		}
+
+		// Unknown labels under sail's prefix are always a mistake, others
+		// are only reported if they're close to a sail label.
+		suggestion := didYouMean(k, known)
+		if suggestion == "" && strings.HasPrefix(k, "share_") {
+			suggestion = fmt.Sprintf(", did you mean %q?", shareLabelPrefix+strings.TrimPrefix(k, "share_"))
+		}
+		if strings.HasPrefix(k, sailLabel+".") || suggestion != "" {
+			warnings = append(warnings, fmt.Sprintf("unknown label %v is ignored%v", k, suggestion))
+		}
+	}
+	return warnings, nil
+}
+
+// didYouMean suggests the closest of candidates to s, if it's close enough
+// to be a typo. It returns an empty string otherwise.
+func didYouMean(s string, candidates []string) string {
+	maxDist := len(s)/4 + 1
+	if maxDist > 2 {
+		maxDist = 2
+	}
+
+	var best string
+	for _, c := range candidates {
+		d := editDistance(s, c)
+		if d < maxDist || (best == "" && d == maxDist) {
+			best, maxDist = c, d
+		}
+	}
+	if best == "" || best == s {
+		return ""
+	}
+	return fmt.Sprintf(", did you mean %q?", best)
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
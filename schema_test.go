@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.coder.com/sail/internal/xlog"
+)
+
+func Test_didYouMean(t *testing.T) {
+	keys := configKeys()
+	assert.Equal(t, `, did you mean "default_hat"?`, didYouMean("default_hatt", keys))
+	assert.Equal(t, `, did you mean "idle_timeout"?`, didYouMean("idle_timout", keys))
+	assert.Equal(t, "", didYouMean("default_hat", keys))
+	assert.Equal(t, "", didYouMean("editor", keys))
+
+	assert.Equal(t, 0, editDistance("on_start", "on_start"))
+	assert.Equal(t, 2, editDistance("on_strat", "on_start"))
+	assert.Equal(t, 3, editDistance("", "hat"))
+}
+
+func Test_checkConfig(t *testing.T) {
+	var buf bytes.Buffer
+	xlog.SetOutput(&buf)
+	defer xlog.SetOutput(nil)
+
+	check := func(path, conf string) error {
+		var c config
+		md, err := toml.Decode(conf, &c)
+		require.NoError(t, err)
+		return checkConfig(path, c, md)
+	}
+
+	require.NoError(t, check("default.toml", DefaultConfig))
+	assert.Empty(t, buf.String())
+
+	require.NoError(t, check("typo.toml", `default_hatt = "~/hat"`))
+	assert.Contains(t, buf.String(), `typo.toml: unknown key "default_hatt" is ignored, did you mean "default_hat"?`)
+
+	// Unknown keys are only reported once per config.
+	buf.Reset()
+	require.NoError(t, check("typo.toml", `default_hatt = "~/hat"`))
+	assert.Empty(t, buf.String())
+
+	err := check("schema.toml", `default_schema = "htps"`)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `invalid default_schema "htps", must be one of ssh, https, http, did you mean "https"?`)
+
+	require.Error(t, check("channel.toml", `update_channel = "nightly"`))
+	require.Error(t, check("platform.toml", `platform = "amd64"`))
+	require.Error(t, check("url.toml", `code_server_url = "mirror/code-server.tar.gz"`))
+	require.NoError(t, check("url.toml", `code_server_url = "https://mirror/code-server-linux-{arch}.tar.gz"`))
+	require.Error(t, check("prebuild.toml", `prebuild_schedule = "3am"`))
+	require.NoError(t, check("prebuild.toml", `prebuild_schedule = "30 2 * * 1-5"`))
+	require.Error(t, check("args.toml", "[build_args]\n\"GO-VERSION\" = \"1.13\""))
+	require.NoError(t, check("args.toml", "[project_build_args.\"cdr/sail\"]\nGO_VERSION = \"1.13\""))
+	require.Error(t, check("backend.toml", `backend = "podman"`))
+	require.Error(t, check("backend.toml", "[backends]\n\"cdr/sail\" = \"firecracker\""))
+	require.NoError(t, check("backend.toml", "backend = \"lxd\"\n[backends]\n\"cdr/sail\" = \"ignite\""))
+}
+
+func Test_checkLabels(t *testing.T) {
+	warnings, err := checkLabels(map[string]string{
+		"maintainer":                      "cdr",
+		"org.opencontainers.image.source": "https://github.com/cdr/sail",
+		shareLabelPrefix + "go":           "~/go:~/go",
+		devicesLabel:                      "/dev/kvm",
+		forwardPortsLabel:                 "3000, 8080",
+		onStartLabel:                      "make",
+		"on_strat":                        "make",
+		"share_go_mod":                    "~/go/pkg/mod:~/go/pkg/mod",
+		sailLabel + ".on_create":          "make",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		`unknown label com.coder.sail.on_create is ignored`,
+		`unknown label on_strat is ignored, did you mean "on_start"?`,
+		`unknown label share_go_mod is ignored, did you mean "share.go_mod"?`,
+	}, warnings)
+
+	for name, labels := range map[string]map[string]string{
+		"share":         {shareLabelPrefix + "go": "~/go"},
+		"devices":       {devicesLabel: "dev/kvm"},
+		"forward_ports": {forwardPortsLabel: "3000,http"},
+		"project_root":  {projectRootLabel: "src"},
+		"share_docker":  {shareDockerLabel: "yes"},
+		"guest_home":    {guestHomeLabel: "~/coder"},
+		"guest_user":    {guestUserLabel: "coder user"},
+	} {
+		_, err := checkLabels(labels)
+		assert.Error(t, err, name)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"golang.org/x/xerrors"
+)
+
+// Image labels that describe the rights a project needs. If an image sets
+// any of them, the container runs unprivileged with only those rights.
+const (
+	// capAddLabel is a comma separated list of capabilities, e.g. SYS_PTRACE.
+	capAddLabel = sailLabel + ".cap_add"
+	// seccompLabel is a path to a seccomp profile on the host, or "unconfined".
+	seccompLabel = sailLabel + ".seccomp"
+	// apparmorLabel is the name of an AppArmor profile loaded on the host,
+	// or "unconfined".
+	apparmorLabel = sailLabel + ".apparmor"
+)
+
+// privilegedLabel records whether the container runs privileged.
+// Containers created before it was introduced are all privileged.
+const privilegedLabel = sailLabel + ".privileged"
+
+// wantsRestrictedRights reports whether labels declare the rights the
+// project needs, in which case the container shouldn't be privileged.
+func wantsRestrictedRights(labels map[string]string) bool {
+	for _, l := range []string{capAddLabel, seccompLabel, apparmorLabel} {
+		if _, ok := labels[l]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// applySecurity configures the container's rights from labels. Without any
+// rights labels the container is privileged, as it always has been.
+func applySecurity(hostConfig *container.HostConfig, labels map[string]string) error {
+	if !wantsRestrictedRights(labels) && labels[rootlessLabel] == "true" {
+		// Rootless daemons can't give containers the host's devices, so
+		// privileged containers fail to start on many of them. Every
+		// capability is as close as it gets, and they only apply within the
+		// daemon's user namespace anyway.
+		hostConfig.Privileged = false
+		hostConfig.CapAdd = []string{"ALL"}
+		labels[privilegedLabel] = "false"
+		return nil
+	}
+	if !wantsRestrictedRights(labels) {
+		hostConfig.Privileged = true
+		labels[privilegedLabel] = "true"
+		return nil
+	}
+	hostConfig.Privileged = false
+	labels[privilegedLabel] = "false"
+
+	for _, c := range strings.Split(labels[capAddLabel], ",") {
+		c = strings.TrimSpace(c)
+		if c != "" {
+			hostConfig.CapAdd = append(hostConfig.CapAdd, strings.ToUpper(c))
+		}
+	}
+
+	if profile := labels[seccompLabel]; profile != "" {
+		opt := "seccomp=unconfined"
+		if profile != "unconfined" {
+			hostHomeDir, err := os.UserHomeDir()
+			if err != nil {
+				return xerrors.Errorf("failed to get host home dir: %w", err)
+			}
+
+			// The daemon expects the profile's contents, not its path.
+			b, err := ioutil.ReadFile(resolvePath(hostHomeDir, profile))
+			if err != nil {
+				return xerrors.Errorf("failed to read seccomp profile from %v label: %w", seccompLabel, err)
+			}
+			opt = "seccomp=" + string(b)
+		}
+		hostConfig.SecurityOpt = append(hostConfig.SecurityOpt, opt)
+	}
+
+	if profile := labels[apparmorLabel]; profile != "" {
+		hostConfig.SecurityOpt = append(hostConfig.SecurityOpt, "apparmor="+profile)
+	}
+
+	return nil
+}
+
+// describeRights summarizes the rights of a container from its labels
+// for `sail ls`.
+func describeRights(labels map[string]string) string {
+	if labels[privilegedLabel] != "false" {
+		return "privileged"
+	}
+	if labels[rootlessLabel] == "true" && !wantsRestrictedRights(labels) {
+		return "rootless"
+	}
+
+	var rights []string
+	if caps := labels[capAddLabel]; caps != "" {
+		rights = append(rights, "cap_add="+caps)
+	}
+	if profile := labels[seccompLabel]; profile == "unconfined" {
+		rights = append(rights, "seccomp=unconfined")
+	}
+	if profile := labels[apparmorLabel]; profile == "unconfined" {
+		rights = append(rights, "apparmor=unconfined")
+	}
+	return strings.Join(rights, " ")
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_applySecurity(t *testing.T) {
+	t.Run("Privileged", func(t *testing.T) {
+		var hc container.HostConfig
+		labels := map[string]string{}
+		require.NoError(t, applySecurity(&hc, labels))
+
+		assert.True(t, hc.Privileged)
+		assert.Equal(t, "privileged", describeRights(labels))
+	})
+
+	t.Run("Restricted", func(t *testing.T) {
+		var hc container.HostConfig
+		labels := map[string]string{
+			capAddLabel:   "sys_ptrace, NET_ADMIN",
+			apparmorLabel: "unconfined",
+		}
+		require.NoError(t, applySecurity(&hc, labels))
+
+		assert.False(t, hc.Privileged)
+		assert.Equal(t, []string{"SYS_PTRACE", "NET_ADMIN"}, []string(hc.CapAdd))
+		assert.Equal(t, []string{"apparmor=unconfined"}, hc.SecurityOpt)
+		assert.Equal(t, "cap_add=sys_ptrace, NET_ADMIN apparmor=unconfined", describeRights(labels))
+	})
+
+	t.Run("Rootless", func(t *testing.T) {
+		var hc container.HostConfig
+		labels := map[string]string{rootlessLabel: "true"}
+		require.NoError(t, applySecurity(&hc, labels))
+
+		assert.False(t, hc.Privileged)
+		assert.Equal(t, []string{"ALL"}, []string(hc.CapAdd))
+		assert.Equal(t, "rootless", describeRights(labels))
+	})
+
+	t.Run("MissingSeccompProfile", func(t *testing.T) {
+		var hc container.HostConfig
+		err := applySecurity(&hc, map[string]string{
+			seccompLabel: "/does/not/exist.json",
+		})
+		require.Error(t, err)
+	})
+}
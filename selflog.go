@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"go.coder.com/sail/internal/xlog"
+)
+
+// maxSelfLogs is how many invocations' logs are kept.
+const maxSelfLogs = 50
+
+// selfLogDir holds a JSON log file per sail invocation.
+func selfLogDir() string {
+	return filepath.Join(metaRoot(), "logs")
+}
+
+// setupSelfLog logs this invocation of command to its own file, and removes
+// the logs of old invocations.
+func setupSelfLog(command string) {
+	dir := selfLogDir()
+	// The time prefix sorts the logs by when they were started.
+	name := fmt.Sprintf("%v-%v.jsonl", time.Now().Format("20060102T150405.000"), os.Getpid())
+	xlog.SetFile(filepath.Join(dir, name), xlog.Fields{
+		"command": command,
+		"pid":     os.Getpid(),
+	})
+
+	logs, err := selfLogs(dir)
+	if err != nil {
+		return
+	}
+	// The file of this invocation is only created once it logs, so keep
+	// room for it.
+	for len(logs) >= maxSelfLogs {
+		os.Remove(logs[0])
+		logs = logs[1:]
+	}
+}
+
+// selfLogs returns the log files in dir, oldest first.
+func selfLogs(dir string) ([]string, error) {
+	fis, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var logs []string
+	for _, fi := range fis {
+		if strings.HasSuffix(fi.Name(), ".jsonl") {
+			logs = append(logs, filepath.Join(dir, fi.Name()))
+		}
+	}
+	sort.Strings(logs)
+	return logs, nil
+}
+
+// readSelfLog reads the entries of a log file.
+func readSelfLog(path string) ([]xlog.Entry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []xlog.Entry
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		var e xlog.Entry
+		err = json.Unmarshal(sc.Bytes(), &e)
+		if err != nil {
+			// The invocation may still be writing the entry.
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, sc.Err()
+}
+
+// recentSelfLogs returns the last n entries logged by sail invocations in dir,
+// oldest first.
+func recentSelfLogs(dir string, n int) ([]xlog.Entry, error) {
+	logs, err := selfLogs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []xlog.Entry
+	for i := len(logs) - 1; i >= 0 && len(entries) < n; i-- {
+		es, err := readSelfLog(logs[i])
+		if os.IsNotExist(err) {
+			// Removed by another invocation.
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		entries = append(es, entries...)
+	}
+	if len(entries) > n {
+		entries = entries[len(entries)-n:]
+	}
+	return entries, nil
+}
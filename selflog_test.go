@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_recentSelfLogs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sail-logs")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	write := func(name string, msgs ...string) {
+		var b []byte
+		for _, m := range msgs {
+			b = append(b, fmt.Sprintf(`{"time":"2019-06-01T00:00:00Z","level":"info","msg":%q}`+"\n", m)...)
+		}
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), b, 0640))
+	}
+	write("20190601T000000.000-1.jsonl", "a", "b")
+	write("20190601T000001.000-2.jsonl", "c")
+	// Partially written entries are skipped.
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "20190601T000002.000-3.jsonl"), []byte(`{"time":"2019-06-01T00:00:02Z","level":"info","msg":"d"}`+"\n"+`{"time":`), 0640))
+
+	msgs := func(n int) []string {
+		entries, err := recentSelfLogs(dir, n)
+		require.NoError(t, err)
+		var msgs []string
+		for _, e := range entries {
+			msgs = append(msgs, e.Msg)
+		}
+		return msgs
+	}
+	assert.Equal(t, []string{"a", "b", "c", "d"}, msgs(10))
+	assert.Equal(t, []string{"b", "c", "d"}, msgs(3))
+	assert.Empty(t, msgs(0))
+
+	entries, err := recentSelfLogs(filepath.Join(dir, "missing"), 10)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v24/github"
+	"golang.org/x/xerrors"
+
+	"go.coder.com/cli"
+	"go.coder.com/sail/internal/xlog"
+)
+
+// Release channels of sail selfupdate.
+const (
+	// channelStable only has full releases.
+	channelStable = "stable"
+	// channelEdge also has pre-releases.
+	channelEdge = "edge"
+)
+
+// checksumsAsset is the release asset listing the SHA-256 checksums of the
+// other assets, in the format of sha256sum.
+const checksumsAsset = "SHA256SUMS"
+
+type selfupdatecmd struct {
+	gf *globalFlags
+
+	channel   string
+	check     bool
+	downgrade bool
+}
+
+func (c *selfupdatecmd) Spec() cli.CommandSpec {
+	return cli.CommandSpec{
+		Name:  "selfupdate",
+		Usage: "[flags]",
+		Desc: `Updates sail to the latest release of its channel.
+
+The release is verified against its published checksum before it replaces the
+running binary. Older releases, e.g. the latest stable release when running an
+edge one, are only installed with --downgrade.`,
+	}
+}
+
+func (c *selfupdatecmd) RegisterFlags(fl *flag.FlagSet) {
+	fl.StringVar(&c.channel, "channel", "", "Release channel to update from (stable or edge). Defaults to update_channel in the config.")
+	fl.BoolVar(&c.check, "check", false, "Only check whether an update is available.")
+	fl.BoolVar(&c.downgrade, "downgrade", false, "Install the latest release of the channel even if it's older than this one.")
+}
+
+func (c *selfupdatecmd) Run(fl *flag.FlagSet) {
+	channel := c.channel
+	if channel == "" {
+		channel = c.gf.config().UpdateChannel
+	}
+	if channel == "" {
+		channel = channelStable
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*5)
+	defer cancel()
+
+	rels, _, err := github.NewClient(nil).Repositories.ListReleases(ctx, "cdr", "sail", &github.ListOptions{PerPage: 30})
+	if err != nil {
+		xlog.Fatal("failed to list sail releases: %v", err)
+	}
+	rel, err := channelRelease(rels, channel)
+	if err != nil {
+		xlog.Fatal("%v", err)
+	}
+
+	tag := rel.GetTagName()
+	// Development builds have no version, so any release replaces them.
+	if version != "" {
+		cmp, err := compareVersions(tag, version)
+		if err != nil {
+			xlog.Fatal("%v", err)
+		}
+		switch {
+		case cmp == 0:
+			xlog.Success("sail %v is the latest %v release", version, channel)
+			return
+		case cmp < 0 && !c.downgrade:
+			xlog.Info("sail %v is newer than %v, the latest %v release, pass --downgrade to install it", version, tag, channel)
+			return
+		}
+	}
+	if c.check {
+		xlog.Info("sail %v is available on the %v channel, you have %v", tag, channel, versionOrDev())
+		return
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		xlog.Fatal("failed to find sail binary: %v", err)
+	}
+	exe, err = filepath.EvalSymlinks(exe)
+	if err != nil {
+		xlog.Fatal("failed to resolve sail binary: %v", err)
+	}
+
+	xlog.Info("updating sail from %v to %v", versionOrDev(), tag)
+	err = installRelease(ctx, rel, exe)
+	if err != nil {
+		xlog.Fatal("failed to update sail: %v", err)
+	}
+	xlog.Success("updated %v to %v", exe, tag)
+}
+
+func versionOrDev() string {
+	if version == "" {
+		return "a development build"
+	}
+	return version
+}
+
+// semver is a semantic version, see https://semver.org.
+type semver struct {
+	major, minor, patch int
+	// pre are the dot separated identifiers of the pre-release version.
+	pre []string
+}
+
+// parseSemver parses the semantic version v, which may be prefixed with v
+// like sail's tags. Build metadata is ignored.
+func parseSemver(v string) (semver, error) {
+	s := strings.TrimPrefix(v, "v")
+	if i := strings.Index(s, "+"); i >= 0 {
+		s = s[:i]
+	}
+	var sv semver
+	if i := strings.Index(s, "-"); i >= 0 {
+		sv.pre = strings.Split(s[i+1:], ".")
+		s = s[:i]
+		for _, id := range sv.pre {
+			if id == "" {
+				return semver{}, xerrors.Errorf("invalid version %q", v)
+			}
+		}
+	}
+	parts := strings.Split(s, ".")
+	if len(parts) != 3 {
+		return semver{}, xerrors.Errorf("invalid version %q", v)
+	}
+	nums := []*int{&sv.major, &sv.minor, &sv.patch}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 || (len(p) > 1 && p[0] == '0') {
+			return semver{}, xerrors.Errorf("invalid version %q", v)
+		}
+		*nums[i] = n
+	}
+	return sv, nil
+}
+
+// compareVersions returns -1, 0 or 1 if the version a is older than, the same
+// as, or newer than b, by the precedence of semantic versions.
+func compareVersions(a, b string) (int, error) {
+	av, err := parseSemver(a)
+	if err != nil {
+		return 0, err
+	}
+	bv, err := parseSemver(b)
+	if err != nil {
+		return 0, err
+	}
+	for _, c := range [][2]int{{av.major, bv.major}, {av.minor, bv.minor}, {av.patch, bv.patch}} {
+		if c[0] != c[1] {
+			return compareInts(c[0], c[1]), nil
+		}
+	}
+
+	// Pre-releases are older than their release.
+	switch {
+	case len(av.pre) == 0 && len(bv.pre) == 0:
+		return 0, nil
+	case len(av.pre) == 0:
+		return 1, nil
+	case len(bv.pre) == 0:
+		return -1, nil
+	}
+	for i := 0; i < len(av.pre) && i < len(bv.pre); i++ {
+		if cmp := comparePrerelease(av.pre[i], bv.pre[i]); cmp != 0 {
+			return cmp, nil
+		}
+	}
+	return compareInts(len(av.pre), len(bv.pre)), nil
+}
+
+// comparePrerelease compares the pre-release identifiers a and b. Numeric
+// ones are compared as numbers, and are older than alphanumeric ones.
+func comparePrerelease(a, b string) int {
+	an, aerr := strconv.Atoi(a)
+	bn, berr := strconv.Atoi(b)
+	switch {
+	case aerr == nil && berr == nil:
+		return compareInts(an, bn)
+	case aerr == nil:
+		return -1
+	case berr == nil:
+		return 1
+	default:
+		return strings.Compare(a, b)
+	}
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// channelRelease returns the newest release of channel in rels, which are
+// ordered newest first as GitHub lists them.
+func channelRelease(rels []*github.RepositoryRelease, channel string) (*github.RepositoryRelease, error) {
+	if channel != channelStable && channel != channelEdge {
+		return nil, xerrors.Errorf("unknown release channel %q, must be %v or %v", channel, channelStable, channelEdge)
+	}
+	for _, rel := range rels {
+		if rel.GetDraft() {
+			continue
+		}
+		if rel.GetPrerelease() && channel == channelStable {
+			continue
+		}
+		return rel, nil
+	}
+	return nil, xerrors.Errorf("no %v release found", channel)
+}
+
+// releaseAssetName is the name of the release tarball for this platform.
+func releaseAssetName() string {
+	return fmt.Sprintf("sail-%v-%v.tar.gz", runtime.GOOS, runtime.GOARCH)
+}
+
+// installRelease downloads this platform's binary of rel, verifies its
+// checksum and atomically replaces the binary at exe with it.
+func installRelease(ctx context.Context, rel *github.RepositoryRelease, exe string) error {
+	var tarURL, sumsURL string
+	for _, a := range rel.Assets {
+		switch a.GetName() {
+		case releaseAssetName():
+			tarURL = a.GetBrowserDownloadURL()
+		case checksumsAsset:
+			sumsURL = a.GetBrowserDownloadURL()
+		}
+	}
+	if tarURL == "" {
+		return xerrors.Errorf("%v has no %v", rel.GetTagName(), releaseAssetName())
+	}
+	// Never install a binary we can't verify.
+	if sumsURL == "" {
+		return xerrors.Errorf("%v has no %v", rel.GetTagName(), checksumsAsset)
+	}
+
+	sums, err := download(ctx, sumsURL)
+	if err != nil {
+		return err
+	}
+	want, err := findChecksum(bytes.NewReader(sums), releaseAssetName())
+	if err != nil {
+		return err
+	}
+
+	tarball, err := download(ctx, tarURL)
+	if err != nil {
+		return err
+	}
+	got := sha256.Sum256(tarball)
+	if hex.EncodeToString(got[:]) != want {
+		return xerrors.Errorf("checksum mismatch for %v: got %x, expected %v", releaseAssetName(), got, want)
+	}
+
+	bin, err := extractSail(bytes.NewReader(tarball))
+	if err != nil {
+		return xerrors.Errorf("failed to extract %v: %w", releaseAssetName(), err)
+	}
+	return replaceBinary(exe, bin)
+}
+
+func download(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, xerrors.Errorf("failed to get %v: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, xerrors.Errorf("failed to get %v: %v", url, resp.Status)
+	}
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to read %v: %w", url, err)
+	}
+	return b, nil
+}
+
+// findChecksum returns the checksum of name from a sha256sum listing.
+func findChecksum(r io.Reader, name string) (string, error) {
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		// Binary mode prefixes the name with a star.
+		if len(fields) != 2 || strings.TrimPrefix(fields[1], "*") != name {
+			continue
+		}
+		sum := strings.ToLower(fields[0])
+		b, err := hex.DecodeString(sum)
+		if err != nil || len(b) != sha256.Size {
+			return "", xerrors.Errorf("invalid checksum %q for %v", fields[0], name)
+		}
+		return sum, nil
+	}
+	if err := sc.Err(); err != nil {
+		return "", err
+	}
+	return "", xerrors.Errorf("no checksum for %v", name)
+}
+
+// extractSail returns the sail binary in a release tarball.
+func extractSail(r io.Reader) ([]byte, error) {
+	grd, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to create gzip decoder: %w", err)
+	}
+	defer grd.Close()
+
+	rd := tar.NewReader(grd)
+	for {
+		hdr, err := rd.Next()
+		if err == io.EOF {
+			return nil, xerrors.New("sail not found")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if filepath.Base(hdr.Name) == "sail" {
+			return ioutil.ReadAll(rd)
+		}
+	}
+}
+
+// replaceBinary replaces the binary at path with bin by renaming a new file
+// over it, which works while the binary is running and never leaves a partial
+// binary behind.
+func replaceBinary(path string, bin []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+"-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	_, err = tmp.Write(bin)
+	if err != nil {
+		return xerrors.Errorf("failed to write %v: %w", tmp.Name(), err)
+	}
+	err = tmp.Sync()
+	if err != nil {
+		return err
+	}
+	err = tmp.Chmod(0755)
+	if err != nil {
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return xerrors.Errorf("failed to close %v: %w", tmp.Name(), err)
+	}
+
+	err = os.Rename(tmp.Name(), path)
+	if err != nil {
+		return xerrors.Errorf("failed to rename %v to %v: %w", tmp.Name(), path, err)
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v24/github"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_channelRelease(t *testing.T) {
+	rels := []*github.RepositoryRelease{
+		{TagName: github.String("v0.3.0"), Draft: github.Bool(true)},
+		{TagName: github.String("v0.2.0-rc.1"), Prerelease: github.Bool(true)},
+		{TagName: github.String("v0.1.0")},
+	}
+
+	rel, err := channelRelease(rels, channelStable)
+	require.NoError(t, err)
+	assert.Equal(t, "v0.1.0", rel.GetTagName())
+
+	rel, err = channelRelease(rels, channelEdge)
+	require.NoError(t, err)
+	assert.Equal(t, "v0.2.0-rc.1", rel.GetTagName())
+
+	_, err = channelRelease(rels, "nightly")
+	require.Error(t, err)
+	_, err = channelRelease(rels[:2], channelStable)
+	require.Error(t, err)
+}
+
+func Test_findChecksum(t *testing.T) {
+	darwin := strings.Repeat("ab", sha256.Size)
+	linux := strings.Repeat("cd", sha256.Size)
+	sums := strings.ToUpper(darwin) + "  sail-darwin-amd64.tar.gz\n" + linux + " *sail-linux-amd64.tar.gz\nabc123  sail-windows-amd64.tar.gz\n"
+
+	sum, err := findChecksum(strings.NewReader(sums), "sail-darwin-amd64.tar.gz")
+	require.NoError(t, err)
+	assert.Equal(t, darwin, sum)
+
+	sum, err = findChecksum(strings.NewReader(sums), "sail-linux-amd64.tar.gz")
+	require.NoError(t, err)
+	assert.Equal(t, linux, sum)
+
+	_, err = findChecksum(strings.NewReader(sums), "sail-windows-amd64.tar.gz")
+	require.Error(t, err)
+	_, err = findChecksum(strings.NewReader(sums), "sail-freebsd-amd64.tar.gz")
+	require.Error(t, err)
+}
+
+func Test_compareVersions(t *testing.T) {
+	for _, tc := range []struct {
+		a, b string
+		cmp  int
+	}{
+		{"v0.1.0", "v0.1.0", 0},
+		{"v0.10.0", "v0.9.0", 1},
+		{"v0.9.0", "v0.10.0", -1},
+		{"v1.0.0", "v0.99.99", 1},
+		{"v0.2.0-rc.1", "v0.2.0", -1},
+		{"v0.2.0", "v0.2.0-rc.1", 1},
+		{"v0.2.0-rc.2", "v0.2.0-rc.10", -1},
+		{"v0.2.0-rc.1", "v0.2.0-rc.1.1", -1},
+		{"v0.2.0-1", "v0.2.0-alpha", -1},
+		{"v0.2.0-alpha", "v0.2.0-beta", -1},
+		{"0.2.0+build.1", "v0.2.0", 0},
+	} {
+		cmp, err := compareVersions(tc.a, tc.b)
+		require.NoError(t, err, tc.a)
+		assert.Equal(t, tc.cmp, cmp, "%v, %v", tc.a, tc.b)
+	}
+
+	for _, v := range []string{"", "latest", "v1.2", "v1.2.3.4", "v01.2.3", "v1.2.3-", "v1.2.3-rc..1"} {
+		_, err := compareVersions(v, "v0.1.0")
+		assert.Error(t, err, v)
+	}
+}
+
+func Test_installRelease(t *testing.T) {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	bin := []byte("#!/bin/sh\necho new\n")
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "sail", Mode: 0755, Size: int64(len(bin))}))
+	_, err := tw.Write(bin)
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+	require.NoError(t, gw.Close())
+	tarball := buf.Bytes()
+
+	sum := sha256.Sum256(tarball)
+	sums := hex.EncodeToString(sum[:]) + "  " + releaseAssetName() + "\n"
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/" + checksumsAsset:
+			w.Write([]byte(sums))
+		case "/" + releaseAssetName():
+			w.Write(tarball)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer s.Close()
+
+	dir, err := ioutil.TempDir("", "sail-selfupdate")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	exe := filepath.Join(dir, "sail")
+	require.NoError(t, ioutil.WriteFile(exe, []byte("old"), 0755))
+
+	rel := &github.RepositoryRelease{
+		TagName: github.String("v0.2.0"),
+		Assets: []github.ReleaseAsset{
+			{Name: github.String(releaseAssetName()), BrowserDownloadURL: github.String(s.URL + "/" + releaseAssetName())},
+			{Name: github.String(checksumsAsset), BrowserDownloadURL: github.String(s.URL + "/" + checksumsAsset)},
+		},
+	}
+
+	// A tarball that doesn't match its checksum isn't installed.
+	sums = strings.Repeat("0", sha256.Size*2) + "  " + releaseAssetName() + "\n"
+	err = installRelease(context.Background(), rel, exe)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "checksum mismatch")
+	b, err := ioutil.ReadFile(exe)
+	require.NoError(t, err)
+	assert.Equal(t, "old", string(b))
+
+	sums = hex.EncodeToString(sum[:]) + "  " + releaseAssetName() + "\n"
+	require.NoError(t, installRelease(context.Background(), rel, exe))
+	b, err = ioutil.ReadFile(exe)
+	require.NoError(t, err)
+	assert.Equal(t, bin, b)
+
+	// Releases without checksums aren't installed.
+	rel.Assets = rel.Assets[:1]
+	require.Error(t, installRelease(context.Background(), rel, exe))
+}
+
+func Test_installBinary(t *testing.T) {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	bin := []byte("#!/bin/sh\necho new\n")
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "sail", Mode: 0755, Size: int64(len(bin))}))
+	_, err := tw.Write(bin)
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+	require.NoError(t, gw.Close())
+
+	extracted, err := extractSail(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, bin, extracted)
+
+	dir, err := ioutil.TempDir("", "sail-selfupdate")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	exe := filepath.Join(dir, "sail")
+	require.NoError(t, ioutil.WriteFile(exe, []byte("old"), 0755))
+	require.NoError(t, replaceBinary(exe, extracted))
+
+	b, err := ioutil.ReadFile(exe)
+	require.NoError(t, err)
+	assert.Equal(t, bin, b)
+	fi, err := os.Stat(exe)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0755), fi.Mode().Perm())
+
+	// The temporary file is gone.
+	fis, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, fis, 1)
+}
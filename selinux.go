@@ -0,0 +1,94 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+)
+
+// SELinux relabeling options of bind mounts, like those of docker run -v.
+const (
+	// relabelShared lets every container use the mount's source.
+	relabelShared = "z"
+	// relabelPrivate lets only the container use the mount's source.
+	relabelPrivate = "Z"
+)
+
+// splitRelabel splits the relabeling option off a mount spec of the form
+// host_path:guest_path[:z|Z].
+func splitRelabel(spec string) (string, string) {
+	for _, opt := range []string{relabelShared, relabelPrivate} {
+		if strings.HasSuffix(spec, ":"+opt) {
+			return strings.TrimSuffix(spec, ":"+opt), opt
+		}
+	}
+	return spec, ""
+}
+
+// relabelOwn relabels the source of sail's own mount at target for all
+// containers, if the daemon uses SELinux. The containers couldn't read it
+// otherwise.
+func (r *runner) relabelOwn(target string) {
+	if r.selinux {
+		r.relabel(target, relabelShared)
+	}
+}
+
+// relabel relabels the source of the mount at target with opt.
+func (r *runner) relabel(target, opt string) {
+	if r.relabels == nil {
+		r.relabels = make(map[string]string)
+	}
+	r.relabels[resolvePath(r.guestHome(), target)] = opt
+}
+
+// relabelMounts moves the bind mounts to relabel to hostConfig.Binds, as
+// mounts can't be relabeled through the API.
+func (r *runner) relabelMounts(hostConfig *container.HostConfig) {
+	mounts := make([]mount.Mount, 0, len(hostConfig.Mounts))
+	for _, m := range hostConfig.Mounts {
+		opt := r.relabels[m.Target]
+		if m.Type != mount.TypeBind || opt == "" {
+			mounts = append(mounts, m)
+			continue
+		}
+		if m.ReadOnly {
+			opt = "ro," + opt
+		}
+		hostConfig.Binds = append(hostConfig.Binds, m.Source+":"+m.Target+":"+opt)
+	}
+	hostConfig.Mounts = mounts
+}
+
+// hostMounts returns the mounts of hostConfig, including those moved to
+// its binds by relabelMounts, along with their relabeling options.
+func hostMounts(hostConfig *container.HostConfig) ([]mount.Mount, []string) {
+	mounts := append([]mount.Mount(nil), hostConfig.Mounts...)
+	relabels := make([]string, len(mounts))
+	for _, b := range hostConfig.Binds {
+		toks := strings.SplitN(b, ":", 3)
+		if len(toks) < 2 {
+			continue
+		}
+		m := mount.Mount{
+			Type:   mount.TypeBind,
+			Source: toks[0],
+			Target: toks[1],
+		}
+		var relabel string
+		if len(toks) == 3 {
+			for _, opt := range strings.Split(toks[2], ",") {
+				switch opt {
+				case "ro":
+					m.ReadOnly = true
+				case relabelShared, relabelPrivate:
+					relabel = opt
+				}
+			}
+		}
+		mounts = append(mounts, m)
+		relabels = append(relabels, relabel)
+	}
+	return mounts, relabels
+}
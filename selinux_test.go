@@ -0,0 +1,66 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_splitRelabel(t *testing.T) {
+	for _, tc := range []struct {
+		spec    string
+		rest    string
+		relabel string
+	}{
+		{"/a:/b", "/a:/b", ""},
+		{"/a:/b:z", "/a:/b", relabelShared},
+		{"/a:/b:Z", "/a:/b", relabelPrivate},
+		{"/a:/z", "/a:/z", ""},
+	} {
+		rest, relabel := splitRelabel(tc.spec)
+		assert.Equal(t, tc.rest, rest, tc.spec)
+		assert.Equal(t, tc.relabel, relabel, tc.spec)
+	}
+}
+
+func Test_relabelMounts(t *testing.T) {
+	r := &runner{selinux: true}
+	r.relabelOwn("~/sail")
+	r.relabel("/data", relabelPrivate)
+	r.relabel("/cache", relabelShared)
+
+	hc := &container.HostConfig{
+		Mounts: []mount.Mount{
+			{Type: mount.TypeBind, Source: "/home/me/sail", Target: "/home/user/sail"},
+			{Type: mount.TypeBind, Source: "/tmp/.X11-unix", Target: "/tmp/.X11-unix"},
+			{Type: mount.TypeBind, Source: "/srv/data", Target: "/data", ReadOnly: true},
+			{Type: mount.TypeVolume, Source: "cache", Target: "/cache"},
+		},
+	}
+	r.relabelMounts(hc)
+
+	assert.Equal(t, []mount.Mount{
+		{Type: mount.TypeBind, Source: "/tmp/.X11-unix", Target: "/tmp/.X11-unix"},
+		{Type: mount.TypeVolume, Source: "cache", Target: "/cache"},
+	}, hc.Mounts)
+	assert.Equal(t, []string{
+		"/home/me/sail:/home/user/sail:z",
+		"/srv/data:/data:ro,Z",
+	}, hc.Binds)
+
+	mounts, relabels := hostMounts(hc)
+	assert.Equal(t, []mount.Mount{
+		{Type: mount.TypeBind, Source: "/tmp/.X11-unix", Target: "/tmp/.X11-unix"},
+		{Type: mount.TypeVolume, Source: "cache", Target: "/cache"},
+		{Type: mount.TypeBind, Source: "/home/me/sail", Target: "/home/user/sail"},
+		{Type: mount.TypeBind, Source: "/srv/data", Target: "/data", ReadOnly: true},
+	}, mounts)
+	assert.Equal(t, []string{"", "", relabelShared, relabelPrivate}, relabels)
+
+	// Without SELinux, only the mounts that asked for it are relabeled.
+	r = &runner{}
+	r.relabelOwn("~/sail")
+	assert.Empty(t, r.relabels)
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/docker/docker/api/types/mount"
+	"golang.org/x/xerrors"
+
+	"go.coder.com/sail/internal/xlog"
+)
+
+// shareLabelPrefix is the prefix of image labels that define shares,
+// e.g. share.go_mod="~/go/pkg/mod:~/go/pkg/mod".
+const shareLabelPrefix = "share."
+
+// mountError describes a mount that couldn't be set up.
+type mountError struct {
+	// label is the image label that defined the mount.
+	// It's empty for mounts added by sail itself.
+	label string
+	mount mount.Mount
+	err   error
+	// hint suggests how the user can fix the error.
+	hint string
+}
+
+func (e *mountError) Error() string {
+	var b strings.Builder
+	if e.label != "" {
+		fmt.Fprintf(&b, "label %v: ", e.label)
+	}
+	fmt.Fprintf(&b, "mount %v:%v: %v", e.mount.Source, e.mount.Target, e.err)
+	if e.hint != "" {
+		fmt.Fprintf(&b, "\nhint: %v", e.hint)
+	}
+	return b.String()
+}
+
+func (e *mountError) Unwrap() error {
+	return e.err
+}
+
+// parseShareLabel parses a share label of the form share.<name>="host_path:guest_path[:z|Z]"
+// into a mount. The relabeling option is read with splitRelabel. The paths
+// may be templates, which expandMount expands, and the host path may be a
+// glob pattern, which globMounts expands.
+func parseShareLabel(key, value string) (mount.Mount, error) {
+	spec, _ := splitRelabel(value)
+	tokens := strings.Split(spec, ":")
+	if len(tokens) != 2 || tokens[0] == "" || tokens[1] == "" {
+		return mount.Mount{}, &mountError{
+			label: key,
+			err:   xerrors.Errorf("invalid share %q", value),
+			hint:  fmt.Sprintf(`shares must be of form %v<name>="host_path:guest_path[:z|Z]"`, shareLabelPrefix),
+		}
+	}
+
+	m := mount.Mount{
+		Type:   mount.TypeBind,
+		Source: tokens[0],
+		Target: tokens[1],
+	}
+
+	for _, path := range tokens {
+		err := checkTemplate(path)
+		if err != nil {
+			return mount.Mount{}, &mountError{
+				label: key,
+				mount: m,
+				err:   err,
+				hint:  templateHint,
+			}
+		}
+	}
+	if isGlob(m.Source) {
+		if _, err := filepath.Match(m.Source, ""); err != nil {
+			return mount.Mount{}, &mountError{
+				label: key,
+				mount: m,
+				err:   xerrors.Errorf("invalid host path pattern: %w", err),
+				hint:  "host paths may use the wildcards of shell globs, *, ? and [...]",
+			}
+		}
+	}
+	// ${HOME} is the container user's home in guest paths, like ~.
+	target := expandTemplate(m.Target, map[string]string{templateHome: guestHomeDir})
+	if !filepath.IsAbs(resolvePath(guestHomeDir, target)) {
+		return mount.Mount{}, &mountError{
+			label: key,
+			mount: m,
+			err:   xerrors.Errorf("guest path %q is relative", m.Target),
+			hint:  "guest paths must be absolute or start with ~/",
+		}
+	}
+
+	return m, nil
+}
+
+// isGlob reports whether the host path p of a share is a glob pattern, such
+// as ~/.aws*.
+func isGlob(p string) bool {
+	return strings.ContainsAny(p, "*?[")
+}
+
+// globMounts expands the resolved share m, whose host path is a glob pattern,
+// to a mount of each path matching it. Each is mounted in m's guest path,
+// under its own name. Shares without matches have no mounts.
+func globMounts(m mount.Mount) ([]mount.Mount, error) {
+	matches, err := filepath.Glob(m.Source)
+	if err != nil {
+		return nil, err
+	}
+	mounts := make([]mount.Mount, 0, len(matches))
+	for _, match := range matches {
+		gm := m
+		gm.Source = match
+		gm.Target = path.Join(m.Target, filepath.Base(match))
+		mounts = append(mounts, gm)
+	}
+	return mounts, nil
+}
+
+// checkShare runs pre-flight checks on a resolved share before the container
+// is created, so problems are reported with the label at fault instead of as
+// an opaque error from Docker.
+func checkShare(label string, m mount.Mount, sailMounts []mount.Mount, projectDir, home string) error {
+	for _, sm := range sailMounts {
+		if resolvePath(home, sm.Target) == m.Target {
+			return &mountError{
+				label: label,
+				mount: m,
+				err:   xerrors.Errorf("guest path collides with sail's mount of %v", sm.Source),
+				hint:  fmt.Sprintf("choose a different guest path, %v is managed by sail", m.Target),
+			}
+		}
+	}
+
+	projectDir = resolvePath(home, projectDir)
+	switch {
+	case m.Target == projectDir:
+		xlog.Warn("%v mounts over the project directory %v, the project will be hidden", label, projectDir)
+	case strings.HasPrefix(m.Target, projectDir+"/"):
+		xlog.Warn("%v mounts inside of the project directory %v, shadowing %v", label, projectDir, m.Target)
+	}
+
+	_, err := os.Stat(m.Source)
+	if err == nil {
+		return nil
+	}
+	if !os.IsNotExist(err) {
+		return &mountError{
+			label: label,
+			mount: m,
+			err:   xerrors.Errorf("failed to stat host path: %w", err),
+		}
+	}
+	if !confirm(fmt.Sprintf("%v: host path %v doesn't exist, create it?", label, m.Source)) {
+		return &mountError{
+			label: label,
+			mount: m,
+			err:   xerrors.New("host path doesn't exist"),
+			hint:  "create the host path or remove the share",
+		}
+	}
+	return nil
+}
+
+// shareLabels returns the share labels from labels, sorted by key so that
+// errors are reported deterministically.
+func shareLabels(labels map[string]string) []string {
+	var keys []string
+	for k := range labels {
+		if strings.HasPrefix(k, shareLabelPrefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// validateImageLabels ensures that every sail label on image is well formed,
+// and warns about labels that look like misspelled sail labels. It's run after
+// building an image so mistakes are reported before a container is ever created.
+func validateImageLabels(image string) error {
+	cli := dockerClient()
+	defer cli.Close()
+
+	ins, _, err := cli.ImageInspectWithRaw(context.Background(), image)
+	if err != nil {
+		return xerrors.Errorf("failed to inspect %v: %w", image, err)
+	}
+
+	warnings, err := checkLabels(ins.Config.Labels)
+	for _, w := range warnings {
+		xlog.Warn("%v: %v", image, w)
+	}
+	return err
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/api/types/mount"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+)
+
+func Test_parseShareLabel(t *testing.T) {
+	m, err := parseShareLabel("share.go_mod", "~/go/pkg/mod:~/go/pkg/mod")
+	require.NoError(t, err)
+	assert.Equal(t, mount.Mount{
+		Type:   mount.TypeBind,
+		Source: "~/go/pkg/mod",
+		Target: "~/go/pkg/mod",
+	}, m)
+
+	m, err = parseShareLabel("share.data", "/srv/data:/data:Z")
+	require.NoError(t, err)
+	assert.Equal(t, "/data", m.Target)
+
+	for _, v := range []string{"~/go", "~/go:", ":~/go", "/a:/b:/c", "/a:relative", "/a:/b:x"} {
+		_, err := parseShareLabel("share.bad", v)
+		require.Error(t, err, v)
+
+		var merr *mountError
+		require.True(t, xerrors.As(err, &merr), v)
+		assert.Equal(t, "share.bad", merr.label)
+		assert.NotEmpty(t, merr.hint)
+	}
+}
+
+func Test_checkShare(t *testing.T) {
+	sailMounts := []mount.Mount{
+		{Source: "/tmp/code-server", Target: "/usr/bin/code-server"},
+		{Source: "/tmp/globalStorage", Target: "~/.local/share/code-server/globalStorage/"},
+	}
+
+	err := checkShare("share.ok", mount.Mount{Source: os.TempDir(), Target: "/tmp/share"}, sailMounts, "~/sail", guestHomeDir)
+	require.NoError(t, err)
+
+	err = checkShare("share.storage", mount.Mount{
+		Source: os.TempDir(),
+		Target: "/home/user/.local/share/code-server/globalStorage",
+	}, sailMounts, "~/sail", guestHomeDir)
+	var merr *mountError
+	require.True(t, xerrors.As(err, &merr))
+	assert.Equal(t, "share.storage", merr.label)
+
+	// The sail mounts are under the home directory of the image's user.
+	err = checkShare("share.storage", mount.Mount{
+		Source: os.TempDir(),
+		Target: "/home/user/.local/share/code-server/globalStorage",
+	}, sailMounts, "~/sail", "/home/coder")
+	require.NoError(t, err)
+}
+
+func Test_globMounts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sail-glob")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	for _, name := range []string{".aws", ".aws-sso", ".azure"} {
+		require.NoError(t, os.Mkdir(filepath.Join(dir, name), 0755))
+	}
+
+	m, err := parseShareLabel("share.aws", "~/.aws*:~")
+	require.NoError(t, err)
+	m.Source = filepath.Join(dir, ".aws*")
+	m.Target = "/home/user"
+	mounts, err := globMounts(m)
+	require.NoError(t, err)
+	assert.Equal(t, []mount.Mount{
+		{Type: mount.TypeBind, Source: filepath.Join(dir, ".aws"), Target: "/home/user/.aws"},
+		{Type: mount.TypeBind, Source: filepath.Join(dir, ".aws-sso"), Target: "/home/user/.aws-sso"},
+	}, mounts)
+
+	// Patterns without matches are skipped.
+	m.Source = filepath.Join(dir, ".gcloud*")
+	mounts, err = globMounts(m)
+	require.NoError(t, err)
+	assert.Empty(t, mounts)
+
+	_, err = parseShareLabel("share.aws", "~/.aws[:~")
+	require.Error(t, err)
+}
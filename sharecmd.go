@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"go.coder.com/cli"
+	"go.coder.com/sail/internal/randstr"
+	"go.coder.com/sail/internal/xlog"
+)
+
+// shareTokenParam is the query parameter of share links holding their token.
+// It's traded for shareCookie on the first request.
+const shareTokenParam = "sail_share_token"
+
+// shareCookie authenticates the requests of whoever opened a share link.
+const shareCookie = "sail_share"
+
+type sharecmd struct {
+	gf *globalFlags
+
+	expire   time.Duration
+	readOnly bool
+	guest    bool
+	tunnel   string
+}
+
+func (c *sharecmd) Spec() cli.CommandSpec {
+	return cli.CommandSpec{
+		Name:  "share",
+		Usage: "[flags] <repo>",
+		Desc: `Shares a project's environment through a temporary link, to pair on it.
+
+The link goes through a tunnel: a cloudflared or ngrok quick tunnel, or a
+self-hosted ssh:// relay. It holds a secret token, so anyone with the link has
+the same access to the environment as you, until it expires or sail share is
+interrupted.
+
+With --guest, the guest gets a code-server of their own in the environment,
+so you share the files and processes but not open editors, settings or
+terminals. It's stopped once sharing stops, its editor state is kept for the
+next guest until the container is recreated.
+
+With --read-only, only the project's files can be browsed, without the editor
+or a terminal. Dotfiles such as .git and .env are hidden, and symlinks out of the
+project aren't followed.`,
+	}
+}
+
+func (c *sharecmd) RegisterFlags(fl *flag.FlagSet) {
+	fl.DurationVar(&c.expire, "expire", time.Hour, "How long the link works for.")
+	fl.BoolVar(&c.readOnly, "read-only", false, "Only share a read-only view of the project's files.")
+	fl.BoolVar(&c.guest, "guest", false, "Give the guest their own code-server, with separate editor state.")
+	fl.StringVar(&c.tunnel, "tunnel", tunnelCloudflared, "Tunnel to share through: cloudflared, ngrok or ssh://[user@]host[:port] of a relay.")
+}
+
+func (c *sharecmd) Run(fl *flag.FlagSet) {
+	if c.expire <= 0 {
+		xlog.Fatal("--expire must be positive")
+	}
+	if c.guest && c.readOnly {
+		xlog.Fatal("--guest and --read-only can't be used together")
+	}
+	_, _, err := tunnelCommand(c.tunnel, "")
+	if err != nil {
+		xlog.Fatal("%v", err)
+	}
+
+	proj := c.gf.project(schemaPrefs{}, fl)
+	c.gf.ensureDockerDaemon()
+
+	exists, err := proj.cntExists()
+	if err != nil {
+		xlog.Fatal("%v", err)
+	}
+	if !exists {
+		xlog.Fatal("%v doesn't exist, create it with sail run", proj.pathName())
+	}
+
+	var next http.Handler
+	switch {
+	case c.readOnly:
+		next = http.FileServer(projectFileSystem{root: proj.localDir()})
+	case c.guest:
+		e, err := startGuest(proj.cntName())
+		if err != nil {
+			xlog.Fatal("%v", err)
+		}
+		defer func() {
+			err := stopGuest(proj.cntName())
+			if err != nil {
+				xlog.Error("%v", err)
+			}
+		}()
+		rp := httputil.NewSingleHostReverseProxy(&url.URL{Scheme: "http", Host: e.host()})
+		rp.Transport = e.transport
+		next = rp
+	default:
+		u, err := proj.proxyURL()
+		if err != nil {
+			xlog.Fatal("%v", err)
+		}
+		resp, err := http.Get(u + "/sail/api/v1/healthz")
+		if err != nil {
+			xlog.Fatal("proxy of %v isn't running, recreate the project with sail run: %v", proj.pathName(), err)
+		}
+		resp.Body.Close()
+
+		target, err := url.Parse(u)
+		if err != nil {
+			xlog.Fatal("invalid proxy URL %q: %v", u, err)
+		}
+		next = httputil.NewSingleHostReverseProxy(target)
+	}
+
+	err = c.share(proj, next)
+	if err != nil {
+		// Stop the guest code-server before exiting.
+		if c.guest {
+			stopGuest(proj.cntName())
+		}
+		xlog.Fatal("%v", err)
+	}
+}
+
+// share shares next through the tunnel until the link expires or sail share
+// is interrupted.
+func (c *sharecmd) share(proj *project, next http.Handler) error {
+	token := randstr.Make(32)
+	expires := time.Now().Add(c.expire)
+	tlsConfig, fingerprint, err := tunnelTLS(c.tunnel, expires)
+	if err != nil {
+		return err
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return xerrors.Errorf("failed to listen: %w", err)
+	}
+	defer l.Close()
+	var sl net.Listener = l
+	if tlsConfig != nil {
+		sl = tls.NewListener(l, tlsConfig)
+	}
+	go http.Serve(sl, &shareServer{
+		token:   token,
+		expires: expires,
+		next:    next,
+		log:     xlog.With(xlog.Fields{"project": proj.pathName()}),
+	})
+
+	ctx, cancel := withInterrupt(context.Background())
+	defer cancel()
+	ctx, cancel = context.WithDeadline(ctx, expires)
+	defer cancel()
+
+	t, err := startTunnel(ctx, c.tunnel, l.Addr().String())
+	if err != nil {
+		return xerrors.Errorf("failed to start tunnel: %w", err)
+	}
+
+	emitEvent(eventShared, proj.cntName(), map[string]string{
+		"expires":   expires.Format(time.RFC3339),
+		"read_only": fmt.Sprint(c.readOnly),
+		"guest":     fmt.Sprint(c.guest),
+		"tunnel":    strings.SplitN(c.tunnel, "://", 2)[0],
+	})
+	xlog.Success("sharing %v until %v, interrupt to stop sharing", proj.pathName(), expires.Format(time.Kitchen))
+	if fingerprint != "" {
+		xlog.Info("the link's certificate is self-signed, its SHA-256 fingerprint is %v", fingerprint)
+	}
+	fmt.Fprintln(os.Stdout, shareLink(t.url, token))
+
+	select {
+	case <-ctx.Done():
+		xlog.Info("stopped sharing %v", proj.pathName())
+		return nil
+	case <-t.done:
+		return xerrors.New("tunnel exited, the link no longer works")
+	}
+}
+
+// shareLink returns the link to the tunnel at tunnelURL holding token.
+func shareLink(tunnelURL, token string) string {
+	return strings.TrimSuffix(tunnelURL, "/") + "/?" + url.Values{shareTokenParam: {token}}.Encode()
+}
+
+// shareServer guards the environment shared by sail share.
+type shareServer struct {
+	token   string
+	expires time.Time
+	next    http.Handler
+	log     *xlog.Logger
+}
+
+func (s *shareServer) validToken(token string) bool {
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) == 1
+}
+
+func (s *shareServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if time.Now().After(s.expires) {
+		http.Error(w, "this link expired", http.StatusGone)
+		return
+	}
+	// Guests can't restart or rebuild the environment.
+	if strings.HasPrefix(r.URL.Path, "/sail/api/") {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	if token := r.URL.Query().Get(shareTokenParam); token != "" {
+		if !s.validToken(token) {
+			http.Error(w, "this link is invalid", http.StatusUnauthorized)
+			return
+		}
+		s.log.Info("link opened from %v", r.Header.Get("User-Agent"))
+		http.SetCookie(w, &http.Cookie{
+			Name:     shareCookie,
+			Value:    token,
+			Path:     "/",
+			Expires:  s.expires,
+			Secure:   true,
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+		// The token is taken out of the URL, so it doesn't end up in the
+		// guest's history or in Referer headers.
+		u := *r.URL
+		q := u.Query()
+		q.Del(shareTokenParam)
+		u.RawQuery = q.Encode()
+		http.Redirect(w, r, u.RequestURI(), http.StatusFound)
+		return
+	}
+
+	cookie, err := r.Cookie(shareCookie)
+	if err != nil || !s.validToken(cookie.Value) {
+		http.Error(w, "this link is invalid", http.StatusUnauthorized)
+		return
+	}
+	s.next.ServeHTTP(w, r)
+}
+
+// projectFileSystem serves the files of the project at root, without its
+// dotfiles, and without following symlinks out of it.
+type projectFileSystem struct {
+	root string
+}
+
+func (fs projectFileSystem) Open(name string) (http.File, error) {
+	name = path.Clean("/" + name)
+	for _, elem := range strings.Split(name, "/") {
+		if strings.HasPrefix(elem, ".") {
+			return nil, os.ErrNotExist
+		}
+	}
+
+	root, err := filepath.EvalSymlinks(fs.root)
+	if err != nil {
+		return nil, err
+	}
+	resolved, err := filepath.EvalSymlinks(filepath.Join(root, filepath.FromSlash(name)))
+	if err != nil {
+		return nil, err
+	}
+	if resolved != root && !strings.HasPrefix(resolved, root+string(filepath.Separator)) {
+		return nil, os.ErrNotExist
+	}
+
+	f, err := http.Dir(root).Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return hiddenFile{f}, nil
+}
+
+// hiddenFile hides the dotfiles of a directory's listing.
+type hiddenFile struct {
+	http.File
+}
+
+func (f hiddenFile) Readdir(n int) ([]os.FileInfo, error) {
+	fis, err := f.File.Readdir(n)
+	visible := fis[:0]
+	for _, fi := range fis {
+		if !strings.HasPrefix(fi.Name(), ".") {
+			visible = append(visible, fi)
+		}
+	}
+	return visible, err
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.coder.com/sail/internal/xlog"
+)
+
+func Test_shareServer(t *testing.T) {
+	s := &shareServer{
+		token:   "secret",
+		expires: time.Now().Add(time.Hour),
+		next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("environment"))
+		}),
+		log: xlog.With(nil),
+	}
+	serve := func(r *http.Request) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, r)
+		return rec
+	}
+
+	link, err := url.Parse(shareLink("https://pair.trycloudflare.com/", "secret"))
+	require.NoError(t, err)
+	rec := serve(httptest.NewRequest(http.MethodGet, link.RequestURI()+"&folder=/home/user/sail", nil))
+	assert.Equal(t, http.StatusFound, rec.Code)
+	assert.Equal(t, "/?folder=%2Fhome%2Fuser%2Fsail", rec.Header().Get("Location"))
+	cookies := rec.Result().Cookies()
+	require.Len(t, cookies, 1)
+	assert.Equal(t, shareCookie, cookies[0].Name)
+	assert.True(t, cookies[0].Secure)
+	assert.True(t, cookies[0].HttpOnly)
+
+	r := httptest.NewRequest(http.MethodGet, "/static/main.js", nil)
+	assert.Equal(t, http.StatusUnauthorized, serve(r).Code)
+	r.AddCookie(cookies[0])
+	rec = serve(r)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "environment", rec.Body.String())
+
+	r = httptest.NewRequest(http.MethodPost, "/sail/api/v1/restart", nil)
+	r.AddCookie(cookies[0])
+	assert.Equal(t, http.StatusForbidden, serve(r).Code)
+
+	assert.Equal(t, http.StatusUnauthorized, serve(httptest.NewRequest(http.MethodGet, "/?"+shareTokenParam+"=guess", nil)).Code)
+
+	s.expires = time.Now().Add(-time.Second)
+	r = httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(cookies[0])
+	assert.Equal(t, http.StatusGone, serve(r).Code)
+}
+
+func Test_projectFileSystem(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sail-share")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	root := filepath.Join(dir, "project")
+	require.NoError(t, os.MkdirAll(filepath.Join(root, ".git"), 0750))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(root, "main.go"), []byte("package main"), 0640))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(root, ".env"), []byte("TOKEN=x"), 0640))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "secret"), []byte("x"), 0640))
+	require.NoError(t, os.Symlink(filepath.Join(dir, "secret"), filepath.Join(root, "secret")))
+	require.NoError(t, os.Symlink("main.go", filepath.Join(root, "link.go")))
+
+	fs := projectFileSystem{root: root}
+	for _, name := range []string{"/main.go", "/link.go", "/"} {
+		f, err := fs.Open(name)
+		if assert.NoError(t, err, name) {
+			f.Close()
+		}
+	}
+	for _, name := range []string{"/.env", "/.git/config", "/secret", "/../secret"} {
+		_, err := fs.Open(name)
+		assert.Error(t, err, name)
+	}
+
+	f, err := fs.Open("/")
+	require.NoError(t, err)
+	defer f.Close()
+	fis, err := f.Readdir(-1)
+	require.NoError(t, err)
+	var names []string
+	for _, fi := range fis {
+		names = append(names, fi.Name())
+	}
+	assert.ElementsMatch(t, []string{"main.go", "link.go", "secret"}, names)
+}
+
+func Test_tunnelTLS(t *testing.T) {
+	expires := time.Now().Add(time.Hour)
+	conf, fingerprint, err := tunnelTLS(tunnelCloudflared, expires)
+	require.NoError(t, err)
+	assert.Nil(t, conf)
+	assert.Empty(t, fingerprint)
+
+	conf, fingerprint, err = tunnelTLS("ssh://me@relay.example.com:2222", expires)
+	require.NoError(t, err)
+	require.Len(t, conf.Certificates, 1)
+	cert, err := x509.ParseCertificate(conf.Certificates[0].Certificate[0])
+	require.NoError(t, err)
+	assert.Equal(t, []string{"relay.example.com"}, cert.DNSNames)
+	assert.Equal(t, expires.Unix(), cert.NotAfter.Unix())
+	sum := sha256.Sum256(cert.Raw)
+	assert.Equal(t, strings.ToUpper(hex.EncodeToString(sum[:2])), strings.Replace(fingerprint[:5], ":", "", 1))
+
+	conf, _, err = tunnelTLS("ssh://10.0.0.1", expires)
+	require.NoError(t, err)
+	cert, err = x509.ParseCertificate(conf.Certificates[0].Certificate[0])
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.1", cert.IPAddresses[0].String())
+}
+
+func Test_tunnelCommand(t *testing.T) {
+	cmd, parse, err := tunnelCommand(tunnelCloudflared, "127.0.0.1:8000")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"cloudflared", "tunnel", "--no-autoupdate", "--url", "http://127.0.0.1:8000"}, cmd.Args)
+	assert.Equal(t, "https://pair-on-it.trycloudflare.com", parse("INF |  https://pair-on-it.trycloudflare.com  |"))
+	assert.Equal(t, "", parse("INF Starting tunnel"))
+
+	_, parse, err = tunnelCommand(tunnelNgrok, "127.0.0.1:8000")
+	require.NoError(t, err)
+	assert.Equal(t, "https://ab12.ngrok.io", parse(`{"lvl":"info","msg":"started tunnel","url":"https://ab12.ngrok.io"}`))
+	assert.Equal(t, "", parse(`{"lvl":"info","msg":"open config file"}`))
+	assert.Equal(t, "", parse(`{"lvl":"info","msg":"started tunnel","url":"http://ab12.ngrok.io"}`))
+
+	cmd, parse, err = tunnelCommand("ssh://me@relay.example.com:2222", "127.0.0.1:8000")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ssh", "-N", "-o", "ExitOnForwardFailure=yes", "-R", "0:127.0.0.1:8000", "-p", "2222", "me@relay.example.com"}, cmd.Args)
+	assert.Equal(t, "https://relay.example.com:41234", parse("Allocated port 41234 for remote forward to 127.0.0.1:8000"))
+
+	_, _, err = tunnelCommand("localtunnel", "127.0.0.1:8000")
+	assert.Error(t, err)
+	_, _, err = tunnelCommand("ssh://", "127.0.0.1:8000")
+	assert.Error(t, err)
+}
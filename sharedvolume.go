@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/client"
+	"golang.org/x/xerrors"
+
+	"go.coder.com/sail/internal/flock"
+	"go.coder.com/sail/internal/xlog"
+)
+
+// sharedVolumesLabel is an image label listing the shared volumes of the
+// config the image's environments mount, separated by commas.
+const sharedVolumesLabel = "shared_volumes"
+
+// sharedVolumeLabel marks the Docker volumes of shared volumes, with the
+// name of the shared volume as value.
+const sharedVolumeLabel = sailLabel + ".shared_volume"
+
+// sharedVolumePrefix prefixes the Docker volume of a shared volume.
+const sharedVolumePrefix = "sail-shared-"
+
+var sharedVolumeNameRx = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// checkSharedVolumes validates shared_volumes of the config.
+func checkSharedVolumes(vols map[string]string) error {
+	for name, target := range vols {
+		if !sharedVolumeNameRx.MatchString(name) {
+			return xerrors.Errorf("invalid shared volume name %q, must only contain letters, digits, _, . and -", name)
+		}
+		if !strings.HasPrefix(target, "/") && !strings.HasPrefix(target, "~/") {
+			return xerrors.Errorf("invalid path %q of shared volume %v, must be absolute or start with ~/", target, name)
+		}
+	}
+	return nil
+}
+
+// parseSharedVolumesLabel returns the names of the shared volumes in the
+// value of sharedVolumesLabel.
+func parseSharedVolumesLabel(v string) ([]string, error) {
+	var names []string
+	for _, name := range strings.Split(v, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !sharedVolumeNameRx.MatchString(name) {
+			return nil, xerrors.Errorf("invalid shared volume name %q", name)
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// sharedVolumeMounts returns the mounts of the shared volumes in vols the
+// image with labels opts into, with their targets resolved against home.
+// Volumes the config doesn't define are skipped with a warning.
+func sharedVolumeMounts(vols map[string]string, labels map[string]string, home string) ([]mount.Mount, error) {
+	names, err := parseSharedVolumesLabel(labels[sharedVolumesLabel])
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+
+	var mounts []mount.Mount
+	for _, name := range names {
+		target, ok := vols[name]
+		if !ok {
+			xlog.Warn("shared volume %v isn't in shared_volumes of the config, it isn't mounted", name)
+			continue
+		}
+		mounts = append(mounts, mount.Mount{
+			Type:   mount.TypeVolume,
+			Source: sharedVolumePrefix + name,
+			Target: resolvePath(home, target),
+		})
+	}
+	return mounts, nil
+}
+
+// mountSharedVolumes mounts the shared volumes image opts into, creating
+// them if they don't exist yet.
+func (r *runner) mountSharedVolumes(ctx context.Context, mounts []mount.Mount, image string, labels map[string]string) ([]mount.Mount, error) {
+	vols, err := sharedVolumeMounts(r.sharedVolumes, labels, r.guestHome())
+	if err != nil {
+		return nil, err
+	}
+	if r.dryRun {
+		return append(mounts, vols...), nil
+	}
+	for _, m := range vols {
+		err = ensureSharedVolume(ctx, m.Source, image)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return append(mounts, vols...), nil
+}
+
+// sharedVolumeLockPath is the lock of creating the Docker volume vol. The
+// volumes are shared by the users of the daemon, so it's in the temporary
+// directory rather than metaRoot.
+func sharedVolumeLockPath(vol string) string {
+	return filepath.Join(os.TempDir(), "sail-locks", vol+".lock")
+}
+
+// ensureSharedVolume creates the Docker volume vol of a shared volume if it
+// doesn't exist. Environments run as different users, so the new volume is
+// made writable by all of them with a container of image, which is run as
+// root. Creating the volume is locked, so it's only done once when several
+// environments are created at the same time.
+func ensureSharedVolume(ctx context.Context, vol, image string) error {
+	l, err := flock.NewShared(sharedVolumeLockPath(vol))
+	if err != nil {
+		return err
+	}
+	defer l.Unlock()
+
+	cli := dockerClient()
+	defer cli.Close()
+
+	_, err = cli.VolumeInspect(ctx, vol)
+	if err == nil {
+		return nil
+	}
+	if !client.IsErrNotFound(err) {
+		return xerrors.Errorf("failed to inspect volume %v: %w", vol, err)
+	}
+
+	xlog.Info("creating shared volume %v", vol)
+	_, err = cli.VolumeCreate(ctx, volume.VolumeCreateBody{
+		Name: vol,
+		Labels: map[string]string{
+			sharedVolumeLabel: strings.TrimPrefix(vol, sharedVolumePrefix),
+		},
+	})
+	if err != nil {
+		return xerrors.Errorf("failed to create volume %v: %w", vol, err)
+	}
+
+	err = chmodVolume(ctx, cli, vol, image)
+	if err != nil {
+		rmErr := cli.VolumeRemove(context.Background(), vol, false)
+		if rmErr != nil {
+			xlog.Error("failed to remove volume %v: %v", vol, rmErr)
+		}
+		return xerrors.Errorf("failed to set permissions of volume %v: %w", vol, err)
+	}
+	return nil
+}
+
+// chmodVolume makes the root of the Docker volume vol writable by all users,
+// with a container of image run as root.
+func chmodVolume(ctx context.Context, cli *client.Client, vol, image string) error {
+	cnt, err := cli.ContainerCreate(ctx, &container.Config{
+		Image:      image,
+		User:       "root",
+		Entrypoint: []string{"chmod"},
+		Cmd:        []string{"0777", "/volume"},
+	}, &container.HostConfig{
+		Mounts: []mount.Mount{{
+			Type:   mount.TypeVolume,
+			Source: vol,
+			Target: "/volume",
+		}},
+	}, nil, "")
+	if err != nil {
+		return xerrors.Errorf("failed to create container: %w", err)
+	}
+	defer func() {
+		err := cli.ContainerRemove(context.Background(), cnt.ID, types.ContainerRemoveOptions{Force: true})
+		if err != nil {
+			xlog.Error("failed to remove container %v: %v", cnt.ID, err)
+		}
+	}()
+
+	waitC, errC := cli.ContainerWait(ctx, cnt.ID, container.WaitConditionNextExit)
+	err = cli.ContainerStart(ctx, cnt.ID, types.ContainerStartOptions{})
+	if err != nil {
+		return xerrors.Errorf("failed to start container: %w", err)
+	}
+	select {
+	case res := <-waitC:
+		if res.Error != nil {
+			return xerrors.Errorf("failed to wait for container: %v", res.Error.Message)
+		}
+		if res.StatusCode != 0 {
+			return xerrors.Errorf("chmod exited with %v", res.StatusCode)
+		}
+		return nil
+	case err := <-errC:
+		return xerrors.Errorf("failed to wait for container: %w", err)
+	}
+}
+
+// containerSharedVolumes returns the shared volumes in the mounts of a
+// container, by name, with their targets.
+func containerSharedVolumes(mounts []types.MountPoint) map[string]string {
+	vols := make(map[string]string)
+	for _, m := range mounts {
+		if m.Type == mount.TypeVolume && strings.HasPrefix(m.Name, sharedVolumePrefix) {
+			vols[strings.TrimPrefix(m.Name, sharedVolumePrefix)] = m.Destination
+		}
+	}
+	return vols
+}
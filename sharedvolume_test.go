@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_sharedVolumeMounts(t *testing.T) {
+	vols := map[string]string{
+		"go-build": "~/.cache/go-build",
+		"npm":      "/npm-cache",
+	}
+	mounts, err := sharedVolumeMounts(vols, map[string]string{sharedVolumesLabel: "npm, go-build, cargo"}, "/home/user")
+	require.NoError(t, err)
+	assert.Equal(t, []mount.Mount{
+		{Type: mount.TypeVolume, Source: "sail-shared-go-build", Target: "/home/user/.cache/go-build"},
+		{Type: mount.TypeVolume, Source: "sail-shared-npm", Target: "/npm-cache"},
+	}, mounts)
+
+	mounts, err = sharedVolumeMounts(vols, nil, "/home/user")
+	require.NoError(t, err)
+	assert.Empty(t, mounts)
+
+	_, err = sharedVolumeMounts(vols, map[string]string{sharedVolumesLabel: "go build"}, "/home/user")
+	require.Error(t, err)
+}
+
+func Test_checkSharedVolumes(t *testing.T) {
+	require.NoError(t, checkSharedVolumes(map[string]string{"go-build": "~/.cache/go-build"}))
+	require.Error(t, checkSharedVolumes(map[string]string{"go/build": "/cache"}))
+	require.Error(t, checkSharedVolumes(map[string]string{"go-build": "cache"}))
+}
+
+func Test_containerSharedVolumes(t *testing.T) {
+	vols := containerSharedVolumes([]types.MountPoint{
+		{Type: mount.TypeVolume, Name: "sail-shared-go-build", Destination: "/home/user/.cache/go-build"},
+		{Type: mount.TypeVolume, Name: "sail-nix", Destination: "/nix"},
+		{Type: mount.TypeBind, Source: "/tmp", Destination: "/tmp"},
+	})
+	assert.Equal(t, map[string]string{"go-build": "/home/user/.cache/go-build"}, vols)
+}
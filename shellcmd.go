@@ -2,13 +2,14 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"flag"
 	"os"
 
 	"go.coder.com/cli"
-	"go.coder.com/flog"
 	"go.coder.com/sail/internal/dockutil"
 	"go.coder.com/sail/internal/xexec"
+	"go.coder.com/sail/internal/xlog"
 )
 
 type shellcmd struct {
@@ -25,14 +26,73 @@ func (c *shellcmd) Spec() cli.CommandSpec {
 
 func (c *shellcmd) Run(fl *flag.FlagSet) {
 	proj := c.gf.project(schemaPrefs{}, fl)
+
+	external, err := loadExternalEnv(proj.cntName())
+	if err != nil {
+		xlog.Fatal("%v", err)
+	}
+	if external != nil {
+		c.externalShell(proj.cntName(), *external)
+	}
 	c.gf.ensureDockerDaemon()
 
-	out, err := dockutil.FmtExec(proj.cntName(), "grep ^.*:.*:$(id -u): /etc/passwd | cut -d : -f 7-").CombinedOutput()
+	env, err := hookEnvFromContainer(context.Background(), proj.cntName())
+	if err != nil {
+		xlog.Fatal("%v", err)
+	}
+	home, err := cntGuestHome(proj.cntName())
+	if err != nil {
+		xlog.Fatal("%v", err)
+	}
+
+	// Windows containers have no passwd, their shell is PowerShell.
+	shell := "powershell"
+	if !env.windows {
+		out, err := dockutil.FmtExec(proj.cntName(), "grep ^.*:.*:$(id -u): /etc/passwd | cut -d : -f 7-").CombinedOutput()
+		if err != nil {
+			xlog.Fatal("failed to get default shell: %v\n%s", err, out)
+		}
+		shell = string(bytes.TrimSpace(out))
+	}
+
+	err = markUsed(proj.cntName())
+	if err != nil {
+		xlog.Error("failed to record project use: %v", err)
+	}
+
+	cmd := dockutil.ExecTTY(proj.cntName(), env.guestPath(home), shell)
+	xexec.Attach(cmd)
+	err = cmd.Run()
+	if err != nil {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// externalShell opens a shell in the container cntName of the external
+// backend that runs env.
+func (c *shellcmd) externalShell(cntName string, env externalEnv) {
+	b, err := env.backend()
 	if err != nil {
-		flog.Fatal("failed to get default shell: %v\n%s", err, out)
+		xlog.Fatal("%v", err)
+	}
+	_, running, err := b.status(context.Background(), cntName)
+	if err != nil {
+		xlog.Fatal("%v", err)
+	}
+	if !running {
+		xlog.Fatal("%v isn't running, start it with sail run", toSailName(cntName))
 	}
 
-	cmd := dockutil.ExecTTY(proj.cntName(), guestHomeDir, string(bytes.TrimSpace(out)))
+	err = markUsed(cntName)
+	if err != nil {
+		xlog.Error("failed to record project use: %v", err)
+	}
+
+	cmd, err := b.shell(cntName, env, guestHome(env.Labels))
+	if err != nil {
+		xlog.Fatal("%v", err)
+	}
 	xexec.Attach(cmd)
 	err = cmd.Run()
 	if err != nil {
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/docker/docker/api/types/mount"
+	"golang.org/x/xerrors"
+
+	"go.coder.com/sail/internal/dockutil"
+)
+
+// commitSigningLabel records that the container signs commits with the
+// host's keys, see configureSigning.
+const commitSigningLabel = sailLabel + ".commit_signing"
+
+// containerGPGAgentSocket is where the host's gpg-agent is mounted in the
+// container. gpg in the container finds it through a redirect at its own
+// agent socket, as the socket's path depends on the user.
+const containerGPGAgentSocket = "/run/sail-gpg-agent.sock"
+
+// signingConfigScript sets up git in the container to sign like the host,
+// with the format $1, the signing key $2 and commit.gpgsign $3. OpenPGP
+// keys are used through the host's agent, whose public keys are imported
+// from stdin. SSH keys are used through the forwarded ssh-agent.
+const signingConfigScript = `command -v git >/dev/null || exit 0
+if [ "$1" = openpgp ]; then
+	command -v gpg >/dev/null || { echo "gpg isn't installed"; exit 1; }
+	sock=$(gpgconf --list-dirs agent-socket) || exit 1
+	mkdir -p "$(dirname "$sock")" && chmod 700 "$(dirname "$sock")" || exit 1
+	printf '%%Assuan%%\nsocket=%s\n' "` + containerGPGAgentSocket + `" > "$sock" || exit 1
+	gpg --batch --quiet --import || exit 1
+else
+	git config --global gpg.format "$1" || exit 1
+fi
+if [ -n "$2" ] && ! git config --global user.signingkey >/dev/null; then
+	git config --global user.signingkey "$2"
+fi
+if [ -n "$3" ] && ! git config --global commit.gpgsign >/dev/null; then
+	git config --global commit.gpgsign "$3"
+fi`
+
+// signingFormat returns the format of the host's signing key for the
+// repository in dir, as git's gpg.format.
+func signingFormat(dir string) string {
+	format := hostGitConfig(dir, "gpg.format")
+	if format == "" {
+		return "openpgp"
+	}
+	return format
+}
+
+// hostGPGAgentSocket returns the host's gpg-agent socket for remote use,
+// which is restricted to signing and decrypting.
+func hostGPGAgentSocket() (string, error) {
+	out, err := exec.Command("gpgconf", "--list-dirs", "agent-extra-socket").Output()
+	if err != nil {
+		return "", xerrors.Errorf("failed to find the gpg-agent socket: %w", err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// sshSigningKey returns the SSH signing key of git's user.signingkey key as
+// a literal key, as the file it may name isn't in the container. Private
+// keys are replaced by their public keys, the agent holds the private ones.
+func sshSigningKey(key string) (string, error) {
+	if key == "" || strings.HasPrefix(key, "key::") || strings.HasPrefix(key, "ssh-") {
+		return key, nil
+	}
+	path := key
+	if strings.HasPrefix(path, "~/") {
+		path = filepath.Join(os.Getenv("HOME"), path[2:])
+	}
+	if !strings.HasSuffix(path, ".pub") {
+		path += ".pub"
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return "key::" + strings.TrimSpace(string(b)), nil
+}
+
+// mountGPGAgent mounts the host's gpg-agent socket, if the container signs
+// commits with the host's OpenPGP keys. Sockets are only shared from Linux
+// hosts whose daemon runs on them.
+func (r *runner) mountGPGAgent(mounts []mount.Mount) []mount.Mount {
+	if !r.commitSigning || signingFormat(r.projectLocalDir) != "openpgp" {
+		return mounts
+	}
+	if runtime.GOOS != "linux" || r.desktop || r.windows || !r.usesDocker() {
+		return mounts
+	}
+	sock, err := hostGPGAgentSocket()
+	if err != nil {
+		return mounts
+	}
+	return append(mounts, mount.Mount{
+		Type:   mount.TypeBind,
+		Source: sock,
+		Target: containerGPGAgentSocket,
+	})
+}
+
+// configureSigning sets up git in the container to sign commits with the
+// host's keys, as the host's git config does for the project, if
+// r.commitSigning.
+func (r *runner) configureSigning() error {
+	if !r.commitSigning || r.windows {
+		return nil
+	}
+	format := signingFormat(r.projectLocalDir)
+	key := hostGitConfig(r.projectLocalDir, "user.signingkey")
+	gpgsign := hostGitConfig(r.projectLocalDir, "commit.gpgsign")
+
+	var stdin []byte
+	switch format {
+	case "openpgp":
+		if runtime.GOOS != "linux" || r.desktop {
+			return xerrors.New("the gpg-agent is only forwarded from Linux hosts")
+		}
+		args := []string{"--export"}
+		if key != "" {
+			args = append(args, key)
+		}
+		var err error
+		stdin, err = exec.Command("gpg", args...).Output()
+		if err != nil {
+			return xerrors.Errorf("failed to export the public keys: %w", err)
+		}
+	case "ssh":
+		var err error
+		key, err = sshSigningKey(key)
+		if err != nil {
+			return xerrors.Errorf("failed to read the SSH signing key: %w", err)
+		}
+	default:
+		return xerrors.Errorf("signing with gpg.format %q isn't supported", format)
+	}
+
+	cmd := dockutil.Exec(r.cntName, "sh", "-c", signingConfigScript, "sh", format, key, gpgsign)
+	cmd.Stdin = bytes.NewReader(stdin)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return xerrors.Errorf("%s: %w", bytes.TrimSpace(out), err)
+	}
+	return nil
+}
+
+// pinentryProgram returns the pinentry-program of the gpg-agent.conf conf,
+// or "" if it doesn't set one.
+func pinentryProgram(conf []byte) string {
+	var program string
+	s := bufio.NewScanner(bytes.NewReader(conf))
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) >= 2 && fields[0] == "pinentry-program" {
+			program = strings.Join(fields[1:], " ")
+		}
+	}
+	return program
+}
+
+// checkCommitSigning checks that the host's keys can sign commits of
+// containers, if commit signing is configured. The host's gpg-agent asks
+// for passphrases of requests from containers, where there's no terminal,
+// so its pinentry must be graphical.
+func checkCommitSigning(conf config) string {
+	if !conf.CommitSigning {
+		return ""
+	}
+	home, _ := os.UserHomeDir()
+	if signingFormat(home) == "ssh" {
+		sock := os.Getenv("SSH_AUTH_SOCK")
+		if sock == "" {
+			return "SSH signing needs an ssh-agent, but SSH_AUTH_SOCK isn't set"
+		}
+		if _, err := os.Stat(sock); err != nil {
+			return fmt.Sprintf("the ssh-agent socket %v doesn't exist", sock)
+		}
+		return ""
+	}
+
+	sock, err := hostGPGAgentSocket()
+	if err != nil {
+		return err.Error()
+	}
+	fi, err := os.Stat(sock)
+	if err != nil || fi.Mode()&os.ModeSocket == 0 {
+		return fmt.Sprintf("the gpg-agent socket %v doesn't exist, start gpg-agent with gpgconf --launch gpg-agent", sock)
+	}
+
+	out, err := exec.Command("gpgconf", "--list-dirs", "homedir").Output()
+	if err != nil {
+		return fmt.Sprintf("failed to find the GnuPG home: %v", err)
+	}
+	agentConf, _ := ioutil.ReadFile(filepath.Join(strings.TrimSpace(string(out)), "gpg-agent.conf"))
+	program := pinentryProgram(agentConf)
+	if program == "" {
+		program, err = exec.LookPath("pinentry")
+		if err != nil {
+			return "no pinentry is installed, so gpg-agent can't ask for passphrases"
+		}
+	} else if _, err := os.Stat(program); err != nil {
+		return fmt.Sprintf("the pinentry-program %v doesn't exist", program)
+	}
+	if base := filepath.Base(program); strings.Contains(base, "curses") || strings.Contains(base, "tty") {
+		return fmt.Sprintf("the pinentry %v needs a terminal, which requests from containers don't have, set a graphical pinentry-program", program)
+	}
+	return ""
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_pinentryProgram(t *testing.T) {
+	conf := "default-cache-ttl 600\n# pinentry-program /usr/bin/pinentry-tty\npinentry-program /usr/bin/pinentry-gnome3\n"
+	assert.Equal(t, "/usr/bin/pinentry-gnome3", pinentryProgram([]byte(conf)))
+	assert.Equal(t, "", pinentryProgram([]byte("enable-ssh-support\n")))
+}
+
+func Test_sshSigningKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sail-signing")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	pub := "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIA user@host"
+	err = ioutil.WriteFile(filepath.Join(dir, "id_ed25519.pub"), []byte(pub+"\n"), 0644)
+	require.NoError(t, err)
+
+	for _, key := range []string{filepath.Join(dir, "id_ed25519"), filepath.Join(dir, "id_ed25519.pub")} {
+		lit, err := sshSigningKey(key)
+		require.NoError(t, err)
+		assert.Equal(t, "key::"+pub, lit)
+	}
+
+	// Literal keys are kept.
+	lit, err := sshSigningKey("key::" + pub)
+	require.NoError(t, err)
+	assert.Equal(t, "key::"+pub, lit)
+
+	_, err = sshSigningKey(filepath.Join(dir, "id_rsa"))
+	require.Error(t, err)
+}
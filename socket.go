@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"github.com/docker/docker/api/types/mount"
+	"golang.org/x/xerrors"
+)
+
+// codeServerSocketLabel holds the host path of the unix socket code-server
+// listens on. Containers without it have code-server listen on a port.
+const codeServerSocketLabel = sailLabel + ".code_server_socket"
+
+// containerSocketDir is where the host directory holding code-server's socket
+// is mounted in the container.
+const containerSocketDir = "/run/sail"
+
+// codeServerSocketName is the name of code-server's socket in its directory.
+const codeServerSocketName = "code-server.sock"
+
+// maxSocketPath is the longest path a unix socket can be bound or dialed at.
+// It's 108 bytes including the terminating null byte on Linux, and 104 on
+// macOS.
+const maxSocketPath = 103
+
+// socketDir returns the host directory holding the code-server socket of the
+// container cntName.
+func socketDir(cntName string) string {
+	return filepath.Join(metaRoot(), cntName, "run")
+}
+
+// listenOnSocket has code-server listen on a unix socket mounted from the host,
+// so the proxy reaches it whichever network the container is on, without a
+// port to find or publish.
+//
+// Sockets don't cross the VM of Docker Desktop, so there code-server's port
+// is published instead. So it is if the socket's path is too long to dial,
+// and if the container isn't run by Docker.
+func (r *runner) listenOnSocket() {
+	r.socket = ""
+	if runtime.GOOS != "linux" || r.desktop || r.windows || !r.usesDocker() {
+		return
+	}
+	path := filepath.Join(socketDir(r.projectCntName()), codeServerSocketName)
+	if len(path) > maxSocketPath {
+		return
+	}
+	r.socket = path
+}
+
+// mountSocketDir mounts the directory of code-server's socket, if it
+// listens on one.
+func (r *runner) mountSocketDir(mounts []mount.Mount) ([]mount.Mount, error) {
+	if r.socket == "" {
+		return mounts, nil
+	}
+
+	dir := filepath.Dir(r.socket)
+	if !r.dryRun {
+		err := os.MkdirAll(dir, 0750)
+		if err != nil {
+			return nil, err
+		}
+		// The container's user may not be the host user, it must still be
+		// able to create the socket. The directory is only reachable through
+		// the container's sail directory, which is the host user's.
+		err = os.Chmod(dir, 0777)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	r.relabelOwn(containerSocketDir)
+	return append(mounts, mount.Mount{
+		Type:   "bind",
+		Source: dir,
+		Target: containerSocketDir,
+	}), nil
+}
+
+// codeServerEndpoint is where the host reaches the code-server of a container.
+type codeServerEndpoint struct {
+	// socket is the host path of code-server's unix socket.
+	socket string
+	// port is code-server's port on the host's loopback, if it doesn't
+	// listen on a socket.
+	port string
+
+	transport http.RoundTripper
+}
+
+func newSocketEndpoint(socket string) *codeServerEndpoint {
+	return &codeServerEndpoint{
+		socket: socket,
+		transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		},
+	}
+}
+
+func newPortEndpoint(port string) *codeServerEndpoint {
+	return &codeServerEndpoint{
+		port:      port,
+		transport: http.DefaultTransport,
+	}
+}
+
+// host is the host of URLs to the endpoint.
+func (e *codeServerEndpoint) host() string {
+	if e.socket != "" {
+		// Only the socket is dialed, the host just has to be valid.
+		return "localhost"
+	}
+	return "localhost:" + e.port
+}
+
+// String describes the endpoint for messages.
+func (e *codeServerEndpoint) String() string {
+	if e.socket != "" {
+		return "unix:" + e.socket
+	}
+	return e.host()
+}
+
+// online checks that code-server responds at the endpoint.
+func (e *codeServerEndpoint) online(ctx context.Context) error {
+	req, err := http.NewRequest(http.MethodGet, "http://"+e.host(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := e.transport.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// codeServerEndpointOf finds where the host reaches the code-server of the
+// container cntName.
+//
+// It will retry for 5 seconds if code-server doesn't respond, in case it's
+// still starting up.
+func codeServerEndpointOf(cntName string) (*codeServerEndpoint, error) {
+	cli := dockerClient()
+	defer cli.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	cnt, err := inspectContainer(ctx, cli, cntName)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to inspect %v: %w", cntName, err)
+	}
+	socket := cnt.Config.Labels[codeServerSocketLabel]
+	if socket == "" {
+		port, err := codeServerPort(cntName)
+		if err != nil {
+			return nil, err
+		}
+		return newPortEndpoint(port), nil
+	}
+
+	e := newSocketEndpoint(socket)
+	for ctx.Err() == nil {
+		err = e.online(ctx)
+		if err == nil {
+			return e, nil
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+	return nil, xerrors.Errorf("failed to reach code-server at %v: %w", socket, err)
+}
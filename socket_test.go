@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_listenOnSocket(t *testing.T) {
+	r := &runner{cntName: "cdr_sail"}
+	r.listenOnSocket()
+	if runtime.GOOS != "linux" {
+		assert.Empty(t, r.socket)
+		return
+	}
+	assert.Equal(t, filepath.Join(socketDir("cdr_sail"), codeServerSocketName), r.socket)
+
+	r.cntName = strings.Repeat("a", maxSocketPath)
+	r.listenOnSocket()
+	assert.Empty(t, r.socket)
+}
+
+func Test_codeServerEndpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sail-socket")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, codeServerSocketName)
+	l, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("code-server"))
+	}))
+	srv.Listener = l
+	srv.Start()
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	e := newSocketEndpoint(socket)
+	assert.Equal(t, "unix:"+socket, e.String())
+	assert.NoError(t, e.online(ctx))
+
+	rec := httptest.NewRecorder()
+	codeServerProxy(rec, httptest.NewRequest(http.MethodGet, "/static/x", nil), e)
+	assert.Equal(t, "code-server", rec.Body.String())
+
+	srv.Close()
+	assert.Error(t, e.online(ctx))
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"go.coder.com/cli"
+	"go.coder.com/sail/internal/xlog"
+)
+
+type statuscmd struct {
+	gf *globalFlags
+
+	fix bool
+}
+
+func (c *statuscmd) Spec() cli.CommandSpec {
+	return cli.CommandSpec{
+		Name:  "status",
+		Usage: "[flags] <repo>",
+		Desc: `Shows whether a project's container drifted from what sail run would create now.
+
+The container's hat, image, mounts and environment variables are compared to
+the current config, hat, image and the state set with sail mount and sail env.
+With --fix, a drifted container is recreated with sail run --rebuild.`,
+	}
+}
+
+func (c *statuscmd) RegisterFlags(fl *flag.FlagSet) {
+	fl.BoolVar(&c.fix, "fix", false, "Recreate the container if it drifted.")
+}
+
+func (c *statuscmd) Run(fl *flag.FlagSet) {
+	proj := c.gf.project(schemaPrefs{}, fl)
+	c.gf.ensureDockerDaemon()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	cli := dockerClient()
+	defer cli.Close()
+
+	cnt, err := inspectContainer(ctx, cli, proj.cntName())
+	if err != nil {
+		xlog.Fatal("%v doesn't exist, create it with sail run: %v", proj.pathName(), err)
+	}
+
+	drift := containerDrift(proj, cnt, new(runcmd).hatBuilder(proj, ""))
+
+	env, err := loadEnv(proj.cntName())
+	if err != nil {
+		xlog.Fatal("failed to load environment: %v", err)
+	}
+	drift = append(drift, envDrift(cnt, env)...)
+
+	old, _, err := cli.ImageInspectWithRaw(ctx, cnt.Image)
+	if err != nil {
+		xlog.Fatal("failed to inspect the image of %v: %v", proj.pathName(), err)
+	}
+	img, _, err := cli.ImageInspectWithRaw(ctx, cnt.Config.Image)
+	if err == nil {
+		drift = append(drift, imageDrift(cnt, old, img)...)
+	} else {
+		drift = append(drift, fmt.Sprintf("%v no longer exists", cnt.Config.Image))
+	}
+
+	fmt.Printf("%v: %v, created from %v (%v)\n", proj.pathName(), cnt.State.Status, cnt.Config.Image, shortID(cnt.Image))
+	if cnt.Config.Labels[guiLabel] == guiVNC {
+		ports, err := loadPorts(proj.cntName())
+		password, perr := loadGUIPassword(proj.cntName())
+		if err == nil && perr == nil && ports[guiPortName] != "" {
+			fmt.Printf("GUI applications are shown at %v\n", guiURL(ports[guiPortName], password))
+		}
+	}
+	if len(drift) == 0 {
+		fmt.Println("in sync with its config")
+		return
+	}
+	fmt.Println("drifted:")
+	for _, d := range drift {
+		fmt.Printf("\t%v\n", d)
+	}
+
+	if !c.fix {
+		xlog.Info("run sail status --fix %v to recreate it", proj.pathName())
+		return
+	}
+
+	args := []string{"--config", c.gf.configPath}
+	if c.gf.verbose {
+		args = append(args, "-v")
+	}
+	args = append(args, "run", "--rebuild", "--no-open")
+	args = append(args, runArgs(projectInfo{name: proj.pathName(), remote: cnt.Config.Labels[repoLabel]})...)
+	cmd := sailCmd(args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	err = cmd.Run()
+	if err != nil {
+		xlog.Fatal("failed to recreate %v: %v", proj.pathName(), err)
+	}
+}
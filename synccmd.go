@@ -0,0 +1,540 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"flag"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"go.coder.com/cli"
+	"go.coder.com/sail/internal/xexec"
+	"go.coder.com/sail/internal/xlog"
+)
+
+const (
+	// syncObject is the name of the archive of the state under sync_url.
+	syncObject = "sail-state.tar.gz"
+	// syncManifest is the name of the list of environments in the archive.
+	syncManifest = "environments.json"
+)
+
+// syncedFiles are the files of an environment's metadata directory that are
+// synced. Others, such as sockets and ports, only make sense on the host.
+// Environment variables often hold secrets, so they're only synced with
+// --env, see syncFiles.
+var syncedFiles = []string{"globalStorage", "mounts"}
+
+// syncFiles returns the synced files, along with the environment variables
+// if env.
+func syncFiles(env bool) []string {
+	if !env {
+		return syncedFiles
+	}
+	return append(append([]string(nil), syncedFiles...), envFile)
+}
+
+// filterSyncHashes returns the digests of hashes, by their path in the sync
+// archive, of the files in files.
+func filterSyncHashes(hashes map[string]string, files []string) map[string]string {
+	if hashes == nil {
+		return nil
+	}
+	filtered := make(map[string]string, len(hashes))
+	for p, h := range hashes {
+		toks := strings.SplitN(p, "/", 3)
+		if len(toks) >= 2 && stringsContain(files, toks[1]) {
+			filtered[p] = h
+		}
+	}
+	return filtered
+}
+
+// syncedEnv is an environment in the manifest of the synced state.
+type syncedEnv struct {
+	Name string `json:"name"`
+	// Remote is the URI the project was cloned from, if any.
+	Remote    string `json:"remote,omitempty"`
+	Container string `json:"container"`
+}
+
+// validateSyncURL checks that u is an s3:// or gs:// URL.
+func validateSyncURL(u string) error {
+	pu, err := url.Parse(u)
+	if err != nil || (pu.Scheme != "s3" && pu.Scheme != "gs") || pu.Host == "" {
+		return xerrors.Errorf("invalid sync_url %q, must be of form s3://<bucket>[/<prefix>] or gs://<bucket>[/<prefix>]", u)
+	}
+	return nil
+}
+
+// syncCopyCmd returns the command copying between a local path and an
+// object in the store of sync_url, with the store's CLI.
+func syncCopyCmd(ctx context.Context, syncURL, src, dst string) *exec.Cmd {
+	if strings.HasPrefix(syncURL, "gs://") {
+		return exec.CommandContext(ctx, "gsutil", "-q", "cp", src, dst)
+	}
+	return exec.CommandContext(ctx, "aws", "s3", "cp", "--only-show-errors", src, dst)
+}
+
+// syncObjectURL returns the URL of the archive under syncURL.
+func syncObjectURL(syncURL string) string {
+	return strings.TrimSuffix(syncURL, "/") + "/" + syncObject
+}
+
+type synccmd struct {
+	gf *globalFlags
+}
+
+func (c *synccmd) Spec() cli.CommandSpec {
+	return cli.CommandSpec{
+		Name:  "sync",
+		Usage: "[push|pull|status]",
+		Desc: `Syncs the editor state and environment list to an object store.
+
+sail sync push uploads the globalStorage and mounts of all environments, along
+with the list of environments, to sync_url of the config. sail sync pull
+restores them on another machine, or after reinstalling. sail sync status shows
+what changed on either side since the last of them. Environment variables set
+with sail env often hold secrets, so they're only synced with --env.
+
+Objects are copied with the aws CLI for s3:// URLs, and gsutil for gs:// URLs,
+so they use their credentials.`,
+	}
+}
+
+func (c *synccmd) Run(fl *flag.FlagSet) {
+	fl.Usage()
+	os.Exit(1)
+}
+
+func (c *synccmd) Subcommands() []cli.Command {
+	return []cli.Command{
+		&syncPushCmd{gf: c.gf},
+		&syncPullCmd{gf: c.gf},
+		&syncStatusCmd{gf: c.gf},
+	}
+}
+
+// syncURL returns sync_url of the config, exiting if it's unset.
+func (gf *globalFlags) syncURL() string {
+	u := gf.config().SyncURL
+	if u == "" {
+		xlog.Fatal("sync_url isn't set, see sync_url in %v", gf.configPath)
+	}
+	return u
+}
+
+type syncPushCmd struct {
+	gf *globalFlags
+
+	force bool
+	env   bool
+}
+
+func (c *syncPushCmd) Spec() cli.CommandSpec {
+	return cli.CommandSpec{
+		Name:  "push",
+		Usage: "[flags]",
+		Desc: `Uploads the state of all environments to sync_url, replacing the previous one.
+
+Pushing fails if another machine pushed changes since this one last synced,
+as they would be lost, unless --force is given.`,
+	}
+}
+
+func (c *syncPushCmd) RegisterFlags(fl *flag.FlagSet) {
+	fl.BoolVar(&c.force, "force", false, "Push even if it replaces changes of other machines.")
+	fl.BoolVar(&c.env, "env", false, "Push the environment variables set with sail env too, which may hold secrets.")
+}
+
+func (c *syncPushCmd) Run(fl *flag.FlagSet) {
+	syncURL := c.gf.syncURL()
+	c.gf.ensureDockerDaemon()
+
+	state, err := readSyncState(syncURL)
+	if err != nil {
+		xlog.Fatal("%v", err)
+	}
+	infos, err := listProjects()
+	if err != nil {
+		xlog.Fatal("failed to list projects: %v", err)
+	}
+	envs := make([]syncedEnv, 0, len(infos))
+	cnts := make([]string, 0, len(infos))
+	for _, info := range infos {
+		envs = append(envs, syncedEnv{
+			Name:      info.name,
+			Remote:    info.remote,
+			Container: info.cntName,
+		})
+		cnts = append(cnts, info.cntName)
+	}
+	files := syncFiles(c.env)
+	local, err := localSyncHashes(metaRoot(), cnts, files)
+	if err != nil {
+		xlog.Fatal("failed to read state: %v", err)
+	}
+
+	// Without a previous sync, it's unknown which side changed.
+	if state.synced() && !c.force {
+		remote, err := remoteSyncHashes(syncURL)
+		if err != nil {
+			xlog.Fatal("%v\npush with --force to replace it anyway", err)
+		}
+		var lost []string
+		for _, s := range syncStatuses(filterSyncHashes(state.base(), files), local, filterSyncHashes(remote, files)) {
+			if len(s.remote) > 0 || len(s.conflicts) > 0 {
+				lost = append(lost, toSailName(s.container))
+			}
+		}
+		if len(lost) > 0 {
+			xlog.Fatal("%v changed on sync_url since the last sync, pull them first or push with --force to replace them", strings.Join(lost, ", "))
+		}
+	}
+
+	fi, err := ioutil.TempFile("", "sail-sync")
+	if err != nil {
+		xlog.Fatal("%v", err)
+	}
+	defer os.Remove(fi.Name())
+
+	err = writeSyncArchive(fi, metaRoot(), envs, files)
+	fi.Close()
+	if err != nil {
+		xlog.Fatal("failed to archive state: %v", err)
+	}
+
+	dst := syncObjectURL(syncURL)
+	cmd := syncCopyCmd(context.Background(), syncURL, fi.Name(), dst)
+	xexec.Attach(cmd)
+	err = cmd.Run()
+	if err != nil {
+		xlog.Fatal("failed to upload to %v: %v", dst, err)
+	}
+	state.LastPush = time.Now()
+	state.Files = local
+	err = saveSyncState(state)
+	if err != nil {
+		xlog.Error("failed to save the sync state: %v", err)
+	}
+	xlog.Success("pushed %v environments to %v", len(envs), dst)
+}
+
+type syncPullCmd struct {
+	gf *globalFlags
+
+	run   bool
+	force bool
+	env   bool
+}
+
+func (c *syncPullCmd) Spec() cli.CommandSpec {
+	return cli.CommandSpec{
+		Name:  "pull",
+		Usage: "[flags]",
+		Desc: `Restores the state of the environments from sync_url.
+
+The state of running environments isn't restored, stop them first. Environments
+that don't exist on this machine are listed, --run creates them with sail run.
+Pulling fails if environments changed since this machine last synced, as their
+changes would be lost, unless --force is given.`,
+	}
+}
+
+func (c *syncPullCmd) RegisterFlags(fl *flag.FlagSet) {
+	fl.BoolVar(&c.run, "run", false, "Create the environments that don't exist with sail run, without opening them.")
+	fl.BoolVar(&c.force, "force", false, "Pull even if it replaces changes that weren't pushed.")
+	fl.BoolVar(&c.env, "env", false, "Restore the environment variables too, if they were pushed with --env.")
+}
+
+func (c *syncPullCmd) Run(fl *flag.FlagSet) {
+	syncURL := c.gf.syncURL()
+	c.gf.ensureDockerDaemon()
+
+	infos, err := listProjects()
+	if err != nil {
+		xlog.Fatal("failed to list projects: %v", err)
+	}
+	existing := make(map[string]projectInfo)
+	var (
+		running []string
+		cnts    []string
+	)
+	for _, info := range infos {
+		existing[info.cntName] = info
+		cnts = append(cnts, info.cntName)
+		if info.running {
+			running = append(running, info.cntName)
+		}
+	}
+	state, err := readSyncState(syncURL)
+	if err != nil {
+		xlog.Fatal("%v", err)
+	}
+
+	archive, err := downloadSyncArchive(syncURL)
+	if err != nil {
+		xlog.Fatal("%v", err)
+	}
+	defer os.Remove(archive)
+
+	fi, err := os.Open(archive)
+	if err != nil {
+		xlog.Fatal("%v", err)
+	}
+	defer fi.Close()
+	files := syncFiles(c.env)
+	remote, err := archiveSyncHashes(fi)
+	if err != nil {
+		xlog.Fatal("failed to read %v: %v", archive, err)
+	}
+	remote = filterSyncHashes(remote, files)
+
+	// Without a previous sync, it's unknown which side changed.
+	if state.synced() && !c.force {
+		local, err := localSyncHashes(metaRoot(), cnts, files)
+		if err != nil {
+			xlog.Fatal("failed to read state: %v", err)
+		}
+		var lost []string
+		for _, s := range syncStatuses(filterSyncHashes(state.base(), files), local, remote) {
+			_, ok := existing[s.container]
+			// Running environments aren't restored anyway.
+			if !ok || stringsContain(running, s.container) {
+				continue
+			}
+			if len(s.local) > 0 || len(s.conflicts) > 0 {
+				lost = append(lost, toSailName(s.container))
+			}
+		}
+		if len(lost) > 0 {
+			xlog.Fatal("%v changed since the last sync, push them first or pull with --force to replace them", strings.Join(lost, ", "))
+		}
+	}
+
+	_, err = fi.Seek(0, io.SeekStart)
+	if err != nil {
+		xlog.Fatal("%v", err)
+	}
+	envs, err := extractSyncArchive(fi, metaRoot(), running, files)
+	if err != nil {
+		xlog.Fatal("failed to restore state: %v", err)
+	}
+	state.LastPull = time.Now()
+	state.Files = remote
+	err = saveSyncState(state)
+	if err != nil {
+		xlog.Error("failed to save the sync state: %v", err)
+	}
+
+	var failed []string
+	for _, env := range envs {
+		if _, ok := existing[env.Container]; ok {
+			continue
+		}
+		args := runArgs(projectInfo{name: env.Name, remote: env.Remote})
+		if !c.run {
+			xlog.Info("%v doesn't exist, create it with sail run %v", env.Name, strings.Join(args, " "))
+			continue
+		}
+		xlog.Info("creating %v", env.Name)
+		sargs := []string{"--config", c.gf.configPath}
+		if c.gf.verbose {
+			sargs = append(sargs, "-v")
+		}
+		cmd := sailCmd(append(append(sargs, "run", "--no-open"), args...)...)
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		err = cmd.Run()
+		if err != nil {
+			failed = append(failed, env.Name)
+		}
+	}
+	if len(failed) > 0 {
+		xlog.Fatal("failed to create %v", strings.Join(failed, ", "))
+	}
+	xlog.Success("pulled %v environments from %v", len(envs), syncObjectURL(syncURL))
+}
+
+// writeSyncArchive writes the files of envs in root, along with the manifest
+// listing envs, as a gzipped tarball to w. files are the synced files, see
+// syncFiles.
+func writeSyncArchive(w io.Writer, root string, envs []syncedEnv, files []string) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+
+	manifest, err := json.MarshalIndent(envs, "", "\t")
+	if err != nil {
+		return err
+	}
+	err = tw.WriteHeader(&tar.Header{
+		Name: syncManifest,
+		Mode: 0600,
+		Size: int64(len(manifest)),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = tw.Write(manifest)
+	if err != nil {
+		return err
+	}
+
+	for _, env := range envs {
+		for _, name := range files {
+			err = tarPath(tw, root, path.Join(env.Container, name))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	err = tw.Close()
+	if err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+// tarPath writes the file or directory at rel in root to tw, if it exists.
+func tarPath(tw *tar.Writer, root, rel string) error {
+	return filepath.Walk(filepath.Join(root, rel), func(p string, fi os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() && !fi.IsDir() {
+			// Sockets and links only make sense on the host.
+			return nil
+		}
+
+		hdr, err := tar.FileInfoHeader(fi, "")
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(name)
+		err = tw.WriteHeader(hdr)
+		if err != nil || fi.IsDir() {
+			return err
+		}
+
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+}
+
+// extractSyncArchive restores the files of the gzipped tarball r that are in
+// files, see syncFiles, into root, and returns the environments of its
+// manifest. The files of the containers in skip are left as they are, as
+// their editor is using them.
+func extractSyncArchive(r io.Reader, root string, skip, files []string) ([]syncedEnv, error) {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	tr := tar.NewReader(gr)
+
+	var (
+		envs     []syncedEnv
+		replaced = make(map[string]bool)
+		skipped  = make(map[string]bool)
+	)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if hdr.Name == syncManifest {
+			err = json.NewDecoder(tr).Decode(&envs)
+			if err != nil {
+				return nil, xerrors.Errorf("invalid %v: %w", syncManifest, err)
+			}
+			continue
+		}
+
+		// Only the synced files of environments are extracted, so the
+		// archive can't write anywhere else.
+		name := path.Clean(hdr.Name)
+		toks := strings.SplitN(name, "/", 3)
+		if len(toks) < 2 || toks[0] == "" || toks[0] == ".." || !stringsContain(syncFiles(true), toks[1]) {
+			return nil, xerrors.Errorf("unexpected file %q in archive", hdr.Name)
+		}
+		if !stringsContain(files, toks[1]) {
+			continue
+		}
+		cnt := toks[0]
+		if stringsContain(skip, cnt) {
+			if !skipped[cnt] {
+				xlog.Warn("%v is running, stop it to restore its state", cnt)
+				skipped[cnt] = true
+			}
+			continue
+		}
+
+		synced := filepath.Join(root, cnt, toks[1])
+		if !replaced[synced] {
+			err = os.RemoveAll(synced)
+			if err != nil {
+				return nil, err
+			}
+			replaced[synced] = true
+		}
+
+		dst := filepath.Join(root, filepath.FromSlash(name))
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(dst, 0750)
+		case tar.TypeReg:
+			err = extractFile(tr, dst, os.FileMode(hdr.Mode).Perm())
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return envs, nil
+}
+
+// extractFile writes the contents of r to path.
+func extractFile(r io.Reader, path string, perm os.FileMode) error {
+	err := os.MkdirAll(filepath.Dir(path), 0750)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_validateSyncURL(t *testing.T) {
+	assert.NoError(t, validateSyncURL("s3://bucket/sail"))
+	assert.NoError(t, validateSyncURL("gs://bucket"))
+	assert.Error(t, validateSyncURL("https://bucket/sail"))
+	assert.Error(t, validateSyncURL("s3:///sail"))
+	assert.Equal(t, "s3://bucket/sail/"+syncObject, syncObjectURL("s3://bucket/sail/"))
+}
+
+func Test_syncArchive(t *testing.T) {
+	src, err := ioutil.TempDir("", "sail-sync")
+	require.NoError(t, err)
+	defer os.RemoveAll(src)
+	dst, err := ioutil.TempDir("", "sail-sync")
+	require.NoError(t, err)
+	defer os.RemoveAll(dst)
+
+	write := func(root, name, content string) {
+		p := filepath.Join(root, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0750))
+		require.NoError(t, ioutil.WriteFile(p, []byte(content), 0640))
+	}
+	write(src, "cdr_sail/globalStorage/state.vscdb", "state")
+	write(src, "cdr_sail/env.json", `{"A":"1"}`)
+	write(src, "cdr_sail/ports.json", "[]")
+	write(src, "cdr_code-server/globalStorage/state.vscdb", "running")
+	write(dst, "cdr_sail/globalStorage/stale", "stale")
+	write(dst, "cdr_code-server/globalStorage/state.vscdb", "local")
+
+	envs := []syncedEnv{
+		{Name: "cdr/sail", Remote: "https://github.com/cdr/sail", Container: "cdr_sail"},
+		{Name: "cdr/code-server", Container: "cdr_code-server"},
+	}
+	var buf bytes.Buffer
+	require.NoError(t, writeSyncArchive(&buf, src, envs, syncFiles(true)))
+
+	// Environment variables are only restored with --env.
+	got, err := extractSyncArchive(bytes.NewReader(buf.Bytes()), dst, []string{"cdr_code-server"}, syncFiles(false))
+	require.NoError(t, err)
+	assert.Equal(t, envs, got)
+	_, err = os.Stat(filepath.Join(dst, "cdr_sail/env.json"))
+	assert.True(t, os.IsNotExist(err))
+
+	got, err = extractSyncArchive(&buf, dst, []string{"cdr_code-server"}, syncFiles(true))
+	require.NoError(t, err)
+	assert.Equal(t, envs, got)
+
+	read := func(name string) string {
+		b, err := ioutil.ReadFile(filepath.Join(dst, name))
+		require.NoError(t, err)
+		return string(b)
+	}
+	assert.Equal(t, "state", read("cdr_sail/globalStorage/state.vscdb"))
+	assert.Equal(t, `{"A":"1"}`, read("cdr_sail/env.json"))
+	assert.Equal(t, "local", read("cdr_code-server/globalStorage/state.vscdb"))
+	_, err = os.Stat(filepath.Join(dst, "cdr_sail/globalStorage/stale"))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(dst, "cdr_sail/ports.json"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func Test_syncArchiveUnexpectedFile(t *testing.T) {
+	dst, err := ioutil.TempDir("", "sail-sync")
+	require.NoError(t, err)
+	defer os.RemoveAll(dst)
+
+	for _, name := range []string{"../escape/globalStorage/x", "/globalStorage/x", "cdr_sail/sail.sock", "sail.toml"} {
+		var buf bytes.Buffer
+		gw := gzip.NewWriter(&buf)
+		tw := tar.NewWriter(gw)
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0600, Size: 1}))
+		_, err = tw.Write([]byte("x"))
+		require.NoError(t, err)
+		require.NoError(t, tw.Close())
+		require.NoError(t, gw.Close())
+
+		_, err = extractSyncArchive(&buf, dst, nil, syncFiles(true))
+		assert.Error(t, err, name)
+	}
+}
+
+func Test_syncHashes(t *testing.T) {
+	src, err := ioutil.TempDir("", "sail-sync")
+	require.NoError(t, err)
+	defer os.RemoveAll(src)
+
+	for name, content := range map[string]string{
+		"cdr_sail/globalStorage/state.vscdb": "state",
+		"cdr_sail/env.json":                  `{"A":"1"}`,
+		"cdr_sail/ports.json":                "[]",
+	} {
+		p := filepath.Join(src, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0750))
+		require.NoError(t, ioutil.WriteFile(p, []byte(content), 0640))
+	}
+
+	local, err := localSyncHashes(src, []string{"cdr_sail", "cdr_missing"}, syncFiles(false))
+	require.NoError(t, err)
+	assert.Len(t, local, 1)
+	local, err = localSyncHashes(src, []string{"cdr_sail", "cdr_missing"}, syncFiles(true))
+	require.NoError(t, err)
+	assert.Len(t, local, 2)
+
+	var buf bytes.Buffer
+	require.NoError(t, writeSyncArchive(&buf, src, []syncedEnv{{Name: "cdr/sail", Container: "cdr_sail"}}, syncFiles(true)))
+	remote, err := archiveSyncHashes(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, local, remote)
+	assert.Equal(t, map[string]string{"cdr_sail/globalStorage/state.vscdb": local["cdr_sail/globalStorage/state.vscdb"]}, filterSyncHashes(remote, syncFiles(false)))
+	assert.Nil(t, filterSyncHashes(nil, syncFiles(false)))
+}
+
+func Test_syncStatuses(t *testing.T) {
+	base := map[string]string{
+		"a_same/env.json":      "1",
+		"b_local/env.json":     "1",
+		"c_remote/env.json":    "1",
+		"d_conflict/env.json":  "1",
+		"e_deleted/env.json":   "1",
+		"f_bothsame/env.json":  "1",
+		"g_localboth/env.json": "1",
+		"g_localboth/mounts":   "1",
+	}
+	local := map[string]string{
+		"a_same/env.json":      "1",
+		"b_local/env.json":     "2",
+		"c_remote/env.json":    "1",
+		"d_conflict/env.json":  "2",
+		"f_bothsame/env.json":  "2",
+		"g_localboth/env.json": "2",
+		"g_localboth/mounts":   "1",
+		"h_new/env.json":       "1",
+	}
+	remote := map[string]string{
+		"a_same/env.json":      "1",
+		"b_local/env.json":     "1",
+		"c_remote/env.json":    "2",
+		"d_conflict/env.json":  "3",
+		"e_deleted/env.json":   "1",
+		"f_bothsame/env.json":  "2",
+		"g_localboth/env.json": "1",
+		"g_localboth/mounts":   "2",
+	}
+
+	got := make(map[string]string)
+	for _, s := range syncStatuses(base, local, remote) {
+		got[s.container] = s.String()
+	}
+	assert.Equal(t, map[string]string{
+		"a_same":      syncUpToDate,
+		"b_local":     syncLocal,
+		"c_remote":    syncRemote,
+		"d_conflict":  syncConflict,
+		"e_deleted":   syncLocal,
+		"f_bothsame":  syncUpToDate,
+		"g_localboth": syncBoth,
+		"h_new":       syncLocal,
+	}, got)
+
+	// Without a previous sync, every difference is a conflict.
+	for _, s := range syncStatuses(nil, local, remote) {
+		if s.container == "a_same" || s.container == "f_bothsame" {
+			assert.Equal(t, syncUpToDate, s.String())
+			continue
+		}
+		assert.Equal(t, syncConflict, s.String(), s.container)
+	}
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/docker/go-units"
+	"golang.org/x/xerrors"
+
+	"go.coder.com/cli"
+	"go.coder.com/sail/internal/xexec"
+	"go.coder.com/sail/internal/xlog"
+)
+
+// syncState is what this machine last pushed or pulled, which changes on
+// either side are compared to.
+type syncState struct {
+	// URL is the sync_url synced with. The state of other URLs isn't known.
+	URL      string    `json:"url"`
+	LastPush time.Time `json:"last_push,omitempty"`
+	LastPull time.Time `json:"last_pull,omitempty"`
+	// Files are the SHA-256 digests of the synced files, by their path in
+	// the archive.
+	Files map[string]string `json:"files"`
+}
+
+// syncStatePath is where the state of the last sync is kept.
+func syncStatePath() string {
+	return filepath.Join(metaRoot(), "sync.json")
+}
+
+// readSyncState returns the state of the last sync with syncURL, which is
+// empty if this machine never synced with it.
+func readSyncState(syncURL string) (syncState, error) {
+	b, err := ioutil.ReadFile(syncStatePath())
+	if os.IsNotExist(err) {
+		return syncState{URL: syncURL}, nil
+	}
+	if err != nil {
+		return syncState{}, err
+	}
+	var s syncState
+	err = json.Unmarshal(b, &s)
+	if err != nil {
+		return syncState{}, xerrors.Errorf("failed to decode %v: %w", syncStatePath(), err)
+	}
+	if s.URL != syncURL {
+		return syncState{URL: syncURL}, nil
+	}
+	return s, nil
+}
+
+func saveSyncState(s syncState) error {
+	b, err := json.MarshalIndent(s, "", "\t")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(metaRoot(), 0750)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(syncStatePath(), b, 0600)
+}
+
+// synced reports whether the state has files to compare changes to.
+func (s syncState) synced() bool {
+	return !s.LastPush.IsZero() || !s.LastPull.IsZero()
+}
+
+func hashReader(r io.Reader) (string, error) {
+	h := sha256.New()
+	_, err := io.Copy(h, r)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// localSyncHashes returns the digests of the files in files, see syncFiles,
+// of the containers in root, by their path in the archive.
+func localSyncHashes(root string, containers, files []string) (map[string]string, error) {
+	hashes := make(map[string]string)
+	for _, cnt := range containers {
+		for _, name := range files {
+			err := filepath.Walk(filepath.Join(root, cnt, name), func(p string, fi os.FileInfo, err error) error {
+				if os.IsNotExist(err) {
+					return nil
+				}
+				if err != nil || !fi.Mode().IsRegular() {
+					return err
+				}
+				rel, err := filepath.Rel(root, p)
+				if err != nil {
+					return err
+				}
+				f, err := os.Open(p)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				hashes[filepath.ToSlash(rel)], err = hashReader(f)
+				return err
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return hashes, nil
+}
+
+// archiveSyncHashes returns the digests of the synced files in the gzipped
+// sync archive r, by their path in it.
+func archiveSyncHashes(r io.Reader) (map[string]string, error) {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	tr := tar.NewReader(gr)
+
+	hashes := make(map[string]string)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return hashes, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Name == syncManifest || hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		hashes[path.Clean(hdr.Name)], err = hashReader(tr)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// The statuses of synced environments.
+const (
+	syncUpToDate = "up to date"
+	syncLocal    = "local changes"
+	syncRemote   = "remote changes"
+	syncBoth     = "local and remote changes"
+	syncConflict = "conflict"
+)
+
+// syncEnvStatus is how an environment's synced files differ between this
+// machine and sync_url.
+type syncEnvStatus struct {
+	container string
+	// local and remote are the files changed on either side since the last
+	// sync, which the other side doesn't have.
+	local  []string
+	remote []string
+	// conflicts are the files changed differently on both sides.
+	conflicts []string
+}
+
+func (s syncEnvStatus) String() string {
+	switch {
+	case len(s.conflicts) > 0:
+		return syncConflict
+	case len(s.local) > 0 && len(s.remote) > 0:
+		return syncBoth
+	case len(s.local) > 0:
+		return syncLocal
+	case len(s.remote) > 0:
+		return syncRemote
+	default:
+		return syncUpToDate
+	}
+}
+
+// syncStatuses compares the digests of the files of both sides to those of
+// the last sync, base, and returns the status of each environment, sorted by
+// container. Files that are the same on both sides aren't changes. Without a
+// base, every difference is a conflict.
+func syncStatuses(base, local, remote map[string]string) []syncEnvStatus {
+	var (
+		statuses = make(map[string]*syncEnvStatus)
+		paths    = make(map[string]struct{})
+	)
+	for _, m := range []map[string]string{base, local, remote} {
+		for p := range m {
+			paths[p] = struct{}{}
+			cnt := strings.SplitN(p, "/", 2)[0]
+			if _, ok := statuses[cnt]; !ok {
+				statuses[cnt] = &syncEnvStatus{container: cnt}
+			}
+		}
+	}
+	sorted := make([]string, 0, len(paths))
+	for p := range paths {
+		sorted = append(sorted, p)
+	}
+	sort.Strings(sorted)
+
+	for _, p := range sorted {
+		l, r := local[p], remote[p]
+		if l == r {
+			continue
+		}
+		s := statuses[strings.SplitN(p, "/", 2)[0]]
+		switch {
+		case base == nil:
+			s.conflicts = append(s.conflicts, p)
+		case r == base[p]:
+			s.local = append(s.local, p)
+		case l == base[p]:
+			s.remote = append(s.remote, p)
+		default:
+			s.conflicts = append(s.conflicts, p)
+		}
+	}
+
+	out := make([]syncEnvStatus, 0, len(statuses))
+	for _, s := range statuses {
+		out = append(out, *s)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].container < out[j].container })
+	return out
+}
+
+// downloadSyncArchive downloads the archive under syncURL to a temporary
+// file, which the caller removes.
+func downloadSyncArchive(syncURL string) (string, error) {
+	fi, err := ioutil.TempFile("", "sail-sync")
+	if err != nil {
+		return "", err
+	}
+	fi.Close()
+
+	src := syncObjectURL(syncURL)
+	cmd := syncCopyCmd(context.Background(), syncURL, src, fi.Name())
+	xexec.Attach(cmd)
+	err = cmd.Run()
+	if err != nil {
+		os.Remove(fi.Name())
+		return "", xerrors.Errorf("failed to download %v: %w", src, err)
+	}
+	return fi.Name(), nil
+}
+
+// remoteSyncHashes downloads the archive under syncURL and returns the
+// digests of its synced files.
+func remoteSyncHashes(syncURL string) (map[string]string, error) {
+	archive, err := downloadSyncArchive(syncURL)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(archive)
+
+	fi, err := os.Open(archive)
+	if err != nil {
+		return nil, err
+	}
+	defer fi.Close()
+	return archiveSyncHashes(fi)
+}
+
+// base returns the digests of the files of the last sync, or nil if there
+// was none.
+func (s syncState) base() map[string]string {
+	if !s.synced() {
+		return nil
+	}
+	if s.Files == nil {
+		return map[string]string{}
+	}
+	return s.Files
+}
+
+type syncStatusCmd struct {
+	gf *globalFlags
+
+	files bool
+	env   bool
+}
+
+func (c *syncStatusCmd) Spec() cli.CommandSpec {
+	return cli.CommandSpec{
+		Name:  "status",
+		Usage: "[flags]",
+		Desc: `Shows whether the state of the environments is in sync with sync_url.
+
+Each environment is compared to the state this machine last pushed or pulled,
+showing changes that weren't pushed, changes of other machines that weren't
+pulled, and conflicts, where both sides changed a file.`,
+	}
+}
+
+func (c *syncStatusCmd) RegisterFlags(fl *flag.FlagSet) {
+	fl.BoolVar(&c.files, "files", false, "List the changed files of each environment.")
+	fl.BoolVar(&c.env, "env", false, "Compare the environment variables set with sail env too.")
+}
+
+func (c *syncStatusCmd) Run(fl *flag.FlagSet) {
+	syncURL := c.gf.syncURL()
+	c.gf.ensureDockerDaemon()
+
+	state, err := readSyncState(syncURL)
+	if err != nil {
+		xlog.Fatal("%v", err)
+	}
+	infos, err := listProjects()
+	if err != nil {
+		xlog.Fatal("failed to list projects: %v", err)
+	}
+	cnts := make([]string, 0, len(infos))
+	for _, info := range infos {
+		cnts = append(cnts, info.cntName)
+	}
+	files := syncFiles(c.env)
+	local, err := localSyncHashes(metaRoot(), cnts, files)
+	if err != nil {
+		xlog.Fatal("failed to read state: %v", err)
+	}
+	remote, err := remoteSyncHashes(syncURL)
+	if err != nil {
+		xlog.Fatal("%v", err)
+	}
+	remote = filterSyncHashes(remote, files)
+
+	fmt.Printf("sync_url:  %v\n", syncURL)
+	fmt.Printf("last push: %v\n", syncTime(state.LastPush))
+	fmt.Printf("last pull: %v\n\n", syncTime(state.LastPull))
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(tw, "ENVIRONMENT\tSTATUS")
+	for _, s := range syncStatuses(filterSyncHashes(state.base(), files), local, remote) {
+		fmt.Fprintf(tw, "%v\t%v\n", toSailName(s.container), s)
+		if !c.files {
+			continue
+		}
+		for _, files := range []struct {
+			kind  string
+			paths []string
+		}{{"local", s.local}, {"remote", s.remote}, {"conflict", s.conflicts}} {
+			for _, p := range files.paths {
+				fmt.Fprintf(tw, "  %v\t%v\n", p, files.kind)
+			}
+		}
+	}
+	tw.Flush()
+	if !state.synced() {
+		fmt.Println("\nthis machine never synced with sync_url, so changes of either side can't be told apart")
+	}
+}
+
+// syncTime describes when a sync happened.
+func syncTime(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return fmt.Sprintf("%v (%v ago)", t.Local().Format("2006-01-02 15:04"), units.HumanDuration(time.Since(t)))
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/client"
+	"golang.org/x/xerrors"
+
+	"go.coder.com/cli"
+	"go.coder.com/sail/internal/randstr"
+	"go.coder.com/sail/internal/xlog"
+)
+
+// telemetryURL receives telemetry reports.
+// It can be overridden with $SAIL_TELEMETRY_URL.
+const telemetryURL = "https://sail.dev/api/v1/telemetry"
+
+// telemetrySendInterval is how often queued telemetry is sent.
+const telemetrySendInterval = time.Hour * 24
+
+// Types of telemetry events.
+const (
+	// telemetryCommand is recorded when a command is run.
+	telemetryCommand = "command"
+	// telemetryStartup is recorded when sail run brings up an environment,
+	// with how long it took.
+	telemetryStartup = "startup"
+	// telemetryError is recorded when sail run fails to bring up an
+	// environment, with the category of the error.
+	telemetryError = "error"
+)
+
+// telemetryEvent is a telemetry report.
+// It mustn't hold anything identifying, such as project names, paths, URLs
+// or error messages.
+type telemetryEvent struct {
+	Time time.Time `json:"time"`
+	// ID is a random ID of the installation, so that reports of the same
+	// installation can be grouped.
+	ID      string `json:"id"`
+	Version string `json:"version"`
+	OS      string `json:"os"`
+	Arch    string `json:"arch"`
+	Type    string `json:"type"`
+	Command string `json:"command"`
+	// DurationMS is set for startup events.
+	DurationMS int64 `json:"duration_ms,omitempty"`
+	// Category is set for error events.
+	Category string `json:"category,omitempty"`
+}
+
+// telemetryState is whether telemetry is enabled, as saved by sail telemetry.
+type telemetryState struct {
+	Enabled  bool      `json:"enabled"`
+	ID       string    `json:"id,omitempty"`
+	LastSent time.Time `json:"last_sent,omitempty"`
+}
+
+func telemetryDir() string {
+	return filepath.Join(metaRoot(), "telemetry")
+}
+
+func telemetryStatePath() string {
+	return filepath.Join(telemetryDir(), "state.json")
+}
+
+// telemetryQueuePath holds the events that haven't been sent yet.
+func telemetryQueuePath() string {
+	return filepath.Join(telemetryDir(), "queue.jsonl")
+}
+
+// loadTelemetryState loads the telemetry state. Telemetry is disabled unless
+// it was explicitly enabled.
+func loadTelemetryState() (telemetryState, error) {
+	var st telemetryState
+	b, err := ioutil.ReadFile(telemetryStatePath())
+	if os.IsNotExist(err) {
+		return st, nil
+	}
+	if err != nil {
+		return st, err
+	}
+	err = json.Unmarshal(b, &st)
+	if err != nil {
+		return st, xerrors.Errorf("failed to decode %v: %w", telemetryStatePath(), err)
+	}
+	return st, nil
+}
+
+func saveTelemetryState(st telemetryState) error {
+	b, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(telemetryDir(), 0750)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(telemetryStatePath(), b, 0640)
+}
+
+// setTelemetry enables or disables telemetry. Disabling it drops the events
+// that haven't been sent.
+func setTelemetry(enabled bool) error {
+	st, err := loadTelemetryState()
+	if err != nil {
+		return err
+	}
+	st.Enabled = enabled
+	if enabled && st.ID == "" {
+		st.ID = randstr.Make(16)
+	}
+	if enabled && st.LastSent.IsZero() {
+		// The first report is sent after a full interval, so there's time
+		// to inspect it.
+		st.LastSent = time.Now()
+	}
+	if !enabled {
+		err = os.Remove(telemetryQueuePath())
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return saveTelemetryState(st)
+}
+
+// recordTelemetry queues ev if telemetry is enabled, and starts sending the
+// queue in the background once it's due.
+// Telemetry never fails the operation, so errors are only logged.
+func recordTelemetry(ev telemetryEvent) {
+	st, err := loadTelemetryState()
+	if err != nil {
+		xlog.Error("failed to load telemetry state: %v", err)
+		return
+	}
+	if !st.Enabled {
+		return
+	}
+
+	ev.Time = time.Now()
+	ev.ID = st.ID
+	ev.Version = version
+	if ev.Version == "" {
+		ev.Version = "dev"
+	}
+	ev.OS = runtime.GOOS
+	ev.Arch = runtime.GOARCH
+
+	err = appendTelemetry(telemetryQueuePath(), ev)
+	if err != nil {
+		xlog.Error("failed to record telemetry: %v", err)
+		return
+	}
+
+	if time.Since(st.LastSent) > telemetrySendInterval {
+		// Sending happens in a separate process so it never slows down
+		// the command.
+		cmd := exec.Command(os.Args[0], "telemetry", "send")
+		detach(cmd)
+		err = cmd.Start()
+		if err != nil {
+			xlog.Error("failed to send telemetry: %v", err)
+		}
+	}
+}
+
+func appendTelemetry(path string, ev telemetryEvent) error {
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0750)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(b, '\n'))
+	return err
+}
+
+// queuedTelemetry returns the events in the queue at path.
+func queuedTelemetry(path string) ([]telemetryEvent, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var evs []telemetryEvent
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var ev telemetryEvent
+		err = json.Unmarshal(sc.Bytes(), &ev)
+		if err != nil {
+			// A partially written event is dropped.
+			continue
+		}
+		evs = append(evs, ev)
+	}
+	return evs, sc.Err()
+}
+
+// sendTelemetry sends the queued events and clears the queue.
+func sendTelemetry(ctx context.Context) error {
+	st, err := loadTelemetryState()
+	if err != nil {
+		return err
+	}
+	if !st.Enabled {
+		return nil
+	}
+
+	// The queue is moved aside first, so events recorded while sending
+	// aren't lost.
+	sending := telemetryQueuePath() + ".sending"
+	err = os.Rename(telemetryQueuePath(), sending)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	evs, err := queuedTelemetry(sending)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(sending)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	// Only try once per interval, even if sending fails.
+	st.LastSent = time.Now()
+	err = saveTelemetryState(st)
+	if err != nil {
+		return err
+	}
+	if len(evs) == 0 {
+		return nil
+	}
+
+	err = postTelemetry(ctx, evs)
+	if err != nil {
+		// Requeue the events to send them next time.
+		for _, ev := range evs {
+			aerr := appendTelemetry(telemetryQueuePath(), ev)
+			if aerr != nil {
+				xlog.Error("failed to requeue telemetry: %v", aerr)
+			}
+		}
+		return err
+	}
+	return nil
+}
+
+func postTelemetry(ctx context.Context, evs []telemetryEvent) error {
+	b, err := json.Marshal(evs)
+	if err != nil {
+		return err
+	}
+
+	u := os.Getenv("SAIL_TELEMETRY_URL")
+	if u == "" {
+		u = telemetryURL
+	}
+	req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return xerrors.Errorf("failed to send telemetry: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return xerrors.Errorf("failed to send telemetry: %v", resp.Status)
+	}
+	return nil
+}
+
+// errorCategory returns a coarse category of err, which unlike the error
+// message is safe to report.
+func errorCategory(err error) string {
+	var (
+		merr *mountError
+		aerr *registryAuthError
+		nerr net.Error
+	)
+	switch {
+	case xerrors.Is(err, context.Canceled):
+		return "interrupted"
+	case xerrors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case client.IsErrConnectionFailed(err):
+		return "docker_unavailable"
+	case xerrors.As(err, &merr):
+		return "mount"
+	case xerrors.As(err, &aerr):
+		return "registry_auth"
+	case xerrors.As(err, &nerr):
+		return "network"
+	default:
+		return "other"
+	}
+}
+
+// commandName returns the name of the command args run, e.g. "mount add".
+// Only names of cmds are returned, so arguments are never reported.
+func commandName(args []string, cmds []cli.Command) string {
+	var names []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if strings.HasPrefix(arg, "-") {
+			// The config path is the only global flag with a value.
+			if (arg == "-config" || arg == "--config") && len(names) == 0 {
+				i++
+			}
+			continue
+		}
+
+		var found cli.Command
+		for _, cmd := range cmds {
+			if cmd.Spec().Name == arg {
+				found = cmd
+			}
+		}
+		if found == nil {
+			break
+		}
+		names = append(names, arg)
+
+		parent, ok := found.(cli.ParentCommand)
+		if !ok {
+			break
+		}
+		cmds = parent.Subcommands()
+	}
+	return strings.Join(names, " ")
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/mount"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+)
+
+func Test_commandName(t *testing.T) {
+	cmds := (&rootCmd{}).Subcommands()
+
+	for name, args := range map[string][]string{
+		"run":       {"run", "cdr/sail"},
+		"mount add": {"-v", "--config", "run", "mount", "add", "cdr/sail", "/a:/b"},
+		"env set":   {"env", "set", "cdr/sail", "TOKEN=secret"},
+		"":          {"cdr/sail"},
+		"ls":        {"ls", "--all"},
+		"telemetry": {"telemetry"},
+		"restart":   {"restart", "--full", "restart"},
+	} {
+		assert.Equal(t, name, commandName(args, cmds), args)
+	}
+}
+
+func Test_errorCategory(t *testing.T) {
+	assert.Equal(t, "timeout", errorCategory(xerrors.Errorf("failed to start: %w", context.DeadlineExceeded)))
+	assert.Equal(t, "interrupted", errorCategory(context.Canceled))
+	assert.Equal(t, "mount", errorCategory(&mountError{mount: mount.Mount{}, err: xerrors.New("bad")}))
+	assert.Equal(t, "other", errorCategory(xerrors.New("secret message")))
+}
+
+func Test_sendTelemetry(t *testing.T) {
+	home, err := ioutil.TempDir("", "sail-telemetry")
+	require.NoError(t, err)
+	defer os.RemoveAll(home)
+	defer os.Setenv("HOME", os.Getenv("HOME"))
+	os.Setenv("HOME", home)
+
+	var (
+		sent []telemetryEvent
+		fail = true
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&sent))
+	}))
+	defer srv.Close()
+	defer os.Unsetenv("SAIL_TELEMETRY_URL")
+	os.Setenv("SAIL_TELEMETRY_URL", srv.URL)
+
+	// Nothing is recorded until telemetry is turned on.
+	recordTelemetry(telemetryEvent{Type: telemetryCommand, Command: "ls"})
+	evs, err := queuedTelemetry(telemetryQueuePath())
+	require.NoError(t, err)
+	assert.Empty(t, evs)
+
+	require.NoError(t, setTelemetry(true))
+	recordTelemetry(telemetryEvent{Type: telemetryStartup, Command: "run", DurationMS: 1500})
+
+	// Failed sends are requeued.
+	require.Error(t, sendTelemetry(context.Background()))
+	evs, err = queuedTelemetry(telemetryQueuePath())
+	require.NoError(t, err)
+	require.Len(t, evs, 1)
+
+	fail = false
+	require.NoError(t, sendTelemetry(context.Background()))
+	require.Len(t, sent, 1)
+	assert.Equal(t, "run", sent[0].Command)
+	assert.Equal(t, int64(1500), sent[0].DurationMS)
+	assert.NotEmpty(t, sent[0].ID)
+
+	evs, err = queuedTelemetry(telemetryQueuePath())
+	require.NoError(t, err)
+	assert.Empty(t, evs)
+
+	st, err := loadTelemetryState()
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), st.LastSent, time.Minute)
+
+	require.NoError(t, setTelemetry(false))
+	st, err = loadTelemetryState()
+	require.NoError(t, err)
+	assert.False(t, st.Enabled)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"go.coder.com/cli"
+	"go.coder.com/sail/internal/xlog"
+)
+
+type telemetrycmd struct{}
+
+func (c *telemetrycmd) Spec() cli.CommandSpec {
+	return cli.CommandSpec{
+		Name:  "telemetry",
+		Usage: "[status|on|off|show]",
+		Desc: `Manages anonymous usage reporting.
+
+Telemetry is off unless turned on. When on, sail reports which commands are
+run, how long environments take to start and the categories of errors starting
+them, to help prioritize work on sail. Project names, paths, URLs and error
+messages are never reported.`,
+	}
+}
+
+func (c *telemetrycmd) Run(fl *flag.FlagSet) {
+	fl.Usage()
+	os.Exit(1)
+}
+
+func (c *telemetrycmd) Subcommands() []cli.Command {
+	return []cli.Command{
+		&telemetryStatusCmd{},
+		&telemetryOnCmd{},
+		&telemetryOffCmd{},
+		&telemetryShowCmd{},
+		&telemetrySendCmd{},
+	}
+}
+
+type telemetryStatusCmd struct{}
+
+func (c *telemetryStatusCmd) Spec() cli.CommandSpec {
+	return cli.CommandSpec{
+		Name: "status",
+		Desc: "Shows whether telemetry is on.",
+	}
+}
+
+func (c *telemetryStatusCmd) Run(fl *flag.FlagSet) {
+	st, err := loadTelemetryState()
+	if err != nil {
+		xlog.Fatal("%v", err)
+	}
+	if !st.Enabled {
+		fmt.Println("telemetry is off")
+		return
+	}
+
+	evs, err := queuedTelemetry(telemetryQueuePath())
+	if err != nil {
+		xlog.Fatal("failed to read telemetry queue: %v", err)
+	}
+	fmt.Println("telemetry is on")
+	fmt.Printf("installation id: %v\n", st.ID)
+	fmt.Printf("queued events: %v, see them with sail telemetry show\n", len(evs))
+	fmt.Printf("last sent: %v\n", st.LastSent.Format(time.RFC3339))
+}
+
+type telemetryOnCmd struct{}
+
+func (c *telemetryOnCmd) Spec() cli.CommandSpec {
+	return cli.CommandSpec{
+		Name: "on",
+		Desc: "Turns on anonymous usage reporting.",
+	}
+}
+
+func (c *telemetryOnCmd) Run(fl *flag.FlagSet) {
+	err := setTelemetry(true)
+	if err != nil {
+		xlog.Fatal("failed to turn on telemetry: %v", err)
+	}
+	xlog.Success("telemetry is on, thanks for helping improve sail")
+	xlog.Info("events are queued in %v and sent once a day, inspect them with sail telemetry show", telemetryQueuePath())
+}
+
+type telemetryOffCmd struct{}
+
+func (c *telemetryOffCmd) Spec() cli.CommandSpec {
+	return cli.CommandSpec{
+		Name: "off",
+		Desc: "Turns off anonymous usage reporting, dropping unsent events.",
+	}
+}
+
+func (c *telemetryOffCmd) Run(fl *flag.FlagSet) {
+	err := setTelemetry(false)
+	if err != nil {
+		xlog.Fatal("failed to turn off telemetry: %v", err)
+	}
+	xlog.Success("telemetry is off")
+}
+
+type telemetryShowCmd struct{}
+
+func (c *telemetryShowCmd) Spec() cli.CommandSpec {
+	return cli.CommandSpec{
+		Name: "show",
+		Desc: "Prints the queued events exactly as they'll be sent.",
+	}
+}
+
+func (c *telemetryShowCmd) Run(fl *flag.FlagSet) {
+	evs, err := queuedTelemetry(telemetryQueuePath())
+	if err != nil {
+		xlog.Fatal("failed to read telemetry queue: %v", err)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	for _, ev := range evs {
+		err = enc.Encode(ev)
+		if err != nil {
+			xlog.Fatal("%v", err)
+		}
+	}
+}
+
+// telemetrySendCmd sends the queued events. It's run in the background by
+// recordTelemetry.
+type telemetrySendCmd struct{}
+
+func (c *telemetrySendCmd) Spec() cli.CommandSpec {
+	return cli.CommandSpec{
+		Name:   "send",
+		Desc:   "Sends the queued events.",
+		Hidden: true,
+	}
+}
+
+func (c *telemetrySendCmd) Run(fl *flag.FlagSet) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+
+	err := sendTelemetry(ctx)
+	if err != nil {
+		xlog.Fatal("%v", err)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/docker/docker/api/types/mount"
+	"golang.org/x/xerrors"
+)
+
+// The variables of mount path templates, e.g.
+// share.npm="~/.cache/sail/${PROJECT}/npm:~/.npm".
+const (
+	// templateProject is the project's name, e.g. cdr/sail.
+	templateProject = "PROJECT"
+	// templateHome is the home directory of the side of the mount: the host
+	// user's in host paths, the container user's in guest paths.
+	templateHome = "HOME"
+	// templateContainerName is the name of the project's container, e.g.
+	// cdr_sail.
+	templateContainerName = "CONTAINER_NAME"
+)
+
+var templateVars = []string{templateProject, templateHome, templateContainerName}
+
+// templateRegex matches the variables of templates, e.g. ${PROJECT}.
+var templateRegex = regexp.MustCompile(`\$\{([^}]*)\}`)
+
+// checkTemplate ensures that s only uses known variables.
+func checkTemplate(s string) error {
+	for _, m := range templateRegex.FindAllStringSubmatch(s, -1) {
+		if !stringsContain(templateVars, m[1]) {
+			return xerrors.Errorf("unknown variable ${%v}, must be one of %v%v", m[1], strings.Join(templateVars, ", "), didYouMean(m[1], templateVars))
+		}
+	}
+	return nil
+}
+
+// expandTemplate replaces the variables of s with their values in vars.
+func expandTemplate(s string, vars map[string]string) string {
+	return templateRegex.ReplaceAllStringFunc(s, func(v string) string {
+		val, ok := vars[v[2:len(v)-1]]
+		if !ok {
+			return v
+		}
+		return val
+	})
+}
+
+// expandMount expands the templates of m's paths for the project name,
+// whose container is cntName and whose user's home is guestHome.
+func expandMount(m *mount.Mount, name, cntName, guestHome string) *mountError {
+	hostHome, err := os.UserHomeDir()
+	if err != nil {
+		return &mountError{
+			mount: *m,
+			err:   xerrors.Errorf("failed to get host home dir: %w", err),
+		}
+	}
+	vars := map[string]string{
+		templateProject:       name,
+		templateContainerName: cntName,
+	}
+
+	vars[templateHome] = hostHome
+	m.Source = expandTemplate(m.Source, vars)
+	vars[templateHome] = guestHome
+	m.Target = expandTemplate(m.Target, vars)
+	return nil
+}
+
+// expandMount expands the templates of m's paths for the runner's project.
+func (r *runner) expandMount(m *mount.Mount) *mountError {
+	return expandMount(m, r.name, r.cntName, r.guestHome())
+}
+
+// templateHint is the hint of errors of templates.
+var templateHint = fmt.Sprintf("paths may use ${%v}, ${%v} and ${%v}", templateProject, templateHome, templateContainerName)
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/docker/docker/api/types/mount"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_checkTemplate(t *testing.T) {
+	require.NoError(t, checkTemplate("~/.cache/${PROJECT}/${CONTAINER_NAME}"))
+	require.NoError(t, checkTemplate("/var/cache/npm"))
+	err := checkTemplate("~/.cache/${PROJCT}")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "PROJECT")
+}
+
+func Test_expandMount(t *testing.T) {
+	home, err := os.UserHomeDir()
+	require.NoError(t, err)
+
+	m, err := parseShareLabel("share.npm", "${HOME}/.cache/sail/${PROJECT}/npm:${HOME}/.npm")
+	require.NoError(t, err)
+	require.Nil(t, expandMount(&m, "cdr/sail", "cdr_sail", "/home/user"))
+	assert.Equal(t, mount.Mount{
+		Type:   mount.TypeBind,
+		Source: home + "/.cache/sail/cdr/sail/npm",
+		Target: "/home/user/.npm",
+	}, m)
+
+	m = mount.Mount{Source: "/tmp/${CONTAINER_NAME}", Target: "/cache/${PROJECT}"}
+	require.Nil(t, expandMount(&m, "cdr/sail", "cdr_sail", "/home/user"))
+	assert.Equal(t, "/tmp/cdr_sail", m.Source)
+	assert.Equal(t, "/cache/cdr/sail", m.Target)
+
+	_, err = parseShareLabel("share.npm", "~/.cache/${NAME}:~/.npm")
+	require.Error(t, err)
+	_, err = parseShareLabel("share.npm", "~/.cache:${PROJECT}/npm")
+	require.Error(t, err)
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/mattn/go-isatty"
+	"golang.org/x/xerrors"
+
+	"go.coder.com/sail/internal/xlog"
+)
+
+// Trust decisions of projects.
+const (
+	trustTrusted   = "trusted"
+	trustSandboxed = "sandboxed"
+)
+
+// sandboxLabel marks containers created sandboxed, see runner.sandbox.
+const sandboxLabel = sailLabel + ".sandbox"
+
+// trustPath holds the trust decisions of projects, by name.
+func trustPath() string {
+	return filepath.Join(metaRoot(), "trust.json")
+}
+
+func loadTrust() (map[string]string, error) {
+	decisions := make(map[string]string)
+	b, err := ioutil.ReadFile(trustPath())
+	if os.IsNotExist(err) {
+		return decisions, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(b, &decisions)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to parse %v: %w", trustPath(), err)
+	}
+	return decisions, nil
+}
+
+// saveTrust records the trust decision of the project name.
+func saveTrust(name, decision string) error {
+	decisions, err := loadTrust()
+	if err != nil {
+		return err
+	}
+	decisions[name] = decision
+	b, err := json.MarshalIndent(decisions, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(trustPath()), 0750)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(trustPath(), b, 0640)
+}
+
+// matchTrusted reports whether the project name matches one of the patterns
+// of the trusted config key, like cdr/*.
+func matchTrusted(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// checkTrusted validates the patterns of the trusted config key.
+func checkTrusted(patterns []string) error {
+	for _, p := range patterns {
+		_, err := path.Match(p, "")
+		if err != nil {
+			return xerrors.Errorf("invalid trusted pattern %q: %w", p, err)
+		}
+	}
+	return nil
+}
+
+// sandboxed reports whether the project's new container must be sandboxed.
+// --trust and --sandbox record the decision. Otherwise projects that are
+// allowed by the config, were decided on before, or were opened before trust
+// was introduced are trusted, and the user is asked about the others. Without
+// a terminal to ask on, they're sandboxed.
+func (c *runcmd) sandboxed(proj *project) (bool, error) {
+	name := proj.pathName()
+	switch {
+	case c.trust && c.sandbox:
+		return false, xerrors.New("--trust and --sandbox can't be used together")
+	case c.trust:
+		return false, saveTrust(name, trustTrusted)
+	case c.sandbox:
+		return true, saveTrust(name, trustSandboxed)
+	case matchTrusted(proj.conf.Trusted, name):
+		return false, nil
+	}
+
+	decisions, err := loadTrust()
+	if err != nil {
+		return false, err
+	}
+	switch decisions[name] {
+	case trustTrusted:
+		return false, nil
+	case trustSandboxed:
+		return true, nil
+	}
+	if isStateDir(filepath.Join(metaRoot(), proj.cntName())) {
+		return false, nil
+	}
+
+	if !isatty.IsTerminal(os.Stdin.Fd()) || !isatty.IsTerminal(os.Stderr.Fd()) {
+		xlog.Info("%v wasn't opened before, so it's sandboxed, trust it with sail run --rebuild --trust", name)
+		return true, nil
+	}
+	fmt.Fprintf(os.Stderr, "%v wasn't opened before. Trusted projects run privileged, with your VS Code settings,\n"+
+		"SSH agent and mounts. Sandboxed ones only have the project mounted.\n"+
+		"Trust it, open it sandboxed, or abort? [t/S/a] ", name)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return false, xerrors.Errorf("failed to read answer: %w", err)
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "t", "trust":
+		return false, saveTrust(name, trustTrusted)
+	case "", "s", "sandbox":
+		return true, saveTrust(name, trustSandboxed)
+	default:
+		return false, xerrors.Errorf("%v isn't trusted", name)
+	}
+}
+
+// trusted reports whether the project was trusted explicitly, with --trust,
+// the config or the answer of sandboxed. Projects opened before trust was
+// introduced aren't sandboxed, but they weren't trusted explicitly.
+func (c *runcmd) trusted(proj *project) (bool, error) {
+	name := proj.pathName()
+	if c.trust || matchTrusted(proj.conf.Trusted, name) {
+		return true, nil
+	}
+	decisions, err := loadTrust()
+	if err != nil {
+		return false, err
+	}
+	return decisions[name] == trustTrusted, nil
+}
+
+// sandboxBuild builds the project's image sandboxed: on Docker's default
+// network, without the host's proxy settings, and with only sail's own build
+// arguments, as build_args may hold credentials.
+func (p *project) sandboxBuild() {
+	p.buildOpts.sandbox = true
+	p.buildOpts.buildArgs = config{}.buildArgs(p.pathName())
+}
+
+// applySandbox restricts the container to the project, if r.sandbox. It runs
+// unprivileged, without the host's groups, devices or security options.
+// Mounts are restricted by runner.mounts.
+func (r *runner) applySandbox(containerConfig *container.Config, hostConfig *container.HostConfig) {
+	if !r.sandbox {
+		return
+	}
+	containerConfig.Labels[sandboxLabel] = "true"
+	containerConfig.Labels[privilegedLabel] = "false"
+	hostConfig.Privileged = false
+	hostConfig.CapAdd = nil
+	hostConfig.SecurityOpt = nil
+	hostConfig.GroupAdd = nil
+	hostConfig.Devices = nil
+}
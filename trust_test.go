@@ -0,0 +1,77 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_matchTrusted(t *testing.T) {
+	patterns := []string{"cdr/*", "nhooyr/websocket"}
+	assert.True(t, matchTrusted(patterns, "cdr/sail"))
+	assert.True(t, matchTrusted(patterns, "nhooyr/websocket"))
+	assert.False(t, matchTrusted(patterns, "nhooyr/color"))
+	assert.False(t, matchTrusted(nil, "cdr/sail"))
+
+	require.NoError(t, checkTrusted(patterns))
+	require.Error(t, checkTrusted([]string{"cdr/[sail"}))
+}
+
+func Test_sandboxed(t *testing.T) {
+	home, err := ioutil.TempDir("", "sail-trust")
+	require.NoError(t, err)
+	defer os.RemoveAll(home)
+	defer os.Setenv("HOME", os.Getenv("HOME"))
+	os.Setenv("HOME", home)
+
+	proj := &project{repo: repo{URL: &url.URL{Path: "someone/untrusted"}}}
+
+	// Without a terminal to ask on, unknown projects are sandboxed.
+	sandbox, err := (&runcmd{}).sandboxed(proj)
+	require.NoError(t, err)
+	assert.True(t, sandbox)
+
+	sandbox, err = (&runcmd{trust: true}).sandboxed(proj)
+	require.NoError(t, err)
+	assert.False(t, sandbox)
+	// The decision is kept.
+	sandbox, err = (&runcmd{}).sandboxed(proj)
+	require.NoError(t, err)
+	assert.False(t, sandbox)
+
+	_, err = (&runcmd{trust: true, sandbox: true}).sandboxed(proj)
+	require.Error(t, err)
+
+	proj.conf.Trusted = []string{"someone/*"}
+	sandbox, err = (&runcmd{}).sandboxed(proj)
+	require.NoError(t, err)
+	assert.False(t, sandbox)
+}
+
+func Test_sandboxBuild(t *testing.T) {
+	proj := &project{repo: repo{URL: &url.URL{Path: "someone/untrusted"}}}
+	proj.buildOpts.buildArgs = config{BuildArgs: map[string]string{"NPM_TOKEN": "secret"}}.buildArgs(proj.pathName())
+	assert.Contains(t, proj.buildOpts.buildArgs, "NPM_TOKEN=secret")
+
+	proj.sandboxBuild()
+	assert.True(t, proj.buildOpts.sandbox)
+	assert.NotContains(t, proj.buildOpts.buildArgs, "NPM_TOKEN=secret")
+	assert.Contains(t, proj.buildOpts.buildArgs, "SAIL_PROJECT=someone/untrusted")
+}
+
+func Test_applySandbox(t *testing.T) {
+	r := &runner{sandbox: true, groupAdd: []string{"video"}}
+	cnt := &container.Config{Labels: map[string]string{}}
+	hostConfig, err := r.hostConfig(cnt, nil)
+	require.NoError(t, err)
+
+	assert.False(t, hostConfig.Privileged)
+	assert.Empty(t, hostConfig.GroupAdd)
+	assert.Equal(t, "true", cnt.Labels[sandboxLabel])
+	assert.Equal(t, "false", cnt.Labels[privilegedLabel])
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/url"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"go.coder.com/sail/internal/xlog"
+)
+
+// Tunnels of sail share, besides ssh:// relays.
+const (
+	tunnelCloudflared = "cloudflared"
+	tunnelNgrok       = "ngrok"
+)
+
+// tunnelTimeout bounds how long a tunnel has to report its public URL.
+const tunnelTimeout = time.Second * 30
+
+// tunnel exposes a local address publicly through a tunnel process.
+type tunnel struct {
+	// url is the public URL of the local address.
+	url string
+	// done is closed once the tunnel process exits.
+	done chan struct{}
+}
+
+// tunnelCommand returns the command exposing the local address addr through
+// the tunnel kind, and the parser of its public URL from its output lines.
+//
+// kind is cloudflared or ngrok, whose quick tunnels need no setup, or the
+// ssh://[user@]host[:port] of a self-hosted relay, an SSH server that lets its
+// clients forward its public ports (GatewayPorts yes).
+func tunnelCommand(kind, addr string) (*exec.Cmd, func(line string) string, error) {
+	switch kind {
+	case tunnelCloudflared:
+		return exec.Command("cloudflared", "tunnel", "--no-autoupdate", "--url", "http://"+addr), parseCloudflaredURL, nil
+	case tunnelNgrok:
+		return exec.Command("ngrok", "http", addr, "--log", "stdout", "--log-format", "json"), parseNgrokURL, nil
+	}
+
+	if !strings.HasPrefix(kind, "ssh://") {
+		return nil, nil, xerrors.Errorf("invalid tunnel %q, must be %v, %v or an ssh:// relay", kind, tunnelCloudflared, tunnelNgrok)
+	}
+	u, err := url.Parse(kind)
+	if err != nil || u.Hostname() == "" {
+		return nil, nil, xerrors.Errorf("invalid relay %q, must be ssh://[user@]host[:port]", kind)
+	}
+	dest := u.Hostname()
+	if u.User != nil {
+		dest = u.User.Username() + "@" + dest
+	}
+	args := []string{"-N", "-o", "ExitOnForwardFailure=yes", "-R", "0:" + addr}
+	if u.Port() != "" {
+		args = append(args, "-p", u.Port())
+	}
+	args = append(args, dest)
+	return exec.Command("ssh", args...), sshRelayURLParser(u.Hostname()), nil
+}
+
+var cloudflaredURLRegex = regexp.MustCompile(`https://[a-z0-9-]+\.trycloudflare\.com`)
+
+// parseCloudflaredURL finds the URL of a quick tunnel in cloudflared's log.
+func parseCloudflaredURL(line string) string {
+	return cloudflaredURLRegex.FindString(line)
+}
+
+// parseNgrokURL finds the URL of the tunnel in ngrok's JSON log. ngrok may
+// also start a plain HTTP tunnel, which isn't used.
+func parseNgrokURL(line string) string {
+	var entry struct {
+		Msg string `json:"msg"`
+		URL string `json:"url"`
+	}
+	err := json.Unmarshal([]byte(line), &entry)
+	if err != nil || entry.Msg != "started tunnel" || !strings.HasPrefix(entry.URL, "https://") {
+		return ""
+	}
+	return entry.URL
+}
+
+var sshAllocatedPortRegex = regexp.MustCompile(`Allocated port (\d+) for remote forward`)
+
+// sshRelayURLParser returns the parser of the port ssh allocated on the relay
+// host for its remote forward. sail serves TLS on it, see tunnelTLS.
+func sshRelayURLParser(host string) func(line string) string {
+	return func(line string) string {
+		m := sshAllocatedPortRegex.FindStringSubmatch(line)
+		if m == nil {
+			return ""
+		}
+		return "https://" + net.JoinHostPort(host, m[1])
+	}
+}
+
+// tunnelTLS returns the TLS config the local address of the tunnel kind is
+// served with, or nil if the tunnel serves TLS. ssh relays only forward TCP,
+// so sail serves TLS itself, with a self-signed certificate for the relay's
+// host that expires at expires. Its SHA-256 fingerprint is returned to be
+// shown with the link.
+func tunnelTLS(kind string, expires time.Time) (*tls.Config, string, error) {
+	if !strings.HasPrefix(kind, "ssh://") {
+		return nil, "", nil
+	}
+	u, err := url.Parse(kind)
+	if err != nil || u.Hostname() == "" {
+		return nil, "", xerrors.Errorf("invalid relay %q, must be ssh://[user@]host[:port]", kind)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, "", xerrors.Errorf("failed to generate key: %w", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, "", err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: u.Hostname()},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     expires,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if ip := net.ParseIP(u.Hostname()); ip != nil {
+		tmpl.IPAddresses = []net.IP{ip}
+	} else {
+		tmpl.DNSNames = []string{u.Hostname()}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, "", xerrors.Errorf("failed to create certificate: %w", err)
+	}
+
+	sum := sha256.Sum256(der)
+	fingerprint := make([]string, len(sum))
+	for i, b := range sum {
+		fingerprint[i] = fmt.Sprintf("%02X", b)
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+	}, strings.Join(fingerprint, ":"), nil
+}
+
+// startTunnel exposes the local address addr through the tunnel kind, see
+// tunnelCommand. The tunnel stops when ctx is done.
+func startTunnel(ctx context.Context, kind, addr string) (*tunnel, error) {
+	cmd, parse, err := tunnelCommand(kind, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	pr, pw := io.Pipe()
+	cmd.Stdout = pw
+	cmd.Stderr = pw
+	err = cmd.Start()
+	if err != nil {
+		return nil, xerrors.Errorf("failed to start %v: %w", cmd.Args[0], err)
+	}
+	go func() {
+		<-ctx.Done()
+		cmd.Process.Kill()
+	}()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		err := cmd.Wait()
+		if err == nil {
+			err = xerrors.New("exited")
+		}
+		pw.CloseWithError(xerrors.Errorf("%v: %w", cmd.Args[0], err))
+	}()
+
+	urls := make(chan string, 1)
+	errs := make(chan error, 1)
+	go func() {
+		sc := bufio.NewScanner(pr)
+		found := false
+		for sc.Scan() {
+			xlog.Debug("%v: %v", cmd.Args[0], sc.Text())
+			if found {
+				continue
+			}
+			if u := parse(sc.Text()); u != "" {
+				found = true
+				urls <- u
+			}
+		}
+		if !found {
+			errs <- sc.Err()
+		}
+	}()
+
+	select {
+	case u := <-urls:
+		return &tunnel{url: u, done: done}, nil
+	case err := <-errs:
+		return nil, xerrors.Errorf("%v didn't report its URL: %w", cmd.Args[0], err)
+	case <-time.After(tunnelTimeout):
+		cmd.Process.Kill()
+		return nil, xerrors.Errorf("%v didn't report its URL within %v", cmd.Args[0], tunnelTimeout)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
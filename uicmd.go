@@ -0,0 +1,388 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"unicode/utf8"
+
+	"github.com/docker/docker/pkg/term"
+	"github.com/docker/go-units"
+	"github.com/mattn/go-isatty"
+	"golang.org/x/xerrors"
+
+	"go.coder.com/cli"
+	"go.coder.com/sail/internal/xexec"
+	"go.coder.com/sail/internal/xlog"
+)
+
+type uicmd struct {
+	gf *globalFlags
+}
+
+func (c *uicmd) Spec() cli.CommandSpec {
+	return cli.CommandSpec{
+		Name: "ui",
+		Desc: `Shows a dashboard of all projects.
+
+Keys:
+	up/k, down/j	Select a project.
+	o, enter	Open the project.
+	s	Stop the project.
+	b	Rebuild the project.
+	l	View the project's logs.
+	d	Remove the project.
+	r	Refresh.
+	q	Quit.`,
+	}
+}
+
+func (c *uicmd) Run(fl *flag.FlagSet) {
+	if !isatty.IsTerminal(os.Stdin.Fd()) || !isatty.IsTerminal(os.Stdout.Fd()) {
+		xlog.Fatal("sail ui must be run in a terminal")
+	}
+	c.gf.ensureDockerDaemon()
+
+	d := &dashboard{gf: c.gf}
+	err := d.run()
+	if err != nil {
+		xlog.Fatal("%v", err)
+	}
+}
+
+// dashboardRefresh is how often the dashboard refreshes the projects.
+const dashboardRefresh = time.Second * 2
+
+// dashboard is the terminal UI of sail ui.
+type dashboard struct {
+	gf    *globalFlags
+	infos []projectInfo
+	// selected is the index of the selected project in infos.
+	selected int
+	// message is shown below the projects, e.g. the result of an action.
+	message string
+	// confirming is set while waiting for confirmation to remove the
+	// selected project.
+	confirming bool
+
+	// termState is the terminal state to restore on exit.
+	termState *term.State
+}
+
+func (d *dashboard) run() error {
+	fd := os.Stdin.Fd()
+	var err error
+	d.termState, err = term.MakeRaw(fd)
+	if err != nil {
+		return xerrors.Errorf("failed to make terminal raw: %w", err)
+	}
+	defer term.RestoreTerminal(fd, d.termState)
+
+	// Use the alternate screen so the dashboard doesn't clobber scrollback,
+	// and hide the cursor.
+	fmt.Print("\x1b[?1049h\x1b[?25l")
+	defer fmt.Print("\x1b[?25h\x1b[?1049l")
+
+	// Keys are only read on request, so nothing reads stdin while an action
+	// runs a command attached to the terminal.
+	readKeys := make(chan struct{})
+	keys := make(chan string)
+	errs := make(chan error, 1)
+	go func() {
+		for range readKeys {
+			key, err := readKey(os.Stdin)
+			if err != nil {
+				errs <- err
+				return
+			}
+			keys <- key
+		}
+	}()
+	defer close(readKeys)
+
+	ticker := time.NewTicker(dashboardRefresh)
+	defer ticker.Stop()
+
+	d.refresh()
+	readKeys <- struct{}{}
+	for {
+		d.draw(os.Stdout)
+
+		select {
+		case key := <-keys:
+			if d.handle(key) {
+				return nil
+			}
+			readKeys <- struct{}{}
+		case <-ticker.C:
+			d.refresh()
+		case err := <-errs:
+			return xerrors.Errorf("failed to read key: %w", err)
+		}
+	}
+}
+
+// refresh lists the projects, keeping the selected project selected.
+func (d *dashboard) refresh() {
+	infos, err := d.gf.listAllProjects()
+	if err != nil {
+		d.message = fmt.Sprintf("failed to list projects: %v", err)
+		return
+	}
+	external, err := externalProjects(context.Background())
+	if err != nil {
+		d.message = fmt.Sprintf("failed to list projects of other backends: %v", err)
+	}
+	d.setProjects(append(infos, external...))
+}
+
+func (d *dashboard) setProjects(infos []projectInfo) {
+	var selected string
+	if sel, ok := d.selection(); ok {
+		selected = sel.cntName
+	}
+
+	d.infos = infos
+	d.selected = 0
+	for i, info := range infos {
+		if info.cntName == selected {
+			d.selected = i
+		}
+	}
+}
+
+// selection returns the selected project.
+func (d *dashboard) selection() (projectInfo, bool) {
+	if d.selected < 0 || d.selected >= len(d.infos) {
+		return projectInfo{}, false
+	}
+	return d.infos[d.selected], true
+}
+
+// handle handles a key press, returning true if the dashboard should quit.
+func (d *dashboard) handle(key string) bool {
+	info, ok := d.selection()
+
+	if d.confirming {
+		d.confirming = false
+		d.message = ""
+		if key == "y" && ok {
+			d.do("removing", "removed", func(ctx context.Context) error {
+				// Hooks run with the docker CLI, so projects of other
+				// contexts are removed by a sail process of theirs.
+				if d.gf.dockerContextEnv(info.dockerContext) != nil {
+					out, err := d.gf.contextSailCmd(info.dockerContext, "rm", info.name).CombinedOutput()
+					if err != nil {
+						return xerrors.Errorf("%s: %w", bytes.TrimSpace(out), err)
+					}
+					return nil
+				}
+				cli := dockerClient()
+				defer cli.Close()
+				return removeContainer(ctx, cli, info.cntName)
+			})
+		}
+		return false
+	}
+
+	d.message = ""
+	switch key {
+	case "q", "ctrl-c":
+		return true
+	case "up":
+		if d.selected > 0 {
+			d.selected--
+		}
+	case "down":
+		if d.selected < len(d.infos)-1 {
+			d.selected++
+		}
+	case "r":
+		d.refresh()
+	}
+
+	if !ok {
+		return false
+	}
+
+	switch key {
+	case "o", "enter":
+		d.suspend(sailCmd(append([]string{"run"}, runArgs(info)...)...))
+	case "b":
+		d.suspend(sailCmd(append([]string{"run", "--rebuild", "--no-open"}, runArgs(info)...)...))
+	case "l":
+		cmd := exec.Command("docker", "logs", "--tail", "200", info.cntName)
+		cmd.Env = d.gf.dockerContextEnv(info.dockerContext)
+		d.suspend(cmd)
+	case "s":
+		d.do("stopping", "stopped", func(ctx context.Context) error {
+			cli, err := d.gf.projectClient(info)
+			if err != nil {
+				return err
+			}
+			defer cli.Close()
+			return stopContainer(ctx, cli, info.cntName)
+		})
+	case "d":
+		d.confirming = true
+		d.message = fmt.Sprintf("remove %v? [y/N]", info.name)
+	}
+	return false
+}
+
+// do runs an action on the selected project and reports the result.
+func (d *dashboard) do(doing, done string, fn func(context.Context) error) {
+	info, _ := d.selection()
+	d.message = fmt.Sprintf("%v %v", doing, info.name)
+	d.draw(os.Stdout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	err := fn(ctx)
+	if err != nil {
+		d.message = err.Error()
+	} else {
+		d.message = fmt.Sprintf("%v %v", done, info.name)
+	}
+	d.refresh()
+}
+
+// suspend restores the terminal to run cmd attached to it, and waits
+// for a key press before returning to the dashboard.
+func (d *dashboard) suspend(cmd *exec.Cmd) {
+	fd := os.Stdin.Fd()
+	fmt.Print("\x1b[?25h\x1b[?1049l")
+	term.RestoreTerminal(fd, d.termState)
+
+	xexec.Attach(cmd)
+	err := cmd.Run()
+	if err != nil {
+		xlog.Error("%v failed: %v", strings.Join(cmd.Args, " "), err)
+	}
+
+	_, err = term.MakeRaw(fd)
+	if err != nil {
+		d.message = fmt.Sprintf("failed to make terminal raw: %v", err)
+	}
+	fmt.Print("\r\npress any key to return to the dashboard")
+	readKey(os.Stdin)
+	fmt.Print("\x1b[?1049h\x1b[?25l")
+	d.refresh()
+}
+
+// sailCmd returns a command running this sail binary with args.
+func sailCmd(args ...string) *exec.Cmd {
+	sail, err := os.Executable()
+	if err != nil {
+		sail = os.Args[0]
+	}
+	return exec.Command(sail, args...)
+}
+
+// runArgs returns the arguments to sail run for the project.
+func runArgs(info projectInfo) []string {
+	if info.remote == "" {
+		return []string{info.name}
+	}
+	return []string{"--name", info.name, info.remote}
+}
+
+// draw renders the dashboard to w.
+func (d *dashboard) draw(w io.Writer) {
+	width, height := 80, 24
+	ws, err := term.GetWinsize(os.Stdout.Fd())
+	if err == nil && ws.Width > 0 && ws.Height > 0 {
+		width, height = int(ws.Width), int(ws.Height)
+	}
+
+	var buf bytes.Buffer
+	d.render(&buf, width, height)
+	// The terminal is raw, so newlines don't return the carriage.
+	io.WriteString(w, "\x1b[H\x1b[2J"+strings.Replace(buf.String(), "\n", "\r\n", -1))
+}
+
+// render writes the dashboard to w, fitting it within width and height.
+func (d *dashboard) render(w io.Writer, width, height int) {
+	var table bytes.Buffer
+	tw := tabwriter.NewWriter(&table, 0, 0, 3, ' ', 0)
+	fmt.Fprintf(tw, "name\tstatus\timage\tlast used\turl\n")
+	for _, info := range d.infos {
+		used := "never"
+		if t := lastUsed(info.cntName); !t.IsZero() {
+			used = units.HumanDuration(time.Since(t)) + " ago"
+		}
+		fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t%v\n", info.name, info.status, info.image, used, info.url)
+	}
+	tw.Flush()
+
+	// Leave room for the title, help and message lines.
+	rows := strings.Split(strings.TrimSuffix(table.String(), "\n"), "\n")
+	header, rows := rows[0], rows[1:]
+	maxRows := height - 5
+	if maxRows < 1 {
+		maxRows = 1
+	}
+	// Scroll so the selected project is visible.
+	offset := 0
+	if d.selected >= maxRows {
+		offset = d.selected - maxRows + 1
+	}
+
+	fmt.Fprintf(w, "%v\n", truncate(fmt.Sprintf("sail - %v projects", len(d.infos)), width))
+	fmt.Fprintf(w, "%v\n", truncate(header, width))
+	for i := offset; i < len(rows) && i < offset+maxRows; i++ {
+		row := truncate(rows[i], width)
+		if i == d.selected {
+			// Reverse video.
+			row = "\x1b[7m" + row + "\x1b[0m"
+		}
+		fmt.Fprintf(w, "%v\n", row)
+	}
+	if len(rows) == 0 {
+		fmt.Fprintf(w, "no projects, create one with sail run\n")
+	}
+
+	fmt.Fprintf(w, "\n%v\n", truncate("o open  s stop  b rebuild  l logs  d remove  r refresh  q quit", width))
+	if d.message != "" {
+		fmt.Fprintf(w, "%v", truncate(d.message, width))
+	}
+}
+
+// truncate shortens s to at most width runes.
+func truncate(s string, width int) string {
+	if utf8.RuneCountInString(s) <= width {
+		return s
+	}
+	return string([]rune(s)[:width])
+}
+
+// readKey reads a key press from a raw terminal.
+// Arrow keys and control characters are returned by name.
+func readKey(r io.Reader) (string, error) {
+	buf := make([]byte, 8)
+	n, err := r.Read(buf)
+	if err != nil {
+		return "", err
+	}
+
+	switch key := string(buf[:n]); key {
+	case "\x1b[A", "\x1bOA", "k":
+		return "up", nil
+	case "\x1b[B", "\x1bOB", "j":
+		return "down", nil
+	case "\r", "\n":
+		return "enter", nil
+	case "\x03":
+		return "ctrl-c", nil
+	default:
+		return key, nil
+	}
+}
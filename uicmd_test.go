@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_dashboard(t *testing.T) {
+	d := &dashboard{}
+	d.setProjects([]projectInfo{
+		{cntName: "cdr_sail", name: "cdr/sail", status: "Up 5 minutes"},
+		{cntName: "cdr_code-server", name: "cdr/code-server", status: "Exited (0) 2 hours ago"},
+	})
+
+	require.False(t, d.handle("down"))
+	require.Equal(t, 1, d.selected)
+	require.False(t, d.handle("down"))
+	require.Equal(t, 1, d.selected, "selection stops at the last project")
+
+	// The selection follows the project when the list changes.
+	d.setProjects([]projectInfo{
+		{cntName: "cdr_code-server", name: "cdr/code-server"},
+		{cntName: "cdr_sail", name: "cdr/sail"},
+	})
+	require.Equal(t, 0, d.selected)
+
+	require.False(t, d.handle("d"))
+	require.True(t, d.confirming)
+	require.False(t, d.handle("n"), "any other key cancels the removal")
+	require.False(t, d.confirming)
+
+	var buf bytes.Buffer
+	d.render(&buf, 40, 24)
+	lines := strings.Split(buf.String(), "\n")
+	for _, l := range lines {
+		l = strings.NewReplacer("\x1b[7m", "", "\x1b[0m", "").Replace(l)
+		require.True(t, len(l) <= 40, "line %q is too wide", l)
+	}
+	require.Contains(t, lines[2], "\x1b[7mcdr/code-server", "the selected project is highlighted")
+
+	require.True(t, d.handle("q"))
+}
+
+func Test_readKey(t *testing.T) {
+	for in, want := range map[string]string{
+		"\x1b[A": "up",
+		"j":      "down",
+		"\r":     "enter",
+		"\x03":   "ctrl-c",
+		"s":      "s",
+	} {
+		got, err := readKey(strings.NewReader(in))
+		require.NoError(t, err)
+		require.Equal(t, want, got)
+	}
+}
+
+func Test_runArgs(t *testing.T) {
+	require.Equal(t, []string{"cdr/sail"}, runArgs(projectInfo{name: "cdr/sail"}))
+	require.Equal(t,
+		[]string{"--name", "me/sail", "ssh://git@github.com/cdr/sail.git"},
+		runArgs(projectInfo{name: "me/sail", remote: "ssh://git@github.com/cdr/sail.git"}),
+	)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"strings"
+
+	"go.coder.com/cli"
+	"go.coder.com/sail/internal/xlog"
+)
+
+type upcmd struct {
+	gf *globalFlags
+
+	noOpen bool
+}
+
+func (c *upcmd) Spec() cli.CommandSpec {
+	return cli.CommandSpec{
+		Name:  "up",
+		Usage: "[flags] <group>",
+		Desc: `Starts all projects of a group, see groups in the config.
+
+Each project is started with sail run in the group's order, so projects that
+don't exist yet are created. Their containers join the group's Docker network,
+on which they reach each other by their hostnames.`,
+	}
+}
+
+func (c *upcmd) RegisterFlags(fl *flag.FlagSet) {
+	fl.BoolVar(&c.noOpen, "no-open", false, "Don't open the projects in the browser.")
+}
+
+func (c *upcmd) Run(fl *flag.FlagSet) {
+	group := fl.Arg(0)
+	if group == "" {
+		xlog.Fatal("a group is required, see groups in the config")
+	}
+	projects, err := c.gf.config().group(group)
+	if err != nil {
+		xlog.Fatal("%v", err)
+	}
+
+	// Projects created with --name are run again from their remote.
+	c.gf.selectDockerContext("")
+	existing := make(map[string]projectInfo)
+	infos, err := listProjects()
+	if err != nil {
+		xlog.Debug("%v", err)
+	}
+	for _, info := range infos {
+		existing[info.name] = info
+	}
+
+	var failed []string
+	for _, name := range projects {
+		args := c.runArgs(name, existing)
+		xlog.Info("starting %v", name)
+		cmd := sailCmd(args...)
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		err := cmd.Run()
+		if err != nil {
+			xlog.Error("failed to start %v: %v", name, err)
+			failed = append(failed, name)
+		}
+	}
+	if len(failed) > 0 {
+		xlog.Fatal("failed to start %v of group %v", strings.Join(failed, ", "), group)
+	}
+	xlog.Success("started group %v", group)
+}
+
+// runArgs returns the arguments to sail running the project name.
+func (c *upcmd) runArgs(name string, existing map[string]projectInfo) []string {
+	args := []string{"--config", c.gf.configPath}
+	if c.gf.verbose {
+		args = append(args, "-v")
+	}
+	args = append(args, "run")
+	if c.noOpen {
+		args = append(args, "--no-open")
+	}
+	if info, ok := existing[name]; ok {
+		return append(args, runArgs(info)...)
+	}
+	return append(args, name)
+}
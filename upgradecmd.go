@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+
+	"github.com/docker/docker/api/types"
+	"golang.org/x/xerrors"
+
+	"go.coder.com/cli"
+	"go.coder.com/sail/internal/randstr"
+	"go.coder.com/sail/internal/xlog"
+)
+
+type upgradecmd struct {
+	gf *globalFlags
+}
+
+func (c *upgradecmd) Spec() cli.CommandSpec {
+	return cli.CommandSpec{
+		Name:  "upgrade",
+		Usage: "<repo>",
+		Desc: `Upgrades a project's environment to the latest versions of its base images.
+
+The project's image is rebuilt with the latest base images and its hat, and
+the container is recreated with it like sail edit does. The project directory,
+the editor's state, environment variables and mounts are kept. Running
+processes and changes outside of mounts and the project directory are lost.
+
+The project's .sail/lock is ignored, run sail lock afterwards to pin the new
+versions. sail outdated lists the projects that can be upgraded.`,
+	}
+}
+
+func (c *upgradecmd) Run(fl *flag.FlagSet) {
+	proj := c.gf.project(schemaPrefs{}, fl)
+	c.gf.ensureDockerDaemon()
+
+	// The lock is released when we exit.
+	_, err := proj.lock()
+	if err != nil {
+		xlog.Fatal("failed to lock project: %v", err)
+	}
+
+	cli := dockerClient()
+	defer cli.Close()
+
+	ctx := context.Background()
+	cnt, err := inspectContainer(ctx, cli, proj.cntName())
+	if err != nil {
+		xlog.Fatal("%v doesn't exist, create it with sail run: %v", proj.pathName(), err)
+	}
+
+	xlog.Info("%v will be recreated to upgrade it", proj.pathName())
+	xlog.Info("running processes and changes outside of mounts and %v will be lost", cnt.Config.Labels[projectDirLabel])
+	if !confirm("continue?") {
+		xlog.Fatal("aborted")
+	}
+
+	err = c.upgrade(ctx, proj, cnt)
+	if err != nil {
+		xlog.Fatal("failed to upgrade %v: %v", proj.pathName(), err)
+	}
+	xlog.Success("upgraded %v", proj.pathName())
+	os.Exit(0)
+}
+
+// upgrade rebuilds the image of the project's container cnt with the latest
+// base images, and swaps the container with one for the new image.
+func (c *upgradecmd) upgrade(ctx context.Context, proj *project, cnt types.ContainerJSON) error {
+	cli := dockerClient()
+	defer cli.Close()
+
+	// The runner needs the running container's code-server port, so the
+	// proxy keeps working.
+	wasRunning := cnt.State.Running
+	if !wasRunning {
+		err := cli.ContainerStart(ctx, proj.cntName(), types.ContainerStartOptions{})
+		if err != nil {
+			return xerrors.Errorf("failed to start container: %w", err)
+		}
+	}
+
+	b, err := hatBuilderFromContainer(proj.cntName())
+	if err != nil {
+		return err
+	}
+	b.buildTimeout = proj.conf.timeouts(false).build
+	b.opts.buildArgs = proj.buildOpts.buildArgs
+
+	r, err := runnerFromContainer(proj.cntName())
+	if err != nil {
+		return xerrors.Errorf("failed to initialize runner: %w", err)
+	}
+	if r.sandbox {
+		proj.sandboxBuild()
+		b.opts.sandbox = true
+		b.opts.buildArgs = proj.buildOpts.buildArgs
+	}
+	r.cntName = proj.cntName() + "-upgrade-" + randstr.Make(5)
+	r.timeouts = proj.conf.timeouts(false)
+	r.codeServer = proj.conf.codeServerSource()
+
+	// The lock pins the versions the project is upgraded from.
+	proj.buildOpts.pull = true
+	proj.buildOpts.unlocked = true
+	image, ok, err := proj.buildImage()
+	if err != nil {
+		return xerrors.Errorf("failed to build image: %w", err)
+	}
+	switch {
+	case ok:
+		b.baseImage = image
+	case b.baseImage == "":
+		// Images from a registry without a hat are used as is.
+		b.baseImage = sourceImage(cnt)
+	}
+	if !ok {
+		// Images pulled at their locked digest are upgraded to their tag's.
+		b.baseImage = unpinImage(b.baseImage, r.upstream)
+		pullCtx, cancel := context.WithTimeout(ctx, proj.conf.timeouts(false).pull)
+		_, err = ensureImage(pullCtx, b.baseImage, proj.buildOpts.platform)
+		cancel()
+		if err != nil {
+			return xerrors.Errorf("failed to pull %v: %w", b.baseImage, err)
+		}
+	}
+
+	image = b.baseImage
+	if b.hatPath != "" {
+		image, err = b.applyHat()
+		if err != nil {
+			return xerrors.Errorf("failed to apply hat: %w", err)
+		}
+	}
+
+	// The upgraded images are pinned again.
+	r.upstream = ""
+	err = swapContainer(ctx, cli, proj.cntName(), r, image)
+	if err != nil {
+		return err
+	}
+
+	if !wasRunning {
+		return stopContainer(ctx, cli, proj.cntName())
+	}
+	return nil
+}
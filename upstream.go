@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"go.coder.com/sail/internal/xlog"
+)
+
+// upstreamLabel holds the registry images a container's image is built from,
+// pinned to the digests they had when it was created, separated by commas,
+// e.g. codercom/ubuntu-dev:latest@sha256:... sail outdated compares them to
+// the registry's.
+const upstreamLabel = sailLabel + ".upstream"
+
+// upstreamTimeout bounds how long pinning the upstream images of a new
+// container may take, as it may query their registries.
+const upstreamTimeout = time.Second * 10
+
+// upstreamImages returns the registry images image is built from. Images
+// sail built from the repo's .sail/Dockerfile come from the Dockerfile's FROM
+// images, other images come from the image below the hat, if any. labels are
+// the labels of image.
+func upstreamImages(image string, labels map[string]string, projectLocalDir string) ([]string, error) {
+	base := labels[baseImageLabel]
+	if base == "" {
+		return []string{image}, nil
+	}
+	if base != image {
+		baseLabels, err := imageLabels(base)
+		if err != nil {
+			return nil, err
+		}
+		// The hat is applied to an image from the registry.
+		if baseLabels[baseImageLabel] != base {
+			return []string{base}, nil
+		}
+	}
+
+	// Images built from devcontainers or Nix files aren't tracked.
+	b, err := ioutil.ReadFile(filepath.Join(projectLocalDir, ".sail", "Dockerfile"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return dockerfileFroms(b), nil
+}
+
+// dockerfileFroms returns the images the Dockerfile df is built from, leaving
+// out its earlier stages, scratch and images named by build arguments.
+func dockerfileFroms(df []byte) []string {
+	var (
+		froms  []string
+		stages = make(map[string]bool)
+	)
+	sc := bufio.NewScanner(bytes.NewReader(df))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 2 || !strings.EqualFold(fields[0], "FROM") {
+			continue
+		}
+		fields = fields[1:]
+		for len(fields) > 0 && strings.HasPrefix(fields[0], "--") {
+			fields = fields[1:]
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		image := fields[0]
+		skip := stages[strings.ToLower(image)] || image == "scratch" || strings.Contains(image, "$")
+		if len(fields) >= 3 && strings.EqualFold(fields[1], "AS") {
+			stages[strings.ToLower(fields[2])] = true
+		}
+		if skip {
+			continue
+		}
+		if !stringsContain(froms, image) {
+			froms = append(froms, image)
+		}
+	}
+	return froms
+}
+
+// imageRepo returns the repository of the image reference ref, without its
+// tag, digest, and Docker Hub's implicit registry and namespace.
+func imageRepo(ref string) string {
+	ref = strings.TrimPrefix(untaggedRef(ref), "docker.io/")
+	return strings.TrimPrefix(ref, "library/")
+}
+
+// pinnedImage is an image reference pinned to the digest it had.
+type pinnedImage struct {
+	ref    string
+	digest string
+}
+
+func (p pinnedImage) String() string {
+	return p.ref + "@" + p.digest
+}
+
+// parseUpstream parses the value of upstreamLabel.
+func parseUpstream(v string) []pinnedImage {
+	var pins []pinnedImage
+	for _, s := range strings.Split(v, ",") {
+		i := strings.LastIndex(s, "@")
+		if i < 0 {
+			continue
+		}
+		pins = append(pins, pinnedImage{ref: s[:i], digest: s[i+1:]})
+	}
+	return pins
+}
+
+// formatUpstream formats pins as the value of upstreamLabel.
+func formatUpstream(pins []pinnedImage) string {
+	strs := make([]string, len(pins))
+	for i, p := range pins {
+		strs[i] = p.String()
+	}
+	return strings.Join(strs, ",")
+}
+
+// localDigest returns the registry digest of the image ref as it was pulled.
+// It's empty for images that weren't pulled from a registry.
+func localDigest(ctx context.Context, ref string) (string, error) {
+	cli := dockerClient()
+	defer cli.Close()
+
+	img, _, err := cli.ImageInspectWithRaw(ctx, ref)
+	if err != nil {
+		return "", xerrors.Errorf("failed to inspect %v: %w", ref, err)
+	}
+	for _, rd := range img.RepoDigests {
+		i := strings.LastIndex(rd, "@")
+		if i >= 0 && imageRepo(rd[:i]) == imageRepo(ref) {
+			return rd[i+1:], nil
+		}
+	}
+	return "", nil
+}
+
+// remoteDigest returns the digest of the image ref in its registry, with the
+// credentials of docker login.
+func remoteDigest(ctx context.Context, ref string) (string, error) {
+	cli := dockerClient()
+	defer cli.Close()
+
+	auth, err := registryAuth(ref)
+	if err != nil {
+		return "", xerrors.Errorf("failed to read credentials for %v: %w", ref, err)
+	}
+	di, err := cli.DistributionInspect(ctx, ref, auth)
+	if err != nil {
+		if isRegistryAuthError(err) {
+			err = &registryAuthError{registry: imageRegistry(ref), msg: err.Error()}
+		}
+		return "", xerrors.Errorf("failed to query registry for %v: %w", ref, err)
+	}
+	return string(di.Descriptor.Digest), nil
+}
+
+// pinUpstream returns the registry images image is built from, pinned to
+// the digests they were built from. Images that aren't in a registry are left
+// out.
+func pinUpstream(ctx context.Context, image string, labels map[string]string, projectLocalDir string) ([]pinnedImage, error) {
+	refs, err := upstreamImages(image, labels, projectLocalDir)
+	if err != nil {
+		return nil, err
+	}
+	var pins []pinnedImage
+	for _, ref := range refs {
+		if i := strings.LastIndex(ref, "@"); i >= 0 {
+			// The image was pulled by its digest.
+			pins = append(pins, pinnedImage{ref: ref[:i], digest: ref[i+1:]})
+			continue
+		}
+		digest, err := localDigest(ctx, ref)
+		if err != nil || digest == "" {
+			// BuildKit doesn't keep the images it builds from, it resolves
+			// them in the registry on every build.
+			digest, err = remoteDigest(ctx, ref)
+			if err != nil {
+				xlog.Debug("not tracking %v: %v", ref, err)
+				continue
+			}
+		}
+		pins = append(pins, pinnedImage{ref: ref, digest: digest})
+	}
+	return pins, nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_dockerfileFroms(t *testing.T) {
+	df := `ARG GO_VERSION=1.12
+FROM golang:${GO_VERSION} AS build
+FROM --platform=linux/amd64 node:12 AS web
+from build AS test
+FROM scratch
+FROM codercom/ubuntu-dev
+COPY --from=web /app /app
+FROM node:12
+`
+	assert.Equal(t, []string{"node:12", "codercom/ubuntu-dev"}, dockerfileFroms([]byte(df)))
+}
+
+func Test_imageRepo(t *testing.T) {
+	assert.Equal(t, "codercom/ubuntu-dev", imageRepo("codercom/ubuntu-dev"))
+	assert.Equal(t, "codercom/ubuntu-dev", imageRepo("codercom/ubuntu-dev:latest"))
+	assert.Equal(t, "ubuntu", imageRepo("docker.io/library/ubuntu:18.04"))
+	assert.Equal(t, "ubuntu", imageRepo("ubuntu@sha256:abc"))
+	assert.Equal(t, "registry:5000/dev", imageRepo("registry:5000/dev:1.0"))
+}
+
+func Test_upstreamLabel(t *testing.T) {
+	pins := []pinnedImage{
+		{ref: "codercom/ubuntu-dev:latest", digest: "sha256:abc"},
+		{ref: "registry:5000/node", digest: "sha256:def"},
+	}
+	v := formatUpstream(pins)
+	assert.Equal(t, "codercom/ubuntu-dev:latest@sha256:abc,registry:5000/node@sha256:def", v)
+	assert.Equal(t, pins, parseUpstream(v))
+	assert.Empty(t, parseUpstream(""))
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+
+	"golang.org/x/xerrors"
+
+	"go.coder.com/sail/internal/xlog"
+)
+
+// warmupCmdLabel is an image label with the command warming up the
+// environment, which replaces the one inferred from the project's stack.
+const warmupCmdLabel = sailLabel + ".warmup_cmd"
+
+// warmupCommands install the dependencies of a project and fill the caches
+// its language server reads, by the language detectStack reports.
+var warmupCommands = map[string]string{
+	"go":         "go mod download && go list -deps -test ./... > /dev/null",
+	"javascript": "if [ -f package-lock.json ]; then npm ci; elif [ -f yarn.lock ]; then yarn install --frozen-lockfile; else npm install; fi",
+	"python":     "if [ -f requirements.txt ]; then pip install --user -r requirements.txt; else pip install --user -e .; fi",
+	"ruby":       "bundle install",
+}
+
+// warmupCommand returns the command warming up the project in localDir, run
+// from an image with labels, or "" if there's none.
+func warmupCommand(localDir string, labels map[string]string) string {
+	if cmd, ok := labels[warmupCmdLabel]; ok {
+		return cmd
+	}
+	lang, _ := detectStack(localDir)
+	return warmupCommands[lang]
+}
+
+// warmup installs the dependencies of the freshly created container cntName,
+// before the environment is declared ready, so its editor isn't slow while
+// they're fetched and indexed.
+func warmup(ctx context.Context, cntName, image string) error {
+	env, err := hookEnvFromContainer(ctx, cntName)
+	if err != nil {
+		return err
+	}
+	labels, err := imageLabels(image)
+	if err != nil {
+		return err
+	}
+	cmd := warmupCommand(env.localDir, labels)
+	if env.windows && labels[warmupCmdLabel] == "" {
+		// The inferred commands are bash.
+		cmd = ""
+	}
+	if cmd == "" {
+		xlog.Debug("no warmup for %v", env.project)
+		return nil
+	}
+
+	xlog.Info("warming up %v: %v", env.project, cmd)
+	err = execInProject(env, shellCommand(env.windows, cmd)...)
+	if err != nil {
+		return xerrors.Errorf("warmup failed: %w", err)
+	}
+	return nil
+}
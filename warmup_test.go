@@ -0,0 +1,26 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_warmupCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sail-warmup")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	assert.Equal(t, "", warmupCommand(dir, nil))
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "package.json"), []byte("{}"), 0644))
+	assert.Equal(t, warmupCommands["javascript"], warmupCommand(dir, nil))
+
+	assert.Equal(t, "make deps", warmupCommand(dir, map[string]string{warmupCmdLabel: "make deps"}))
+	// Images can opt out with an empty command.
+	assert.Equal(t, "", warmupCommand(dir, map[string]string{warmupCmdLabel: ""}))
+}
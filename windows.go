@@ -0,0 +1,152 @@
+package main
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"golang.org/x/xerrors"
+
+	"go.coder.com/sail/internal/xlog"
+)
+
+// windowsOSType is the OSType of daemons running Windows containers.
+const windowsOSType = "windows"
+
+// Windows containers run as the unprivileged user of Windows base images,
+// whose home is the default home of sail's guest paths.
+const (
+	windowsUser      = "ContainerUser"
+	windowsGuestHome = "/Users/ContainerUser"
+)
+
+// windowsCodeServerDir is where the Windows build of code-server is mounted.
+// It's a directory, as code-server for Windows isn't a single binary.
+const windowsCodeServerDir = "/code-server"
+
+// windowsLauncherScript is the launcherScript of Windows containers. It takes
+// the same arguments but the environment file and socket, which Windows
+// containers don't support. code-server is run from windowsCodeServerDir if
+// it's mounted, otherwise it must be on the image's PATH.
+const windowsLauncherScript = `$ErrorActionPreference = 'Stop'
+$project, $addr, $port, $extensions, $log, $restart, $bin = $args
+Set-Location $project
+New-Item -ItemType Directory -Force -Path (Split-Path $log) | Out-Null
+if (-not (Test-Path $bin)) { $bin = 'code-server' }
+while ($true) {
+& $bin --host $addr --port $port --user-data-dir "$env:USERPROFILE\.config\Code" --extensions-dir $extensions --extra-extensions-dir "$env:USERPROFILE\.vscode\extensions" --auth=none --allow-http 2>&1 | Tee-Object -FilePath $log
+$status = $LASTEXITCODE
+if (-not (Test-Path $restart)) { exit $status }
+Remove-Item $restart
+}`
+
+// windowsGuestPath converts the guest path p, which is absolute with slashes
+// like every guest path of sail, to a path on the C: drive of a Windows
+// container.
+func windowsGuestPath(p string) string {
+	return `C:` + strings.Replace(filepath.Clean(p), "/", `\`, -1)
+}
+
+var wslDriveRx = regexp.MustCompile(`^/mnt/([a-zA-Z])(/.*)?$`)
+
+// windowsHostPath converts the host path p to a path of the Windows host.
+// Windows daemons are reached from WSL, which mounts the host's drives in
+// /mnt, so only paths on them can be mounted.
+func windowsHostPath(p string) (string, error) {
+	sm := wslDriveRx.FindStringSubmatch(filepath.Clean(p))
+	if sm == nil {
+		return "", xerrors.Errorf("%v isn't on a Windows drive, which Windows containers can only mount from WSL's /mnt", p)
+	}
+	rest := strings.Replace(sm[2], "/", `\`, -1)
+	if rest == "" {
+		rest = `\`
+	}
+	return strings.ToUpper(sm[1]) + ":" + rest, nil
+}
+
+// windowsMounts converts the paths of mounts to Windows paths. Volumes are
+// named, so only their targets are converted. Sail's own directories that
+// aren't on a Windows drive, like its state in the WSL home, are left out, so
+// the container keeps the editor's state to itself. The project directory,
+// mounted at projectDir, and mounts of the image and the user must be on one.
+func (r *runner) windowsMounts(mounts []mount.Mount, projectDir string) ([]mount.Mount, error) {
+	projectDir = resolvePath(r.guestHome(), projectDir)
+	var (
+		converted = make([]mount.Mount, 0, len(mounts))
+		skipped   []string
+	)
+	for _, m := range mounts {
+		if m.Type == mount.TypeBind {
+			src, err := windowsHostPath(m.Source)
+			switch {
+			case err == nil:
+				m.Source = src
+			case m.Target == projectDir:
+				return nil, xerrors.Errorf("%w, set project_root to a directory in it, e.g. /mnt/c/src", err)
+			case r.mountSource(m).origin == originSail && m.Target != windowsCodeServerDir:
+				skipped = append(skipped, m.Source)
+				continue
+			default:
+				return nil, err
+			}
+		}
+		m.Target = windowsGuestPath(m.Target)
+		converted = append(converted, m)
+	}
+	if len(skipped) > 0 {
+		xlog.Warn("%v aren't on a Windows drive, so the container keeps its editor state to itself", strings.Join(skipped, ", "))
+	}
+	return converted, nil
+}
+
+// shellCommand returns the argv running the shell command cmd in a
+// container, with bash, or with PowerShell in Windows containers.
+func shellCommand(windows bool, cmd string) []string {
+	if windows {
+		return []string{"powershell", "-NoProfile", "-NonInteractive", "-Command", cmd}
+	}
+	return []string{"/bin/bash", "-c", cmd}
+}
+
+// windowsCommand returns the argv of the init process of Windows containers,
+// with the arguments of windowsLauncherScript.
+func windowsCommand(args ...string) []string {
+	quoted := make([]string, len(args))
+	for i, a := range args {
+		quoted[i] = psQuote(a)
+	}
+	return []string{
+		"powershell", "-NoProfile", "-NonInteractive", "-Command",
+		"& {\n" + windowsLauncherScript + "\n} " + strings.Join(quoted, " "),
+	}
+}
+
+// psQuote quotes s as a literal PowerShell string.
+func psQuote(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
+
+// applyWindows adapts the container to a Windows daemon, if r.windows.
+// Windows containers run with process isolation, sharing the host's kernel
+// like Linux containers, and don't support Linux's users, groups and
+// capabilities.
+func (r *runner) applyWindows(containerConfig *container.Config, hostConfig *container.HostConfig) {
+	if !r.windows {
+		return
+	}
+	if containerConfig.User == "" {
+		containerConfig.User = windowsUser
+	}
+	// Other commands find the home of the container's user in its labels.
+	if _, ok := containerConfig.Labels[guestHomeLabel]; !ok {
+		containerConfig.Labels[guestHomeLabel] = r.guestHome()
+	}
+	hostConfig.Isolation = container.IsolationProcess
+	hostConfig.GroupAdd = nil
+	hostConfig.CapAdd = nil
+	hostConfig.CapDrop = nil
+	hostConfig.SecurityOpt = nil
+	hostConfig.UsernsMode = ""
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_windowsPaths(t *testing.T) {
+	assert.Equal(t, `C:\Users\ContainerUser\sail`, windowsGuestPath("/Users/ContainerUser/sail/"))
+
+	p, err := windowsHostPath("/mnt/d/src/sail")
+	require.NoError(t, err)
+	assert.Equal(t, `D:\src\sail`, p)
+	p, err = windowsHostPath("/mnt/c")
+	require.NoError(t, err)
+	assert.Equal(t, `C:\`, p)
+	_, err = windowsHostPath("/home/user/.config/Code")
+	require.Error(t, err)
+
+	r := &runner{windows: true, home: windowsGuestHome}
+	project := mount.Mount{Type: mount.TypeBind, Source: "/mnt/c/src/sail", Target: "/Users/ContainerUser/sail"}
+	mounts, err := r.windowsMounts([]mount.Mount{
+		project,
+		{Type: mount.TypeVolume, Source: "sail-shared-nuget", Target: "/Users/ContainerUser/.nuget"},
+		// Sail's state in the WSL home is left out.
+		{Type: mount.TypeBind, Source: "/home/user/.config/sail/cdr_sail/globalStorage", Target: "/Users/ContainerUser/.local/share/code-server/globalStorage"},
+	}, "~/sail")
+	require.NoError(t, err)
+	assert.Equal(t, []mount.Mount{
+		{Type: mount.TypeBind, Source: `C:\src\sail`, Target: `C:\Users\ContainerUser\sail`},
+		{Type: mount.TypeVolume, Source: "sail-shared-nuget", Target: `C:\Users\ContainerUser\.nuget`},
+	}, mounts)
+
+	// The project and mounts of the user must be on a drive.
+	project.Source = "/home/user/src/sail"
+	_, err = r.windowsMounts([]mount.Mount{project}, "~/sail")
+	assert.Error(t, err)
+	share := mount.Mount{Type: mount.TypeBind, Source: "/home/user/datasets", Target: "/Users/ContainerUser/datasets"}
+	r.setMountSource(share, mountSource{origin: originConfig, desc: "sail mount"})
+	_, err = r.windowsMounts([]mount.Mount{share}, "~/sail")
+	assert.Error(t, err)
+}
+
+func Test_windowsCommand(t *testing.T) {
+	r := &runner{windows: true, home: windowsGuestHome, port: "8000"}
+	cmd := r.command("/Users/ContainerUser/it's")
+	require.Len(t, cmd, 5)
+	assert.Equal(t, "powershell", cmd[0])
+	assert.True(t, strings.HasSuffix(cmd[4], `} 'C:\Users\ContainerUser\it''s' '0.0.0.0' '8443'`+
+		` 'C:\Users\ContainerUser\.vscode\host-extensions' 'C:\tmp\code-server.log' 'C:\tmp\.sail-restart-code-server'`+
+		` 'C:\code-server\bin\code-server.cmd'`), cmd[4])
+}
+
+func Test_applyWindows(t *testing.T) {
+	r := &runner{windows: true, home: windowsGuestHome}
+	cnt := &container.Config{Labels: map[string]string{}}
+	host := &container.HostConfig{CapAdd: []string{"SYS_PTRACE"}, GroupAdd: []string{"999"}}
+	r.applyWindows(cnt, host)
+
+	assert.Equal(t, windowsUser, cnt.User)
+	assert.Equal(t, windowsGuestHome, cnt.Labels[guestHomeLabel])
+	assert.Equal(t, container.IsolationProcess, host.Isolation)
+	assert.Empty(t, host.CapAdd)
+	assert.Empty(t, host.GroupAdd)
+
+	r = &runner{}
+	cnt = &container.Config{Labels: map[string]string{}}
+	r.applyWindows(cnt, &container.HostConfig{})
+	assert.Equal(t, "", cnt.User)
+}
+
+func Test_shellCommand(t *testing.T) {
+	assert.Equal(t, []string{"/bin/bash", "-c", "make"}, shellCommand(false, "make"))
+	assert.Equal(t, []string{"powershell", "-NoProfile", "-NonInteractive", "-Command", "make"}, shellCommand(true, "make"))
+
+	env := hookEnv{cntDir: "/Users/ContainerUser/sail", windows: true}
+	assert.Equal(t, `C:\Users\ContainerUser\sail`, env.guestPath(env.cntDir))
+	assert.Contains(t, env.environ(), `SAIL_CONTAINER_PROJECT_DIR=C:\Users\ContainerUser\sail`)
+}